@@ -0,0 +1,185 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+const (
+	_ADMIN_REQUEST_TIMEOUT = time.Second * 10
+)
+
+// adminError is returned if the admin server responds an error
+type adminError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *adminError) Error() string {
+	return fmt.Sprintf("%s: %s", http.StatusText(e.StatusCode), e.Message)
+}
+
+// adminClient sends requests to admin HTTP servers of components (see admin_addr), authenticated by [admin].token
+type adminClient struct {
+	httpClient *http.Client
+	scheme     string
+	token      string
+}
+
+func newAdminClient() *adminClient {
+	adminConfig := config.GetAdmin()
+	if adminConfig.ClientCAFile != "" {
+		showMsgAndQuit("admin servers require client certificates ([admin].client_ca_file), which is not supported by goworld")
+	}
+
+	ac := &adminClient{
+		httpClient: &http.Client{Timeout: _ADMIN_REQUEST_TIMEOUT},
+		scheme:     "http",
+		token:      adminConfig.Token,
+	}
+	if adminConfig.CertFile != "" {
+		// admin servers are served using TLS, and the certificate (usually self-signed) is trusted
+		certData, err := ioutil.ReadFile(path.Join(config.GetConfigDir(), adminConfig.CertFile))
+		checkErrorOrQuit(err, "read admin certificate failed")
+		rootCAs := x509.NewCertPool()
+		rootCAs.AppendCertsFromPEM(certData)
+		ac.httpClient.Transport = &http.Transport{
+			TLSClientConfig: &tls.Config{RootCAs: rootCAs},
+		}
+		ac.scheme = "https"
+	}
+	return ac
+}
+
+// request sends the request to the admin server and returns the response body
+func (ac *adminClient) request(method string, addr string, path string, form url.Values) ([]byte, error) {
+	u := ac.scheme + "://" + addr + path
+	var body io.Reader
+	if method == http.MethodGet {
+		u += "?" + form.Encode()
+	} else {
+		body = strings.NewReader(form.Encode())
+	}
+
+	req, err := http.NewRequest(method, u, body)
+	if err != nil {
+		return nil, err
+	}
+	if method == http.MethodPost {
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	}
+	if ac.token != "" {
+		req.Header.Set("Authorization", "Bearer "+ac.token)
+	}
+
+	resp, err := ac.httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return nil, &adminError{StatusCode: resp.StatusCode, Message: strings.TrimSpace(string(data))}
+	}
+	return data, nil
+}
+
+// getJSON gets the JSON response of the admin server
+func (ac *adminClient) getJSON(addr string, path string, form url.Values, v interface{}) error {
+	data, err := ac.request(http.MethodGet, addr, path, form)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// postJSON posts the form to the admin server and gets the JSON response
+func (ac *adminClient) postJSON(addr string, path string, form url.Values, v interface{}) error {
+	data, err := ac.request(http.MethodPost, addr, path, form)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// post posts the form to the admin server and returns the response message
+func (ac *adminClient) post(addr string, path string, form url.Values) (string, error) {
+	data, err := ac.request(http.MethodPost, addr, path, form)
+	return strings.TrimSpace(string(data)), err
+}
+
+// adminComponent is a component with its admin server address
+type adminComponent struct {
+	Name      string // dispatcher1, game1, gate1, ...
+	AdminAddr string // empty if the admin server is disabled
+}
+
+func dispatcherAdminComponents() []adminComponent {
+	var comps []adminComponent
+	for _, dispid := range config.GetDispatcherIDs() {
+		comps = append(comps, adminComponent{fmt.Sprintf("dispatcher%d", dispid), config.GetDispatcher(dispid).AdminAddr})
+	}
+	return comps
+}
+
+func gameAdminComponents() []adminComponent {
+	var comps []adminComponent
+	for gameid := 1; gameid <= config.GetDeployment().DesiredGames; gameid++ {
+		comps = append(comps, adminComponent{fmt.Sprintf("game%d", gameid), config.GetGame(uint16(gameid)).AdminAddr})
+	}
+	return comps
+}
+
+func gateAdminComponents() []adminComponent {
+	var comps []adminComponent
+	for gateid := 1; gateid <= config.GetDeployment().DesiredGates; gateid++ {
+		comps = append(comps, adminComponent{fmt.Sprintf("gate%d", gateid), config.GetGate(uint16(gateid)).AdminAddr})
+	}
+	return comps
+}
+
+// parseAdminComponent parses the component name like game2 or gate1 of the given kinds
+func parseAdminComponent(name string, kinds ...string) adminComponent {
+	for _, kind := range kinds {
+		if !strings.HasPrefix(name, kind) {
+			continue
+		}
+
+		id, err := strconv.Atoi(name[len(kind):])
+		if err != nil || id <= 0 {
+			break
+		}
+		comp := adminComponent{Name: name}
+		switch kind {
+		case "dispatcher":
+			if cfg := config.GetDispatcher(uint16(id)); cfg != nil {
+				comp.AdminAddr = cfg.AdminAddr
+			}
+		case "game":
+			comp.AdminAddr = config.GetGame(uint16(id)).AdminAddr
+		case "gate":
+			comp.AdminAddr = config.GetGate(uint16(id)).AdminAddr
+		}
+		if comp.AdminAddr == "" {
+			showMsgAndQuit("admin_addr of %s is not set", name)
+		}
+		return comp
+	}
+	showMsgAndQuit("invalid component: %s, should be %sN", name, strings.Join(kinds, "N or "))
+	return adminComponent{}
+}
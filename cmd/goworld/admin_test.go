@@ -0,0 +1,83 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestAdminClientRequest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			http.Error(w, "invalid token", http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/stats":
+			if r.Method != http.MethodGet || r.FormValue("limit") != "0" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte(`{"uptime": 90, "num_goroutine": 10, "heap_alloc": 1048576}`))
+		case "/drain":
+			if r.Method != http.MethodPost || r.PostFormValue("reason") != "upgrade" {
+				http.Error(w, "bad request", http.StatusBadRequest)
+				return
+			}
+			w.Write([]byte("draining\n"))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+
+	addr := strings.TrimPrefix(server.URL, "http://")
+	ac := &adminClient{httpClient: server.Client(), scheme: "http", token: "secret"}
+
+	var stats adminStats
+	if err := ac.getJSON(addr, "/stats", url.Values{"limit": {"0"}}, &stats); err != nil {
+		t.Fatalf("get stats failed: %s", err)
+	}
+	if stats.NumGoroutine != 10 || stats.String() != "up 1m30s, 10 goroutines, heap 1.0MB" {
+		t.Errorf("wrong stats: %s", &stats)
+	}
+
+	if msg, err := ac.post(addr, "/drain", url.Values{"reason": {"upgrade"}}); err != nil || msg != "draining" {
+		t.Errorf("post drain should succeed, but got %#v, %v", msg, err)
+	}
+
+	_, err := ac.post(addr, "/call_entity", nil)
+	if aerr, ok := err.(*adminError); !ok || aerr.StatusCode != http.StatusNotFound {
+		t.Errorf("admin error of not found should be returned, but got %v", err)
+	}
+
+	ac.token = "wrong"
+	_, err = ac.post(addr, "/drain", url.Values{"reason": {"upgrade"}})
+	if aerr, ok := err.(*adminError); !ok || aerr.StatusCode != http.StatusUnauthorized || aerr.Message != "invalid token" {
+		t.Errorf("admin error of invalid token should be returned, but got %v", err)
+	}
+}
+
+func TestEncodeCallArgs(t *testing.T) {
+	args, err := encodeCallArgs([]string{"1", "hello", `{"gold": 100}`, "[1, 2]", "true", `"quoted"`})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args != `[1,"hello",{"gold":100},[1,2],true,"quoted"]` {
+		t.Errorf("wrong arguments: %s", args)
+	}
+	if args, _ := encodeCallArgs(nil); args != "[]" {
+		t.Errorf("no arguments should be encoded as empty array, but got %s", args)
+	}
+}
+
+func TestFormatEntityCounts(t *testing.T) {
+	if s := formatEntityCounts(map[string]int{"Monster": 20, "Avatar": 3, "Account": 1}); s != "Account 1, Avatar 3, Monster 20" {
+		t.Errorf("entity counts should be sorted by types, but got %s", s)
+	}
+	if s := formatEntityCounts(nil); s != "" {
+		t.Errorf("no entity counts should be formatted as empty, but got %s", s)
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"flag"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/banlist"
+)
+
+// ban bans the IP or CIDR, the account or the device on all gates by admin servers
+//
+// Usage: goworld ban <ip|account|device> <value> [--duration <d>] [--reason <reason>]
+//
+// The ban is permanent if duration is 0, and duration of IP bans defaults to flood_ban_duration of gates. Bans are also
+// saved to KVDB by gates with persist_ban_list enabled, so that they are applied by gates restarted later.
+func ban(kind string, value string, args []string) {
+	flags := flag.NewFlagSet("ban", flag.ExitOnError)
+	duration := flags.String("duration", "", "duration of the ban, e.g. 24h, 0 for permanent ban")
+	reason := flags.String("reason", "", "reason of the ban")
+	flags.Parse(args)
+
+	form := banForm(kind, value)
+	if *duration != "" {
+		d, err := time.ParseDuration(*duration)
+		if err != nil || d < 0 || (d > 0 && d < time.Second) {
+			showMsgAndQuit("invalid duration: %s", *duration)
+		}
+		form.Set("duration", strconv.Itoa(int(d/time.Second)))
+	}
+	if *reason != "" {
+		form.Set("reason", *reason)
+	}
+	postToGates("/ban", form)
+}
+
+// unban unbans the IP or CIDR, the account or the device on all gates by admin servers
+//
+// Usage: goworld unban <ip|account|device> <value>
+func unban(kind string, value string) {
+	postToGates("/unban", banForm(kind, value))
+}
+
+// bans lists bans of all gates
+//
+// Usage: goworld bans
+func bans() {
+	type gateBan struct {
+		banlist.Ban
+		gates []string
+	}
+	merged := map[string]*gateBan{}
+	ac := newAdminClient()
+	for _, comp := range gateAdminComponents() {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+		var resp []banlist.Ban
+		if err := ac.getJSON(comp.AdminAddr, "/bans", nil, &resp); err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		for _, b := range resp {
+			key := string(b.Kind) + ":" + b.Value
+			if merged[key] == nil {
+				merged[key] = &gateBan{Ban: b}
+			}
+			merged[key].gates = append(merged[key].gates, comp.Name)
+		}
+	}
+
+	keys := make([]string, 0, len(merged))
+	for key := range merged {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		showMsg("%s (%s)", merged[key].Ban, strings.Join(merged[key].gates, ","))
+	}
+	showMsg("%d bans", len(merged))
+}
+
+func banForm(kind string, value string) url.Values {
+	value, err := banlist.Normalize(banlist.Kind(kind), value)
+	checkErrorOrQuit(err, "invalid ban")
+	return url.Values{kind: {value}}
+}
+
+func postToGates(path string, form url.Values) {
+	ac := newAdminClient()
+	for _, comp := range gateAdminComponents() {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+		msg, err := ac.post(comp.AdminAddr, path, form)
+		if err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		showMsg("%s", msg)
+	}
+}
@@ -0,0 +1,300 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"math/rand"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/goworldtest"
+)
+
+const (
+	_BENCH_SEED         = 1
+	_BENCH_AOI_DISTANCE = 100
+	_BENCH_SPACE_SIZE   = 1000 // entities are placed in the square of the size, e.g. 1000 entities have about 30 neighbors each
+	_BENCH_SPACE_KIND   = 1
+	_BENCH_ENTITY_TYPE  = "BenchAvatar"
+	_BENCH_RPC_BATCH    = 100 // RPCs sent before the world handles them, like RPCs sent in the same tick
+)
+
+var benchmarks = []struct {
+	Name string
+	Run  func(opts *benchOptions)
+}{
+	{"aoi", benchAOI},
+	{"rpc", benchRPC},
+	{"attrs", benchAttrs},
+	{"storage", benchStorage},
+}
+
+type benchOptions struct {
+	Entities []int
+	Count    int
+	Storage  string
+}
+
+type benchSpace struct {
+	entity.Space
+}
+
+func (space *benchSpace) OnSpaceCreated() {
+	space.EnableAOI(_BENCH_AOI_DISTANCE)
+}
+
+type benchAvatar struct {
+	entity.Entity
+}
+
+func (a *benchAvatar) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.SetUseAOI(true, _BENCH_AOI_DISTANCE)
+	desc.DefineAttr("name", "AllClients")
+	desc.DefineAttr("hp", "AllClients")
+	desc.DefineAttr("exp", "Client")
+}
+
+func (a *benchAvatar) Echo(n int, msg string, pos []float64) {
+}
+
+// bench runs benchmarks of engine subsystems in the in-process world of goworldtest
+//
+// Usage: goworld bench [--entities <n,...>] [--count <n>] [--storage <filesystem|memory|config>] [aoi|rpc|attrs|storage ...]
+//
+// Results are printed to stdout in the format of go test benchmarks, so that results of engine versions can be compared
+// by benchstat. Entities are placed by the RNG of the fixed seed, so that each run does the same work.
+func bench(args []string) {
+	flags := flag.NewFlagSet("bench", flag.ExitOnError)
+	entitiesFlag := flags.String("entities", "100,1000", "numbers of entities in the space of aoi and attrs benchmarks")
+	count := flags.Int("count", 1, "run each benchmark n times")
+	storageFlag := flags.String("storage", "filesystem", "storage of the storage benchmark: filesystem (temporary directory), memory or config ([storage] of goworld.ini)")
+	flags.Parse(args)
+
+	opts := &benchOptions{
+		Count:   *count,
+		Storage: *storageFlag,
+	}
+	for _, s := range strings.Split(*entitiesFlag, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(s))
+		if err != nil || n <= 0 {
+			showMsgAndQuit("invalid number of entities: %s", s)
+		}
+		opts.Entities = append(opts.Entities, n)
+	}
+
+	selected := flags.Args()
+	for _, name := range selected {
+		if !isBenchmarkName(name) {
+			showMsgAndQuit("unknown benchmark: %s", name)
+		}
+	}
+
+	gwlog.SetLevel(gwlog.ErrorLevel)
+	entity.RegisterSpace(&benchSpace{})
+	entity.RegisterEntity(_BENCH_ENTITY_TYPE, &benchAvatar{}, false)
+	goworldtest.Setup()
+
+	fmt.Printf("goos: %s\ngoarch: %s\npkg: github.com/xiaonanln/goworld\n", runtime.GOOS, runtime.GOARCH)
+	for _, b := range benchmarks {
+		if len(selected) > 0 && !containsString(selected, b.Name) {
+			continue
+		}
+		b.Run(opts)
+	}
+}
+
+func isBenchmarkName(name string) bool {
+	for _, b := range benchmarks {
+		if b.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// runBenchmark runs the benchmark and prints the result like go test -bench -benchmem
+func runBenchmark(opts *benchOptions, name string, f func(b *testing.B)) {
+	for i := 0; i < opts.Count; i++ {
+		r := testing.Benchmark(func(b *testing.B) {
+			b.ReportAllocs()
+			f(b)
+		})
+		fmt.Printf("Benchmark%s-%d\t%s\t%s\n", name, runtime.GOMAXPROCS(0), r.String(), r.MemString())
+	}
+}
+
+// createBenchSpace creates the space with AOI enabled and n avatars placed randomly by the RNG
+func createBenchSpace(w *goworldtest.World, rng *rand.Rand, n int) (*entity.Space, []*entity.Entity) {
+	space := entity.CreateSpaceLocally(_BENCH_SPACE_KIND)
+	w.Step()
+	avatars := make([]*entity.Entity, n)
+	for i := range avatars {
+		avatars[i] = w.CreateEntity(_BENCH_ENTITY_TYPE)
+		avatars[i].EnterSpace(space.ID, randomBenchPosition(rng))
+	}
+	w.Step()
+	return space, avatars
+}
+
+func randomBenchPosition(rng *rand.Rand) entity.Vector3 {
+	return entity.Vector3{
+		X: entity.Coord(rng.Float64() * _BENCH_SPACE_SIZE),
+		Z: entity.Coord(rng.Float64() * _BENCH_SPACE_SIZE),
+	}
+}
+
+func destroyBenchSpace(w *goworldtest.World, space *entity.Space, avatars []*entity.Entity) {
+	for _, a := range avatars {
+		a.Destroy()
+	}
+	space.Destroy()
+	w.Step()
+}
+
+// benchAOI measures moving entities in the space, each move updates neighbors of the entity
+func benchAOI(opts *benchOptions) {
+	w := goworldtest.Setup()
+	for _, n := range opts.Entities {
+		rng := rand.New(rand.NewSource(_BENCH_SEED))
+		space, avatars := createBenchSpace(w, rng, n)
+		runBenchmark(opts, fmt.Sprintf("AOI/entities=%d", n), func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				a := avatars[i%n]
+				pos := a.GetPosition()
+				pos.X += entity.Coord(rng.Float64()*20 - 10)
+				pos.Z += entity.Coord(rng.Float64()*20 - 10)
+				a.SetPosition(pos)
+			}
+		})
+		destroyBenchSpace(w, space, avatars)
+	}
+}
+
+// benchRPC measures entity RPCs through the dispatcher, the same way as RPCs of entities on other games: arguments are
+// packed to packets by the caller game, routed by the dispatcher and unpacked by the callee game
+func benchRPC(opts *benchOptions) {
+	w := goworldtest.Setup()
+	callee := w.CreateEntity(_BENCH_ENTITY_TYPE)
+	args := []interface{}{1, "hello", []float64{1, 2, 3}}
+	runBenchmark(opts, "RPC", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			dispatchercluster.SelectByEntityID(callee.ID).SendCallEntityMethod(callee.ID, "Echo", args)
+			if i%_BENCH_RPC_BATCH == _BENCH_RPC_BATCH-1 {
+				w.Step()
+			}
+		}
+		w.Step()
+	})
+	callee.Destroy()
+	w.Step()
+}
+
+// benchAttrs measures changing attributes of players in the space, each change is synced to the own client and clients of
+// neighbors. Bytes sent to clients per change are reported as client-B/op.
+func benchAttrs(opts *benchOptions) {
+	w := goworldtest.Setup()
+	for _, n := range opts.Entities {
+		rng := rand.New(rand.NewSource(_BENCH_SEED))
+		space := entity.CreateSpaceLocally(_BENCH_SPACE_KIND)
+		w.Step()
+		clients := make([]*goworldtest.Client, n)
+		avatars := make([]*entity.Entity, n)
+		for i := range clients {
+			clients[i] = w.Connect(_BENCH_ENTITY_TYPE)
+			avatars[i] = entity.GetEntity(clients[i].OwnerID)
+			avatars[i].EnterSpace(space.ID, randomBenchPosition(rng))
+		}
+		w.Step()
+
+		runBenchmark(opts, fmt.Sprintf("Attrs/entities=%d", n), func(b *testing.B) {
+			sent := w.ClientBytes()
+			for i := 0; i < b.N; i++ {
+				a := avatars[i%n]
+				a.Attrs.SetInt("hp", int64(i))
+				a.Attrs.SetInt("exp", int64(i))
+				w.Step()
+			}
+			b.ReportMetric(float64(w.ClientBytes()-sent)/float64(b.N), "client-B/op")
+		})
+
+		for _, c := range clients {
+			c.Disconnect()
+		}
+		destroyBenchSpace(w, space, avatars)
+	}
+}
+
+// benchStorage measures saving entities to the storage one by one, and reports percentiles of latencies
+func benchStorage(opts *benchOptions) {
+	cfg := &config.StorageConfig{Type: opts.Storage}
+	if opts.Storage == "filesystem" {
+		dir, err := ioutil.TempDir("", "goworld-bench")
+		checkErrorOrQuit(err, "create temporary directory failed")
+		defer os.RemoveAll(dir)
+		cfg.Directory = dir
+	} else if opts.Storage == "config" {
+		cfg = config.GetStorage()
+	} else if opts.Storage != "memory" {
+		showMsgAndQuit("invalid storage: %s, should be filesystem, memory or config", opts.Storage)
+	}
+
+	es, err := storage.OpenStorageEngine(cfg)
+	checkErrorOrQuit(err, "open storage failed")
+	defer es.Close()
+
+	data := benchEntityData()
+	runBenchmark(opts, "StorageSave/"+cfg.Type, func(b *testing.B) {
+		latencies := make([]time.Duration, b.N)
+		for i := 0; i < b.N; i++ {
+			t0 := time.Now()
+			if err := es.Write(_BENCH_ENTITY_TYPE, common.GenEntityID(), data); err != nil {
+				b.Fatalf("save entity failed: %v", err)
+			}
+			latencies[i] = time.Since(t0)
+		}
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		b.ReportMetric(float64(latencies[b.N/2]), "p50-ns")
+		b.ReportMetric(float64(latencies[b.N*99/100]), "p99-ns")
+	})
+}
+
+// benchEntityData returns the persistent data of a typical player, with some scalars and an inventory
+func benchEntityData() map[string]interface{} {
+	items := make([]interface{}, 50)
+	for i := range items {
+		items[i] = map[string]interface{}{
+			"id":    int64(1000 + i),
+			"count": int64(i),
+			"bound": i%2 == 0,
+		}
+	}
+	return map[string]interface{}{
+		"name":  "benchmark",
+		"level": int64(60),
+		"exp":   int64(123456789),
+		"gold":  int64(987654321),
+		"pos":   []interface{}{1.5, 0.0, -2.5},
+		"items": items,
+	}
+}
@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/url"
+)
+
+// call calls the method of the entity on the game hosting it by the admin servers
+//
+// Each argument is parsed as JSON, or used as a string if it is not valid JSON. Only methods allowed by
+// EntityTypeDesc.AllowInspectorCall can be called.
+func call(entityID string, method string, args []string) {
+	argsJSON, err := encodeCallArgs(args)
+	checkErrorOrQuit(err, "encode arguments failed")
+
+	ac := newAdminClient()
+	form := url.Values{
+		"id":     {entityID},
+		"method": {method},
+		"args":   {string(argsJSON)},
+	}
+	// the entity is searched on all games, since which game hosts the entity is only known by dispatchers
+	for _, comp := range gameAdminComponents() {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+
+		msg, err := ac.post(comp.AdminAddr, "/call_entity", form)
+		if aerr, ok := err.(*adminError); ok && aerr.StatusCode == http.StatusNotFound {
+			continue
+		}
+		checkErrorOrQuit(err, comp.Name+": call failed")
+		showMsg("%s: %s", comp.Name, msg)
+		return
+	}
+	showMsgAndQuit("entity %s is not found on any game", entityID)
+}
+
+// encodeCallArgs encodes arguments as the JSON array, arguments which are not valid JSON are encoded as strings
+func encodeCallArgs(args []string) (string, error) {
+	jsonArgs := make([]json.RawMessage, len(args))
+	for i, arg := range args {
+		if json.Valid([]byte(arg)) {
+			jsonArgs[i] = json.RawMessage(arg)
+		} else {
+			jsonArgs[i], _ = json.Marshal(arg)
+		}
+	}
+	data, err := json.Marshal(jsonArgs)
+	return string(data), err
+}
@@ -0,0 +1,12 @@
+package main
+
+// drain drains the game or the gate by the admin server
+//
+// Draining games hand off services to other games, and draining gates ask clients to reconnect to other gates and quit
+// when all clients are gone.
+func drain(name string) {
+	comp := parseAdminComponent(name, "game", "gate")
+	msg, err := newAdminClient().post(comp.AdminAddr, "/drain", nil)
+	checkErrorOrQuit(err, comp.Name+": drain failed")
+	showMsg("%s", msg)
+}
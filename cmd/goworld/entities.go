@@ -0,0 +1,64 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"net/url"
+	"strconv"
+)
+
+// entityListResponse is the response of /entities of games
+type entityListResponse struct {
+	Types    map[string]int `json:"types"`
+	Total    int            `json:"total"`
+	Entities []struct {
+		ID       string `json:"id"`
+		TypeName string `json:"type"`
+		Space    string `json:"space"`
+		Position struct {
+			X, Y, Z float32
+		} `json:"position"`
+		Client string `json:"client"`
+	} `json:"entities"`
+}
+
+// entities lists entities on games by the admin servers
+//
+// Usage: goworld entities [--type <entity type>] [--game <gameid>] [--space <space ID>] [--limit <max entities per game>]
+func entities(args []string) {
+	flags := flag.NewFlagSet("entities", flag.ExitOnError)
+	typeName := flags.String("type", "", "list entities of the type")
+	gameid := flags.Int("game", 0, "list entities on the game, all games if not set")
+	spaceID := flags.String("space", "", "list entities in the space")
+	limit := flags.Int("limit", 100, "max number of entities listed per game")
+	flags.Parse(args)
+
+	games := gameAdminComponents()
+	if *gameid > 0 {
+		games = []adminComponent{parseAdminComponent(fmt.Sprintf("game%d", *gameid), "game")}
+	}
+
+	ac := newAdminClient()
+	form := url.Values{
+		"type":  {*typeName},
+		"space": {*spaceID},
+		"limit": {strconv.Itoa(*limit)},
+	}
+	for _, comp := range games {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+		var resp entityListResponse
+		if err := ac.getJSON(comp.AdminAddr, "/entities", form, &resp); err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+
+		showMsg("%s: %d entities listed of %d matched (%s)", comp.Name, len(resp.Entities), resp.Total, formatEntityCounts(resp.Types))
+		for _, e := range resp.Entities {
+			showMsg("\t%-20s%-20s space=%s position=(%.2f, %.2f, %.2f) client=%s", e.ID, e.TypeName, e.Space,
+				e.Position.X, e.Position.Y, e.Position.Z, e.Client)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package main
+
+import (
+	"flag"
+	"net/url"
+)
+
+// faultsResponse is the response of /faults of components
+type faultsResponse struct {
+	Latency        string  `json:"latency"`
+	Jitter         string  `json:"jitter"`
+	DropRate       float64 `json:"drop"`
+	ReorderRate    float64 `json:"reorder"`
+	DisconnectRate float64 `json:"disconnect"`
+	Disconnected   int     `json:"disconnected"`
+}
+
+// faults shows or changes faults injected to packets between dispatchers, games and gates by the admin servers
+//
+// Usage: goworld faults <all|dispatcherN|gameN|gateN> [--latency <d>] [--jitter <d>] [--drop <p>] [--reorder <p>] [--disconnect <p>] [--clear] [--disconnect-now]
+//
+// Faults can only be injected to components with [admin].fault_injection enabled. Faults not given are cleared if any
+// fault is given. Faults are injected to packets received by the component, so
+// faults should be injected to all components to affect packets of both directions.
+func faults(name string, args []string) {
+	flags := flag.NewFlagSet("faults", flag.ExitOnError)
+	flags.String("latency", "", "delay of each packet, e.g. 50ms")
+	flags.String("jitter", "", "max random delay added to latency, e.g. 20ms")
+	flags.String("drop", "", "probability of dropping packets")
+	flags.String("reorder", "", "probability of delivering packets after the next packets")
+	flags.String("disconnect", "", "probability of closing connections when receiving packets")
+	clearFaults := flags.Bool("clear", false, "clear all faults")
+	disconnectNow := flags.Bool("disconnect-now", false, "close connections to other components now, which reconnect")
+	flags.Parse(args)
+
+	form := url.Values{}
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name != "clear" && f.Name != "disconnect-now" {
+			form.Set(f.Name, f.Value.String())
+		}
+	})
+	if *clearFaults {
+		form.Set("clear", "1")
+	}
+	if *disconnectNow {
+		form.Set("disconnect_now", "1")
+	}
+
+	var comps []adminComponent
+	if name == "all" {
+		comps = append(comps, dispatcherAdminComponents()...)
+		comps = append(comps, gameAdminComponents()...)
+		comps = append(comps, gateAdminComponents()...)
+	} else {
+		comps = []adminComponent{parseAdminComponent(name, "dispatcher", "game", "gate")}
+	}
+
+	ac := newAdminClient()
+	for _, comp := range comps {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+		var resp faultsResponse
+		var err error
+		if len(form) > 0 {
+			err = ac.postJSON(comp.AdminAddr, "/faults", form, &resp)
+		} else {
+			err = ac.getJSON(comp.AdminAddr, "/faults", form, &resp)
+		}
+		if err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		showMsg("%s: latency=%s jitter=%s drop=%v reorder=%v disconnect=%v", comp.Name, resp.Latency, resp.Jitter,
+			resp.DropRate, resp.ReorderRate, resp.DisconnectRate)
+		if *disconnectNow {
+			showMsg("%s: %d connections are closed", comp.Name, resp.Disconnected)
+		}
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// gm runs the GM command on the entity on the game hosting it by the admin servers
+//
+// Arguments containing spaces are quoted, so that they are parsed as single arguments by the game.
+func gm(entityID string, command string, args []string) {
+	if len(entityID) != common.ENTITYID_LENGTH {
+		showMsgAndQuit("invalid entity ID: %s", entityID)
+	}
+	words := []string{command}
+	for _, arg := range args {
+		if strings.Contains(arg, "\"") {
+			showMsgAndQuit("GM command arguments can not contain \": %s", arg)
+		}
+		if strings.ContainsAny(arg, " \t") || arg == "" {
+			arg = "\"" + arg + "\""
+		}
+		words = append(words, arg)
+	}
+
+	ac := newAdminClient()
+	form := url.Values{
+		"id":      {entityID},
+		"command": {strings.Join(words, " ")},
+	}
+	// the entity is searched on all games, since which game hosts the entity is only known by dispatchers
+	for _, comp := range gameAdminComponents() {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+
+		msg, err := ac.post(comp.AdminAddr, "/gm", form)
+		if aerr, ok := err.(*adminError); ok && aerr.StatusCode == http.StatusNotFound {
+			continue
+		}
+		checkErrorOrQuit(err, comp.Name+": GM command failed")
+		showMsg("%s: %s", comp.Name, msg)
+		return
+	}
+	showMsgAndQuit("entity %s is not found on any game", entityID)
+}
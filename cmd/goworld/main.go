@@ -29,6 +29,23 @@ func main() {
 		showMsg("no command to execute")
 		flag.Usage()
 		fmt.Fprintf(os.Stderr, "\tgoworld <build|start|stop|kill|reload|status> [server-id]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld replay <record-file> <gate-address> [speed]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld entities [--type <entity-type>] [--game <gameid>] [--space <space-id>] [--limit <n>]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld call <entity-id> <method> [args...]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld gm <entity-id> <command> [args...]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld drain <gameN|gateN>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld freeze <gameN> [--to-kvdb]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld restore <server-id> <gameN> [--from-kvdb]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld reload-plugin <all|gameN> <plugin-file>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld record-space <gameN> <space-id> <record-file>|--stop [--attrs <attr1,attr2>] [--interval <d>]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld dump-space-record <record-file>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld faults <all|dispatcherN|gameN|gateN> [--latency <d>] [--jitter <d>] [--drop <p>] [--reorder <p>] [--disconnect <p>] [--clear] [--disconnect-now]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld ban <ip|account|device> <value> [--duration <d>] [--reason <reason>]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld unban <ip|account|device> <value>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld bans\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld new project <server-id>\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld new entity <type> [--attrs <name>:<type>[:<def>...],...] [--project <server-id>] [--force]\n")
+		fmt.Fprintf(os.Stderr, "\tgoworld bench [--entities <n,...>] [--count <n>] [--storage <filesystem|memory|config>] [aoi|rpc|attrs|storage ...]\n")
 		os.Exit(1)
 	}
 
@@ -56,6 +73,79 @@ func main() {
 		kill(ServerID(args[1]))
 	} else if cmd == "status" {
 		status()
+	} else if cmd == "replay" {
+		if len(args) < 3 {
+			showMsgAndQuit("record file and gate address should be given")
+		}
+		replay(args[1], args[2], parseReplaySpeed(args[3:]))
+	} else if cmd == "entities" {
+		entities(args[1:])
+	} else if cmd == "call" {
+		if len(args) < 3 {
+			showMsgAndQuit("entity ID and method should be given")
+		}
+		call(args[1], args[2], args[3:])
+	} else if cmd == "gm" {
+		if len(args) < 3 {
+			showMsgAndQuit("entity ID and GM command should be given")
+		}
+		gm(args[1], args[2], args[3:])
+	} else if cmd == "drain" {
+		if len(args) != 2 {
+			showMsgAndQuit("game or gate to drain is not given")
+		}
+		drain(args[1])
+	} else if cmd == "freeze" {
+		if len(args) < 2 {
+			showMsgAndQuit("game to freeze is not given")
+		}
+		freezeGame(args[1], args[2:])
+	} else if cmd == "restore" {
+		if len(args) < 3 {
+			showMsgAndQuit("server id and game to restore should be given")
+		}
+		restoreGame(ServerID(args[1]), args[2], args[3:])
+	} else if cmd == "reload-plugin" {
+		if len(args) != 3 {
+			showMsgAndQuit("games and plugin file should be given")
+		}
+		reloadPlugin(args[1], args[2])
+	} else if cmd == "record-space" {
+		if len(args) < 4 {
+			showMsgAndQuit("game, space ID and record file (or --stop) should be given")
+		}
+		recordSpace(args[1], args[2], args[3:])
+	} else if cmd == "dump-space-record" {
+		if len(args) != 2 {
+			showMsgAndQuit("record file is not given")
+		}
+		dumpSpaceRecord(args[1])
+	} else if cmd == "new" {
+		if len(args) >= 3 && args[1] == "project" {
+			newProject(ServerID(args[2]))
+		} else if len(args) >= 3 && args[1] == "entity" {
+			newEntity(args[2], args[3:])
+		} else {
+			showMsgAndQuit("usage: goworld new project <server-id> | goworld new entity <type> [--attrs ...] [--project <server-id>] [--force]")
+		}
+	} else if cmd == "faults" {
+		if len(args) < 2 {
+			showMsgAndQuit("component is not given, should be all, dispatcherN, gameN or gateN")
+		}
+		faults(args[1], args[2:])
+	} else if cmd == "ban" || cmd == "unban" {
+		if len(args) < 3 {
+			showMsgAndQuit("kind (ip, account or device) and value to %s are not given", cmd)
+		}
+		if cmd == "ban" {
+			ban(args[1], args[2], args[3:])
+		} else {
+			unban(args[1], args[2])
+		}
+	} else if cmd == "bans" {
+		bans()
+	} else if cmd == "bench" {
+		bench(args[1:])
 	} else {
 		showMsgAndQuit("unknown command: %s", cmd)
 	}
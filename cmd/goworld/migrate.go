@@ -0,0 +1,51 @@
+package main
+
+import (
+	"flag"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// freezeGame freezes the game by the admin server, the game exits after entities are freezed
+//
+// With --to-kvdb, the freeze data is also stored in KVDB, so that the game can be restored on another host by
+// goworld restore <server-id> <gameN> --from-kvdb, e.g. to move the game off a host for hardware maintenance.
+func freezeGame(name string, args []string) {
+	comp := parseAdminComponent(name, "game")
+	flags := flag.NewFlagSet("freeze", flag.ExitOnError)
+	toKVDB := flags.Bool("to-kvdb", false, "store the freeze data in KVDB to restore the game on another host")
+	flags.Parse(args)
+
+	form := url.Values{}
+	if *toKVDB {
+		form.Set("to", "kvdb")
+	}
+	msg, err := newAdminClient().post(comp.AdminAddr, "/freeze", form)
+	checkErrorOrQuit(err, comp.Name+": freeze failed")
+	showMsg("%s", msg)
+}
+
+// restoreGame starts the game on this host and restores entities from the freeze data
+//
+// The freeze data is read from the file written by the game on this host, or from KVDB with --from-kvdb. Dispatchers
+// keep entities of the freezed game and wait for it to be restored, so clients and other games do not notice.
+func restoreGame(sid ServerID, name string, args []string) {
+	gameid, err := strconv.Atoi(strings.TrimPrefix(name, "game"))
+	if !strings.HasPrefix(name, "game") || err != nil || gameid <= 0 {
+		showMsgAndQuit("invalid game: %s", name)
+	}
+	flags := flag.NewFlagSet("restore", flag.ExitOnError)
+	fromKVDB := flags.Bool("from-kvdb", false, "restore from the freeze data stored in KVDB by goworld freeze --to-kvdb")
+	flags.Parse(args)
+
+	err = os.Chdir(env.GoWorldRoot)
+	checkErrorOrQuit(err, "chdir to goworld directory failed")
+	if *fromKVDB {
+		startGameWithArgs(sid, uint16(gameid), "-restore-from", "kvdb")
+	} else {
+		startGameWithArgs(sid, uint16(gameid), "-restore")
+	}
+	showMsg("game%d is restored", gameid)
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"go/format"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"text/template"
+
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+var (
+	entityTypeNamePattern = regexp.MustCompile(`^[A-Z][A-Za-z0-9_]*$`)
+	attrNamePattern       = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+	// registerEntitiesFuncPattern matches the function registering entity types generated by goworld new project
+	registerEntitiesFuncPattern = regexp.MustCompile(`(?s)\nfunc registerEntities\(\) \{\n.*?\n\}\n`)
+)
+
+// attrTypes are Go types and MapAttr methods of attribute types supported by goworld new entity
+var attrTypes = map[string]struct {
+	GoType    string
+	AttrType  string // Int for MapAttr.GetInt, MapAttr.SetInt, ...
+	TestValue string
+}{
+	"int":    {"int64", "Int", "1"},
+	"float":  {"float64", "Float", "1.5"},
+	"bool":   {"bool", "Bool", "true"},
+	"string": {"string", "Str", `"test"`},
+}
+
+// attrDefs are definitions of attributes supported by EntityTypeDesc.DefineAttr
+var attrDefs = map[string]string{
+	"client":     "Client",
+	"allclients": "AllClients",
+	"persistent": "Persistent",
+}
+
+// newAttr is the attribute of the entity generated by goworld new entity
+type newAttr struct {
+	Name      string
+	Accessor  string // name in accessors, e.g. MaxHp for GetMaxHp and SetMaxHp of attribute max_hp
+	GoType    string
+	AttrType  string
+	TestValue string
+	Defs      []string
+}
+
+// DefArgs returns the arguments of EntityTypeDesc.DefineAttr for the attribute
+func (a *newAttr) DefArgs() string {
+	args := []string{`"` + a.Name + `"`}
+	for _, def := range a.Defs {
+		args = append(args, `"`+def+`"`)
+	}
+	return strings.Join(args, ", ")
+}
+
+// newProject generates the project of the server ID with the space, the boot entity Account and the test
+//
+// Usage: goworld new project <server-id>
+func newProject(sid ServerID) {
+	dir := sid.Path()
+	if files, err := ioutil.ReadDir(dir); err == nil && len(files) > 0 {
+		showMsgAndQuit("%s already exists and is not empty", dir)
+	}
+	err := os.MkdirAll(dir, 0755)
+	checkErrorOrQuit(err, "create project directory failed")
+
+	data := map[string]interface{}{
+		"Name": sid.Name(),
+	}
+	writeGoFile(filepath.Join(dir, sid.Name()+".go"), projectMainTemplate, data)
+	writeGoFile(filepath.Join(dir, sid.Name()+"_test.go"), projectTestTemplate, data)
+	writeGoFile(filepath.Join(dir, "MySpace.go"), projectSpaceTemplate, data)
+	writeGoFile(filepath.Join(dir, "Account.go"), projectAccountTemplate, data)
+	showMsg("project %s is created, add entity types by: goworld new entity <type> --project %s", sid, sid)
+}
+
+// newEntity generates the entity type with attribute accessors and the test, and registers the entity type in
+// registerEntities of the project
+//
+// Existing files of the entity type are not overwritten unless --force is set.
+//
+// Usage: goworld new entity <type> [--attrs <name>:<type>[:<def>...],...] [--project <server-id>] [--force]
+func newEntity(typeName string, args []string) {
+	flags := flag.NewFlagSet("new entity", flag.ExitOnError)
+	attrsFlag := flags.String("attrs", "", "attributes like hp:int,name:string:client, types: int, float, bool, string, defs: client, allclients, persistent")
+	project := flags.String("project", "", "server ID of the project, the current directory if not set")
+	force := flags.Bool("force", false, "overwrite existing files of the entity type")
+	flags.Parse(args)
+
+	if !entityTypeNamePattern.MatchString(typeName) {
+		showMsgAndQuit("invalid entity type: %s, should be an exported Go identifier", typeName)
+	}
+	dir, err := os.Getwd()
+	checkErrorOrQuit(err, "get current directory failed")
+	if *project != "" {
+		dir = ServerID(*project).Path()
+	}
+	entityFile := filepath.Join(dir, typeName+".go")
+	testFile := filepath.Join(dir, typeName+"_test.go")
+	if !*force {
+		for _, file := range []string{entityFile, testFile} {
+			if isexists(file) {
+				showMsgAndQuit("%s already exists, use --force to overwrite it", file)
+			}
+		}
+	}
+
+	attrs, err := parseNewAttrs(*attrsFlag)
+	checkErrorOrQuit(err, "parse attributes failed")
+	persistent := false
+	for _, attr := range attrs {
+		for _, def := range attr.Defs {
+			if def == "Persistent" {
+				persistent = true
+			}
+		}
+	}
+	data := map[string]interface{}{
+		"TypeName":   typeName,
+		"Receiver":   strings.ToLower(typeName[:1]),
+		"Attrs":      attrs,
+		"Persistent": persistent,
+	}
+
+	mainFile, mainSource := findRegisterEntities(dir)
+	writeGoFile(entityFile, entityTemplate, data)
+	registration := `goworld.RegisterEntity("` + typeName + `", &` + typeName + `{})`
+	if mainFile == "" {
+		showMsg("registerEntities is not found in %s, register the entity type by %s", dir, registration)
+		return
+	}
+
+	// the registration is appended to registerEntities, and the test uses setupWorld calling registerEntities
+	if strings.Contains(mainSource, registration) {
+		showMsg("%s is already registered in %s", typeName, mainFile)
+	} else {
+		formatted, err := addRegistration(mainSource, registration)
+		checkErrorOrQuit(err, "format "+mainFile+" failed")
+		err = ioutil.WriteFile(mainFile, formatted, 0644)
+		checkErrorOrQuit(err, "write "+mainFile+" failed")
+		showMsg("%s is registered in %s", typeName, mainFile)
+	}
+	writeGoFile(testFile, entityTestTemplate, data)
+}
+
+// addRegistration appends the registration to registerEntities in the source, and returns the formatted source
+func addRegistration(source string, registration string) ([]byte, error) {
+	loc := registerEntitiesFuncPattern.FindStringIndex(source)
+	if loc == nil {
+		return nil, fmt.Errorf("registerEntities is not found")
+	}
+	end := loc[1] - len("}\n")
+	source = source[:end] + "\t" + registration + "\n" + source[end:]
+	return format.Source([]byte(source))
+}
+
+// parseNewAttrs parses attributes like hp:int,name:string:client
+func parseNewAttrs(s string) ([]*newAttr, error) {
+	var attrs []*newAttr
+	accessors := map[string]bool{}
+	entityType := reflect.TypeOf(&entity.Entity{})
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+
+		parts := strings.Split(item, ":")
+		if len(parts) < 2 || !attrNamePattern.MatchString(parts[0]) || attrAccessorName(parts[0]) == "" {
+			return nil, fmt.Errorf("invalid attribute: %s, should be <name>:<type>[:<def>...]", item)
+		}
+		attrType, ok := attrTypes[parts[1]]
+		if !ok {
+			return nil, fmt.Errorf("attribute %s: invalid type: %s, should be int, float, bool or string", parts[0], parts[1])
+		}
+		attr := &newAttr{
+			Name:      parts[0],
+			Accessor:  attrAccessorName(parts[0]),
+			GoType:    attrType.GoType,
+			AttrType:  attrType.AttrType,
+			TestValue: attrType.TestValue,
+		}
+		for _, def := range parts[2:] {
+			d, ok := attrDefs[strings.ToLower(def)]
+			if !ok {
+				return nil, fmt.Errorf("attribute %s: invalid def: %s, should be client, allclients or persistent", attr.Name, def)
+			}
+			attr.Defs = append(attr.Defs, d)
+		}
+
+		// accessors should not hide methods of Entity
+		for _, method := range []string{"Get" + attr.Accessor, "Set" + attr.Accessor} {
+			if _, ok := entityType.MethodByName(method); ok || accessors[method] {
+				return nil, fmt.Errorf("attribute %s: accessor %s conflicts with other methods", attr.Name, method)
+			}
+			accessors[method] = true
+		}
+		attrs = append(attrs, attr)
+	}
+	return attrs, nil
+}
+
+// attrAccessorName converts the attribute name to the name in accessors, e.g. max_hp to MaxHp
+func attrAccessorName(name string) string {
+	var parts []string
+	for _, part := range strings.Split(name, "_") {
+		if part != "" {
+			parts = append(parts, strings.ToUpper(part[:1])+part[1:])
+		}
+	}
+	return strings.Join(parts, "")
+}
+
+// findRegisterEntities finds the Go file defining registerEntities in the directory
+func findRegisterEntities(dir string) (string, string) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	checkErrorOrQuit(err, "list Go files failed")
+	for _, file := range files {
+		if strings.HasSuffix(file, "_test.go") {
+			continue
+		}
+		source, err := ioutil.ReadFile(file)
+		checkErrorOrQuit(err, "read "+file+" failed")
+		if registerEntitiesFuncPattern.Match(source) {
+			return file, string(source)
+		}
+	}
+	return "", ""
+}
+
+func writeGoFile(file string, tmpl *template.Template, data interface{}) {
+	source, err := generateGoSource(tmpl, data)
+	checkErrorOrQuit(err, "generate "+file+" failed")
+	err = ioutil.WriteFile(file, source, 0644)
+	checkErrorOrQuit(err, "write "+file+" failed")
+	showMsg("%s is generated", file)
+}
+
+// generateGoSource generates the formatted Go source by the template
+func generateGoSource(tmpl *template.Template, data interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return format.Source(buf.Bytes())
+}
+
+var projectMainTemplate = template.Must(template.New("main").Parse(`package main
+
+import (
+	"github.com/xiaonanln/goworld"
+)
+
+func main() {
+	registerEntities()
+	goworld.Run()
+}
+
+// registerEntities registers the space type and entity types, which is also called by tests
+func registerEntities() {
+	goworld.RegisterSpace(&MySpace{})
+	goworld.RegisterEntity("Account", &Account{})
+}
+`))
+
+var projectTestTemplate = template.Must(template.New("test").Parse(`package main
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/goworldtest"
+)
+
+var world *goworldtest.World
+
+// setupWorld registers entity types and creates the world for tests
+func setupWorld() *goworldtest.World {
+	if world == nil {
+		registerEntities()
+		world = goworldtest.Setup()
+	}
+	return world
+}
+
+func TestConnect(t *testing.T) {
+	w := setupWorld()
+	client := w.Connect("Account")
+	if client.OwnerID.IsNil() {
+		t.Fatalf("client should own the boot entity")
+	}
+}
+`))
+
+var projectSpaceTemplate = template.Must(template.New("space").Parse(`package main
+
+import (
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// MySpace is the custom space type
+type MySpace struct {
+	goworld.Space // Space type should always inherit from entity.Space
+}
+
+// OnGameReady is called when the game server is ready
+func (space *MySpace) OnGameReady() {
+	gwlog.Infof("Game %d Is Ready", goworld.GetGameID())
+}
+`))
+
+var projectAccountTemplate = template.Must(template.New("account").Parse(`package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+// Account is the boot entity created for each client (see boot_entity in goworld.ini)
+type Account struct {
+	entity.Entity
+}
+
+// DescribeEntityType defines the entity type
+func (a *Account) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+`))
+
+var entityTemplate = template.Must(template.New("entity").Parse(`package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+// {{.TypeName}} is the entity type
+type {{.TypeName}} struct {
+	entity.Entity
+}
+
+// DescribeEntityType defines attributes of the entity type
+func ({{.Receiver}} *{{.TypeName}}) DescribeEntityType(desc *entity.EntityTypeDesc) {
+{{- if .Persistent}}
+	desc.SetPersistent(true)
+{{- end}}
+{{- range .Attrs}}
+	desc.DefineAttr({{.DefArgs}})
+{{- end}}
+}
+
+// OnCreated is called when the entity is created
+func ({{.Receiver}} *{{.TypeName}}) OnCreated() {
+	{{.Receiver}}.Entity.OnCreated()
+}
+{{range .Attrs}}
+// Get{{.Accessor}} returns attribute {{.Name}}
+func ({{$.Receiver}} *{{$.TypeName}}) Get{{.Accessor}}() {{.GoType}} {
+	return {{$.Receiver}}.Attrs.Get{{.AttrType}}("{{.Name}}")
+}
+
+// Set{{.Accessor}} sets attribute {{.Name}}
+func ({{$.Receiver}} *{{$.TypeName}}) Set{{.Accessor}}(v {{.GoType}}) {
+	{{$.Receiver}}.Attrs.Set{{.AttrType}}("{{.Name}}", v)
+}
+{{end}}`))
+
+var entityTestTemplate = template.Must(template.New("entity_test").Parse(`package main
+
+import (
+	"testing"
+)
+
+func Test{{.TypeName}}(t *testing.T) {
+	w := setupWorld()
+	e := w.CreateEntity("{{.TypeName}}")
+	x, ok := e.I.(*{{.TypeName}})
+	if !ok {
+		t.Fatalf("%s is not {{.TypeName}}", e)
+	}
+{{- range .Attrs}}
+
+	x.Set{{.Accessor}}({{.TestValue}})
+	if v := x.Get{{.Accessor}}(); v != {{.TestValue}} {
+		t.Errorf("{{.Name}} should be %v, but got %v", {{.TestValue}}, v)
+	}
+{{- end}}
+}
+`))
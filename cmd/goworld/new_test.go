@@ -0,0 +1,193 @@
+package main
+
+import (
+	"go/parser"
+	"go/token"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestParseNewAttrs(t *testing.T) {
+	attrs, err := parseNewAttrs("hp:int:persistent, max_hp:float:client:persistent,name:string,,alive:bool:AllClients")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(attrs) != 4 {
+		t.Fatalf("4 attributes should be parsed, but got %d", len(attrs))
+	}
+	if attrs[1].Name != "max_hp" || attrs[1].Accessor != "MaxHp" || attrs[1].GoType != "float64" || attrs[1].DefArgs() != `"max_hp", "Client", "Persistent"` {
+		t.Errorf("wrong attribute: %+v", attrs[1])
+	}
+	if attrs[3].AttrType != "Bool" || attrs[3].DefArgs() != `"alive", "AllClients"` {
+		t.Errorf("wrong attribute: %+v", attrs[3])
+	}
+
+	for _, s := range []string{
+		"hp",                     // no type
+		"hp:int64",               // invalid type
+		"hp:int:private",         // invalid def
+		"1hp:int",                // invalid name
+		"_:int",                  // no accessor name
+		"hp:int,hp:float",        // duplicated accessors
+		"max_hp:int,maxHp:float", // duplicated accessors
+		"int:int",                // GetInt and SetInt conflict with methods of Entity
+	} {
+		if _, err := parseNewAttrs(s); err == nil {
+			t.Errorf("%s should be invalid", s)
+		}
+	}
+}
+
+func TestAttrAccessorName(t *testing.T) {
+	for name, accessor := range map[string]string{
+		"hp":         "Hp",
+		"max_hp":     "MaxHp",
+		"_max__hp_":  "MaxHp",
+		"levelUpExp": "LevelUpExp",
+		"_":          "",
+	} {
+		if got := attrAccessorName(name); got != accessor {
+			t.Errorf("accessor name of %s should be %s, but is %s", name, accessor, got)
+		}
+	}
+}
+
+func TestGenerateEntity(t *testing.T) {
+	attrs, err := parseNewAttrs("hp:int:persistent,name:string:client")
+	if err != nil {
+		t.Fatal(err)
+	}
+	data := map[string]interface{}{
+		"TypeName":   "Player",
+		"Receiver":   "p",
+		"Attrs":      attrs,
+		"Persistent": true,
+	}
+
+	source, err := generateGoSource(entityTemplate, data)
+	if err != nil {
+		t.Fatalf("generate entity failed: %s", err)
+	}
+	for _, s := range []string{
+		"desc.SetPersistent(true)",
+		`desc.DefineAttr("name", "Client")`,
+		"func (p *Player) GetHp() int64",
+		`p.Attrs.SetStr("name", v)`,
+	} {
+		if !strings.Contains(string(source), s) {
+			t.Errorf("generated entity should contain %s:\n%s", s, source)
+		}
+	}
+
+	testSource, err := generateGoSource(entityTestTemplate, data)
+	if err != nil {
+		t.Fatalf("generate entity test failed: %s", err)
+	}
+	if !strings.Contains(string(testSource), "func TestPlayer(t *testing.T)") || !strings.Contains(string(testSource), `x.SetName("test")`) {
+		t.Errorf("wrong entity test:\n%s", testSource)
+	}
+	for _, src := range [][]byte{source, testSource} {
+		if _, err := parser.ParseFile(token.NewFileSet(), "", src, 0); err != nil {
+			t.Errorf("generated source is invalid: %s", err)
+		}
+	}
+}
+
+func TestAddRegistration(t *testing.T) {
+	mainSource, err := generateGoSource(projectMainTemplate, map[string]interface{}{"Name": "my_game"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	source, err := addRegistration(string(mainSource), `goworld.RegisterEntity("Player", &Player{})`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(source), "\tgoworld.RegisterEntity(\"Account\", &Account{})\n\tgoworld.RegisterEntity(\"Player\", &Player{})\n}") {
+		t.Errorf("registration should be appended to registerEntities:\n%s", source)
+	}
+	if _, err := addRegistration("package main\n\nfunc main() {\n}\n", `goworld.RegisterEntity("Player", &Player{})`); err == nil {
+		t.Errorf("registration should fail without registerEntities")
+	}
+}
+
+// setupProjectDir creates the project with registerEntities in a temporary directory, and changes the current directory
+// to it
+func setupProjectDir(t *testing.T) (string, func()) {
+	dir, err := ioutil.TempDir("", "goworld_new")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cwd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writeGoFile(filepath.Join(dir, "my_game.go"), projectMainTemplate, map[string]interface{}{"Name": "my_game"})
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	return dir, func() {
+		os.Chdir(cwd)
+		os.RemoveAll(dir)
+	}
+}
+
+func TestNewEntityForce(t *testing.T) {
+	dir, cleanup := setupProjectDir(t)
+	defer cleanup()
+
+	newEntity("Player", []string{"--attrs", "hp:int"})
+	testFile := filepath.Join(dir, "Player_test.go")
+	if err := ioutil.WriteFile(testFile, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	newEntity("Player", []string{"--attrs", "hp:int,name:string", "--force"})
+	source, err := ioutil.ReadFile(filepath.Join(dir, "Player.go"))
+	if err != nil || !strings.Contains(string(source), "GetName") {
+		t.Errorf("entity should be overwritten with --force: %s", source)
+	}
+	testSource, err := ioutil.ReadFile(testFile)
+	if err != nil || !strings.Contains(string(testSource), "func TestPlayer") {
+		t.Errorf("entity test should be overwritten with --force: %s", testSource)
+	}
+	mainSource, err := ioutil.ReadFile(filepath.Join(dir, "my_game.go"))
+	if err != nil || strings.Count(string(mainSource), `goworld.RegisterEntity("Player", &Player{})`) != 1 {
+		t.Errorf("entity should be registered once: %s", mainSource)
+	}
+}
+
+func TestNewEntityRefuseOverwrite(t *testing.T) {
+	if os.Getenv("GOWORLD_TEST_NEW_ENTITY") == "1" {
+		// newEntity quits the process if files exist
+		newEntity("Player", nil)
+		return
+	}
+
+	dir, cleanup := setupProjectDir(t)
+	defer cleanup()
+
+	testFile := filepath.Join(dir, "Player_test.go")
+	if err := ioutil.WriteFile(testFile, []byte("package main\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cmd := exec.Command(exe, "-test.run=TestNewEntityRefuseOverwrite")
+	cmd.Env = append(os.Environ(), "GOWORLD_TEST_NEW_ENTITY=1")
+	if output, err := cmd.CombinedOutput(); err == nil || !strings.Contains(string(output), "already exists") {
+		t.Fatalf("goworld new entity should refuse to overwrite the existing test file: %v, %s", err, output)
+	}
+	if data, err := ioutil.ReadFile(testFile); err != nil || string(data) != "package main\n" {
+		t.Errorf("existing test file should not be overwritten: %s", data)
+	}
+	if isexists(filepath.Join(dir, "Player.go")) {
+		t.Errorf("entity should not be generated if its test file exists")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"net/url"
+	"path/filepath"
+)
+
+// reloadPlugin reloads entity types from the Go plugin on games by the admin servers
+//
+// Usage: goworld reload-plugin <all|gameN> <plugin-file>
+//
+// The plugin file is built by `go build -buildmode=plugin`, and should be accessible by games at the same path.
+func reloadPlugin(name string, path string) {
+	path, err := filepath.Abs(path)
+	checkErrorOrQuit(err, "get absolute path of plugin failed")
+
+	var comps []adminComponent
+	if name == "all" {
+		comps = gameAdminComponents()
+	} else {
+		comps = []adminComponent{parseAdminComponent(name, "game")}
+	}
+
+	ac := newAdminClient()
+	form := url.Values{"path": {path}}
+	for _, comp := range comps {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+		msg, err := ac.post(comp.AdminAddr, "/reload_plugin", form)
+		if err != nil {
+			showMsg("%s: reload plugin failed: %s", comp.Name, err)
+			continue
+		}
+		showMsg("%s", msg)
+	}
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/clientrecord"
+	"github.com/xiaonanln/goworld/ext/botclient"
+)
+
+const replayWaitEntityTimeout = time.Second * 30
+
+// replay connects to the gate and replays the client record recorded by gates
+func replay(recordFile string, gateAddr string, speed float64) {
+	file, err := os.Open(recordFile)
+	checkErrorOrQuit(err, "open record file failed")
+	defer file.Close()
+
+	reader, err := clientrecord.NewReader(file)
+	checkErrorOrQuit(err, "read record file failed")
+	header := reader.Header()
+	showMsg("replaying client %s (%s, auth ID %#v) recorded by gate%d at %s, speed %v", header.ClientID, header.RemoteAddr,
+		header.AuthID, header.GateID, header.StartTime.Format(time.RFC3339), speed)
+
+	client, err := botclient.Dial(gateAddr, botclient.Options{})
+	checkErrorOrQuit(err, "connect to gate failed")
+	defer client.Close()
+
+	startTime := time.Now()
+	err = client.Replay(reader, speed, replayWaitEntityTimeout)
+	checkErrorOrQuit(err, "replay failed")
+	showMsg("replay finished in %s", time.Since(startTime))
+}
+
+func parseReplaySpeed(args []string) float64 {
+	if len(args) == 0 {
+		return 1
+	}
+	speed, err := strconv.ParseFloat(args[0], 64)
+	if err != nil || speed <= 0 {
+		showMsgAndQuit("invalid replay speed: %s", args[0])
+	}
+	return speed
+}
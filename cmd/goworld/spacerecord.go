@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/spacerecord"
+)
+
+// recordSpace starts or stops recording the space on the game by the admin server
+//
+// The record file is written by the game at the same path, and recording is stopped by
+// goworld record-space <gameN> <space-id> --stop
+func recordSpace(name string, spaceID string, args []string) {
+	comp := parseAdminComponent(name, "game")
+	if comp.AdminAddr == "" {
+		showMsgAndQuit("%s: admin_addr is not set", comp.Name)
+	}
+
+	var file string
+	if len(args) > 0 && args[0] != "--stop" {
+		file, args = args[0], args[1:]
+	}
+	flags := flag.NewFlagSet("record-space", flag.ExitOnError)
+	attrs := flags.String("attrs", "", "recorded attributes separated by commas, attributes synced to all clients by default")
+	interval := flags.Duration("interval", 100*time.Millisecond, "interval of sampling states of the space")
+	stop := flags.Bool("stop", false, "stop recording the space")
+	flags.Parse(args)
+
+	ac := newAdminClient()
+	var msg string
+	var err error
+	if *stop {
+		msg, err = ac.post(comp.AdminAddr, "/unrecord_space", url.Values{"space": {spaceID}})
+	} else {
+		if file == "" {
+			showMsgAndQuit("record file is not given")
+		}
+		file, err = filepath.Abs(file)
+		checkErrorOrQuit(err, "get absolute path of record file failed")
+		if *interval < time.Millisecond {
+			showMsgAndQuit("invalid interval: %s", *interval)
+		}
+		msg, err = ac.post(comp.AdminAddr, "/record_space", url.Values{
+			"space":       {spaceID},
+			"file":        {file},
+			"attrs":       {*attrs},
+			"interval_ms": {strconv.Itoa(int(*interval / time.Millisecond))},
+		})
+	}
+	checkErrorOrQuit(err, "record space failed")
+	showMsg("%s", msg)
+}
+
+// dumpSpaceRecord prints the header and events of the space record as JSON lines for offline analysis
+//
+// Usage: goworld dump-space-record <record-file>
+func dumpSpaceRecord(file string) {
+	f, err := os.Open(file)
+	checkErrorOrQuit(err, "open space record failed")
+	defer f.Close()
+
+	r, err := spacerecord.NewReader(f)
+	checkErrorOrQuit(err, "read space record failed")
+
+	enc := json.NewEncoder(os.Stdout)
+	header := r.Header()
+	enc.Encode(map[string]interface{}{
+		"space":      header.SpaceID,
+		"kind":       header.Kind,
+		"world":      header.World,
+		"start_time": header.StartTime,
+	})
+	for {
+		ev, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		checkErrorOrQuit(err, "read space record failed")
+
+		line := map[string]interface{}{
+			"time": ev.Time.Seconds(),
+			"type": ev.Type.String(),
+			"id":   ev.EntityID,
+		}
+		if ev.Type == spacerecord.EventEnter {
+			line["type_name"] = ev.TypeName
+		}
+		if ev.Type == spacerecord.EventEnter || ev.Type == spacerecord.EventMove {
+			line["pos"] = []float32{ev.X, ev.Y, ev.Z}
+			line["yaw"] = ev.Yaw
+		}
+		if ev.Type == spacerecord.EventEnter || ev.Type == spacerecord.EventAttrs {
+			line["attrs"] = ev.Attrs
+		}
+		checkErrorOrQuit(enc.Encode(line), "write event failed")
+	}
+}
@@ -59,13 +59,19 @@ func startGames(sid ServerID, isRestore bool) {
 }
 
 func startGame(sid ServerID, gameid uint16, isRestore bool) {
+	if isRestore {
+		startGameWithArgs(sid, gameid, "-restore")
+	} else {
+		startGameWithArgs(sid, gameid)
+	}
+}
+
+func startGameWithArgs(sid ServerID, gameid uint16, extraArgs ...string) {
 	showMsg("start game %d ...", gameid)
 
 	gameExePath := filepath.Join(sid.Path(), sid.Name()+BinaryExtension)
 	args := []string{"-gid", strconv.Itoa(int(gameid))}
-	if isRestore {
-		args = append(args, "-restore")
-	}
+	args = append(args, extraArgs...)
 	if arguments.runInDaemonMode {
 		args = append(args, "-d")
 	}
@@ -1,8 +1,11 @@
 package main
 
 import (
+	"net/url"
 	"path/filepath"
+	"sort"
 	"strings"
+	"time"
 
 	"fmt"
 
@@ -89,6 +92,7 @@ func detectServerStatus() *ServerStatus {
 func status() {
 	ss := detectServerStatus()
 	showServerStatus(ss)
+	showClusterStatus()
 }
 
 func showServerStatus(ss *ServerStatus) {
@@ -114,3 +118,124 @@ func showServerStatus(ss *ServerStatus) {
 		showMsg("\t%-10d%-16s%s", proc.Pid(), proc.Executable(), cmdline)
 	}
 }
+
+// adminStats is the response of /stats of admin servers
+type adminStats struct {
+	Uptime       float64 `json:"uptime"`
+	NumGoroutine int     `json:"num_goroutine"`
+	HeapAlloc    uint64  `json:"heap_alloc"`
+}
+
+func (s *adminStats) String() string {
+	return fmt.Sprintf("up %s, %d goroutines, heap %.1fMB", time.Duration(s.Uptime*float64(time.Second)).Round(time.Second),
+		s.NumGoroutine, float64(s.HeapAlloc)/1024/1024)
+}
+
+// dispatcherStatus is the response of /status of dispatchers
+type dispatcherStatus struct {
+	Games []struct {
+		GameID     uint16  `json:"gameid"`
+		Connected  bool    `json:"connected"`
+		Blocked    bool    `json:"blocked"`
+		CPUPercent float64 `json:"cpu_percent"`
+	} `json:"games"`
+	Gates             []uint16 `json:"gates"`
+	Entities          int      `json:"entities"`
+	ServiceEntities   int      `json:"service_entities"`
+	ServiceFailovers  int      `json:"service_failovers"`
+	PendingEntityRPCs int      `json:"pending_entity_rpcs"`
+}
+
+// gateStatus is the response of /status of gates
+type gateStatus struct {
+	Clients     int  `json:"clients"`
+	Handshaking int  `json:"handshaking"`
+	Draining    bool `json:"draining"`
+}
+
+// showClusterStatus shows status of dispatchers, games and gates queried from their admin servers
+func showClusterStatus() {
+	ac := newAdminClient()
+	for _, comp := range dispatcherAdminComponents() {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+		var stats adminStats
+		var status dispatcherStatus
+		if err := ac.getJSON(comp.AdminAddr, "/stats", nil, &stats); err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		if err := ac.getJSON(comp.AdminAddr, "/status", nil, &status); err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		showMsg("%s: %s, %d games, %d gates, %d entities (%d services, %d failing over), %d pending RPCs", comp.Name, &stats,
+			len(status.Games), len(status.Gates), status.Entities, status.ServiceEntities, status.ServiceFailovers, status.PendingEntityRPCs)
+		for _, game := range status.Games {
+			state := "connected"
+			if !game.Connected {
+				state = "disconnected"
+			} else if game.Blocked {
+				state = "blocked"
+			}
+			showMsg("\tgame%d: %s, CPU %.1f%%", game.GameID, state, game.CPUPercent)
+		}
+	}
+
+	for _, comp := range gameAdminComponents() {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+		var stats adminStats
+		var entities entityListResponse
+		if err := ac.getJSON(comp.AdminAddr, "/stats", nil, &stats); err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		if err := ac.getJSON(comp.AdminAddr, "/entities", url.Values{"limit": {"0"}}, &entities); err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		showMsg("%s: %s, %d entities (%s)", comp.Name, &stats, entities.Total, formatEntityCounts(entities.Types))
+	}
+
+	for _, comp := range gateAdminComponents() {
+		if comp.AdminAddr == "" {
+			showMsg("%s: admin_addr is not set", comp.Name)
+			continue
+		}
+		var stats adminStats
+		var status gateStatus
+		if err := ac.getJSON(comp.AdminAddr, "/stats", nil, &stats); err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		if err := ac.getJSON(comp.AdminAddr, "/status", nil, &status); err != nil {
+			showMsg("%s: %s", comp.Name, err)
+			continue
+		}
+		draining := ""
+		if status.Draining {
+			draining = ", draining"
+		}
+		showMsg("%s: %s, %d clients (%d handshaking)%s", comp.Name, &stats, status.Clients, status.Handshaking, draining)
+	}
+}
+
+// formatEntityCounts formats numbers of entities by types, sorted by types
+func formatEntityCounts(counts map[string]int) string {
+	typeNames := make([]string, 0, len(counts))
+	for typeName := range counts {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	items := make([]string, len(typeNames))
+	for i, typeName := range typeNames {
+		items[i] = fmt.Sprintf("%s %d", typeName, counts[typeName])
+	}
+	return strings.Join(items, ", ")
+}
@@ -26,13 +26,16 @@ goworld在逻辑开发的时候使用一直单线程事件触发的方式进行
 package goworld
 
 import (
+	"context"
 	"time"
 
-	"github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/components/game"
+	"github.com/xiaonanln/goworld/engine/async"
 	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/crontab"
 	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwtimer"
 	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/service"
@@ -175,6 +178,11 @@ func Exists(typeName string, entityID EntityID, callback storage.ExistsCallbackF
 	storage.Exists(typeName, entityID, callback)
 }
 
+// ExistsContext 与Exists相同，但是ctx（例如Entity.Context()）被取消后callback不会被调用
+func ExistsContext(ctx context.Context, typeName string, entityID EntityID, callback storage.ExistsCallbackFunc) {
+	storage.ExistsContext(ctx, typeName, entityID, callback)
+}
+
 // GetEntity 获得当前game进程中的指定EntityID的Entity对象。不存在则返回nil。
 func GetEntity(id EntityID) *Entity {
 	return entity.GetEntity(id)
@@ -263,14 +271,62 @@ func GetOrPutKVDB(key string, val string, callback kvdb.KVDBGetOrPutCallback) {
 	kvdb.GetOrPut(key, val, callback)
 }
 
+// GetKVDBContext 与GetKVDB相同，但是ctx（例如Entity.Context()）被取消后callback不会被调用
+func GetKVDBContext(ctx context.Context, key string, callback kvdb.KVDBGetCallback) {
+	kvdb.GetContext(ctx, key, callback)
+}
+
+// PutKVDBContext 与PutKVDB相同，如果ctx在操作开始前已经结束，则不会存入key-value键值对
+func PutKVDBContext(ctx context.Context, key string, val string, callback kvdb.KVDBPutCallback) {
+	kvdb.PutContext(ctx, key, val, callback)
+}
+
+// GetOrPutKVDBContext 与GetOrPutKVDB相同，但是使用ctx控制截止时间和取消
+func GetOrPutKVDBContext(ctx context.Context, key string, val string, callback kvdb.KVDBGetOrPutCallback) {
+	kvdb.GetOrPutContext(ctx, key, val, callback)
+}
+
 // AddCallback 添加一个定时回调。回调将在指定时间之后触发。回调函数（callback）总是在主线程（逻辑coroutine）中运行。
 func AddCallback(d time.Duration, callback func()) {
-	timer.AddCallback(d, callback)
+	gwtimer.AddCallback(d, callback)
+}
+
+// AddCallbackHandle 和AddCallback一样添加一个定时回调，返回的Timer可以在主线程中取消（Cancel）或者重置（Reset）。
+func AddCallbackHandle(d time.Duration, callback func()) *gwtimer.Timer {
+	return gwtimer.AddCallback(d, callback)
 }
 
 // AddTimer 添加一个定时触发的回调函数。在制定时间间隔之后触发第一次，以后每过指定时间触发一次。所有触发函数总是在主线程（逻辑coroutine）中执行。
 func AddTimer(d time.Duration, callback func()) {
-	timer.AddTimer(d, callback)
+	gwtimer.AddTimer(d, callback)
+}
+
+// AddTimerHandle 和AddTimer一样添加一个定时触发的回调函数，返回的Timer可以在主线程中取消（Cancel）或者重置（Reset）。
+func AddTimerHandle(d time.Duration, callback func()) *gwtimer.Timer {
+	return gwtimer.AddTimer(d, callback)
+}
+
+// Async 在group对应的工作协程池中执行routine，并在主线程（逻辑coroutine）中以routine的结果调用callback。
+// routine在返回的函数被调用时开始执行，例如goworld.Async("http", routine)(callback)。routine中不应该访问entity等主线程中的数据。
+// 每个group默认只有1个工作协程，按顺序执行，可以在game配置中用async_workers设置group的工作协程数目。
+func Async(group string, routine async.AsyncRoutine) func(callback async.AsyncCallback) {
+	return func(callback async.AsyncCallback) {
+		async.AppendAsyncJob(group, routine, callback)
+	}
+}
+
+// AsyncContext 与Async相同，但是使用ctx（例如Entity.Context()）控制routine的截止时间。ctx被取消后callback不会被调用，
+// 因此不会在已经销毁的entity上执行回调。
+func AsyncContext(ctx context.Context, group string, routine async.ContextAsyncRoutine) func(callback async.AsyncCallback) {
+	return func(callback async.AsyncCallback) {
+		async.AppendAsyncJobContext(ctx, group, routine, callback)
+	}
+}
+
+// AddCron 添加一个按cron表达式触发的回调函数，例如"0 5 * * *"表示每天5点触发。可以用前缀CRON_TZ=<时区>指定时区，
+// 例如"CRON_TZ=Asia/Shanghai 0 5 * * *"。回调函数总是在主线程（逻辑coroutine）中执行。
+func AddCron(spec string, cb func()) (*crontab.Cron, error) {
+	return crontab.AddCron(spec, cb)
 }
 
 func Post(callback post.PostCallback) {
@@ -0,0 +1,168 @@
+package main
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"path"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwgrpc"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/rbac"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	_MAX_REQUEST_BODY_SIZE = 1024 * 1024
+	_CALL_TIMEOUT          = time.Second * 10
+)
+
+// HTTPBridge maps authenticated REST requests to entity & service calls through gRPC of games
+//
+//	POST /services/<ServiceName>/<Method>  with JSON array of arguments as body
+//	POST /entities/<EntityID>/<Method>     with JSON array of arguments as body
+//
+// Requests carrying tokens of users in [rbac] config are authorized by actions call_service:<ServiceName>.<Method> and
+// call_entity:<Method>.
+type HTTPBridge struct {
+	cfg     *config.BridgeConfig
+	clients []*gwgrpc.EntityServiceClient
+	next    uint32
+}
+
+func newHTTPBridge(cfg *config.BridgeConfig) *HTTPBridge {
+	bridge := &HTTPBridge{
+		cfg: cfg,
+	}
+	dialOpt := grpc.WithInsecure()
+	if cfg.GRPCCAFile != "" {
+		caFile := path.Join(config.GetConfigDir(), cfg.GRPCCAFile)
+		caData, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			gwlog.Fatalf("load gRPC CA failed: %v", err)
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caData) {
+			gwlog.Fatalf("no certificates found in %s", caFile)
+		}
+		dialOpt = grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: rootCAs}))
+	}
+	for _, addr := range cfg.GRPCAddrs {
+		cc, err := grpc.Dial(addr, dialOpt)
+		if err != nil {
+			gwlog.Fatalf("dial gRPC %s failed: %v", addr, err)
+		}
+		bridge.clients = append(bridge.clients, gwgrpc.NewEntityServiceClient(cc))
+	}
+	return bridge
+}
+
+// selectClient selects the gRPC client of games in round-robin
+func (bridge *HTTPBridge) selectClient() *gwgrpc.EntityServiceClient {
+	idx := atomic.AddUint32(&bridge.next, 1)
+	return bridge.clients[idx%uint32(len(bridge.clients))]
+}
+
+func (bridge *HTTPBridge) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != "POST" {
+		writeError(w, http.StatusMethodNotAllowed, "only POST is allowed")
+		return
+	}
+	user, ok := bridge.authenticate(r)
+	if !ok {
+		writeError(w, http.StatusUnauthorized, "invalid token")
+		return
+	}
+
+	// path: /<kind>/<target>/<method>
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+		writeError(w, http.StatusNotFound, "invalid path: "+r.URL.Path)
+		return
+	}
+
+	argsJSON, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, _MAX_REQUEST_BODY_SIZE))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(gwgrpc.WithToken(r.Context(), bridge.cfg.GRPCToken), _CALL_TIMEOUT)
+	defer cancel()
+
+	kind, target, method := parts[0], parts[1], parts[2]
+	if user != rbac.Superuser {
+		action := "call_service:" + target + "." + method
+		if kind == "entities" {
+			action = "call_entity:" + method
+		}
+		if err := rbac.Authorize(user, action, r.RemoteAddr); err != nil {
+			writeError(w, http.StatusForbidden, user+" is not allowed to "+action)
+			return
+		}
+	}
+
+	if kind == "services" {
+		_, err = bridge.selectClient().CallService(ctx, &gwgrpc.CallServiceRequest{ServiceName: target, Method: method, ArgsJSON: string(argsJSON)})
+	} else if kind == "entities" {
+		_, err = bridge.selectClient().CallEntity(ctx, &gwgrpc.CallEntityRequest{EntityID: target, Method: method, ArgsJSON: string(argsJSON)})
+	} else {
+		writeError(w, http.StatusNotFound, "invalid path: "+r.URL.Path)
+		return
+	}
+
+	if err != nil {
+		gwlog.Warnf("HTTP bridge: %s %s failed: %v", r.Method, r.URL.Path, err)
+		writeError(w, httpStatusOfGRPCError(err), status.Convert(err).Message())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{"ok": true})
+}
+
+// authenticate returns rbac.Superuser for [bridge].token, or the user of the token in [rbac] config
+func (bridge *HTTPBridge) authenticate(r *http.Request) (string, bool) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") {
+		return "", false
+	}
+	token := strings.TrimPrefix(auth, "Bearer ")
+	if bridge.cfg.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(bridge.cfg.Token)) == 1 {
+		return rbac.Superuser, true
+	}
+	return rbac.Authenticate(token)
+}
+
+func httpStatusOfGRPCError(err error) int {
+	switch status.Code(err) {
+	case codes.InvalidArgument:
+		return http.StatusBadRequest
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeError(w http.ResponseWriter, statusCode int, msg string) {
+	writeJSON(w, statusCode, map[string]interface{}{"ok": false, "error": msg})
+}
+
+func writeJSON(w http.ResponseWriter, statusCode int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"flag"
+	"net/http"
+
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/rbac"
+)
+
+var (
+	configFile      string
+	logLevel        string
+	runInDaemonMode bool
+)
+
+func parseArgs() {
+	flag.StringVar(&configFile, "configfile", "", "set config file path")
+	flag.Var(config.OverrideFlag{}, "config", "override config value in the form of section.key=value, e.g. -config game1.http_addr=:25001, can be repeated")
+	flag.StringVar(&logLevel, "log", "", "set log level, will override log level in config")
+	flag.BoolVar(&runInDaemonMode, "d", false, "run in daemon mode")
+	flag.Parse()
+}
+
+func main() {
+	parseArgs()
+	if runInDaemonMode {
+		daemoncontext := binutil.Daemonize()
+		defer daemoncontext.Release()
+	}
+
+	if configFile != "" {
+		config.SetConfigFile(configFile)
+	}
+
+	bridgeConfig := config.GetBridge()
+	binutil.OnReloadLogLevel("bridge", logLevel, func() string {
+		return config.GetBridge().LogLevel
+	})
+	if logLevel == "" {
+		logLevel = bridgeConfig.LogLevel
+	}
+	binutil.SetupGWLog("bridge", logLevel, bridgeConfig.LogFile, bridgeConfig.LogStderr, bridgeConfig.LogFormat)
+
+	if bridgeConfig.Token == "" && !rbac.Enabled() {
+		gwlog.Fatalf("neither [bridge].token nor [rbac] users is set")
+	}
+	if len(bridgeConfig.GRPCAddrs) == 0 {
+		gwlog.Fatalf("[bridge].grpc_addrs is not set")
+	}
+
+	binutil.HandleReloadConfigSignal(binutil.ReloadConfigSignal)
+	config.StartWatch()
+	bridge := newHTTPBridge(bridgeConfig)
+	gwlog.Infof("HTTP bridge listening on %s, calling games on %v ...", bridgeConfig.ListenAddr, bridgeConfig.GRPCAddrs)
+	if err := http.ListenAndServe(bridgeConfig.ListenAddr, bridge); err != nil {
+		gwlog.Fatalf("HTTP bridge quited: %v", err)
+	}
+}
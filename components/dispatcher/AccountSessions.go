@@ -0,0 +1,88 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/accountsession"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// handleClaimAccountSession claims the session of the account for the entity, and acks the claiming game with the
+// result and the older sessions which should be kicked
+//
+// Claims of the same account are always handled by the same dispatcher, so that simultaneous logins are serialized.
+func (service *DispatcherService) handleClaimAccountSession(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	account := pkt.ReadVarStr()
+	eid := pkt.ReadEntityID()
+	gateid := pkt.ReadUint16()
+	maxSessions := int(pkt.ReadUint32())
+	kickOlder := pkt.ReadBool()
+	refresh := pkt.ReadBool()
+
+	session := accountsession.Session{EntityID: eid, GameID: dcp.gameid, GateID: gateid, LoginTime: time.Now()}
+	if refresh {
+		// the session is already claimed, e.g. the entity is migrated or the dispatcher is restarted
+		service.accountSessions.Refresh(account, session)
+		return
+	}
+
+	ok, kicked := service.accountSessions.Claim(account, session, maxSessions, kickOlder)
+	kickedEids := make(common.EntityIDSet, len(kicked))
+	for _, s := range kicked {
+		kickedEids.Add(s.EntityID)
+	}
+	if !ok {
+		gwlog.Infof("%s: session of account %s is rejected for %s on game%d: too many sessions", service, account, eid, dcp.gameid)
+	} else if len(kicked) > 0 {
+		gwlog.Infof("%s: session of account %s is claimed by %s on game%d, kicking %v", service, account, eid, dcp.gameid, kicked)
+	}
+	dcp.SendClaimAccountSessionAck(account, eid, ok, kickedEids)
+}
+
+func (service *DispatcherService) handleReleaseAccountSession(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	account := pkt.ReadVarStr()
+	eid := pkt.ReadEntityID()
+	service.accountSessions.Release(account, eid)
+}
+
+// handleQueryOnlinePlayer acks the querying game with the player of the latest session of the account
+func (service *DispatcherService) handleQueryOnlinePlayer(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	account := pkt.ReadVarStr()
+	requestID := pkt.ReadUint32()
+
+	var data []byte
+	if player := service.accountSessions.FindPlayer(account); player != nil {
+		var err error
+		if data, err = netutil.MSG_PACKER.PackMsg(player, nil); err != nil {
+			gwlog.Errorf("%s: pack online player %s failed: %s", service, account, err)
+			data = nil
+		}
+	}
+	dcp.SendQueryOnlinePlayerAck(requestID, data)
+}
+
+// emitOnlinePlayerEvent emits LoginEvent or LogoutEvent of the player to the event bus of all games
+//
+// Events of each account are emitted by the dispatcher selected by the account, so they are delivered in order.
+func (service *DispatcherService) emitOnlinePlayerEvent(player *accountsession.OnlinePlayer, online bool) {
+	name := accountsession.LogoutEvent
+	if online {
+		name = accountsession.LoginEvent
+	}
+	data, err := netutil.MSG_PACKER.PackMsg(player, nil)
+	if err != nil {
+		gwlog.Errorf("%s: pack %s event of %s failed: %s", service, name, player.Account, err)
+		return
+	}
+	service.broadcastToGamesRelease(proto.MakeEmitEventPacket(name, "", data))
+}
+
+// releaseAccountSessionsOfGame releases sessions held by entities on the game which is down
+func (service *DispatcherService) releaseAccountSessionsOfGame(gameid uint16) {
+	if released := service.accountSessions.ReleaseGame(gameid); released > 0 {
+		gwlog.Infof("%s: game%d is down, %d account sessions released", service, gameid, released)
+	}
+}
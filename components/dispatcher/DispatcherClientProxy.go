@@ -29,6 +29,7 @@ func newDispatcherClientProxy(owner *DispatcherService, _conn net.Conn) *dispatc
 		owner:             owner,
 	}
 	dcp.SetAutoFlush(consts.DISPATCHER_CLIENT_PROXY_WRITE_FLUSH_INTERVAL)
+	dcp.EnableFaultInjection()
 	return dcp
 }
 
@@ -14,6 +14,7 @@ import (
 	"container/heap"
 
 	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/accountsession"
 	"github.com/xiaonanln/goworld/engine/binutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
@@ -23,6 +24,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/watchdog"
 )
 
 type entityDispatchInfo struct {
@@ -86,6 +88,7 @@ type gameDispatchInfo struct {
 	blockUntilTime     time.Time // game can be blocked
 	pendingPacketQueue []*netutil.Packet
 	isBanBootEntity    bool
+	isShuttingDown     bool // game is shutting down, so boot entities are not created on the game
 	lbcheapentry       *lbcheapentry
 }
 
@@ -183,7 +186,10 @@ type DispatcherService struct {
 	messageQueue          chan dispatcherMessage
 	entityDispatchInfos   map[common.EntityID]*entityDispatchInfo
 	srvdisRegisterMap     map[string]string
-	entitySyncInfosToGame map[uint16]*netutil.Packet // cache entity sync infos to gates
+	supervisedServices    map[common.EntityID]*supervisedService
+	serviceSnapshots      map[string]*serviceSnapshot // ShardName -> Latest snapshot
+	accountSessions       *accountsession.Table       // active sessions of accounts selecting the dispatcher
+	entitySyncInfosToGame map[uint16]*netutil.Packet  // cache entity sync infos to gates
 	ticker                <-chan time.Time
 	lbcheap               lbcheap // heap for game load balancing
 	chooseGameIdx         int     // choose game in a round robin way
@@ -200,6 +206,9 @@ func newDispatcherService(dispid uint16) *DispatcherService {
 		gates:                 map[uint16]*dispatcherClientProxy{},
 		entityDispatchInfos:   map[common.EntityID]*entityDispatchInfo{},
 		srvdisRegisterMap:     map[string]string{},
+		supervisedServices:    map[common.EntityID]*supervisedService{},
+		serviceSnapshots:      map[string]*serviceSnapshot{},
+		accountSessions:       accountsession.NewTable(),
 		entitySyncInfosToGame: map[uint16]*netutil.Packet{},
 		ticker:                time.Tick(consts.DISPATCHER_SERVICE_TICK_INTERVAL),
 		lbcheap:               nil,
@@ -207,6 +216,7 @@ func newDispatcherService(dispid uint16) *DispatcherService {
 	}
 
 	ds.recalcBootGames()
+	ds.accountSessions.SetListener(ds.emitOnlinePlayerEvent)
 
 	return ds
 }
@@ -226,8 +236,11 @@ func (service *DispatcherService) messageLoop() {
 					service.handleSyncPositionYawFromClient(dcp, pkt)
 				case proto.MT_SYNC_POSITION_YAW_ON_CLIENTS:
 					service.handleSyncPositionYawOnClients(dcp, pkt)
-				case proto.MT_CALL_ENTITY_METHOD:
+				case proto.MT_CALL_ENTITY_METHOD, proto.MT_CALL_SERVICE_REQUEST, proto.MT_KICK_ACCOUNT_SESSION:
+					// service requests and kicks of account sessions are dispatched to entities just like entity calls
 					service.handleCallEntityMethod(dcp, pkt)
+				case proto.MT_SERVICE_RESPONSE:
+					service.handleServiceResponse(dcp, pkt)
 				case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:
 					service.handleCallEntityMethodFromClient(dcp, pkt)
 				case proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE:
@@ -238,10 +251,18 @@ func (service *DispatcherService) messageLoop() {
 					service.handleRealMigrate(dcp, pkt)
 				case proto.MT_CALL_FILTERED_CLIENTS:
 					service.handleCallFilteredClientProxies(dcp, pkt)
+				case proto.MT_SYNC_BAN_LIST:
+					service.handleSyncBanList(dcp, pkt)
 				case proto.MT_NOTIFY_CLIENT_CONNECTED:
 					service.handleNotifyClientConnected(dcp, pkt)
 				case proto.MT_NOTIFY_CLIENT_DISCONNECTED:
 					service.handleNotifyClientDisconnected(dcp, pkt)
+				case proto.MT_NOTIFY_CLIENT_FLOOD:
+					service.handleNotifyClientFlood(dcp, pkt)
+				case proto.MT_NOTIFY_CLIENT_LATENCY:
+					service.handleNotifyClientLatency(dcp, pkt)
+				case proto.MT_RESUME_CLIENT_SESSION:
+					service.handleResumeClientSession(dcp, pkt)
 				case proto.MT_LOAD_ENTITY_SOMEWHERE:
 					service.handleLoadEntitySomewhere(dcp, pkt)
 				case proto.MT_NOTIFY_CREATE_ENTITY:
@@ -260,6 +281,18 @@ func (service *DispatcherService) messageLoop() {
 					service.handleCancelMigrate(dcp, pkt)
 				case proto.MT_SRVDIS_REGISTER:
 					service.handleSrvdisRegister(dcp, pkt)
+				case proto.MT_SERVICE_SNAPSHOT:
+					service.handleServiceSnapshot(dcp, pkt)
+				case proto.MT_SUPERVISE_SERVICE_ENTITY:
+					service.handleSuperviseServiceEntity(dcp, pkt)
+				case proto.MT_EMIT_EVENT:
+					service.handleEmitEvent(dcp, pkt)
+				case proto.MT_CLAIM_ACCOUNT_SESSION:
+					service.handleClaimAccountSession(dcp, pkt)
+				case proto.MT_RELEASE_ACCOUNT_SESSION:
+					service.handleReleaseAccountSession(dcp, pkt)
+				case proto.MT_QUERY_ONLINE_PLAYER:
+					service.handleQueryOnlinePlayer(dcp, pkt)
 				case proto.MT_SET_GAME_ID:
 					// this is a game server
 					service.handleSetGameID(dcp, pkt)
@@ -269,6 +302,8 @@ func (service *DispatcherService) messageLoop() {
 				case proto.MT_START_FREEZE_GAME:
 					// freeze the game
 					service.handleStartFreezeGame(dcp, pkt)
+				case proto.MT_NOTIFY_GAME_SHUTTING_DOWN:
+					service.handleNotifyGameShuttingDown(dcp)
 				default:
 					gwlog.TraceError("unknown msgtype %d from %s", msgtype, dcp)
 				}
@@ -277,8 +312,10 @@ func (service *DispatcherService) messageLoop() {
 			pkt.Release()
 			break
 		case <-service.ticker:
+			watchdog.Heartbeat()
 			post.Tick()
 			service.sendEntitySyncInfosToGames()
+			service.checkServiceFailovers()
 			break
 		}
 	}
@@ -351,7 +388,7 @@ func (service *DispatcherService) handleSetGameID(dcp *dispatcherClientProxy, pk
 	gdi := service.games[gameid]
 	if gdi == nil {
 		// new game connected, create dispatch info for the game
-		lbcheapentry := &lbcheapentry{gameid, len(service.lbcheap), 0, 0}
+		lbcheapentry := &lbcheapentry{gameid, len(service.lbcheap), 0, 0, false, false}
 		gdi = &gameDispatchInfo{gameid: gameid, isBanBootEntity: isBanBootEntity, lbcheapentry: lbcheapentry}
 		service.games[gameid] = gdi
 		heap.Push(&service.lbcheap, lbcheapentry)
@@ -369,7 +406,10 @@ func (service *DispatcherService) handleSetGameID(dcp *dispatcherClientProxy, pk
 	gdi.isBanBootEntity = isBanBootEntity
 	gdi.setClientProxy(dcp) // should be nil, unless reconnect
 	gdi.unblock()           // unlock game dispatch info if new game is connected
-	if oldIsBanBootEntity != isBanBootEntity {
+	if gdi.isShuttingDown {
+		// the game notifies again after reconnected if it is still shutting down
+		service.setGameShuttingDown(gdi, false)
+	} else if oldIsBanBootEntity != isBanBootEntity {
 		service.recalcBootGames() // recalc if necessary
 	}
 
@@ -396,6 +436,7 @@ func (service *DispatcherService) handleSetGameID(dcp *dispatcherClientProxy, pk
 	connectedGameIDs := service.getConnectedGameIDs()
 
 	dcp.SendSetGameIDAck(service.dispid, service.isDeploymentReady, connectedGameIDs, rejectEntities, service.srvdisRegisterMap)
+	service.sendServiceSnapshots(dcp)
 	service.sendNotifyGameConnected(gameid)
 	service.checkDeploymentReady()
 	return
@@ -484,7 +525,11 @@ func (service *DispatcherService) handleStartFreezeGame(dcp *dispatcherClientPro
 		gwlog.Panicf("%s handleStartFreezeGame: game%d not found", service, gameid)
 	}
 
-	gdi.block(consts.DISPATCHER_FREEZE_GAME_TIMEOUT)
+	if toAnotherHost := pkt.ReadBool(); toAnotherHost {
+		gdi.block(consts.DISPATCHER_MOVE_GAME_TIMEOUT)
+	} else {
+		gdi.block(consts.DISPATCHER_FREEZE_GAME_TIMEOUT)
+	}
 
 	// tell the game to start real freeze, re-using the packet
 	pkt.ClearPayload()
@@ -493,6 +538,27 @@ func (service *DispatcherService) handleStartFreezeGame(dcp *dispatcherClientPro
 	dcp.SendPacket(pkt)
 }
 
+// handleNotifyGameShuttingDown stops creating boot entities on the game, and prefers other games for creating and
+// loading entities anywhere
+func (service *DispatcherService) handleNotifyGameShuttingDown(dcp *dispatcherClientProxy) {
+	gdi := service.games[dcp.gameid]
+	if gdi == nil {
+		gwlog.Errorf("%s handleNotifyGameShuttingDown: game%d not found", service, dcp.gameid)
+		return
+	}
+
+	gwlog.Infof("%s: game%d is shutting down", service, dcp.gameid)
+	service.setGameShuttingDown(gdi, true)
+}
+
+func (service *DispatcherService) setGameShuttingDown(gdi *gameDispatchInfo, shuttingDown bool) {
+	gdi.isShuttingDown = shuttingDown
+	gdi.lbcheapentry.shuttingDown = shuttingDown
+	heap.Fix(&service.lbcheap, gdi.lbcheapentry.heapidx)
+	service.lbcheap.validateHeapIndexes()
+	service.recalcBootGames()
+}
+
 func (service *DispatcherService) isAllGameClientsConnected() bool {
 	for _, gdi := range service.games {
 		if !gdi.isConnected() {
@@ -619,6 +685,8 @@ func (service *DispatcherService) handleGameDown(gdi *gameDispatchInfo) {
 	gwlog.Infof("%s: game%d is down, cleaning up...", service, gameid)
 	service.cleanupEntitiesOfGame(gameid)
 	gdi.clearPendingPackets()
+	service.startServiceFailover(gameid)
+	service.releaseAccountSessionsOfGame(gameid)
 
 	// send gamedown packet to all games
 	service.broadcastToGamesRelease(proto.MakeNotifyGameDisconnectedPacket(gameid))
@@ -647,6 +715,7 @@ func (service *DispatcherService) handleNotifyCreateEntity(dcp *dispatcherClient
 	entityDispatchInfo := service.setEntityDispatcherInfoForWrite(entityID)
 	entityDispatchInfo.gameid = dcp.gameid
 	entityDispatchInfo.unblock()
+	service.finishServiceFailover(entityID, entityDispatchInfo)
 }
 
 func (service *DispatcherService) handleNotifyDestroyEntity(dcp *dispatcherClientProxy, pkt *netutil.Packet, entityID common.EntityID) {
@@ -654,6 +723,7 @@ func (service *DispatcherService) handleNotifyDestroyEntity(dcp *dispatcherClien
 		gwlog.Debugf("%s.handleNotifyDestroyEntity: dcp=%s, entityID=%s", service, dcp, entityID)
 	}
 	service.cleanupEntityInfo(entityID)
+	delete(service.supervisedServices, entityID)
 }
 
 func (service *DispatcherService) cleanupEntityInfo(entityID common.EntityID) {
@@ -676,6 +746,41 @@ func (service *DispatcherService) handleNotifyClientDisconnected(dcp *dispatcher
 	}
 }
 
+func (service *DispatcherService) handleNotifyClientFlood(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	ownerEntityID := pkt.ReadEntityID() // owner entity's ID for the client
+	edi := service.entityDispatchInfos[ownerEntityID]
+	if edi != nil {
+		edi.dispatchPacket(pkt)
+	} else {
+		gwlog.Warnf("%s: client %s is flooding, but owner entity %s not found", service, dcp, ownerEntityID)
+	}
+}
+
+func (service *DispatcherService) handleNotifyClientLatency(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	ownerEntityID := pkt.ReadEntityID() // owner entity's ID for the client
+	edi := service.entityDispatchInfos[ownerEntityID]
+	if edi != nil {
+		edi.dispatchPacket(pkt)
+	} else {
+		gwlog.Debugf("%s: client latency of %s is measured, but owner entity %s not found", service, dcp, ownerEntityID)
+	}
+}
+
+func (service *DispatcherService) handleResumeClientSession(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	ownerEntityID := pkt.ReadEntityID()
+	edi := service.entityDispatchInfos[ownerEntityID]
+	if edi != nil && edi.gameid != 0 {
+		pkt.AppendUint16(dcp.gateid)
+		edi.dispatchPacket(pkt)
+	} else {
+		// owner entity not found, session can not be resumed
+		_ = pkt.ReadVarStr() // session token
+		clientid := pkt.ReadClientID()
+		gwlog.Warnf("%s: client %s can not resume session: owner entity %s not found", service, clientid, ownerEntityID)
+		dcp.SendNotifySessionResumedOnClient(dcp.gateid, clientid, ownerEntityID, false)
+	}
+}
+
 func (service *DispatcherService) handleLoadEntitySomewhere(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	//typeName := pkt.ReadVarStr()
 	//eid := pkt.ReadEntityID()
@@ -747,6 +852,42 @@ func (service *DispatcherService) handleSrvdisRegister(dcp *dispatcherClientProx
 	}
 }
 
+// serviceSnapshot is the latest snapshot published by the service shard, which is sent to games when they are connected
+type serviceSnapshot struct {
+	version uint64
+	packet  *netutil.Packet
+}
+
+func (service *DispatcherService) handleServiceSnapshot(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	shardName := pkt.ReadVarStr()
+	version := pkt.ReadUint64()
+
+	snapshot := service.serviceSnapshots[shardName]
+	if snapshot != nil {
+		if version <= snapshot.version {
+			// stale snapshot, or republished after dispatcher reconnected
+			return
+		}
+		snapshot.packet.Release()
+	}
+
+	pkt.AddRefCount(1)
+	service.serviceSnapshots[shardName] = &serviceSnapshot{version: version, packet: pkt}
+	service.broadcastToGames(pkt)
+	gwlog.Infof("%s: service %s published snapshot version %d, size %d", service, shardName, version, pkt.GetPayloadLen())
+}
+
+func (service *DispatcherService) handleEmitEvent(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	// the event is also delivered to subscribers on the emitting game
+	service.broadcastToGames(pkt)
+}
+
+func (service *DispatcherService) sendServiceSnapshots(dcp *dispatcherClientProxy) {
+	for _, snapshot := range service.serviceSnapshots {
+		dcp.SendPacket(snapshot.packet)
+	}
+}
+
 func (service *DispatcherService) handleServiceDown(gameid uint16, serviceName string, eid common.EntityID) {
 	gwlog.Warnf("%s: service %s: entity %s is down!", service, serviceName, eid)
 	pkt := netutil.NewPacket()
@@ -764,6 +905,10 @@ func (service *DispatcherService) handleCallEntityMethod(dcp *dispatcherClientPr
 		gwlog.Debugf("%s.handleCallEntityMethod: dcp=%s, entityID=%s", service, dcp, entityID)
 	}
 
+	if service.tryQueueServiceCall(dcp, entityID, pkt) {
+		return
+	}
+
 	entityDispatchInfo := service.entityDispatchInfos[entityID]
 	if entityDispatchInfo != nil {
 		entityDispatchInfo.dispatchPacket(pkt)
@@ -772,6 +917,11 @@ func (service *DispatcherService) handleCallEntityMethod(dcp *dispatcherClientPr
 	}
 }
 
+func (service *DispatcherService) handleServiceResponse(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	callerGameID := pkt.ReadUint16()
+	service.dispatchPacketToGame(callerGameID, pkt)
+}
+
 func (service *DispatcherService) handleCallNilSpaces(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	// send the packet to all games
 	exceptGameID := pkt.ReadUint16()
@@ -847,6 +997,11 @@ func (service *DispatcherService) handleCallFilteredClientProxies(dcp *dispatche
 	service.broadcastToGates(pkt)
 }
 
+// handleSyncBanList broadcasts the ban or unban to all gates, including the gate which sent it
+func (service *DispatcherService) handleSyncBanList(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	service.broadcastToGates(pkt)
+}
+
 func (service *DispatcherService) handleQuerySpaceGameIDForMigrate(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
 	spaceid := pkt.ReadEntityID()
 	if consts.DEBUG_PACKETS {
@@ -900,6 +1055,10 @@ func (service *DispatcherService) handleRealMigrate(dcp *dispatcherClientProxy,
 	entityDispatchInfo := service.setEntityDispatcherInfoForWrite(eid)
 
 	entityDispatchInfo.gameid = targetGame
+	if ss := service.supervisedServices[eid]; ss != nil {
+		// service entity is handed off to the target game
+		ss.gameid = targetGame
+	}
 
 	service.dispatchPacketToGame(targetGame, pkt)
 	// send the cached calls to target game
@@ -937,7 +1096,7 @@ func (service *DispatcherService) broadcastToGates(pkt *netutil.Packet) {
 func (service *DispatcherService) recalcBootGames() {
 	var candidates []uint16
 	for gameid, gdi := range service.games {
-		if !gdi.isBanBootEntity {
+		if !gdi.isBanBootEntity && !gdi.isShuttingDown {
 			candidates = append(candidates, gameid)
 		}
 	}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// supervisedService is a service entity whose calls are kept by dispatcher when its game is down
+type supervisedService struct {
+	shardName        string
+	gameid           uint16
+	failoverDeadline time.Time // zero if the service entity is not lost
+	pendingCalls     []pendingServiceCall
+}
+
+type pendingServiceCall struct {
+	callerGameID uint16
+	packet       *netutil.Packet
+}
+
+func (ss *supervisedService) isFailingOver() bool {
+	return !ss.failoverDeadline.IsZero()
+}
+
+func (service *DispatcherService) handleSuperviseServiceEntity(dcp *dispatcherClientProxy, pkt *netutil.Packet) {
+	eid := pkt.ReadEntityID()
+	shardName := pkt.ReadVarStr()
+
+	ss := service.supervisedServices[eid]
+	if ss == nil {
+		ss = &supervisedService{}
+		service.supervisedServices[eid] = ss
+		gwlog.Infof("%s: supervising service %s: entity %s on game%d", service, shardName, eid, dcp.gameid)
+	}
+	ss.shardName = shardName
+	ss.gameid = dcp.gameid
+
+	// the service is re-created as another entity, so calls to the lost entity can not be replayed
+	for lostEid, lostSs := range service.supervisedServices {
+		if lostEid != eid && lostSs.shardName == shardName && lostSs.isFailingOver() {
+			service.failServiceCalls(lostEid, lostSs)
+		}
+	}
+}
+
+// startServiceFailover keeps calls to the services on the game which is down, until services are re-created
+func (service *DispatcherService) startServiceFailover(gameid uint16) {
+	timeout := service.config.ServiceFailoverTimeout
+	for eid, ss := range service.supervisedServices {
+		if ss.gameid != gameid {
+			continue
+		}
+
+		if timeout <= 0 {
+			delete(service.supervisedServices, eid)
+			continue
+		}
+		gwlog.Warnf("%s: service %s: entity %s is lost with game%d, failing over in %s ...", service, ss.shardName, eid, gameid, timeout)
+		ss.failoverDeadline = time.Now().Add(timeout)
+	}
+
+	// services hosted by the game should be registered again by other games
+	for srvid, srvinfo := range service.srvdisRegisterMap {
+		if srvinfo != fmt.Sprintf("game%d", gameid) {
+			continue
+		}
+
+		gwlog.Warnf("%s: srvdis unregister %s = %s since game%d is down", service, srvid, srvinfo, gameid)
+		delete(service.srvdisRegisterMap, srvid)
+		pkt := netutil.NewPacket()
+		pkt.AppendUint16(proto.MT_SRVDIS_REGISTER)
+		pkt.AppendVarStr(srvid)
+		pkt.AppendVarStr("") // empty srvinfo for unregistering
+		pkt.AppendBool(true)
+		service.broadcastToGamesRelease(pkt)
+	}
+}
+
+// tryQueueServiceCall queues the call if the target service entity is failing over
+func (service *DispatcherService) tryQueueServiceCall(dcp *dispatcherClientProxy, eid common.EntityID, pkt *netutil.Packet) bool {
+	ss := service.supervisedServices[eid]
+	if ss == nil || !ss.isFailingOver() {
+		return false
+	}
+
+	if len(ss.pendingCalls) >= consts.ENTITY_PENDING_PACKET_QUEUE_MAX_LEN {
+		gwlog.Errorf("%s: service %s: too many calls pending for failover, call dropped", service, ss.shardName)
+		service.notifyServiceCallFailed(dcp.gameid, ss.shardName, eid, pkt)
+		return true
+	}
+
+	pkt.AddRefCount(1)
+	ss.pendingCalls = append(ss.pendingCalls, pendingServiceCall{dcp.gameid, pkt})
+	return true
+}
+
+// finishServiceFailover replays calls to the service entity which is reloaded
+func (service *DispatcherService) finishServiceFailover(eid common.EntityID, edi *entityDispatchInfo) {
+	ss := service.supervisedServices[eid]
+	if ss == nil || !ss.isFailingOver() {
+		return
+	}
+
+	gwlog.Infof("%s: service %s: entity %s is reloaded on game%d, replaying %d calls", service, ss.shardName, eid, edi.gameid, len(ss.pendingCalls))
+	ss.gameid = edi.gameid
+	ss.failoverDeadline = time.Time{}
+	pendingCalls := ss.pendingCalls
+	ss.pendingCalls = nil
+	for _, call := range pendingCalls {
+		edi.dispatchPacket(call.packet)
+		call.packet.Release()
+	}
+}
+
+// checkServiceFailovers fails calls to services which are not re-created in time
+func (service *DispatcherService) checkServiceFailovers() {
+	now := time.Now()
+	for eid, ss := range service.supervisedServices {
+		if ss.isFailingOver() && now.After(ss.failoverDeadline) {
+			gwlog.Errorf("%s: service %s: entity %s is not reloaded in time", service, ss.shardName, eid)
+			service.failServiceCalls(eid, ss)
+		}
+	}
+}
+
+func (service *DispatcherService) failServiceCalls(eid common.EntityID, ss *supervisedService) {
+	delete(service.supervisedServices, eid)
+	for _, call := range ss.pendingCalls {
+		service.notifyServiceCallFailed(call.callerGameID, ss.shardName, eid, call.packet)
+		call.packet.Release()
+	}
+	ss.pendingCalls = nil
+}
+
+func (service *DispatcherService) notifyServiceCallFailed(callerGameID uint16, shardName string, eid common.EntityID, pkt *netutil.Packet) {
+	method := pkt.ReadVarStr() // entity ID is already read
+	gdi := service.games[callerGameID]
+	if gdi == nil || gdi.clientProxy == nil {
+		return
+	}
+	gdi.clientProxy.SendNotifyServiceCallFailed(shardName, eid, method)
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/accountsession"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+const (
+	testCallerGameID  = 1 // the game calling services, which is connected
+	testServiceGameID = 2 // the game of services, which is down
+	testBackupGameID  = 3 // the game re-creating services, whose packets are kept in the pending queue
+)
+
+type testGame struct {
+	dcp  *dispatcherClientProxy
+	conn *proto.GoWorldConnection // the game side of the connection
+}
+
+// newTestDispatcherService returns the dispatcher service with the connected caller game and disconnected games
+func newTestDispatcherService(failoverTimeout time.Duration) (*DispatcherService, *testGame) {
+	ds := &DispatcherService{
+		config:              &config.DispatcherConfig{ServiceFailoverTimeout: failoverTimeout},
+		games:               map[uint16]*gameDispatchInfo{},
+		entityDispatchInfos: map[common.EntityID]*entityDispatchInfo{},
+		srvdisRegisterMap:   map[string]string{},
+		supervisedServices:  map[common.EntityID]*supervisedService{},
+		accountSessions:     accountsession.NewTable(),
+	}
+	dispatcherService = ds
+
+	c1, c2 := net.Pipe()
+	caller := &testGame{
+		dcp: &dispatcherClientProxy{
+			GoWorldConnection: proto.NewGoWorldConnection(netutil.NewBufferedConnection(netutil.NetConnection{Conn: c1}), false, ""),
+			owner:             ds,
+			gameid:            testCallerGameID,
+		},
+		conn: proto.NewGoWorldConnection(netutil.NewBufferedConnection(netutil.NetConnection{Conn: c2}), false, ""),
+	}
+	ds.games[testCallerGameID] = &gameDispatchInfo{gameid: testCallerGameID, clientProxy: caller.dcp}
+	ds.games[testServiceGameID] = &gameDispatchInfo{gameid: testServiceGameID}
+	ds.games[testBackupGameID] = &gameDispatchInfo{gameid: testBackupGameID}
+	return ds, caller
+}
+
+// recvFailedCalls closes the connection of the game, and returns methods of failed service calls received by the game
+func (g *testGame) recvFailedCalls() []string {
+	go func() {
+		g.dcp.Flush("test")
+		g.dcp.Close()
+	}()
+
+	var methods []string
+	for {
+		var msgtype proto.MsgType
+		pkt, err := g.conn.Recv(&msgtype)
+		if err != nil {
+			return methods
+		}
+		if msgtype == proto.MT_NOTIFY_SERVICE_CALL_FAILED {
+			pkt.ReadVarStr() // shard name
+			pkt.ReadEntityID()
+			methods = append(methods, pkt.ReadVarStr())
+		}
+		pkt.Release()
+	}
+}
+
+func superviseService(ds *DispatcherService, gameid uint16, eid common.EntityID, shardName string) {
+	pkt := netutil.NewPacket()
+	pkt.AppendEntityID(eid)
+	pkt.AppendVarStr(shardName)
+	ds.handleSuperviseServiceEntity(&dispatcherClientProxy{gameid: gameid}, pkt)
+	pkt.Release()
+	ds.setEntityDispatcherInfoForWrite(eid).gameid = gameid
+}
+
+// callService calls the service from the caller game, and returns the packet of the call
+func callService(ds *DispatcherService, caller *testGame, eid common.EntityID, method string) *netutil.Packet {
+	pkt := netutil.NewPacket()
+	pkt.AppendUint16(proto.MT_CALL_ENTITY_METHOD)
+	pkt.AppendEntityID(eid)
+	pkt.AppendVarStr(method)
+	pkt.ReadUint16() // msgtype is read before handling
+	ds.handleCallEntityMethod(caller.dcp, pkt)
+	pkt.Release()
+	return pkt
+}
+
+func TestServiceFailover(t *testing.T) {
+	ds, caller := newTestDispatcherService(time.Minute)
+	eid := common.GenEntityID()
+	superviseService(ds, testServiceGameID, eid, "TestService#0")
+	ds.srvdisRegisterMap["/service/TestService#0"] = "game2"
+
+	ds.handleGameDown(ds.games[testServiceGameID])
+	if !ds.supervisedServices[eid].isFailingOver() || ds.entityDispatchInfos[eid] != nil {
+		t.Fatalf("service of the game down should be failing over")
+	}
+	if _, ok := ds.srvdisRegisterMap["/service/TestService#0"]; ok {
+		t.Errorf("services registered by the game down should be unregistered")
+	}
+
+	pkt1, pkt2 := callService(ds, caller, eid, "Hello"), callService(ds, caller, eid, "World")
+	if n := len(ds.supervisedServices[eid].pendingCalls); n != 2 {
+		t.Fatalf("calls to the service failing over should be kept, but %d are kept", n)
+	}
+
+	backup := ds.games[testBackupGameID]
+	backup.clearPendingPackets() // clear broadcasts of the game down
+	ds.handleNotifyCreateEntity(&dispatcherClientProxy{gameid: testBackupGameID}, nil, eid)
+	if len(backup.pendingPacketQueue) != 2 || backup.pendingPacketQueue[0] != pkt1 || backup.pendingPacketQueue[1] != pkt2 {
+		t.Fatalf("calls should be replayed in order to the game re-creating the service, but got %v", backup.pendingPacketQueue)
+	}
+	if ss := ds.supervisedServices[eid]; ss.isFailingOver() || ss.gameid != testBackupGameID || len(ss.pendingCalls) != 0 {
+		t.Errorf("failover should be finished, but got %+v", ss)
+	}
+
+	callService(ds, caller, eid, "Again")
+	if len(backup.pendingPacketQueue) != 3 {
+		t.Errorf("calls should be dispatched to the re-created service")
+	}
+	if methods := caller.recvFailedCalls(); len(methods) != 0 {
+		t.Errorf("no call should fail, but got %v", methods)
+	}
+}
+
+func TestServiceFailoverTimeout(t *testing.T) {
+	ds, caller := newTestDispatcherService(time.Minute)
+	eid := common.GenEntityID()
+	superviseService(ds, testServiceGameID, eid, "TestService#0")
+	ds.handleGameDown(ds.games[testServiceGameID])
+	callService(ds, caller, eid, "Hello")
+
+	ds.checkServiceFailovers()
+	if len(ds.supervisedServices[eid].pendingCalls) != 1 {
+		t.Fatalf("calls should be kept before the failover timeout")
+	}
+
+	ds.supervisedServices[eid].failoverDeadline = time.Now().Add(-time.Second)
+	ds.checkServiceFailovers()
+	if ds.supervisedServices[eid] != nil {
+		t.Errorf("service should not be supervised after the failover timeout")
+	}
+	if methods := caller.recvFailedCalls(); len(methods) != 1 || methods[0] != "Hello" {
+		t.Errorf("callers should be notified of failed calls, but got %v", methods)
+	}
+}
+
+func TestServiceRecreatedAsOtherEntity(t *testing.T) {
+	ds, caller := newTestDispatcherService(time.Minute)
+	lostEid, otherEid := common.GenEntityID(), common.GenEntityID()
+	superviseService(ds, testServiceGameID, lostEid, "TestService#0")
+	superviseService(ds, testServiceGameID, common.GenEntityID(), "OtherService#0")
+	ds.handleGameDown(ds.games[testServiceGameID])
+	callService(ds, caller, lostEid, "Hello")
+
+	// the service is re-created as a new entity if it is not persistent, so calls to the lost entity fail at once
+	superviseService(ds, testBackupGameID, otherEid, "TestService#0")
+	if ds.supervisedServices[lostEid] != nil || ds.supervisedServices[otherEid].gameid != testBackupGameID {
+		t.Fatalf("the lost service entity should be replaced by the new one")
+	}
+	if len(ds.supervisedServices) != 2 {
+		t.Errorf("other services should be still failing over")
+	}
+	if methods := caller.recvFailedCalls(); len(methods) != 1 || methods[0] != "Hello" {
+		t.Errorf("calls to the lost entity should fail, but got %v", methods)
+	}
+}
+
+func TestServiceFailoverLimits(t *testing.T) {
+	ds, caller := newTestDispatcherService(0)
+	eid := common.GenEntityID()
+	superviseService(ds, testServiceGameID, eid, "TestService#0")
+	ds.handleGameDown(ds.games[testServiceGameID])
+	if ds.supervisedServices[eid] != nil {
+		t.Fatalf("services should not fail over if service_failover_timeout is 0")
+	}
+	callService(ds, caller, eid, "Hello") // dropped since the entity is not found
+
+	ds, caller = newTestDispatcherService(time.Minute)
+	superviseService(ds, testServiceGameID, eid, "TestService#0")
+	ds.handleGameDown(ds.games[testServiceGameID])
+	for i := 0; i <= consts.ENTITY_PENDING_PACKET_QUEUE_MAX_LEN; i++ {
+		callService(ds, caller, eid, "Hello")
+	}
+	if n := len(ds.supervisedServices[eid].pendingCalls); n != consts.ENTITY_PENDING_PACKET_QUEUE_MAX_LEN {
+		t.Errorf("at most %d calls should be kept, but %d are kept", consts.ENTITY_PENDING_PACKET_QUEUE_MAX_LEN, n)
+	}
+	if methods := caller.recvFailedCalls(); len(methods) != 1 {
+		t.Errorf("calls over the limit should fail, but %d failed", len(methods))
+	}
+}
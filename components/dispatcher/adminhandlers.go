@@ -0,0 +1,117 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"syscall"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+const (
+	_ADMIN_REQUEST_TIMEOUT = time.Second * 5
+)
+
+type dispatcherGameStatus struct {
+	GameID        uint16  `json:"gameid"`
+	Connected     bool    `json:"connected"`
+	Blocked       bool    `json:"blocked"`
+	BanBootEntity bool    `json:"ban_boot_entity"`
+	ShuttingDown  bool    `json:"shutting_down"`
+	CPUPercent    float64 `json:"cpu_percent"` // reported by the game for load balancing
+}
+
+type dispatcherStatus struct {
+	DispatcherID      uint16                 `json:"dispid"`
+	DeploymentReady   bool                   `json:"deployment_ready"`
+	Games             []dispatcherGameStatus `json:"games"`
+	Gates             []uint16               `json:"gates"`
+	Entities          int                    `json:"entities"`
+	ServiceEntities   int                    `json:"service_entities"`
+	ServiceFailovers  int                    `json:"service_failovers"`
+	PendingEntityRPCs int                    `json:"pending_entity_rpcs"` // RPCs blocked by loading or migrating entities
+	AccountSessions   int                    `json:"account_sessions"`    // accounts having active sessions
+}
+
+func setupAdminHandlers() {
+	binutil.HandleAdminFunc("/status", handleStatusRequest)
+	binutil.HandleAdminFunc("/terminate", handleTerminateRequest)
+}
+
+// handleStatusRequest responds games, gates and entities of the dispatcher in JSON
+//
+// Usage: /status
+func handleStatusRequest(w http.ResponseWriter, r *http.Request) {
+	statusChan := make(chan *dispatcherStatus, 1)
+	post.Post(func() {
+		statusChan <- dispatcherService.getStatus()
+	})
+
+	select {
+	case status := <-statusChan:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	case <-time.After(_ADMIN_REQUEST_TIMEOUT):
+		http.Error(w, "dispatcher is busy", http.StatusServiceUnavailable)
+	}
+}
+
+// handleTerminateRequest terminates the dispatcher like receiving SIGTERM
+//
+// Usage: /terminate
+func handleTerminateRequest(w http.ResponseWriter, r *http.Request) {
+	select {
+	case sigChan <- syscall.SIGTERM:
+		fmt.Fprintf(w, "dispatcher%d is terminating\n", dispid)
+	default:
+		http.Error(w, fmt.Sprintf("dispatcher%d is busy handling signals", dispid), http.StatusServiceUnavailable)
+	}
+}
+
+// getStatus returns status of the dispatcher, which should be called in the message loop
+func (service *DispatcherService) getStatus() *dispatcherStatus {
+	status := &dispatcherStatus{
+		DispatcherID:    service.dispid,
+		DeploymentReady: service.isDeploymentReady,
+		Games:           []dispatcherGameStatus{},
+		Gates:           []uint16{},
+		Entities:        len(service.entityDispatchInfos),
+		ServiceEntities: len(service.supervisedServices),
+		AccountSessions: service.accountSessions.Len(),
+	}
+
+	for gameid, gdi := range service.games {
+		status.Games = append(status.Games, dispatcherGameStatus{
+			GameID:        gameid,
+			Connected:     gdi.isConnected(),
+			Blocked:       gdi.isBlocked,
+			BanBootEntity: gdi.isBanBootEntity,
+			ShuttingDown:  gdi.isShuttingDown,
+			CPUPercent:    gdi.lbcheapentry.origCPUPercent,
+		})
+	}
+	sort.Slice(status.Games, func(i, j int) bool {
+		return status.Games[i].GameID < status.Games[j].GameID
+	})
+
+	for gateid := range service.gates {
+		status.Gates = append(status.Gates, gateid)
+	}
+	sort.Slice(status.Gates, func(i, j int) bool {
+		return status.Gates[i] < status.Gates[j]
+	})
+
+	for _, ss := range service.supervisedServices {
+		if ss.isFailingOver() {
+			status.ServiceFailovers++
+		}
+	}
+	for _, info := range service.entityDispatchInfos {
+		status.PendingEntityRPCs += len(info.pendingPacketQueue)
+	}
+	return status
+}
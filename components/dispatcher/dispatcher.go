@@ -1,13 +1,12 @@
 package main
 
 import (
+	"fmt"
 	"os"
 	"syscall"
 
 	"flag"
 
-	_ "net/http/pprof"
-
 	"os/signal"
 
 	"runtime/debug"
@@ -32,6 +31,7 @@ var (
 func parseArgs() {
 	flag.IntVar(&dispidArg, "dispid", 0, "set dispatcher ID")
 	flag.StringVar(&configFile, "configfile", "", "set config file path")
+	flag.Var(config.OverrideFlag{}, "config", "override config value in the form of section.key=value, e.g. -config game1.http_addr=:25001, can be repeated")
 	flag.StringVar(&logLevel, "log", "", "set log level, will override log level in config")
 	flag.BoolVar(&runInDaemonMode, "d", false, "run in daemon mode")
 	flag.Parse()
@@ -62,11 +62,18 @@ func main() {
 
 	dispatcherConfig := config.GetDispatcher(dispid)
 
+	binutil.OnReloadLogLevel("dispatcher", logLevel, func() string {
+		return config.GetDispatcher(dispid).LogLevel
+	})
 	if logLevel == "" {
 		logLevel = dispatcherConfig.LogLevel
 	}
-	binutil.SetupGWLog("dispatcherService", logLevel, dispatcherConfig.LogFile, dispatcherConfig.LogStderr)
+	binutil.SetupGWLog("dispatcherService", logLevel, dispatcherConfig.LogFile, dispatcherConfig.LogStderr, dispatcherConfig.LogFormat)
+	gwlog.AddGlobalFields("dispid", dispid)
 	binutil.SetupHTTPServer(dispatcherConfig.HTTPAddr, nil)
+	setupAdminHandlers()
+	binutil.SetupAdminServer(fmt.Sprintf("dispatcher%d", dispid), dispatcherConfig.AdminAddr)
+	binutil.SetupWatchdog(fmt.Sprintf("dispatcher%d", dispid))
 
 	dispatcherService = newDispatcherService(dispid)
 	setupSignals() // call setupSignals to avoid data race on `dispatcherService`
@@ -74,8 +81,10 @@ func main() {
 }
 
 func setupSignals() {
-	signal.Ignore(syscall.Signal(10), syscall.Signal(12), syscall.SIGPIPE, syscall.SIGHUP)
+	signal.Ignore(syscall.Signal(10), syscall.Signal(12), syscall.SIGPIPE)
 	signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+	binutil.HandleReloadConfigSignal(binutil.ReloadConfigSignal)
+	config.StartWatch()
 	go func() {
 		for {
 			sig := <-sigChan
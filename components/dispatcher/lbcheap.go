@@ -13,11 +13,14 @@ type lbcheapentry struct {
 	heapidx        int // index of this entry in the heap
 	CPUPercent     float64
 	origCPUPercent float64
+	shuttingDown   bool // games shutting down are chosen only if all games are shutting down
+	draining       bool // games drained by watchdog are chosen only if all other games are shutting down or draining
 }
 
 func (e *lbcheapentry) update(info proto.GameLBCInfo) {
 	e.origCPUPercent = info.CPUPercent
 	e.CPUPercent = info.CPUPercent
+	e.draining = info.Draining
 }
 
 type lbcheap []*lbcheapentry
@@ -27,6 +30,12 @@ func (h lbcheap) Len() int {
 }
 
 func (h lbcheap) Less(i, j int) bool {
+	if h[i].shuttingDown != h[j].shuttingDown {
+		return !h[i].shuttingDown
+	}
+	if h[i].draining != h[j].draining {
+		return !h[i].draining
+	}
 	return h[i].CPUPercent < h[j].CPUPercent
 }
 
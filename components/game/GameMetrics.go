@@ -0,0 +1,218 @@
+package game
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/storage"
+)
+
+const (
+	_ENTITY_STATS_INTERVAL = time.Second * 5
+)
+
+// _GameMetrics are the Prometheus metrics of game, which are exported at /metrics of the game HTTP server if export_metrics is enabled
+//
+// GC and memory stats are exported by the Go collector of the default Prometheus registry.
+type _GameMetrics struct {
+	entities        *prometheus.GaugeVec
+	timers          prometheus.Gauge
+	tickDuration    prometheus.Histogram
+	frameTime       prometheus.Histogram
+	tickJitter      prometheus.Histogram
+	frameOverruns   prometheus.Counter
+	queueDrains     *prometheus.HistogramVec
+	timerDrain      prometheus.Observer
+	postDrain       prometheus.Observer
+	packetQueueLen  prometheus.GaugeFunc
+	postQueueLen    prometheus.GaugeFunc
+	postQueueAge    prometheus.GaugeFunc
+	recvRPCs        *prometheus.CounterVec
+	recvClientRPCs  prometheus.Counter
+	recvServerRPCs  prometheus.Counter
+	recvServiceReqs prometheus.Counter
+	sentRPCs        []prometheus.Collector
+	saveQueueLen    prometheus.GaugeFunc
+	deferredSyncs   prometheus.CounterFunc
+	handlers        *_HandlerStatsCollector
+}
+
+func newGameMetrics(gameid uint16, packetQueue chan proto.Message) *_GameMetrics {
+	constLabels := prometheus.Labels{"gameid": strconv.Itoa(int(gameid))}
+	gm := &_GameMetrics{
+		entities: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "goworld_game_entities",
+			Help:        "Number of entities by type.",
+			ConstLabels: constLabels,
+		}, []string{"type"}),
+		timers: prometheus.NewGauge(prometheus.GaugeOpts{
+			Name:        "goworld_game_entity_timers",
+			Help:        "Number of entity timers.",
+			ConstLabels: constLabels,
+		}),
+		tickDuration: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "goworld_game_tick_duration_seconds",
+			Help:        "Duration of game ticks, including timers, posted functions and position syncs.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+		frameTime: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "goworld_game_frame_time_seconds",
+			Help:        "Time between the starts of consecutive game ticks, including packets handled between ticks.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.001, 2, 10),
+		}),
+		tickJitter: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "goworld_game_tick_jitter_seconds",
+			Help:        "Deviation of frame time from the tick interval.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}),
+		frameOverruns: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "goworld_game_frame_overruns_total",
+			Help:        "Number of frames exceeding frame_budget_ms.",
+			ConstLabels: constLabels,
+		}),
+		queueDrains: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "goworld_game_queue_drain_duration_seconds",
+			Help:        "Duration of running all due timers and posted functions within post_budget_ms.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.0001, 4, 8),
+		}, []string{"queue"}),
+		packetQueueLen: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "goworld_game_packet_queue_length",
+			Help:        "Number of packets waiting to be handled by the game routine.",
+			ConstLabels: constLabels,
+		}, func() float64 {
+			return float64(len(packetQueue))
+		}),
+		postQueueLen: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "goworld_game_post_queue_length",
+			Help:        "Number of posted functions waiting to run, including functions spilled over by post_budget_ms.",
+			ConstLabels: constLabels,
+		}, func() float64 {
+			return float64(post.GetQueueLen())
+		}),
+		postQueueAge: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "goworld_game_post_queue_age_seconds",
+			Help:        "Waiting time of the oldest posted function.",
+			ConstLabels: constLabels,
+		}, func() float64 {
+			return post.GetQueueAge().Seconds()
+		}),
+		recvRPCs: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "goworld_game_received_rpcs_total",
+			Help:        "Number of RPC calls received from clients, servers and service requests.",
+			ConstLabels: constLabels,
+		}, []string{"source"}),
+		saveQueueLen: prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+			Name:        "goworld_game_save_queue_length",
+			Help:        "Number of storage operations waiting to run.",
+			ConstLabels: constLabels,
+		}, func() float64 {
+			return float64(storage.GetQueueLen())
+		}),
+		deferredSyncs: prometheus.NewCounterFunc(prometheus.CounterOpts{
+			Name:        "goworld_game_deferred_syncs_total",
+			Help:        "Number of position syncs of less relevant entities deferred by client_sync_budget.",
+			ConstLabels: constLabels,
+		}, func() float64 {
+			return float64(entity.GetDeferredSyncs())
+		}),
+	}
+
+	gm.timerDrain = gm.queueDrains.WithLabelValues("timers")
+	gm.postDrain = gm.queueDrains.WithLabelValues("posts")
+	gm.recvClientRPCs = gm.recvRPCs.WithLabelValues("client")
+	gm.recvServerRPCs = gm.recvRPCs.WithLabelValues("server")
+	gm.recvServiceReqs = gm.recvRPCs.WithLabelValues("service_request")
+	gm.sentRPCs = []prometheus.Collector{
+		newSentRPCsCounter("entity", constLabels, func() uint64 {
+			toEntities, _ := entity.GetSentRPCCounts()
+			return toEntities
+		}),
+		newSentRPCsCounter("client", constLabels, func() uint64 {
+			_, toClients := entity.GetSentRPCCounts()
+			return toClients
+		}),
+	}
+
+	gm.handlers = newHandlerStatsCollector(constLabels)
+
+	prometheus.MustRegister(gm.entities, gm.timers, gm.tickDuration, gm.recvRPCs, gm.saveQueueLen, gm.handlers)
+	prometheus.MustRegister(gm.frameTime, gm.tickJitter, gm.frameOverruns, gm.queueDrains, gm.packetQueueLen)
+	prometheus.MustRegister(gm.postQueueLen, gm.postQueueAge, gm.deferredSyncs)
+	prometheus.MustRegister(gm.sentRPCs...)
+
+	// entity stats are collected in the game routine
+	timer.AddTimer(_ENTITY_STATS_INTERVAL, gm.collectEntityStats)
+	return gm
+}
+
+func newSentRPCsCounter(target string, constLabels prometheus.Labels, count func() uint64) prometheus.CounterFunc {
+	labels := prometheus.Labels{"target": target}
+	for k, v := range constLabels {
+		labels[k] = v
+	}
+	return prometheus.NewCounterFunc(prometheus.CounterOpts{
+		Name:        "goworld_game_sent_rpcs_total",
+		Help:        "Number of RPC calls sent to entities on other games and to clients.",
+		ConstLabels: labels,
+	}, func() float64 {
+		return float64(count())
+	})
+}
+
+func (gm *_GameMetrics) collectEntityStats() {
+	stats := entity.GetEntityStats()
+	for etype, count := range stats.EntityCounts {
+		gm.entities.WithLabelValues(etype).Set(float64(count))
+	}
+	gm.timers.Set(float64(stats.TimerCount))
+}
+
+// _HandlerStatsCollector exports timing stats of entity methods, timers and posted functions collected by opmon
+type _HandlerStatsCollector struct {
+	calls       *prometheus.Desc
+	duration    *prometheus.Desc
+	maxDuration *prometheus.Desc
+	exceeded    *prometheus.Desc
+}
+
+func newHandlerStatsCollector(constLabels prometheus.Labels) *_HandlerStatsCollector {
+	labels := []string{"kind", "name"}
+	return &_HandlerStatsCollector{
+		calls: prometheus.NewDesc("goworld_game_handler_calls_total",
+			"Number of calls of entity methods, timers and posted functions.", labels, constLabels),
+		duration: prometheus.NewDesc("goworld_game_handler_duration_seconds_total",
+			"Total duration of entity methods, timers and posted functions.", labels, constLabels),
+		maxDuration: prometheus.NewDesc("goworld_game_handler_max_duration_seconds",
+			"Max duration of entity methods, timers and posted functions.", labels, constLabels),
+		exceeded: prometheus.NewDesc("goworld_game_handler_deadline_exceeded_total",
+			"Number of calls of entity methods, timers and posted functions exceeding handler_deadline_ms.", labels, constLabels),
+	}
+}
+
+// Describe implements prometheus.Collector
+func (c *_HandlerStatsCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.calls
+	ch <- c.duration
+	ch <- c.maxDuration
+	ch <- c.exceeded
+}
+
+// Collect implements prometheus.Collector
+func (c *_HandlerStatsCollector) Collect(ch chan<- prometheus.Metric) {
+	for _, stats := range opmon.GetHandlerStats() {
+		ch <- prometheus.MustNewConstMetric(c.calls, prometheus.CounterValue, float64(stats.Count), stats.Kind, stats.Name)
+		ch <- prometheus.MustNewConstMetric(c.duration, prometheus.CounterValue, stats.TotalDuration.Seconds(), stats.Kind, stats.Name)
+		ch <- prometheus.MustNewConstMetric(c.maxDuration, prometheus.GaugeValue, stats.MaxDuration.Seconds(), stats.Kind, stats.Name)
+		ch <- prometheus.MustNewConstMetric(c.exceeded, prometheus.CounterValue, float64(stats.DeadlineExceeded), stats.Kind, stats.Name)
+	}
+}
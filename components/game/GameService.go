@@ -16,15 +16,20 @@ import (
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/eventbus"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/gwvar"
 	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/service"
+	"github.com/xiaonanln/goworld/engine/simulation"
 	"github.com/xiaonanln/goworld/engine/srvdis"
+	"github.com/xiaonanln/goworld/engine/timingwheel"
+	"github.com/xiaonanln/goworld/engine/watchdog"
 )
 
 const (
@@ -49,11 +54,15 @@ type GameService struct {
 	ticker                         <-chan time.Time
 	onlineGames                    common.Uint16Set
 	isDeploymentReady              bool
+	metrics                        *_GameMetrics // nil if export_metrics is disabled
+	frameMonitor                   *_FrameMonitor
+	shuttingDown                   bool      // game is shutting down, new entities are not accepted
+	terminateDeadline              time.Time // deadline of waiting for in-flight migrations before terminating
 }
 
-func newGameService(gameid uint16) *GameService {
+func newGameService(gameid uint16, exportMetrics bool, frameBudget time.Duration) *GameService {
 	//cfg := config.GetGame(gameid)
-	return &GameService{
+	gs := &GameService{
 		id: gameid,
 		//registeredServices: map[string]common.EntityIDSet{},
 		packetQueue: make(chan proto.Message, consts.GAME_SERVICE_PACKET_QUEUE_SIZE),
@@ -65,6 +74,11 @@ func newGameService(gameid uint16) *GameService {
 		//collectEntitySyncInfosRequest: make(chan struct{}),
 		//collectEntitySycnInfosReply:   make(chan interface{}),
 	}
+	if exportMetrics {
+		gs.metrics = newGameMetrics(gameid, gs.packetQueue)
+	}
+	gs.frameMonitor = newFrameMonitor(frameBudget, gs.metrics)
+	return gs
 }
 
 func (gs *GameService) run() {
@@ -74,6 +88,7 @@ func (gs *GameService) run() {
 }
 
 func (gs *GameService) serveRoutine() {
+	opmon.SetMainRoutine()
 	cfg := config.GetGame(gameid)
 	gs.config = cfg
 	gs.positionSyncInterval = time.Millisecond * time.Duration(cfg.PositionSyncIntervalMS)
@@ -98,12 +113,36 @@ func (gs *GameService) serveRoutine() {
 				method := pkt.ReadVarStr()
 				args := pkt.ReadArgs()
 				clientid := pkt.ReadClientID()
+				if gs.metrics != nil {
+					gs.metrics.recvClientRPCs.Inc()
+				}
 				gs.HandleCallEntityMethod(eid, method, args, clientid)
 			case proto.MT_CALL_ENTITY_METHOD:
 				eid := pkt.ReadEntityID()
 				method := pkt.ReadVarStr()
 				args := pkt.ReadArgs()
+				if gs.metrics != nil {
+					gs.metrics.recvServerRPCs.Inc()
+				}
 				gs.HandleCallEntityMethod(eid, method, args, "")
+			case proto.MT_CALL_SERVICE_REQUEST:
+				eid := pkt.ReadEntityID()
+				method := pkt.ReadVarStr()
+				callerGameID := pkt.ReadUint16()
+				requestID := pkt.ReadUint32()
+				args := pkt.ReadArgs()
+				if gs.metrics != nil {
+					gs.metrics.recvServiceReqs.Inc()
+				}
+				service.OnServiceRequest(eid, method, callerGameID, requestID, args)
+			case proto.MT_SERVICE_RESPONSE:
+				_ = pkt.ReadUint16() // caller gameid
+				requestID := pkt.ReadUint32()
+				code := pkt.ReadOneByte()
+				errmsg := pkt.ReadVarStr()
+				var result interface{}
+				pkt.ReadData(&result)
+				service.OnServiceResponse(requestID, code, errmsg, result)
 			case proto.MT_QUERY_SPACE_GAMEID_FOR_MIGRATE_ACK:
 				gs.HandleQuerySpaceGameIDForMigrateAck(pkt)
 			case proto.MT_MIGRATE_REQUEST_ACK:
@@ -113,24 +152,50 @@ func (gs *GameService) serveRoutine() {
 			case proto.MT_NOTIFY_CLIENT_CONNECTED:
 				clientid := pkt.ReadClientID()
 				eid := pkt.ReadEntityID()
+				sessionToken := pkt.ReadVarStr()
+				geoTag := pkt.ReadVarStr()
+				authID := pkt.ReadVarStr()
+				world := pkt.ReadVarStr()
 				gid := pkt.ReadUint16()
-				gs.HandleNotifyClientConnected(clientid, eid, gid)
+				gs.HandleNotifyClientConnected(clientid, eid, sessionToken, geoTag, authID, world, gid)
 			case proto.MT_NOTIFY_CLIENT_DISCONNECTED:
 				eid := pkt.ReadEntityID()
 				clientid := pkt.ReadClientID()
 				gs.HandleNotifyClientDisconnected(eid, clientid)
+			case proto.MT_NOTIFY_CLIENT_FLOOD:
+				eid := pkt.ReadEntityID()
+				clientid := pkt.ReadClientID()
+				reason := pkt.ReadVarStr()
+				gs.HandleNotifyClientFlood(eid, clientid, reason)
+			case proto.MT_NOTIFY_CLIENT_LATENCY:
+				eid := pkt.ReadEntityID()
+				clientid := pkt.ReadClientID()
+				latency := time.Duration(pkt.ReadUint32()) * time.Microsecond
+				gs.HandleNotifyClientLatency(eid, clientid, latency)
+			case proto.MT_RESUME_CLIENT_SESSION:
+				eid := pkt.ReadEntityID()
+				sessionToken := pkt.ReadVarStr()
+				clientid := pkt.ReadClientID()
+				newSessionToken := pkt.ReadVarStr()
+				bootEid := pkt.ReadEntityID()
+				geoTag := pkt.ReadVarStr()
+				authID := pkt.ReadVarStr()
+				gid := pkt.ReadUint16()
+				gs.HandleResumeClientSession(eid, sessionToken, clientid, newSessionToken, bootEid, geoTag, authID, gid)
 			case proto.MT_LOAD_ENTITY_SOMEWHERE:
 				_ = pkt.ReadUint16()
 				eid := pkt.ReadEntityID()
 				typeName := pkt.ReadVarStr()
-				gs.HandleLoadEntitySomewhere(typeName, eid)
+				world := pkt.ReadVarStr()
+				gs.HandleLoadEntitySomewhere(typeName, eid, world)
 			case proto.MT_CREATE_ENTITY_SOMEWHERE:
 				_ = pkt.ReadUint16() // gameid
 				entityid := pkt.ReadEntityID()
 				typeName := pkt.ReadVarStr()
 				var data map[string]interface{}
 				pkt.ReadData(&data)
-				gs.HandleCreateEntitySomewhere(entityid, typeName, data)
+				world := pkt.ReadVarStr()
+				gs.HandleCreateEntitySomewhere(entityid, typeName, world, data)
 			case proto.MT_CALL_NIL_SPACES:
 				_ = pkt.ReadUint16() // ignore except gameid
 				method := pkt.ReadVarStr()
@@ -138,6 +203,36 @@ func (gs *GameService) serveRoutine() {
 				gs.HandleCallNilSpaces(method, args)
 			case proto.MT_SRVDIS_REGISTER:
 				gs.HandleSrvdisRegister(pkt)
+			case proto.MT_SERVICE_SNAPSHOT:
+				shardName := pkt.ReadVarStr()
+				version := pkt.ReadUint64()
+				data := pkt.ReadVarBytes()
+				service.OnServiceSnapshot(shardName, version, data)
+			case proto.MT_EMIT_EVENT:
+				name := pkt.ReadVarStr()
+				id := pkt.ReadVarStr()
+				data := pkt.ReadVarBytes()
+				eventbus.OnEvent(name, id, data)
+			case proto.MT_NOTIFY_SERVICE_CALL_FAILED:
+				shardName := pkt.ReadVarStr()
+				eid := pkt.ReadEntityID()
+				method := pkt.ReadVarStr()
+				service.OnServiceCallFailed(shardName, eid, method)
+			case proto.MT_CLAIM_ACCOUNT_SESSION_ACK:
+				account := pkt.ReadVarStr()
+				eid := pkt.ReadEntityID()
+				ok := pkt.ReadBool()
+				kicked := pkt.ReadEntityIDSet()
+				entity.OnClaimAccountSessionAck(account, eid, ok, kicked)
+			case proto.MT_KICK_ACCOUNT_SESSION:
+				eid := pkt.ReadEntityID()
+				account := pkt.ReadVarStr()
+				reason := pkt.ReadVarStr()
+				entity.OnKickAccountSession(eid, account, reason)
+			case proto.MT_QUERY_ONLINE_PLAYER_ACK:
+				requestID := pkt.ReadUint32()
+				data := pkt.ReadVarBytes()
+				entity.OnQueryOnlinePlayerAck(requestID, data)
 			//case proto.MT_UNDECLARE_SERVICE:
 			//	eid := pkt.ReadEntityID()
 			//	serviceName := pkt.ReadVarStr()
@@ -165,36 +260,57 @@ func (gs *GameService) serveRoutine() {
 			pkt.Release()
 		case <-gs.ticker:
 			isTick = true
+			watchdog.Heartbeat()
+			gs.frameMonitor.onTickStart(time.Now())
+			post.StartFrame()
+			if simulation.Enabled() {
+				simulation.Tick()
+			}
 			runState := gs.runState.Load()
 			if runState == rsTerminating {
-				// game is terminating, run the terminating process
-				gs.doTerminate()
+				// game is terminating, run the terminating process after in-flight migrations are completed
+				gs.tryTerminate()
 			} else if runState == rsFreezing {
 				//game is freezing, run freeze process
 				gs.doFreeze()
 			}
 
-			timer.Tick()
+			if gs.metrics != nil {
+				timerStartTime := time.Now()
+				timer.Tick()
+				timingwheel.Tick()
+				gs.metrics.timerDrain.Observe(time.Since(timerStartTime).Seconds())
+			} else {
+				timer.Tick()
+				timingwheel.Tick()
+			}
 
 			//case <-gs.collectEntitySyncInfosRequest: //
 			//	gs.collectEntitySycnInfosReply <- 1
 		}
 
 		// after handling packets or firing timers, check the posted functions
-		post.Tick()
+		if gs.metrics != nil {
+			postStartTime := time.Now()
+			post.Tick()
+			gs.metrics.postDrain.Observe(time.Since(postStartTime).Seconds())
+		} else {
+			post.Tick()
+		}
 		if isTick {
 			now := time.Now()
 			if !gs.nextCollectEntitySyncInfosTime.After(now) {
 				gs.nextCollectEntitySyncInfosTime = now.Add(gs.positionSyncInterval)
 				entity.CollectEntitySyncInfos()
 			}
+			gs.frameMonitor.onTickEnd(time.Now())
 		}
 	}
 }
 
 func (gs *GameService) waitPostsComplete() {
 	gwlog.Infof("waiting for posts to complete ...")
-	post.Tick() // just tick is Ok, tick will consume all posts
+	post.Flush() // run all posts regardless of the post budget
 }
 
 func (gs *GameService) doTerminate() {
@@ -210,6 +326,12 @@ func (gs *GameService) doTerminate() {
 	// destroy all entities
 	gwlog.Infof("Destroying all entities ...")
 	entity.OnGameTerminating()
+	// saves and KVDB writes of destroyed entities (e.g. in OnDestroy) are flushed before storage and KVDB are closed
+	gwlog.Infof("Waiting for KVDB writes of destroyed entities to complete ...")
+	gs.waitPostsComplete()
+	for async.WaitClear() {
+		gs.waitPostsComplete()
+	}
 	gwlog.Infof("All entities saved & destroyed, game service terminated.")
 	gs.runState.Store(rsTerminated)
 
@@ -253,6 +375,15 @@ func (gs *GameService) doFreeze() {
 		}
 		gwlog.Infof("write freeze data to file takes %s", time.Now().Sub(st))
 
+		if freezeToKVDB.Load() {
+			// ship the freeze data by KVDB, so that the game can be restored on another host
+			st = time.Now()
+			if err := saveFreezeDataToKVDB(gameid, freezeData); err != nil {
+				return err
+			}
+			gwlog.Infof("store freeze data to KVDB takes %s", time.Now().Sub(st))
+		}
+
 		return nil
 	}
 
@@ -275,18 +406,18 @@ func (gs *GameService) String() string {
 	return fmt.Sprintf("GameService<%d>", gs.id)
 }
 
-func (gs *GameService) HandleCreateEntitySomewhere(entityid common.EntityID, typeName string, data map[string]interface{}) {
+func (gs *GameService) HandleCreateEntitySomewhere(entityid common.EntityID, typeName string, world string, data map[string]interface{}) {
 	if consts.DEBUG_PACKETS {
-		gwlog.Debugf("%s.handleCreateEntityAnywhere: %s, typeName=%s, data=%v", gs, entityid, typeName, data)
+		gwlog.Debugf("%s.handleCreateEntityAnywhere: %s, typeName=%s, world=%s, data=%v", gs, entityid, typeName, world, data)
 	}
-	entity.OnCreateEntitySomewhere(entityid, typeName, data)
+	entity.OnCreateEntitySomewhere(entityid, typeName, world, data)
 }
 
-func (gs *GameService) HandleLoadEntitySomewhere(typeName string, entityID common.EntityID) {
+func (gs *GameService) HandleLoadEntitySomewhere(typeName string, entityID common.EntityID, world string) {
 	if consts.DEBUG_PACKETS {
-		gwlog.Debugf("%s.handleLoadEntityAnywhere: typeName=%s, entityID=%s", gs, typeName, entityID)
+		gwlog.Debugf("%s.handleLoadEntityAnywhere: typeName=%s, entityID=%s, world=%s", gs, typeName, entityID, world)
 	}
-	entity.OnLoadEntitySomewhere(typeName, entityID)
+	entity.OnLoadEntitySomewhere(typeName, entityID, world)
 }
 
 func (gs *GameService) HandleSrvdisRegister(pkt *netutil.Packet) {
@@ -327,6 +458,22 @@ func (gs *GameService) handleNotifyGameConnected(pkt *netutil.Packet) {
 	gwlog.Infof("%s notify game connected: %d online games currently", gs, len(gs.onlineGames))
 }
 
+// drain hands off services on this game to another online game to reduce the load, which is the drain action of watchdog
+func (gs *GameService) drain() {
+	for targetGame := range gs.onlineGames {
+		if targetGame == gameid {
+			continue
+		}
+
+		gwlog.Warnf("%s: draining services to game%d ...", gs, targetGame)
+		if err := service.Handoff(targetGame); err != nil {
+			gwlog.Errorf("%s: drain services to game%d failed: %s", gs, targetGame, err)
+		}
+		return
+	}
+	gwlog.Warnf("%s: no other online game to drain services to", gs)
+}
+
 func (gs *GameService) handleNotifyGameDisconnected(pkt *netutil.Packet) {
 	gameid := pkt.ReadUint16()
 
@@ -373,6 +520,13 @@ func (gs *GameService) handleSetGameIDAck(pkt *netutil.Packet) {
 	for srvid, srvinfo := range srvdisMap {
 		srvdis.WatchSrvdisRegister(srvid, srvinfo)
 	}
+	service.RepublishServiceSnapshots(dispid)
+	entity.RefreshAccountSessions(dispid)
+	eventbus.OnDispatcherReconnected(dispid)
+	if gs.shuttingDown {
+		// the dispatcher forgets the game is shutting down when the game reconnects
+		dispatchercluster.SelectByDispatcherID(dispid).SendNotifyGameShuttingDown()
+	}
 
 	gwlog.Infof("%s: set game ID ack received, deployment ready: %v, %d online games, reject entities: %d, srvdis map: %+v",
 		gs, isDeploymentReady, len(gs.onlineGames), rejectEntitiesNum, srvdisMap)
@@ -393,6 +547,7 @@ func (gs *GameService) onDeploymentReady() {
 	gwlog.Infof("DEPLOYMENT IS READY!")
 	entity.OnGameReady()
 	service.OnDeploymentReady()
+	eventbus.OnDeploymentReady()
 }
 
 func (gs *GameService) HandleSyncPositionYawFromClient(pkt *netutil.Packet) {
@@ -416,14 +571,17 @@ func (gs *GameService) HandleCallEntityMethod(entityID common.EntityID, method s
 	entity.OnCall(entityID, method, args, clientid)
 }
 
-func (gs *GameService) HandleNotifyClientConnected(clientid common.ClientID, bootEid common.EntityID, gateid uint16) {
+func (gs *GameService) HandleNotifyClientConnected(clientid common.ClientID, bootEid common.EntityID, sessionToken string, geoTag string, authID string, world string, gateid uint16) {
 	client := entity.MakeGameClient(clientid, gateid)
+	client.SetSessionToken(sessionToken)
+	client.SetGeoTag(geoTag)
+	client.SetAuthID(authID)
 	if consts.DEBUG_PACKETS {
 		gwlog.Debugf("%s.handleNotifyClientConnected: %s", gs, client)
 	}
 
-	// create a boot entity for the new client and set the client as the OWN CLIENT of the entity
-	e := entity.CreateEntityLocallyWithID(gs.config.BootEntity, nil, bootEid)
+	// create a boot entity in the world chosen by the client and set the client as the OWN CLIENT of the entity
+	e := entity.CreateEntityLocallyInWorldWithID(gs.config.BootEntity, world, nil, bootEid)
 	e.SetClient(client)
 }
 
@@ -444,6 +602,29 @@ func (gs *GameService) HandleNotifyClientDisconnected(ownerID common.EntityID, c
 	entity.OnClientDisconnected(ownerID, clientid)
 }
 
+func (gs *GameService) HandleNotifyClientFlood(ownerID common.EntityID, clientid common.ClientID, reason string) {
+	gwlog.Warnf("%s.HandleNotifyClientFlood: %s.%s: %s", gs, ownerID, clientid, reason)
+	entity.OnClientFlood(ownerID, clientid, reason)
+}
+
+func (gs *GameService) HandleNotifyClientLatency(ownerID common.EntityID, clientid common.ClientID, latency time.Duration) {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s.HandleNotifyClientLatency: %s.%s: %s", gs, ownerID, clientid, latency)
+	}
+	entity.OnClientLatency(ownerID, clientid, latency)
+}
+
+func (gs *GameService) HandleResumeClientSession(ownerID common.EntityID, sessionToken string, clientid common.ClientID, newSessionToken string, bootEid common.EntityID, geoTag string, authID string, gateid uint16) {
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s.HandleResumeClientSession: %s.%s, boot entity %s", gs, ownerID, clientid, bootEid)
+	}
+	client := entity.MakeGameClient(clientid, gateid)
+	client.SetSessionToken(newSessionToken)
+	client.SetGeoTag(geoTag)
+	client.SetAuthID(authID)
+	entity.OnResumeClientSession(ownerID, sessionToken, client, bootEid)
+}
+
 func (gs *GameService) HandleQuerySpaceGameIDForMigrateAck(pkt *netutil.Packet) {
 	spaceid := pkt.ReadEntityID()
 	entityid := pkt.ReadEntityID()
@@ -477,7 +658,7 @@ func (gs *GameService) terminate() {
 func (gs *GameService) startFreeze() {
 	dispatcherNum := len(config.GetDispatcherIDs())
 	gs.dispatcherStartFreezeAcks = make([]bool, dispatcherNum)
-	dispatchercluster.SendStartFreezeGame()
+	dispatchercluster.SendStartFreezeGame(freezeToKVDB.Load())
 }
 
 // GetOnlineGames returns all online game IDs
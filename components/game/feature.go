@@ -0,0 +1,60 @@
+package game
+
+import (
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+var (
+	featureChangedCallbacks = map[string][]func(enabled bool){}
+	featureStates           map[string]bool // states of feature flags on this game when the config is last read
+)
+
+// FeatureEnabled returns if the feature flag in [features] config is enabled on this game
+func FeatureEnabled(name string) bool {
+	return config.FeatureEnabled(name, gameid)
+}
+
+// OnFeatureChanged registers the callback which is called in the game routine when the feature flag is enabled or
+// disabled on this game by hot reloading the config
+func OnFeatureChanged(name string, cb func(enabled bool)) {
+	name = strings.ToLower(name) // feature names are case insensitive as config keys
+	featureChangedCallbacks[name] = append(featureChangedCallbacks[name], cb)
+}
+
+func setupFeatures() {
+	featureStates = getFeatureStates()
+	config.OnReload("features", func() {
+		post.Post(reloadFeatures)
+	})
+}
+
+func getFeatureStates() map[string]bool {
+	states := map[string]bool{}
+	for _, name := range config.GetFeatureNames() {
+		states[name] = FeatureEnabled(name)
+	}
+	for name := range featureChangedCallbacks {
+		states[name] = FeatureEnabled(name)
+	}
+	return states
+}
+
+// reloadFeatures calls callbacks of feature flags changed on this game in the game routine
+func reloadFeatures() {
+	oldStates := featureStates
+	featureStates = getFeatureStates()
+	for name, enabled := range featureStates {
+		if enabled == oldStates[name] {
+			continue
+		}
+
+		gwlog.Infof("Feature %s is changed: enabled = %v", name, enabled)
+		for _, cb := range featureChangedCallbacks[name] {
+			cb(enabled)
+		}
+	}
+}
@@ -0,0 +1,110 @@
+package game
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+const (
+	_FRAME_OVERRUN_LOG_INTERVAL = time.Second * 10
+)
+
+// FrameOverrunInfo describes the game frame exceeding the frame budget
+type FrameOverrunInfo struct {
+	FrameTime    time.Duration // time between the starts of the last tick and the current tick
+	TickDuration time.Duration // time spent in the last tick running timers, posted functions and position syncs
+	Budget       time.Duration // frame_budget_ms of game config
+	Overruns     int           // number of consecutive overrun frames, including this one
+}
+
+var (
+	frameOverrunCallbacks []func(info FrameOverrunInfo)
+)
+
+// OnFrameOverrun registers the callback which is called in the game routine when a frame exceeds the frame budget
+//
+// Callbacks are called at the start of the tick before timers fire, so that game logic can shed load in the tick,
+// e.g. skip AI ticks or defer saves.
+func OnFrameOverrun(cb func(info FrameOverrunInfo)) {
+	frameOverrunCallbacks = append(frameOverrunCallbacks, cb)
+}
+
+// _FrameMonitor tracks frame times, tick durations and jitters of the game main loop
+type _FrameMonitor struct {
+	budget           time.Duration
+	metrics          *_GameMetrics // nil if export_metrics is disabled
+	lastTickTime     time.Time
+	lastTickDuration time.Duration
+	overruns         int // number of consecutive overrun frames
+	unloggedOverruns int // number of overrun frames not logged yet
+	lastLogTime      time.Time
+}
+
+func newFrameMonitor(budget time.Duration, metrics *_GameMetrics) *_FrameMonitor {
+	return &_FrameMonitor{
+		budget:  budget,
+		metrics: metrics,
+	}
+}
+
+// onTickStart is called at the start of tick, and notifies overrun callbacks if the frame exceeds the budget
+func (fm *_FrameMonitor) onTickStart(now time.Time) {
+	lastTickTime := fm.lastTickTime
+	fm.lastTickTime = now
+	if lastTickTime.IsZero() {
+		return
+	}
+
+	frameTime := now.Sub(lastTickTime)
+	if fm.metrics != nil {
+		jitter := frameTime - consts.GAME_SERVICE_TICK_INTERVAL
+		if jitter < 0 {
+			jitter = -jitter
+		}
+		fm.metrics.frameTime.Observe(frameTime.Seconds())
+		fm.metrics.tickJitter.Observe(jitter.Seconds())
+	}
+
+	if fm.budget <= 0 || frameTime <= fm.budget {
+		fm.overruns = 0
+		entity.SetSyncOverloaded(false)
+		return
+	}
+
+	fm.overruns += 1
+	entity.SetSyncOverloaded(true) // position syncs are limited by overload_client_sync_budget
+	fm.unloggedOverruns += 1
+	if fm.metrics != nil {
+		fm.metrics.frameOverruns.Inc()
+	}
+	if now.Sub(fm.lastLogTime) >= _FRAME_OVERRUN_LOG_INTERVAL {
+		gwlog.Warnf("game%d: frame takes %s > %s (last tick takes %s), %d frames overrun since last report", gameid, frameTime,
+			fm.budget, fm.lastTickDuration, fm.unloggedOverruns)
+		fm.unloggedOverruns = 0
+		fm.lastLogTime = now
+	}
+
+	info := FrameOverrunInfo{
+		FrameTime:    frameTime,
+		TickDuration: fm.lastTickDuration,
+		Budget:       fm.budget,
+		Overruns:     fm.overruns,
+	}
+	for _, cb := range frameOverrunCallbacks {
+		gwutils.RunPanicless(func() {
+			cb(info)
+		})
+	}
+}
+
+// onTickEnd is called when the tick is finished
+func (fm *_FrameMonitor) onTickEnd(now time.Time) {
+	fm.lastTickDuration = now.Sub(fm.lastTickTime)
+	if fm.metrics != nil {
+		fm.metrics.tickDuration.Observe(fm.lastTickDuration.Seconds())
+	}
+}
@@ -0,0 +1,124 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	dto "github.com/prometheus/client_model/go"
+	"github.com/xiaonanln/goworld/engine/consts"
+)
+
+func newTestFrameMetrics() *_GameMetrics {
+	return &_GameMetrics{
+		tickDuration:  prometheus.NewHistogram(prometheus.HistogramOpts{Name: "tick_duration", Buckets: []float64{0.01, 0.1}}),
+		frameTime:     prometheus.NewHistogram(prometheus.HistogramOpts{Name: "frame_time", Buckets: []float64{0.01, 0.1}}),
+		tickJitter:    prometheus.NewHistogram(prometheus.HistogramOpts{Name: "tick_jitter", Buckets: []float64{0.01, 0.1}}),
+		frameOverruns: prometheus.NewCounter(prometheus.CounterOpts{Name: "frame_overruns"}),
+	}
+}
+
+func readHistogram(t *testing.T, h prometheus.Histogram) *dto.Histogram {
+	var m dto.Metric
+	if err := h.Write(&m); err != nil {
+		t.Fatalf("write histogram failed: %s", err)
+	}
+	return m.GetHistogram()
+}
+
+// recordFrameOverruns records notified overruns until restore is called
+func recordFrameOverruns() (infos *[]FrameOverrunInfo, restore func()) {
+	callbacks := frameOverrunCallbacks
+	infos = &[]FrameOverrunInfo{}
+	OnFrameOverrun(func(info FrameOverrunInfo) {
+		*infos = append(*infos, info)
+	})
+	return infos, func() {
+		frameOverrunCallbacks = callbacks
+	}
+}
+
+func TestFrameMonitorThreshold(t *testing.T) {
+	infos, restore := recordFrameOverruns()
+	defer restore()
+	OnFrameOverrun(func(info FrameOverrunInfo) {
+		panic("callbacks should be panicless")
+	})
+	fm := newFrameMonitor(time.Millisecond*50, nil)
+	now := time.Now()
+
+	fm.onTickStart(now)
+	fm.onTickEnd(now.Add(time.Millisecond * 20))
+	now = now.Add(time.Millisecond * 50)
+	fm.onTickStart(now) // frame time equals to the budget
+	if len(*infos) != 0 {
+		t.Fatalf("frames within budget should not overrun, but got %+v", *infos)
+	}
+
+	fm.onTickEnd(now.Add(time.Millisecond * 70))
+	now = now.Add(time.Millisecond * 80)
+	fm.onTickStart(now)
+	fm.onTickEnd(now.Add(time.Millisecond * 10))
+	now = now.Add(time.Millisecond * 60)
+	fm.onTickStart(now)
+	expected := []FrameOverrunInfo{
+		{FrameTime: time.Millisecond * 80, TickDuration: time.Millisecond * 70, Budget: time.Millisecond * 50, Overruns: 1},
+		{FrameTime: time.Millisecond * 60, TickDuration: time.Millisecond * 10, Budget: time.Millisecond * 50, Overruns: 2},
+	}
+	if len(*infos) != len(expected) || (*infos)[0] != expected[0] || (*infos)[1] != expected[1] {
+		t.Fatalf("consecutive overruns should be notified as %+v, but got %+v", expected, *infos)
+	}
+	if fm.unloggedOverruns != 1 || fm.lastLogTime != now.Add(-time.Millisecond*60) {
+		t.Errorf("overruns should be logged at most once per %s, but %d are not logged", _FRAME_OVERRUN_LOG_INTERVAL, fm.unloggedOverruns)
+	}
+
+	now = now.Add(time.Millisecond * 30)
+	fm.onTickStart(now)
+	now = now.Add(time.Millisecond * 60)
+	fm.onTickStart(now)
+	if len(*infos) != 3 || (*infos)[2].Overruns != 1 {
+		t.Errorf("overruns should be reset by frames within budget, but got %+v", *infos)
+	}
+
+	fm = newFrameMonitor(0, nil)
+	fm.onTickStart(now)
+	fm.onTickStart(now.Add(time.Second))
+	if len(*infos) != 3 {
+		t.Errorf("frames should not overrun if the budget is disabled")
+	}
+}
+
+func TestFrameMonitorHistogram(t *testing.T) {
+	_, restore := recordFrameOverruns()
+	defer restore()
+	metrics := newTestFrameMetrics()
+	fm := newFrameMonitor(time.Millisecond*50, metrics)
+	now := time.Now()
+
+	fm.onTickStart(now)
+	if n := readHistogram(t, metrics.frameTime).GetSampleCount(); n != 0 {
+		t.Fatalf("the first tick should not be observed as a frame, but %d are observed", n)
+	}
+	fm.onTickEnd(now.Add(time.Millisecond * 5))
+	fm.onTickStart(now.Add(consts.GAME_SERVICE_TICK_INTERVAL - time.Millisecond*2))
+	fm.onTickEnd(now.Add(consts.GAME_SERVICE_TICK_INTERVAL + time.Millisecond*3))
+	fm.onTickStart(now.Add(consts.GAME_SERVICE_TICK_INTERVAL + time.Millisecond*200))
+
+	frameTime := readHistogram(t, metrics.frameTime)
+	if frameTime.GetSampleCount() != 2 || frameTime.Bucket[1].GetCumulativeCount() != 1 {
+		t.Errorf("frame times should be observed, but got %s", frameTime)
+	}
+	tickDuration := readHistogram(t, metrics.tickDuration)
+	if tickDuration.GetSampleCount() != 2 || tickDuration.Bucket[0].GetCumulativeCount() != 2 {
+		t.Errorf("tick durations should be observed, but got %s", tickDuration)
+	}
+	// the jitter of the first frame is 2ms less than the tick interval, and the second one is about 200ms more
+	jitter := readHistogram(t, metrics.tickJitter)
+	if jitter.GetSampleCount() != 2 || jitter.Bucket[0].GetCumulativeCount() != 1 || jitter.Bucket[1].GetCumulativeCount() != 1 {
+		t.Errorf("jitters should be observed as absolute deviations, but got %s", jitter)
+	}
+	if n := testutil.ToFloat64(metrics.frameOverruns); n != 1 {
+		t.Errorf("1 frame overrun should be counted, but got %v", n)
+	}
+}
@@ -8,9 +8,6 @@ import (
 
 	"os"
 
-	// for go tool pprof
-	_ "net/http/pprof"
-
 	"runtime"
 
 	"os/signal"
@@ -22,20 +19,29 @@ import (
 	"context"
 
 	"github.com/xiaonanln/goworld/components/game/lbc"
+	"github.com/xiaonanln/goworld/engine/async"
 	"github.com/xiaonanln/goworld/engine/binutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/crontab"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster/dispatcherclient"
 	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/eventbus"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwtimer"
+	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xiaonanln/goworld/engine/service"
+	"github.com/xiaonanln/goworld/engine/simulation"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/timingwheel"
+	"github.com/xiaonanln/goworld/engine/watchdog"
 )
 
 var (
@@ -43,6 +49,7 @@ var (
 	configFile      string
 	logLevel        string
 	restore         bool
+	restoreFrom     string
 	runInDaemonMode bool
 	gameService     *GameService
 	signalChan      = make(chan os.Signal, 1)
@@ -53,8 +60,10 @@ func parseArgs() {
 	var gameidArg int
 	flag.IntVar(&gameidArg, "gid", 0, "set gameid")
 	flag.StringVar(&configFile, "configfile", "", "set config file path")
+	flag.Var(config.OverrideFlag{}, "config", "override config value in the form of section.key=value, e.g. -config game1.http_addr=:25001, can be repeated")
 	flag.StringVar(&logLevel, "log", "", "set log level, will override log level in config")
 	flag.BoolVar(&restore, "restore", false, "restore from freezed state")
+	flag.StringVar(&restoreFrom, "restore-from", "file", "restore freezed state from file of this host or kvdb (freezed by /freeze?to=kvdb on any host), kvdb implies -restore")
 	flag.BoolVar(&runInDaemonMode, "d", false, "run in daemon mode")
 	flag.Parse()
 	gameid = uint16(gameidArg)
@@ -80,6 +89,13 @@ func Run() {
 		gwlog.Errorf("gameid %d is not valid, should be positive", gameid)
 		os.Exit(1)
 	}
+	if restoreFrom != "file" && restoreFrom != "kvdb" {
+		gwlog.Errorf("-restore-from should be file or kvdb, but is %s", restoreFrom)
+		os.Exit(1)
+	}
+	if restoreFrom == "kvdb" {
+		restore = true
+	}
 
 	gameConfig := config.GetGame(gameid)
 	if gameConfig == nil {
@@ -91,10 +107,14 @@ func Run() {
 		gwlog.Infof("SET GOMAXPROCS = %d", gameConfig.GoMaxProcs)
 		runtime.GOMAXPROCS(gameConfig.GoMaxProcs)
 	}
+	binutil.OnReloadLogLevel("game", logLevel, func() string {
+		return config.GetGame(gameid).LogLevel
+	})
 	if logLevel == "" {
 		logLevel = gameConfig.LogLevel
 	}
-	binutil.SetupGWLog(fmt.Sprintf("game%d", gameid), logLevel, gameConfig.LogFile, gameConfig.LogStderr)
+	binutil.SetupGWLog(fmt.Sprintf("game%d", gameid), logLevel, gameConfig.LogFile, gameConfig.LogStderr, gameConfig.LogFormat)
+	gwlog.AddGlobalFields("gameid", gameid)
 
 	gwlog.Infof("Initializing storage ...")
 	storage.Initialize()
@@ -104,12 +124,46 @@ func Run() {
 	crontab.Initialize()
 
 	gwlog.Infof("Setup http server ...")
+	setupHTTPHandlers(gameConfig.ExportMetrics)
 	binutil.SetupHTTPServer(gameConfig.HTTPAddr, nil)
+	setupAdminHandlers()
+	binutil.SetupAdminServer(fmt.Sprintf("game%d", gameid), gameConfig.AdminAddr)
+	binutil.SetupWatchdog(fmt.Sprintf("game%d", gameid))
+	watchdog.SetDrainHandler(func(draining bool) {
+		// dispatchers are notified from the watchdog goroutine, since the game routine might be stalled
+		gamelbc.SetDraining(draining)
+		if draining {
+			post.Post(gameService.drain) // services are handed off when the game routine runs again
+		}
+	})
 
 	entity.SetSaveInterval(gameConfig.SaveInterval)
+	entity.SetSessionResumeTimeout(gameConfig.SessionResumeTimeout)
+	entity.SetAccountSessionPolicy(gameConfig.MaxAccountSessions, gameConfig.AccountSessionConflict == "kick_older")
+	entity.SetJitterTimers(gameConfig.JitterEntityTimers)
+	if gameConfig.Deterministic {
+		gwlog.Infof("Running in deterministic mode with seed %d", gameConfig.DeterministicSeed)
+		// games of the same seed use different RNGs, so that they generate different entity IDs
+		simulation.Enable(gameConfig.DeterministicSeed<<16|int64(gameid), consts.GAME_SERVICE_TICK_INTERVAL)
+		timingwheel.SetClock(simulation.Now)
+	}
+	entity.SetTimingWheel(gameConfig.TimingWheel || gameConfig.Deterministic)
+	gwtimer.SetTimingWheel(gameConfig.Deterministic)
+	opmon.SetHandlerBudget(gameConfig.HandlerBudget)
+	opmon.SetHandlerDeadline(gameConfig.HandlerDeadline)
+	post.SetBudget(gameConfig.PostBudget)
+	entity.SetClientSyncBudget(gameConfig.ClientSyncBudget, gameConfig.OverloadClientSyncBudget)
+	setAsyncWorkers(gameConfig.AsyncWorkers)
+	entity.EnableCrashDump(fmt.Sprintf("game%d", gameid), gameConfig.CrashDumpDir, gameConfig.CrashDumpStorage)
+	gwutils.SetPanicHandler(entity.DumpCrash)
 
 	gwlog.Infof("Start game service ...")
-	gameService = newGameService(gameid)
+	gameService = newGameService(gameid, gameConfig.ExportMetrics, gameConfig.FrameBudget)
+	config.OnReload("game", func() {
+		post.Post(reloadGameConfig)
+	})
+	setupFeatures()
+	service.SetWorlds(config.GetDeployment().Worlds) // world services should be registered before restoring entities
 
 	if !restore {
 		gwlog.Infof("Creating nil space ...")
@@ -131,14 +185,44 @@ func Run() {
 	setupSignals()
 
 	service.Setup(gameid)
+	eventbus.Setup(gameid)
+	if gameConfig.GRPCAddr != "" {
+		serveGRPC(gameConfig.GRPCAddr, gameConfig.GRPCToken, gameConfig.GRPCCertFile, gameConfig.GRPCKeyFile)
+	}
 	gwlog.Infof("Game service start running ...")
 	gameService.run()
 }
 
+// reloadGameConfig applies hot-reloadable settings of game config in the game routine
+//
+// Save timers of existing entities keep the old save interval, and existing timers are not jittered. Async worker pools
+// only grow.
+func reloadGameConfig() {
+	gameConfig := config.GetGame(gameid)
+	entity.SetSaveInterval(gameConfig.SaveInterval)
+	entity.SetSessionResumeTimeout(gameConfig.SessionResumeTimeout)
+	entity.SetAccountSessionPolicy(gameConfig.MaxAccountSessions, gameConfig.AccountSessionConflict == "kick_older")
+	entity.SetJitterTimers(gameConfig.JitterEntityTimers)
+	opmon.SetHandlerBudget(gameConfig.HandlerBudget)
+	opmon.SetHandlerDeadline(gameConfig.HandlerDeadline)
+	post.SetBudget(gameConfig.PostBudget)
+	entity.SetClientSyncBudget(gameConfig.ClientSyncBudget, gameConfig.OverloadClientSyncBudget)
+	setAsyncWorkers(gameConfig.AsyncWorkers)
+	gameService.frameMonitor.budget = gameConfig.FrameBudget
+}
+
+func setAsyncWorkers(workers map[string]int) {
+	for group, count := range workers {
+		async.SetWorkerCount(group, count)
+	}
+}
+
 func setupSignals() {
 	gwlog.Infof("Setup signals ...")
-	signal.Ignore(syscall.Signal(12), syscall.SIGPIPE, syscall.Signal(10))
+	signal.Ignore(syscall.Signal(12), syscall.SIGPIPE)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM, binutil.FreezeSignal)
+	binutil.HandleReloadConfigSignal(binutil.GameReloadConfigSignal)
+	config.StartWatch()
 
 	go func() {
 		for {
@@ -212,6 +296,9 @@ func waitEntityStorageFinish() {
 	// wait until entity storage's queue is empty
 	gwlog.Infof("Closing Entity Storage ...")
 	storage.Shutdown()
+	// async KVDB operations are finished when the game is terminated or freezed
+	gwlog.Infof("Closing KVDB ...")
+	kvdb.Shutdown()
 	gwlog.Infof("*** DB OK ***")
 }
 
@@ -0,0 +1,155 @@
+package game
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"path"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwgrpc"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/rbac"
+	"github.com/xiaonanln/goworld/engine/service"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// _EntityServiceServer serves EntityService for trusted external services
+type _EntityServiceServer struct{}
+
+// serveGRPC serves gRPC on addr, using TLS if certFile and keyFile are set
+func serveGRPC(addr string, token string, certFile string, keyFile string) {
+	var tlsConfig *tls.Config
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(path.Join(config.GetConfigDir(), certFile), path.Join(config.GetConfigDir(), keyFile))
+		if err != nil {
+			gwlog.Fatalf("load gRPC certificate failed: %v", err)
+		}
+		tlsConfig = &tls.Config{Certificates: []tls.Certificate{cert}}
+	}
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		gwlog.Fatalf("listen gRPC on %s failed: %v", addr, err)
+	}
+
+	server := gwgrpc.NewServer(token, authorizeGRPC, tlsConfig)
+	gwgrpc.RegisterEntityServiceServer(server, _EntityServiceServer{})
+	gwlog.Infof("Serving gRPC on %s, TLS = %v ...", addr, tlsConfig != nil)
+	go func() {
+		if err := server.Serve(ln); err != nil {
+			gwlog.Errorf("gRPC server quited: %v", err)
+		}
+	}()
+}
+
+// authorizeGRPC authorizes gRPC requests carrying tokens of users in [rbac] config
+func authorizeGRPC(token string, action string) error {
+	user, ok := rbac.Authenticate(token)
+	if !ok {
+		return status.Errorf(codes.Unauthenticated, "invalid token")
+	}
+	if err := rbac.Authorize(user, action, "grpc"); err != nil {
+		return status.Errorf(codes.PermissionDenied, "%s is not allowed to %s", user, action)
+	}
+	return nil
+}
+
+// runInGameRoutine runs f in the game routine and waits for the result
+func runInGameRoutine(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	post.Post(func() {
+		defer func() {
+			if err := recover(); err != nil {
+				done <- status.Errorf(codes.Internal, "%v", err)
+				panic(err)
+			}
+		}()
+		done <- f()
+	})
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return status.Error(codes.DeadlineExceeded, ctx.Err().Error())
+	}
+}
+
+func (s _EntityServiceServer) CallEntity(ctx context.Context, req *gwgrpc.CallEntityRequest) (*gwgrpc.CallEntityReply, error) {
+	eid := common.EntityID(req.EntityID)
+	if len(eid) != common.ENTITYID_LENGTH {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid entity ID: %s", req.EntityID)
+	}
+	args, err := gwgrpc.DecodeArgs(req.ArgsJSON)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	gwlog.Infof("gRPC: call %s.%s%v", eid, req.Method, args)
+	err = runInGameRoutine(ctx, func() error {
+		entity.Call(eid, req.Method, args)
+		return nil
+	})
+	return &gwgrpc.CallEntityReply{}, err
+}
+
+func (s _EntityServiceServer) CallService(ctx context.Context, req *gwgrpc.CallServiceRequest) (*gwgrpc.CallServiceReply, error) {
+	args, err := gwgrpc.DecodeArgs(req.ArgsJSON)
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+
+	gwlog.Infof("gRPC: call service %s.%s%v", req.ServiceName, req.Method, args)
+	err = runInGameRoutine(ctx, func() error {
+		if service.GetServiceEntityID(req.ServiceName).IsNil() {
+			return status.Errorf(codes.NotFound, "service %s not found", req.ServiceName)
+		}
+		service.CallService(req.ServiceName, req.Method, args)
+		return nil
+	})
+	return &gwgrpc.CallServiceReply{}, err
+}
+
+func (s _EntityServiceServer) CreateEntity(ctx context.Context, req *gwgrpc.CreateEntityRequest) (*gwgrpc.CreateEntityReply, error) {
+	if entity.GetEntityTypeDesc(req.TypeName) == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown entity type: %s", req.TypeName)
+	}
+
+	var eid common.EntityID
+	err := runInGameRoutine(ctx, func() error {
+		eid = entity.CreateEntitySomewhere(uint16(req.GameID), req.TypeName)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gwlog.Infof("gRPC: created %s<%s> on game %d", req.TypeName, eid, req.GameID)
+	return &gwgrpc.CreateEntityReply{EntityID: string(eid)}, nil
+}
+
+func (s _EntityServiceServer) LoadEntity(ctx context.Context, req *gwgrpc.LoadEntityRequest) (*gwgrpc.LoadEntityReply, error) {
+	if entity.GetEntityTypeDesc(req.TypeName) == nil {
+		return nil, status.Errorf(codes.InvalidArgument, "unknown entity type: %s", req.TypeName)
+	}
+	eid := common.EntityID(req.EntityID)
+	if len(eid) != common.ENTITYID_LENGTH {
+		return nil, status.Errorf(codes.InvalidArgument, "invalid entity ID: %s", req.EntityID)
+	}
+
+	gwlog.Infof("gRPC: load %s<%s> on game %d", req.TypeName, eid, req.GameID)
+	err := runInGameRoutine(ctx, func() error {
+		if req.GameID == 0 {
+			entity.LoadEntityAnywhere(req.TypeName, eid)
+		} else {
+			entity.LoadEntityOnGame(req.TypeName, eid, uint16(req.GameID))
+		}
+		return nil
+	})
+	return &gwgrpc.LoadEntityReply{}, err
+}
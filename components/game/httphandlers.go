@@ -0,0 +1,410 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/luascript"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/service"
+)
+
+const (
+	_HTTP_REQUEST_TIMEOUT           = time.Second * 5
+	_DEFAULT_ENTITY_PROFILE_SECONDS = 10
+	_MAX_ENTITY_PROFILE_SECONDS     = 300
+	_DEFAULT_ENTITY_PROFILE_TOP     = 50
+)
+
+// setupHTTPHandlers registers handlers of http_addr, which are public: admin actions should be registered by
+// setupAdminHandlers, and /metrics is deliberately public so that Prometheus can scrape it without the admin token
+func setupHTTPHandlers(exportMetrics bool) {
+	if exportMetrics {
+		http.Handle("/metrics", promhttp.Handler())
+	}
+}
+
+func setupAdminHandlers() {
+	binutil.HandleAdminFunc("/services", handleServicesRequest)
+	binutil.HandleAdminFunc("/handoff_services", handleHandoffServicesRequest)
+	binutil.HandleAdminFunc("/entities", handleEntitiesRequest)
+	binutil.HandleAdminFunc("/entity", handleEntityRequest)
+	binutil.HandleAdminFunc("/call_entity", handleCallEntityRequest)
+	binutil.HandleAdminFunc("/entity_profile", handleEntityProfileRequest)
+	binutil.HandleAdminFunc("/gm", handleGMRequest)
+	binutil.HandleAdminFunc("/inspector", handleInspectorRequest)
+	binutil.HandleAdminFunc("/drain", handleDrainRequest)
+	binutil.HandleAdminFunc("/freeze", handleFreezeRequest)
+	binutil.HandleAdminFunc("/terminate", handleTerminateRequest)
+	binutil.HandleAdminFunc("/shutdown", handleShutdownRequest)
+	binutil.HandleAdminFunc("/reload_plugin", handleReloadPluginRequest)
+	binutil.HandleAdminFunc("/reload_scripts", handleReloadScriptsRequest)
+	binutil.HandleAdminFunc("/record_space", handleRecordSpaceRequest)
+	binutil.HandleAdminFunc("/unrecord_space", handleUnrecordSpaceRequest)
+}
+
+// handleServicesRequest responds all service shards with their hosting games, entity IDs and health in JSON
+//
+// Usage: /services
+func handleServicesRequest(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	var services []service.ServiceInfo
+	if err := runInGameRoutine(ctx, func() error {
+		services = service.ListServices()
+		return nil
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(services)
+}
+
+// handleHandoffServicesRequest migrates all service entities on this game to the target game
+//
+// Usage: POST /handoff_services?game=<target gameid>
+func handleHandoffServicesRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method should be called using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	targetGame, err := strconv.Atoi(r.FormValue("game"))
+	if err != nil || targetGame <= 0 {
+		http.Error(w, fmt.Sprintf("invalid game: %#v", r.FormValue("game")), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	if err := runInGameRoutine(ctx, func() error {
+		return service.Handoff(uint16(targetGame))
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "game%d is handing off services to game%d\n", gameid, targetGame)
+}
+
+// entityDump is the dump of entity for debugging
+type entityDump struct {
+	ID         common.EntityID        `json:"id"`
+	TypeName   string                 `json:"type"`
+	Space      common.EntityID        `json:"space"`
+	Position   entity.Vector3         `json:"position"`
+	Yaw        entity.Yaw             `json:"yaw"`
+	Client     string                 `json:"client"`
+	Persistent bool                   `json:"persistent"`
+	Attrs      map[string]interface{} `json:"attrs"`
+
+	UseAOI         bool              `json:"use_aoi"`
+	InterestedIn   []common.EntityID `json:"interested_in"`   // entities in AOI of the entity
+	InterestedBy   []common.EntityID `json:"interested_by"`   // entities having the entity in AOI
+	SpaceEntities  int               `json:"space_entities"`  // number of entities in the space, only for space entities
+	InspectorCalls []string          `json:"inspector_calls"` // methods allowed to be called from the inspector
+}
+
+// handleEntityRequest dumps the entity on this game in JSON
+//
+// Usage: /entity?id=<entity ID>
+func handleEntityRequest(w http.ResponseWriter, r *http.Request) {
+	eid := common.EntityID(r.FormValue("id"))
+	if len(eid) != common.ENTITYID_LENGTH {
+		http.Error(w, fmt.Sprintf("invalid entity ID: %#v", string(eid)), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	var dump *entityDump
+	if err := runInGameRoutine(ctx, func() error {
+		e := entity.GetEntity(eid)
+		if e == nil {
+			return nil
+		}
+
+		dump = &entityDump{
+			ID:             e.ID,
+			TypeName:       e.TypeName,
+			Position:       e.GetPosition(),
+			Yaw:            e.GetYaw(),
+			Persistent:     e.IsPersistent(),
+			Attrs:          e.Attrs.ToMap(),
+			UseAOI:         e.IsUseAOI(),
+			InterestedIn:   getEntityIDs(e.InterestedIn),
+			InterestedBy:   getEntityIDs(e.InterestedBy),
+			InspectorCalls: entity.GetEntityTypeDesc(e.TypeName).GetInspectorCalls(),
+		}
+		if e.Space != nil {
+			dump.Space = e.Space.ID
+		}
+		if client := e.GetClient(); client != nil {
+			dump.Client = client.String()
+		}
+		if e.IsSpaceEntity() {
+			dump.SpaceEntities = e.AsSpace().GetEntityCount()
+		}
+		return nil
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	if dump == nil {
+		http.Error(w, fmt.Sprintf("entity %s not found on game%d", eid, gameid), http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(dump)
+}
+
+// handleEntityProfileRequest profiles CPU time of method calls and bytes synced to clients of entities for the specified
+// seconds, and responds entity types and top entities in JSON
+//
+// Usage: /entity_profile?seconds=<sampling seconds>&top=<number of top entities>&sort=<cpu|bytes>
+func handleEntityProfileRequest(w http.ResponseWriter, r *http.Request) {
+	seconds, err := getIntFormValue(r, "seconds", _DEFAULT_ENTITY_PROFILE_SECONDS)
+	if err != nil || seconds <= 0 || seconds > _MAX_ENTITY_PROFILE_SECONDS {
+		http.Error(w, fmt.Sprintf("invalid seconds: %#v, should be in 1~%d", r.FormValue("seconds"), _MAX_ENTITY_PROFILE_SECONDS), http.StatusBadRequest)
+		return
+	}
+	top, err := getIntFormValue(r, "top", _DEFAULT_ENTITY_PROFILE_TOP)
+	if err != nil || top < 0 {
+		http.Error(w, fmt.Sprintf("invalid top: %#v", r.FormValue("top")), http.StatusBadRequest)
+		return
+	}
+	sortBy := r.FormValue("sort")
+	if sortBy == "" {
+		sortBy = entity.ProfileSortByCPU
+	} else if sortBy != entity.ProfileSortByCPU && sortBy != entity.ProfileSortByBytes {
+		http.Error(w, fmt.Sprintf("invalid sort: %#v", sortBy), http.StatusBadRequest)
+		return
+	}
+
+	result := profileEntities(time.Second*time.Duration(seconds), top, sortBy)
+	select {
+	case res := <-result:
+		if res.err != nil {
+			http.Error(w, res.err.Error(), http.StatusConflict)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(res.report)
+	case <-time.After(time.Second*time.Duration(seconds) + _HTTP_REQUEST_TIMEOUT):
+		http.Error(w, "entity profiling timeout, the game routine is busy", http.StatusServiceUnavailable)
+	case <-r.Context().Done():
+	}
+}
+
+type _EntityProfileResult struct {
+	report *entity.ProfileReport
+	err    error
+}
+
+// profileEntities starts entity profiling in the game routine, and stops it by the timer after the duration, so that
+// profiling is always stopped even if the request is canceled or times out
+func profileEntities(d time.Duration, top int, sortBy string) <-chan _EntityProfileResult {
+	result := make(chan _EntityProfileResult, 1)
+	post.Post(func() {
+		if err := entity.StartProfiling(); err != nil {
+			result <- _EntityProfileResult{err: err}
+			return
+		}
+		timer.AddCallback(d, func() {
+			report, err := entity.StopProfiling(top, sortBy)
+			result <- _EntityProfileResult{report, err}
+		})
+	})
+	return result
+}
+
+func getIntFormValue(r *http.Request, key string, defaultValue int) (int, error) {
+	if r.FormValue(key) == "" {
+		return defaultValue, nil
+	}
+	return strconv.Atoi(r.FormValue(key))
+}
+
+// handleDrainRequest hands off services on this game to another online game, like the drain action of watchdog
+//
+// Usage: /drain
+func handleDrainRequest(w http.ResponseWriter, r *http.Request) {
+	post.Post(gameService.drain)
+	fmt.Fprintf(w, "game%d is draining services\n", gameid)
+}
+
+// handleShutdownRequest shuts down the game gracefully after the grace period, see Shutdown
+//
+// Usage: /shutdown?grace=<seconds>&reason=<reason>
+func handleShutdownRequest(w http.ResponseWriter, r *http.Request) {
+	var grace time.Duration
+	if s := r.FormValue("grace"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			http.Error(w, fmt.Sprintf("invalid grace: %#v", s), http.StatusBadRequest)
+			return
+		}
+		grace = time.Second * time.Duration(seconds)
+	}
+	reason := r.FormValue("reason")
+	if reason == "" {
+		reason = _DEFAULT_SHUTDOWN_REASON
+	}
+
+	post.Post(func() {
+		Shutdown(grace, reason)
+	})
+	fmt.Fprintf(w, "game%d is shutting down in %s\n", gameid, grace)
+}
+
+// handleReloadPluginRequest reloads entity types from the Go plugin on this game, see ReloadPlugin
+//
+// Usage: /reload_plugin?path=<plugin file>
+func handleReloadPluginRequest(w http.ResponseWriter, r *http.Request) {
+	path := r.FormValue("path")
+	if path == "" {
+		http.Error(w, "path is required", http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	if err := runInGameRoutine(ctx, func() error {
+		return ReloadPlugin(path)
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "game%d reloaded plugin %s\n", gameid, path)
+}
+
+// handleReloadScriptsRequest reloads Lua scripts of entity types on this game, see luascript.ReloadScripts
+//
+// Usage: /reload_scripts
+func handleReloadScriptsRequest(w http.ResponseWriter, r *http.Request) {
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	if err := runInGameRoutine(ctx, luascript.ReloadScripts); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "game%d reloaded scripts\n", gameid)
+}
+
+// handleRecordSpaceRequest starts recording the space on this game to the file, see Space.StartRecording
+//
+// Usage: /record_space?space=<space ID>&file=<record file>&attrs=<attr1,attr2>&interval_ms=100
+func handleRecordSpaceRequest(w http.ResponseWriter, r *http.Request) {
+	spaceID := common.EntityID(r.FormValue("space"))
+	file := r.FormValue("file")
+	if spaceID == "" || file == "" {
+		http.Error(w, "space and file are required", http.StatusBadRequest)
+		return
+	}
+	var attrs []string
+	if r.FormValue("attrs") != "" {
+		attrs = strings.Split(r.FormValue("attrs"), ",")
+	}
+	intervalMS, err := getIntFormValue(r, "interval_ms", int(entity.DefaultRecordInterval/time.Millisecond))
+	if err != nil || intervalMS <= 0 {
+		http.Error(w, fmt.Sprintf("invalid interval_ms: %#v", r.FormValue("interval_ms")), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	if err := runInGameRoutine(ctx, func() error {
+		space := entity.GetSpace(spaceID)
+		if space == nil {
+			return errors.Errorf("space %s is not on game%d", spaceID, gameid)
+		}
+		return space.StartRecording(file, attrs, time.Duration(intervalMS)*time.Millisecond)
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "game%d is recording space %s to %s\n", gameid, spaceID, file)
+}
+
+// handleUnrecordSpaceRequest stops recording the space on this game
+//
+// Usage: /unrecord_space?space=<space ID>
+func handleUnrecordSpaceRequest(w http.ResponseWriter, r *http.Request) {
+	spaceID := common.EntityID(r.FormValue("space"))
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	if err := runInGameRoutine(ctx, func() error {
+		space := entity.GetSpace(spaceID)
+		if space == nil {
+			return errors.Errorf("space %s is not on game%d", spaceID, gameid)
+		}
+		return space.StopRecording()
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	fmt.Fprintf(w, "game%d stopped recording space %s\n", gameid, spaceID)
+}
+
+// handleFreezeRequest freezes the game like receiving the freeze signal, the game exits after entities are freezed
+//
+// The freeze data is also stored in KVDB if to=kvdb, so that the game can be restored on another host by
+// -restore-from kvdb, e.g. for hardware maintenance.
+//
+// Usage: /freeze[?to=kvdb]
+func handleFreezeRequest(w http.ResponseWriter, r *http.Request) {
+	to := r.FormValue("to")
+	if to != "" && to != "file" && to != "kvdb" {
+		http.Error(w, fmt.Sprintf("to should be file or kvdb, but is %s", to), http.StatusBadRequest)
+		return
+	}
+	if to == "kvdb" && config.GetKVDB().Type == "" {
+		http.Error(w, "KVDB is not configured", http.StatusBadRequest)
+		return
+	}
+	freezeToKVDB.Store(to == "kvdb")
+	if to == "kvdb" {
+		sendSignal(w, binutil.FreezeSignal, "freezing to KVDB")
+	} else {
+		sendSignal(w, binutil.FreezeSignal, "freezing")
+	}
+}
+
+// handleTerminateRequest terminates the game gracefully like receiving SIGTERM
+//
+// Usage: /terminate
+func handleTerminateRequest(w http.ResponseWriter, r *http.Request) {
+	sendSignal(w, syscall.SIGTERM, "terminating")
+}
+
+func sendSignal(w http.ResponseWriter, sig os.Signal, action string) {
+	select {
+	case signalChan <- sig:
+		fmt.Fprintf(w, "game%d is %s\n", gameid, action)
+	default:
+		http.Error(w, fmt.Sprintf("game%d is busy handling signals", gameid), http.StatusServiceUnavailable)
+	}
+}
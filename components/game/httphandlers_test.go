@@ -0,0 +1,53 @@
+package game
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// waitEntityProfileResult handles posted callbacks and timers like the game routine until the result is received
+func waitEntityProfileResult(t *testing.T, result <-chan _EntityProfileResult) _EntityProfileResult {
+	deadline := time.Now().Add(time.Second * 5)
+	for time.Now().Before(deadline) {
+		post.Tick()
+		timer.Tick()
+		select {
+		case res := <-result:
+			return res
+		default:
+			time.Sleep(time.Millisecond)
+		}
+	}
+	t.Fatalf("entity profiling result is not received")
+	return _EntityProfileResult{}
+}
+
+func TestProfileEntitiesStopped(t *testing.T) {
+	result := profileEntities(time.Millisecond*50, 10, entity.ProfileSortByCPU)
+	post.Tick()
+	if !entity.IsProfiling() {
+		t.Fatalf("entity profiling should be started")
+	}
+
+	// requests are rejected while profiling, and profiling is stopped by the timer even if nobody waits for the result
+	conflict := profileEntities(time.Millisecond*50, 10, entity.ProfileSortByCPU)
+	if res := waitEntityProfileResult(t, conflict); res.err == nil {
+		t.Fatalf("entity profiling should not be started while running")
+	}
+	res := waitEntityProfileResult(t, result)
+	if res.err != nil || res.report == nil {
+		t.Fatalf("entity profiling should be reported, but got %v", res.err)
+	}
+	if entity.IsProfiling() {
+		t.Fatalf("entity profiling should be stopped after the duration")
+	}
+
+	// profiling can be started again
+	if res := waitEntityProfileResult(t, profileEntities(time.Millisecond, 10, entity.ProfileSortByBytes)); res.err != nil {
+		t.Errorf("entity profiling should be started again, but got %v", res.err)
+	}
+}
@@ -0,0 +1,353 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwgrpc"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/rbac"
+	"google.golang.org/grpc/codes"
+	grpcstatus "google.golang.org/grpc/status"
+)
+
+const (
+	_DEFAULT_ENTITY_LIST_LIMIT = 100
+)
+
+// entitySummary is the brief of entity listed in the entity inspector
+type entitySummary struct {
+	ID       common.EntityID `json:"id"`
+	TypeName string          `json:"type"`
+	Space    common.EntityID `json:"space"`
+	Position entity.Vector3  `json:"position"`
+	Client   string          `json:"client"`
+}
+
+type entityListResponse struct {
+	Types    map[string]int  `json:"types"` // number of entities by type
+	Total    int             `json:"total"` // number of entities matching the filter
+	Entities []entitySummary `json:"entities"`
+}
+
+// handleEntitiesRequest lists entities on this game in JSON, entities are sorted by ID
+//
+// Usage: /entities?type=<entity type>&space=<space ID>&limit=<max number of entities>, limit=0 to only count entities
+func handleEntitiesRequest(w http.ResponseWriter, r *http.Request) {
+	typeName := r.FormValue("type")
+	spaceID := common.EntityID(r.FormValue("space"))
+	limit := _DEFAULT_ENTITY_LIST_LIMIT
+	if r.FormValue("limit") != "" {
+		var err error
+		if limit, err = strconv.Atoi(r.FormValue("limit")); err != nil || limit < 0 {
+			http.Error(w, fmt.Sprintf("invalid limit: %#v", r.FormValue("limit")), http.StatusBadRequest)
+			return
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	resp := entityListResponse{
+		Entities: []entitySummary{},
+	}
+	if err := runInGameRoutine(ctx, func() error {
+		resp.Types = entity.GetEntityStats().EntityCounts
+
+		entities := entity.Entities()
+		if typeName != "" {
+			entities = entity.GetEntitiesByType(typeName)
+		}
+		for _, e := range entities {
+			if !spaceID.IsNil() && (e.Space == nil || e.Space.ID != spaceID) {
+				continue
+			}
+
+			summary := entitySummary{
+				ID:       e.ID,
+				TypeName: e.TypeName,
+				Position: e.GetPosition(),
+			}
+			if e.Space != nil {
+				summary.Space = e.Space.ID
+			}
+			if client := e.GetClient(); client != nil {
+				summary.Client = client.String()
+			}
+			resp.Entities = append(resp.Entities, summary)
+		}
+		return nil
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusServiceUnavailable)
+		return
+	}
+
+	sort.Slice(resp.Entities, func(i, j int) bool {
+		return resp.Entities[i].ID < resp.Entities[j].ID
+	})
+	resp.Total = len(resp.Entities)
+	if len(resp.Entities) > limit {
+		resp.Entities = resp.Entities[:limit]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleCallEntityRequest calls the method of entity on this game, only methods allowed by EntityTypeDesc.AllowInspectorCall can be called
+//
+// If the game routine is too busy to run the call before the request times out, the call is still queued and might run
+// later, so 202 Accepted is responded with the result unknown.
+//
+// Usage: POST /call_entity?id=<entity ID>&method=<method>&args=<arguments in JSON array>
+func handleCallEntityRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method should be called using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	eid := common.EntityID(r.FormValue("id"))
+	if len(eid) != common.ENTITYID_LENGTH {
+		http.Error(w, fmt.Sprintf("invalid entity ID: %#v", string(eid)), http.StatusBadRequest)
+		return
+	}
+	method := r.FormValue("method")
+	argsJSON := r.FormValue("args")
+	if argsJSON == "" {
+		argsJSON = "[]"
+	}
+	args, err := gwgrpc.DecodeArgs(argsJSON)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	status := http.StatusOK
+	if err := runInGameRoutine(ctx, func() error {
+		e := entity.GetEntity(eid)
+		if e == nil {
+			status = http.StatusNotFound
+			return fmt.Errorf("entity %s not found on game%d", eid, gameid)
+		}
+		if !entity.GetEntityTypeDesc(e.TypeName).IsInspectorCallAllowed(method) {
+			status = http.StatusForbidden
+			return fmt.Errorf("method %s of %s is not allowed to be called from inspector", method, e.TypeName)
+		}
+
+		gwlog.Infof("admin: call %s.%s%v", e, method, args)
+		entity.Call(eid, method, args)
+		return nil
+	}); grpcstatus.Code(err) == codes.DeadlineExceeded {
+		// status is not read, since the queued call might be running in the game routine
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintf(w, "call %s.%s%v is queued, but the result is unknown: %s\n", eid, method, args, err)
+		return
+	} else if err != nil {
+		if status == http.StatusOK {
+			status = http.StatusInternalServerError // the call panics
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	fmt.Fprintf(w, "called %s.%s%v\n", eid, method, args)
+}
+
+// handleGMRequest runs the GM command on the entity on this game by the user of the request
+//
+// Usage: POST /gm?id=<entity ID>&command=<command line>, the command line is "<name> [args...]"
+func handleGMRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "GM commands should be run using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	eid := common.EntityID(r.FormValue("id"))
+	if len(eid) != common.ENTITYID_LENGTH {
+		http.Error(w, fmt.Sprintf("invalid entity ID: %#v", string(eid)), http.StatusBadRequest)
+		return
+	}
+	_, name, args, err := entity.ParseGMCommand(r.FormValue("command"))
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), _HTTP_REQUEST_TIMEOUT)
+	defer cancel()
+
+	status := http.StatusOK
+	var output string
+	if err := runInGameRoutine(ctx, func() error {
+		e := entity.GetEntity(eid)
+		if e == nil {
+			status = http.StatusNotFound
+			return fmt.Errorf("entity %s not found on game%d", eid, gameid)
+		}
+
+		var err error
+		output, err = entity.ExecGMCommand(binutil.AdminUser(r), r.RemoteAddr, e, name, args)
+		if errors.Cause(err) == rbac.ErrPermissionDenied {
+			status = http.StatusForbidden
+		} else if err != nil {
+			status = http.StatusBadRequest
+		}
+		return err
+	}); err != nil {
+		if status == http.StatusOK {
+			status = http.StatusServiceUnavailable
+		}
+		http.Error(w, err.Error(), status)
+		return
+	}
+	fmt.Fprintln(w, output)
+}
+
+// handleInspectorRequest serves the web page of entity inspector, which browses entities using the admin APIs
+//
+// Usage: /inspector, browsers prompt for the admin token, which is the password of basic authentication
+func handleInspectorRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	io.WriteString(w, inspectorPage)
+}
+
+// getEntityIDs returns sorted IDs of entities in the EntitySet
+func getEntityIDs(es entity.EntitySet) []common.EntityID {
+	eids := make([]common.EntityID, 0, len(es))
+	for e := range es {
+		eids = append(eids, e.ID)
+	}
+	sort.Slice(eids, func(i, j int) bool {
+		return eids[i] < eids[j]
+	})
+	return eids
+}
+
+const inspectorPage = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>GoWorld Entity Inspector</title>
+<style>
+body { font-family: sans-serif; font-size: 13px; margin: 0; display: flex; height: 100vh; }
+#list { width: 480px; overflow: auto; border-right: 1px solid #ccc; padding: 8px; }
+#detail { flex: 1; overflow: auto; padding: 8px; }
+table { border-collapse: collapse; width: 100%; }
+td, th { border-bottom: 1px solid #eee; padding: 2px 4px; text-align: left; vertical-align: top; }
+tr.entity:hover { background: #eef; cursor: pointer; }
+a { color: #36c; cursor: pointer; }
+pre { background: #f6f6f6; padding: 6px; margin: 0; }
+.error { color: #c00; }
+</style>
+</head>
+<body>
+<div id="list">
+  <div>
+    Type <select id="type"><option value="">all</option></select>
+    Space <input id="space" size="16">
+    <button onclick="loadEntities()">Refresh</button>
+  </div>
+  <div id="total"></div>
+  <table><thead><tr><th>ID</th><th>Type</th><th>Space</th><th>Position</th></tr></thead><tbody id="entities"></tbody></table>
+</div>
+<div id="detail">Select an entity</div>
+<script>
+// requests carry the basic authentication entered when opening the page
+function api(path, params, method) {
+  var query = new URLSearchParams(params || {}).toString();
+  return fetch(path + (query ? "?" + query : ""), {method: method || "GET", credentials: "same-origin"}).then(function (resp) {
+    return resp.text().then(function (text) {
+      if (!resp.ok) {
+        throw new Error(text);
+      }
+      return text;
+    });
+  });
+}
+
+function esc(s) {
+  var div = document.createElement("div");
+  div.textContent = s;
+  return div.innerHTML;
+}
+
+function entityLink(eid) {
+  return eid ? '<a onclick="showEntity(\'' + esc(eid) + '\')">' + esc(eid) + '</a>' : "";
+}
+
+function formatPos(pos) {
+  return [pos.X, pos.Y, pos.Z].map(function (v) { return Number(v).toFixed(1); }).join(", ");
+}
+
+function loadEntities() {
+  var params = {limit: 500};
+  var typeName = document.getElementById("type").value;
+  var space = document.getElementById("space").value.trim();
+  if (typeName) params.type = typeName;
+  if (space) params.space = space;
+  api("/entities", params).then(JSON.parse).then(function (resp) {
+    var select = document.getElementById("type");
+    Object.keys(resp.types).sort().forEach(function (t) {
+      if (!select.querySelector('option[value="' + t + '"]')) {
+        var opt = document.createElement("option");
+        opt.value = opt.textContent = t;
+        select.appendChild(opt);
+      }
+    });
+    document.getElementById("total").textContent = resp.total + " entities, " + resp.entities.length + " shown";
+    document.getElementById("entities").innerHTML = resp.entities.map(function (e) {
+      return '<tr class="entity" onclick="showEntity(\'' + esc(e.id) + '\')"><td>' + esc(e.id) + '</td><td>' + esc(e.type) +
+        '</td><td>' + esc(e.space) + '</td><td>' + formatPos(e.position) + '</td></tr>';
+    }).join("");
+  }).catch(showError);
+}
+
+function showEntity(eid) {
+  api("/entity", {id: eid}).then(JSON.parse).then(function (e) {
+    var html = "<h3>" + esc(e.type) + " " + esc(e.id) + ' <button onclick="showEntity(\'' + esc(e.id) + '\')">Refresh</button></h3><table>';
+    html += "<tr><th>Space</th><td>" + entityLink(e.space) + (e.space_entities ? " (" + e.space_entities + " entities)" : "") + "</td></tr>";
+    html += "<tr><th>Position</th><td>" + formatPos(e.position) + "</td></tr>";
+    html += "<tr><th>Yaw</th><td>" + e.yaw + "</td></tr>";
+    html += "<tr><th>Client</th><td>" + esc(e.client) + "</td></tr>";
+    html += "<tr><th>Persistent</th><td>" + e.persistent + "</td></tr>";
+    html += "<tr><th>Use AOI</th><td>" + e.use_aoi + "</td></tr>";
+    html += "<tr><th>Interested in</th><td>" + e.interested_in.map(entityLink).join(" ") + "</td></tr>";
+    html += "<tr><th>Interested by</th><td>" + e.interested_by.map(entityLink).join(" ") + "</td></tr>";
+    html += "</table><h4>Attributes</h4><pre>" + esc(JSON.stringify(e.attrs, null, 2)) + "</pre>";
+    if (e.inspector_calls.length > 0) {
+      html += '<h4>Call</h4><select id="method">' + e.inspector_calls.map(function (m) {
+        return "<option>" + esc(m) + "</option>";
+      }).join("") + '</select> args <input id="args" size="40" value="[]"> <button onclick="callEntity(\'' + esc(e.id) + '\')">Call</button> <span id="result"></span>';
+    }
+    document.getElementById("detail").innerHTML = html;
+  }).catch(showError);
+}
+
+function callEntity(eid) {
+  var params = {id: eid, method: document.getElementById("method").value, args: document.getElementById("args").value};
+  api("/call_entity", params, "POST").then(function (text) {
+    document.getElementById("result").textContent = text;
+  }).catch(function (err) {
+    document.getElementById("result").innerHTML = '<span class="error">' + esc(err.message) + "</span>";
+  });
+}
+
+function showError(err) {
+  document.getElementById("detail").innerHTML = '<pre class="error">' + esc(err.message) + "</pre>";
+}
+
+loadEntities();
+</script>
+</body>
+</html>
+`
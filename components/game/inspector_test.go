@@ -0,0 +1,148 @@
+package game
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/goworldtest"
+)
+
+type testInspectorSpace struct {
+	entity.Space
+}
+
+type testInspected struct {
+	entity.Entity
+	healed int
+}
+
+func (e *testInspected) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.AllowInspectorCall("Heal")
+}
+
+func (e *testInspected) Heal(amount int) {
+	e.healed += amount
+}
+
+func (e *testInspected) Kill() {
+}
+
+var inspectorWorld *goworldtest.World
+
+func setupInspectorWorld() *goworldtest.World {
+	if inspectorWorld == nil {
+		entity.RegisterSpace(&testInspectorSpace{})
+		entity.RegisterEntity("testInspected", &testInspected{}, false)
+		inspectorWorld = goworldtest.Setup()
+	}
+	return inspectorWorld
+}
+
+// serveInGameRoutine serves the request, and runs posted functions like the game routine until the response is written
+func serveInGameRoutine(w *goworldtest.World, handler http.HandlerFunc, r *http.Request) *httptest.ResponseRecorder {
+	rec := httptest.NewRecorder()
+	done := make(chan struct{})
+	go func() {
+		handler(rec, r)
+		close(done)
+	}()
+	for {
+		select {
+		case <-done:
+			w.Step()
+			return rec
+		default:
+			w.Step()
+			time.Sleep(time.Millisecond)
+		}
+	}
+}
+
+func newCallEntityRequest(eid string, method string, args string) *http.Request {
+	form := url.Values{"id": {eid}, "method": {method}, "args": {args}}
+	r := httptest.NewRequest(http.MethodPost, "/call_entity", strings.NewReader(form.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return r
+}
+
+func TestCallEntityRequest(t *testing.T) {
+	w := setupInspectorWorld()
+	e := w.CreateEntity("testInspected")
+	x := e.I.(*testInspected)
+
+	rec := serveInGameRoutine(w, handleCallEntityRequest, newCallEntityRequest(string(e.ID), "Heal", "[10]"))
+	if rec.Code != http.StatusOK || x.healed != 10 {
+		t.Fatalf("allowed method should be called, but got %d %s, healed %d", rec.Code, rec.Body, x.healed)
+	}
+
+	for _, c := range []struct {
+		r    *http.Request
+		code int
+	}{
+		{httptest.NewRequest(http.MethodGet, "/call_entity?id="+string(e.ID)+"&method=Heal", nil), http.StatusMethodNotAllowed},
+		{newCallEntityRequest("invalid", "Heal", "[10]"), http.StatusBadRequest},
+		{newCallEntityRequest(string(e.ID), "Heal", "not json"), http.StatusBadRequest},
+		{newCallEntityRequest(string(e.ID), "Kill", "[]"), http.StatusForbidden},
+		{newCallEntityRequest(strings.Repeat("A", len(e.ID)), "Heal", "[10]"), http.StatusNotFound},
+	} {
+		if rec := serveInGameRoutine(w, handleCallEntityRequest, c.r); rec.Code != c.code {
+			t.Errorf("%s %s should respond %d, but got %d %s", c.r.Method, c.r.URL, c.code, rec.Code, rec.Body)
+		}
+	}
+	if x.healed != 10 {
+		t.Errorf("rejected calls should not be called, but healed %d", x.healed)
+	}
+}
+
+func TestCallEntityRequestQueued(t *testing.T) {
+	w := setupInspectorWorld()
+	e := w.CreateEntity("testInspected")
+	x := e.I.(*testInspected)
+
+	// the game routine is busy, so the call is queued when the request times out
+	ctx, cancel := context.WithTimeout(context.Background(), time.Millisecond*10)
+	defer cancel()
+	rec := httptest.NewRecorder()
+	handleCallEntityRequest(rec, newCallEntityRequest(string(e.ID), "Heal", "[5]").WithContext(ctx))
+	if rec.Code != http.StatusAccepted || !strings.Contains(rec.Body.String(), "queued") {
+		t.Fatalf("timed out call should be reported as queued, but got %d %s", rec.Code, rec.Body)
+	}
+
+	if post.GetQueueLen() == 0 {
+		t.Fatalf("the call should be queued")
+	}
+	w.Step()
+	if x.healed != 5 {
+		t.Errorf("the queued call should be called by the game routine, but healed %d", x.healed)
+	}
+}
+
+func TestEntitiesRequest(t *testing.T) {
+	w := setupInspectorWorld()
+	e1, e2 := w.CreateEntity("testInspected"), w.CreateEntity("testInspected")
+
+	rec := serveInGameRoutine(w, handleEntitiesRequest, httptest.NewRequest(http.MethodGet, "/entities?type=testInspected&limit=1", nil))
+	var resp entityListResponse
+	if err := json.Unmarshal(rec.Body.Bytes(), &resp); rec.Code != http.StatusOK || err != nil {
+		t.Fatalf("list entities failed: %d %s", rec.Code, rec.Body)
+	}
+	if resp.Total < 2 || len(resp.Entities) != 1 || resp.Types["testInspected"] != resp.Total {
+		t.Errorf("entities should be limited, but got %+v", resp)
+	}
+	if id := resp.Entities[0].ID; id > e1.ID || id > e2.ID || resp.Entities[0].TypeName != "testInspected" {
+		t.Errorf("entities should be sorted by ID, but got %+v", resp.Entities[0])
+	}
+
+	rec = serveInGameRoutine(w, handleEntitiesRequest, httptest.NewRequest(http.MethodGet, "/entities?limit=-1", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid limit should be rejected, but got %d", rec.Code)
+	}
+}
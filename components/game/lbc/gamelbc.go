@@ -1,7 +1,9 @@
 package gamelbc
 
 import (
+	"math"
 	"os"
+	"sync/atomic"
 
 	"context"
 
@@ -14,6 +16,29 @@ import (
 	"github.com/xiaonanln/goworld/engine/proto"
 )
 
+var (
+	cpuPercentBits uint64 // math.Float64bits of the last CPU percent
+	draining       int32
+)
+
+// SetDraining notifies all dispatchers whether the game is drained, which can be called from any goroutine, so that
+// dispatchers choose other games for new entities even if the game routine is stalled
+func SetDraining(d bool) {
+	var v int32
+	if d {
+		v = 1
+	}
+	atomic.StoreInt32(&draining, v)
+	sendGameLBCInfo()
+}
+
+func sendGameLBCInfo() {
+	dispatchercluster.SendGameLBCInfo(proto.GameLBCInfo{
+		CPUPercent: math.Float64frombits(atomic.LoadUint64(&cpuPercentBits)),
+		Draining:   atomic.LoadInt32(&draining) != 0,
+	})
+}
+
 func Initialize(ctx context.Context, collectInterval time.Duration) {
 	pid := os.Getpid()
 	p, err := process.NewProcess(int32(pid))
@@ -31,9 +56,8 @@ func Initialize(ctx context.Context, collectInterval time.Duration) {
 			}
 
 			gwlog.Debugf("gamelbc: cpu percent is %.3f%%", pcnt)
-			dispatchercluster.SendGameLBCInfo(proto.GameLBCInfo{
-				CPUPercent: pcnt,
-			})
+			atomic.StoreUint64(&cpuPercentBits, math.Float64bits(pcnt))
+			sendGameLBCInfo()
 		}
 	})
 }
@@ -0,0 +1,52 @@
+package game
+
+import (
+	"plugin"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// _PLUGIN_ENTITY_TYPES_SYMBOL is the symbol of plugins returning entity types to be reloaded
+	_PLUGIN_ENTITY_TYPES_SYMBOL = "EntityTypes"
+)
+
+var (
+	loadedPlugins = map[string]struct{}{} // paths of loaded plugins
+)
+
+// ReloadPlugin reloads entity types from the Go plugin, see entity.ReloadEntityTypes
+//
+// The plugin is built by `go build -buildmode=plugin` against the same goworld version as the game, and exports
+//
+//	func EntityTypes() map[string]entity.IEntity
+//
+// which returns new types of registered entity types by type names. Go plugins can not be unloaded or opened twice, so
+// each build of the plugin should be written to a new file.
+//
+// ReloadPlugin should be called in the game routine.
+func ReloadPlugin(path string) error {
+	if _, ok := loadedPlugins[path]; ok {
+		return errors.Errorf("plugin %s is already loaded, build the plugin to a new file", path)
+	}
+
+	p, err := plugin.Open(path)
+	if err != nil {
+		return errors.Wrap(err, "open plugin failed")
+	}
+	loadedPlugins[path] = struct{}{}
+
+	sym, err := p.Lookup(_PLUGIN_ENTITY_TYPES_SYMBOL)
+	if err != nil {
+		return errors.Wrap(err, "lookup plugin symbol failed")
+	}
+	entityTypes, ok := sym.(func() map[string]entity.IEntity)
+	if !ok {
+		return errors.Errorf("plugin symbol %s should be func() map[string]entity.IEntity, but is %T", _PLUGIN_ENTITY_TYPES_SYMBOL, sym)
+	}
+
+	gwlog.Infof("%s: reloading plugin %s ...", gameService, path)
+	return entity.ReloadEntityTypes(entityTypes())
+}
@@ -1,22 +1,47 @@
 package game
 
 import (
+	"encoding/base64"
 	"fmt"
+	"hash/crc32"
 	"io/ioutil"
 	"time"
 
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/post"
 )
 
+const (
+	_KVDB_FREEZE_KEY_PREFIX = "_freeze_:"
+	// freeze data is split into chunks stored in KVDB, since KVDB backends limit sizes of values
+	_KVDB_FREEZE_CHUNK_SIZE = 1024 * 1024
+)
+
+// freezeToKVDB is set if the freeze data of the game is also stored in KVDB, so that the game can be restored on
+// another host by -restore-from kvdb
+var freezeToKVDB xnsyncutil.AtomicBool
+
 func freezeFilename(gameid uint16) string {
 	return fmt.Sprintf("game%d_freezed.dat", gameid)
 }
 
 func restoreFreezedEntities() error {
 	t0 := time.Now()
-	freezeFilename := freezeFilename(gameid)
-	data, err := ioutil.ReadFile(freezeFilename)
+	var data []byte
+	var chunks int
+	var err error
+	if restoreFrom == "kvdb" {
+		data, chunks, err = loadFreezeDataFromKVDB(gameid)
+	} else {
+		data, err = ioutil.ReadFile(freezeFilename(gameid))
+	}
 	if err != nil {
 		return err
 	}
@@ -29,6 +54,172 @@ func restoreFreezedEntities() error {
 	err = entity.RestoreFreezedEntities(&freezeEntity)
 	t3 := time.Now()
 
-	gwlog.Infof("Restored game service: load = %s, unpack = %s, restore = %s", t1.Sub(t0), t2.Sub(t1), t3.Sub(t2))
+	gwlog.Infof("Restored game service from %s: load = %s, unpack = %s, restore = %s", restoreFrom, t1.Sub(t0), t2.Sub(t1), t3.Sub(t2))
+	if err == nil && restoreFrom == "kvdb" {
+		// the freeze data is consumed, so that the game is not restored from it again
+		err = deleteFreezeDataFromKVDB(gameid, chunks)
+	}
 	return err
 }
+
+// waitKVDB runs KVDB operations and waits for them to finish in the game routine, before the game service runs or
+// after it stops
+func waitKVDB(op func(done func(error))) error {
+	var opErr error
+	op(func(err error) {
+		if err != nil && opErr == nil {
+			opErr = err
+		}
+	})
+	for async.WaitClear() {
+		post.Flush()
+	}
+	return opErr
+}
+
+func freezeMetaKey(gameid uint16) string {
+	return fmt.Sprintf("%sgame%d", _KVDB_FREEZE_KEY_PREFIX, gameid)
+}
+
+func freezeChunkKey(gameid uint16, index int) string {
+	return fmt.Sprintf("%s:%06d", freezeMetaKey(gameid), index)
+}
+
+// encodeFreezeChunk encodes the chunk of freeze data in base64, since KVDB values are strings
+func encodeFreezeChunk(chunk []byte) string {
+	return base64.StdEncoding.EncodeToString(chunk)
+}
+
+// saveFreezeDataToKVDB stores chunks of the freeze data in KVDB, and then the meta of the freeze data in the form of
+// "<chunks>:<crc32>", so that partially stored freeze data is never restored
+func saveFreezeDataToKVDB(gameid uint16, data []byte) error {
+	if config.GetKVDB().Type == "" {
+		return errors.New("KVDB is not configured")
+	}
+
+	chunks := 0
+	err := waitKVDB(func(done func(error)) {
+		for offset := 0; offset < len(data); offset += _KVDB_FREEZE_CHUNK_SIZE {
+			end := offset + _KVDB_FREEZE_CHUNK_SIZE
+			if end > len(data) {
+				end = len(data)
+			}
+			kvdb.Put(freezeChunkKey(gameid, chunks), encodeFreezeChunk(data[offset:end]), done)
+			chunks++
+		}
+	})
+	if err != nil {
+		return errors.Wrap(err, "store freeze data to KVDB failed")
+	}
+
+	// chunks of the previous freeze data which is not restored are overwritten, except those beyond the new chunks
+	oldChunks, _, _, err := loadFreezeMetaFromKVDB(gameid)
+	if err != nil {
+		oldChunks = 0 // the meta is overwritten anyway
+	}
+
+	meta := fmt.Sprintf("%d:%d", chunks, crc32.ChecksumIEEE(data))
+	if err := waitKVDB(func(done func(error)) {
+		kvdb.Put(freezeMetaKey(gameid), meta, done)
+	}); err != nil {
+		return errors.Wrap(err, "store freeze data to KVDB failed")
+	}
+
+	if oldChunks > chunks {
+		if err := deleteFreezeChunksFromKVDB(gameid, chunks, oldChunks); err != nil {
+			gwlog.Errorf("delete stale chunks of freeze data from KVDB failed: %s", err)
+		}
+	}
+	return nil
+}
+
+// loadFreezeMetaFromKVDB loads the number of chunks and the checksum of the freeze data
+func loadFreezeMetaFromKVDB(gameid uint16) (chunks int, checksum uint32, found bool, err error) {
+	var meta string
+	if err = waitKVDB(func(done func(error)) {
+		kvdb.Get(freezeMetaKey(gameid), func(val string, err error) {
+			meta = val
+			done(err)
+		})
+	}); err != nil {
+		return
+	}
+	if meta == "" {
+		return
+	}
+	found = true
+	if _, err = fmt.Sscanf(meta, "%d:%d", &chunks, &checksum); err != nil {
+		err = errors.Errorf("invalid meta of freeze data in KVDB: %s", meta)
+	}
+	return
+}
+
+// deleteFreezeDataFromKVDB deletes the meta and all chunks of the freeze data from KVDB
+//
+// The meta is deleted first, so that partially deleted freeze data is never restored.
+func deleteFreezeDataFromKVDB(gameid uint16, chunks int) error {
+	if err := waitKVDB(func(done func(error)) {
+		kvdb.Delete(freezeMetaKey(gameid), done)
+	}); err != nil {
+		return errors.Wrap(err, "delete freeze data from KVDB failed")
+	}
+	return deleteFreezeChunksFromKVDB(gameid, 0, chunks)
+}
+
+// deleteFreezeChunksFromKVDB deletes chunks in [begin, end) of the freeze data from KVDB
+func deleteFreezeChunksFromKVDB(gameid uint16, begin int, end int) error {
+	if err := waitKVDB(func(done func(error)) {
+		for i := begin; i < end; i++ {
+			kvdb.Delete(freezeChunkKey(gameid, i), done)
+		}
+	}); err != nil {
+		return errors.Wrap(err, "delete chunks of freeze data from KVDB failed")
+	}
+	return nil
+}
+
+// loadFreezeDataFromKVDB loads the freeze data stored by saveFreezeDataToKVDB, and verifies it by the checksum, returns
+// the data and the number of chunks
+func loadFreezeDataFromKVDB(gameid uint16) ([]byte, int, error) {
+	if config.GetKVDB().Type == "" {
+		return nil, 0, errors.New("KVDB is not configured")
+	}
+
+	chunks, checksum, found, err := loadFreezeMetaFromKVDB(gameid)
+	if err != nil {
+		return nil, 0, errors.Wrap(err, "load freeze data from KVDB failed")
+	}
+	if !found {
+		return nil, 0, errors.Errorf("freeze data of game%d is not found in KVDB", gameid)
+	}
+
+	var items []kvdbtypes.KVItem
+	if err := waitKVDB(func(done func(error)) {
+		kvdb.GetRange(freezeChunkKey(gameid, 0), freezeChunkKey(gameid, chunks), func(_items []kvdbtypes.KVItem, err error) {
+			items = _items
+			done(err)
+		})
+	}); err != nil {
+		return nil, 0, errors.Wrap(err, "load freeze data from KVDB failed")
+	}
+	if len(items) != chunks {
+		return nil, 0, errors.Errorf("freeze data in KVDB should have %d chunks, but has %d", chunks, len(items))
+	}
+
+	var data []byte
+	for i, item := range items {
+		if item.Key != freezeChunkKey(gameid, i) {
+			return nil, 0, errors.Errorf("chunk %d of freeze data is not found in KVDB", i)
+		}
+		chunk, err := base64.StdEncoding.DecodeString(item.Val)
+		if err != nil {
+			return nil, 0, errors.Wrapf(err, "decode chunk %d of freeze data failed", i)
+		}
+		data = append(data, chunk...)
+	}
+	if crc32.ChecksumIEEE(data) != checksum {
+		return nil, 0, errors.New("checksum of freeze data in KVDB mismatches")
+	}
+	gwlog.Infof("Loaded freeze data of %d bytes in %d chunks from KVDB", len(data), chunks)
+	return data, chunks, nil
+}
@@ -0,0 +1,131 @@
+package game
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+func init() {
+	config.SetConfigFile("../../goworld.ini")
+	kvdb.Initialize()
+}
+
+func randomFreezeData(size int) []byte {
+	data := make([]byte, size)
+	rand.Read(data)
+	return data
+}
+
+func TestFreezeDataKVDBRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, _KVDB_FREEZE_CHUNK_SIZE, _KVDB_FREEZE_CHUNK_SIZE*2 + 100} {
+		data := randomFreezeData(size)
+		if err := saveFreezeDataToKVDB(1001, data); err != nil {
+			t.Fatalf("save freeze data of %d bytes failed: %v", size, err)
+		}
+		loaded, _, err := loadFreezeDataFromKVDB(1001)
+		if err != nil {
+			t.Fatalf("load freeze data of %d bytes failed: %v", size, err)
+		}
+		if !bytes.Equal(loaded, data) {
+			t.Fatalf("loaded freeze data of %d bytes mismatches, got %d bytes", size, len(loaded))
+		}
+	}
+}
+
+func TestFreezeDataKVDBNotFound(t *testing.T) {
+	if _, _, err := loadFreezeDataFromKVDB(1002); err == nil {
+		t.Fatalf("freeze data should not be found")
+	}
+}
+
+func TestFreezeDataKVDBCorruptedChunk(t *testing.T) {
+	data := randomFreezeData(_KVDB_FREEZE_CHUNK_SIZE + 100)
+	if err := saveFreezeDataToKVDB(1003, data); err != nil {
+		t.Fatal(err)
+	}
+
+	// replace the chunk with valid base64 of different bytes, so that only the checksum detects the corruption
+	corrupted := append([]byte{}, data[_KVDB_FREEZE_CHUNK_SIZE:]...)
+	corrupted[0] ^= 0xff
+	if err := waitKVDB(func(done func(error)) {
+		kvdb.Put(freezeChunkKey(1003, 1), encodeFreezeChunk(corrupted), done)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadFreezeDataFromKVDB(1003); err == nil {
+		t.Fatalf("corrupted freeze data should not be loaded")
+	}
+
+	if err := waitKVDB(func(done func(error)) {
+		kvdb.Put(freezeChunkKey(1003, 1), "not base64!", done)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadFreezeDataFromKVDB(1003); err == nil {
+		t.Fatalf("freeze data with invalid chunk should not be loaded")
+	}
+}
+
+func TestFreezeDataKVDBMissingChunk(t *testing.T) {
+	data := randomFreezeData(_KVDB_FREEZE_CHUNK_SIZE*2 + 100)
+	if err := saveFreezeDataToKVDB(1004, data); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := waitKVDB(func(done func(error)) {
+		kvdb.Delete(freezeChunkKey(1004, 1), done)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := loadFreezeDataFromKVDB(1004); err == nil {
+		t.Fatalf("freeze data with missing chunk should not be loaded")
+	}
+}
+
+// countFreezeKeys returns the number of the meta and chunks of the freeze data in KVDB
+func countFreezeKeys(t *testing.T, gameid uint16) int {
+	var items []kvdbtypes.KVItem
+	if err := waitKVDB(func(done func(error)) {
+		kvdb.GetRange(freezeMetaKey(gameid), kvdb.NextLargerKey(freezeChunkKey(gameid, 999999)), func(_items []kvdbtypes.KVItem, err error) {
+			items = _items
+			done(err)
+		})
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return len(items)
+}
+
+func TestFreezeDataKVDBDelete(t *testing.T) {
+	if err := saveFreezeDataToKVDB(1006, randomFreezeData(_KVDB_FREEZE_CHUNK_SIZE*3)); err != nil {
+		t.Fatal(err)
+	}
+	if n := countFreezeKeys(t, 1006); n != 4 {
+		t.Fatalf("freeze data should have the meta and 3 chunks, but has %d keys", n)
+	}
+
+	// stale chunks of the previous freeze data are deleted
+	data := randomFreezeData(_KVDB_FREEZE_CHUNK_SIZE + 100)
+	if err := saveFreezeDataToKVDB(1006, data); err != nil {
+		t.Fatal(err)
+	}
+	if n := countFreezeKeys(t, 1006); n != 3 {
+		t.Fatalf("freeze data should have the meta and 2 chunks, but has %d keys", n)
+	}
+
+	loaded, chunks, err := loadFreezeDataFromKVDB(1006)
+	if err != nil || !bytes.Equal(loaded, data) {
+		t.Fatalf("load freeze data failed: %v", err)
+	}
+	if err := deleteFreezeDataFromKVDB(1006, chunks); err != nil {
+		t.Fatal(err)
+	}
+	if n := countFreezeKeys(t, 1006); n != 0 {
+		t.Fatalf("all chunks of restored freeze data should be deleted, but %d keys remain", n)
+	}
+}
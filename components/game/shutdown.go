@@ -0,0 +1,68 @@
+package game
+
+import (
+	"syscall"
+	"time"
+
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// _DEFAULT_SHUTDOWN_REASON is the reason notified to clients if the game is terminated without Shutdown
+	_DEFAULT_SHUTDOWN_REASON = "server shutdown"
+	// _SHUTDOWN_MIGRATION_TIMEOUT is the max time waiting for in-flight migrations before the game is terminated
+	_SHUTDOWN_MIGRATION_TIMEOUT = consts.ENTER_SPACE_REQUEST_TIMEOUT
+)
+
+// Shutdown shuts down the game gracefully after the grace period
+//
+// Dispatchers stop creating boot entities on the game immediately, and entities created or loaded anywhere are put on
+// other games if possible. Clients of all entities are notified with the reason and the countdown. After the grace
+// period, the game is terminated like receiving SIGTERM: in-flight migrations are completed, entities are saved and
+// destroyed, and the game exits after all entity saves and KVDB writes are flushed.
+//
+// Shutdown should be called in the game routine.
+func Shutdown(grace time.Duration, reason string) {
+	if gameService.shuttingDown {
+		gwlog.Warnf("%s: game is already shutting down", gameService)
+		return
+	}
+
+	gameService.startShutdown(reason, grace)
+	timer.AddCallback(grace, func() {
+		// the signal routine might be busy, so never block the game routine
+		go func() {
+			signalChan <- syscall.SIGTERM
+		}()
+	})
+}
+
+// startShutdown stops accepting new entities from dispatchers and notifies clients that the game is shutting down
+func (gs *GameService) startShutdown(reason string, countdown time.Duration) {
+	gwlog.Infof("%s: shutting down in %s: %s", gs, countdown, reason)
+	gs.shuttingDown = true
+	dispatchercluster.SendNotifyGameShuttingDown()
+	entity.NotifyShutdownOnClients(reason, countdown)
+}
+
+// tryTerminate terminates the game if there is no in-flight migration, or the migration timeout is exceeded
+func (gs *GameService) tryTerminate() {
+	if !gs.shuttingDown {
+		gs.startShutdown(_DEFAULT_SHUTDOWN_REASON, 0)
+	}
+	if gs.terminateDeadline.IsZero() {
+		gs.terminateDeadline = time.Now().Add(_SHUTDOWN_MIGRATION_TIMEOUT)
+	}
+
+	if n := entity.CountEnteringSpaceEntities(); n > 0 {
+		if time.Now().Before(gs.terminateDeadline) {
+			return
+		}
+		gwlog.Warnf("%s: migration timeout, terminating with %d entities entering spaces ...", gs, n)
+	}
+	gs.doTerminate()
+}
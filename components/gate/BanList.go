@@ -0,0 +1,248 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/banlist"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+// addBan adds the ban to the ban list, publishes it to other gates, and saves it to KVDB if the ban list is persisted
+//
+// addBan can be called in any goroutine
+func (gs *GateService) addBan(ban banlist.Ban) {
+	gwlog.Infof("%s: %s", gs, ban)
+	gs.banList.Add(ban)
+	if err := banlist.Publish(ban); err != nil {
+		gwlog.Errorf("%s: publish %s %s failed: %s", gs, ban.Kind, ban.Value, err)
+	}
+	if gs.persistBanList {
+		banlist.Save(ban, func(err error) {
+			if err != nil {
+				gwlog.Errorf("%s: save %s %s to KVDB failed: %s", gs, ban.Kind, ban.Value, err)
+			}
+		})
+	}
+}
+
+// removeBan removes the ban of the value from the ban list and other gates, and from KVDB if the ban list is persisted
+func (gs *GateService) removeBan(kind banlist.Kind, value string) {
+	gs.banList.Remove(kind, value)
+	if err := banlist.PublishDelete(kind, value); err != nil {
+		gwlog.Errorf("%s: publish unban of %s %s failed: %s", gs, kind, value, err)
+	}
+	if gs.persistBanList {
+		banlist.Delete(kind, value, func(err error) {
+			if err != nil {
+				gwlog.Errorf("%s: delete %s %s from KVDB failed: %s", gs, kind, value, err)
+			}
+		})
+	}
+}
+
+// banFloodingClient bans the account, the device or the IP of the flooding client by flood_ban_scope for flood_ban_duration
+//
+// IPs are only banned by ip scope, since clients behind the same NAT share the IP. banFloodingClient should be called in
+// the gate routine.
+func (gs *GateService) banFloodingClient(cp *ClientProxy, reason string) {
+	if cp.cfg.FloodBanDuration <= 0 {
+		return
+	}
+
+	var kind banlist.Kind
+	var value string
+	switch cp.cfg.FloodBanScope {
+	case "ip":
+		kind, value = banlist.KindIP, getAddrIP(cp.RemoteAddr())
+	case "account":
+		if cp.authID != "" {
+			kind, value = banlist.KindAccount, cp.authID
+		} else if cp.deviceID != "" {
+			kind, value = banlist.KindDevice, cp.deviceID
+		}
+	}
+	if kind == "" {
+		return
+	}
+
+	ban, err := banlist.NewBan(kind, value, cp.cfg.FloodBanDuration, "flooding: "+reason, gs.String())
+	if err != nil {
+		gwlog.Errorf("%s: ban flooding %s failed: %s", gs, cp, err)
+		return
+	}
+	gs.addBan(ban)
+}
+
+// handleSyncBanList applies the ban or unban published by gates and games, and kicks banned clients
+func (gs *GateService) handleSyncBanList(packet *netutil.Packet) {
+	kind := banlist.Kind(packet.ReadVarStr())
+	value := packet.ReadVarStr()
+	data := packet.ReadVarStr()
+	if data == "" {
+		gs.banList.Remove(kind, value)
+		return
+	}
+
+	ban, err := banlist.Decode(data)
+	if err != nil {
+		gwlog.Errorf("%s: invalid ban of %s %s: %s", gs, kind, value, err)
+		return
+	}
+	gs.banList.Add(ban)
+	gs.kickBannedClients()
+}
+
+// findClientBan returns the ban of the IP, the account or the device of the client
+func (gs *GateService) findClientBan(cp *ClientProxy) (banlist.Ban, bool) {
+	if ban, ok := gs.banList.Find(banlist.KindIP, getAddrIP(cp.RemoteAddr())); ok {
+		return ban, true
+	}
+	if ban, ok := gs.banList.Find(banlist.KindAccount, cp.authID); ok {
+		return ban, true
+	}
+	return gs.banList.Find(banlist.KindDevice, cp.deviceID)
+}
+
+// rejectBannedClient rejects the client if it is handshaking, or closes it
+func (gs *GateService) rejectBannedClient(cp *ClientProxy, ban banlist.Ban) {
+	gwlog.Warnf("%s: %s is rejected: %s", gs, cp, ban)
+	if _, ok := gs.handshakingClientProxies[cp.clientid]; ok {
+		gs.rejectHandshakingClient(cp, "banned")
+	} else {
+		cp.Close()
+	}
+}
+
+// kickBannedClients closes all banned clients
+func (gs *GateService) kickBannedClients() {
+	for _, cp := range gs.clientProxies {
+		if ban, ok := gs.findClientBan(cp); ok {
+			gs.rejectBannedClient(cp, ban)
+		}
+	}
+}
+
+// tryRefreshBanList reloads bans from KVDB every ban list refresh interval, so that bans added by other gates and
+// games are applied
+func (gs *GateService) tryRefreshBanList() {
+	if !gs.persistBanList || gs.banListRefreshInterval <= 0 || gs.banListRefreshing {
+		return
+	}
+	if time.Since(gs.banListRefreshTime) < gs.banListRefreshInterval {
+		return
+	}
+	gs.refreshBanList()
+}
+
+// refreshBanList reloads bans from KVDB and kicks banned clients
+func (gs *GateService) refreshBanList() {
+	since := time.Now()
+	gs.banListRefreshing = true
+	gs.banListRefreshTime = since
+	banlist.Load(func(bans []banlist.Ban, err error) {
+		gs.banListRefreshing = false
+		if err != nil {
+			gwlog.Errorf("%s: load bans from KVDB failed: %s", gs, err)
+			return
+		}
+
+		gs.banList.Replace(bans, since)
+		gs.kickBannedClients()
+	})
+}
+
+// parseBanTarget parses the kind and the value of the ban from parameters ip, account and device of the request
+func parseBanTarget(r *http.Request) (banlist.Kind, string, error) {
+	var kind banlist.Kind
+	var value string
+	for _, k := range []banlist.Kind{banlist.KindIP, banlist.KindAccount, banlist.KindDevice} {
+		if v := r.FormValue(string(k)); v != "" {
+			if kind != "" {
+				return "", "", errors.New("only one of ip, account and device should be given")
+			}
+			kind, value = k, v
+		}
+	}
+	if kind == "" {
+		return "", "", errors.New("ip, account or device is required")
+	}
+
+	value, err := banlist.Normalize(kind, value)
+	return kind, value, err
+}
+
+// handleBanRequest bans the IP or CIDR, the account or the device for duration seconds and kicks its clients
+//
+// Usage: POST /ban?ip=<ip or CIDR>&account=<auth ID>&device=<device ID>&duration=<seconds>&reason=<reason>
+//
+// Only one of ip, account and device should be given. The ban is permanent if duration is 0. Duration defaults to
+// flood_ban_duration for IPs, and bans of accounts and devices are permanent by default.
+func (gs *GateService) handleBanRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method should be called using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind, value, err := parseBanTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var duration time.Duration
+	if kind == banlist.KindIP {
+		duration = config.GetGate(args.gateid).FloodBanDuration
+	}
+	if s := r.FormValue("duration"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds < 0 {
+			http.Error(w, fmt.Sprintf("invalid duration: %#v", s), http.StatusBadRequest)
+			return
+		}
+		duration = time.Second * time.Duration(seconds)
+	}
+
+	ban, err := banlist.NewBan(kind, value, duration, r.FormValue("reason"), "admin")
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	gs.addBan(ban)
+	post.Post(gs.kickBannedClients)
+	fmt.Fprintf(w, "gate%d: %s\n", args.gateid, ban)
+}
+
+// handleUnbanRequest unbans the IP or CIDR, the account or the device
+//
+// Usage: POST /unban?ip=<ip or CIDR>&account=<auth ID>&device=<device ID>, only one of ip, account and device should be given
+func (gs *GateService) handleUnbanRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method should be called using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	kind, value, err := parseBanTarget(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	gs.removeBan(kind, value)
+	fmt.Fprintf(w, "gate%d unbanned %s %s\n", args.gateid, kind, value)
+}
+
+// handleBansRequest responds bans of the gate in JSON
+//
+// Usage: /bans
+func (gs *GateService) handleBansRequest(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(gs.banList.Bans())
+}
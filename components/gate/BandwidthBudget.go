@@ -0,0 +1,199 @@
+package main
+
+import (
+	"math"
+	"sort"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+const (
+	// _BANDWIDTH_POLICY_DROP drops position syncs of other entities when the bandwidth budget is exceeded
+	_BANDWIDTH_POLICY_DROP = "drop"
+	// _BANDWIDTH_POLICY_DELAY drops position syncs, and also delays attr changes of other entities when the bandwidth budget is exceeded
+	_BANDWIDTH_POLICY_DELAY = "delay"
+
+	_SYNC_ENTRY_SIZE = common.ENTITYID_LENGTH + proto.SYNC_INFO_SIZE_PER_ENTITY
+)
+
+// _SendPriority is the priority of data sent to clients, data of lower priority is dropped or delayed first
+type _SendPriority int
+
+const (
+	// _SEND_PRIORITY_POSITION is for position syncs, which are fitted to the budget by fitSyncData before sent
+	_SEND_PRIORITY_POSITION _SendPriority = iota
+	// _SEND_PRIORITY_OTHER_ATTR is for attr changes of other entities, delayed if over budget using delay policy
+	_SEND_PRIORITY_OTHER_ATTR
+	// _SEND_PRIORITY_ESSENTIAL is for RPCs, entity creations & destructions and own-entity attr changes, never dropped or delayed by itself
+	_SEND_PRIORITY_ESSENTIAL
+)
+
+// _BandwidthBudget limits outgoing bytes per second of a client proxy using token bucket
+//
+// Position syncs are dropped when the budget is exceeded, far-away entities first and own entity last, and are queued
+// after delayed packets like other packets.
+// Using delay policy, attr changes of other entities are also delayed until the budget is available,
+// and all following packets are delayed too to keep the packet order.
+//
+// _BandwidthBudget is used only in the gate service goroutine
+type _BandwidthBudget struct {
+	bytesPerSec    int
+	delayPolicy    bool
+	tokens         int
+	lastRefillTime time.Time
+	delayedPackets []*netutil.Packet
+	delayedBytes   int
+	ownPosition    proto.EntitySyncInfo
+	hasOwnPosition bool
+}
+
+func newBandwidthBudget(bytesPerSec int, policy string) *_BandwidthBudget {
+	return &_BandwidthBudget{
+		bytesPerSec:    bytesPerSec,
+		delayPolicy:    policy == _BANDWIDTH_POLICY_DELAY,
+		tokens:         bytesPerSec,
+		lastRefillTime: time.Now(),
+	}
+}
+
+func (bb *_BandwidthBudget) refill(now time.Time) {
+	elapsed := now.Sub(bb.lastRefillTime)
+	if elapsed <= 0 {
+		return
+	}
+
+	bb.lastRefillTime = now
+	bb.tokens += int(int64(bb.bytesPerSec) * int64(elapsed) / int64(time.Second))
+	if bb.tokens > bb.bytesPerSec { // allow bursts of at most 1 second
+		bb.tokens = bb.bytesPerSec
+	}
+}
+
+// available returns number of bytes that can be sent now
+func (bb *_BandwidthBudget) available(now time.Time) int {
+	bb.refill(now)
+	return bb.tokens
+}
+
+func (bb *_BandwidthBudget) consume(n int) {
+	bb.tokens -= n // tokens can be negative if essential data is sent over budget
+}
+
+func (bb *_BandwidthBudget) isDelaying() bool {
+	return len(bb.delayedPackets) > 0
+}
+
+// shouldDelay returns if the packet should be delayed instead of sent now
+func (bb *_BandwidthBudget) shouldDelay(size int, priority _SendPriority, now time.Time) bool {
+	if bb.isDelaying() {
+		return true // keep the order of packets
+	}
+	return bb.delayPolicy && priority == _SEND_PRIORITY_OTHER_ATTR && bb.available(now) < size
+}
+
+func (bb *_BandwidthBudget) delay(packet *netutil.Packet) {
+	packet.AddRefCount(1)
+	bb.delayedPackets = append(bb.delayedPackets, packet)
+	bb.delayedBytes += int(packet.GetPayloadLen())
+}
+
+// popSendablePackets pops delayed packets that can be sent within budget
+//
+// All delayed packets are popped if delayed bytes exceed the budget of 1 second, so that delayed packets do not grow unboundedly.
+func (bb *_BandwidthBudget) popSendablePackets(now time.Time) (packets []*netutil.Packet) {
+	force := bb.delayedBytes > bb.bytesPerSec
+	available := bb.available(now)
+	n := 0
+	for _, packet := range bb.delayedPackets {
+		size := int(packet.GetPayloadLen())
+		if !force && available < size {
+			break
+		}
+		available -= size
+		bb.consume(size)
+		bb.delayedBytes -= size
+		n += 1
+	}
+
+	packets = bb.delayedPackets[:n]
+	bb.delayedPackets = bb.delayedPackets[n:]
+	if len(bb.delayedPackets) == 0 {
+		bb.delayedPackets = nil
+	}
+	return
+}
+
+func (bb *_BandwidthBudget) releaseDelayedPackets() {
+	for _, packet := range bb.delayedPackets {
+		packet.Release()
+	}
+	bb.delayedPackets = nil
+	bb.delayedBytes = 0
+}
+
+func (bb *_BandwidthBudget) setOwnPosition(syncInfo []byte) {
+	bb.ownPosition = readSyncInfo(syncInfo)
+	bb.hasOwnPosition = true
+}
+
+// fitSyncData drops sync entries of far-away entities so that the packet of sync data fits in the budget
+//
+// syncData consists of entries of entity ID and sync info, the sync entry of owner entity is never dropped. The budget
+// is consumed when the packet is sent by ClientProxy.sendWithBudget.
+func (bb *_BandwidthBudget) fitSyncData(syncData []byte, ownerEntityID common.EntityID, now time.Time) []byte {
+	for i := 0; i < len(syncData); i += _SYNC_ENTRY_SIZE {
+		if common.EntityID(syncData[i:i+common.ENTITYID_LENGTH]) == ownerEntityID {
+			bb.setOwnPosition(syncData[i+common.ENTITYID_LENGTH : i+_SYNC_ENTRY_SIZE])
+			break
+		}
+	}
+
+	available := bb.available(now) - 2 // 2 bytes for message type
+	if len(syncData) <= available {
+		return syncData
+	}
+
+	type syncEntry struct {
+		data     []byte
+		distance float32 // square of distance to the owner entity
+	}
+	entries := make([]syncEntry, 0, len(syncData)/_SYNC_ENTRY_SIZE)
+	for i := 0; i < len(syncData); i += _SYNC_ENTRY_SIZE {
+		entry := syncEntry{data: syncData[i : i+_SYNC_ENTRY_SIZE]}
+		if common.EntityID(entry.data[:common.ENTITYID_LENGTH]) == ownerEntityID {
+			entry.distance = -1 // own entity first
+		} else if bb.hasOwnPosition {
+			pos := readSyncInfo(entry.data[common.ENTITYID_LENGTH:])
+			dx, dy, dz := pos.X-bb.ownPosition.X, pos.Y-bb.ownPosition.Y, pos.Z-bb.ownPosition.Z
+			entry.distance = dx*dx + dy*dy + dz*dz
+		}
+		entries = append(entries, entry)
+	}
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].distance < entries[j].distance
+	})
+
+	fitData := make([]byte, 0, len(syncData))
+	for _, entry := range entries {
+		if len(fitData)+_SYNC_ENTRY_SIZE > available && entry.distance >= 0 {
+			break
+		}
+		fitData = append(fitData, entry.data...)
+	}
+	return fitData
+}
+
+func readSyncInfo(data []byte) proto.EntitySyncInfo {
+	readFloat32 := func(i int) float32 {
+		return math.Float32frombits(netutil.NETWORK_ENDIAN.Uint32(data[i : i+4]))
+	}
+	return proto.EntitySyncInfo{
+		X:   readFloat32(0),
+		Y:   readFloat32(4),
+		Z:   readFloat32(8),
+		Yaw: readFloat32(12),
+	}
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"math"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func newTestPacket(size int) *netutil.Packet {
+	packet := netutil.NewPacket()
+	packet.AppendBytes(make([]byte, size))
+	return packet
+}
+
+func syncEntry(eid common.EntityID, x float32) []byte {
+	data := []byte(eid)
+	info := make([]byte, proto.SYNC_INFO_SIZE_PER_ENTITY)
+	netutil.NETWORK_ENDIAN.PutUint32(info[0:4], math.Float32bits(x))
+	return append(data, info...)
+}
+
+func TestBandwidthBudgetRefill(t *testing.T) {
+	bb := newBandwidthBudget(1000, _BANDWIDTH_POLICY_DROP)
+	now := bb.lastRefillTime
+	bb.consume(1000)
+	if n := bb.available(now); n != 0 {
+		t.Fatalf("available should be 0 after consuming all, but is %d", n)
+	}
+	if n := bb.available(now.Add(time.Millisecond * 500)); n != 500 {
+		t.Errorf("available should be 500 after half second, but is %d", n)
+	}
+	if n := bb.available(now.Add(time.Second * 5)); n != 1000 {
+		t.Errorf("available should be capped to the budget of 1 second, but is %d", n)
+	}
+	if n := bb.available(now); n != 1000 {
+		t.Errorf("available should not change if time goes backwards, but is %d", n)
+	}
+
+	bb.consume(1500) // essential data can be sent over budget
+	if n := bb.available(now.Add(time.Second * 5).Add(time.Millisecond * 500)); n != 0 {
+		t.Errorf("available should be 0 after refilling the debt, but is %d", n)
+	}
+}
+
+func TestBandwidthBudgetPriority(t *testing.T) {
+	bb := newBandwidthBudget(100, _BANDWIDTH_POLICY_DELAY)
+	now := bb.lastRefillTime
+	if bb.shouldDelay(50, _SEND_PRIORITY_OTHER_ATTR, now) {
+		t.Errorf("attr changes of other entities should not be delayed within budget")
+	}
+	if !bb.shouldDelay(150, _SEND_PRIORITY_OTHER_ATTR, now) {
+		t.Errorf("attr changes of other entities should be delayed over budget using delay policy")
+	}
+	if bb.shouldDelay(150, _SEND_PRIORITY_ESSENTIAL, now) || bb.shouldDelay(150, _SEND_PRIORITY_POSITION, now) {
+		t.Errorf("essential data and position syncs should not be delayed by itself")
+	}
+	if newBandwidthBudget(100, _BANDWIDTH_POLICY_DROP).shouldDelay(150, _SEND_PRIORITY_OTHER_ATTR, now) {
+		t.Errorf("nothing should be delayed using drop policy")
+	}
+
+	packet := newTestPacket(150)
+	defer packet.Release()
+	bb.delay(packet)
+	for _, priority := range []_SendPriority{_SEND_PRIORITY_POSITION, _SEND_PRIORITY_OTHER_ATTR, _SEND_PRIORITY_ESSENTIAL} {
+		if !bb.shouldDelay(1, priority, now) {
+			t.Errorf("packets of priority %d should be delayed after delayed packets to keep the order", priority)
+		}
+	}
+	bb.releaseDelayedPackets()
+}
+
+func TestBandwidthBudgetDelayedPackets(t *testing.T) {
+	bb := newBandwidthBudget(100, _BANDWIDTH_POLICY_DELAY)
+	now := bb.lastRefillTime
+	bb.consume(100)
+	for i := 0; i < 3; i++ {
+		packet := newTestPacket(30)
+		bb.delay(packet)
+		packet.Release()
+	}
+
+	if packets := bb.popSendablePackets(now); len(packets) != 0 {
+		t.Fatalf("no packet should be sent without budget, but %d are sent", len(packets))
+	}
+	packets := bb.popSendablePackets(now.Add(time.Millisecond * 700))
+	if len(packets) != 2 || !bb.isDelaying() {
+		t.Fatalf("2 packets should be sent within budget, but %d are sent", len(packets))
+	}
+	for _, packet := range packets {
+		packet.Release()
+	}
+	if n := bb.available(now.Add(time.Millisecond * 700)); n != 10 {
+		t.Errorf("sent packets should consume the budget, but available is %d", n)
+	}
+
+	// overflow: all delayed packets are sent if delayed bytes exceed the budget of 1 second
+	for i := 0; i < 4; i++ {
+		packet := newTestPacket(30)
+		bb.delay(packet)
+		packet.Release()
+	}
+	packets = bb.popSendablePackets(now.Add(time.Millisecond * 700))
+	if len(packets) != 5 || bb.isDelaying() || bb.delayedBytes != 0 {
+		t.Fatalf("all 5 delayed packets should be sent on overflow, but %d are sent", len(packets))
+	}
+	for _, packet := range packets {
+		packet.Release()
+	}
+}
+
+func TestBandwidthBudgetFitSyncData(t *testing.T) {
+	owner, near, far := common.GenEntityID(), common.GenEntityID(), common.GenEntityID()
+	var syncData []byte
+	syncData = append(syncData, syncEntry(far, 100)...)
+	syncData = append(syncData, syncEntry(owner, 0)...)
+	syncData = append(syncData, syncEntry(near, 1)...)
+
+	// the packet of all entries and the message type fits in the budget
+	bb := newBandwidthBudget(len(syncData)+2, _BANDWIDTH_POLICY_DROP)
+	now := bb.lastRefillTime
+	if fitData := bb.fitSyncData(syncData, owner, now); len(fitData) != len(syncData) {
+		t.Errorf("all sync entries should fit in budget, but %d bytes are kept", len(fitData))
+	}
+	if n := bb.available(now); n != len(syncData)+2 {
+		t.Errorf("fitSyncData should not consume the budget, but available is %d", n)
+	}
+
+	// the message type is reserved, so that the far entity is dropped
+	bb = newBandwidthBudget(len(syncData)+1, _BANDWIDTH_POLICY_DROP)
+	fitData := bb.fitSyncData(syncData, owner, now)
+	if len(fitData) != _SYNC_ENTRY_SIZE*2 || common.EntityID(fitData[:common.ENTITYID_LENGTH]) != owner ||
+		common.EntityID(fitData[_SYNC_ENTRY_SIZE:_SYNC_ENTRY_SIZE+common.ENTITYID_LENGTH]) != near {
+		t.Errorf("the far entity should be dropped, and the owner should be first")
+	}
+
+	// the owner is never dropped
+	bb = newBandwidthBudget(1, _BANDWIDTH_POLICY_DROP)
+	if fitData := bb.fitSyncData(syncData, owner, now); len(fitData) != _SYNC_ENTRY_SIZE || common.EntityID(fitData[:common.ENTITYID_LENGTH]) != owner {
+		t.Errorf("only the owner should be kept without budget, but %d bytes are kept", len(fitData))
+	}
+}
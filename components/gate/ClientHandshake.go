@@ -0,0 +1,251 @@
+package main
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/banlist"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/webhook"
+)
+
+const (
+	_REJECT_CLOSE_DELAY = time.Second // delay closing the rejected client, so that the reject reason can be sent
+)
+
+// startClientSession creates the boot entity for the client, which is called after the client handshake is completed
+func (gs *GateService) startClientSession(cp *ClientProxy) {
+	bootEntityID := common.GenEntityID() // generate boot entity ID in the gate
+	cp.ownerEntityID = bootEntityID
+	dispatchercluster.SelectByEntityID(bootEntityID).SendNotifyClientConnected(cp.clientid, bootEntityID, cp.sessionToken, cp.geoTag, cp.authID, cp.world)
+	if cp.supportsMsgType(proto.MT_SET_CLIENT_SESSION_TOKEN) {
+		cp.SendSetClientSessionToken(cp.sessionToken)
+	}
+}
+
+// isClientPacketAllowedBeforeHandshake returns if the message type can be sent by clients before the handshake is completed
+func isClientPacketAllowedBeforeHandshake(msgtype proto.MsgType) bool {
+	return msgtype == proto.MT_PROTOCOL_VERSION_FROM_CLIENT || msgtype == proto.MT_AUTH_FROM_CLIENT || msgtype == proto.MT_HEARTBEAT_FROM_CLIENT ||
+		msgtype == proto.MT_DEVICE_ID_FROM_CLIENT || msgtype == proto.MT_WORLD_FROM_CLIENT
+}
+
+// tryCompleteHandshake starts the client session if the client is authenticated and its protocol version is accepted
+func (gs *GateService) tryCompleteHandshake(cp *ClientProxy) {
+	if gs.authVerifier != nil && !cp.authenticated {
+		return
+	}
+	if gs.minClientProtocolVersion > proto.CLIENT_PROTOCOL_VERSION_1 && !cp.protocolVersionReceived {
+		return
+	}
+	if gs.requirePacketIntegrity && !cp.packetIntegrity {
+		return
+	}
+	if len(gs.worlds) > 0 && !cp.worldReceived {
+		return
+	}
+
+	delete(gs.handshakingClientProxies, cp.clientid)
+	cp.handshaked = true
+	gs.startClientSession(cp)
+}
+
+func (gs *GateService) handleProtocolVersionFromClient(cp *ClientProxy, pkt *netutil.Packet) {
+	if cp.protocolVersionReceived {
+		gwlog.Warnf("%s: %s sent protocol version again", gs, cp)
+		return
+	}
+
+	version := pkt.ReadUint16()
+	cp.protocolVersionReceived = true
+	if version < gs.minClientProtocolVersion {
+		gwlog.Warnf("%s: %s protocol version %d is older than %d, rejected", gs, cp, version, gs.minClientProtocolVersion)
+		gs.rejectHandshakingClient(cp, "protocol_version")
+		cp.SendSetClientProtocolVersion(false, gs.minClientProtocolVersion)
+		return
+	}
+
+	// newer clients should be compatible with the latest protocol version of gate
+	if version > proto.CLIENT_PROTOCOL_VERSION {
+		version = proto.CLIENT_PROTOCOL_VERSION
+	}
+	cp.protocolVersion = version
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s: %s uses protocol version %d", gs, cp, version)
+	}
+	cp.SendSetClientProtocolVersion(true, version)
+
+	if cp.handshaked {
+		// the session is already started as a protocol version 1 client, so send what was skipped
+		if cp.supportsMsgType(proto.MT_SET_CLIENT_SESSION_TOKEN) {
+			cp.SendSetClientSessionToken(cp.sessionToken)
+		}
+		return
+	}
+	if _, ok := gs.handshakingClientProxies[cp.clientid]; ok {
+		gs.tryCompleteHandshake(cp)
+	}
+}
+
+func (gs *GateService) handleAuthFromClient(cp *ClientProxy, pkt *netutil.Packet) {
+	if gs.authVerifier == nil {
+		gwlog.Warnf("%s: %s sent auth token, but authentication is disabled", gs, cp)
+		return
+	}
+	if _, ok := gs.handshakingClientProxies[cp.clientid]; !ok || cp.authenticated || cp.authenticating {
+		gwlog.Warnf("%s: %s is already authenticated, authenticating or rejected", gs, cp)
+		return
+	}
+
+	token := pkt.ReadVarStr()
+	cp.authenticating = true
+	go func() {
+		authID, err := gs.authVerifier.Verify(token)
+		post.Post(func() {
+			gs.onClientAuthResult(cp, authID, err)
+		})
+	}()
+}
+
+func (gs *GateService) onClientAuthResult(cp *ClientProxy, authID string, err error) {
+	cp.authenticating = false
+	if _, ok := gs.handshakingClientProxies[cp.clientid]; !ok {
+		return // client already closed or rejected
+	}
+
+	if err != nil {
+		gwlog.Warnf("%s: %s authentication failed: %s", gs, cp, err)
+		gs.rejectHandshakingClient(cp, "auth")
+		cp.SendAuthResultOnClient(false, "authentication failed")
+		return
+	}
+
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s: %s authenticated as %s", gs, cp, authID)
+	}
+	if ban, ok := gs.banList.Find(banlist.KindAccount, authID); ok {
+		gs.rejectBannedClient(cp, ban)
+		cp.SendAuthResultOnClient(false, "banned: "+ban.Reason)
+		return
+	}
+
+	cp.authenticated = true
+	cp.authID = authID
+	if gs.clientRecorder.isSelected(cp) {
+		gs.clientRecorder.startRecording(cp)
+	}
+	cp.SendAuthResultOnClient(true, "")
+	gs.tryCompleteHandshake(cp)
+}
+
+// handleDeviceIDFromClient rejects the client if its device is banned
+func (gs *GateService) handleDeviceIDFromClient(cp *ClientProxy, pkt *netutil.Packet) {
+	deviceID := pkt.ReadVarStr()
+	if cp.deviceID != "" {
+		gwlog.Warnf("%s: %s already sent device ID %s", gs, cp, cp.deviceID)
+		return
+	}
+
+	cp.deviceID = deviceID
+	if ban, ok := gs.banList.Find(banlist.KindDevice, deviceID); ok {
+		gs.rejectBannedClient(cp, ban)
+	}
+}
+
+// handleWorldFromClient sets the world of the client, and rejects the client if the world is not hosted by the cluster
+func (gs *GateService) handleWorldFromClient(cp *ClientProxy, pkt *netutil.Packet) {
+	world := pkt.ReadVarStr()
+	if _, ok := gs.handshakingClientProxies[cp.clientid]; !ok || cp.worldReceived {
+		gwlog.Warnf("%s: %s sent world %s after handshake or rejected", gs, cp, world)
+		return
+	}
+	if len(gs.worlds) == 0 {
+		gwlog.Warnf("%s: %s sent world %s, but no worlds are configured", gs, cp, world)
+		return
+	}
+
+	if !gs.worlds.Contains(world) {
+		gwlog.Warnf("%s: %s chose unknown world %s, rejected", gs, cp, world)
+		gs.rejectHandshakingClient(cp, "world")
+		return
+	}
+
+	cp.world = world
+	cp.worldReceived = true
+	gs.tryCompleteHandshake(cp)
+}
+
+// onClientPacketIntegrity is called after the key exchange of the client if packet integrity is required, ok is false if
+// no cipher format is negotiated
+func (gs *GateService) onClientPacketIntegrity(cp *ClientProxy, ok bool) {
+	if _, handshaking := gs.handshakingClientProxies[cp.clientid]; !handshaking {
+		return // client already closed, rejected or exchanging keys again
+	}
+
+	if !ok {
+		gwlog.Warnf("%s: %s negotiated no cipher format, but packet integrity is required, rejected", gs, cp)
+		gs.rejectHandshakingClient(cp, "integrity")
+		return
+	}
+
+	cp.packetIntegrity = true
+	gs.tryCompleteHandshake(cp)
+}
+
+// onClientIntegrityViolation reports the client packet failing integrity checks to webhooks
+func (gs *GateService) onClientIntegrityViolation(cp *ClientProxy, reason string) {
+	if len(config.GetWebhook().URLs) == 0 {
+		return
+	}
+
+	webhook.PostEvent(cp.ownerEntityID, "", "client_integrity_violation", map[string]interface{}{
+		"clientid": cp.clientid,
+		"addr":     cp.RemoteAddr().String(),
+		"auth_id":  cp.authID,
+		"reason":   reason,
+	})
+}
+
+// rejectHandshakingClient closes the client after the reject reason is sent
+func (gs *GateService) rejectHandshakingClient(cp *ClientProxy, reason string) {
+	delete(gs.handshakingClientProxies, cp.clientid)
+	gs.metrics.rejects.WithLabelValues(cp.transport, reason).Inc()
+	time.AfterFunc(_REJECT_CLOSE_DELAY, func() {
+		cp.Close()
+	})
+}
+
+// checkHandshakeTimeouts rejects clients which do not complete the handshake in time
+func (gs *GateService) checkHandshakeTimeouts() {
+	if len(gs.handshakingClientProxies) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for _, cp := range gs.handshakingClientProxies {
+		if !now.After(cp.handshakeDeadline) {
+			continue
+		}
+
+		if gs.authVerifier != nil && !cp.authenticated {
+			gwlog.Warnf("%s: %s authentication timeout", gs, cp)
+			gs.rejectHandshakingClient(cp, "auth")
+			cp.SendAuthResultOnClient(false, "authentication timeout")
+		} else if gs.requirePacketIntegrity && !cp.packetIntegrity {
+			gwlog.Warnf("%s: %s key exchange timeout", gs, cp)
+			gs.rejectHandshakingClient(cp, "integrity")
+		} else if len(gs.worlds) > 0 && !cp.worldReceived {
+			gwlog.Warnf("%s: %s world timeout", gs, cp)
+			gs.rejectHandshakingClient(cp, "world")
+		} else {
+			// clients not sending protocol version are too old to understand the reject reason
+			gwlog.Warnf("%s: %s protocol version timeout", gs, cp)
+			gs.rejectHandshakingClient(cp, "protocol_version")
+		}
+	}
+}
@@ -2,15 +2,19 @@ package main
 
 import (
 	"fmt"
+	"os"
+	"strings"
+	"sync"
 
 	"time"
 
+	"github.com/xiaonanln/goworld/engine/clientrecord"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
-	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwioutil"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 )
@@ -28,19 +32,54 @@ func (info *clientSyncInfo) IsEmpty() bool {
 // ClientProxy is a game client connections managed by gate
 type ClientProxy struct {
 	*proto.GoWorldConnection
-	clientid       common.ClientID
-	filterProps    map[string]string
-	clientSyncInfo clientSyncInfo
-	heartbeatTime  time.Time
-	ownerEntityID  common.EntityID // owner entity's ID
+	cfg                     *config.GateConfig
+	clientid                common.ClientID
+	sessionToken            string // token for resuming the client session after reconnecting
+	filterProps             map[string]string
+	clientSyncInfo          clientSyncInfo
+	heartbeatTime           time.Time
+	ownerEntityID           common.EntityID // owner entity's ID
+	floodGuard              *_FloodGuard
+	pingSeq                 uint64            // sequence number of the last ping sent to client
+	pingSendTime            time.Time         // send time of the last ping, zero if it is already replied
+	latency                 time.Duration     // smoothed round trip time measured by ping
+	reportedLatency         time.Duration     // latency last notified to the owner entity
+	geoTag                  string            // GeoIP tag of the client address
+	bandwidth               *_BandwidthBudget // nil if client send budget is unlimited
+	transport               string
+	handshaked              bool      // client session is started after the handshake is completed
+	handshakeDeadline       time.Time // client is closed if the handshake is not completed before deadline
+	protocolVersion         uint16    // negotiated protocol version
+	protocolVersionReceived bool      // client sent its protocol version
+	packetIntegrity         bool      // packets from client are authenticated by the negotiated cipher format
+	keyExchanged            bool      // a cipher format is negotiated by key exchange, accessed by the receiving goroutine
+	authenticated           bool
+	authenticating          bool   // auth token is being verified
+	authID                  string // ID authenticated by auth verifier
+	deviceID                string // device ID sent by client
+	world                   string // world chosen by client
+	worldReceived           bool
+	recordLock              sync.Mutex
+	recordFile              *os.File
+	recordWriter            *clientrecord.Writer // nil if the client is not recorded
 }
 
 func newClientProxy(conn netutil.Connection, cfg *config.GateConfig) *ClientProxy {
 	gwc := proto.NewGoWorldConnection(netutil.NewBufferedConnection(conn), cfg.CompressConnection, cfg.CompressFormat)
+	gwc.SetMaxRecvPayloadLength(uint32(cfg.MaxClientPacketSize))
+	var bandwidth *_BandwidthBudget
+	if cfg.ClientSendBudget > 0 {
+		bandwidth = newBandwidthBudget(cfg.ClientSendBudget, cfg.BandwidthPolicy)
+	}
 	return &ClientProxy{
 		GoWorldConnection: gwc,
+		cfg:               cfg,
 		clientid:          common.GenClientID(), // each client has its unique clientid
+		sessionToken:      common.GenSessionToken(),
 		filterProps:       map[string]string{},
+		floodGuard:        newFloodGuard(cfg),
+		bandwidth:         bandwidth,
+		protocolVersion:   proto.CLIENT_PROTOCOL_VERSION_1, // clients not sending protocol version use the original protocol
 	}
 }
 
@@ -48,13 +87,11 @@ func (cp *ClientProxy) String() string {
 	return fmt.Sprintf("ClientProxy<%s@%s>", cp.clientid, cp.RemoteAddr())
 }
 
-//func (cp *ClientProxy) SendPacket(packet *netutil.Packet) error {
-//	err := cp.GoWorldConnection.SendPacket(packet)
-//	if err != nil {
-//		return err
-//	}
-//	return cp.Flush("ClientProxy")
-//}
+// SendPacket sends packet to client, packet size is recorded in gate metrics
+func (cp *ClientProxy) SendPacket(packet *netutil.Packet) error {
+	gateService.metrics.sentPacketSize.Observe(float64(packet.GetPayloadLen()))
+	return cp.GoWorldConnection.SendPacket(packet)
+}
 
 func (cp *ClientProxy) serve() {
 	defer func() {
@@ -71,14 +108,56 @@ func (cp *ClientProxy) serve() {
 		}
 	}()
 
-	cp.SetAutoFlush(consts.CLIENT_PROXY_WRITE_FLUSH_INTERVAL)
+	cp.SetAutoFlush(time.Millisecond * time.Duration(cp.cfg.ClientFlushIntervalMS))
 	//cp.SendSetClientClientID(cp.cp) // set the cp on the client side
 
 	for {
 		var msgtype proto.MsgType
 		pkt, err := cp.Recv(&msgtype)
 		if pkt != nil {
+			cp.record(false, msgtype, pkt.UnreadPayload())
+			gateService.metrics.recvPacketSize.Observe(float64(pkt.GetPayloadLen()))
+			if reason := cp.floodGuard.onRecvPacket(pkt.GetPayloadLen(), time.Now()); reason != "" {
+				pkt.Release()
+				cp.onFlood(reason)
+				break
+			}
+			if err := proto.CheckClientPacket(msgtype, pkt.UnreadPayload()); err != nil {
+				gwlog.Warnf("%s sent malformed packet: %s, closing", cp, err)
+				pkt.Release()
+				break
+			}
+			if cp.cfg.RequireKeyExchange && !cp.keyExchanged && !isClientPacketAllowedBeforeKeyExchange(msgtype) {
+				gwlog.Warnf("%s sent packet %d before key exchange, closing", cp, msgtype)
+				pkt.Release()
+				break
+			}
+		}
+
+		if pkt != nil && msgtype == proto.MT_NEGOTIATE_COMPRESSION_FROM_CLIENT {
+			// handle compression negotiation in the receiving goroutine, so that decompression can be switched in time
+			cp.handleNegotiateCompression(pkt)
+			pkt.Release()
+		} else if pkt != nil && msgtype == proto.MT_KEY_EXCHANGE_FROM_CLIENT {
+			// handle key exchange in the receiving goroutine, so that decryption can be switched in time
+			ok := cp.handleKeyExchange(pkt)
+			pkt.Release()
+			if !ok {
+				break
+			}
+		} else if pkt != nil && msgtype == proto.MT_PONG_FROM_CLIENT {
+			// measure round trip time in the receiving goroutine, so that it is not delayed by the packet queue
+			cp.handlePong(pkt)
+			pkt.Release()
+		} else if pkt != nil {
 			gateService.clientPacketQueue <- clientProxyMessage{cp, proto.Message{msgtype, pkt}}
+		} else if ie, ok := err.(*netutil.IntegrityError); ok {
+			if !cp.onIntegrityViolation(ie) {
+				break
+			}
+		} else if err == netutil.ErrPayloadTooLarge {
+			cp.onFlood("packet too large")
+			break
 		} else if err != nil && !gwioutil.IsTimeoutError(err) {
 			if netutil.IsConnectionError(err) {
 				break
@@ -88,3 +167,166 @@ func (cp *ClientProxy) serve() {
 		}
 	}
 }
+
+// sendWithBudget sends the packet within bandwidth budget, returns true if the packet is delayed
+//
+// sendWithBudget is called in the gate service goroutine
+func (cp *ClientProxy) sendWithBudget(packet *netutil.Packet, priority _SendPriority) bool {
+	if cp.bandwidth == nil {
+		cp.SendPacket(packet)
+		return false
+	}
+
+	size := int(packet.GetPayloadLen())
+	if cp.bandwidth.shouldDelay(size, priority, time.Now()) {
+		cp.bandwidth.delay(packet)
+		return true
+	}
+
+	cp.bandwidth.consume(size)
+	cp.SendPacket(packet)
+	return false
+}
+
+// flushDelayedPackets sends delayed packets within bandwidth budget, returns true if there are still delayed packets
+func (cp *ClientProxy) flushDelayedPackets(now time.Time) bool {
+	for _, packet := range cp.bandwidth.popSendablePackets(now) {
+		cp.SendPacket(packet)
+		packet.Release()
+	}
+	return cp.bandwidth.isDelaying()
+}
+
+// onFlood is called in the receiving goroutine when the client exceeds flood limits
+func (cp *ClientProxy) onFlood(reason string) {
+	gwlog.Warnf("%s is flooding: %s", cp, reason)
+	// flood notification is posted before the client proxy is closed, and the client is banned in the gate routine,
+	// since its account and device are set in the gate routine
+	post.Post(func() {
+		gateService.banFloodingClient(cp, reason)
+		gateService.onClientProxyFlood(cp, reason)
+	})
+}
+
+// onIntegrityViolation is called in the receiving goroutine when a client packet fails integrity checks, the packet is
+// already discarded, returns false if the client should be closed
+func (cp *ClientProxy) onIntegrityViolation(ie *netutil.IntegrityError) bool {
+	gateService.metrics.integrityViolations.WithLabelValues(ie.Reason).Inc()
+	switch cp.cfg.PacketIntegrityViolation {
+	case "drop":
+		gwlog.Debugf("%s: %s, dropped", cp, ie)
+		return true
+	case "report":
+		gwlog.Warnf("%s: %s, reported", cp, ie)
+		post.Post(func() {
+			gateService.onClientIntegrityViolation(cp, ie.Reason)
+		})
+		return true
+	default:
+		gwlog.Warnf("%s: %s, closing", cp, ie)
+		return false
+	}
+}
+
+func (cp *ClientProxy) handlePong(pkt *netutil.Packet) {
+	seq := pkt.ReadUint64()
+	recvTime := time.Now()
+	post.Post(func() {
+		gateService.onClientPong(cp, seq, recvTime)
+	})
+}
+
+func (cp *ClientProxy) handleNegotiateCompression(pkt *netutil.Packet) {
+	clientFormats := pkt.ReadStringList()
+	compressFormat := ""
+	for _, format := range cp.cfg.CompressFormats {
+		if formatSupported(format, clientFormats) {
+			compressFormat = format
+			break
+		}
+	}
+
+	threshold := uint32(cp.cfg.CompressThreshold)
+	gwlog.Debugf("%s negotiate compression: client formats %v, use %#v, threshold %d", cp, clientFormats, compressFormat, threshold)
+	// client switches compression when receiving MT_SET_CLIENT_COMPRESSION, so the gate switches right after sending it
+	cp.SendSetClientCompression(compressFormat, threshold)
+	cp.SetCompression(compressFormat, threshold)
+	cp.SetDecompression(compressFormat)
+}
+
+// isClientPacketAllowedBeforeKeyExchange returns if the message type can be sent by clients before the key exchange if
+// require_key_exchange is enabled
+func isClientPacketAllowedBeforeKeyExchange(msgtype proto.MsgType) bool {
+	return msgtype == proto.MT_PROTOCOL_VERSION_FROM_CLIENT || msgtype == proto.MT_NEGOTIATE_COMPRESSION_FROM_CLIENT ||
+		msgtype == proto.MT_KEY_EXCHANGE_FROM_CLIENT || msgtype == proto.MT_HEARTBEAT_FROM_CLIENT
+}
+
+// handleKeyExchange exchanges keys with the client and enables packet encryption in both directions, returns false if
+// the client should be closed
+func (cp *ClientProxy) handleKeyExchange(pkt *netutil.Packet) bool {
+	clientFormats := pkt.ReadStringList()
+	clientPublicKey := pkt.ReadVarBytes()
+	cipherFormat := ""
+	for _, format := range cp.cfg.CipherFormats {
+		if formatSupported(format, clientFormats) {
+			cipherFormat = format
+			break
+		}
+	}
+
+	gwlog.Debugf("%s key exchange: client formats %v, use %#v", cp, clientFormats, cipherFormat)
+	if cipherFormat == "" {
+		cp.SendSetClientCipher("", nil, nil)
+		if cp.cfg.RequireKeyExchange {
+			gwlog.Warnf("%s negotiated no cipher format, but key exchange is required, closing", cp)
+			return false
+		}
+		if cp.cfg.RequirePacketIntegrity {
+			post.Post(func() {
+				gateService.onClientPacketIntegrity(cp, false)
+			})
+		}
+		return true
+	}
+
+	keyPair, err := crypt.GenerateKeyPair()
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	sendKey, recvKey, err := keyPair.DeriveKeys(clientPublicKey, false)
+	if err != nil {
+		gwlog.Errorf("%s key exchange failed: %v", cp, err)
+		return false
+	}
+	var signature []byte
+	if gateService.keyExchangeSigner != nil {
+		if signature, err = crypt.SignKeyExchange(gateService.keyExchangeSigner, cipherFormat, clientPublicKey, keyPair.PublicKey[:]); err != nil {
+			gwlog.Panic(err)
+		}
+	}
+
+	// client switches encryption when receiving MT_SET_CLIENT_CIPHER, so the gate switches right after sending it
+	cp.SendSetClientCipher(cipherFormat, keyPair.PublicKey[:], signature)
+	if err := cp.SetEncryption(cipherFormat, sendKey); err != nil {
+		gwlog.Panic(err)
+	}
+	if err := cp.SetDecryption(cipherFormat, recvKey); err != nil {
+		gwlog.Panic(err)
+	}
+	cp.keyExchanged = true
+	if cp.cfg.RequirePacketIntegrity {
+		post.Post(func() {
+			gateService.onClientPacketIntegrity(cp, true)
+		})
+	}
+	return true
+}
+
+func formatSupported(format string, formats []string) bool {
+	for _, f := range formats {
+		if strings.EqualFold(f, format) {
+			return true
+		}
+	}
+	return false
+}
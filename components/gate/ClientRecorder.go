@@ -0,0 +1,145 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/clientrecord"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// _ClientRecorder selects clients to record by IPs or auth IDs, it is only accessed in the gate routine
+//
+// Clients are selected when connected or authenticated, and recorded until closed.
+type _ClientRecorder struct {
+	dir     string
+	ips     map[string]time.Time // IP -> expire time of the selection, zero if never expires
+	authIDs map[string]time.Time // auth ID -> expire time of the selection, zero if never expires
+}
+
+func newClientRecorder(dir string) *_ClientRecorder {
+	return &_ClientRecorder{
+		dir:     dir,
+		ips:     map[string]time.Time{},
+		authIDs: map[string]time.Time{},
+	}
+}
+
+// selectClients selects clients of the IP or the auth ID to record for the duration, 0 to record until unselected
+func (r *_ClientRecorder) selectClients(ip string, authID string, duration time.Duration) {
+	var expireTime time.Time
+	if duration > 0 {
+		expireTime = time.Now().Add(duration)
+	}
+	if ip != "" {
+		r.ips[ip] = expireTime
+	}
+	if authID != "" {
+		r.authIDs[authID] = expireTime
+	}
+}
+
+// unselectClients stops selecting clients of the IP or the auth ID
+func (r *_ClientRecorder) unselectClients(ip string, authID string) {
+	delete(r.ips, ip)
+	delete(r.authIDs, authID)
+}
+
+// isSelected returns if the client should be recorded
+func (r *_ClientRecorder) isSelected(cp *ClientProxy) bool {
+	return r.checkSelection(r.ips, getAddrIP(cp.RemoteAddr())) || (cp.authID != "" && r.checkSelection(r.authIDs, cp.authID))
+}
+
+func (r *_ClientRecorder) checkSelection(selection map[string]time.Time, key string) bool {
+	expireTime, ok := selection[key]
+	if !ok {
+		return false
+	}
+	if !expireTime.IsZero() && time.Now().After(expireTime) {
+		delete(selection, key)
+		return false
+	}
+	return true
+}
+
+// startRecording starts recording packets received by the client
+func (r *_ClientRecorder) startRecording(cp *ClientProxy) {
+	if cp.isRecording() {
+		return
+	}
+	if err := os.MkdirAll(r.dir, 0755); err != nil {
+		gwlog.Errorf("%s: create record dir %s failed: %s", cp, r.dir, err)
+		return
+	}
+
+	now := time.Now()
+	name := fmt.Sprintf("gate%d_%s_%s%s", args.gateid, cp.clientid, now.Format("20060102150405"), clientrecord.FileExt)
+	file, err := os.Create(filepath.Join(r.dir, name))
+	if err != nil {
+		gwlog.Errorf("%s: create record file failed: %s", cp, err)
+		return
+	}
+	writer, err := clientrecord.NewWriter(file, clientrecord.Header{
+		GateID:     args.gateid,
+		ClientID:   cp.clientid,
+		RemoteAddr: cp.RemoteAddr().String(),
+		AuthID:     cp.authID,
+		StartTime:  now,
+	})
+	if err != nil {
+		gwlog.Errorf("%s: write record file failed: %s", cp, err)
+		file.Close()
+		return
+	}
+
+	gwlog.Infof("%s: recording to %s", cp, file.Name())
+	cp.recordLock.Lock()
+	cp.recordFile, cp.recordWriter = file, writer
+	cp.recordLock.Unlock()
+}
+
+func (cp *ClientProxy) isRecording() bool {
+	cp.recordLock.Lock()
+	recording := cp.recordWriter != nil
+	cp.recordLock.Unlock()
+	return recording
+}
+
+// record writes the packet to the record of the client if it is recorded
+//
+// Records are flushed immediately, so that records are complete when gates crash.
+func (cp *ClientProxy) record(outbound bool, msgtype proto.MsgType, payload []byte) {
+	cp.recordLock.Lock()
+	defer cp.recordLock.Unlock()
+	if cp.recordWriter == nil {
+		return
+	}
+
+	err := cp.recordWriter.Write(time.Now(), outbound, msgtype, payload)
+	if err == nil {
+		err = cp.recordWriter.Flush()
+	}
+	if err != nil {
+		gwlog.Errorf("%s: write record failed: %s", cp, err)
+		cp.closeRecord()
+	}
+}
+
+// stopRecording stops recording the client
+func (cp *ClientProxy) stopRecording() {
+	cp.recordLock.Lock()
+	if cp.recordWriter != nil {
+		gwlog.Infof("%s: recording stopped", cp)
+		cp.closeRecord()
+	}
+	cp.recordLock.Unlock()
+}
+
+func (cp *ClientProxy) closeRecord() {
+	cp.recordWriter.Flush()
+	cp.recordFile.Close()
+	cp.recordFile, cp.recordWriter = nil, nil
+}
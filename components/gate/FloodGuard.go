@@ -0,0 +1,63 @@
+package main
+
+import (
+	"fmt"
+	"net"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// _FloodGuard counts packets received from a client in the current second and checks flood limits
+//
+// _FloodGuard is used only in the receiving goroutine of the client proxy
+type _FloodGuard struct {
+	maxPacketsPerSec int
+	maxBytesPerSec   int
+	windowStart      time.Time
+	packets          int
+	bytes            int
+}
+
+func newFloodGuard(cfg *config.GateConfig) *_FloodGuard {
+	return &_FloodGuard{
+		maxPacketsPerSec: cfg.MaxClientPacketsPerSec,
+		maxBytesPerSec:   cfg.MaxClientBytesPerSec,
+	}
+}
+
+// onRecvPacket records a received packet and returns the flood reason if any limit is exceeded
+func (fg *_FloodGuard) onRecvPacket(payloadLen uint32, now time.Time) string {
+	if fg.maxPacketsPerSec <= 0 && fg.maxBytesPerSec <= 0 {
+		return ""
+	}
+
+	if now.Sub(fg.windowStart) >= time.Second {
+		fg.windowStart = now
+		fg.packets = 0
+		fg.bytes = 0
+	}
+
+	fg.packets += 1
+	fg.bytes += int(payloadLen)
+	if fg.maxPacketsPerSec > 0 && fg.packets > fg.maxPacketsPerSec {
+		return fmt.Sprintf("too many packets: %d/s", fg.packets)
+	}
+	if fg.maxBytesPerSec > 0 && fg.bytes > fg.maxBytesPerSec {
+		return fmt.Sprintf("too many bytes: %d/s", fg.bytes)
+	}
+	return ""
+}
+
+// getAddrIP returns the IP of the network address
+func getAddrIP(addr net.Addr) string {
+	if addr == nil {
+		return ""
+	}
+
+	host, _, err := net.SplitHostPort(addr.String())
+	if err != nil {
+		return addr.String()
+	}
+	return host
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"net"
+	"strconv"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	_TRANSPORT_TCP       = "tcp"
+	_TRANSPORT_KCP       = "kcp"
+	_TRANSPORT_WEBSOCKET = "websocket"
+)
+
+// _GateMetrics are the Prometheus metrics of gate, which are exported at /metrics of the gate HTTP server if export_metrics is enabled
+type _GateMetrics struct {
+	connections         *prometheus.GaugeVec
+	accepts             *prometheus.CounterVec
+	rejects             *prometheus.CounterVec
+	recvBytes           *prometheus.CounterVec
+	sentBytes           *prometheus.CounterVec
+	packetSize          *prometheus.HistogramVec
+	recvPacketSize      prometheus.Observer
+	sentPacketSize      prometheus.Observer
+	flushLatency        *prometheus.HistogramVec
+	clientLatency       prometheus.Histogram
+	droppedSyncBytes    prometheus.Counter
+	delayedPacketsNum   prometheus.Counter
+	integrityViolations *prometheus.CounterVec
+}
+
+func newGateMetrics(gateid uint16) *_GateMetrics {
+	constLabels := prometheus.Labels{"gateid": strconv.Itoa(int(gateid))}
+	gm := &_GateMetrics{
+		connections: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Name:        "goworld_gate_connections",
+			Help:        "Number of live client connections.",
+			ConstLabels: constLabels,
+		}, []string{"transport"}),
+		accepts: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "goworld_gate_accepts_total",
+			Help:        "Number of accepted client connections.",
+			ConstLabels: constLabels,
+		}, []string{"transport"}),
+		rejects: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "goworld_gate_rejects_total",
+			Help:        "Number of rejected client connections.",
+			ConstLabels: constLabels,
+		}, []string{"transport", "reason"}),
+		recvBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "goworld_gate_received_bytes_total",
+			Help:        "Bytes received from clients.",
+			ConstLabels: constLabels,
+		}, []string{"transport"}),
+		sentBytes: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "goworld_gate_sent_bytes_total",
+			Help:        "Bytes sent to clients.",
+			ConstLabels: constLabels,
+		}, []string{"transport"}),
+		packetSize: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "goworld_gate_packet_size_bytes",
+			Help:        "Payload size of packets received from and sent to clients.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(16, 4, 8),
+		}, []string{"direction"}),
+		flushLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Name:        "goworld_gate_flush_latency_seconds",
+			Help:        "Latency of flushing buffered data to client connections.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.00001, 4, 8),
+		}, []string{"transport"}),
+		clientLatency: prometheus.NewHistogram(prometheus.HistogramOpts{
+			Name:        "goworld_gate_client_latency_seconds",
+			Help:        "Round trip time between gate and clients measured by ping.",
+			ConstLabels: constLabels,
+			Buckets:     prometheus.ExponentialBuckets(0.005, 2, 10),
+		}),
+		droppedSyncBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "goworld_gate_dropped_sync_bytes_total",
+			Help:        "Bytes of position syncs dropped by client send budgets.",
+			ConstLabels: constLabels,
+		}),
+		delayedPacketsNum: prometheus.NewCounter(prometheus.CounterOpts{
+			Name:        "goworld_gate_delayed_packets_total",
+			Help:        "Number of packets delayed by client send budgets.",
+			ConstLabels: constLabels,
+		}),
+		integrityViolations: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Name:        "goworld_gate_client_integrity_violations_total",
+			Help:        "Number of client packets failing integrity checks.",
+			ConstLabels: constLabels,
+		}, []string{"reason"}),
+	}
+
+	gm.recvPacketSize = gm.packetSize.WithLabelValues("recv")
+	gm.sentPacketSize = gm.packetSize.WithLabelValues("sent")
+	prometheus.MustRegister(gm.connections, gm.accepts, gm.rejects, gm.recvBytes, gm.sentBytes,
+		gm.packetSize, gm.flushLatency, gm.clientLatency, gm.droppedSyncBytes, gm.delayedPacketsNum, gm.integrityViolations)
+	return gm
+}
+
+// meteredConn counts bytes of client connections and measures flush latency
+//
+// Writes to meteredConn are flushes of buffered data, since client connections are buffered
+type meteredConn struct {
+	net.Conn
+	recvBytes    prometheus.Counter
+	sentBytes    prometheus.Counter
+	flushLatency prometheus.Observer
+}
+
+func newMeteredConn(conn net.Conn, transport string, gm *_GateMetrics) *meteredConn {
+	return &meteredConn{
+		Conn:         conn,
+		recvBytes:    gm.recvBytes.WithLabelValues(transport),
+		sentBytes:    gm.sentBytes.WithLabelValues(transport),
+		flushLatency: gm.flushLatency.WithLabelValues(transport),
+	}
+}
+
+func (mc *meteredConn) Read(b []byte) (int, error) {
+	n, err := mc.Conn.Read(b)
+	if n > 0 {
+		mc.recvBytes.Add(float64(n))
+	}
+	return n, err
+}
+
+func (mc *meteredConn) Write(b []byte) (int, error) {
+	startTime := time.Now()
+	n, err := mc.Conn.Write(b)
+	mc.flushLatency.Observe(time.Since(startTime).Seconds())
+	if n > 0 {
+		mc.sentBytes.Add(float64(n))
+	}
+	return n, err
+}
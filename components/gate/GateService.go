@@ -1,11 +1,17 @@
 package main
 
 import (
+	"crypto"
+	"encoding/json"
 	"fmt"
+	"net/http"
+	"strconv"
+	"syscall"
 	"time"
 
 	"golang.org/x/net/websocket"
 
+	"math/rand"
 	"net"
 
 	"crypto/tls"
@@ -14,6 +20,8 @@ import (
 
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
+	"github.com/xiaonanln/goworld/engine/auth"
+	"github.com/xiaonanln/goworld/engine/banlist"
 	"github.com/xiaonanln/goworld/engine/binutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
@@ -22,9 +30,12 @@ import (
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/netutil/compress"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
 	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/watchdog"
 	"github.com/xtaci/kcp-go"
 )
 
@@ -37,21 +48,46 @@ type clientProxyMessage struct {
 type GateService struct {
 	listenAddr                  string
 	clientProxies               map[common.ClientID]*ClientProxy
+	delayingClientProxies       map[common.ClientID]*ClientProxy // client proxies with packets delayed by bandwidth budget
+	handshakingClientProxies    map[common.ClientID]*ClientProxy // client proxies waiting for protocol version or authentication
 	dispatcherClientPacketQueue chan proto.Message
 	clientPacketQueue           chan clientProxyMessage
 	ticker                      <-chan time.Time
 
-	filterTrees             map[string]*_FilterTree
-	pendingSyncPackets      []*netutil.Packet
-	nextFlushSyncTime       time.Time
-	terminating             xnsyncutil.AtomicBool
-	terminated              *xnsyncutil.OneTimeCond
-	tlsConfig               *tls.Config
-	checkHeartbeatsInterval time.Duration
-	positionSyncInterval    time.Duration
+	filterTrees              map[string]*_FilterTree
+	pendingSyncPackets       []*netutil.Packet
+	nextFlushSyncTime        time.Time
+	terminating              xnsyncutil.AtomicBool
+	terminated               *xnsyncutil.OneTimeCond
+	draining                 xnsyncutil.AtomicBool // new connections are rejected, which can be set by watchdog before drain starts
+	drainDeadline            time.Time             // zero if drain is not started in the gate routine
+	drainTimeoutClosed       bool
+	drained                  bool
+	banList                  *banlist.List
+	persistBanList           bool // bans are saved to KVDB and reloaded every ban list refresh interval
+	banListRefreshInterval   time.Duration
+	banListRefreshTime       time.Time
+	banListRefreshing        bool
+	clientRecorder           *_ClientRecorder
+	ipPolicy                 *_IPPolicy
+	metrics                  *_GateMetrics
+	authVerifier             auth.Verifier // nil if authentication is disabled
+	handshakeTimeout         time.Duration
+	minClientProtocolVersion uint16
+	requirePacketIntegrity   bool             // clients should negotiate a cipher format before the handshake is completed
+	worlds                   common.StringSet // worlds hosted by the cluster, clients should choose one if not empty
+	proxyProtocolTrustedIPs  []*net.IPNet
+	tlsConfig                *tls.Config
+	keyExchangeSigner        crypto.Signer // signs key exchanges if sign_key_exchange is enabled
+	checkHeartbeatsInterval  time.Duration
+	positionSyncInterval     time.Duration
+	pingInterval             time.Duration
+	nextPingTime             time.Time
+	latencyChangeThreshold   time.Duration
 }
 
 func newGateService() *GateService {
+	cfg := config.GetGate(args.gateid)
 	dispIds := config.GetDispatcherIDs()
 	pendingSyncPackets := make([]*netutil.Packet, len(dispIds)) // one packet for each dispatcher
 	for i := range pendingSyncPackets {
@@ -63,11 +99,20 @@ func newGateService() *GateService {
 	return &GateService{
 		//dispatcherClientPacketQueue: make(chan packetQueueItem, consts.DISPATCHER_CLIENT_PACKET_QUEUE_SIZE),
 		clientProxies:               map[common.ClientID]*ClientProxy{},
+		delayingClientProxies:       map[common.ClientID]*ClientProxy{},
+		handshakingClientProxies:    map[common.ClientID]*ClientProxy{},
 		dispatcherClientPacketQueue: make(chan proto.Message, consts.GATE_SERVICE_PACKET_QUEUE_SIZE),
 		clientPacketQueue:           make(chan clientProxyMessage, consts.GATE_SERVICE_PACKET_QUEUE_SIZE),
 		ticker:                      time.Tick(consts.GATE_SERVICE_TICK_INTERVAL),
 		filterTrees:                 map[string]*_FilterTree{},
 		pendingSyncPackets:          pendingSyncPackets,
+		banList:                     banlist.NewList(),
+		persistBanList:              cfg.PersistBanList,
+		banListRefreshInterval:      cfg.BanListRefreshInterval,
+		clientRecorder:              newClientRecorder(cfg.RecordDir),
+		ipPolicy:                    newIPPolicy(cfg),
+		metrics:                     newGateMetrics(args.gateid),
+		proxyProtocolTrustedIPs:     parseCIDRs(cfg.ProxyProtocolTrustedIPs),
 		terminated:                  xnsyncutil.NewOneTimeCond(),
 	}
 }
@@ -75,10 +120,42 @@ func newGateService() *GateService {
 func (gs *GateService) run() {
 	cfg := config.GetGate(args.gateid)
 	gwlog.Infof("Compress connection: %v, encrypt connection: %v", cfg.CompressConnection, cfg.EncryptConnection)
+	gwlog.Infof("Negotiable compress formats: %v, compress threshold: %d", cfg.CompressFormats, cfg.CompressThreshold)
+	for _, format := range cfg.CompressFormats {
+		compress.NewCompressor(format) // panics if compress format is not supported
+	}
+	gwlog.Infof("Negotiable cipher formats: %v", cfg.CipherFormats)
+	for _, format := range cfg.CipherFormats {
+		if !crypt.IsFormatSupported(format) {
+			gwlog.Panicf("unknown cipher format: %s", format)
+		}
+	}
+	if cfg.AuthMethod != "" {
+		verifier, err := auth.NewVerifier(cfg.AuthMethod, cfg)
+		if err != nil {
+			gwlog.Panic(errors.Wrapf(err, "create verifier for auth method %s failed", cfg.AuthMethod))
+		}
+		gs.authVerifier = verifier
+		gwlog.Infof("Client authentication enabled: method %s, timeout %s", cfg.AuthMethod, cfg.AuthTimeout)
+	}
+	if cfg.MinClientProtocolVersion > proto.CLIENT_PROTOCOL_VERSION {
+		gwlog.Panicf("min_client_protocol_version %d is newer than the latest protocol version %d", cfg.MinClientProtocolVersion, proto.CLIENT_PROTOCOL_VERSION)
+	}
+	gs.minClientProtocolVersion = uint16(cfg.MinClientProtocolVersion)
+	gs.requirePacketIntegrity = cfg.RequirePacketIntegrity
+	gs.handshakeTimeout = cfg.AuthTimeout
+	gs.worlds = common.StringSet{}
+	for _, world := range config.GetDeployment().Worlds {
+		gs.worlds.Add(world)
+	}
+	gwlog.Infof("Client protocol version: %d, min client protocol version: %d", proto.CLIENT_PROTOCOL_VERSION, cfg.MinClientProtocolVersion)
 
 	if cfg.EncryptConnection {
 		gs.setupTLSConfig(cfg)
 	}
+	if cfg.SignKeyExchange {
+		gs.setupKeyExchangeSigner(cfg)
+	}
 
 	gs.listenAddr = cfg.ListenAddr
 	go netutil.ServeTCPForever(gs.listenAddr, gs)
@@ -90,6 +167,8 @@ func (gs *GateService) run() {
 	}
 	gs.positionSyncInterval = time.Millisecond * time.Duration(cfg.PositionSyncIntervalMS)
 	gwlog.Infof("%s: positionSyncInterval = %s", gs, gs.positionSyncInterval)
+	gs.pingInterval = cfg.PingInterval
+	gs.latencyChangeThreshold = cfg.LatencyChangeThreshold
 	binutil.PrintSupervisorTag(consts.GATE_STARTED_TAG)
 	gwutils.RepeatUntilPanicless(gs.mainRoutine)
 }
@@ -117,6 +196,20 @@ func (gs *GateService) setupTLSConfig(cfg *config.GateConfig) {
 	}
 }
 
+func (gs *GateService) setupKeyExchangeSigner(cfg *config.GateConfig) {
+	cfgdir := config.GetConfigDir()
+	cert, err := tls.LoadX509KeyPair(path.Join(cfgdir, cfg.RSACertificate), path.Join(cfgdir, cfg.RSAKey))
+	if err != nil {
+		gwlog.Panic(errors.Wrap(err, "load RSA key & certificate for signing key exchanges failed"))
+	}
+	signer, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		gwlog.Panicf("private key %s can not sign key exchanges", cfg.RSAKey)
+	}
+	gs.keyExchangeSigner = signer
+	gwlog.Infof("Key exchanges are signed by %s", cfg.RSAKey)
+}
+
 func (gs *GateService) String() string {
 	return fmt.Sprintf("GateService<%s>", gs.listenAddr)
 }
@@ -128,7 +221,36 @@ func (gs *GateService) ServeTCPConnection(conn net.Conn) {
 	tcpConn.SetReadBuffer(consts.CLIENT_PROXY_READ_BUFFER_SIZE)
 	tcpConn.SetNoDelay(consts.CLIENT_PROXY_SET_TCP_NO_DELAY)
 
-	gs.handleClientConnection(conn, false)
+	if config.GetGate(args.gateid).ProxyProtocol {
+		var ok bool
+		if conn, ok = gs.acceptProxyProtocol(conn); !ok {
+			gs.metrics.rejects.WithLabelValues(_TRANSPORT_TCP, "proxy_protocol").Inc()
+			return
+		}
+	}
+
+	gs.handleClientConnection(conn, _TRANSPORT_TCP)
+}
+
+// acceptProxyProtocol reads PROXY protocol header from trusted load balancers, returns the connection with real client address
+func (gs *GateService) acceptProxyProtocol(conn net.Conn) (net.Conn, bool) {
+	if ip := net.ParseIP(getAddrIP(conn.RemoteAddr())); ip == nil || !containsIP(gs.proxyProtocolTrustedIPs, ip) {
+		gwlog.Warnf("%s: rejected connection from untrusted proxy %s", gs, conn.RemoteAddr())
+		conn.Close()
+		return nil, false
+	}
+
+	proxyConn, err := netutil.ReadProxyProtocolHeader(conn, consts.PROXY_PROTOCOL_HEADER_TIMEOUT)
+	if err != nil {
+		gwlog.Warnf("%s: read PROXY protocol header from %s failed: %s", gs, conn.RemoteAddr(), err)
+		conn.Close()
+		return nil, false
+	}
+
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s: proxied connection from %s via %s", gs, proxyConn.RemoteAddr(), conn.RemoteAddr())
+	}
+	return proxyConn, true
 }
 
 func (gs *GateService) serveKCP(addr string) {
@@ -161,33 +283,53 @@ func (gs *GateService) handleKCPConn(conn *kcp.UDPSession) {
 	conn.SetWriteDelay(consts.KCP_SET_WRITE_DELAY)
 	conn.SetACKNoDelay(consts.KCP_SET_ACK_NO_DELAY)
 
-	gs.handleClientConnection(conn, false)
+	gs.handleClientConnection(conn, _TRANSPORT_KCP)
 }
 
 func (gs *GateService) handleWebSocketConn(wsConn *websocket.Conn) {
 	gwlog.Debugf("WebSocket Connection: %s", wsConn.RemoteAddr())
 	//var conn netutil.Connection = NewWebSocketConn(wsConn)
 	wsConn.PayloadType = websocket.BinaryFrame
-	gs.handleClientConnection(wsConn, true)
+	gs.handleClientConnection(wsConn, _TRANSPORT_WEBSOCKET)
 }
 
-func (gs *GateService) handleClientConnection(netconn net.Conn, isWebSocket bool) {
+func (gs *GateService) handleClientConnection(netconn net.Conn, transport string) {
 	// this function might run in multiple threads
-	if gs.terminating.Load() {
-		// server terminating, not accepting more connections
+	if gs.terminating.Load() || gs.draining.Load() {
+		// server terminating or draining, not accepting more connections
+		gs.metrics.rejects.WithLabelValues(transport, "unavailable").Inc()
 		netconn.Close()
 		return
 	}
 
+	ip := getAddrIP(netconn.RemoteAddr())
+	if !gs.ipPolicy.isAllowed(ip) {
+		gwlog.Warnf("%s: rejected connection from denied address %s", gs, netconn.RemoteAddr())
+		gs.metrics.rejects.WithLabelValues(transport, "denied").Inc()
+		netconn.Close()
+		return
+	}
+
+	if ban, ok := gs.banList.Find(banlist.KindIP, ip); ok {
+		gwlog.Warnf("%s: rejected connection from banned address %s: %s", gs, netconn.RemoteAddr(), ban)
+		gs.metrics.rejects.WithLabelValues(transport, "banned").Inc()
+		netconn.Close()
+		return
+	}
+
+	gs.metrics.accepts.WithLabelValues(transport).Inc()
+	netconn = newMeteredConn(netconn, transport, gs.metrics)
 	cfg := config.GetGate(args.gateid)
 
-	if cfg.EncryptConnection && !isWebSocket {
+	if cfg.EncryptConnection && transport != _TRANSPORT_WEBSOCKET {
 		tlsConn := tls.Server(netconn, gs.tlsConfig)
 		netconn = net.Conn(tlsConn)
 	}
 
 	conn := netutil.NetConnection{netconn}
 	cp := newClientProxy(conn, cfg)
+	cp.geoTag = gs.ipPolicy.geoTag(ip)
+	cp.transport = transport
 	if consts.DEBUG_CLIENTS {
 		gwlog.Debugf("%s.ServeTCPConnection: client %s connected", gs, cp)
 	}
@@ -213,13 +355,25 @@ func (gs *GateService) checkClientHeartbeats() {
 
 func (gs *GateService) onNewClientProxy(cp *ClientProxy) {
 	gs.clientProxies[cp.clientid] = cp
-	bootEntityID := common.GenEntityID() // generate boot entity ID in the gate
-	cp.ownerEntityID = bootEntityID
-	dispatchercluster.SelectByEntityID(bootEntityID).SendNotifyClientConnected(cp.clientid, bootEntityID)
+	gs.metrics.connections.WithLabelValues(cp.transport).Inc()
+	// entities are not created for the client until it is authenticated and sends the required protocol version
+	cp.handshakeDeadline = time.Now().Add(gs.handshakeTimeout)
+	gs.handshakingClientProxies[cp.clientid] = cp
+	if gs.clientRecorder.isSelected(cp) {
+		gs.clientRecorder.startRecording(cp)
+	}
+	gs.tryCompleteHandshake(cp)
 }
 
 func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
 	delete(gs.clientProxies, cp.clientid)
+	gs.metrics.connections.WithLabelValues(cp.transport).Dec()
+	delete(gs.handshakingClientProxies, cp.clientid)
+	cp.stopRecording()
+	if cp.bandwidth != nil {
+		delete(gs.delayingClientProxies, cp.clientid)
+		cp.bandwidth.releaseDelayedPackets()
+	}
 
 	for key, val := range cp.filterProps {
 		ft := gs.filterTrees[key]
@@ -231,40 +385,158 @@ func (gs *GateService) onClientProxyClose(cp *ClientProxy) {
 		}
 	}
 
+	if cp.ownerEntityID == "" {
+		return // client session is not started (handshake not completed)
+	}
+
 	dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientDisconnected(cp.clientid, cp.ownerEntityID)
 	if consts.DEBUG_CLIENTS {
 		gwlog.Debugf("%s.onClientProxyClose: client %s disconnected", gs, cp)
 	}
 }
 
+func (gs *GateService) onClientProxyFlood(cp *ClientProxy, reason string) {
+	if cp.ownerEntityID == "" {
+		return
+	}
+	dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientFlood(cp.clientid, cp.ownerEntityID, reason)
+}
+
+// tryPingClients pings all clients for measuring latency every ping interval
+func (gs *GateService) tryPingClients() {
+	if gs.pingInterval <= 0 {
+		return
+	}
+
+	now := time.Now()
+	if now.Before(gs.nextPingTime) {
+		return
+	}
+
+	gs.nextPingTime = now.Add(gs.pingInterval)
+	for _, cp := range gs.clientProxies {
+		if cp.supportsMsgType(proto.MT_PING_TO_CLIENT) {
+			// the previous ping is abandoned if it is not replied yet
+			// sequence numbers are random, so that clients can not reply pings before receiving them
+			cp.pingSeq = rand.Uint64()
+			cp.pingSendTime = now
+			cp.SendPingToClient(cp.pingSeq)
+		}
+	}
+}
+
+// onClientPong is called when client replies the ping, the round trip time is measured by the send time kept by gate
+func (gs *GateService) onClientPong(cp *ClientProxy, seq uint64, recvTime time.Time) {
+	if cp.pingSendTime.IsZero() || seq != cp.pingSeq {
+		gwlog.Debugf("%s: unexpected pong %d, ignored", cp, seq)
+		return
+	}
+
+	rtt := recvTime.Sub(cp.pingSendTime)
+	cp.pingSendTime = time.Time{} // each ping is measured only once
+	if rtt < 0 {
+		rtt = 0
+	}
+	gs.onClientLatency(cp, rtt)
+}
+
+// onClientLatency is called when the round trip time of client is measured
+func (gs *GateService) onClientLatency(cp *ClientProxy, rtt time.Duration) {
+	if _, ok := gs.clientProxies[cp.clientid]; !ok {
+		return // client already closed
+	}
+
+	gs.metrics.clientLatency.Observe(rtt.Seconds())
+	firstSample := cp.latency == 0
+	if firstSample {
+		cp.latency = rtt
+	} else {
+		cp.latency = (cp.latency*7 + rtt) / 8
+	}
+
+	diff := cp.latency - cp.reportedLatency
+	if diff < 0 {
+		diff = -diff
+	}
+	if !firstSample && diff < gs.latencyChangeThreshold {
+		return
+	}
+
+	cp.reportedLatency = cp.latency
+	if cp.ownerEntityID != "" {
+		dispatchercluster.SelectByEntityID(cp.ownerEntityID).SendNotifyClientLatency(cp.clientid, cp.ownerEntityID, cp.latency)
+	}
+}
+
 // HandleDispatcherClientPacket handles packets received by dispatcher client
 func (gs *GateService) handleClientProxyPacket(cp *ClientProxy, msgtype proto.MsgType, pkt *netutil.Packet) {
+	defer func() {
+		// packets are checked when received, but the gate should never crash for one client
+		if err := recover(); err != nil {
+			gwlog.TraceError("%s: handle message type %d from %s failed: %v, closing", gs, msgtype, cp, err)
+			cp.Close()
+		}
+	}()
+
 	cp.heartbeatTime = time.Now()
+	if !cp.handshaked && !isClientPacketAllowedBeforeHandshake(msgtype) {
+		gwlog.Warnf("%s: %s sent message type %d before handshake completed, closing", gs, cp, msgtype)
+		cp.Close()
+		return
+	}
+
 	switch msgtype {
+	case proto.MT_PROTOCOL_VERSION_FROM_CLIENT:
+		gs.handleProtocolVersionFromClient(cp, pkt)
+	case proto.MT_AUTH_FROM_CLIENT:
+		gs.handleAuthFromClient(cp, pkt)
+	case proto.MT_DEVICE_ID_FROM_CLIENT:
+		gs.handleDeviceIDFromClient(cp, pkt)
+	case proto.MT_WORLD_FROM_CLIENT:
+		gs.handleWorldFromClient(cp, pkt)
 	case proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:
-		gs.handleSyncPositionYawFromClient(pkt)
+		gs.handleSyncPositionYawFromClient(cp, pkt)
 	case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT:
 		pkt.AppendClientID(cp.clientid) // append cp to the packet
 		eid := pkt.ReadEntityID()
 		dispatchercluster.SelectByEntityID(eid).SendPacket(pkt)
 	case proto.MT_HEARTBEAT_FROM_CLIENT:
 		// kcp connected from client, need to do nothing here
+	case proto.MT_RESUME_SESSION_FROM_CLIENT:
+		gs.handleResumeSessionFromClient(cp, pkt)
 	default:
-		gwlog.Panicf("unknown message type from client: %d", msgtype)
+		gwlog.Warnf("%s: %s sent unknown message type %d, closing", gs, cp, msgtype)
+		cp.Close()
 	}
 
 }
 
+func (gs *GateService) handleResumeSessionFromClient(cp *ClientProxy, pkt *netutil.Packet) {
+	ownerEntityID := pkt.ReadEntityID()
+	sessionToken := pkt.ReadVarStr()
+	if consts.DEBUG_CLIENTS {
+		gwlog.Debugf("%s: %s resuming session of %s", gs, cp, ownerEntityID)
+	}
+	// the current owner entity (usually the boot entity) loses the client if session is resumed
+	dispatchercluster.SelectByEntityID(ownerEntityID).SendResumeClientSession(ownerEntityID, sessionToken, cp.clientid, cp.sessionToken, cp.ownerEntityID, cp.geoTag, cp.authID)
+}
+
 func (gs *GateService) handleDispatcherClientPacket(msgtype proto.MsgType, packet *netutil.Packet) {
 	if consts.DEBUG_PACKETS {
 		gwlog.Debugf("%s.handleDispatcherClientPacket: msgtype=%v, packet(%d)=%v", gs, msgtype, packet.GetPayloadLen(), packet.Payload())
 	}
 
 	if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
+		payload := packet.UnreadPayload()
 		_ = packet.ReadUint16() // gid
 		clientid := packet.ReadClientID()
 
 		clientproxy := gs.clientProxies[clientid]
+		if clientproxy != nil && msgtype == proto.MT_CREATE_ENTITY_ON_CLIENT {
+			// entities created on the client are recorded (with gate ID and client ID as received by the client) for
+			// mapping entity IDs when records are replayed
+			clientproxy.record(true, msgtype, payload)
+		}
 
 		// if msgtype is MT_CREATE_ENTITY_ON_CLIENT, update owner entity for the client proxy when isPlayer == true
 		if msgtype == proto.MT_CREATE_ENTITY_ON_CLIENT {
@@ -287,9 +559,16 @@ func (gs *GateService) handleDispatcherClientPacket(msgtype proto.MsgType, packe
 				gs.handleSetClientFilterProp(clientproxy, packet)
 			} else if msgtype == proto.MT_CLEAR_CLIENTPROXY_FILTER_PROPS {
 				gs.handleClearClientFilterProps(clientproxy, packet)
-			} else {
+			} else if msgtype == proto.MT_REDIRECT_CLIENT_TO_GATE {
+				gs.handleRedirectClientToGate(clientproxy, packet)
+			} else if msgtype == proto.MT_KICK_CLIENT {
+				gs.handleKickClient(clientproxy, packet)
+			} else if clientproxy.supportsMsgType(msgtype) {
 				// message types that should be redirected to client proxy
-				clientproxy.SendPacket(packet)
+				if gs.isUrgentClientMsgType(clientproxy, msgtype) {
+					packet.SetUrgent()
+				}
+				gs.sendToClientProxy(clientproxy, packet, gs.getSendPriority(clientproxy, msgtype, packet))
 			}
 		}
 
@@ -297,11 +576,106 @@ func (gs *GateService) handleDispatcherClientPacket(msgtype proto.MsgType, packe
 		gs.handleSyncPositionYawOnClients(packet)
 	} else if msgtype == proto.MT_CALL_FILTERED_CLIENTS {
 		gs.handleCallFilteredClientProxies(packet)
+	} else if msgtype == proto.MT_SYNC_BAN_LIST {
+		gs.handleSyncBanList(packet)
 	} else {
 		gwlog.Panicf("%s: unknown msg type: %d", gs, msgtype)
 	}
 }
 
+// getSendPriority returns the priority of the packet redirected to the client proxy
+func (gs *GateService) getSendPriority(cp *ClientProxy, msgtype proto.MsgType, packet *netutil.Packet) _SendPriority {
+	switch msgtype {
+	case proto.MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT, proto.MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT, proto.MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT,
+		proto.MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT, proto.MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT, proto.MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT:
+		// entity ID follows gate ID and client ID, which are already read
+		payload := packet.UnreadPayload()
+		if len(payload) >= common.ENTITYID_LENGTH && common.EntityID(payload[:common.ENTITYID_LENGTH]) != cp.ownerEntityID {
+			return _SEND_PRIORITY_OTHER_ATTR
+		}
+	}
+	return _SEND_PRIORITY_ESSENTIAL
+}
+
+func (gs *GateService) sendToClientProxy(cp *ClientProxy, packet *netutil.Packet, priority _SendPriority) {
+	if cp.sendWithBudget(packet, priority) {
+		gs.delayingClientProxies[cp.clientid] = cp
+		gs.metrics.delayedPacketsNum.Inc()
+	}
+}
+
+func (gs *GateService) flushDelayedClientPackets() {
+	if len(gs.delayingClientProxies) == 0 {
+		return
+	}
+
+	now := time.Now()
+	for clientid, cp := range gs.delayingClientProxies {
+		if !cp.flushDelayedPackets(now) {
+			delete(gs.delayingClientProxies, clientid)
+		}
+	}
+}
+
+// isUrgentClientMsgType returns if messages of the type should be flushed to the client immediately, bypassing batching
+func (gs *GateService) isUrgentClientMsgType(cp *ClientProxy, msgtype proto.MsgType) bool {
+	if msgtype == proto.MT_CALL_ENTITY_METHOD_ON_CLIENT {
+		return cp.cfg.UrgentClientRPC
+	}
+	return msgtype == proto.MT_NOTIFY_SESSION_RESUMED_ON_CLIENT || msgtype == proto.MT_NOTIFY_SHUTDOWN_ON_CLIENT
+}
+
+// handleRedirectClientToGate asks the client to reconnect to the target gate and resume its session
+func (gs *GateService) handleRedirectClientToGate(cp *ClientProxy, packet *netutil.Packet) {
+	targetGateID := packet.ReadUint16()
+	if targetGateID < 1 || int(targetGateID) > config.GetDeployment().DesiredGates {
+		gwlog.Errorf("%s: redirect %s to gate %d failed: gate not found", gs, cp, targetGateID)
+		return
+	}
+	gateAddr, err := getGateAdvertiseAddr(targetGateID)
+	if err != nil {
+		gwlog.Errorf("%s: redirect %s to gate %d failed: %s", gs, cp, targetGateID, err)
+		return
+	}
+	if !cp.redirectToGate(gateAddr, cp.ownerEntityID, cp.sessionToken) {
+		gwlog.Warnf("%s: redirect %s to gate %d failed: protocol version %d is too old", gs, cp, targetGateID, cp.protocolVersion)
+		return
+	}
+	gwlog.Infof("%s: redirect %s to gate %d at %s", gs, cp, targetGateID, gateAddr)
+	// the client should close the connection after reconnected, close it anyway if not
+	time.AfterFunc(consts.CLIENT_REDIRECT_CLOSE_TIMEOUT, func() {
+		cp.Close()
+	})
+}
+
+// getGateAdvertiseAddr returns the address of the gate for clients to connect
+func getGateAdvertiseAddr(gateid uint16) (string, error) {
+	cfg := config.GetGate(gateid)
+	if cfg.AdvertiseAddr != "" {
+		return cfg.AdvertiseAddr, nil
+	}
+	if host, _, err := net.SplitHostPort(cfg.ListenAddr); err != nil || host == "" || net.ParseIP(host).IsUnspecified() {
+		return "", errors.Errorf("advertise_addr is not set, and listen_addr %s can not be advertised", cfg.ListenAddr)
+	}
+	return cfg.ListenAddr, nil
+}
+
+// handleKickClient notifies the client of the kick reason and closes it
+func (gs *GateService) handleKickClient(cp *ClientProxy, packet *netutil.Packet) {
+	reason := packet.ReadVarStr()
+	gwlog.Infof("%s: %s is kicked: %s", gs, cp, reason)
+	if !cp.supportsMsgType(proto.MT_KICKED_ON_CLIENT) {
+		cp.Close()
+		return
+	}
+
+	cp.SendKickedOnClient(reason)
+	// delay closing the client, so that the kick reason can be sent
+	time.AfterFunc(_REJECT_CLOSE_DELAY, func() {
+		cp.Close()
+	})
+}
+
 func (gs *GateService) handleSetClientFilterProp(clientproxy *ClientProxy, packet *netutil.Packet) {
 	gwlog.Debugf("%s.handleSetClientFilterProp: clientproxy=%s", gs, clientproxy)
 	key := packet.ReadVarStr()
@@ -362,11 +736,22 @@ func (gs *GateService) handleSyncPositionYawOnClients(packet *netutil.Packet) {
 	for clientid, data := range dispatch {
 		clientproxy := gs.clientProxies[clientid]
 		if clientproxy != nil {
+			if clientproxy.bandwidth != nil {
+				// drop sync infos of far-away entities if bandwidth budget is exceeded
+				fitData := clientproxy.bandwidth.fitSyncData(data, clientproxy.ownerEntityID, time.Now())
+				if len(fitData) < len(data) {
+					gs.metrics.droppedSyncBytes.Add(float64(len(data) - len(fitData)))
+				}
+				if data = fitData; len(data) == 0 {
+					continue
+				}
+			}
+
 			packet := netutil.NewPacket()
 			packet.AppendUint16(proto.MT_SYNC_POSITION_YAW_ON_CLIENTS)
 			packet.AppendBytes(data)
 			packet.SetNotCompress() // too many these packets, giveup compress to save time
-			clientproxy.SendPacket(packet)
+			gs.sendToClientProxy(clientproxy, packet, _SEND_PRIORITY_POSITION)
 			packet.Release()
 		}
 	}
@@ -380,7 +765,9 @@ func (gs *GateService) handleCallFilteredClientProxies(packet *netutil.Packet) {
 	if key == "" {
 		// empty key meaning calling all clients
 		for _, cp := range gs.clientProxies {
-			cp.SendPacket(packet)
+			if cp.handshaked {
+				gs.sendToClientProxy(cp, packet, _SEND_PRIORITY_ESSENTIAL)
+			}
 		}
 		return
 	}
@@ -389,7 +776,7 @@ func (gs *GateService) handleCallFilteredClientProxies(packet *netutil.Packet) {
 	if ft != nil {
 		ft.Visit(op, val, func(cp *ClientProxy) {
 			//// visit all clientids and
-			cp.SendPacket(packet)
+			gs.sendToClientProxy(cp, packet, _SEND_PRIORITY_ESSENTIAL)
 		})
 	} else {
 		gwlog.Errorf("clients are not filtered by key %s", key)
@@ -397,9 +784,12 @@ func (gs *GateService) handleCallFilteredClientProxies(packet *netutil.Packet) {
 
 }
 
-func (gs *GateService) handleSyncPositionYawFromClient(packet *netutil.Packet) {
+func (gs *GateService) handleSyncPositionYawFromClient(cp *ClientProxy, packet *netutil.Packet) {
 	eid := packet.ReadEntityID()
 	data := packet.ReadBytes(proto.SYNC_INFO_SIZE_PER_ENTITY)
+	if cp.bandwidth != nil && eid == cp.ownerEntityID {
+		cp.bandwidth.setOwnPosition(data) // own position is used for prioritizing position syncs
+	}
 	dispid := dispatchercluster.EntityIDToDispatcherID(eid) // get the target dispatcher for the entity ID
 	pkt := gs.pendingSyncPackets[dispid-1]
 	pkt.AppendEntityID(eid)
@@ -441,7 +831,15 @@ func (gs *GateService) mainRoutine() {
 			item.Packet.Release()
 			break
 		case <-gs.ticker:
+			watchdog.Heartbeat()
 			gs.tryFlushPendingSyncPackets()
+			gs.tryPingClients()
+			gs.flushDelayedClientPackets()
+			gs.checkHandshakeTimeouts()
+			gs.tryRefreshBanList()
+			if !gs.drainDeadline.IsZero() {
+				gs.checkDrained()
+			}
 			break
 		}
 
@@ -449,6 +847,184 @@ func (gs *GateService) mainRoutine() {
 	}
 }
 
+// reloadConfig applies hot-reloadable settings of gate config in the gate routine
+//
+// Client rate limits and send budgets are read when clients connect, so they are applied to new connections.
+func (gs *GateService) reloadConfig() {
+	cfg := config.GetGate(args.gateid)
+	gs.pingInterval = cfg.PingInterval
+	gs.latencyChangeThreshold = cfg.LatencyChangeThreshold
+	gs.banListRefreshInterval = cfg.BanListRefreshInterval
+}
+
+// drain stops accepting new connections and notifies all clients to reconnect to other gates
+//
+// Gate quits when all clients are gone, remaining clients are closed after drain timeout
+func (gs *GateService) drain() {
+	if !gs.drainDeadline.IsZero() {
+		return
+	}
+
+	cfg := config.GetGate(args.gateid)
+	gs.draining.Store(true)
+	gs.drainDeadline = time.Now().Add(cfg.DrainTimeout)
+	gwlog.Infof("%s: draining %d clients, timeout %s ...", gs, len(gs.clientProxies), cfg.DrainTimeout)
+
+	// clients are spread over other gates in round-robin, and resume their sessions on the gates using session tokens
+	var targetAddrs []string
+	for gateid := uint16(1); int(gateid) <= config.GetDeployment().DesiredGates; gateid++ {
+		if gateid == args.gateid {
+			continue
+		}
+		if gateAddr, err := getGateAdvertiseAddr(gateid); err == nil {
+			targetAddrs = append(targetAddrs, gateAddr)
+		} else {
+			gwlog.Warnf("%s: clients can not be redirected to gate %d: %s", gs, gateid, err)
+		}
+	}
+
+	i := 0
+	for _, cp := range gs.clientProxies {
+		gateAddr := "" // clients should choose other gates by themselves if there is no other gate
+		if len(targetAddrs) > 0 {
+			gateAddr = targetAddrs[i%len(targetAddrs)]
+		}
+		if cp.notifyGateDraining(gateAddr, cp.ownerEntityID, cp.sessionToken) {
+			i++
+		}
+	}
+}
+
+func (gs *GateService) checkDrained() {
+	if gs.drained {
+		return
+	}
+
+	if len(gs.clientProxies) == 0 {
+		gwlog.Infof("%s: all clients are gone, gate quits.", gs)
+		gs.drained = true
+		signalChan <- syscall.SIGTERM
+		return
+	}
+
+	if !gs.drainTimeoutClosed && time.Now().After(gs.drainDeadline) {
+		gwlog.Warnf("%s: drain timeout, closing %d remaining clients ...", gs, len(gs.clientProxies))
+		gs.drainTimeoutClosed = true
+		for _, cp := range gs.clientProxies {
+			cp.Close()
+		}
+	}
+}
+
+const (
+	_ADMIN_REQUEST_TIMEOUT = time.Second * 5
+)
+
+type gateStatus struct {
+	GateID      uint16 `json:"gateid"`
+	Clients     int    `json:"clients"`
+	Handshaking int    `json:"handshaking"` // clients waiting for protocol version or authentication
+	Draining    bool   `json:"draining"`
+}
+
+// handleStatusRequest responds clients of the gate in JSON
+//
+// Usage: /status
+func (gs *GateService) handleStatusRequest(w http.ResponseWriter, r *http.Request) {
+	statusChan := make(chan *gateStatus, 1)
+	post.Post(func() {
+		statusChan <- &gateStatus{
+			GateID:      args.gateid,
+			Clients:     len(gs.clientProxies),
+			Handshaking: len(gs.handshakingClientProxies),
+			Draining:    gs.draining.Load(),
+		}
+	})
+
+	select {
+	case status := <-statusChan:
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(status)
+	case <-time.After(_ADMIN_REQUEST_TIMEOUT):
+		http.Error(w, "gate is busy", http.StatusServiceUnavailable)
+	}
+}
+
+// handleDrainRequest drains the gate, which quits when all clients reconnected to other gates
+//
+// Usage: POST /drain
+func (gs *GateService) handleDrainRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method should be called using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	post.Post(gs.drain)
+	fmt.Fprintf(w, "gate%d is draining\n", args.gateid)
+}
+
+// handleRecordRequest starts recording packets received from clients to record_dir
+//
+// Usage: /record?ip=<ip>&auth=<auth ID>&clientid=<client ID>&entity=<owner entity ID>&duration=<seconds>
+//
+// Connected clients matching any of the parameters are recorded immediately. Clients of the IP or the auth ID are also
+// recorded when connected or authenticated in duration seconds, or until /unrecord if duration is not set.
+func (gs *GateService) handleRecordRequest(w http.ResponseWriter, r *http.Request) {
+	ip, authID, clientid, ownerID := r.FormValue("ip"), r.FormValue("auth"), common.ClientID(r.FormValue("clientid")), common.EntityID(r.FormValue("entity"))
+	if ip == "" && authID == "" && clientid == "" && ownerID == "" {
+		http.Error(w, "ip, auth, clientid or entity is required", http.StatusBadRequest)
+		return
+	}
+	if ip != "" && net.ParseIP(ip) == nil {
+		http.Error(w, fmt.Sprintf("invalid ip: %#v", ip), http.StatusBadRequest)
+		return
+	}
+
+	var duration time.Duration
+	if s := r.FormValue("duration"); s != "" {
+		seconds, err := strconv.Atoi(s)
+		if err != nil || seconds <= 0 {
+			http.Error(w, fmt.Sprintf("invalid duration: %#v", s), http.StatusBadRequest)
+			return
+		}
+		duration = time.Second * time.Duration(seconds)
+	}
+
+	post.Post(func() {
+		gs.clientRecorder.selectClients(ip, authID, duration)
+		for _, cp := range gs.clientProxies {
+			if gs.clientRecorder.isSelected(cp) || cp.clientid == clientid || (ownerID != "" && cp.ownerEntityID == ownerID) {
+				gs.clientRecorder.startRecording(cp)
+			}
+		}
+	})
+	fmt.Fprintf(w, "gate%d recording clients to %s\n", args.gateid, gs.clientRecorder.dir)
+}
+
+// handleUnrecordRequest stops recording clients
+//
+// Usage: /unrecord?ip=<ip>&auth=<auth ID>&clientid=<client ID>&entity=<owner entity ID>, recording of all clients is
+// stopped if no parameter is set
+func (gs *GateService) handleUnrecordRequest(w http.ResponseWriter, r *http.Request) {
+	ip, authID, clientid, ownerID := r.FormValue("ip"), r.FormValue("auth"), common.ClientID(r.FormValue("clientid")), common.EntityID(r.FormValue("entity"))
+	all := ip == "" && authID == "" && clientid == "" && ownerID == ""
+
+	post.Post(func() {
+		if all {
+			gs.clientRecorder = newClientRecorder(gs.clientRecorder.dir)
+		} else {
+			gs.clientRecorder.unselectClients(ip, authID)
+		}
+		for _, cp := range gs.clientProxies {
+			if all || getAddrIP(cp.RemoteAddr()) == ip || (authID != "" && cp.authID == authID) || cp.clientid == clientid ||
+				(ownerID != "" && cp.ownerEntityID == ownerID) {
+				cp.stopRecording()
+			}
+		}
+	})
+	fmt.Fprintf(w, "gate%d stopped recording clients\n", args.gateid)
+}
+
 func (gs *GateService) terminate() {
 	gs.terminating.Store(true)
 
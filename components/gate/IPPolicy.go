@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"io"
+	"net"
+	"os"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+type geoIPEntry struct {
+	ipnet  *net.IPNet
+	tag    string
+	first  net.IP // first and last IPs of the network in 16-byte form
+	last   net.IP
+	parent int // index of the smallest entry containing the network, or -1
+}
+
+// _IPPolicy checks client addresses against allow & deny lists and tags client addresses using GeoIP data
+//
+// _IPPolicy is read-only after created, so it can be used in multiple goroutines
+type _IPPolicy struct {
+	allowNets []*net.IPNet
+	denyNets  []*net.IPNet
+	geoIP     []geoIPEntry // sorted by first IPs, so that entries are searched by binary search
+}
+
+func newIPPolicy(cfg *config.GateConfig) *_IPPolicy {
+	policy := &_IPPolicy{
+		allowNets: parseCIDRs(cfg.AllowIPs),
+		denyNets:  parseCIDRs(cfg.DenyIPs),
+	}
+
+	if cfg.GeoIPFile != "" {
+		geoIPFile := cfg.GeoIPFile
+		if !path.IsAbs(geoIPFile) {
+			geoIPFile = path.Join(config.GetConfigDir(), geoIPFile)
+		}
+		policy.geoIP = loadGeoIPFile(geoIPFile)
+		gwlog.Infof("GeoIP file %s loaded: %d entries", geoIPFile, len(policy.geoIP))
+	}
+	return policy
+}
+
+// isAllowed returns if clients of the IP are allowed to connect
+func (policy *_IPPolicy) isAllowed(ip string) bool {
+	netip := net.ParseIP(ip)
+	if netip == nil {
+		// address is not IP (e.g. unix socket), policy does not apply
+		return true
+	}
+
+	if containsIP(policy.denyNets, netip) {
+		return false
+	}
+	return len(policy.allowNets) == 0 || containsIP(policy.allowNets, netip)
+}
+
+// geoTag returns the GeoIP tag of the IP, or "" if not found
+func (policy *_IPPolicy) geoTag(ip string) string {
+	netip := net.ParseIP(ip)
+	if netip == nil {
+		return ""
+	}
+
+	if entry := findGeoIPEntry(policy.geoIP, netip.To16()); entry != nil {
+		return entry.tag
+	}
+	return ""
+}
+
+// findGeoIPEntry returns the most specific entry containing the 16-byte IP, or nil if not found
+//
+// The last entry starting at or before the IP is found by binary search, and the IP is either in the network of the
+// entry, or in one of the networks containing it, since networks are either nested or disjoint.
+func findGeoIPEntry(entries []geoIPEntry, ip net.IP) *geoIPEntry {
+	i := sort.Search(len(entries), func(i int) bool {
+		return bytes.Compare(entries[i].first, ip) > 0
+	}) - 1
+	for i >= 0 {
+		entry := &entries[i]
+		if bytes.Compare(ip, entry.last) <= 0 {
+			return entry
+		}
+		i = entry.parent
+	}
+	return nil
+}
+
+func containsIP(ipnets []*net.IPNet, ip net.IP) bool {
+	for _, ipnet := range ipnets {
+		if ipnet.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCIDR parses CIDR notation, single IPs are treated as /32 (IPv4) or /128 (IPv6) networks
+func parseCIDR(s string) (*net.IPNet, error) {
+	s = strings.TrimSpace(s)
+	if !strings.Contains(s, "/") {
+		if ip := net.ParseIP(s); ip != nil && ip.To4() != nil {
+			s = s + "/32"
+		} else {
+			s = s + "/128"
+		}
+	}
+
+	_, ipnet, err := net.ParseCIDR(s)
+	return ipnet, err
+}
+
+func parseCIDRs(cidrs []string) []*net.IPNet {
+	var ipnets []*net.IPNet
+	for _, cidr := range cidrs {
+		if strings.TrimSpace(cidr) == "" {
+			continue
+		}
+
+		ipnet, err := parseCIDR(cidr)
+		if err != nil {
+			gwlog.Fatalf("invalid CIDR %#v: %s", cidr, err)
+		}
+		ipnets = append(ipnets, ipnet)
+	}
+	return ipnets
+}
+
+func loadGeoIPFile(filename string) []geoIPEntry {
+	f, err := os.Open(filename)
+	if err != nil {
+		gwlog.Fatalf("open GeoIP file %s failed: %s", filename, err)
+	}
+	defer f.Close()
+
+	entries, err := readGeoIPEntries(f)
+	if err != nil {
+		gwlog.Fatalf("read GeoIP file %s failed: %s", filename, err)
+	}
+	return entries
+}
+
+// readGeoIPEntries reads GeoIP entries from CSV lines of "CIDR,tag", lines starting with # are ignored
+func readGeoIPEntries(r io.Reader) ([]geoIPEntry, error) {
+	reader := csv.NewReader(r)
+	reader.Comment = '#'
+	reader.FieldsPerRecord = 2
+	reader.TrimLeadingSpace = true
+
+	var entries []geoIPEntry
+	for {
+		record, err := reader.Read()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, err
+		}
+
+		ipnet, err := parseCIDR(record[0])
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, geoIPEntry{ipnet: ipnet, tag: strings.TrimSpace(record[1])})
+	}
+
+	sortGeoIPEntries(entries)
+	return entries, nil
+}
+
+// sortGeoIPEntries sorts entries by first IPs, larger networks first if first IPs are the same, and links each entry to
+// the smallest entry containing it
+func sortGeoIPEntries(entries []geoIPEntry) {
+	for i := range entries {
+		entry := &entries[i]
+		entry.first = entry.ipnet.IP.To16()
+		entry.last = make(net.IP, net.IPv6len)
+		mask := entry.ipnet.Mask
+		if len(mask) == net.IPv4len {
+			mask = append(net.CIDRMask(96, 128)[:12:12], mask...)
+		}
+		for j := range entry.last {
+			entry.last[j] = entry.first[j] | ^mask[j]
+		}
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		if c := bytes.Compare(entries[i].first, entries[j].first); c != 0 {
+			return c < 0
+		}
+		return bytes.Compare(entries[i].last, entries[j].last) > 0
+	})
+
+	// entries containing the current entry are kept in the stack, from the largest to the smallest
+	var stack []int
+	for i := range entries {
+		for len(stack) > 0 && bytes.Compare(entries[stack[len(stack)-1]].last, entries[i].first) < 0 {
+			stack = stack[:len(stack)-1]
+		}
+		entries[i].parent = -1
+		if len(stack) > 0 {
+			entries[i].parent = stack[len(stack)-1]
+		}
+		stack = append(stack, i)
+	}
+}
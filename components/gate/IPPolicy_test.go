@@ -0,0 +1,96 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGeoTag(t *testing.T) {
+	entries, err := readGeoIPEntries(strings.NewReader(`# CIDR,tag
+10.0.0.0/8,private
+10.1.0.0/16,office
+10.1.2.0/24,lab
+10.1.2.3,server
+10.2.0.0/16,vpn
+192.168.1.0/24,home
+2001:db8::/32,v6
+2001:db8:1::/48,v6-office
+`))
+	if err != nil {
+		t.Fatalf("read GeoIP entries failed: %s", err)
+	}
+
+	policy := &_IPPolicy{geoIP: entries}
+	for ip, tag := range map[string]string{
+		"10.0.0.1":        "private",
+		"10.1.0.1":        "office",
+		"10.1.2.1":        "lab",
+		"10.1.2.3":        "server",
+		"10.1.2.4":        "lab",
+		"10.1.3.0":        "office",
+		"10.2.255.255":    "vpn",
+		"10.3.0.0":        "private",
+		"10.255.255.255":  "private",
+		"11.0.0.0":        "",
+		"9.255.255.255":   "",
+		"192.168.1.255":   "home",
+		"192.168.2.0":     "",
+		"2001:db8::1":     "v6",
+		"2001:db8:1::1":   "v6-office",
+		"2001:db8:2::1":   "v6",
+		"2001:db9::1":     "",
+		"::ffff:10.1.2.3": "server",
+		"not an ip":       "",
+	} {
+		if got := policy.geoTag(ip); got != tag {
+			t.Errorf("geo tag of %s should be %#v, but is %#v", ip, tag, got)
+		}
+	}
+}
+
+func TestGeoTagManyEntries(t *testing.T) {
+	var lines []string
+	for i := 0; i < 256; i++ {
+		lines = append(lines, fmt.Sprintf("10.%d.0.0/16,region%d", i, i))
+		if i%2 == 0 {
+			lines = append(lines, fmt.Sprintf("10.%d.128.0/17,city%d", i, i))
+		}
+	}
+	entries, err := readGeoIPEntries(strings.NewReader(strings.Join(lines, "\n")))
+	if err != nil {
+		t.Fatalf("read GeoIP entries failed: %s", err)
+	}
+
+	policy := &_IPPolicy{geoIP: entries}
+	for i := 0; i < 256; i++ {
+		if tag := policy.geoTag(fmt.Sprintf("10.%d.1.1", i)); tag != fmt.Sprintf("region%d", i) {
+			t.Errorf("wrong geo tag of 10.%d.1.1: %s", i, tag)
+		}
+		expected := fmt.Sprintf("region%d", i)
+		if i%2 == 0 {
+			expected = fmt.Sprintf("city%d", i)
+		}
+		if tag := policy.geoTag(fmt.Sprintf("10.%d.200.1", i)); tag != expected {
+			t.Errorf("geo tag of 10.%d.200.1 should be %s, but is %s", i, expected, tag)
+		}
+	}
+}
+
+func TestIPPolicyIsAllowed(t *testing.T) {
+	policy := &_IPPolicy{
+		allowNets: parseCIDRs([]string{"10.0.0.0/8", "192.168.1.1"}),
+		denyNets:  parseCIDRs([]string{"10.0.1.0/24"}),
+	}
+	for ip, allowed := range map[string]bool{
+		"10.0.0.1":    true,
+		"10.0.1.1":    false,
+		"192.168.1.1": true,
+		"192.168.1.2": false,
+		"unix":        true,
+	} {
+		if policy.isAllowed(ip) != allowed {
+			t.Errorf("%s should be allowed: %v", ip, allowed)
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// clientMsgTypeVersions defines the protocol versions introducing gate-to-client message types
+//
+// Messages are not sent to clients using older protocol versions, so that old clients keep working during rolling upgrades.
+// Message types not listed are supported by all protocol versions.
+var clientMsgTypeVersions = map[proto.MsgType]uint16{
+	proto.MT_SET_CLIENT_SESSION_TOKEN:         proto.CLIENT_PROTOCOL_VERSION_2,
+	proto.MT_NOTIFY_SESSION_RESUMED_ON_CLIENT: proto.CLIENT_PROTOCOL_VERSION_2,
+	proto.MT_NOTIFY_GATE_DRAINING:             proto.CLIENT_PROTOCOL_VERSION_2,
+	proto.MT_PING_TO_CLIENT:                   proto.CLIENT_PROTOCOL_VERSION_2,
+	proto.MT_REDIRECT_TO_GATE_ON_CLIENT:       proto.CLIENT_PROTOCOL_VERSION_3,
+	proto.MT_KICKED_ON_CLIENT:                 proto.CLIENT_PROTOCOL_VERSION_4,
+	proto.MT_NOTIFY_SHUTDOWN_ON_CLIENT:        proto.CLIENT_PROTOCOL_VERSION_5,
+}
+
+// supportsMsgType returns if the message type can be sent to the client using its protocol version
+func (cp *ClientProxy) supportsMsgType(msgtype proto.MsgType) bool {
+	version, ok := clientMsgTypeVersions[msgtype]
+	return !ok || cp.protocolVersion >= version
+}
+
+// notifyGateDraining notifies the client to reconnect to the gate and resume its session, the message is translated
+// for the protocol version of the client:
+//
+//	version 6+: MT_NOTIFY_GATE_DRAINING with the gate address and the session token
+//	version 2~5: MT_NOTIFY_GATE_DRAINING without payload, the client reconnects to any gate using the session token it
+//	             received by MT_SET_CLIENT_SESSION_TOKEN
+//	version 1: not notified, the client is closed when the drain timeout is reached
+func (cp *ClientProxy) notifyGateDraining(gateAddr string, ownerEntityID common.EntityID, sessionToken string) bool {
+	if cp.protocolVersion >= proto.CLIENT_PROTOCOL_VERSION_6 {
+		cp.SendNotifyGateDraining(gateAddr, ownerEntityID, sessionToken)
+		return true
+	} else if cp.protocolVersion >= proto.CLIENT_PROTOCOL_VERSION_2 {
+		cp.sendLegacyNotifyGateDraining()
+		return true
+	}
+	return false
+}
+
+// redirectToGate asks the client to reconnect to the gate and resume its session, the message is translated for the
+// protocol version of the client:
+//
+//	version 3+: MT_REDIRECT_TO_GATE_ON_CLIENT with the gate address and the session token
+//	version 2: MT_NOTIFY_GATE_DRAINING without payload, the client reconnects to any gate using the session token it
+//	           received by MT_SET_CLIENT_SESSION_TOKEN
+//	version 1: not supported, returns false
+func (cp *ClientProxy) redirectToGate(gateAddr string, ownerEntityID common.EntityID, sessionToken string) bool {
+	if cp.protocolVersion >= proto.CLIENT_PROTOCOL_VERSION_3 {
+		cp.SendRedirectToGateOnClient(gateAddr, ownerEntityID, sessionToken)
+		return true
+	} else if cp.protocolVersion >= proto.CLIENT_PROTOCOL_VERSION_2 {
+		cp.sendLegacyNotifyGateDraining()
+		return true
+	}
+	return false
+}
+
+// sendLegacyNotifyGateDraining sends MT_NOTIFY_GATE_DRAINING in the format of protocol version 2~5, which has no payload
+func (cp *ClientProxy) sendLegacyNotifyGateDraining() {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(proto.MT_NOTIFY_GATE_DRAINING)
+	packet.SetUrgent()
+	cp.SendPacketRelease(packet)
+}
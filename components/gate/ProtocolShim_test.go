@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// newTestClientProxy returns the client proxy of the protocol version and the connection of the client
+func newTestClientProxy(version uint16) (*ClientProxy, *proto.GoWorldConnection) {
+	c1, c2 := net.Pipe()
+	cp := newClientProxy(netutil.NetConnection{Conn: c1}, &config.GateConfig{})
+	cp.protocolVersion = version
+	client := proto.NewGoWorldConnection(netutil.NewBufferedConnection(netutil.NetConnection{Conn: c2}), false, "")
+	return cp, client
+}
+
+// sendAndRecv runs send on the client proxy, and returns the packet received by the client
+func sendAndRecv(cp *ClientProxy, client *proto.GoWorldConnection, send func() bool) (proto.MsgType, *netutil.Packet) {
+	go func() {
+		if send() {
+			cp.Flush("test")
+		}
+		cp.Close()
+	}()
+
+	var msgtype proto.MsgType
+	pkt, err := client.Recv(&msgtype)
+	if err != nil {
+		return 0, nil
+	}
+	return msgtype, pkt
+}
+
+func TestNotifyGateDraining(t *testing.T) {
+	owner := common.GenEntityID()
+	for _, version := range []uint16{proto.CLIENT_PROTOCOL_VERSION_6, proto.CLIENT_PROTOCOL_VERSION} {
+		cp, client := newTestClientProxy(version)
+		msgtype, pkt := sendAndRecv(cp, client, func() bool { return cp.notifyGateDraining("127.0.0.1:14001", owner, "token") })
+		if msgtype != proto.MT_NOTIFY_GATE_DRAINING || pkt == nil {
+			t.Fatalf("version %d: MT_NOTIFY_GATE_DRAINING should be sent, but got %v", version, msgtype)
+		}
+		if gateAddr, eid, token := pkt.ReadVarStr(), pkt.ReadEntityID(), pkt.ReadVarStr(); gateAddr != "127.0.0.1:14001" || eid != owner || token != "token" {
+			t.Errorf("version %d: wrong payload: %s %s %s", version, gateAddr, eid, token)
+		}
+		pkt.Release()
+	}
+
+	for _, version := range []uint16{proto.CLIENT_PROTOCOL_VERSION_2, proto.CLIENT_PROTOCOL_VERSION_5} {
+		cp, client := newTestClientProxy(version)
+		msgtype, pkt := sendAndRecv(cp, client, func() bool { return cp.notifyGateDraining("127.0.0.1:14001", owner, "token") })
+		if msgtype != proto.MT_NOTIFY_GATE_DRAINING || pkt == nil {
+			t.Fatalf("version %d: MT_NOTIFY_GATE_DRAINING should be sent, but got %v", version, msgtype)
+		}
+		if pkt.HasUnreadPayload() {
+			t.Errorf("version %d: MT_NOTIFY_GATE_DRAINING should have no payload", version)
+		}
+		pkt.Release()
+	}
+
+	cp, client := newTestClientProxy(proto.CLIENT_PROTOCOL_VERSION_1)
+	if _, pkt := sendAndRecv(cp, client, func() bool { return cp.notifyGateDraining("127.0.0.1:14001", owner, "token") }); pkt != nil {
+		t.Errorf("version 1: nothing should be sent")
+	}
+}
+
+func TestRedirectToGate(t *testing.T) {
+	owner := common.GenEntityID()
+	for _, version := range []uint16{proto.CLIENT_PROTOCOL_VERSION_3, proto.CLIENT_PROTOCOL_VERSION} {
+		cp, client := newTestClientProxy(version)
+		msgtype, pkt := sendAndRecv(cp, client, func() bool { return cp.redirectToGate("127.0.0.1:14002", owner, "token") })
+		if msgtype != proto.MT_REDIRECT_TO_GATE_ON_CLIENT || pkt == nil {
+			t.Fatalf("version %d: MT_REDIRECT_TO_GATE_ON_CLIENT should be sent, but got %v", version, msgtype)
+		}
+		if gateAddr, eid, token := pkt.ReadVarStr(), pkt.ReadEntityID(), pkt.ReadVarStr(); gateAddr != "127.0.0.1:14002" || eid != owner || token != "token" {
+			t.Errorf("version %d: wrong payload: %s %s %s", version, gateAddr, eid, token)
+		}
+		pkt.Release()
+	}
+
+	cp, client := newTestClientProxy(proto.CLIENT_PROTOCOL_VERSION_2)
+	msgtype, pkt := sendAndRecv(cp, client, func() bool { return cp.redirectToGate("127.0.0.1:14002", owner, "token") })
+	if msgtype != proto.MT_NOTIFY_GATE_DRAINING || pkt == nil || pkt.HasUnreadPayload() {
+		t.Fatalf("version 2: redirect should be translated to MT_NOTIFY_GATE_DRAINING without payload, but got %v", msgtype)
+	}
+	pkt.Release()
+
+	cp, client = newTestClientProxy(proto.CLIENT_PROTOCOL_VERSION_1)
+	if cp.redirectToGate("127.0.0.1:14002", owner, "token") {
+		t.Errorf("version 1: redirect should not be supported")
+	}
+	cp.Close()
+	client.Close()
+}
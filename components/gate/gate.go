@@ -8,7 +8,7 @@ import (
 
 	"os"
 
-	_ "net/http/pprof"
+	"net/http"
 
 	"runtime"
 
@@ -20,15 +20,18 @@ import (
 
 	"path"
 
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/xiaonanln/goworld/engine/binutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster/dispatcherclient"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/watchdog"
 )
 
 var (
@@ -47,6 +50,7 @@ func parseArgs() {
 	var gateIdArg int
 	flag.IntVar(&gateIdArg, "gid", 0, "set gateid")
 	flag.StringVar(&args.configFile, "configfile", "", "set config file path")
+	flag.Var(config.OverrideFlag{}, "config", "override config value in the form of section.key=value, e.g. -config game1.http_addr=:25001, can be repeated")
 	flag.StringVar(&args.logLevel, "log", "", "set log level, will override log level in config")
 	flag.BoolVar(&args.runInDaemonMode, "d", false, "run in daemon mode")
 	//flag.StringVar(&args.listenAddr, "listen-addr", "", "set listen address for gate, overriding listen_addr in config file")
@@ -78,13 +82,37 @@ func main() {
 		gwlog.Infof("SET GOMAXPROCS = %d", gateConfig.GoMaxProcs)
 		runtime.GOMAXPROCS(gateConfig.GoMaxProcs)
 	}
+	binutil.OnReloadLogLevel("gate", args.logLevel, func() string {
+		return config.GetGate(args.gateid).LogLevel
+	})
 	logLevel := args.logLevel
 	if logLevel == "" {
 		logLevel = gateConfig.LogLevel
 	}
-	binutil.SetupGWLog(fmt.Sprintf("gate%d", args.gateid), logLevel, gateConfig.LogFile, gateConfig.LogStderr)
+	binutil.SetupGWLog(fmt.Sprintf("gate%d", args.gateid), logLevel, gateConfig.LogFile, gateConfig.LogStderr, gateConfig.LogFormat)
+	gwlog.AddGlobalFields("gateid", args.gateid)
+
+	if gateConfig.PersistBanList || gateConfig.AuthMethod == "kvdb" {
+		kvdb.Initialize()
+	}
 
 	gateService = newGateService()
+	if gateConfig.PersistBanList {
+		gateService.refreshBanList()
+	}
+	if gateConfig.ExportMetrics {
+		// /metrics is served on http_addr without the admin token, all admin actions are only served by the admin server
+		gwlog.Warnf("gate%d: exporting metrics at /metrics of %s, which should not be exposed to the internet", args.gateid, gateConfig.HTTPAddr)
+		http.Handle("/metrics", promhttp.Handler())
+	}
+	binutil.HandleAdminFunc("/status", gateService.handleStatusRequest)
+	binutil.HandleAdminFunc("/drain", gateService.handleDrainRequest)
+	binutil.HandleAdminFunc("/ban", gateService.handleBanRequest)
+	binutil.HandleAdminFunc("/unban", gateService.handleUnbanRequest)
+	binutil.HandleAdminFunc("/bans", gateService.handleBansRequest)
+	binutil.HandleAdminFunc("/record", gateService.handleRecordRequest)
+	binutil.HandleAdminFunc("/unrecord", gateService.handleUnrecordRequest)
+	binutil.HandleAdminFunc("/terminate", handleTerminateRequest)
 	if gateConfig.EncryptConnection {
 		cfgdir := config.GetConfigDir()
 		rsaCert := path.Join(cfgdir, gateConfig.RSACertificate)
@@ -93,6 +121,19 @@ func main() {
 	} else {
 		binutil.SetupHTTPServer(gateConfig.HTTPAddr, gateService.handleWebSocketConn)
 	}
+	binutil.SetupAdminServer(fmt.Sprintf("gate%d", args.gateid), gateConfig.AdminAddr)
+	binutil.SetupWatchdog(fmt.Sprintf("gate%d", args.gateid))
+	watchdog.SetDrainHandler(func(draining bool) {
+		if !draining {
+			return // gate quits after drained
+		}
+		// new connections are rejected from the watchdog goroutine, since the gate routine might be stalled
+		gateService.draining.Store(true)
+		post.Post(gateService.drain)
+	})
+	config.OnReload("gate", func() {
+		post.Post(gateService.reloadConfig)
+	})
 
 	dispatchercluster.Initialize(args.gateid, dispatcherclient.GateDispatcherClientType, false, false, &gateDispatcherClientDelegate{})
 	//dispatcherclient.Initialize(&gateDispatcherClientDelegate{}, true)
@@ -101,12 +142,29 @@ func main() {
 }
 
 func verifyGateConfig(gateConfig *config.GateConfig) {
+	if gateConfig.PersistBanList && config.GetKVDB().Type == "" {
+		gwlog.Fatalf("gate%d: persist_ban_list is enabled, but KVDB is not configured", args.gateid)
+	}
+}
+
+// handleTerminateRequest terminates the gate gracefully like receiving SIGTERM
+//
+// Usage: /terminate
+func handleTerminateRequest(w http.ResponseWriter, r *http.Request) {
+	select {
+	case signalChan <- syscall.SIGTERM:
+		fmt.Fprintf(w, "gate%d is terminating\n", args.gateid)
+	default:
+		http.Error(w, fmt.Sprintf("gate%d is busy handling signals", args.gateid), http.StatusServiceUnavailable)
+	}
 }
 
 func setupSignals() {
 	gwlog.Infof("Setup signals ...")
-	signal.Ignore(syscall.Signal(10), syscall.Signal(12), syscall.SIGPIPE, syscall.SIGHUP)
+	signal.Ignore(syscall.Signal(10), syscall.Signal(12), syscall.SIGPIPE)
 	signal.Notify(signalChan, syscall.SIGINT, syscall.SIGTERM)
+	binutil.HandleReloadConfigSignal(binutil.ReloadConfigSignal)
+	config.StartWatch()
 
 	go func() {
 		for {
@@ -85,6 +85,14 @@ the entity will be reconstructed using attribute data.
 Configuration
 
 GoWorld uses `goworld.ini` as the default config file. Use '-configfile <path>' to use specified config file for processes.
+YAML (.yaml, .yml) and TOML (.toml) config files of the same schema are also supported, see loadConfigFile in engine/config.
+The config can also be stored in etcd or consul, e.g. '-configfile etcd://127.0.0.1:2379/goworld/goworld.ini', which is watched
+and hot reloaded by all processes. Use etcd+https:// or consul+https:// for non-loopback hosts, with CA, client
+certificates and credentials read from the environment variables of etcdctl (ETCDCTL_*) or consul (CONSUL_*).
+Config values can be overridden by environment variables GOWORLD_<SECTION>_<KEY> (e.g. GOWORLD_GAME1_HTTP_ADDR) and
+command-line flags '-config <section>.<key>=<value>' (e.g. -config game1.http_addr=:25001), flags take precedence.
+Credentials should be referenced as secrets like ${env:VAR}, ${file:path} or ${vault:path:field} in config values, which
+are resolved when the config is loaded, so that they are not checked into git with goworld.ini.
 
 */
 package goworld
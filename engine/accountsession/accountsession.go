@@ -0,0 +1,187 @@
+// Package accountsession tracks active sessions of accounts for enforcing the concurrent login policy.
+//
+// Sessions of each account are claimed by entities (e.g. the Account entity after login) at the dispatcher selected by
+// the account, so that simultaneous logins of the same account on different gates and games are serialized: the older
+// sessions are kicked or the new session is rejected when the account has too many sessions.
+//
+// The table of sessions is also the registry of online players: players are found by accounts, and LoginEvent and
+// LogoutEvent are emitted to the event bus of all games when sessions are claimed and released.
+package accountsession
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+const (
+	// LoginEvent is the event emitted with *OnlinePlayer when the session of the account is claimed
+	LoginEvent = "goworld.login"
+	// LogoutEvent is the event emitted with *OnlinePlayer when the session of the account is released or kicked, or the
+	// game of the entity holding the session is down
+	LogoutEvent = "goworld.logout"
+)
+
+// Session is the active session of the account held by the entity
+type Session struct {
+	EntityID  common.EntityID
+	GameID    uint16
+	GateID    uint16    // gate of the client of the entity, 0 if the entity has no client
+	LoginTime time.Time // when the session is claimed
+}
+
+// OnlinePlayer is the player online by the session of the account
+type OnlinePlayer struct {
+	Account   string          `msgpack:"account"`
+	EntityID  common.EntityID `msgpack:"entity"`
+	GameID    uint16          `msgpack:"game"`
+	GateID    uint16          `msgpack:"gate"`
+	LoginTime time.Time       `msgpack:"login_time"`
+}
+
+// Player returns the online player of the account by the session
+func (s Session) Player(account string) *OnlinePlayer {
+	return &OnlinePlayer{Account: account, EntityID: s.EntityID, GameID: s.GameID, GateID: s.GateID, LoginTime: s.LoginTime}
+}
+
+// Listener is called when the player is online by claiming the session, or offline by releasing or kicking the session
+type Listener func(player *OnlinePlayer, online bool)
+
+// Table is the table of active sessions of accounts, sessions of each account are ordered by claim time
+//
+// Table is not goroutine-safe
+type Table struct {
+	sessions map[string][]Session
+	listener Listener
+}
+
+// NewTable creates the empty session table
+func NewTable() *Table {
+	return &Table{
+		sessions: map[string][]Session{},
+	}
+}
+
+// SetListener sets the listener of players going online and offline, refreshed sessions are not notified
+func (t *Table) SetListener(listener Listener) {
+	t.listener = listener
+}
+
+func (t *Table) notify(account string, s Session, online bool) {
+	if t.listener != nil {
+		t.listener(s.Player(account), online)
+	}
+}
+
+// Claim claims the session of the account for the entity
+//
+// The account can have at most maxSessions sessions, or unlimited sessions if maxSessions is 0. If the account has too
+// many sessions, the oldest sessions are kicked and returned if kickOlder is true, otherwise the claim is rejected.
+// Claiming the session again by the same entity always succeeds.
+func (t *Table) Claim(account string, s Session, maxSessions int, kickOlder bool) (ok bool, kicked []Session) {
+	sessions := t.sessions[account]
+	if idx := indexOf(sessions, s.EntityID); idx >= 0 {
+		sessions[idx].GameID, sessions[idx].GateID = s.GameID, s.GateID
+		return true, nil
+	}
+
+	if maxSessions > 0 && len(sessions) >= maxSessions {
+		if !kickOlder {
+			return false, nil
+		}
+		n := len(sessions) - maxSessions + 1
+		kicked = append(kicked, sessions[:n]...)
+		sessions = append(sessions[:0:0], sessions[n:]...)
+	}
+	t.sessions[account] = append(sessions, s)
+	for _, ks := range kicked {
+		t.notify(account, ks, false)
+	}
+	t.notify(account, s, true)
+	return true, kicked
+}
+
+// Refresh adds the session of the account unconditionally, or updates its game and gate if the session exists
+//
+// Refresh is used for sessions already claimed, e.g. when the entity is migrated or gets a new client, or the
+// dispatcher is restarted.
+func (t *Table) Refresh(account string, s Session) {
+	sessions := t.sessions[account]
+	if idx := indexOf(sessions, s.EntityID); idx >= 0 {
+		sessions[idx].GameID, sessions[idx].GateID = s.GameID, s.GateID
+		return
+	}
+	t.sessions[account] = append(sessions, s)
+}
+
+// Release releases the session of the account held by the entity, and returns if the session existed
+func (t *Table) Release(account string, eid common.EntityID) bool {
+	sessions := t.sessions[account]
+	idx := indexOf(sessions, eid)
+	if idx < 0 {
+		return false
+	}
+
+	s := sessions[idx]
+	if len(sessions) == 1 {
+		delete(t.sessions, account)
+	} else {
+		t.sessions[account] = append(sessions[:idx:idx], sessions[idx+1:]...)
+	}
+	t.notify(account, s, false)
+	return true
+}
+
+// ReleaseGame releases all sessions held by entities on the game, and returns the number of released sessions
+func (t *Table) ReleaseGame(gameid uint16) int {
+	released := 0
+	for account, sessions := range t.sessions {
+		kept := sessions[:0]
+		var down []Session
+		for _, s := range sessions {
+			if s.GameID != gameid {
+				kept = append(kept, s)
+			} else {
+				down = append(down, s)
+			}
+		}
+		released += len(sessions) - len(kept)
+		if len(kept) == 0 {
+			delete(t.sessions, account)
+		} else {
+			t.sessions[account] = kept
+		}
+		for _, s := range down {
+			t.notify(account, s, false)
+		}
+	}
+	return released
+}
+
+// Sessions returns active sessions of the account, ordered by claim time
+func (t *Table) Sessions(account string) []Session {
+	return append([]Session(nil), t.sessions[account]...)
+}
+
+// FindPlayer returns the player of the latest session of the account, or nil if the account is offline
+func (t *Table) FindPlayer(account string) *OnlinePlayer {
+	sessions := t.sessions[account]
+	if len(sessions) == 0 {
+		return nil
+	}
+	return sessions[len(sessions)-1].Player(account)
+}
+
+// Len returns the number of accounts having active sessions
+func (t *Table) Len() int {
+	return len(t.sessions)
+}
+
+func indexOf(sessions []Session, eid common.EntityID) int {
+	for i, s := range sessions {
+		if s.EntityID == eid {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,118 @@
+package accountsession
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func TestClaimKickOlder(t *testing.T) {
+	table := NewTable()
+	s1 := Session{EntityID: common.GenEntityID(), GameID: 1}
+	s2 := Session{EntityID: common.GenEntityID(), GameID: 2}
+	s3 := Session{EntityID: common.GenEntityID(), GameID: 1}
+
+	if ok, kicked := table.Claim("alice", s1, 1, true); !ok || len(kicked) != 0 {
+		t.Fatalf("first claim should succeed without kicking, but got %v, %v", ok, kicked)
+	}
+	if ok, kicked := table.Claim("alice", s1, 1, true); !ok || len(kicked) != 0 {
+		t.Fatalf("claiming again by the same entity should succeed without kicking, but got %v, %v", ok, kicked)
+	}
+	if ok, kicked := table.Claim("alice", s2, 1, true); !ok || len(kicked) != 1 || kicked[0] != s1 {
+		t.Fatalf("second claim should kick the first session, but got %v, %v", ok, kicked)
+	}
+	if sessions := table.Sessions("alice"); len(sessions) != 1 || sessions[0] != s2 {
+		t.Fatalf("alice should have the second session, but got %v", sessions)
+	}
+
+	// refreshed sessions may exceed the limit, e.g. after the dispatcher is restarted
+	table.Refresh("alice", s3)
+	if ok, kicked := table.Claim("bob", s1, 1, true); !ok || len(kicked) != 0 {
+		t.Fatalf("claim of another account should succeed, but got %v, %v", ok, kicked)
+	}
+	if ok, kicked := table.Claim("alice", Session{EntityID: s1.EntityID, GameID: 3}, 1, true); !ok || len(kicked) != 2 {
+		t.Fatalf("claim should kick both older sessions, but got %v, %v", ok, kicked)
+	}
+}
+
+func TestClaimRejectNew(t *testing.T) {
+	table := NewTable()
+	s1 := Session{EntityID: common.GenEntityID(), GameID: 1}
+	s2 := Session{EntityID: common.GenEntityID(), GameID: 2}
+	s3 := Session{EntityID: common.GenEntityID(), GameID: 2}
+
+	for _, s := range []Session{s1, s2} {
+		if ok, _ := table.Claim("alice", s, 2, false); !ok {
+			t.Fatalf("claim of %v should succeed", s)
+		}
+	}
+	if ok, kicked := table.Claim("alice", s3, 2, false); ok || len(kicked) != 0 {
+		t.Fatalf("third claim should be rejected, but got %v, %v", ok, kicked)
+	}
+	if ok, _ := table.Claim("alice", s3, 0, false); !ok {
+		t.Fatalf("claim should succeed if sessions are unlimited")
+	}
+
+	if !table.Release("alice", s1.EntityID) || table.Release("alice", s1.EntityID) {
+		t.Fatalf("session should be released once")
+	}
+	if sessions := table.Sessions("alice"); len(sessions) != 2 || sessions[0] != s2 || sessions[1] != s3 {
+		t.Fatalf("sessions should be ordered by claim time, but got %v", sessions)
+	}
+}
+
+func TestReleaseGame(t *testing.T) {
+	table := NewTable()
+	table.Refresh("alice", Session{EntityID: common.GenEntityID(), GameID: 1})
+	table.Refresh("alice", Session{EntityID: common.GenEntityID(), GameID: 2})
+	table.Refresh("bob", Session{EntityID: common.GenEntityID(), GameID: 1})
+
+	if released := table.ReleaseGame(1); released != 2 {
+		t.Fatalf("2 sessions should be released, but got %d", released)
+	}
+	if table.Len() != 1 || len(table.Sessions("alice")) != 1 || len(table.Sessions("bob")) != 0 {
+		t.Fatalf("only the session of alice on game2 should be kept, but got %v, %v", table.Sessions("alice"), table.Sessions("bob"))
+	}
+}
+
+func TestOnlinePlayers(t *testing.T) {
+	table := NewTable()
+	var online, offline []*OnlinePlayer
+	table.SetListener(func(player *OnlinePlayer, isOnline bool) {
+		if isOnline {
+			online = append(online, player)
+		} else {
+			offline = append(offline, player)
+		}
+	})
+
+	s1 := Session{EntityID: common.GenEntityID(), GameID: 1, GateID: 1, LoginTime: time.Unix(1, 0)}
+	s2 := Session{EntityID: common.GenEntityID(), GameID: 2, GateID: 2, LoginTime: time.Unix(2, 0)}
+	table.Claim("alice", s1, 1, true)
+	table.Claim("alice", s1, 1, true) // claimed again by the same entity
+	if p := table.FindPlayer("alice"); len(online) != 1 || p == nil || *p != *s1.Player("alice") {
+		t.Fatalf("alice should be online by s1, but got %+v, %v", p, online)
+	}
+
+	// the entity gets a new client on gate3
+	table.Refresh("alice", Session{EntityID: s1.EntityID, GameID: 1, GateID: 3})
+	if p := table.FindPlayer("alice"); p.GateID != 3 || !p.LoginTime.Equal(s1.LoginTime) || len(online) != 1 {
+		t.Fatalf("gate of alice should be refreshed without notifying, but got %+v", p)
+	}
+
+	table.Claim("alice", s2, 1, true)
+	if len(offline) != 1 || offline[0].EntityID != s1.EntityID || len(online) != 2 || table.FindPlayer("alice").EntityID != s2.EntityID {
+		t.Fatalf("s1 should be offline after kicked by s2, but got %v, %v", online, offline)
+	}
+
+	table.Claim("bob", s1, 0, false)
+	table.ReleaseGame(1)
+	table.Release("alice", s2.EntityID)
+	if len(offline) != 3 || offline[1].Account != "bob" || offline[2].Account != "alice" {
+		t.Fatalf("bob and alice should be offline, but got %v", offline)
+	}
+	if table.FindPlayer("alice") != nil || table.FindPlayer("bob") != nil {
+		t.Fatalf("no player should be online")
+	}
+}
@@ -1,13 +1,14 @@
 package async
 
 import (
+	"context"
 	"sync"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/post"
-	"golang.org/x/net/context"
 )
 
 var (
@@ -18,9 +19,9 @@ var (
 // AsyncCallback is a function which will be called after async job is finished with result and error
 type AsyncCallback func(res interface{}, err error)
 
-func (ac AsyncCallback) callback(res interface{}, err error) {
+func (ac AsyncCallback) callback(ctx context.Context, res interface{}, err error) {
 	if ac != nil {
-		post.Post(func() {
+		post.PostContext(ctx, func() {
 			ac(res, err)
 		})
 	}
@@ -29,26 +30,41 @@ func (ac AsyncCallback) callback(res interface{}, err error) {
 // AsyncRoutine is a function that will be executed in the async goroutine and its result and error will be passed to AsyncCallback
 type AsyncRoutine func() (res interface{}, err error)
 
+// ContextAsyncRoutine is an AsyncRoutine receiving the context of the async job
+type ContextAsyncRoutine func(ctx context.Context) (res interface{}, err error)
+
+// asyncJobWorker is the pool of worker goroutines running jobs of the group
 type asyncJobWorker struct {
-	jobQueue chan asyncJobItem
+	jobQueue   chan asyncJobItem
+	numWorkers int
 }
 
 type asyncJobItem struct {
+	ctx      context.Context
 	routine  AsyncRoutine
 	callback AsyncCallback
 }
 
-func newAsyncJobWorker() *asyncJobWorker {
+func newAsyncJobWorker(numWorkers int) *asyncJobWorker {
+	if numWorkers <= 0 {
+		numWorkers = 1
+	}
 	ajw := &asyncJobWorker{
 		jobQueue: make(chan asyncJobItem, consts.ASYNC_JOB_QUEUE_MAXLEN),
 	}
-	numAsyncJobWorkersRunning.Add(1)
-	go ajw.loop()
+	ajw.startWorkers(numWorkers)
 	return ajw
 }
 
-func (ajw *asyncJobWorker) appendJob(routine AsyncRoutine, callback AsyncCallback) {
-	ajw.jobQueue <- asyncJobItem{routine, callback}
+func (ajw *asyncJobWorker) startWorkers(numWorkers int) {
+	for ; ajw.numWorkers < numWorkers; ajw.numWorkers++ {
+		numAsyncJobWorkersRunning.Add(1)
+		go ajw.loop()
+	}
+}
+
+func (ajw *asyncJobWorker) appendJob(ctx context.Context, routine AsyncRoutine, callback AsyncCallback) {
+	ajw.jobQueue <- asyncJobItem{ctx, routine, callback}
 }
 
 func (ajw *asyncJobWorker) loop() {
@@ -56,17 +72,50 @@ func (ajw *asyncJobWorker) loop() {
 
 	gwutils.RepeatUntilPanicless(func() {
 		for item := range ajw.jobQueue {
-			res, err := item.routine()
-			item.callback.callback(res, err)
+			res, err := item.run()
+			item.callback.callback(item.ctx, res, err)
 		}
 	})
 }
 
+// run runs the routine, and returns the panic as error so that the callback is always called
+func (item *asyncJobItem) run() (res interface{}, err error) {
+	if err := item.ctx.Err(); err != nil {
+		return nil, err // the job is canceled or timed out before running
+	}
+
+	defer func() {
+		if r := recover(); r != nil {
+			gwlog.TraceError("async routine paniced: %v", r)
+			err = errors.Errorf("async routine paniced: %v", r)
+		}
+	}()
+	return item.routine()
+}
+
 var (
 	asyncJobWorkersLock sync.RWMutex
 	asyncJobWorkers     = map[string]*asyncJobWorker{}
+	asyncWorkerCounts   = map[string]int{} // number of workers of groups, protected by asyncJobWorkersLock
 )
 
+// SetWorkerCount sets the number of worker goroutines running async jobs of the group, which is 1 by default
+//
+// Jobs of the group run in the order of appending only if there is 1 worker. The worker pool of running group grows
+// if the count is increased, but never shrinks.
+func SetWorkerCount(group string, count int) {
+	if count <= 0 {
+		count = 1
+	}
+
+	asyncJobWorkersLock.Lock()
+	asyncWorkerCounts[group] = count
+	if ajw := asyncJobWorkers[group]; ajw != nil {
+		ajw.startWorkers(count)
+	}
+	asyncJobWorkersLock.Unlock()
+}
+
 func getAsyncJobWorker(group string) (ajw *asyncJobWorker) {
 	asyncJobWorkersLock.RLock()
 	ajw = asyncJobWorkers[group]
@@ -76,7 +125,7 @@ func getAsyncJobWorker(group string) (ajw *asyncJobWorker) {
 		asyncJobWorkersLock.Lock()
 		ajw = asyncJobWorkers[group]
 		if ajw == nil {
-			ajw = newAsyncJobWorker()
+			ajw = newAsyncJobWorker(asyncWorkerCounts[group])
 			asyncJobWorkers[group] = ajw
 		}
 		asyncJobWorkersLock.Unlock()
@@ -87,7 +136,18 @@ func getAsyncJobWorker(group string) (ajw *asyncJobWorker) {
 // AppendAsyncJob append an async job to be executed asyncly (not in the game goroutine)
 func AppendAsyncJob(group string, routine AsyncRoutine, callback AsyncCallback) {
 	ajw := getAsyncJobWorker(group)
-	ajw.appendJob(routine, callback)
+	ajw.appendJob(context.Background(), routine, callback)
+}
+
+// AppendAsyncJobContext appends an async job with the context
+//
+// The routine is skipped if ctx is done before it runs, and the callback receives the error of ctx. The callback is
+// dropped if ctx is canceled, e.g. the context of the entity which is destroyed.
+func AppendAsyncJobContext(ctx context.Context, group string, routine ContextAsyncRoutine, callback AsyncCallback) {
+	ajw := getAsyncJobWorker(group)
+	ajw.appendJob(ctx, func() (res interface{}, err error) {
+		return routine(ctx)
+	}, callback)
 }
 
 // WaitClear wait for all async job workers to finish (should only be called in the game goroutine)
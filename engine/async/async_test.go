@@ -1,6 +1,7 @@
 package async
 
 import (
+	"context"
 	"sync"
 	"testing"
 
@@ -22,6 +23,63 @@ func TestNewAsyncJob(t *testing.T) {
 	wait.Wait()
 }
 
+func TestWorkerPool(t *testing.T) {
+	SetWorkerCount("pool", 4)
+	var running, done sync.WaitGroup
+	running.Add(4)
+	done.Add(4)
+	for i := 0; i < 4; i++ {
+		AppendAsyncJob("pool", func() (res interface{}, err error) {
+			running.Done()
+			running.Wait() // blocks until all jobs are running concurrently
+			return nil, nil
+		}, func(res interface{}, err error) {
+			done.Done()
+		})
+	}
+	done.Wait()
+}
+
+func TestAsyncPanic(t *testing.T) {
+	errChan := make(chan error, 1)
+	AppendAsyncJob("panic", func() (res interface{}, err error) {
+		panic("async panic")
+	}, func(res interface{}, err error) {
+		errChan <- err
+	})
+	if err := <-errChan; err == nil {
+		t.Errorf("panic in async routine should be returned as error")
+	}
+}
+
+func TestAsyncContext(t *testing.T) {
+	ctx, cancel := context.WithDeadline(context.Background(), time.Now())
+	defer cancel()
+	errChan := make(chan error, 1)
+	AppendAsyncJobContext(ctx, "context", func(ctx context.Context) (res interface{}, err error) {
+		t.Errorf("routine should not run after deadline")
+		return nil, nil
+	}, func(res interface{}, err error) {
+		errChan <- err
+	})
+	if err := <-errChan; err != context.DeadlineExceeded {
+		t.Errorf("callback should receive deadline exceeded, but got %v", err)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	var wait sync.WaitGroup
+	wait.Add(1)
+	AppendAsyncJobContext(ctx, "context", func(ctx context.Context) (res interface{}, err error) {
+		cancel()
+		wait.Done()
+		return nil, nil
+	}, func(res interface{}, err error) {
+		t.Errorf("callback should be dropped if the context is canceled")
+	})
+	wait.Wait()
+	time.Sleep(time.Millisecond * 10)
+}
+
 func init() {
 	go func() {
 		for {
@@ -0,0 +1,53 @@
+// Package auth provides pluggable verifiers for authenticating clients at gates
+//
+// Clients send auth tokens to gates before any entity is created for them. The verifier configured by auth_method
+// of the gate verifies the token and returns the authenticated user ID, which is passed to game as GameClient.AuthID().
+package auth
+
+import (
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// Verifier verifies auth tokens sent by clients
+//
+// Verify is called in a separate goroutine for each client, so it can block (e.g. for querying databases or HTTP services)
+type Verifier interface {
+	Verify(token string) (authID string, err error)
+}
+
+// VerifierFactory creates the Verifier using the gate config
+type VerifierFactory func(cfg *config.GateConfig) (Verifier, error)
+
+var (
+	// ErrInvalidToken is returned by verifiers when the token is invalid or expired
+	ErrInvalidToken = errors.New("invalid auth token")
+
+	verifierFactoriesLock sync.RWMutex
+	verifierFactories     = map[string]VerifierFactory{
+		"jwt":  newJWTVerifier,
+		"kvdb": newKVDBVerifier,
+		"http": newHTTPVerifier,
+	}
+)
+
+// RegisterVerifier registers custom verifier which can be used by setting auth_method of gate to the name
+func RegisterVerifier(name string, factory VerifierFactory) {
+	verifierFactoriesLock.Lock()
+	verifierFactories[name] = factory
+	verifierFactoriesLock.Unlock()
+}
+
+// NewVerifier creates the verifier of the auth method
+func NewVerifier(method string, cfg *config.GateConfig) (Verifier, error) {
+	verifierFactoriesLock.RLock()
+	factory := verifierFactories[method]
+	verifierFactoriesLock.RUnlock()
+
+	if factory == nil {
+		return nil, errors.Errorf("unknown auth method: %s", method)
+	}
+	return factory(cfg)
+}
@@ -0,0 +1,125 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+func makeJWT(alg string, claims map[string]interface{}, secret []byte) string {
+	header, _ := json.Marshal(map[string]string{"alg": alg, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(signingInput))
+	return signingInput + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func TestVerifyJWT(t *testing.T) {
+	secret := []byte("test secret")
+	now := time.Now()
+
+	token := makeJWT("HS256", map[string]interface{}{"sub": "user1", "exp": now.Unix() + 60}, secret)
+	authID, err := verifyJWT(token, secret, now)
+	if err != nil || authID != "user1" {
+		t.Fatalf("verify valid JWT failed: %v, %v", authID, err)
+	}
+
+	if _, err := verifyJWT(token, []byte("wrong secret"), now); err == nil {
+		t.Fatalf("JWT with wrong secret should be rejected")
+	}
+
+	if _, err := verifyJWT(token, secret, now.Add(time.Minute*2)); err == nil {
+		t.Fatalf("expired JWT should be rejected")
+	}
+
+	token = makeJWT("HS256", map[string]interface{}{"sub": "user1", "nbf": now.Unix() + 60}, secret)
+	if _, err := verifyJWT(token, secret, now); err == nil {
+		t.Fatalf("JWT not valid yet should be rejected")
+	}
+
+	token = makeJWT("none", map[string]interface{}{"sub": "user1"}, secret)
+	if _, err := verifyJWT(token, secret, now); err == nil {
+		t.Fatalf("JWT with alg none should be rejected")
+	}
+
+	token = makeJWT("HS256", map[string]interface{}{"exp": now.Unix() + 60}, secret)
+	if _, err := verifyJWT(token, secret, now); err == nil {
+		t.Fatalf("JWT without subject should be rejected")
+	}
+
+	if _, err := verifyJWT("not a jwt", secret, now); err == nil {
+		t.Fatalf("malformed JWT should be rejected")
+	}
+}
+
+func TestHTTPVerifier(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req httpAuthRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		if req.Token != "good token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		json.NewEncoder(w).Encode(httpAuthResponse{AuthID: "user2"})
+	}))
+	defer server.Close()
+
+	verifier, err := NewVerifier("http", &config.GateConfig{AuthHTTPURL: server.URL, AuthTimeout: time.Second})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	authID, err := verifier.Verify("good token")
+	if err != nil || authID != "user2" {
+		t.Fatalf("verify good token failed: %v, %v", authID, err)
+	}
+
+	if _, err := verifier.Verify("bad token"); err == nil {
+		t.Fatalf("bad token should be rejected")
+	}
+}
+
+type testVerifier struct{}
+
+func (v testVerifier) Verify(token string) (string, error) {
+	return token, nil
+}
+
+func TestRegisterVerifier(t *testing.T) {
+	if _, err := NewVerifier("test", &config.GateConfig{}); err == nil {
+		t.Fatalf("unknown auth method should fail")
+	}
+
+	RegisterVerifier("test", func(cfg *config.GateConfig) (Verifier, error) {
+		return testVerifier{}, nil
+	})
+	verifier, err := NewVerifier("test", &config.GateConfig{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if authID, _ := verifier.Verify("user3"); authID != "user3" {
+		t.Fatalf("custom verifier returns wrong auth ID: %s", authID)
+	}
+}
+
+func TestParseKVDBToken(t *testing.T) {
+	now := time.Now()
+	val, _ := json.Marshal(kvdbToken{AuthID: "user1", CreateTime: now.Unix()})
+	if authID, err := parseKVDBToken(string(val), time.Minute, now); err != nil || authID != "user1" {
+		t.Fatalf("parse valid token failed: %v, %v", authID, err)
+	}
+	if _, err := parseKVDBToken(string(val), time.Minute, now.Add(time.Minute*2)); err != ErrInvalidToken {
+		t.Errorf("expired token should be rejected, but got %v", err)
+	}
+	if _, err := parseKVDBToken("user1", time.Minute, now); err != ErrInvalidToken {
+		t.Errorf("token without create time should be rejected, but got %v", err)
+	}
+}
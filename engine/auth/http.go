@@ -0,0 +1,63 @@
+package auth
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// httpVerifier posts tokens to an external auth service
+//
+// The request body is {"token": "<token>"}. The service should respond 200 with {"auth_id": "<auth ID>"} if the token is valid,
+// or any other status if the token is invalid.
+type httpVerifier struct {
+	url    string
+	client *http.Client
+}
+
+type httpAuthRequest struct {
+	Token string `json:"token"`
+}
+
+type httpAuthResponse struct {
+	AuthID string `json:"auth_id"`
+}
+
+func newHTTPVerifier(cfg *config.GateConfig) (Verifier, error) {
+	if cfg.AuthHTTPURL == "" {
+		return nil, errors.New("auth_http_url is not set")
+	}
+	return &httpVerifier{
+		url:    cfg.AuthHTTPURL,
+		client: &http.Client{Timeout: cfg.AuthTimeout},
+	}, nil
+}
+
+func (v *httpVerifier) Verify(token string) (string, error) {
+	body, err := json.Marshal(httpAuthRequest{Token: token})
+	if err != nil {
+		return "", err
+	}
+
+	resp, err := v.client.Post(v.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return "", errors.Wrap(err, "request auth service failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Wrapf(ErrInvalidToken, "auth service responded %s", resp.Status)
+	}
+
+	var authResp httpAuthResponse
+	if err := json.NewDecoder(resp.Body).Decode(&authResp); err != nil {
+		return "", errors.Wrap(err, "decode auth service response failed")
+	}
+	if authResp.AuthID == "" {
+		return "", errors.Wrap(ErrInvalidToken, "auth service responded empty auth_id")
+	}
+	return authResp.AuthID, nil
+}
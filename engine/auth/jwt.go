@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+// jwtVerifier verifies JSON Web Tokens signed using HS256, the subject of token is used as the auth ID
+type jwtVerifier struct {
+	secret []byte
+}
+
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+type jwtClaims struct {
+	Sub string `json:"sub"`
+	Exp int64  `json:"exp"`
+	Nbf int64  `json:"nbf"`
+}
+
+func newJWTVerifier(cfg *config.GateConfig) (Verifier, error) {
+	if cfg.AuthJWTSecret == "" {
+		return nil, errors.New("auth_jwt_secret is not set")
+	}
+	return &jwtVerifier{secret: []byte(cfg.AuthJWTSecret)}, nil
+}
+
+func (v *jwtVerifier) Verify(token string) (string, error) {
+	return verifyJWT(token, v.secret, time.Now())
+}
+
+func verifyJWT(token string, secret []byte, now time.Time) (string, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return "", errors.Wrap(ErrInvalidToken, "malformed JWT")
+	}
+
+	var header jwtHeader
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return "", err
+	}
+	if header.Alg != "HS256" {
+		return "", errors.Wrapf(ErrInvalidToken, "unsupported JWT algorithm: %s", header.Alg)
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return "", errors.Wrap(ErrInvalidToken, "malformed JWT signature")
+	}
+	mac := hmac.New(sha256.New, secret)
+	mac.Write([]byte(parts[0] + "." + parts[1]))
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return "", errors.Wrap(ErrInvalidToken, "wrong JWT signature")
+	}
+
+	var claims jwtClaims
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return "", err
+	}
+	if claims.Exp != 0 && now.Unix() >= claims.Exp {
+		return "", errors.Wrap(ErrInvalidToken, "JWT expired")
+	}
+	if claims.Nbf != 0 && now.Unix() < claims.Nbf {
+		return "", errors.Wrap(ErrInvalidToken, "JWT not valid yet")
+	}
+	if claims.Sub == "" {
+		return "", errors.Wrap(ErrInvalidToken, "JWT subject is empty")
+	}
+	return claims.Sub, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return errors.Wrap(ErrInvalidToken, "malformed JWT")
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return errors.Wrap(ErrInvalidToken, "malformed JWT")
+	}
+	return nil
+}
@@ -0,0 +1,90 @@
+package auth
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+)
+
+// kvdbToken is the value of the token saved in KVDB
+type kvdbToken struct {
+	AuthID     string `json:"auth_id"`
+	CreateTime int64  `json:"create_time"` // unix time in seconds
+}
+
+// kvdbVerifier looks up tokens in KVDB, the value of key <prefix><token> is the auth ID and the create time of the token
+//
+// Tokens are usually saved to KVDB by login services using PutKVDBToken before clients connect to gates, and expire
+// after the TTL since created.
+type kvdbVerifier struct {
+	keyPrefix string
+	ttl       time.Duration
+}
+
+func newKVDBVerifier(cfg *config.GateConfig) (Verifier, error) {
+	if config.GetKVDB().Type == "" {
+		return nil, errors.New("KVDB is not configured")
+	}
+	return &kvdbVerifier{keyPrefix: cfg.AuthKVDBKeyPrefix, ttl: cfg.AuthKVDBTokenTTL}, nil
+}
+
+// PutKVDBToken saves the token of the auth ID to KVDB for kvdb auth method, keyPrefix should be auth_kvdb_key_prefix
+// of gates, KVDB should be initialized
+func PutKVDBToken(keyPrefix string, token string, authID string, callback func(err error)) {
+	data, err := json.Marshal(kvdbToken{AuthID: authID, CreateTime: time.Now().Unix()})
+	if err != nil {
+		if callback != nil {
+			callback(err)
+		}
+		return
+	}
+	kvdb.Put(keyPrefix+token, string(data), callback)
+}
+
+func (v *kvdbVerifier) Verify(token string) (string, error) {
+	if token == "" {
+		return "", ErrInvalidToken
+	}
+
+	type result struct {
+		val string
+		err error
+	}
+	// KVDB callbacks are called in the main goroutine, so Verify must not be called in the main goroutine
+	resultChan := make(chan result, 1)
+	kvdb.Get(v.keyPrefix+token, func(val string, err error) {
+		resultChan <- result{val, err}
+	})
+
+	res := <-resultChan
+	if res.err != nil {
+		return "", errors.Wrap(res.err, "lookup token in KVDB failed")
+	}
+	if res.val == "" {
+		return "", ErrInvalidToken
+	}
+
+	authID, err := parseKVDBToken(res.val, v.ttl, time.Now())
+	if err == ErrInvalidToken {
+		// expired tokens are deleted, so that they do not pile up in KVDB
+		kvdb.Delete(v.keyPrefix+token, nil)
+	}
+	return authID, err
+}
+
+// parseKVDBToken returns the auth ID of the token value, or ErrInvalidToken if the token is expired at now
+func parseKVDBToken(val string, ttl time.Duration, now time.Time) (string, error) {
+	var token kvdbToken
+	if err := json.Unmarshal([]byte(val), &token); err != nil || token.AuthID == "" || token.CreateTime == 0 {
+		gwlog.Warnf("auth: invalid token value in KVDB: %#v", val)
+		return "", ErrInvalidToken
+	}
+	if !now.Before(time.Unix(token.CreateTime, 0).Add(ttl)) {
+		return "", ErrInvalidToken
+	}
+	return token.AuthID, nil
+}
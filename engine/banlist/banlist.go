@@ -0,0 +1,339 @@
+// Package banlist manages bans of client IPs or CIDRs, accounts and devices, which are enforced by gates when clients
+// connect and handshake.
+//
+// Bans are published to all gates through dispatchers and applied immediately: gates ban flooding clients, operators ban
+// clients by admin endpoints of gates (or goworld ban), and games ban clients by goworld.Ban. Bans are also saved in KVDB
+// if persist_ban_list is enabled at gates, and gates reload bans from KVDB every ban_list_refresh_interval seconds, so
+// that bans are kept after gates restart.
+package banlist
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+// Kind is the kind of banned values
+type Kind string
+
+const (
+	// KindIP bans client IPs, or CIDRs like 10.0.0.0/8
+	KindIP Kind = "ip"
+	// KindAccount bans auth IDs of clients authenticated by gates
+	KindAccount Kind = "account"
+	// KindDevice bans device IDs sent by clients
+	KindDevice Kind = "device"
+)
+
+const (
+	_KVDB_KEY_PREFIX = "_gate_ban_:"
+	// _KVDB_KEY_END is larger than all keys of bans, since ';' is the next character of ':'
+	_KVDB_KEY_END = "_gate_ban_;"
+	// _MAX_VALUE_LEN is the max length of banned accounts and devices
+	_MAX_VALUE_LEN = 256
+)
+
+// Ban is the ban of the IP, CIDR, account or device
+type Ban struct {
+	Kind       Kind      `json:"kind"`
+	Value      string    `json:"value"`
+	Reason     string    `json:"reason"`
+	By         string    `json:"by"` // who banned the value, e.g. gate1 for flooding clients, admin or game1
+	CreateTime time.Time `json:"create_time"`
+	ExpireTime time.Time `json:"expire_time"` // zero for permanent bans
+}
+
+// NewBan creates the ban of the value which expires after duration, or never expires if duration is 0
+func NewBan(kind Kind, value string, duration time.Duration, reason string, by string) (Ban, error) {
+	value, err := Normalize(kind, value)
+	if err != nil {
+		return Ban{}, err
+	}
+	if duration < 0 {
+		return Ban{}, errors.Errorf("invalid ban duration: %s", duration)
+	}
+
+	now := time.Now()
+	ban := Ban{Kind: kind, Value: value, Reason: reason, By: by, CreateTime: now}
+	if duration > 0 {
+		ban.ExpireTime = now.Add(duration)
+	}
+	return ban, nil
+}
+
+// IsExpired returns if the ban is expired at the time
+func (ban *Ban) IsExpired(now time.Time) bool {
+	return !ban.ExpireTime.IsZero() && !now.Before(ban.ExpireTime)
+}
+
+func (ban Ban) String() string {
+	expire := "permanently"
+	if !ban.ExpireTime.IsZero() {
+		expire = "until " + ban.ExpireTime.Format(time.RFC3339)
+	}
+	return fmt.Sprintf("%s %s banned %s by %s: %s", ban.Kind, ban.Value, expire, ban.By, ban.Reason)
+}
+
+// Normalize checks the value of the kind and returns the normalized value, e.g. CIDRs are masked
+func Normalize(kind Kind, value string) (string, error) {
+	value = strings.TrimSpace(value)
+	switch kind {
+	case KindIP:
+		if strings.Contains(value, "/") {
+			_, ipnet, err := net.ParseCIDR(value)
+			if err != nil {
+				return "", errors.Errorf("invalid CIDR: %#v", value)
+			}
+			return ipnet.String(), nil
+		}
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return "", errors.Errorf("invalid IP: %#v", value)
+		}
+		return ip.String(), nil
+	case KindAccount, KindDevice:
+		if value == "" || len(value) > _MAX_VALUE_LEN {
+			return "", errors.Errorf("invalid %s: %#v", kind, value)
+		}
+		return value, nil
+	default:
+		return "", errors.Errorf("invalid ban kind: %#v, should be ip, account or device", kind)
+	}
+}
+
+func banKey(kind Kind, value string) string {
+	return string(kind) + ":" + value
+}
+
+// List is the in-memory list of bans for checking clients
+//
+// List is used in multiple goroutines
+type List struct {
+	sync.Mutex
+	bans  map[string]Ban        // kind:value -> ban
+	cidrs map[string]*net.IPNet // banned CIDR -> network
+}
+
+// NewList creates the empty ban list
+func NewList() *List {
+	return &List{
+		bans:  map[string]Ban{},
+		cidrs: map[string]*net.IPNet{},
+	}
+}
+
+// Add adds the ban to the list, replacing the ban of the same value
+func (l *List) Add(ban Ban) {
+	l.Lock()
+	l.add(ban)
+	l.Unlock()
+}
+
+func (l *List) add(ban Ban) {
+	l.bans[banKey(ban.Kind, ban.Value)] = ban
+	if ban.Kind == KindIP && strings.Contains(ban.Value, "/") {
+		if _, ipnet, err := net.ParseCIDR(ban.Value); err == nil {
+			l.cidrs[ban.Value] = ipnet
+		}
+	}
+}
+
+// Remove removes the ban of the value, and returns if the value was banned
+func (l *List) Remove(kind Kind, value string) bool {
+	l.Lock()
+	defer l.Unlock()
+	return l.remove(kind, value)
+}
+
+func (l *List) remove(kind Kind, value string) bool {
+	key := banKey(kind, value)
+	if _, ok := l.bans[key]; !ok {
+		return false
+	}
+	delete(l.bans, key)
+	if kind == KindIP {
+		delete(l.cidrs, value)
+	}
+	return true
+}
+
+// Replace replaces bans in the list by bans loaded from KVDB
+//
+// Bans created after since are kept, because they may be added after loading started and not loaded.
+func (l *List) Replace(bans []Ban, since time.Time) {
+	l.Lock()
+	defer l.Unlock()
+
+	for _, ban := range l.bans {
+		if ban.CreateTime.Before(since) {
+			l.remove(ban.Kind, ban.Value)
+		}
+	}
+	for _, ban := range bans {
+		if old, ok := l.bans[banKey(ban.Kind, ban.Value)]; ok && old.CreateTime.After(ban.CreateTime) {
+			continue
+		}
+		l.add(ban)
+	}
+}
+
+// Find returns the ban of the value which is not expired, IPs are also checked against banned CIDRs
+func (l *List) Find(kind Kind, value string) (Ban, bool) {
+	if value == "" {
+		return Ban{}, false
+	}
+	if kind == KindIP {
+		ip := net.ParseIP(value)
+		if ip == nil {
+			return Ban{}, false
+		}
+		value = ip.String()
+	}
+
+	now := time.Now()
+	l.Lock()
+	defer l.Unlock()
+
+	if ban, ok := l.bans[banKey(kind, value)]; ok {
+		if !ban.IsExpired(now) {
+			return ban, true
+		}
+		l.remove(kind, value)
+	}
+
+	if kind == KindIP && len(l.cidrs) > 0 {
+		ip := net.ParseIP(value)
+		for cidr, ipnet := range l.cidrs {
+			if !ipnet.Contains(ip) {
+				continue
+			}
+			ban := l.bans[banKey(KindIP, cidr)]
+			if !ban.IsExpired(now) {
+				return ban, true
+			}
+			l.remove(KindIP, cidr)
+		}
+	}
+	return Ban{}, false
+}
+
+// Bans returns all bans which are not expired, sorted by kinds and values
+func (l *List) Bans() []Ban {
+	now := time.Now()
+	l.Lock()
+	bans := make([]Ban, 0, len(l.bans))
+	for _, ban := range l.bans {
+		if !ban.IsExpired(now) {
+			bans = append(bans, ban)
+		}
+	}
+	l.Unlock()
+
+	sort.Slice(bans, func(i, j int) bool {
+		if bans[i].Kind != bans[j].Kind {
+			return bans[i].Kind < bans[j].Kind
+		}
+		return bans[i].Value < bans[j].Value
+	})
+	return bans
+}
+
+// Save saves the ban to KVDB, KVDB should be initialized
+func Save(ban Ban, callback func(err error)) {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		if callback != nil {
+			callback(err)
+		}
+		return
+	}
+	kvdb.Put(_KVDB_KEY_PREFIX+banKey(ban.Kind, ban.Value), string(data), callback)
+}
+
+// Delete deletes the ban of the value from KVDB, KVDB should be initialized
+func Delete(kind Kind, value string, callback func(err error)) {
+	if kind == KindIP && !strings.Contains(value, "/") {
+		kvdb.Delete(_KVDB_KEY_PREFIX+value, nil) // IPs banned by older gates
+	}
+	kvdb.Delete(_KVDB_KEY_PREFIX+banKey(kind, value), callback)
+}
+
+// Publish broadcasts the ban to all gates through dispatchers, dispatchers should be connected
+func Publish(ban Ban) error {
+	data, err := json.Marshal(ban)
+	if err != nil {
+		return err
+	}
+	return dispatchercluster.SendSyncBanList(string(ban.Kind), ban.Value, string(data))
+}
+
+// PublishDelete broadcasts the unban of the value to all gates through dispatchers, dispatchers should be connected
+func PublishDelete(kind Kind, value string) error {
+	return dispatchercluster.SendSyncBanList(string(kind), value, "")
+}
+
+// Decode decodes the ban published by Publish
+func Decode(data string) (Ban, error) {
+	var ban Ban
+	if err := json.Unmarshal([]byte(data), &ban); err != nil {
+		return Ban{}, err
+	}
+	value, err := Normalize(ban.Kind, ban.Value)
+	if err != nil {
+		return Ban{}, err
+	}
+	ban.Value = value
+	return ban, nil
+}
+
+// Load loads all bans which are not expired from KVDB, KVDB should be initialized
+func Load(callback func(bans []Ban, err error)) {
+	kvdb.GetRange(_KVDB_KEY_PREFIX, _KVDB_KEY_END, func(items []kvdbtypes.KVItem, err error) {
+		if err != nil {
+			callback(nil, err)
+			return
+		}
+
+		now := time.Now()
+		var bans []Ban
+		for _, item := range items {
+			if item.Val == "" { // unbanned by older gates which saved empty values
+				continue
+			}
+			ban, err := decodeBan(strings.TrimPrefix(item.Key, _KVDB_KEY_PREFIX), item.Val)
+			if err != nil {
+				gwlog.Warnf("banlist: invalid ban %s = %#v: %s", item.Key, item.Val, err)
+				continue
+			}
+			if !ban.IsExpired(now) {
+				bans = append(bans, ban)
+			}
+		}
+		callback(bans, nil)
+	})
+}
+
+// decodeBan decodes the ban saved in KVDB
+//
+// Older gates saved banned IPs as <ip> = <expire unix time>, which are decoded as IP bans.
+func decodeBan(key string, val string) (Ban, error) {
+	if expireUnix, err := strconv.ParseInt(val, 10, 64); err == nil {
+		ip, err := Normalize(KindIP, key)
+		if err != nil {
+			return Ban{}, err
+		}
+		return Ban{Kind: KindIP, Value: ip, Reason: "banned by older gates", ExpireTime: time.Unix(expireUnix, 0)}, nil
+	}
+	return Decode(val)
+}
@@ -0,0 +1,130 @@
+package banlist
+
+import (
+	"encoding/json"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestNormalize(t *testing.T) {
+	for _, c := range []struct {
+		kind   Kind
+		value  string
+		result string
+		ok     bool
+	}{
+		{KindIP, "10.0.0.1", "10.0.0.1", true},
+		{KindIP, " ::1 ", "::1", true},
+		{KindIP, "10.1.2.3/8", "10.0.0.0/8", true},
+		{KindIP, "10.0.0", "", false},
+		{KindIP, "10.0.0.0/33", "", false},
+		{KindAccount, "alice", "alice", true},
+		{KindAccount, "", "", false},
+		{KindDevice, "device-1", "device-1", true},
+		{"user", "alice", "", false},
+	} {
+		result, err := Normalize(c.kind, c.value)
+		if (err == nil) != c.ok || result != c.result {
+			t.Errorf("normalize %s %#v should return %#v, %v, but got %#v, %v", c.kind, c.value, c.result, c.ok, result, err)
+		}
+	}
+}
+
+func TestListFind(t *testing.T) {
+	l := NewList()
+	for _, c := range []struct {
+		kind     Kind
+		value    string
+		duration time.Duration
+	}{
+		{KindIP, "1.2.3.4", time.Hour},
+		{KindIP, "10.0.0.0/8", 0},
+		{KindIP, "5.6.7.8", -time.Second}, // expired
+		{KindAccount, "alice", time.Hour},
+		{KindDevice, "device-1", 0},
+	} {
+		ban := Ban{Kind: c.kind, Value: c.value, CreateTime: time.Now()}
+		if c.duration != 0 {
+			ban.ExpireTime = time.Now().Add(c.duration)
+		}
+		l.Add(ban)
+	}
+
+	for _, c := range []struct {
+		kind   Kind
+		value  string
+		banned bool
+	}{
+		{KindIP, "1.2.3.4", true},
+		{KindIP, "10.20.30.40", true},
+		{KindIP, "11.0.0.1", false},
+		{KindIP, "5.6.7.8", false},
+		{KindIP, "alice", false},
+		{KindAccount, "alice", true},
+		{KindAccount, "bob", false},
+		{KindDevice, "device-1", true},
+		{KindDevice, "", false},
+	} {
+		if _, banned := l.Find(c.kind, c.value); banned != c.banned {
+			t.Errorf("%s %s should be banned: %v", c.kind, c.value, c.banned)
+		}
+	}
+	if len(l.Bans()) != 4 {
+		t.Errorf("there should be 4 bans which are not expired, but got %v", l.Bans())
+	}
+
+	if !l.Remove(KindIP, "10.0.0.0/8") || l.Remove(KindIP, "10.0.0.0/8") {
+		t.Errorf("10.0.0.0/8 should be removed once")
+	}
+	if _, banned := l.Find(KindIP, "10.20.30.40"); banned {
+		t.Errorf("10.20.30.40 should not be banned after 10.0.0.0/8 is removed")
+	}
+}
+
+func TestListReplace(t *testing.T) {
+	l := NewList()
+	since := time.Now()
+	l.Add(Ban{Kind: KindAccount, Value: "old", CreateTime: since.Add(-time.Second)})
+	l.Add(Ban{Kind: KindAccount, Value: "new", CreateTime: since.Add(time.Second)})
+
+	l.Replace([]Ban{{Kind: KindDevice, Value: "loaded", CreateTime: since.Add(-time.Minute)}}, since)
+	for value, banned := range map[string]bool{"old": false, "new": true} {
+		if _, ok := l.Find(KindAccount, value); ok != banned {
+			t.Errorf("account %s should be banned: %v", value, banned)
+		}
+	}
+	if _, ok := l.Find(KindDevice, "loaded"); !ok {
+		t.Errorf("loaded device should be banned")
+	}
+}
+
+func TestDecodeBan(t *testing.T) {
+	expireTime := time.Now().Add(time.Hour).Truncate(time.Second)
+	ban, err := decodeBan("1.2.3.4", strconv.FormatInt(expireTime.Unix(), 10))
+	if err != nil || ban.Kind != KindIP || ban.Value != "1.2.3.4" || !ban.ExpireTime.Equal(expireTime) {
+		t.Errorf("IPs banned by older gates should be decoded, but got %v, %v", ban, err)
+	}
+
+	ban, err = decodeBan("account:alice", `{"kind":"account","value":"alice","reason":"cheating","by":"game1"}`)
+	if err != nil || ban.Kind != KindAccount || ban.Value != "alice" || ban.Reason != "cheating" || !ban.ExpireTime.IsZero() {
+		t.Errorf("account ban should be decoded, but got %v, %v", ban, err)
+	}
+
+	if _, err = decodeBan("user:alice", `{"kind":"user","value":"alice"}`); err == nil {
+		t.Errorf("ban of invalid kind should not be decoded")
+	}
+}
+
+func TestDecodePublishedBan(t *testing.T) {
+	ban, _ := NewBan(KindIP, "10.1.2.3/8", time.Hour, "flooding", "gate1")
+	data, _ := json.Marshal(ban)
+	decoded, err := Decode(string(data))
+	if err != nil || decoded.Kind != KindIP || decoded.Value != "10.0.0.0/8" || decoded.By != "gate1" || !decoded.ExpireTime.Equal(ban.ExpireTime) {
+		t.Errorf("published ban should be decoded, but got %v, %v", decoded, err)
+	}
+
+	if _, err := Decode(`{"kind":"ip","value":"not an ip"}`); err == nil {
+		t.Errorf("ban of invalid IP should not be decoded")
+	}
+}
@@ -0,0 +1,214 @@
+package binutil
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/pprof"
+	"path"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/rbac"
+)
+
+var (
+	adminMux       = http.NewServeMux()
+	startTime      = time.Now()
+	adminComponent string
+)
+
+// adminUserKey is the context key of the user of admin requests
+type adminUserKey struct{}
+
+func init() {
+	adminMux.HandleFunc("/debug/pprof/", pprof.Index)
+	adminMux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	adminMux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	adminMux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	adminMux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	adminMux.HandleFunc("/stats", handleAdminStatsRequest)
+	adminMux.HandleFunc("/loglevel", handleLogLevelRequest)
+	adminMux.HandleFunc("/reload_config", handleReloadConfigRequest)
+}
+
+// HandleAdminFunc registers the handler of component specific action to the admin HTTP server, which should be called before SetupAdminServer
+func HandleAdminFunc(pattern string, handler func(w http.ResponseWriter, r *http.Request)) {
+	adminMux.HandleFunc(pattern, handler)
+}
+
+// SetupAdminServer starts the admin HTTP server exposing pprof, runtime stats, log levels and component specific actions
+//
+// Requests should carry [admin].token or tokens of users in [rbac] in header "Authorization: Bearer <token>" (or as the
+// password of basic authentication for browsers), or client certificates verified by [admin].client_ca_file. Tokens are
+// never accepted in queries, which are leaked to logs and browser history. Requests of users in [rbac] (including client
+// certificates whose common names are users) are authorized by action admin:<path>. The admin server is not started if
+// listenAddr is empty.
+func SetupAdminServer(component string, listenAddr string) {
+	if listenAddr == "" {
+		return
+	}
+
+	adminConfig := config.GetAdmin()
+	if adminConfig.Token == "" && adminConfig.ClientCAFile == "" && !rbac.Enabled() {
+		gwlog.Fatalf("admin server of %s is enabled, but none of [admin].token, [admin].client_ca_file and [rbac] users is set", component)
+	}
+
+	adminComponent = component
+	if adminConfig.FaultInjection {
+		gwlog.Warnf("fault injection is enabled on admin server of %s, which should never be enabled in production", component)
+		adminMux.HandleFunc("/faults", handleFaultsRequest)
+	}
+
+	server := &http.Server{
+		Addr:    listenAddr,
+		Handler: &adminHandler{token: adminConfig.Token, clientCert: adminConfig.ClientCAFile != ""},
+	}
+	if adminConfig.ClientCAFile != "" {
+		tlsConfig, err := newAdminTLSConfig(path.Join(config.GetConfigDir(), adminConfig.ClientCAFile))
+		if err != nil {
+			gwlog.Fatalf("load admin client CA failed: %s", err)
+		}
+		server.TLSConfig = tlsConfig
+	}
+
+	gwlog.Infof("admin server of %s listening on %s", component, listenAddr)
+	go func() {
+		var err error
+		if adminConfig.CertFile != "" || adminConfig.KeyFile != "" {
+			certFile := path.Join(config.GetConfigDir(), adminConfig.CertFile)
+			keyFile := path.Join(config.GetConfigDir(), adminConfig.KeyFile)
+			err = server.ListenAndServeTLS(certFile, keyFile)
+		} else {
+			err = server.ListenAndServe()
+		}
+		gwlog.Errorf("admin server of %s stopped: %s", component, err)
+	}()
+}
+
+func newAdminTLSConfig(clientCAFile string) (*tls.Config, error) {
+	caData, err := ioutil.ReadFile(clientCAFile)
+	if err != nil {
+		return nil, err
+	}
+
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(caData) {
+		return nil, errors.Errorf("no certificates found in %s", clientCAFile)
+	}
+	return &tls.Config{
+		ClientCAs:  clientCAs,
+		ClientAuth: tls.RequireAndVerifyClientCert,
+	}, nil
+}
+
+// adminHandler authenticates and authorizes admin requests before serving them
+type adminHandler struct {
+	token      string
+	clientCert bool // client certificates are verified
+}
+
+func (h *adminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	user, ok := h.authenticate(r)
+	if !ok {
+		gwlog.Warnf("admin: unauthorized request %s %s from %s", r.Method, r.URL.Path, r.RemoteAddr)
+		// browsers prompt for the token, e.g. for /inspector
+		w.Header().Set("WWW-Authenticate", `Basic realm="goworld admin"`)
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	if rbac.Enabled() {
+		action := "admin:" + strings.TrimPrefix(path.Clean(r.URL.Path), "/")
+		if err := rbac.Authorize(user, action, r.RemoteAddr); err != nil {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+	}
+
+	gwlog.Infof("admin: %s %s from %s (%s)", r.Method, r.URL.Path, r.RemoteAddr, user)
+	adminMux.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), adminUserKey{}, user)))
+}
+
+// AdminUser returns the authenticated user of the admin request, e.g. to authorize GM commands run by the request
+func AdminUser(r *http.Request) string {
+	user, _ := r.Context().Value(adminUserKey{}).(string)
+	return user
+}
+
+// authenticate returns the user of the request: rbac.Superuser for [admin].token, users of tokens or client certificates
+// in [rbac], and rbac.Superuser for other client certificates if [rbac] users are not defined
+func (h *adminHandler) authenticate(r *http.Request) (string, bool) {
+	var token string
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		token = strings.TrimPrefix(auth, "Bearer ")
+	} else if _, password, ok := r.BasicAuth(); ok {
+		token = password
+	}
+	if token != "" && h.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(h.token)) == 1 {
+		return rbac.Superuser, true
+	}
+	if user, ok := rbac.Authenticate(token); ok {
+		return user, true
+	}
+
+	if h.clientCert && r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		name := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if rbac.IsUser(name) {
+			return name, true
+		} else if !rbac.Enabled() && h.token == "" {
+			return rbac.Superuser, true
+		}
+	}
+	return "", false
+}
+
+// AdminStats are runtime stats of component
+type AdminStats struct {
+	Component    string  `json:"component"`
+	Uptime       float64 `json:"uptime"` // in seconds
+	GoVersion    string  `json:"go_version"`
+	NumCPU       int     `json:"num_cpu"`
+	GoMaxProcs   int     `json:"gomaxprocs"`
+	NumGoroutine int     `json:"num_goroutine"`
+	HeapAlloc    uint64  `json:"heap_alloc"`
+	HeapInuse    uint64  `json:"heap_inuse"`
+	HeapObjects  uint64  `json:"heap_objects"`
+	Sys          uint64  `json:"sys"`
+	NumGC        uint32  `json:"num_gc"`
+	PauseTotalNs uint64  `json:"pause_total_ns"`
+	LastGC       int64   `json:"last_gc"` // unix time in nanoseconds
+}
+
+// handleAdminStatsRequest responds runtime stats in JSON
+//
+// Usage: /stats
+func handleAdminStatsRequest(w http.ResponseWriter, r *http.Request) {
+	var ms runtime.MemStats
+	runtime.ReadMemStats(&ms)
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(AdminStats{
+		Component:    adminComponent,
+		Uptime:       time.Since(startTime).Seconds(),
+		GoVersion:    runtime.Version(),
+		NumCPU:       runtime.NumCPU(),
+		GoMaxProcs:   runtime.GOMAXPROCS(0),
+		NumGoroutine: runtime.NumGoroutine(),
+		HeapAlloc:    ms.HeapAlloc,
+		HeapInuse:    ms.HeapInuse,
+		HeapObjects:  ms.HeapObjects,
+		Sys:          ms.Sys,
+		NumGC:        ms.NumGC,
+		PauseTotalNs: ms.PauseTotalNs,
+		LastGC:       int64(ms.LastGC),
+	})
+}
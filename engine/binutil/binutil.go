@@ -2,8 +2,11 @@ package binutil
 
 import (
 	"net/http"
+	"path"
+	"strings"
 	"syscall"
 
+	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"golang.org/x/net/websocket"
 )
@@ -11,23 +14,24 @@ import (
 const (
 	// FreezeSignal syscall used to freeze server
 	FreezeSignal = syscall.SIGHUP
+	// ReloadConfigSignal syscall used to hot reload config of gates and dispatchers
+	ReloadConfigSignal = syscall.SIGHUP
+	// GameReloadConfigSignal syscall used to hot reload config of games, since FreezeSignal freezes games
+	GameReloadConfigSignal = syscall.Signal(10) // SIGUSR1
 )
 
-// SetupHTTPServer starts the HTTP server for go tool pprof and websockets
+// SetupHTTPServer starts the HTTP server for websockets and metrics, pprof is only served by the admin server
 func SetupHTTPServer(listenAddr string, wsHandler func(ws *websocket.Conn)) {
 	setupHTTPServer(listenAddr, wsHandler, "", "")
 }
 
-// SetupHTTPServerTLS starts the HTTPs server for go tool pprof and websockets
+// SetupHTTPServerTLS starts the HTTPs server for websockets and metrics, pprof is only served by the admin server
 func SetupHTTPServerTLS(listenAddr string, wsHandler func(ws *websocket.Conn), certFile string, keyFile string) {
 	setupHTTPServer(listenAddr, wsHandler, certFile, keyFile)
 }
 
 func setupHTTPServer(listenAddr string, wsHandler func(ws *websocket.Conn), certFile string, keyFile string) {
 	gwlog.Infof("http server listening on %s", listenAddr)
-	gwlog.Infof("pprof http://%s/debug/pprof/ ... available commands: ", listenAddr)
-	gwlog.Infof("    go tool pprof http://%s/debug/pprof/heap", listenAddr)
-	gwlog.Infof("    go tool pprof http://%s/debug/pprof/profile", listenAddr)
 	if keyFile != "" || certFile != "" {
 		gwlog.Infof("TLS is enabled on http: key=%s, cert=%s", keyFile, certFile)
 	}
@@ -39,19 +43,39 @@ func setupHTTPServer(listenAddr string, wsHandler func(ws *websocket.Conn), cert
 
 	go func() {
 		if keyFile == "" && certFile == "" {
-			http.ListenAndServe(listenAddr, nil)
+			http.ListenAndServe(listenAddr, publicHandler{})
 		} else {
-			http.ListenAndServeTLS(listenAddr, certFile, keyFile, nil)
+			http.ListenAndServeTLS(listenAddr, certFile, keyFile, publicHandler{})
 		}
 	}()
 }
 
+// publicHandler serves the default mux without pprof, which is registered to the default mux by importing net/http/pprof,
+// so that pprof is only served by the authenticated admin server
+type publicHandler struct{}
+
+func (publicHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if strings.HasPrefix(path.Clean(r.URL.Path), "/debug/pprof") {
+		http.NotFound(w, r)
+		return
+	}
+	http.DefaultServeMux.ServeHTTP(w, r)
+}
+
 // SetupGWLog setup the GoWord log system
-func SetupGWLog(component string, logLevel string, logFile string, logStderr bool) {
+func SetupGWLog(component string, logLevel string, logFile string, logStderr bool, logFormat string) {
 	gwlog.SetSource(component)
+	if logFormat != "" {
+		gwlog.SetFormat(logFormat)
+	}
 	gwlog.Infof("Set log level to %s", logLevel)
 	gwlog.SetLevel(gwlog.ParseLevel(logLevel))
 
+	gwlog.SetRotation(getRotateConfig())
+	config.OnReload("log", func() {
+		gwlog.SetRotation(getRotateConfig())
+	})
+
 	var outputs []string
 	if logStderr {
 		outputs = append(outputs, "stderr")
@@ -81,6 +105,17 @@ func SetupGWLog(component string, logLevel string, logFile string, logStderr boo
 	//}
 }
 
+func getRotateConfig() gwlog.RotateConfig {
+	logConfig := config.GetLog()
+	return gwlog.RotateConfig{
+		MaxSize:    logConfig.RotateSize,
+		Interval:   logConfig.RotateInterval,
+		MaxBackups: logConfig.MaxBackups,
+		MaxAge:     logConfig.MaxAge,
+		Compress:   logConfig.Compress,
+	}
+}
+
 func PrintSupervisorTag(tag string) {
 	curlvl := gwlog.GetLevel()
 	if curlvl != gwlog.DebugLevel && curlvl != gwlog.InfoLevel {
@@ -0,0 +1,107 @@
+package binutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+type faultsResponse struct {
+	Latency        string  `json:"latency"`
+	Jitter         string  `json:"jitter"`
+	DropRate       float64 `json:"drop"`
+	ReorderRate    float64 `json:"reorder"`
+	DisconnectRate float64 `json:"disconnect"`
+	Disconnected   int     `json:"disconnected,omitempty"` // number of connections closed by disconnect_now
+}
+
+// handleFaultsRequest shows or changes faults injected to packets received from other components (dispatchers, games and
+// gates), which is used to verify that migrations, failovers and reconnections survive bad networks
+//
+// /faults is only served if [admin].fault_injection is enabled, and faults should be changed using POST.
+//
+// Usage:
+//
+//	GET /faults                                                             show injected faults in JSON
+//	POST /faults latency=50ms&jitter=20ms&drop=0.01&reorder=0.01&disconnect=0.001  inject faults, faults not given are cleared
+//	POST /faults clear=1                                                    clear all faults
+//	POST /faults disconnect_now=1                                           close connections to other components, which reconnect
+func handleFaultsRequest(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost && (r.FormValue("clear") != "" || r.FormValue("latency") != "" || r.FormValue("jitter") != "" ||
+		r.FormValue("drop") != "" || r.FormValue("reorder") != "" || r.FormValue("disconnect") != "" || r.FormValue("disconnect_now") != "") {
+		http.Error(w, "method should be called using POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp faultsResponse
+	if r.FormValue("clear") != "" {
+		proto.SetFaults(proto.Faults{})
+	} else if r.FormValue("latency") != "" || r.FormValue("jitter") != "" || r.FormValue("drop") != "" ||
+		r.FormValue("reorder") != "" || r.FormValue("disconnect") != "" {
+		f, err := parseFaults(r)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		proto.SetFaults(f)
+	}
+	if r.FormValue("disconnect_now") != "" {
+		resp.Disconnected = proto.DisconnectFaultyConnections()
+		gwlog.Warnf("Injected fault: %d connections to other components are closed", resp.Disconnected)
+	}
+
+	f := proto.GetFaults()
+	resp.Latency = f.Latency.String()
+	resp.Jitter = f.Jitter.String()
+	resp.DropRate = f.DropRate
+	resp.ReorderRate = f.ReorderRate
+	resp.DisconnectRate = f.DisconnectRate
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
+
+func parseFaults(r *http.Request) (f proto.Faults, err error) {
+	if f.Latency, err = parseFaultDuration(r, "latency"); err != nil {
+		return
+	}
+	if f.Jitter, err = parseFaultDuration(r, "jitter"); err != nil {
+		return
+	}
+	if f.DropRate, err = parseFaultRate(r, "drop"); err != nil {
+		return
+	}
+	if f.ReorderRate, err = parseFaultRate(r, "reorder"); err != nil {
+		return
+	}
+	f.DisconnectRate, err = parseFaultRate(r, "disconnect")
+	return
+}
+
+func parseFaultDuration(r *http.Request, key string) (time.Duration, error) {
+	s := r.FormValue(key)
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid %s: %#v", key, s)
+	}
+	return d, nil
+}
+
+func parseFaultRate(r *http.Request, key string) (float64, error) {
+	s := r.FormValue(key)
+	if s == "" {
+		return 0, nil
+	}
+	rate, err := strconv.ParseFloat(s, 64)
+	if err != nil || rate < 0 || rate > 1 {
+		return 0, fmt.Errorf("invalid %s: %#v, should be between 0 and 1", key, s)
+	}
+	return rate, nil
+}
@@ -0,0 +1,72 @@
+package binutil
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+type logLevelsResponse struct {
+	Level   string              `json:"level"`
+	Modules []gwlog.ModuleLevel `json:"modules"`
+}
+
+// handleLogLevelRequest shows or changes the global log level and log levels of modules (entity, storage, dispatcherclient, aoi, ...)
+//
+// Usage:
+//
+//	GET  /loglevel                            show log levels in JSON
+//	POST /loglevel?level=info                 set the global log level
+//	POST /loglevel?module=aoi&level=debug     set the log level of module
+//	POST /loglevel?module=aoi&level=reset     make the module follow the global log level
+//
+// Log levels are only changed by POST requests, so that crawlers and link previews can not change them.
+func handleLogLevelRequest(w http.ResponseWriter, r *http.Request) {
+	module := r.FormValue("module")
+	level := r.FormValue("level")
+	if (module != "" || level != "") && r.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "log levels can only be changed by POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if level != "" {
+		if err := setLogLevel(module, level); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		gwlog.Infof("Set log level of module %#v to %s", module, level)
+	} else if module != "" {
+		http.Error(w, "level is not specified", http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(logLevelsResponse{
+		Level:   gwlog.GetLevel().String(),
+		Modules: gwlog.GetModuleLevels(),
+	})
+}
+
+func setLogLevel(module string, level string) error {
+	if module != "" && level == "reset" {
+		if !gwlog.ResetModuleLevel(module) {
+			return fmt.Errorf("unknown module: %#v", module)
+		}
+		return nil
+	}
+
+	var lv gwlog.Level
+	if err := lv.UnmarshalText([]byte(level)); err != nil {
+		return fmt.Errorf("invalid level: %#v", level)
+	}
+
+	if module == "" {
+		gwlog.SetLevel(lv)
+	} else if !gwlog.SetModuleLevel(module, lv) {
+		return fmt.Errorf("unknown module: %#v", module)
+	}
+	return nil
+}
@@ -0,0 +1,38 @@
+package binutil
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+func TestLogLevelRequest(t *testing.T) {
+	defer gwlog.SetLevel(gwlog.GetLevel())
+	gwlog.SetLevel(gwlog.InfoLevel)
+
+	w := httptest.NewRecorder()
+	handleLogLevelRequest(w, httptest.NewRequest(http.MethodGet, "/loglevel", nil))
+	if w.Code != http.StatusOK {
+		t.Fatalf("showing log levels by GET should succeed, but got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleLogLevelRequest(w, httptest.NewRequest(http.MethodGet, "/loglevel?level=debug", nil))
+	if w.Code != http.StatusMethodNotAllowed || gwlog.GetLevel() != gwlog.InfoLevel {
+		t.Fatalf("changing log level by GET should be rejected, but got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleLogLevelRequest(w, httptest.NewRequest(http.MethodPost, "/loglevel?level=debug", nil))
+	if w.Code != http.StatusOK || gwlog.GetLevel() != gwlog.DebugLevel {
+		t.Fatalf("changing log level by POST should succeed, but got %d", w.Code)
+	}
+
+	w = httptest.NewRecorder()
+	handleLogLevelRequest(w, httptest.NewRequest(http.MethodPost, "/loglevel?module=unknown_module&level=debug", nil))
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("changing log level of unknown module should fail, but got %d", w.Code)
+	}
+}
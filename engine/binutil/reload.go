@@ -0,0 +1,58 @@
+package binutil
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/signal"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// HandleReloadConfigSignal hot reloads the config when receiving the signal, see config.HotReload
+func HandleReloadConfigSignal(sig os.Signal) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, sig)
+	go func() {
+		for range sigChan {
+			gwlog.Infof("Hot reloading config on signal %s ...", sig)
+			if _, err := config.HotReload(); err != nil {
+				gwlog.Errorf("hot reload config failed: %s", err)
+			}
+		}
+	}()
+}
+
+// OnReloadLogLevel sets the log level of component when log_level in the config section of component is changed by hot
+// reload, unless the log level is set by command-line flag
+func OnReloadLogLevel(section string, flagLogLevel string, getLogLevel func() string) {
+	if flagLogLevel != "" {
+		return
+	}
+
+	logLevel := getLogLevel()
+	config.OnReload(section, func() {
+		if newLogLevel := getLogLevel(); newLogLevel != logLevel {
+			logLevel = newLogLevel
+			gwlog.Infof("Set log level to %s", logLevel)
+			gwlog.SetLevel(gwlog.ParseLevel(logLevel))
+		}
+	})
+}
+
+// handleReloadConfigRequest hot reloads the config and responds changed config sections in JSON
+//
+// Usage: /reload_config
+func handleReloadConfigRequest(w http.ResponseWriter, r *http.Request) {
+	changed, err := config.HotReload()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"changed": changed,
+	})
+}
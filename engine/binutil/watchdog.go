@@ -0,0 +1,34 @@
+package binutil
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/watchdog"
+)
+
+// SetupWatchdog starts the watchdog of component using [watchdog] config
+//
+// The main loop of component should call watchdog.Heartbeat periodically, and the component can set the drain handler
+// using watchdog.SetDrainHandler.
+func SetupWatchdog(component string) {
+	watchdogConfig := config.GetWatchdog()
+	watchdog.Start(component, watchdog.Config{
+		CheckInterval: watchdogConfig.CheckInterval,
+		Goroutines: watchdog.Thresholds{
+			Warn:     int64(watchdogConfig.GoroutinesWarn),
+			Critical: int64(watchdogConfig.GoroutinesCritical),
+		},
+		Heap: watchdog.Thresholds{
+			Warn:     int64(watchdogConfig.HeapWarn),
+			Critical: int64(watchdogConfig.HeapCritical),
+		},
+		Stall: watchdog.Thresholds{
+			Warn:     int64(watchdogConfig.StallWarn / time.Millisecond),
+			Critical: int64(watchdogConfig.StallCritical / time.Millisecond),
+		},
+		CriticalActions: watchdogConfig.CriticalActions,
+		ProfileDir:      watchdogConfig.ProfileDir,
+		ActionCooldown:  watchdogConfig.ActionCooldown,
+	})
+}
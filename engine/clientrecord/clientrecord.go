@@ -0,0 +1,199 @@
+// Package clientrecord reads and writes records of packets of client connections
+//
+// Gates record packets received from selected clients with timestamps (see /record of the gate admin server), and the
+// replayer feeds records back to the gate of a test cluster (see goworld replay), so that desyncs and exploits reported
+// in production can be reproduced. Entities created on the client are recorded with inbound packets, so that entity
+// IDs in the records can be mapped to entities created in the test cluster.
+package clientrecord
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+const (
+	magic = "GWRECORD1"
+	// FileExt is the extension of record files
+	FileExt = ".gwrec"
+
+	maxPayloadLen = 64 * 1024 * 1024
+)
+
+// Header is the header of the record of a client connection
+type Header struct {
+	GateID     uint16
+	ClientID   common.ClientID
+	RemoteAddr string
+	AuthID     string
+	StartTime  time.Time
+}
+
+// Record is the packet received from the client, or sent to the client if Outbound is set
+type Record struct {
+	Time     time.Duration // time since the start of recording
+	Outbound bool
+	MsgType  proto.MsgType
+	Payload  []byte // payload following the message type
+}
+
+// Writer writes records of the client connection, it is safe for concurrent use
+type Writer struct {
+	lock      sync.Mutex
+	w         *bufio.Writer
+	startTime time.Time
+	err       error // the first error of writing, following writes are ignored
+}
+
+// NewWriter writes the header and creates the writer of records
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	rw := &Writer{
+		w:         bufio.NewWriter(w),
+		startTime: header.StartTime,
+	}
+	rw.w.WriteString(magic)
+	rw.writeUvarint(uint64(header.GateID))
+	rw.writeBytes([]byte(header.ClientID))
+	rw.writeBytes([]byte(header.RemoteAddr))
+	rw.writeBytes([]byte(header.AuthID))
+	rw.writeVarint(header.StartTime.UnixNano())
+	if err := rw.w.Flush(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// Write writes the packet received from the client (or sent to the client if outbound is set) at the time
+func (w *Writer) Write(now time.Time, outbound bool, msgtype proto.MsgType, payload []byte) error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.err != nil {
+		return w.err
+	}
+
+	w.writeVarint(int64(now.Sub(w.startTime)))
+	if outbound {
+		w.w.WriteByte(1)
+	} else {
+		w.w.WriteByte(0)
+	}
+	w.writeUvarint(uint64(msgtype))
+	if err := w.writeBytes(payload); err != nil {
+		w.err = err
+	}
+	return w.err
+}
+
+// Flush writes buffered records to the underlying writer
+func (w *Writer) Flush() error {
+	w.lock.Lock()
+	defer w.lock.Unlock()
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+func (w *Writer) writeVarint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.w.Write(buf[:binary.PutVarint(buf[:], v)])
+}
+
+func (w *Writer) writeUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.w.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (w *Writer) writeBytes(b []byte) error {
+	w.writeUvarint(uint64(len(b)))
+	_, err := w.w.Write(b)
+	return err
+}
+
+// Reader reads records of the client connection
+type Reader struct {
+	r      *bufio.Reader
+	header Header
+}
+
+// NewReader reads the header and creates the reader of records
+func NewReader(r io.Reader) (*Reader, error) {
+	rr := &Reader{r: bufio.NewReader(r)}
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(rr.r, head); err != nil || string(head) != magic {
+		return nil, errors.Errorf("not a client record")
+	}
+
+	gateid, err := binary.ReadUvarint(rr.r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	rr.header.GateID = uint16(gateid)
+	var clientid, remoteAddr, authID []byte
+	for _, field := range []*[]byte{&clientid, &remoteAddr, &authID} {
+		if *field, err = rr.readBytes(); err != nil {
+			return nil, errors.Wrap(err, "read header")
+		}
+	}
+	startTime, err := binary.ReadVarint(rr.r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	rr.header.ClientID = common.ClientID(clientid)
+	rr.header.RemoteAddr = string(remoteAddr)
+	rr.header.AuthID = string(authID)
+	rr.header.StartTime = time.Unix(0, startTime)
+	return rr, nil
+}
+
+// Header returns the header of the record
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Read reads the next record, io.EOF is returned if there are no more records
+func (r *Reader) Read() (*Record, error) {
+	t, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return nil, err // io.EOF if the record ends
+	}
+	outbound, err := r.r.ReadByte()
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	msgtype, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	payload, err := r.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	return &Record{
+		Time:     time.Duration(t),
+		Outbound: outbound != 0,
+		MsgType:  proto.MsgType(msgtype),
+		Payload:  payload,
+	}, nil
+}
+
+func (r *Reader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if n > maxPayloadLen {
+		return nil, errors.Errorf("payload too large: %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
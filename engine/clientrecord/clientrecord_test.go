@@ -0,0 +1,65 @@
+package clientrecord
+
+import (
+	"bytes"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	header := Header{
+		GateID:     2,
+		ClientID:   "abcdefghijklmnop",
+		RemoteAddr: "10.0.0.1:12345",
+		AuthID:     "user1",
+		StartTime:  time.Unix(1600000000, 123),
+	}
+	w, err := NewWriter(&buf, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	records := []Record{
+		{Time: 0, MsgType: proto.MT_PROTOCOL_VERSION_FROM_CLIENT, Payload: []byte{2, 0}},
+		{Time: time.Millisecond * 15, Outbound: true, MsgType: proto.MT_CREATE_ENTITY_ON_CLIENT, Payload: []byte("player")},
+		{Time: time.Second, MsgType: proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT, Payload: []byte{}},
+	}
+	for _, rec := range records {
+		if err := w.Write(header.StartTime.Add(rec.Time), rec.Outbound, rec.MsgType, rec.Payload); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := r.Header(); h.GateID != header.GateID || h.ClientID != header.ClientID || h.RemoteAddr != header.RemoteAddr ||
+		h.AuthID != header.AuthID || !h.StartTime.Equal(header.StartTime) {
+		t.Errorf("header should be %+v, but is %+v", header, h)
+	}
+	for i, rec := range records {
+		read, err := r.Read()
+		if err != nil {
+			t.Fatalf("read record %d failed: %s", i, err)
+		}
+		if read.Time != rec.Time || read.Outbound != rec.Outbound || read.MsgType != rec.MsgType || !bytes.Equal(read.Payload, rec.Payload) {
+			t.Errorf("record %d should be %+v, but is %+v", i, rec, read)
+		}
+	}
+	if _, err := r.Read(); err != io.EOF {
+		t.Errorf("reader should return EOF after all records, but returns %v", err)
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("not a record"))); err == nil {
+		t.Errorf("reader should reject invalid records")
+	}
+}
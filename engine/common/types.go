@@ -1,6 +1,9 @@
 package common
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/uuid"
 )
@@ -16,9 +19,29 @@ func (id EntityID) IsNil() bool {
 	return id == ""
 }
 
+// entityIDGenerator generates entity IDs if set, see SetEntityIDGenerator
+var entityIDGenerator func() EntityID
+
+// SetEntityIDGenerator installs the generator of entity IDs, or restores the default generator if gen is nil
+//
+// Generated IDs must be unique among all games and gates, and have the length of ENTITYID_LENGTH. The last 2 characters
+// of IDs select dispatchers of entities, so they should be evenly distributed. SetEntityIDGenerator should be called
+// before any entity is created, e.g. with uuid.Snowflake for time-ordered IDs.
+func SetEntityIDGenerator(gen func() EntityID) {
+	entityIDGenerator = gen
+}
+
 // GenEntityID generates a new EntityID
 func GenEntityID() EntityID {
-	return EntityID(uuid.GenUUID())
+	if entityIDGenerator == nil {
+		return EntityID(uuid.GenUUID())
+	}
+
+	id := entityIDGenerator()
+	if len(id) != ENTITYID_LENGTH {
+		gwlog.Panicf("generated entity ID %s of len %d is not a valid entity ID (len=%d)", id, len(id), ENTITYID_LENGTH)
+	}
+	return id
 }
 
 // MustEntityID assures a string to be EntityID
@@ -42,5 +65,14 @@ func (id ClientID) IsNil() bool {
 	return id == ""
 }
 
+// GenSessionToken generates a new random token for resuming client sessions
+func GenSessionToken() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		gwlog.Panic(err)
+	}
+	return hex.EncodeToString(b)
+}
+
 // CLIENTID_LENGTH is the length of Client IDs
 const CLIENTID_LENGTH = uuid.UUID_LENGTH
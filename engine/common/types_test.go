@@ -18,6 +18,27 @@ func TestEntityID(t *testing.T) {
 
 }
 
+func TestEntityIDGenerator(t *testing.T) {
+	defer SetEntityIDGenerator(nil)
+
+	SetEntityIDGenerator(func() EntityID {
+		return "0123456789abcdef"
+	})
+	if eid := GenEntityID(); eid != "0123456789abcdef" {
+		t.Errorf("entity ID should be generated by the generator, but got %s", eid)
+	}
+
+	SetEntityIDGenerator(func() EntityID {
+		return "short"
+	})
+	defer func() {
+		if recover() == nil {
+			t.Errorf("invalid entity ID should panic")
+		}
+	}()
+	GenEntityID()
+}
+
 func TestClientID(t *testing.T) {
 	if !ClientID("").IsNil() {
 		t.Fail()
@@ -31,3 +52,13 @@ func TestClientID(t *testing.T) {
 	}
 
 }
+
+func TestGenSessionToken(t *testing.T) {
+	token1, token2 := GenSessionToken(), GenSessionToken()
+	if len(token1) != 32 || len(token2) != 32 {
+		t.Fail()
+	}
+	if token1 == token2 {
+		t.Fail()
+	}
+}
@@ -0,0 +1,66 @@
+package config
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// FeatureConfig defines the feature flag in [features] section
+type FeatureConfig struct {
+	Enabled bool
+	Games   []uint16 // the feature is only enabled on these games if not empty
+}
+
+// readFeaturesConfig reads feature flags, whose values are true, false or games enabling the feature, e.g. game1,game3
+func readFeaturesConfig(sec *ini.Section, features map[string]FeatureConfig) {
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		value := strings.ToLower(strings.TrimSpace(key.String()))
+		if !strings.HasPrefix(value, "game") {
+			features[name] = FeatureConfig{Enabled: mustBool(sec, key, false)}
+			continue
+		}
+
+		feature := FeatureConfig{Enabled: true}
+		for _, game := range strings.Split(value, ",") {
+			game = strings.TrimSpace(game)
+			gameid, err := strconv.Atoi(strings.TrimPrefix(game, "game"))
+			if !strings.HasPrefix(game, "game") || err != nil || gameid <= 0 || gameid > 65535 {
+				invalidKeyType(sec, key, "a bool (true/false/1/0) or games (e.g. game1,game3)")
+			}
+			feature.Games = append(feature.Games, uint16(gameid))
+		}
+		features[name] = feature
+	}
+}
+
+// FeatureEnabled returns if the feature flag is enabled on the game, features not in config are disabled
+//
+// Feature flags can be changed by hot reloading the config, e.g. when the config is watched in etcd or consul.
+func FeatureEnabled(name string, gameid uint16) bool {
+	feature, ok := Get().Features[strings.ToLower(name)]
+	if !ok || !feature.Enabled {
+		return false
+	}
+	if len(feature.Games) == 0 {
+		return true
+	}
+	for _, id := range feature.Games {
+		if id == gameid {
+			return true
+		}
+	}
+	return false
+}
+
+// GetFeatureNames returns names of all feature flags in config
+func GetFeatureNames() []string {
+	features := Get().Features
+	names := make([]string, 0, len(features))
+	for name := range features {
+		names = append(names, name)
+	}
+	return names
+}
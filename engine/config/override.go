@@ -0,0 +1,116 @@
+package config
+
+import (
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// EnvPrefix is the prefix of environment variables overriding config values, e.g. GOWORLD_GAME1_HTTP_ADDR overrides
+	// http_addr in [game1]
+	EnvPrefix = "GOWORLD_"
+)
+
+var (
+	overrides []configOverride // overrides of command-line flags, in order
+
+	knownSectionNames = []string{
+		"deployment", "storage", "kvdb", "debug", "bridge", "webhook", "log", "admin", "watchdog", "features", "rbac",
+		"game_common", "gate_common", "dispatcher_common",
+	}
+	componentSectionPattern = regexp.MustCompile(`^(game|gate|dispatcher)\d+_`)
+)
+
+type configOverride struct {
+	section string
+	key     string
+	value   string
+	source  string
+}
+
+// AddOverride overrides the config value of key in section, which takes precedence over config files and environment
+// variables. It should be called before the config is read.
+func AddOverride(section string, key string, value string) {
+	overrides = append(overrides, configOverride{
+		section: strings.ToLower(section),
+		key:     strings.ToLower(key),
+		value:   value,
+		source:  "command-line flag",
+	})
+}
+
+// OverrideFlag is the flag.Value of command-line flags overriding config values in the form of section.key=value,
+// e.g. -config game1.http_addr=0.0.0.0:25001
+type OverrideFlag struct{}
+
+// String implements flag.Value
+func (OverrideFlag) String() string {
+	return ""
+}
+
+// Set implements flag.Value
+func (OverrideFlag) Set(s string) error {
+	kv := strings.SplitN(s, "=", 2)
+	if len(kv) != 2 {
+		return errors.Errorf("config override should be section.key=value, but is %s", s)
+	}
+	dot := strings.LastIndex(kv[0], ".")
+	if dot <= 0 || dot == len(kv[0])-1 {
+		return errors.Errorf("config override should be section.key=value, but is %s", s)
+	}
+	AddOverride(kv[0][:dot], kv[0][dot+1:], kv[1])
+	return nil
+}
+
+// applyOverrides overrides config values of environment variables (GOWORLD_<SECTION>_<KEY>) and command-line flags
+func applyOverrides(iniFile *ini.File) {
+	var all []configOverride
+	environ := os.Environ()
+	sort.Strings(environ)
+	for _, env := range environ {
+		kv := strings.SplitN(env, "=", 2)
+		if len(kv) != 2 || !strings.HasPrefix(kv[0], EnvPrefix) {
+			continue
+		}
+
+		section, key := splitEnvName(strings.ToLower(kv[0][len(EnvPrefix):]))
+		if section == "" {
+			gwlog.Warnf("environment variable %s is ignored because config section is unknown", kv[0])
+			continue
+		}
+		all = append(all, configOverride{section, key, kv[1], "environment variable " + kv[0]})
+	}
+	all = append(all, overrides...)
+
+	for _, o := range all {
+		_, err := iniFile.Section(o.section).NewKey(o.key, o.value)
+		checkConfigError(err, "")
+		gwlog.Infof("config [%s].%s is overridden by %s", o.section, o.key, o.source)
+	}
+}
+
+// splitEnvName splits the lower-cased environment variable name (without prefix) to section and key, section is empty
+// if unknown
+func splitEnvName(name string) (section string, key string) {
+	if m := componentSectionPattern.FindString(name); m != "" {
+		section, key = m[:len(m)-1], name[len(m):]
+	} else {
+		for _, secName := range knownSectionNames {
+			if strings.HasPrefix(name, secName+"_") {
+				section, key = secName, name[len(secName)+1:]
+				break
+			}
+		}
+	}
+
+	if key == "" {
+		return "", ""
+	}
+	return
+}
@@ -0,0 +1,75 @@
+package config
+
+import (
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+// RBACConfig defines roles and users in [rbac] section, which authorize admin API requests, GM commands and calls
+// bridged by HTTP bridge or gRPC of games
+type RBACConfig struct {
+	Roles map[string][]string // permissions of roles, which are actions or action prefixes ending with *
+	Users map[string]*RBACUser
+}
+
+// RBACUser defines roles and token of the user in [rbac] section
+type RBACUser struct {
+	Roles []string
+	Token string // token for admin servers, HTTP bridge and gRPC of games, empty if the user only runs GM commands
+}
+
+// readRBACConfig reads role_<role>=<permissions>, user_<user>=<roles> and token_<user>=<token> keys
+func readRBACConfig(sec *ini.Section, config *RBACConfig) {
+	config.Roles = map[string][]string{}
+	config.Users = map[string]*RBACUser{}
+	getUser := func(name string) *RBACUser {
+		user := config.Users[name]
+		if user == nil {
+			user = &RBACUser{}
+			config.Users[name] = user
+		}
+		return user
+	}
+
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if strings.HasPrefix(name, "role_") && len(name) > 5 {
+			config.Roles[name[5:]] = key.Strings(",")
+		} else if strings.HasPrefix(name, "user_") && len(name) > 5 {
+			roles := key.Strings(",")
+			for i, role := range roles {
+				roles[i] = strings.ToLower(role)
+			}
+			getUser(name[5:]).Roles = roles
+		} else if strings.HasPrefix(name, "token_") && len(name) > 6 {
+			getUser(name[6:]).Token = key.MustString("")
+		} else {
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+
+	tokens := map[string]string{}
+	for name, user := range config.Users {
+		if len(user.Roles) == 0 {
+			configFatalf("[%s].token_%s is set, but user_%s is not set", sec.Name(), name, name)
+		}
+		for _, role := range user.Roles {
+			if _, ok := config.Roles[role]; !ok {
+				configFatalf("[%s].user_%s has undefined role: %s", sec.Name(), name, role)
+			}
+		}
+		if user.Token == "" {
+			continue
+		}
+		if other, ok := tokens[user.Token]; ok {
+			configFatalf("[%s].token_%s is the same as token_%s", sec.Name(), name, other)
+		}
+		tokens[user.Token] = name
+	}
+}
+
+// GetRBAC returns the RBAC config
+func GetRBAC() *RBACConfig {
+	return &Get().RBAC
+}
@@ -17,70 +17,200 @@ import (
 
 	"path"
 
+	"regexp"
+
 	"github.com/go-ini/ini"
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 )
 
 const (
-	_DEFAULT_CONFIG_FILE   = "goworld.ini"
-	_DEFAULT_SAVE_ITNERVAL = time.Minute * 5
-	_DEFAULT_LOG_LEVEL     = "debug"
-	_DEFAULT_STORAGE_DB    = "goworld"
+	_DEFAULT_CONFIG_FILE    = "goworld.ini"
+	_DEFAULT_SAVE_ITNERVAL  = time.Minute * 5
+	_DEFAULT_LOG_LEVEL      = "debug"
+	_DEFAULT_LOG_FORMAT     = "console"
+	_DEFAULT_STORAGE_DB     = "goworld"
+	_DEFAULT_HANDLER_BUDGET = time.Millisecond * 5
+	_DEFAULT_FRAME_BUDGET   = time.Millisecond * 50
+
+	_DEFAULT_SNAPSHOT_INTERVAL = time.Minute
 )
 
 var (
 	configFilePath = _DEFAULT_CONFIG_FILE
 	goWorldConfig  *GoWorldConfig
 	configLock     sync.Mutex
+
+	worldNamePattern = regexp.MustCompile(`^[A-Za-z0-9_-]+$`)
 )
 
 // DeploymentConfig defines fields of deployment config
 type DeploymentConfig struct {
-	DesiredDispatchers int `ini:"desired_dispatchers"`
-	DesiredGames       int `ini:"desired_games"`
-	DesiredGates       int `ini:"desired_gates"`
+	DesiredDispatchers int      `ini:"desired_dispatchers"`
+	DesiredGames       int      `ini:"desired_games"`
+	DesiredGates       int      `ini:"desired_gates"`
+	Worlds             []string `ini:"worlds"`       // names of worlds hosted by the cluster, empty for the single default world
+	WorkerGames        []uint16 `ini:"worker_games"` // games dedicated to worker services, empty to spread workers across all games
 }
 
 // GameConfig defines fields of game config
 type GameConfig struct {
-	BootEntity             string
-	SaveInterval           time.Duration
-	LogFile                string
-	LogStderr              bool
-	HTTPAddr               string
-	LogLevel               string
-	GoMaxProcs             int
-	PositionSyncIntervalMS int
-	BanBootEntity          bool
+	BootEntity               string
+	SaveInterval             time.Duration
+	LogFile                  string
+	LogStderr                bool
+	HTTPAddr                 string
+	AdminAddr                string // address of admin HTTP server, empty to disable
+	LogLevel                 string
+	LogFormat                string // console or json
+	GoMaxProcs               int
+	PositionSyncIntervalMS   int
+	BanBootEntity            bool
+	SessionResumeTimeout     time.Duration
+	MaxAccountSessions       int            // max sessions of each account claimed by entities, 0 for unlimited
+	AccountSessionConflict   string         // policy when the account has too many sessions: kick_older or reject_new
+	GRPCAddr                 string         // address to serve gRPC for external services, empty to disable
+	GRPCToken                string         // token for authenticating gRPC requests
+	GRPCCertFile             string         // certificate file for serving gRPC using TLS
+	GRPCKeyFile              string         // key file for serving gRPC using TLS
+	GRPCAllowInsecure        bool           // allow serving gRPC without TLS on non-loopback addresses
+	ExportMetrics            bool           // export Prometheus metrics at /metrics of the game HTTP server
+	HandlerBudget            time.Duration  // entity methods, timers and posted functions taking longer are logged, 0 to disable
+	HandlerDeadline          time.Duration  // handlers running longer are logged with the stack while running, 0 to disable
+	FrameBudget              time.Duration  // frames (intervals between ticks) taking longer are overruns, 0 to disable
+	PostBudget               time.Duration  // max duration of running posted functions in each frame, 0 for no limit
+	ClientSyncBudget         int            // max number of other entities whose positions are synced to each client in each sync, 0 for no limit
+	OverloadClientSyncBudget int            // ClientSyncBudget when frames overrun FrameBudget, 0 for ClientSyncBudget
+	CrashDumpDir             string         // directory of crash dump files written on panics, empty to disable
+	CrashDumpStorage         bool           // save crash dumps to entity storage
+	JitterEntityTimers       bool           // first intervals of entity repeat timers are randomized
+	TimingWheel              bool           // entity timers are added to the hierarchical timing wheel
+	Deterministic            bool           // the game runs in the deterministic simulation mode
+	DeterministicSeed        int64          // seed of the RNG in the deterministic mode
+	AsyncWorkers             map[string]int // number of workers of async job groups
 }
 
 // GateConfig defines fields of gate config
 type GateConfig struct {
+	ListenAddr               string
+	AdvertiseAddr            string // address for clients to connect when redirected to the gate, listen_addr is used if empty and not a wildcard address
+	LogFile                  string
+	LogStderr                bool
+	HTTPAddr                 string
+	AdminAddr                string // address of admin HTTP server, empty to disable
+	LogLevel                 string
+	LogFormat                string // console or json
+	GoMaxProcs               int
+	CompressConnection       bool
+	CompressFormat           string
+	CompressFormats          []string // compress formats that can be negotiated with clients, in preference order
+	CompressThreshold        int      // minimal packet payload length to compress for negotiated compression
+	EncryptConnection        bool
+	RSAKey                   string
+	RSACertificate           string
+	CipherFormats            []string // cipher formats for packet encryption that can be negotiated with clients, in preference order
+	RequirePacketIntegrity   bool     // clients should negotiate a cipher format before the handshake is completed
+	SignKeyExchange          bool     // sign gate's public key of key exchanges using rsa_key, for clients to verify by rsa_certificate
+	RequireKeyExchange       bool     // clients sending other packets before the key exchange or negotiating no cipher format are closed
+	PacketIntegrityViolation string   // policy of client packets failing integrity checks: disconnect, drop or report
+	HeartbeatCheckInterval   int
+	PositionSyncIntervalMS   int
+	ClientFlushIntervalMS    int  // interval to flush batched packets to each client
+	UrgentClientRPC          bool // flush RPC calls to clients immediately, bypassing packet batching
+	DrainTimeout             time.Duration
+	MaxClientPacketsPerSec   int // max packets per second received from each client, 0 for unlimited
+	MaxClientBytesPerSec     int // max bytes per second received from each client, 0 for unlimited
+	MaxClientPacketSize      int // max payload length of packets received from clients, 0 for unlimited
+	FloodBanDuration         time.Duration
+	FloodBanScope            string        // what flooding clients are banned by: account, ip or none
+	PingInterval             time.Duration // interval to ping clients for measuring latency, 0 to disable
+	ExportMetrics            bool          // export Prometheus metrics at /metrics of the gate HTTP server, which is public
+	LatencyChangeThreshold   time.Duration // min latency change to notify the owner entity
+	AllowIPs                 []string      // CIDRs of client addresses allowed to connect, all addresses are allowed if empty
+	DenyIPs                  []string      // CIDRs of client addresses not allowed to connect
+	GeoIPFile                string        // CSV file of "CIDR,tag" lines for tagging client connections
+	PersistBanList           bool          // persist bans in KVDB, so that bans are shared by all gates and games
+	BanListRefreshInterval   time.Duration // interval to reload bans from KVDB if ban list is persisted, 0 to disable
+	ProxyProtocol            bool          // TCP connections start with PROXY protocol v2 header sent by load balancers
+	ProxyProtocolTrustedIPs  []string      // CIDRs of load balancers allowed to send PROXY protocol header, required if proxy_protocol is enabled
+	ClientSendBudget         int           // max bytes per second sent to each client, 0 for unlimited
+	BandwidthPolicy          string        // policy when client send budget is exceeded: drop or delay
+	AuthMethod               string        // verifier for authenticating clients: jwt, kvdb, http or custom verifiers, authentication is disabled if empty
+	AuthJWTSecret            string        // HS256 secret for jwt auth method
+	AuthKVDBKeyPrefix        string        // key prefix of tokens for kvdb auth method
+	AuthKVDBTokenTTL         time.Duration // tokens for kvdb auth method expire after the TTL since created
+	AuthHTTPURL              string        // auth service URL for http auth method
+	AuthTimeout              time.Duration // clients are closed if not authenticated (or not sending required protocol version) in time
+	MinClientProtocolVersion int           // clients with older protocol versions are rejected
+	RecordDir                string        // directory of records of packets from clients selected by /record of the admin server
+}
+
+// DispatcherConfig defines fields of dispatcher config
+type DispatcherConfig struct {
 	ListenAddr             string
+	AdvertiseAddr          string
+	HTTPAddr               string
+	AdminAddr              string // address of admin HTTP server, empty to disable
 	LogFile                string
 	LogStderr              bool
-	HTTPAddr               string
 	LogLevel               string
-	GoMaxProcs             int
-	CompressConnection     bool
-	CompressFormat         string
-	EncryptConnection      bool
-	RSAKey                 string
-	RSACertificate         string
-	HeartbeatCheckInterval int
-	PositionSyncIntervalMS int
+	LogFormat              string        // console or json
+	ServiceFailoverTimeout time.Duration // calls to services of crashed games are kept until re-created in time
 }
 
-// DispatcherConfig defines fields of dispatcher config
-type DispatcherConfig struct {
-	ListenAddr    string
-	AdvertiseAddr string
-	HTTPAddr      string
-	LogFile       string
-	LogStderr     bool
-	LogLevel      string
+// BridgeConfig defines fields of HTTP bridge config
+type BridgeConfig struct {
+	ListenAddr string
+	LogFile    string
+	LogStderr  bool
+	LogLevel   string
+	LogFormat  string   // console or json
+	Token      string   // token for authenticating HTTP requests
+	GRPCAddrs  []string // gRPC addresses of games
+	GRPCToken  string   // token for calling gRPC of games
+	GRPCCAFile string   // CA file for verifying certificates of gRPC of games, gRPC is called without TLS if empty
+}
+
+// LogConfig defines rotation and retention of log files of all components
+type LogConfig struct {
+	RotateSize     int64         // log files are rotated when exceeding the size in bytes, 0 to disable
+	RotateInterval time.Duration // log files are rotated every interval, 0 to disable
+	MaxBackups     int           // max number of rotated log files to keep, 0 for unlimited
+	MaxAge         time.Duration // rotated log files older than max age are removed, 0 for unlimited
+	Compress       bool          // rotated log files are compressed using gzip
+}
+
+// AdminConfig defines authentication of admin HTTP servers of all components
+type AdminConfig struct {
+	Token          string // token for authenticating admin requests
+	CertFile       string // certificate file for serving admin HTTP server using TLS
+	KeyFile        string // key file for serving admin HTTP server using TLS
+	ClientCAFile   string // CA file for verifying client certificates (mTLS)
+	FaultInjection bool   // serve /faults for injecting faults on links between components, which should never be enabled in production
+}
+
+// WatchdogConfig defines thresholds and mitigations of the watchdog of all components
+type WatchdogConfig struct {
+	CheckInterval      time.Duration // interval of checking goroutines, heap and main loop stalls
+	GoroutinesWarn     int           // 0 to disable
+	GoroutinesCritical int           // 0 to disable
+	HeapWarn           uint64        // in bytes, 0 to disable
+	HeapCritical       uint64        // in bytes, 0 to disable
+	StallWarn          time.Duration // 0 to disable
+	StallCritical      time.Duration // 0 to disable
+	CriticalActions    []string      // mitigations when reaching critical thresholds: gc, profile, drain
+	ProfileDir         string        // directory of pprof profiles dumped by the profile action
+	ActionCooldown     time.Duration // min interval between mitigations
+}
+
+// WebhookConfig defines fields of webhook config
+type WebhookConfig struct {
+	URLs    []string      // URLs to deliver entity events
+	Secret  string        // secret for signing webhook requests
+	Timeout time.Duration // timeout of each webhook request
+	Retries int           // retry times of failed webhook requests
 }
 
 // GoWorldConfig defines the total GoWorld config file structure
@@ -95,33 +225,48 @@ type GoWorldConfig struct {
 	Storage          StorageConfig
 	KVDB             KVDBConfig
 	Debug            DebugConfig
+	Bridge           BridgeConfig
+	Webhook          WebhookConfig
+	Log              LogConfig
+	Admin            AdminConfig
+	Watchdog         WatchdogConfig
+	Features         map[string]FeatureConfig
+	RBAC             RBACConfig
 }
 
 // StorageConfig defines fields of storage config
 type StorageConfig struct {
-	Type       string // Type of storage (filesystem, mongodb, redis, mysql)
-	Directory  string // Directory of filesystem storage (filesystem)
-	Url        string // Connection URL (mongodb, redis, mysql)
-	DB         string // Database name (mongodb, redis)
-	Driver     string // SQL Driver name (mysql)
-	StartNodes common.StringSet
+	Type             string // Type of storage (filesystem, mongodb, redis, mysql, memory)
+	Directory        string // Directory of filesystem storage (filesystem)
+	Url              string // Connection URL (mongodb, redis, mysql)
+	DB               string // Database name (mongodb, redis)
+	Driver           string // SQL Driver name (mysql)
+	StartNodes       common.StringSet
+	SnapshotFile     string        // File to load and save entities, entities are not persisted if empty (memory)
+	SnapshotInterval time.Duration // Interval to save entities to the snapshot file (memory)
 }
 
 // KVDBConfig defines fields of KVDB config
 type KVDBConfig struct {
-	Type       string
-	Url        string // MongoDB
-	DB         string // MongoDB
-	Collection string // MongoDB
-	Driver     string // SQL Driver: e.x. mysql
-	StartNodes common.StringSet
+	Type             string
+	Url              string // MongoDB
+	DB               string // MongoDB
+	Collection       string // MongoDB
+	Driver           string // SQL Driver: e.x. mysql
+	StartNodes       common.StringSet
+	SnapshotFile     string        // File to load and save items, items are not persisted if empty (memory)
+	SnapshotInterval time.Duration // Interval to save items to the snapshot file (memory)
 }
 
 type DebugConfig struct {
 	Debug bool
 }
 
-// SetConfigFile sets the config file path (goworld.ini by default)
+// SetConfigFile sets the config file path (goworld.ini by default), YAML (.yaml, .yml) and TOML (.toml) config files are
+// also supported with the same schema
+//
+// The config can also be read from etcd or consul, see loadRemoteConfig. Config values can be overridden by environment
+// variables (see EnvPrefix) and command-line flags (see OverrideFlag).
 func SetConfigFile(f string) {
 	configLock.Lock()
 	if configFilePath == f {
@@ -135,8 +280,11 @@ func SetConfigFile(f string) {
 	Reload()
 }
 
-// GetConfigDir returns the directory of goworld.ini
+// GetConfigDir returns the directory of goworld.ini, or the working directory if the config is remote
 func GetConfigDir() string {
+	if isRemoteConfig(configFilePath) {
+		return ""
+	}
 	dir, _ := path.Split(configFilePath)
 	return dir
 }
@@ -162,13 +310,12 @@ func Get() *GoWorldConfig {
 	return goWorldConfig
 }
 
-// Reload forces goworld server to reload the whole config
+// Reload forces goworld server to reload the whole config, see HotReload
 func Reload() *GoWorldConfig {
-	configLock.Lock()
-	goWorldConfig = nil
-	configLock.Unlock()
-
-	return Get()
+	if _, err := HotReload(); err != nil {
+		gwlog.Errorf("reload config failed: %s", err)
+	}
+	return Get() // the config is read again if it has never been read successfully, and the process exits if it is invalid
 }
 
 func GetDeployment() *DeploymentConfig {
@@ -224,13 +371,38 @@ func GetKVDB() *KVDBConfig {
 	return &Get().KVDB
 }
 
+// GetBridge returns the HTTP bridge config
+func GetBridge() *BridgeConfig {
+	return &Get().Bridge
+}
+
+// GetWebhook returns the webhook config
+func GetWebhook() *WebhookConfig {
+	return &Get().Webhook
+}
+
+// GetLog returns the log config
+func GetLog() *LogConfig {
+	return &Get().Log
+}
+
+// GetAdmin returns the admin config
+func GetAdmin() *AdminConfig {
+	return &Get().Admin
+}
+
+// GetWatchdog returns the watchdog config
+func GetWatchdog() *WatchdogConfig {
+	return &Get().Watchdog
+}
+
 // DumpPretty format config to string in pretty format
 func DumpPretty(cfg interface{}) string {
 	s, err := json.MarshalIndent(cfg, "", "    ")
 	if err != nil {
 		return err.Error()
 	}
-	return string(s)
+	return maskSecrets(string(s))
 }
 
 func Debug() bool {
@@ -242,10 +414,14 @@ func readGoWorldConfig() *GoWorldConfig {
 		_Dispatchers: map[uint16]*DispatcherConfig{},
 		_Games:       map[uint16]*GameConfig{},
 		_Gates:       map[uint16]*GateConfig{},
+		Features:     map[string]FeatureConfig{},
 	}
 	gwlog.Infof("Using config file: %s", configFilePath)
-	iniFile, err := ini.Load(configFilePath)
+	iniFile, err := loadConfigFile(configFilePath)
 	checkConfigError(err, "")
+	expandTemplates(iniFile)
+	applyOverrides(iniFile)
+	resolveSecrets(iniFile)
 	gameCommonSec := iniFile.Section("game_common")
 	readGameCommonConfig(gameCommonSec, &config.GameCommon)
 	gateCommonSec := iniFile.Section("gate_common")
@@ -254,9 +430,16 @@ func readGoWorldConfig() *GoWorldConfig {
 	readDispatcherCommonConfig(dispatcherCommonSec, &config.DispatcherCommon)
 	deploymentSec := iniFile.Section("deployment")
 	if deploymentSec == nil {
-		gwlog.Fatalf("[deployment] section not found in config file")
+		configFatalf("[deployment] section not found in config file")
 	}
 	readDeploymentConfig(deploymentSec, &config.Deployment)
+	readBridgeConfig(iniFile.Section("bridge"), &config.Bridge)
+	readWebhookConfig(iniFile.Section("webhook"), &config.Webhook)
+	readLogConfig(iniFile.Section("log"), &config.Log)
+	readAdminConfig(iniFile.Section("admin"), &config.Admin)
+	readWatchdogConfig(iniFile.Section("watchdog"), &config.Watchdog)
+	readFeaturesConfig(iniFile.Section("features"), config.Features)
+	readRBACConfig(iniFile.Section("rbac"), &config.RBAC)
 	for _, sec := range iniFile.Sections() {
 		secName := sec.Name()
 		if secName == "DEFAULT" {
@@ -267,12 +450,15 @@ func readGoWorldConfig() *GoWorldConfig {
 		secName = strings.ToLower(secName)
 		if secName == "game_common" || secName == "gate_common" || secName == "dispatcher_common" {
 			// ignore common section here
-		} else if secName == "deployment" {
-			// deployment section already read
+		} else if secName == "deployment" || secName == "bridge" || secName == "webhook" || secName == "log" || secName == "admin" || secName == "watchdog" || secName == "features" || secName == "rbac" {
+			// deployment, bridge, webhook, log, admin, watchdog, features & rbac section already read
 		} else if len(secName) > 10 && secName[:10] == "dispatcher" {
 			// dispatcher config
 			id, err := strconv.Atoi(secName[10:])
 			checkConfigError(err, fmt.Sprintf("invalid dispatcher name: %s", secName))
+			if id <= 0 {
+				configFatalf("invalid dispatcher name: %s, dispatcher ID should be positive", secName)
+			}
 			if id > config.Deployment.DesiredDispatchers {
 				gwlog.Warnf("Section [%s] is ignored because [deployment].desired_dispatchers = %d", secName, config.Deployment.DesiredDispatchers)
 				continue
@@ -283,10 +469,12 @@ func readGoWorldConfig() *GoWorldConfig {
 			// game config
 			id, err := strconv.Atoi(secName[4:])
 			checkConfigError(err, fmt.Sprintf("invalid game name: %s", secName))
+			checkComponentID(secName, id, "desired_games", config.Deployment.DesiredGames)
 			config._Games[uint16(id)] = readGameConfig(sec, &config.GameCommon)
 		} else if len(secName) > 4 && secName[:4] == "gate" {
 			id, err := strconv.Atoi(secName[4:])
 			checkConfigError(err, fmt.Sprintf("invalid gate name: %s", secName))
+			checkComponentID(secName, id, "desired_gates", config.Deployment.DesiredGates)
 			config._Gates[uint16(id)] = readGateConfig(sec, &config.GateCommon)
 		} else if secName == "storage" {
 			// storage config
@@ -298,7 +486,7 @@ func readGoWorldConfig() *GoWorldConfig {
 			// debug config
 			readDebugConfig(sec, &config.Debug)
 		} else {
-			gwlog.Fatalf("unknown section: %s", secName)
+			configFatalf("unknown section: %s", secName)
 		}
 
 	}
@@ -315,7 +503,39 @@ func readGoWorldConfig() *GoWorldConfig {
 }
 
 func readDeploymentConfig(sec *ini.Section, config *DeploymentConfig) {
-	sec.MapTo(config)
+	for _, name := range []string{"desired_dispatchers", "desired_games", "desired_gates"} {
+		if !sec.HasKey(name) {
+			configFatalf("[deployment].%s is required", name)
+		}
+	}
+
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "desired_dispatchers" {
+			config.DesiredDispatchers = mustInt(sec, key, config.DesiredDispatchers)
+		} else if name == "desired_games" {
+			config.DesiredGames = mustInt(sec, key, config.DesiredGames)
+		} else if name == "desired_gates" {
+			config.DesiredGates = mustInt(sec, key, config.DesiredGates)
+		} else if name == "worker_games" {
+			for _, s := range key.Strings(",") {
+				gameid, err := strconv.Atoi(strings.TrimPrefix(s, "game"))
+				if err != nil || gameid <= 0 || gameid > 65535 {
+					configFatalf("[deployment].worker_games has invalid game: %q", s)
+				}
+				config.WorkerGames = append(config.WorkerGames, uint16(gameid))
+			}
+		} else if name == "worlds" {
+			config.Worlds = key.Strings(",")
+			for _, world := range config.Worlds {
+				if !worldNamePattern.MatchString(world) {
+					configFatalf("[deployment].worlds has invalid world name: %q", world)
+				}
+			}
+		} else {
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
 }
 
 func readGameCommonConfig(section *ini.Section, scc *GameConfig) {
@@ -323,10 +543,14 @@ func readGameCommonConfig(section *ini.Section, scc *GameConfig) {
 	scc.LogFile = "game.log"
 	scc.LogStderr = true
 	scc.LogLevel = _DEFAULT_LOG_LEVEL
+	scc.LogFormat = _DEFAULT_LOG_FORMAT
 	scc.SaveInterval = _DEFAULT_SAVE_ITNERVAL
 	scc.HTTPAddr = "127.0.0.1:25000"
 	scc.GoMaxProcs = 0
 	scc.PositionSyncIntervalMS = 100 // sync positions per 100ms by default
+	scc.HandlerBudget = _DEFAULT_HANDLER_BUDGET
+	scc.FrameBudget = _DEFAULT_FRAME_BUDGET
+	scc.AccountSessionConflict = "kick_older"
 
 	_readGameConfig(section, scc)
 }
@@ -336,7 +560,25 @@ func readGameConfig(sec *ini.Section, gameCommonConfig *GameConfig) *GameConfig
 	_readGameConfig(sec, &sc)
 	// validate game config
 	if sc.BootEntity == "" {
-		panic("boot_entity is not set in game config")
+		configFatalf("[%s].boot_entity is required", sec.Name())
+	}
+	if sc.GRPCAddr != "" && sc.GRPCToken == "" {
+		configFatalf("Game %s: grpc_addr is set, but grpc_token is not set", sec.Name())
+	}
+	if (sc.GRPCCertFile == "") != (sc.GRPCKeyFile == "") {
+		configFatalf("Game %s: grpc_cert_file and grpc_key_file should be set together", sec.Name())
+	}
+	if sc.GRPCAddr != "" && sc.GRPCCertFile == "" && !sc.GRPCAllowInsecure && !isLoopbackAddr(sc.GRPCAddr) {
+		configFatalf("Game %s: grpc_addr %s is not a loopback address, but grpc_cert_file is not set (set grpc_allow_insecure to serve gRPC without TLS)", sec.Name(), sc.GRPCAddr)
+	}
+	if sc.MaxAccountSessions < 0 {
+		configFatalf("Game %s: max_account_sessions is %d, which must not be negative", sec.Name(), sc.MaxAccountSessions)
+	}
+	if sc.ClientSyncBudget < 0 || sc.OverloadClientSyncBudget < 0 {
+		configFatalf("Game %s: client_sync_budget and overload_client_sync_budget must not be negative", sec.Name())
+	}
+	if sc.AccountSessionConflict != "kick_older" && sc.AccountSessionConflict != "reject_new" {
+		configFatalf("Game %s: account_session_conflict should be kick_older or reject_new, but is %s", sec.Name(), sc.AccountSessionConflict)
 	}
 	return &sc
 }
@@ -347,40 +589,135 @@ func _readGameConfig(sec *ini.Section, sc *GameConfig) {
 		if name == "boot_entity" {
 			sc.BootEntity = key.MustString(sc.BootEntity)
 		} else if name == "save_interval" {
-			sc.SaveInterval = time.Second * time.Duration(key.MustInt(int(_DEFAULT_SAVE_ITNERVAL/time.Second)))
+			sc.SaveInterval = time.Second * time.Duration(mustInt(sec, key, int(_DEFAULT_SAVE_ITNERVAL/time.Second)))
 		} else if name == "log_file" {
 			sc.LogFile = key.MustString(sc.LogFile)
 		} else if name == "log_stderr" {
-			sc.LogStderr = key.MustBool(sc.LogStderr)
+			sc.LogStderr = mustBool(sec, key, sc.LogStderr)
 		} else if name == "http_addr" {
 			sc.HTTPAddr = key.MustString(sc.HTTPAddr)
+		} else if name == "admin_addr" {
+			sc.AdminAddr = key.MustString(sc.AdminAddr)
 		} else if name == "log_level" {
 			sc.LogLevel = key.MustString(sc.LogLevel)
+		} else if name == "log_format" {
+			sc.LogFormat = key.MustString(sc.LogFormat)
 		} else if name == "gomaxprocs" {
-			sc.GoMaxProcs = key.MustInt(sc.GoMaxProcs)
+			sc.GoMaxProcs = mustInt(sec, key, sc.GoMaxProcs)
 		} else if name == "position_sync_interval_ms" {
-			sc.PositionSyncIntervalMS = key.MustInt(sc.PositionSyncIntervalMS)
+			sc.PositionSyncIntervalMS = mustInt(sec, key, sc.PositionSyncIntervalMS)
 		} else if name == "ban_boot_entity" {
-			sc.BanBootEntity = key.MustBool(sc.BanBootEntity)
+			sc.BanBootEntity = mustBool(sec, key, sc.BanBootEntity)
+		} else if name == "session_resume_timeout" {
+			sc.SessionResumeTimeout = time.Second * time.Duration(mustInt(sec, key, int(sc.SessionResumeTimeout/time.Second)))
+		} else if name == "max_account_sessions" {
+			sc.MaxAccountSessions = mustInt(sec, key, sc.MaxAccountSessions)
+		} else if name == "account_session_conflict" {
+			sc.AccountSessionConflict = key.MustString(sc.AccountSessionConflict)
+		} else if name == "grpc_addr" {
+			sc.GRPCAddr = key.MustString(sc.GRPCAddr)
+		} else if name == "grpc_token" {
+			sc.GRPCToken = key.MustString(sc.GRPCToken)
+		} else if name == "grpc_cert_file" {
+			sc.GRPCCertFile = key.MustString(sc.GRPCCertFile)
+		} else if name == "grpc_key_file" {
+			sc.GRPCKeyFile = key.MustString(sc.GRPCKeyFile)
+		} else if name == "grpc_allow_insecure" {
+			sc.GRPCAllowInsecure = mustBool(sec, key, sc.GRPCAllowInsecure)
+		} else if name == "export_metrics" {
+			sc.ExportMetrics = mustBool(sec, key, sc.ExportMetrics)
+		} else if name == "handler_budget_ms" {
+			sc.HandlerBudget = time.Millisecond * time.Duration(mustInt(sec, key, int(sc.HandlerBudget/time.Millisecond)))
+		} else if name == "handler_deadline_ms" {
+			sc.HandlerDeadline = time.Millisecond * time.Duration(mustInt(sec, key, int(sc.HandlerDeadline/time.Millisecond)))
+		} else if name == "frame_budget_ms" {
+			sc.FrameBudget = time.Millisecond * time.Duration(mustInt(sec, key, int(sc.FrameBudget/time.Millisecond)))
+		} else if name == "post_budget_ms" {
+			sc.PostBudget = time.Millisecond * time.Duration(mustInt(sec, key, int(sc.PostBudget/time.Millisecond)))
+		} else if name == "client_sync_budget" {
+			sc.ClientSyncBudget = mustInt(sec, key, sc.ClientSyncBudget)
+		} else if name == "overload_client_sync_budget" {
+			sc.OverloadClientSyncBudget = mustInt(sec, key, sc.OverloadClientSyncBudget)
+		} else if name == "crash_dump_dir" {
+			sc.CrashDumpDir = key.MustString(sc.CrashDumpDir)
+		} else if name == "crash_dump_storage" {
+			sc.CrashDumpStorage = mustBool(sec, key, sc.CrashDumpStorage)
+		} else if name == "jitter_entity_timers" {
+			sc.JitterEntityTimers = mustBool(sec, key, sc.JitterEntityTimers)
+		} else if name == "timing_wheel" {
+			sc.TimingWheel = mustBool(sec, key, sc.TimingWheel)
+		} else if name == "deterministic" {
+			sc.Deterministic = mustBool(sec, key, sc.Deterministic)
+		} else if name == "deterministic_seed" {
+			sc.DeterministicSeed = mustInt64(sec, key, sc.DeterministicSeed)
+		} else if name == "async_workers" {
+			sc.AsyncWorkers = readAsyncWorkers(sec, key)
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 }
 
+// readAsyncWorkers reads numbers of workers of async job groups, e.g. http:4,pathfinding:8
+func readAsyncWorkers(sec *ini.Section, key *ini.Key) map[string]int {
+	workers := map[string]int{}
+	for _, item := range key.Strings(",") {
+		colon := strings.LastIndex(item, ":")
+		count, err := strconv.Atoi(item[colon+1:])
+		if colon <= 0 || err != nil || count <= 0 {
+			invalidKeyType(sec, key, "numbers of workers of groups (e.g. http:4,pathfinding:8)")
+		}
+		workers[strings.TrimSpace(item[:colon])] = count
+	}
+	return workers
+}
+
 func readGateCommonConfig(section *ini.Section, gcc *GateConfig) {
 	gcc.LogFile = "gate.log"
 	gcc.LogStderr = true
 	gcc.LogLevel = _DEFAULT_LOG_LEVEL
+	gcc.LogFormat = _DEFAULT_LOG_FORMAT
 	gcc.ListenAddr = "0.0.0.0:14000"
 	gcc.HTTPAddr = "127.0.0.1:24000"
 	gcc.GoMaxProcs = 0
 	gcc.CompressFormat = ""
 	gcc.CompressFormat = "gwsnappy"
+	gcc.CompressFormats = nil
+	gcc.CompressThreshold = 512
 	gcc.RSAKey = "rsa.key"
 	gcc.RSACertificate = "rsa.crt"
+	gcc.CipherFormats = nil
+	gcc.RequirePacketIntegrity = false
+	gcc.SignKeyExchange = false
+	gcc.RequireKeyExchange = false
+	gcc.PacketIntegrityViolation = "disconnect"
 	gcc.HeartbeatCheckInterval = 0
 	gcc.PositionSyncIntervalMS = 100
+	gcc.ClientFlushIntervalMS = int(consts.CLIENT_PROXY_WRITE_FLUSH_INTERVAL / time.Millisecond)
+	gcc.UrgentClientRPC = true
+	gcc.DrainTimeout = time.Minute
+	gcc.FloodBanDuration = time.Minute
+	gcc.FloodBanScope = "account"
+	gcc.PingInterval = time.Second * 5
+	gcc.LatencyChangeThreshold = time.Millisecond * 20
+	gcc.AllowIPs = nil
+	gcc.DenyIPs = nil
+	gcc.GeoIPFile = ""
+	gcc.PersistBanList = false
+	gcc.BanListRefreshInterval = time.Second * 10
+	gcc.ExportMetrics = false
+	gcc.ProxyProtocol = false
+	gcc.ProxyProtocolTrustedIPs = nil
+	gcc.ClientSendBudget = 0
+	gcc.BandwidthPolicy = "drop"
+	gcc.AuthMethod = ""
+	gcc.AuthJWTSecret = ""
+	gcc.AuthKVDBKeyPrefix = "_auth_token_:"
+	gcc.AuthKVDBTokenTTL = time.Minute * 5
+	gcc.AuthHTTPURL = ""
+	gcc.AuthTimeout = time.Second * 10
+	gcc.MinClientProtocolVersion = 1
+	gcc.RecordDir = "records"
 
 	_readGateConfig(section, gcc)
 }
@@ -390,13 +727,52 @@ func readGateConfig(sec *ini.Section, gateCommonConfig *GateConfig) *GateConfig
 	_readGateConfig(sec, &sc)
 	// validate game config here
 	if sc.CompressConnection && sc.CompressFormat == "" {
-		gwlog.Fatalf("Gate %s: compress_connection is enabled, but compress format is not set", sec.Name())
+		configFatalf("Gate %s: compress_connection is enabled, but compress format is not set", sec.Name())
+	}
+	if sc.CompressThreshold <= 0 {
+		configFatalf("Gate %s: compress_threshold should be positive, but is %d", sec.Name(), sc.CompressThreshold)
+	}
+	if sc.ClientFlushIntervalMS <= 0 {
+		configFatalf("Gate %s: client_flush_interval_ms should be positive, but is %d", sec.Name(), sc.ClientFlushIntervalMS)
+	}
+	if sc.FloodBanScope != "account" && sc.FloodBanScope != "ip" && sc.FloodBanScope != "none" {
+		configFatalf("Gate %s: flood_ban_scope should be account, ip or none, but is %s", sec.Name(), sc.FloodBanScope)
+	}
+	if sc.BandwidthPolicy != "drop" && sc.BandwidthPolicy != "delay" {
+		configFatalf("Gate %s: bandwidth_policy should be drop or delay, but is %s", sec.Name(), sc.BandwidthPolicy)
+	}
+	if sc.PacketIntegrityViolation != "disconnect" && sc.PacketIntegrityViolation != "drop" && sc.PacketIntegrityViolation != "report" {
+		configFatalf("Gate %s: packet_integrity_violation should be disconnect, drop or report, but is %s", sec.Name(), sc.PacketIntegrityViolation)
+	}
+	if sc.RequirePacketIntegrity && len(sc.CipherFormats) == 0 {
+		configFatalf("Gate %s: require_packet_integrity is enabled, but cipher_formats is not set", sec.Name())
+	}
+	if sc.SignKeyExchange && len(sc.CipherFormats) == 0 {
+		configFatalf("Gate %s: sign_key_exchange is enabled, but cipher_formats is not set", sec.Name())
+	}
+	if sc.SignKeyExchange && (sc.RSAKey == "" || sc.RSACertificate == "") {
+		configFatalf("Gate %s: sign_key_exchange is enabled, but rsa_key or rsa_certificate is not set", sec.Name())
+	}
+	if sc.ProxyProtocol && !hasNonEmptyString(sc.ProxyProtocolTrustedIPs) {
+		configFatalf("Gate %s: proxy_protocol is enabled, but proxy_protocol_trusted_ips is not set", sec.Name())
+	}
+	if sc.RequireKeyExchange && len(sc.CipherFormats) == 0 {
+		configFatalf("Gate %s: require_key_exchange is enabled, but cipher_formats is not set", sec.Name())
+	}
+	if sc.MinClientProtocolVersion < 1 {
+		configFatalf("Gate %s: min_client_protocol_version should be at least 1, but is %d", sec.Name(), sc.MinClientProtocolVersion)
+	}
+	if (sc.AuthMethod != "" || sc.MinClientProtocolVersion > 1 || sc.RequirePacketIntegrity) && sc.AuthTimeout <= 0 {
+		configFatalf("Gate %s: auth_timeout should be positive, but is %s", sec.Name(), sc.AuthTimeout)
+	}
+	if sc.AuthMethod == "kvdb" && sc.AuthKVDBTokenTTL <= 0 {
+		configFatalf("Gate %s: auth_kvdb_token_ttl should be positive, but is %s", sec.Name(), sc.AuthKVDBTokenTTL)
 	}
 	if sc.EncryptConnection && sc.RSAKey == "" {
-		gwlog.Fatalf("Gate %s: encrypt_connection is enabled, but rsa_key is not set", sec.Name())
+		configFatalf("Gate %s: encrypt_connection is enabled, but rsa_key is not set", sec.Name())
 	}
 	if sc.EncryptConnection && sc.RSACertificate == "" {
-		gwlog.Fatalf("Gate %s: encrypt_connection is enabled, but rsa_certificate is not set", sec.Name())
+		configFatalf("Gate %s: encrypt_connection is enabled, but rsa_certificate is not set", sec.Name())
 	}
 	return &sc
 }
@@ -406,32 +782,108 @@ func _readGateConfig(sec *ini.Section, sc *GateConfig) {
 		name := strings.ToLower(key.Name())
 		if name == "listen_addr" {
 			sc.ListenAddr = key.MustString(sc.ListenAddr)
+		} else if name == "advertise_addr" {
+			sc.AdvertiseAddr = key.MustString(sc.AdvertiseAddr)
 		} else if name == "log_file" {
 			sc.LogFile = key.MustString(sc.LogFile)
 		} else if name == "log_stderr" {
-			sc.LogStderr = key.MustBool(sc.LogStderr)
+			sc.LogStderr = mustBool(sec, key, sc.LogStderr)
 		} else if name == "http_addr" {
 			sc.HTTPAddr = key.MustString(sc.HTTPAddr)
+		} else if name == "admin_addr" {
+			sc.AdminAddr = key.MustString(sc.AdminAddr)
 		} else if name == "log_level" {
 			sc.LogLevel = key.MustString(sc.LogLevel)
+		} else if name == "log_format" {
+			sc.LogFormat = key.MustString(sc.LogFormat)
 		} else if name == "gomaxprocs" {
-			sc.GoMaxProcs = key.MustInt(sc.GoMaxProcs)
+			sc.GoMaxProcs = mustInt(sec, key, sc.GoMaxProcs)
 		} else if name == "compress_connection" {
-			sc.CompressConnection = key.MustBool(sc.CompressConnection)
+			sc.CompressConnection = mustBool(sec, key, sc.CompressConnection)
 		} else if name == "compress_format" {
 			sc.CompressFormat = key.MustString(sc.CompressFormat)
+		} else if name == "compress_formats" {
+			sc.CompressFormats = key.Strings(",")
+		} else if name == "compress_threshold" {
+			sc.CompressThreshold = mustInt(sec, key, sc.CompressThreshold)
 		} else if name == "encrypt_connection" {
-			sc.EncryptConnection = key.MustBool(sc.EncryptConnection)
+			sc.EncryptConnection = mustBool(sec, key, sc.EncryptConnection)
 		} else if name == "rsa_key" {
 			sc.RSAKey = key.MustString(sc.RSAKey)
 		} else if name == "rsa_certificate" {
 			sc.RSACertificate = key.MustString(sc.RSACertificate)
+		} else if name == "cipher_formats" {
+			sc.CipherFormats = key.Strings(",")
+		} else if name == "require_packet_integrity" {
+			sc.RequirePacketIntegrity = mustBool(sec, key, sc.RequirePacketIntegrity)
+		} else if name == "sign_key_exchange" {
+			sc.SignKeyExchange = mustBool(sec, key, sc.SignKeyExchange)
+		} else if name == "require_key_exchange" {
+			sc.RequireKeyExchange = mustBool(sec, key, sc.RequireKeyExchange)
+		} else if name == "packet_integrity_violation" {
+			sc.PacketIntegrityViolation = key.MustString(sc.PacketIntegrityViolation)
 		} else if name == "heartbeat_check_interval" {
-			sc.HeartbeatCheckInterval = key.MustInt(sc.HeartbeatCheckInterval)
+			sc.HeartbeatCheckInterval = mustInt(sec, key, sc.HeartbeatCheckInterval)
 		} else if name == "position_sync_interval_ms" {
-			sc.PositionSyncIntervalMS = key.MustInt(sc.PositionSyncIntervalMS)
+			sc.PositionSyncIntervalMS = mustInt(sec, key, sc.PositionSyncIntervalMS)
+		} else if name == "client_flush_interval_ms" {
+			sc.ClientFlushIntervalMS = mustInt(sec, key, sc.ClientFlushIntervalMS)
+		} else if name == "urgent_client_rpc" {
+			sc.UrgentClientRPC = mustBool(sec, key, sc.UrgentClientRPC)
+		} else if name == "drain_timeout" {
+			sc.DrainTimeout = time.Second * time.Duration(mustInt(sec, key, int(sc.DrainTimeout/time.Second)))
+		} else if name == "max_client_packets_per_sec" {
+			sc.MaxClientPacketsPerSec = mustInt(sec, key, sc.MaxClientPacketsPerSec)
+		} else if name == "max_client_bytes_per_sec" {
+			sc.MaxClientBytesPerSec = mustInt(sec, key, sc.MaxClientBytesPerSec)
+		} else if name == "max_client_packet_size" {
+			sc.MaxClientPacketSize = mustInt(sec, key, sc.MaxClientPacketSize)
+		} else if name == "flood_ban_duration" {
+			sc.FloodBanDuration = time.Second * time.Duration(mustInt(sec, key, int(sc.FloodBanDuration/time.Second)))
+		} else if name == "flood_ban_scope" {
+			sc.FloodBanScope = key.MustString(sc.FloodBanScope)
+		} else if name == "export_metrics" {
+			sc.ExportMetrics = mustBool(sec, key, sc.ExportMetrics)
+		} else if name == "ping_interval" {
+			sc.PingInterval = time.Second * time.Duration(mustInt(sec, key, int(sc.PingInterval/time.Second)))
+		} else if name == "latency_change_threshold_ms" {
+			sc.LatencyChangeThreshold = time.Millisecond * time.Duration(mustInt(sec, key, int(sc.LatencyChangeThreshold/time.Millisecond)))
+		} else if name == "allow_ips" {
+			sc.AllowIPs = key.Strings(",")
+		} else if name == "deny_ips" {
+			sc.DenyIPs = key.Strings(",")
+		} else if name == "geoip_file" {
+			sc.GeoIPFile = key.MustString(sc.GeoIPFile)
+		} else if name == "persist_ban_list" {
+			sc.PersistBanList = mustBool(sec, key, sc.PersistBanList)
+		} else if name == "ban_list_refresh_interval" {
+			sc.BanListRefreshInterval = time.Second * time.Duration(mustInt(sec, key, int(sc.BanListRefreshInterval/time.Second)))
+		} else if name == "proxy_protocol" {
+			sc.ProxyProtocol = mustBool(sec, key, sc.ProxyProtocol)
+		} else if name == "proxy_protocol_trusted_ips" {
+			sc.ProxyProtocolTrustedIPs = key.Strings(",")
+		} else if name == "client_send_budget" {
+			sc.ClientSendBudget = mustInt(sec, key, sc.ClientSendBudget)
+		} else if name == "bandwidth_policy" {
+			sc.BandwidthPolicy = key.MustString(sc.BandwidthPolicy)
+		} else if name == "auth_method" {
+			sc.AuthMethod = key.MustString(sc.AuthMethod)
+		} else if name == "auth_jwt_secret" {
+			sc.AuthJWTSecret = key.MustString(sc.AuthJWTSecret)
+		} else if name == "auth_kvdb_key_prefix" {
+			sc.AuthKVDBKeyPrefix = key.MustString(sc.AuthKVDBKeyPrefix)
+		} else if name == "auth_kvdb_token_ttl" {
+			sc.AuthKVDBTokenTTL = time.Second * time.Duration(mustInt(sec, key, int(sc.AuthKVDBTokenTTL/time.Second)))
+		} else if name == "auth_http_url" {
+			sc.AuthHTTPURL = key.MustString(sc.AuthHTTPURL)
+		} else if name == "auth_timeout" {
+			sc.AuthTimeout = time.Second * time.Duration(mustInt(sec, key, int(sc.AuthTimeout/time.Second)))
+		} else if name == "min_client_protocol_version" {
+			sc.MinClientProtocolVersion = mustInt(sec, key, sc.MinClientProtocolVersion)
+		} else if name == "record_dir" {
+			sc.RecordDir = key.MustString(sc.RecordDir)
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 }
@@ -443,6 +895,8 @@ func readDispatcherCommonConfig(section *ini.Section, dc *DispatcherConfig) {
 	dc.LogFile = "dispatcher.log"
 	dc.LogStderr = true
 	dc.LogLevel = _DEFAULT_LOG_LEVEL
+	dc.LogFormat = _DEFAULT_LOG_FORMAT
+	dc.ServiceFailoverTimeout = time.Second * 30
 
 	_readDispatcherConfig(section, dc)
 }
@@ -464,13 +918,19 @@ func _readDispatcherConfig(sec *ini.Section, config *DispatcherConfig) {
 		} else if name == "log_file" {
 			config.LogFile = key.MustString(config.LogFile)
 		} else if name == "log_stderr" {
-			config.LogStderr = key.MustBool(config.LogStderr)
+			config.LogStderr = mustBool(sec, key, config.LogStderr)
 		} else if name == "http_addr" {
 			config.HTTPAddr = key.MustString(config.HTTPAddr)
+		} else if name == "admin_addr" {
+			config.AdminAddr = key.MustString(config.AdminAddr)
 		} else if name == "log_level" {
 			config.LogLevel = key.MustString(config.LogLevel)
+		} else if name == "log_format" {
+			config.LogFormat = key.MustString(config.LogFormat)
+		} else if name == "service_failover_timeout" {
+			config.ServiceFailoverTimeout = time.Second * time.Duration(mustInt(sec, key, int(config.ServiceFailoverTimeout/time.Second)))
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 	return
@@ -484,6 +944,8 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 	config.Url = ""
 	config.Driver = ""
 	config.StartNodes = common.StringSet{}
+	config.SnapshotFile = ""
+	config.SnapshotInterval = _DEFAULT_SNAPSHOT_INTERVAL
 
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
@@ -499,8 +961,16 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 			config.Driver = key.MustString(config.Driver)
 		} else if strings.HasPrefix(name, "start_nodes_") {
 			config.StartNodes.Add(key.MustString(""))
+		} else if name == "start_nodes" {
+			for _, node := range key.Strings(",") {
+				config.StartNodes.Add(node)
+			}
+		} else if name == "snapshot_file" {
+			config.SnapshotFile = key.MustString(config.SnapshotFile)
+		} else if name == "snapshot_interval" {
+			config.SnapshotInterval = time.Second * time.Duration(mustInt(sec, key, int(config.SnapshotInterval/time.Second)))
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 
@@ -515,6 +985,7 @@ func readStorageConfig(sec *ini.Section, config *StorageConfig) {
 
 func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 	config.StartNodes = common.StringSet{}
+	config.SnapshotInterval = _DEFAULT_SNAPSHOT_INTERVAL
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "type" {
@@ -529,8 +1000,16 @@ func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 			config.Driver = key.MustString(config.Driver)
 		} else if strings.HasPrefix(name, "start_nodes_") {
 			config.StartNodes.Add(key.MustString(""))
+		} else if name == "start_nodes" {
+			for _, node := range key.Strings(",") {
+				config.StartNodes.Add(node)
+			}
+		} else if name == "snapshot_file" {
+			config.SnapshotFile = key.MustString(config.SnapshotFile)
+		} else if name == "snapshot_interval" {
+			config.SnapshotInterval = time.Second * time.Duration(mustInt(sec, key, int(config.SnapshotInterval/time.Second)))
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 
@@ -546,14 +1025,16 @@ func readKVDBConfig(sec *ini.Section, config *KVDBConfig) {
 func validateKVDBConfig(config *KVDBConfig) {
 	if config.Type == "" {
 		// KVDB not enabled, it's OK
+	} else if config.Type == "memory" {
+		// snapshot file is optional
 	} else if config.Type == "mongodb" {
 		// must set DB and Collection for mongodb
 		if config.Url == "" || config.DB == "" || config.Collection == "" {
-			gwlog.Fatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
+			configFatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
 		}
 	} else if config.Type == "redis" {
 		if config.Url == "" {
-			gwlog.Fatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
+			configFatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
 		}
 		_, err := strconv.Atoi(config.DB) // make sure db is integer for redis
 		if err != nil {
@@ -561,22 +1042,22 @@ func validateKVDBConfig(config *KVDBConfig) {
 		}
 	} else if config.Type == "redis_cluster" {
 		if len(config.StartNodes) == 0 {
-			gwlog.Fatalf("must have at least 1 start_nodes for [kvdb].redis_cluster")
+			configFatalf("must have at least 1 start_nodes for [kvdb].redis_cluster")
 		}
 		for s := range config.StartNodes {
 			if s == "" {
-				gwlog.Fatalf("start_nodes must not be empty")
+				configFatalf("start_nodes must not be empty")
 			}
 		}
 	} else if config.Type == "sql" {
 		if config.Driver == "" {
-			gwlog.Fatalf("invalid %s KVDB config:\n %s", config.Type, DumpPretty(config))
+			configFatalf("invalid %s KVDB config:\n %s", config.Type, DumpPretty(config))
 		}
 		if config.Url == "" {
-			gwlog.Fatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
+			configFatalf("invalid %s KVDB config:\n%s", config.Type, DumpPretty(config))
 		}
 	} else {
-		gwlog.Fatalf("unknown storage type: %s", config.Type)
+		configFatalf("unknown storage type: %s", config.Type)
 	}
 }
 
@@ -586,19 +1067,173 @@ func readDebugConfig(sec *ini.Section, config *DebugConfig) {
 	for _, key := range sec.Keys() {
 		name := strings.ToLower(key.Name())
 		if name == "debug" {
-			config.Debug = key.MustBool(config.Debug)
+			config.Debug = mustBool(sec, key, config.Debug)
+		} else {
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+}
+
+func readBridgeConfig(sec *ini.Section, config *BridgeConfig) {
+	config.ListenAddr = "127.0.0.1:27000"
+	config.LogFile = "bridge.log"
+	config.LogStderr = true
+	config.LogLevel = _DEFAULT_LOG_LEVEL
+	config.LogFormat = _DEFAULT_LOG_FORMAT
+
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "listen_addr" {
+			config.ListenAddr = key.MustString(config.ListenAddr)
+		} else if name == "log_file" {
+			config.LogFile = key.MustString(config.LogFile)
+		} else if name == "log_stderr" {
+			config.LogStderr = mustBool(sec, key, config.LogStderr)
+		} else if name == "log_level" {
+			config.LogLevel = key.MustString(config.LogLevel)
+		} else if name == "log_format" {
+			config.LogFormat = key.MustString(config.LogFormat)
+		} else if name == "token" {
+			config.Token = key.MustString(config.Token)
+		} else if name == "grpc_addrs" {
+			config.GRPCAddrs = key.Strings(",")
+		} else if name == "grpc_token" {
+			config.GRPCToken = key.MustString(config.GRPCToken)
+		} else if name == "grpc_ca_file" {
+			config.GRPCCAFile = key.MustString(config.GRPCCAFile)
 		} else {
-			gwlog.Fatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
 		}
 	}
 }
 
+func readWebhookConfig(sec *ini.Section, config *WebhookConfig) {
+	config.Timeout = time.Second * 5
+	config.Retries = 2
+
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "urls" {
+			config.URLs = key.Strings(",")
+		} else if name == "secret" {
+			config.Secret = key.MustString(config.Secret)
+		} else if name == "timeout" {
+			config.Timeout = time.Second * time.Duration(mustInt(sec, key, int(config.Timeout/time.Second)))
+		} else if name == "retries" {
+			config.Retries = mustInt(sec, key, config.Retries)
+		} else {
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+
+	if len(config.URLs) > 0 && config.Secret == "" {
+		configFatalf("[%s].urls is set, but secret is not set", sec.Name())
+	}
+}
+
+func readLogConfig(sec *ini.Section, config *LogConfig) {
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "rotate_size_mb" {
+			config.RotateSize = mustInt64(sec, key, config.RotateSize>>20) << 20
+		} else if name == "rotate_interval" {
+			config.RotateInterval = time.Second * time.Duration(mustInt(sec, key, int(config.RotateInterval/time.Second)))
+		} else if name == "max_backups" {
+			config.MaxBackups = mustInt(sec, key, config.MaxBackups)
+		} else if name == "max_age_days" {
+			config.MaxAge = time.Hour * 24 * time.Duration(mustInt(sec, key, int(config.MaxAge/(time.Hour*24))))
+		} else if name == "compress" {
+			config.Compress = mustBool(sec, key, config.Compress)
+		} else {
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+}
+
+func readAdminConfig(sec *ini.Section, config *AdminConfig) {
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "token" {
+			config.Token = key.MustString(config.Token)
+		} else if name == "cert_file" {
+			config.CertFile = key.MustString(config.CertFile)
+		} else if name == "key_file" {
+			config.KeyFile = key.MustString(config.KeyFile)
+		} else if name == "client_ca_file" {
+			config.ClientCAFile = key.MustString(config.ClientCAFile)
+		} else if name == "fault_injection" {
+			config.FaultInjection = mustBool(sec, key, config.FaultInjection)
+		} else {
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+
+	if (config.CertFile == "") != (config.KeyFile == "") {
+		configFatalf("[admin].cert_file and key_file should be set together")
+	}
+	if config.ClientCAFile != "" && config.CertFile == "" {
+		configFatalf("[admin].client_ca_file is set, but cert_file and key_file are not set")
+	}
+}
+
+func readWatchdogConfig(sec *ini.Section, config *WatchdogConfig) {
+	config.CheckInterval = time.Second * 5
+	config.ProfileDir = "profiles"
+	config.ActionCooldown = time.Minute * 5
+
+	for _, key := range sec.Keys() {
+		name := strings.ToLower(key.Name())
+		if name == "check_interval" {
+			config.CheckInterval = time.Second * time.Duration(mustInt(sec, key, int(config.CheckInterval/time.Second)))
+		} else if name == "goroutines_warn" {
+			config.GoroutinesWarn = mustInt(sec, key, config.GoroutinesWarn)
+		} else if name == "goroutines_critical" {
+			config.GoroutinesCritical = mustInt(sec, key, config.GoroutinesCritical)
+		} else if name == "heap_warn_mb" {
+			config.HeapWarn = mustUint64(sec, key, config.HeapWarn>>20) << 20
+		} else if name == "heap_critical_mb" {
+			config.HeapCritical = mustUint64(sec, key, config.HeapCritical>>20) << 20
+		} else if name == "stall_warn_ms" {
+			config.StallWarn = time.Millisecond * time.Duration(mustInt(sec, key, int(config.StallWarn/time.Millisecond)))
+		} else if name == "stall_critical_ms" {
+			config.StallCritical = time.Millisecond * time.Duration(mustInt(sec, key, int(config.StallCritical/time.Millisecond)))
+		} else if name == "critical_actions" {
+			config.CriticalActions = key.Strings(",")
+		} else if name == "profile_dir" {
+			config.ProfileDir = key.MustString(config.ProfileDir)
+		} else if name == "action_cooldown" {
+			config.ActionCooldown = time.Second * time.Duration(mustInt(sec, key, int(config.ActionCooldown/time.Second)))
+		} else {
+			configFatalf("section %s has unknown key: %s", sec.Name(), key.Name())
+		}
+	}
+
+	if config.CheckInterval <= 0 {
+		configFatalf("[watchdog].check_interval should be positive")
+	}
+	for _, action := range config.CriticalActions {
+		if action != "gc" && action != "profile" && action != "drain" {
+			configFatalf("[watchdog].critical_actions has unknown action: %s, should be gc, profile or drain", action)
+		}
+	}
+}
+
+// checkComponentID checks the ID of game or gate section, sections out of the desired range are read but warned
+func checkComponentID(secName string, id int, desiredKey string, desired int) {
+	if id <= 0 || id > 65535 {
+		configFatalf("invalid %s name: %s, ID should be 1~65535", strings.TrimRight(secName, "0123456789"), secName)
+	}
+	if id > desired {
+		gwlog.Warnf("Section [%s] is not used because [deployment].%s = %d", secName, desiredKey, desired)
+	}
+}
+
 func checkConfigError(err error, msg string) {
 	if err != nil {
 		if msg == "" {
 			msg = err.Error()
 		}
-		gwlog.Fatalf("read config error: %s", msg)
+		configFatalf("read config error: %s", msg)
 	}
 }
 
@@ -606,51 +1241,59 @@ func validateStorageConfig(config *StorageConfig) {
 	if config.Type == "filesystem" {
 		// directory must be set
 		if config.Directory == "" {
-			gwlog.Fatalf("directory is not set in %s storage config", config.Type)
+			configFatalf("directory is not set in %s storage config", config.Type)
 		}
+	} else if config.Type == "memory" {
+		// snapshot file is optional
 	} else if config.Type == "mongodb" {
 		if config.Url == "" {
-			gwlog.Fatalf("url is not set in %s storage config", config.Type)
+			configFatalf("url is not set in %s storage config", config.Type)
 		}
 		if config.DB == "" {
-			gwlog.Fatalf("db is not set in %s storage config", config.Type)
+			configFatalf("db is not set in %s storage config", config.Type)
 		}
 	} else if config.Type == "redis" {
 		if config.Url == "" {
-			gwlog.Fatalf("redis host is not set")
+			configFatalf("redis host is not set")
 		}
 		if _, err := strconv.Atoi(config.DB); err != nil {
 			gwlog.Panic(errors.Wrap(err, "redis db must be integer"))
 		}
 	} else if config.Type == "redis_cluster" {
 		if len(config.StartNodes) == 0 {
-			gwlog.Fatalf("must have at least 1 start_nodes for [storage].redis_cluster")
+			configFatalf("must have at least 1 start_nodes for [storage].redis_cluster")
 		}
 		for s := range config.StartNodes {
 			if s == "" {
-				gwlog.Fatalf("start_nodes must not be empty")
+				configFatalf("start_nodes must not be empty")
 			}
 		}
 	} else if config.Type == "sql" {
 		if config.Driver == "" {
-			gwlog.Fatalf("sql driver is not set")
+			configFatalf("sql driver is not set")
 		}
 		if config.Url == "" {
-			gwlog.Fatalf("db url is not set")
+			configFatalf("db url is not set")
 		}
 	} else {
-		gwlog.Fatalf("unknown storage type: %s", config.Type)
+		configFatalf("unknown storage type: %s", config.Type)
 	}
 }
 
 func validateConfig(config *GoWorldConfig) {
 	deploymentConfig := &config.Deployment
 	if deploymentConfig.DesiredGates <= 0 {
-		gwlog.Fatalf("[deployment].desired_gates is %d, which must be positive", deploymentConfig.DesiredGates)
+		configFatalf("[deployment].desired_gates is %d, which must be positive", deploymentConfig.DesiredGates)
 	}
 
 	if deploymentConfig.DesiredGames <= 0 {
-		gwlog.Fatalf("[deployment].desired_games is %d, which must be positive", deploymentConfig.DesiredGames)
+		configFatalf("[deployment].desired_games is %d, which must be positive", deploymentConfig.DesiredGames)
+	}
+
+	for _, gameid := range deploymentConfig.WorkerGames {
+		if int(gameid) > deploymentConfig.DesiredGames {
+			configFatalf("[deployment].worker_games has game%d, but desired_games is %d", gameid, deploymentConfig.DesiredGames)
+		}
 	}
 
 	dispatchersNum := deploymentConfig.DesiredDispatchers
@@ -658,12 +1301,14 @@ func validateConfig(config *GoWorldConfig) {
 		gwlog.Panicf("[deployment].desired_dispatchers is %d, but find %d dispatcher section in config file", dispatchersNum, len(config._Dispatchers))
 	}
 	if dispatchersNum <= 0 {
-		gwlog.Fatalf("dispatcher not found in config file, must has at least 1 dispatcher")
+		configFatalf("dispatcher not found in config file, must has at least 1 dispatcher")
 	}
 
 	for dispatcherid := 1; dispatcherid <= dispatchersNum; dispatcherid++ {
 		if _, ok := config._Dispatchers[uint16(dispatcherid)]; !ok {
-			gwlog.Fatalf("found %d dispatchers in config file, but dispatcher%d is not found. dispatcherid must be 1~%d", dispatchersNum, dispatcherid, dispatchersNum)
+			configFatalf("found %d dispatchers in config file, but dispatcher%d is not found. dispatcherid must be 1~%d", dispatchersNum, dispatcherid, dispatchersNum)
 		}
 	}
+
+	validateAddrs(config)
 }
@@ -0,0 +1,104 @@
+package config
+
+import (
+	"reflect"
+	"sync"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+var (
+	hotReloading    bool // invalid config aborts the hot reload instead of exiting the process, protected by configLock
+	reloadCallbacks = map[string][]func(){}
+	reloadLock      sync.Mutex
+)
+
+// configFatalf reports invalid config, the process exits if the config is read for the first time
+func configFatalf(format string, args ...interface{}) {
+	if hotReloading {
+		panic(errors.Errorf(format, args...))
+	}
+	gwlog.Fatalf(format, args...)
+}
+
+// OnReload registers the callback which is called when the config section is changed by HotReload
+//
+// Sections are deployment, storage, kvdb, debug, game, gate, dispatcher, bridge, webhook, log, admin, watchdog, features
+// and rbac, in which game, gate and dispatcher include the common section and sections of all components. Callbacks are
+// called in the goroutine calling HotReload after the new config takes effect, so callbacks of game logic should post to
+// the game routine.
+func OnReload(section string, cb func()) {
+	reloadLock.Lock()
+	reloadCallbacks[section] = append(reloadCallbacks[section], cb)
+	reloadLock.Unlock()
+}
+
+// HotReload re-reads the config file and calls reload callbacks of changed sections
+//
+// The current config is kept if the new config is invalid. Only settings applied by reload callbacks take effect at
+// runtime, other settings (e.g. listen addresses) take effect when processes are restarted.
+func HotReload() (changed []string, err error) {
+	configLock.Lock()
+	oldConfig := goWorldConfig
+	newConfig, err := readConfigForHotReload()
+	if err != nil {
+		configLock.Unlock()
+		return nil, err
+	}
+	goWorldConfig = newConfig
+	configLock.Unlock()
+
+	if oldConfig == nil {
+		return nil, nil
+	}
+
+	changed = getChangedSections(oldConfig, newConfig)
+	gwlog.Infof("config reloaded from %s, changed sections: %v", configFilePath, changed)
+	for _, section := range changed {
+		reloadLock.Lock()
+		callbacks := reloadCallbacks[section]
+		reloadLock.Unlock()
+		for _, cb := range callbacks {
+			cb()
+		}
+	}
+	return changed, nil
+}
+
+func readConfigForHotReload() (config *GoWorldConfig, err error) {
+	hotReloading = true
+	defer func() {
+		hotReloading = false
+		if r := recover(); r != nil {
+			err = errors.Errorf("invalid config: %v", r)
+		}
+	}()
+
+	return readGoWorldConfig(), nil
+}
+
+func getChangedSections(oldConfig, newConfig *GoWorldConfig) []string {
+	changed := []string{}
+	check := func(section string, oldSection, newSection interface{}) {
+		if !reflect.DeepEqual(oldSection, newSection) {
+			changed = append(changed, section)
+		}
+	}
+
+	check("deployment", oldConfig.Deployment, newConfig.Deployment)
+	check("storage", oldConfig.Storage, newConfig.Storage)
+	check("kvdb", oldConfig.KVDB, newConfig.KVDB)
+	check("debug", oldConfig.Debug, newConfig.Debug)
+	check("game", []interface{}{oldConfig.GameCommon, oldConfig._Games}, []interface{}{newConfig.GameCommon, newConfig._Games})
+	check("gate", []interface{}{oldConfig.GateCommon, oldConfig._Gates}, []interface{}{newConfig.GateCommon, newConfig._Gates})
+	check("dispatcher", []interface{}{oldConfig.DispatcherCommon, oldConfig._Dispatchers}, []interface{}{newConfig.DispatcherCommon, newConfig._Dispatchers})
+	check("bridge", oldConfig.Bridge, newConfig.Bridge)
+	check("webhook", oldConfig.Webhook, newConfig.Webhook)
+	check("log", oldConfig.Log, newConfig.Log)
+	check("admin", oldConfig.Admin, newConfig.Admin)
+	check("watchdog", oldConfig.Watchdog, newConfig.Watchdog)
+	check("features", oldConfig.Features, newConfig.Features)
+	check("rbac", oldConfig.RBAC, newConfig.RBAC)
+	return changed
+}
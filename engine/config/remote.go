@@ -0,0 +1,397 @@
+package config
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	_REMOTE_CONFIG_TIMEOUT     = time.Second * 10
+	_REMOTE_CONFIG_WATCH_WAIT  = time.Minute * 5 // max wait time of consul blocking queries
+	_REMOTE_CONFIG_RETRY_DELAY = time.Second * 5
+	_CONSUL_TOKEN_ENV          = "CONSUL_HTTP_TOKEN"
+	_CONSUL_CACERT_ENV         = "CONSUL_CACERT"
+	_CONSUL_CLIENT_CERT_ENV    = "CONSUL_CLIENT_CERT"
+	_CONSUL_CLIENT_KEY_ENV     = "CONSUL_CLIENT_KEY"
+	_ETCD_USER_ENV             = "ETCDCTL_USER" // user:password
+	_ETCD_CACERT_ENV           = "ETCDCTL_CACERT"
+	_ETCD_CERT_ENV             = "ETCDCTL_CERT"
+	_ETCD_KEY_ENV              = "ETCDCTL_KEY"
+)
+
+var (
+	remoteConfigClient  = &http.Client{Timeout: _REMOTE_CONFIG_TIMEOUT}
+	remoteConfigSchemes = []string{"etcd", "etcd+https", "consul", "consul+https"}
+	watchOnce           sync.Once
+)
+
+// _RemoteConfig is the key of remote config in etcd or consul
+type _RemoteConfig struct {
+	store     string // etcd or consul
+	baseURL   string // http://host:port or https://host:port
+	key       string
+	tlsConfig *tls.Config // nil if plain http is used
+}
+
+// newClient returns the HTTP client for requests to the store, no timeout if timeout is 0
+func (rc *_RemoteConfig) newClient(timeout time.Duration) *http.Client {
+	if rc.tlsConfig == nil && timeout == _REMOTE_CONFIG_TIMEOUT {
+		return remoteConfigClient
+	}
+	client := &http.Client{Timeout: timeout}
+	if rc.tlsConfig != nil {
+		client.Transport = &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: rc.tlsConfig,
+		}
+	}
+	return client
+}
+
+// isRemoteConfig returns if the config file is the key of etcd (etcd://host:port/key) or consul (consul://host:port/key)
+func isRemoteConfig(file string) bool {
+	for _, scheme := range remoteConfigSchemes {
+		if strings.HasPrefix(file, scheme+"://") {
+			return true
+		}
+	}
+	return false
+}
+
+// loadRemoteConfig loads the config from etcd or consul
+//
+// The config is the value of key in the etcd v3 (using the HTTP gateway of etcd 3.4+) or consul KV store, whose format is
+// detected by the extension of key, e.g. etcd://127.0.0.1:2379/goworld/goworld.yaml.
+//
+// Plain http is only allowed for loopback hosts, use etcd+https:// or consul+https:// for remote hosts. TLS and
+// credentials are read from environment variables in the same way as etcdctl and consul:
+// ETCDCTL_CACERT, ETCDCTL_CERT, ETCDCTL_KEY and ETCDCTL_USER (user:password) for etcd,
+// CONSUL_CACERT, CONSUL_CLIENT_CERT, CONSUL_CLIENT_KEY and CONSUL_HTTP_TOKEN (ACL token) for consul.
+func loadRemoteConfig(file string) ([]byte, error) {
+	rc, err := parseRemoteConfig(file)
+	if err != nil {
+		return nil, err
+	}
+
+	var data []byte
+	if rc.store == "etcd" {
+		data, _, err = getEtcdKey(rc)
+	} else {
+		data, _, err = getConsulKey(rc, 0)
+	}
+	if err != nil {
+		return nil, errors.Wrapf(err, "load remote config %s failed", file)
+	}
+	return data, nil
+}
+
+func parseRemoteConfig(file string) (*_RemoteConfig, error) {
+	u, err := url.Parse(file)
+	if err != nil {
+		return nil, err
+	}
+	key := strings.TrimPrefix(u.Path, "/")
+	if u.Host == "" || key == "" {
+		return nil, errors.Errorf("remote config should be %s://host:port/key, but is %s", u.Scheme, file)
+	}
+
+	rc := &_RemoteConfig{key: key}
+	secure := strings.HasSuffix(u.Scheme, "+https")
+	rc.store = strings.TrimSuffix(u.Scheme, "+https")
+	if !secure {
+		if !isLoopback(u.Hostname()) {
+			return nil, errors.Errorf("remote config %s is not on a loopback host, %s+https:// should be used", file, rc.store)
+		}
+		rc.baseURL = "http://" + u.Host
+	} else {
+		rc.baseURL = "https://" + u.Host
+		if rc.store == "etcd" {
+			rc.tlsConfig, err = newRemoteConfigTLSConfig(os.Getenv(_ETCD_CACERT_ENV), os.Getenv(_ETCD_CERT_ENV), os.Getenv(_ETCD_KEY_ENV))
+		} else {
+			rc.tlsConfig, err = newRemoteConfigTLSConfig(os.Getenv(_CONSUL_CACERT_ENV), os.Getenv(_CONSUL_CLIENT_CERT_ENV), os.Getenv(_CONSUL_CLIENT_KEY_ENV))
+		}
+		if err != nil {
+			return nil, errors.Wrapf(err, "remote config %s", file)
+		}
+	}
+	return rc, nil
+}
+
+// newRemoteConfigTLSConfig returns the TLS config verifying the store using the CA file (system CAs if empty), with the
+// client certificate if set
+func newRemoteConfigTLSConfig(caFile string, certFile string, keyFile string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{}
+	if caFile != "" {
+		caData, err := ioutil.ReadFile(caFile)
+		if err != nil {
+			return nil, err
+		}
+		rootCAs := x509.NewCertPool()
+		if !rootCAs.AppendCertsFromPEM(caData) {
+			return nil, errors.Errorf("no certificates found in %s", caFile)
+		}
+		tlsConfig.RootCAs = rootCAs
+	}
+
+	if (certFile == "") != (keyFile == "") {
+		return nil, errors.Errorf("client certificate and key should be set together")
+	}
+	if certFile != "" {
+		cert, err := tls.LoadX509KeyPair(certFile, keyFile)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// StartWatch watches the remote config in etcd or consul, and hot reloads the config when it is changed
+//
+// StartWatch does nothing if the config is read from local files.
+func StartWatch() {
+	file := GetConfigFilePath()
+	if !isRemoteConfig(file) {
+		return
+	}
+
+	watchOnce.Do(func() {
+		rc, err := parseRemoteConfig(file)
+		if err != nil {
+			gwlog.Errorf("watch remote config failed: %s", err)
+			return
+		}
+
+		gwlog.Infof("Watching remote config %s ...", file)
+		if rc.store == "etcd" {
+			go watchEtcdKey(rc)
+		} else {
+			go watchConsulKey(rc)
+		}
+	})
+}
+
+func reloadRemoteConfig() {
+	if _, err := HotReload(); err != nil {
+		gwlog.Errorf("hot reload remote config failed: %s", err)
+	}
+}
+
+// getConsulKey gets the value of key and its modify index from consul, the request blocks until the modify index
+// is larger than waitIndex if waitIndex is positive
+func getConsulKey(rc *_RemoteConfig, waitIndex uint64) ([]byte, uint64, error) {
+	query := url.Values{"raw": {""}}
+	timeout := _REMOTE_CONFIG_TIMEOUT
+	if waitIndex > 0 {
+		query.Set("index", strconv.FormatUint(waitIndex, 10))
+		query.Set("wait", strconv.Itoa(int(_REMOTE_CONFIG_WATCH_WAIT/time.Second))+"s")
+		timeout = _REMOTE_CONFIG_WATCH_WAIT + _REMOTE_CONFIG_TIMEOUT
+	}
+	client := rc.newClient(timeout)
+
+	req, err := http.NewRequest("GET", rc.baseURL+"/v1/kv/"+rc.key+"?"+query.Encode(), nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	if token := os.Getenv(_CONSUL_TOKEN_ENV); token != "" {
+		req.Header.Set("X-Consul-Token", token)
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer resp.Body.Close()
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, 0, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return nil, 0, errors.Errorf("consul responds %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+
+	index, _ := strconv.ParseUint(resp.Header.Get("X-Consul-Index"), 10, 64)
+	return data, index, nil
+}
+
+func watchConsulKey(rc *_RemoteConfig) {
+	var index uint64
+	for {
+		_, newIndex, err := getConsulKey(rc, index)
+		if err != nil {
+			gwlog.Errorf("watch consul key %s failed: %s", rc.key, err)
+			time.Sleep(_REMOTE_CONFIG_RETRY_DELAY)
+			continue
+		}
+
+		if index != 0 && newIndex != index {
+			reloadRemoteConfig()
+		}
+		if newIndex < index {
+			// the index is reset, see https://www.consul.io/api/features/blocking.html
+			newIndex = 0
+		}
+		index = newIndex
+	}
+}
+
+type _EtcdKeyValue struct {
+	Value string `json:"value"` // base64 encoded
+}
+
+type _EtcdRangeResponse struct {
+	Header struct {
+		Revision string `json:"revision"`
+	} `json:"header"`
+	Kvs []_EtcdKeyValue `json:"kvs"`
+}
+
+type _EtcdWatchResponse struct {
+	Result struct {
+		Events []struct{} `json:"events"`
+	} `json:"result"`
+	Error *struct {
+		Message string `json:"message"`
+	} `json:"error"`
+}
+
+type _EtcdAuthenticateResponse struct {
+	Token string `json:"token"`
+}
+
+// authenticateEtcd returns the auth token of the user set by ETCDCTL_USER, or empty string if auth is not used
+//
+// Auth tokens of etcd expire, so a new token is requested before each request.
+func authenticateEtcd(rc *_RemoteConfig) (string, error) {
+	user := os.Getenv(_ETCD_USER_ENV)
+	if user == "" {
+		return "", nil
+	}
+	name, password := user, ""
+	if i := strings.IndexByte(user, ':'); i >= 0 {
+		name, password = user[:i], user[i+1:]
+	}
+	var authResp _EtcdAuthenticateResponse
+	if err := postEtcd(rc.newClient(_REMOTE_CONFIG_TIMEOUT), rc.baseURL, "", "/v3/auth/authenticate", map[string]interface{}{
+		"name":     name,
+		"password": password,
+	}, func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&authResp)
+	}); err != nil {
+		return "", errors.Wrap(err, "etcd authenticate failed")
+	}
+	return authResp.Token, nil
+}
+
+// getEtcdKey gets the value of key and the revision of store from the HTTP gateway of etcd
+func getEtcdKey(rc *_RemoteConfig) ([]byte, int64, error) {
+	token, err := authenticateEtcd(rc)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	var rangeResp _EtcdRangeResponse
+	if err := postEtcd(rc.newClient(_REMOTE_CONFIG_TIMEOUT), rc.baseURL, token, "/v3/kv/range", map[string]interface{}{
+		"key": base64.StdEncoding.EncodeToString([]byte(rc.key)),
+	}, func(body io.Reader) error {
+		return json.NewDecoder(body).Decode(&rangeResp)
+	}); err != nil {
+		return nil, 0, err
+	}
+
+	if len(rangeResp.Kvs) == 0 {
+		return nil, 0, errors.Errorf("etcd key %s is not found", rc.key)
+	}
+	data, err := base64.StdEncoding.DecodeString(rangeResp.Kvs[0].Value)
+	if err != nil {
+		return nil, 0, err
+	}
+	revision, _ := strconv.ParseInt(rangeResp.Header.Revision, 10, 64)
+	return data, revision, nil
+}
+
+func watchEtcdKey(rc *_RemoteConfig) {
+	client := rc.newClient(0) // watch streams never end
+	_, revision, err := getEtcdKey(rc)
+	for {
+		// the store revision is fetched again after failures, so that the watch starts after the reloaded config
+		if err != nil {
+			gwlog.Errorf("watch etcd key %s failed: %s", rc.key, err)
+			time.Sleep(_REMOTE_CONFIG_RETRY_DELAY)
+			_, revision, err = getEtcdKey(rc)
+			if err == nil {
+				reloadRemoteConfig() // changes might be missed during the retry
+			}
+			continue
+		}
+
+		var token string
+		if token, err = authenticateEtcd(rc); err != nil {
+			continue
+		}
+		err = postEtcd(client, rc.baseURL, token, "/v3/watch", map[string]interface{}{
+			"create_request": map[string]interface{}{
+				"key":            base64.StdEncoding.EncodeToString([]byte(rc.key)),
+				"start_revision": strconv.FormatInt(revision+1, 10),
+			},
+		}, func(body io.Reader) error {
+			decoder := json.NewDecoder(body)
+			for {
+				var watchResp _EtcdWatchResponse
+				if err := decoder.Decode(&watchResp); err != nil {
+					return err
+				}
+				if watchResp.Error != nil {
+					return errors.New(watchResp.Error.Message)
+				}
+				if len(watchResp.Result.Events) > 0 {
+					reloadRemoteConfig()
+				}
+			}
+		})
+		if err == nil {
+			err = errors.New("watch stream closed")
+		}
+	}
+}
+
+// postEtcd posts the request to the HTTP gateway of etcd, with the auth token if it is not empty
+func postEtcd(client *http.Client, baseURL string, token string, path string, request interface{}, readResponse func(body io.Reader) error) error {
+	reqData, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", baseURL+path, bytes.NewReader(reqData))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	if token != "" {
+		req.Header.Set("Authorization", token)
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		data, _ := ioutil.ReadAll(resp.Body)
+		return errors.Errorf("etcd responds %s: %s", resp.Status, bytes.TrimSpace(data))
+	}
+	return readResponse(resp.Body)
+}
@@ -0,0 +1,171 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+)
+
+const (
+	_VAULT_ADDR_ENV  = "VAULT_ADDR"
+	_VAULT_TOKEN_ENV = "VAULT_TOKEN"
+)
+
+// SecretProvider resolves the secret reference, e.g. the provider of ${vault:secret/goworld:db_password} resolves
+// secret/goworld:db_password
+type SecretProvider func(ref string) (string, error)
+
+var (
+	secretPattern   = regexp.MustCompile(`\$\{(\w+):([^}]*)\}`)
+	secretProviders = map[string]SecretProvider{
+		"env":   resolveEnvSecret,
+		"file":  resolveFileSecret,
+		"vault": resolveVaultSecret,
+	}
+	secretProvidersLock sync.RWMutex
+	resolvedSecrets     = map[string]struct{}{} // resolved secrets are masked when dumping configs, protected by secretProvidersLock
+)
+
+// RegisterSecretProvider registers the provider resolving secret references of ${<name>:<ref>} in config values
+//
+// Built-in providers are env (environment variables), file (content of files) and vault (HashiCorp Vault). It should be
+// called before the config is read.
+func RegisterSecretProvider(name string, provider SecretProvider) {
+	secretProvidersLock.Lock()
+	secretProviders[name] = provider
+	secretProvidersLock.Unlock()
+}
+
+// resolveSecrets replaces secret references in all config values with secrets resolved by providers
+func resolveSecrets(iniFile *ini.File) {
+	for _, sec := range iniFile.Sections() {
+		for _, key := range sec.Keys() {
+			value := key.Value()
+			if !strings.Contains(value, "${") {
+				continue
+			}
+
+			var resolveErr error
+			value = secretPattern.ReplaceAllStringFunc(value, func(ref string) string {
+				m := secretPattern.FindStringSubmatch(ref)
+				secret, err := resolveSecret(m[1], m[2])
+				if err != nil && resolveErr == nil {
+					resolveErr = err
+				}
+				return secret
+			})
+			if resolveErr != nil {
+				// secrets are never logged
+				configFatalf("[%s].%s: resolve secret failed: %s", sec.Name(), key.Name(), resolveErr)
+			}
+			key.SetValue(value)
+		}
+	}
+}
+
+func resolveSecret(providerName string, ref string) (string, error) {
+	secretProvidersLock.RLock()
+	provider := secretProviders[providerName]
+	secretProvidersLock.RUnlock()
+	if provider == nil {
+		return "", errors.Errorf("unknown secret provider: %s", providerName)
+	}
+
+	secret, err := provider(ref)
+	if err != nil {
+		return "", errors.Wrapf(err, "${%s:%s}", providerName, ref)
+	}
+	if secret != "" {
+		secretProvidersLock.Lock()
+		resolvedSecrets[secret] = struct{}{}
+		secretProvidersLock.Unlock()
+	}
+	return secret, nil
+}
+
+// maskSecrets replaces resolved secrets in s with ******, so that secrets are not logged
+func maskSecrets(s string) string {
+	secretProvidersLock.RLock()
+	defer secretProvidersLock.RUnlock()
+	for secret := range resolvedSecrets {
+		s = strings.Replace(s, secret, "******", -1)
+	}
+	return s
+}
+
+func resolveEnvSecret(name string) (string, error) {
+	secret, ok := os.LookupEnv(name)
+	if !ok {
+		return "", errors.Errorf("environment variable %s is not set", name)
+	}
+	return secret, nil
+}
+
+// resolveFileSecret reads the secret from the file (e.g. mounted Kubernetes secrets), relative paths are relative to
+// the config directory
+func resolveFileSecret(file string) (string, error) {
+	if !path.IsAbs(file) {
+		file = path.Join(GetConfigDir(), file)
+	}
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return string(bytes.TrimRight(data, "\r\n")), nil
+}
+
+// resolveVaultSecret reads the field of secret from HashiCorp Vault at $VAULT_ADDR using $VAULT_TOKEN
+//
+// The reference is <path>:<field>, e.g. secret/data/goworld:db_password for KV version 2 or secret/goworld:db_password
+// for KV version 1. The field is not separated by # since # starts comments in INI files.
+func resolveVaultSecret(ref string) (string, error) {
+	colon := strings.LastIndex(ref, ":")
+	if colon <= 0 || colon == len(ref)-1 {
+		return "", errors.Errorf("vault secret should be <path>:<field>")
+	}
+	secretPath, field := strings.TrimPrefix(ref[:colon], "/"), ref[colon+1:]
+
+	addr := os.Getenv(_VAULT_ADDR_ENV)
+	if addr == "" {
+		return "", errors.Errorf("environment variable %s is not set", _VAULT_ADDR_ENV)
+	}
+	req, err := http.NewRequest("GET", strings.TrimRight(addr, "/")+"/v1/"+secretPath, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("X-Vault-Token", os.Getenv(_VAULT_TOKEN_ENV))
+
+	resp, err := remoteConfigClient.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", errors.Errorf("vault responds %s", resp.Status)
+	}
+
+	var secretResp struct {
+		Data map[string]interface{} `json:"data"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&secretResp); err != nil {
+		return "", err
+	}
+	data := secretResp.Data
+	if nested, ok := data["data"].(map[string]interface{}); ok && data["metadata"] != nil {
+		data = nested // KV version 2
+	}
+	secret, ok := data[field].(string)
+	if !ok {
+		return "", errors.Errorf("field %s is not found", field)
+	}
+	return secret, nil
+}
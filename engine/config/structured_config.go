@@ -0,0 +1,149 @@
+package config
+
+import (
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+	"github.com/go-ini/ini"
+	"github.com/pkg/errors"
+	"gopkg.in/yaml.v2"
+)
+
+// loadConfigFile loads the config file (or remote config, see loadRemoteConfig) as INI sections, YAML (.yaml, .yml)
+// and TOML (.toml) files are converted to INI sections of the same schema, so that all config files are read and
+// validated in the same way
+//
+// In YAML and TOML files:
+//   - top level keys are section names, e.g. deployment, game_common, game1
+//   - game, gate and dispatcher can be nested sections: common is converted to game_common, 1 to game1, etc.
+//   - nested keys in sections are joined by "_", e.g. heap: {warn_mb: 1024} in watchdog is converted to heap_warn_mb
+//   - lists are joined by ",", e.g. allow_ips: [10.0.0.0/8, 192.168.0.0/16]
+func loadConfigFile(file string) (*ini.File, error) {
+	var content []byte
+	var err error
+	if isRemoteConfig(file) {
+		content, err = loadRemoteConfig(file)
+	} else {
+		content, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var data map[string]interface{}
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".yaml", ".yml":
+		if err := yaml.Unmarshal(content, &data); err != nil {
+			return nil, errors.Wrapf(err, "parse YAML config %s failed", file)
+		}
+	case ".toml":
+		if _, err := toml.Decode(string(content), &data); err != nil {
+			return nil, errors.Wrapf(err, "parse TOML config %s failed", file)
+		}
+	default:
+		return ini.Load(content)
+	}
+
+	return convertToINI(data)
+}
+
+func convertToINI(data map[string]interface{}) (*ini.File, error) {
+	iniFile := ini.Empty()
+	for _, name := range sortedKeys(data) {
+		values, ok := toStringMap(data[name])
+		if !ok {
+			return nil, errors.Errorf("section %s should be a table, but is %v", name, data[name])
+		}
+
+		lowerName := strings.ToLower(name)
+		if lowerName == "game" || lowerName == "gate" || lowerName == "dispatcher" {
+			// nested sections of components
+			for _, id := range sortedKeys(values) {
+				subValues, ok := toStringMap(values[id])
+				if !ok {
+					return nil, errors.Errorf("section %s.%s should be a table, but is %v", name, id, values[id])
+				}
+				secName := lowerName + id
+				if strings.ToLower(id) == "common" {
+					secName = lowerName + "_common"
+				}
+				if err := addINISection(iniFile, secName, subValues); err != nil {
+					return nil, err
+				}
+			}
+		} else if err := addINISection(iniFile, name, values); err != nil {
+			return nil, err
+		}
+	}
+	return iniFile, nil
+}
+
+func addINISection(iniFile *ini.File, name string, values map[string]interface{}) error {
+	if _, err := iniFile.GetSection(name); err == nil {
+		return errors.Errorf("duplicate section: %s", name)
+	}
+
+	sec, err := iniFile.NewSection(name)
+	if err != nil {
+		return err
+	}
+	return addINIKeys(sec, "", values)
+}
+
+func addINIKeys(sec *ini.Section, prefix string, values map[string]interface{}) error {
+	for _, name := range sortedKeys(values) {
+		keyName := prefix + name
+		val := values[name]
+		if subValues, ok := toStringMap(val); ok {
+			if err := addINIKeys(sec, keyName+"_", subValues); err != nil {
+				return err
+			}
+			continue
+		}
+
+		var s string // nil values are empty
+		if list, ok := val.([]interface{}); ok {
+			items := make([]string, len(list))
+			for i, item := range list {
+				items[i] = fmt.Sprint(item)
+			}
+			s = strings.Join(items, ",")
+		} else if val != nil {
+			s = fmt.Sprint(val)
+		}
+
+		if _, err := sec.NewKey(keyName, s); err != nil {
+			return errors.Wrapf(err, "section %s", sec.Name())
+		}
+	}
+	return nil
+}
+
+// toStringMap converts tables of YAML (map[interface{}]interface{}) and TOML (map[string]interface{}) to map[string]interface{}
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch m := v.(type) {
+	case map[string]interface{}:
+		return m, true
+	case map[interface{}]interface{}:
+		sm := make(map[string]interface{}, len(m))
+		for k, v := range m {
+			sm[fmt.Sprint(k)] = v
+		}
+		return sm, true
+	default:
+		return nil, false
+	}
+}
+
+func sortedKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
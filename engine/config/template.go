@@ -0,0 +1,69 @@
+package config
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/go-ini/ini"
+)
+
+const (
+	_TEMPLATE_KEY = "template"
+)
+
+var (
+	templateSectionPattern = regexp.MustCompile(`^(game|gate|dispatcher)\s*:\s*(\S+)$`)
+)
+
+// expandTemplates copies keys of template sections to sections inheriting them, and removes template sections
+//
+// Template sections are named [game:<name>], [gate:<name>] or [dispatcher:<name>]. Sections of components (e.g.
+// [game12]) inherit the template of the same kind by key template=<name>, and keys in the section override keys in
+// the template. Templates can also inherit other templates by key template. Keys not set in the section or its
+// templates are still inherited from the common section, e.g. [game_common].
+func expandTemplates(iniFile *ini.File) {
+	templates := map[string]*ini.Section{} // kind:name -> template section
+	for _, sec := range iniFile.Sections() {
+		if m := templateSectionPattern.FindStringSubmatch(strings.ToLower(sec.Name())); m != nil {
+			templates[m[1]+":"+m[2]] = sec
+		}
+	}
+
+	for _, sec := range iniFile.Sections() {
+		secName := strings.ToLower(sec.Name())
+		if _, isTemplate := templates[secName]; isTemplate || !sec.HasKey(_TEMPLATE_KEY) {
+			continue
+		}
+
+		kind := strings.TrimRight(secName, "0123456789")
+		if kind != "game" && kind != "gate" && kind != "dispatcher" {
+			configFatalf("[%s].%s is not supported, only game, gate and dispatcher sections can inherit templates", sec.Name(), _TEMPLATE_KEY)
+		}
+
+		visited := map[*ini.Section]bool{}
+		for parent := sec; parent.HasKey(_TEMPLATE_KEY); {
+			templateName := kind + ":" + strings.ToLower(parent.Key(_TEMPLATE_KEY).String())
+			tmpl := templates[templateName]
+			if tmpl == nil {
+				configFatalf("[%s].%s is %s, but template section [%s] is not found", parent.Name(), _TEMPLATE_KEY, parent.Key(_TEMPLATE_KEY).String(), templateName)
+			}
+			if visited[tmpl] {
+				configFatalf("[%s].%s: template inheritance of [%s] is circular", parent.Name(), _TEMPLATE_KEY, sec.Name())
+			}
+			visited[tmpl] = true
+
+			for _, key := range tmpl.Keys() {
+				if !sec.HasKey(key.Name()) {
+					_, err := sec.NewKey(key.Name(), key.Value())
+					checkConfigError(err, "")
+				}
+			}
+			parent = tmpl
+		}
+		sec.DeleteKey(_TEMPLATE_KEY)
+	}
+
+	for name := range templates {
+		iniFile.DeleteSection(templates[name].Name())
+	}
+}
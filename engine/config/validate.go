@@ -0,0 +1,194 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/go-ini/ini"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// mustBool returns the bool value of key, or def if the value is empty, the process exits if the value is invalid
+func mustBool(sec *ini.Section, key *ini.Key, def bool) bool {
+	if key.String() == "" {
+		return def
+	}
+	v, err := key.Bool()
+	if err != nil {
+		invalidKeyType(sec, key, "a bool (true/false/1/0)")
+	}
+	return v
+}
+
+// mustInt returns the int value of key, or def if the value is empty, the process exits if the value is invalid
+func mustInt(sec *ini.Section, key *ini.Key, def int) int {
+	if key.String() == "" {
+		return def
+	}
+	v, err := key.Int()
+	if err != nil {
+		invalidKeyType(sec, key, "an integer")
+	}
+	return v
+}
+
+// mustInt64 returns the int64 value of key, or def if the value is empty, the process exits if the value is invalid
+func mustInt64(sec *ini.Section, key *ini.Key, def int64) int64 {
+	if key.String() == "" {
+		return def
+	}
+	v, err := key.Int64()
+	if err != nil {
+		invalidKeyType(sec, key, "an integer")
+	}
+	return v
+}
+
+// mustUint64 returns the uint64 value of key, or def if the value is empty, the process exits if the value is invalid
+func mustUint64(sec *ini.Section, key *ini.Key, def uint64) uint64 {
+	if key.String() == "" {
+		return def
+	}
+	v, err := key.Uint64()
+	if err != nil {
+		invalidKeyType(sec, key, "a non-negative integer")
+	}
+	return v
+}
+
+func invalidKeyType(sec *ini.Section, key *ini.Key, expected string) {
+	configFatalf("[%s].%s should be %s, but is %q", sec.Name(), key.Name(), expected, key.String())
+}
+
+// _ListenAddr is the address listened by the component, for validating addresses and detecting port conflicts
+type _ListenAddr struct {
+	component string // e.g. game1
+	section   string // the section of the address, e.g. game1 or game_common
+	key       string
+	host      string
+	port      int
+}
+
+func (la *_ListenAddr) String() string {
+	return fmt.Sprintf("[%s].%s (%s) = %s", la.section, la.key, la.component, net.JoinHostPort(la.host, strconv.Itoa(la.port)))
+}
+
+// validateAddrs validates addresses of all components in deployment, and reports port conflicts
+//
+// Addresses of different components conflict if they have the same port and the same host, except loopback and
+// wildcard hosts which only conflict if components run on the same machine, so warnings are logged for them.
+func validateAddrs(config *GoWorldConfig) {
+	var addrs []*_ListenAddr
+	add := func(component string, section string, key string, addr string) {
+		if addr == "" {
+			return
+		}
+		host, portStr, err := net.SplitHostPort(addr)
+		port, portErr := strconv.Atoi(portStr)
+		if err != nil || portErr != nil || port < 0 || port > 65535 {
+			configFatalf("[%s].%s should be an address of host:port, but is %q", section, key, addr)
+		}
+		addrs = append(addrs, &_ListenAddr{component, section, key, host, port})
+		if key == "admin_addr" && !isLoopback(host) && config.Admin.CertFile == "" {
+			// admin tokens should never be sent in plain text over the network
+			configFatalf("[%s].admin_addr %s is not a loopback address, but [admin].cert_file is not set", section, addr)
+		}
+	}
+	sectionOf := func(kind string, id uint16, hasSection bool) string {
+		if hasSection {
+			return fmt.Sprintf("%s%d", kind, id)
+		}
+		return kind + "_common"
+	}
+
+	advertiseAddrs := map[string]string{}
+	for dispid := uint16(1); int(dispid) <= config.Deployment.DesiredDispatchers; dispid++ {
+		dc := config._Dispatchers[dispid]
+		component := fmt.Sprintf("dispatcher%d", dispid)
+		section := sectionOf("dispatcher", dispid, dc != &config.DispatcherCommon)
+		add(component, section, "listen_addr", dc.ListenAddr)
+		add(component, section, "http_addr", dc.HTTPAddr)
+		add(component, section, "admin_addr", dc.AdminAddr)
+		if other, ok := advertiseAddrs[dc.AdvertiseAddr]; ok {
+			configFatalf("[%s].advertise_addr of %s is %s, which is the same as %s", section, component, dc.AdvertiseAddr, other)
+		}
+		advertiseAddrs[dc.AdvertiseAddr] = component
+	}
+	for gameid := uint16(1); int(gameid) <= config.Deployment.DesiredGames; gameid++ {
+		gc, ok := config._Games[gameid]
+		if !ok {
+			gc = &config.GameCommon
+		}
+		component := fmt.Sprintf("game%d", gameid)
+		section := sectionOf("game", gameid, ok)
+		add(component, section, "http_addr", gc.HTTPAddr)
+		add(component, section, "admin_addr", gc.AdminAddr)
+		add(component, section, "grpc_addr", gc.GRPCAddr)
+	}
+	for gateid := uint16(1); int(gateid) <= config.Deployment.DesiredGates; gateid++ {
+		gc, ok := config._Gates[gateid]
+		if !ok {
+			gc = &config.GateCommon
+		}
+		component := fmt.Sprintf("gate%d", gateid)
+		section := sectionOf("gate", gateid, ok)
+		add(component, section, "listen_addr", gc.ListenAddr)
+		add(component, section, "http_addr", gc.HTTPAddr)
+		add(component, section, "admin_addr", gc.AdminAddr)
+		if config.Deployment.DesiredGates > 1 && gc.AdvertiseAddr == "" {
+			// clients are redirected to the gate at listen_addr if advertise_addr is not set
+			if host, _, err := net.SplitHostPort(gc.ListenAddr); err == nil && isWildcard(host) {
+				configFatalf("[%s].listen_addr %s of %s is a wildcard address, advertise_addr should be set for redirecting clients", section, gc.ListenAddr, component)
+			}
+		}
+	}
+
+	for i, a := range addrs {
+		for _, b := range addrs[:i] {
+			if a.port == 0 || a.port != b.port || !hostsOverlap(a.host, b.host) {
+				continue
+			}
+			if a.component == b.component || (a.host == b.host && !isLoopbackOrWildcard(a.host)) {
+				configFatalf("port conflict: %s and %s", b, a)
+			}
+			gwlog.Warnf("port conflict if running on the same machine: %s and %s", b, a)
+		}
+	}
+}
+
+func hostsOverlap(h1, h2 string) bool {
+	return h1 == h2 || isWildcard(h1) || isWildcard(h2)
+}
+
+func isWildcard(host string) bool {
+	return host == "" || host == "0.0.0.0" || host == "::"
+}
+
+func isLoopbackOrWildcard(host string) bool {
+	return isWildcard(host) || isLoopback(host)
+}
+
+func isLoopback(host string) bool {
+	if strings.ToLower(host) == "localhost" {
+		return true
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+// isLoopbackAddr returns if the listen address only accepts connections from the same machine
+func isLoopbackAddr(addr string) bool {
+	host, _, err := net.SplitHostPort(addr)
+	return err == nil && isLoopback(host)
+}
+
+func hasNonEmptyString(ss []string) bool {
+	for _, s := range ss {
+		if strings.TrimSpace(s) != "" {
+			return true
+		}
+	}
+	return false
+}
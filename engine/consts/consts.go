@@ -64,6 +64,10 @@ const (
 	// CLIENT_PROXY_SET_TCP_NO_DELAY = true sets client proxies to TcpNoDelay
 	CLIENT_PROXY_SET_TCP_NO_DELAY     = true
 	CLIENT_PROXY_WRITE_FLUSH_INTERVAL = time.Millisecond * 5
+	// PROXY_PROTOCOL_HEADER_TIMEOUT is the timeout for receiving PROXY protocol header from load balancers
+	PROXY_PROTOCOL_HEADER_TIMEOUT = time.Second * 5
+	// CLIENT_REDIRECT_CLOSE_TIMEOUT is the timeout for redirected clients to reconnect to the target gate before closed
+	CLIENT_REDIRECT_CLOSE_TIMEOUT = time.Second * 10
 
 	//SAVE_INTERVAL      = time.Minute * 5 // Save interval of entities
 
@@ -75,6 +79,8 @@ const (
 	DISPATCHER_LOAD_TIMEOUT = time.Minute
 	// DISPATCHER_FREEZE_GAME_TIMEOUT is timeout for freezing & restoring game
 	DISPATCHER_FREEZE_GAME_TIMEOUT = time.Second * 10
+	// DISPATCHER_MOVE_GAME_TIMEOUT is timeout for freezing & restoring game on another host
+	DISPATCHER_MOVE_GAME_TIMEOUT = time.Minute * 10
 	// For Storage
 	// For Operation Monitor
 	// OPMON_DUMP_INTERVAL is the interval to print opmon infos to output
@@ -105,6 +111,9 @@ const (
 	DEBUG_PACKET_ALLOC = false
 	// DEBUG_FILTER_PROP prints filter props debug logs
 	DEBUG_FILTER_PROP = false
+	// DEBUG_GAME_ROUTINE panics if goworld APIs are called outside of the game routine, which gets the goroutine ID by
+	// parsing the stack on each call
+	DEBUG_GAME_ROUTINE = false
 )
 
 //  System level configurations
@@ -0,0 +1,69 @@
+package crontab
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwtimer"
+)
+
+// Cron is the handle of the callback scheduled by the cron expression
+type Cron struct {
+	schedule Schedule
+	cb       func()
+	next     time.Time
+	timer    *gwtimer.Timer
+}
+
+// AddCron adds the callback which is called in the game routine at times matching the cron expression
+//
+// See ParseSchedule for the syntax of spec, e.g. "0 5 * * *" for 5:00 every day in local time zone, or
+// "CRON_TZ=Asia/Shanghai 0 5 * * *" for 5:00 every day in time zone Asia/Shanghai.
+func AddCron(spec string, cb func()) (*Cron, error) {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Cron{schedule: schedule, cb: cb}
+	c.scheduleNext(time.Now())
+	if c.next.IsZero() {
+		return nil, errors.Errorf("cron %s never matches", spec)
+	}
+	return c, nil
+}
+
+// Next returns the next time of calling the callback, or zero time if the cron is cancelled or never matches
+func (c *Cron) Next() time.Time {
+	return c.next
+}
+
+// Cancel cancels the cron, the callback is not called after Cancel returns
+func (c *Cron) Cancel() {
+	if c.timer != nil {
+		c.timer.Cancel()
+		c.timer = nil
+	}
+	c.next = time.Time{}
+}
+
+func (c *Cron) scheduleNext(now time.Time) {
+	c.next = c.schedule.Next(now)
+	if c.next.IsZero() {
+		c.timer = nil
+		return
+	}
+	c.timer = gwtimer.AddCallback(c.next.Sub(now), c.fire)
+}
+
+func (c *Cron) fire() {
+	now := time.Now()
+	if now.Before(c.next) {
+		// the timer might fire early if the wall clock is adjusted
+		c.timer = gwtimer.AddCallback(c.next.Sub(now), c.fire)
+		return
+	}
+
+	c.scheduleNext(now) // matched times missed while the game routine is blocked are skipped
+	c.cb()
+}
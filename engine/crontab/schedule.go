@@ -0,0 +1,225 @@
+package crontab
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+// Schedule decides when cron jobs run
+type Schedule interface {
+	// Next returns the next time of running after t
+	Next(t time.Time) time.Time
+}
+
+type fieldBounds struct {
+	min, max int
+}
+
+var (
+	minuteBounds = fieldBounds{0, 59}
+	hourBounds   = fieldBounds{0, 23}
+	domBounds    = fieldBounds{1, 31}
+	monthBounds  = fieldBounds{1, 12}
+	dowBounds    = fieldBounds{0, 7} // both 0 and 7 are Sunday
+
+	descriptors = map[string]string{
+		"@yearly":   "0 0 1 1 *",
+		"@annually": "0 0 1 1 *",
+		"@monthly":  "0 0 1 * *",
+		"@weekly":   "0 0 * * 0",
+		"@daily":    "0 0 * * *",
+		"@midnight": "0 0 * * *",
+		"@hourly":   "0 * * * *",
+	}
+)
+
+// cronSchedule is the schedule of cron expression: minute hour day-of-month month day-of-week
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64 // bit sets of matched values
+	domStar, dowStar              bool
+}
+
+// everySchedule runs jobs at fixed intervals
+type everySchedule struct {
+	interval time.Duration
+}
+
+// zonedSchedule matches the schedule in the time zone
+type zonedSchedule struct {
+	Schedule
+	location *time.Location
+}
+
+// ParseSchedule parses the cron expression with 5 fields: minute hour day-of-month month day-of-week
+//
+// Each field supports *, values, ranges (1-5), lists (1,3,5) and steps (*/15, 0-30/10).
+// Descriptors @yearly, @monthly, @weekly, @daily, @hourly and @every <duration> are also supported.
+// The time zone of the schedule can be specified by prefix CRON_TZ=<zone>, e.g. "CRON_TZ=Asia/Shanghai 0 5 * * *",
+// otherwise the schedule matches times in the location of times passed to Next.
+func ParseSchedule(spec string) (Schedule, error) {
+	spec = strings.TrimSpace(spec)
+	if strings.HasPrefix(spec, "CRON_TZ=") {
+		fields := strings.SplitN(spec, " ", 2)
+		location, err := time.LoadLocation(fields[0][len("CRON_TZ="):])
+		if err != nil || len(fields) < 2 {
+			return nil, errors.Errorf("invalid schedule %s: invalid time zone", spec)
+		}
+		s, err := ParseSchedule(fields[1])
+		if err != nil {
+			return nil, err
+		}
+		return &zonedSchedule{s, location}, nil
+	}
+
+	if strings.HasPrefix(spec, "@every ") {
+		interval, err := time.ParseDuration(strings.TrimSpace(spec[len("@every "):]))
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid schedule %s", spec)
+		}
+		if interval < time.Second {
+			return nil, errors.Errorf("invalid schedule %s: interval is less than 1 second", spec)
+		}
+		return &everySchedule{interval: interval}, nil
+	}
+
+	if expr, ok := descriptors[spec]; ok {
+		spec = expr
+	}
+
+	fields := strings.Fields(spec)
+	if len(fields) != 5 {
+		return nil, errors.Errorf("invalid schedule %s: expect 5 fields, but got %d", spec, len(fields))
+	}
+
+	var s cronSchedule
+	var err error
+	if s.minute, err = parseField(fields[0], minuteBounds); err != nil {
+		return nil, errors.Wrapf(err, "invalid minute of schedule %s", spec)
+	}
+	if s.hour, err = parseField(fields[1], hourBounds); err != nil {
+		return nil, errors.Wrapf(err, "invalid hour of schedule %s", spec)
+	}
+	if s.dom, err = parseField(fields[2], domBounds); err != nil {
+		return nil, errors.Wrapf(err, "invalid day of month of schedule %s", spec)
+	}
+	if s.month, err = parseField(fields[3], monthBounds); err != nil {
+		return nil, errors.Wrapf(err, "invalid month of schedule %s", spec)
+	}
+	if s.dow, err = parseField(fields[4], dowBounds); err != nil {
+		return nil, errors.Wrapf(err, "invalid day of week of schedule %s", spec)
+	}
+	if hasBit(s.dow, 7) {
+		s.dow |= 1
+	}
+	s.domStar = fields[2] == "*"
+	s.dowStar = fields[4] == "*"
+	return &s, nil
+}
+
+// parseField parses comma separated ranges of the field into bit set
+func parseField(field string, bounds fieldBounds) (uint64, error) {
+	var bits uint64
+	for _, r := range strings.Split(field, ",") {
+		rangeBits, err := parseRange(r, bounds)
+		if err != nil {
+			return 0, err
+		}
+		bits |= rangeBits
+	}
+	return bits, nil
+}
+
+// parseRange parses range of the field: *, N, N-M, */S, N-M/S
+func parseRange(r string, bounds fieldBounds) (uint64, error) {
+	step := 1
+	if i := strings.Index(r, "/"); i >= 0 {
+		var err error
+		if step, err = strconv.Atoi(r[i+1:]); err != nil || step <= 0 {
+			return 0, errors.Errorf("invalid step %s", r)
+		}
+		r = r[:i]
+	}
+
+	var start, end int
+	if r == "*" {
+		start, end = bounds.min, bounds.max
+	} else {
+		var err error
+		parts := strings.SplitN(r, "-", 2)
+		if start, err = strconv.Atoi(parts[0]); err != nil {
+			return 0, errors.Errorf("invalid value %s", r)
+		}
+		end = start
+		if len(parts) == 2 {
+			if end, err = strconv.Atoi(parts[1]); err != nil {
+				return 0, errors.Errorf("invalid value %s", r)
+			}
+		} else if step > 1 {
+			end = bounds.max // N/S means from N to max by step S
+		}
+	}
+
+	if start < bounds.min || end > bounds.max || start > end {
+		return 0, errors.Errorf("value %s out of range [%d, %d]", r, bounds.min, bounds.max)
+	}
+
+	var bits uint64
+	for i := start; i <= end; i += step {
+		bits |= 1 << uint(i)
+	}
+	return bits, nil
+}
+
+func hasBit(bits uint64, i int) bool {
+	return bits&(1<<uint(i)) != 0
+}
+
+// Next returns the next time matching the schedule after t, or zero time if not found in 5 years
+func (s *cronSchedule) Next(t time.Time) time.Time {
+	t = t.Add(time.Minute - time.Duration(t.Second())*time.Second - time.Duration(t.Nanosecond()))
+	yearLimit := t.Year() + 5
+
+	for t.Year() <= yearLimit {
+		if !hasBit(s.month, int(t.Month())) {
+			t = time.Date(t.Year(), t.Month()+1, 1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !s.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if !hasBit(s.hour, t.Hour()) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !hasBit(s.minute, t.Minute()) {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// dayMatches checks day of month and day of week like cron: either of them matches if both are restricted
+func (s *cronSchedule) dayMatches(t time.Time) bool {
+	domMatch := hasBit(s.dom, t.Day())
+	dowMatch := hasBit(s.dow, int(t.Weekday()))
+	if s.domStar || s.dowStar {
+		return domMatch && dowMatch
+	}
+	return domMatch || dowMatch
+}
+
+// Next returns the next time after t aligned to the interval
+func (s *everySchedule) Next(t time.Time) time.Time {
+	return t.Truncate(s.interval).Add(s.interval)
+}
+
+// Next returns the next time matching the schedule in the time zone after t
+func (s *zonedSchedule) Next(t time.Time) time.Time {
+	return s.Schedule.Next(t.In(s.location))
+}
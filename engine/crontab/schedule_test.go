@@ -0,0 +1,60 @@
+package crontab
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseSchedule(t *testing.T) {
+	shanghai, err := time.LoadLocation("Asia/Shanghai")
+	if err != nil {
+		t.Skipf("time zone database is not available: %s", err)
+	}
+
+	now := time.Date(2020, 1, 31, 10, 30, 15, 0, time.UTC) // Friday
+	for _, c := range []struct {
+		spec string
+		next time.Time
+	}{
+		{"* * * * *", time.Date(2020, 1, 31, 10, 31, 0, 0, time.UTC)},
+		{"*/15 * * * *", time.Date(2020, 1, 31, 10, 45, 0, 0, time.UTC)},
+		{"0 5 * * *", time.Date(2020, 2, 1, 5, 0, 0, 0, time.UTC)},
+		{"0 0 * * 1-5", time.Date(2020, 2, 3, 0, 0, 0, 0, time.UTC)},
+		{"0 0 29 2 *", time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC)},
+		{"@hourly", time.Date(2020, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"@every 1h", time.Date(2020, 1, 31, 11, 0, 0, 0, time.UTC)},
+		{"CRON_TZ=Asia/Shanghai 0 5 * * *", time.Date(2020, 2, 1, 5, 0, 0, 0, shanghai)},
+	} {
+		schedule, err := ParseSchedule(c.spec)
+		if err != nil {
+			t.Fatalf("parse %s failed: %s", c.spec, err)
+		}
+		if next := schedule.Next(now); !next.Equal(c.next) {
+			t.Errorf("next time of %s should be %s, but is %s", c.spec, c.next, next)
+		}
+	}
+
+	for _, spec := range []string{"", "* * * *", "60 * * * *", "* * 0 * *", "*/0 * * * *", "@every 1ms", "CRON_TZ=Unknown/Zone 0 5 * * *", "CRON_TZ=UTC"} {
+		if _, err := ParseSchedule(spec); err == nil {
+			t.Errorf("parse %q should fail", spec)
+		}
+	}
+}
+
+func TestAddCron(t *testing.T) {
+	if _, err := AddCron("0 0 30 2 *", func() {}); err == nil {
+		t.Errorf("cron never matching should fail")
+	}
+
+	c, err := AddCron("* * * * *", func() {})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if next := c.Next(); next.Second() != 0 || !next.After(time.Now()) || next.After(time.Now().Add(time.Minute)) {
+		t.Errorf("wrong next time %s", next)
+	}
+	c.Cancel()
+	if !c.Next().IsZero() {
+		t.Errorf("cancelled cron should have no next time")
+	}
+}
@@ -37,9 +37,19 @@ func newDispatcherClient(dctype DispatcherClientType, conn net.Conn, isReconnect
 		isRestoreGame:     isRestoreGame,
 	}
 	dc.SetAutoFlush(consts.DISPATCHER_CLIENT_FLUSH_INTERVAL)
+	dc.EnableFaultInjection()
 	return dc
 }
 
+// newLocalDispatcherClient creates the dispatcher client writing to the in-process dispatcher, packets are not flushed
+// automatically, so that the dispatcher receives packets only when the client is flushed
+func newLocalDispatcherClient(dctype DispatcherClientType, conn net.Conn) *DispatcherClient {
+	return &DispatcherClient{
+		GoWorldConnection: proto.NewGoWorldConnection(netutil.NetConnection{Conn: conn}, false, ""),
+		dctype:            dctype,
+	}
+}
+
 // Close the dispatcher client
 func (dc *DispatcherClient) Close() error {
 	return dc.GoWorldConnection.Close()
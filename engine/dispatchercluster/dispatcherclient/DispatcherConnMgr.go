@@ -36,6 +36,7 @@ type DispatcherConnMgr struct {
 
 var (
 	errDispatcherNotConnected = errors.New("dispatcher not connected")
+	logger                    = gwlog.Module("dispatcherclient")
 )
 
 func NewDispatcherConnMgr(gid uint16, dctype DispatcherClientType, dispid uint16, isRestoreGame, isBanBootEntity bool, delegate IDispatcherClientDelegate) *DispatcherConnMgr {
@@ -49,6 +50,19 @@ func NewDispatcherConnMgr(gid uint16, dctype DispatcherClientType, dispid uint16
 	}
 }
 
+// NewLocalDispatcherConnMgr creates the DispatcherConnMgr connected to the in-process dispatcher through the connection
+//
+// The connection is never reconnected, and packets from the dispatcher are not received by the DispatcherConnMgr, so the
+// in-process dispatcher should handle packets by itself. It is used by tests running components in one process.
+func NewLocalDispatcherConnMgr(gid uint16, dctype DispatcherClientType, dispid uint16, conn net.Conn) *DispatcherConnMgr {
+	return &DispatcherConnMgr{
+		gid:               gid,
+		dctype:            dctype,
+		dispid:            dispid,
+		_dispatcherClient: newLocalDispatcherClient(dctype, conn),
+	}
+}
+
 func (dcm *DispatcherConnMgr) getDispatcherClient() *DispatcherClient { // atomic
 	addr := (*uintptr)(unsafe.Pointer(&dcm._dispatcherClient))
 	return (*DispatcherClient)(unsafe.Pointer(atomic.LoadUintptr(addr)))
@@ -64,13 +78,13 @@ func (dcm *DispatcherConnMgr) String() string {
 }
 
 func (dcm *DispatcherConnMgr) assureConnected() *DispatcherClient {
-	//gwlog.Debugf("assureConnected: _dispatcherClient", _dispatcherClient)
+	//logger.Debugf("assureConnected: _dispatcherClient", _dispatcherClient)
 	var err error
 	dc := dcm.getDispatcherClient()
 	for dc == nil || dc.IsClosed() {
 		dc, err = dcm.connectDispatchClient()
 		if err != nil {
-			gwlog.Errorf("Connect to dispatcher%d failed: %s", dcm.dispid, err.Error())
+			logger.Errorf("Connect to dispatcher%d failed: %s", dcm.dispid, err.Error())
 			time.Sleep(_LOOP_DELAY_ON_DISPATCHER_CLIENT_ERROR)
 			continue
 		}
@@ -82,7 +96,7 @@ func (dcm *DispatcherConnMgr) assureConnected() *DispatcherClient {
 		}
 		dcm.isReconnect = true
 
-		gwlog.Infof("dispatcher_client: connected to dispatcher: %s", dc)
+		logger.Infof("dispatcher_client: connected to dispatcher: %s", dc)
 	}
 	return dc
 }
@@ -121,7 +135,7 @@ func (dcm *DispatcherConnMgr) GetDispatcherClientForSend() *DispatcherClient {
 
 // serve the dispatcher client, receive RESPs from dispatcher and process
 func (dcm *DispatcherConnMgr) serveDispatcherClient() {
-	gwlog.Debugf("%s.serveDispatcherClient: start serving dispatcher client ...", dcm)
+	logger.Debugf("%s.serveDispatcherClient: start serving dispatcher client ...", dcm)
 	for {
 		dc := dcm.assureConnected()
 		var msgtype proto.MsgType
@@ -132,7 +146,7 @@ func (dcm *DispatcherConnMgr) serveDispatcherClient() {
 				continue
 			}
 
-			gwlog.TraceError("serveDispatcherClient: RecvMsgPacket error: %s", err.Error())
+			logger.TraceError("serveDispatcherClient: RecvMsgPacket error: %s", err.Error())
 			dc.Close()
 			dcm.delegate.HandleDispatcherClientDisconnect()
 			time.Sleep(_LOOP_DELAY_ON_DISPATCHER_CLIENT_ERROR)
@@ -140,7 +154,7 @@ func (dcm *DispatcherConnMgr) serveDispatcherClient() {
 		}
 
 		if consts.DEBUG_PACKETS {
-			gwlog.Debugf("%s.RecvPacket: msgtype=%v, payload=%v", dc, msgtype, pkt.Payload())
+			logger.Debugf("%s.RecvPacket: msgtype=%v, payload=%v", dc, msgtype, pkt.Payload())
 		}
 		dcm.delegate.HandleDispatcherClientPacket(msgtype, pkt)
 	}
@@ -1,6 +1,8 @@
 package dispatchercluster
 
 import (
+	"net"
+
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster/dispatcherclient"
@@ -36,6 +38,22 @@ func Initialize(_gid uint16, dctype dispatcherclient.DispatcherClientType, isRes
 	}
 }
 
+// InitializeLocal initializes the only dispatcher connection to the in-process dispatcher, which is used by tests
+func InitializeLocal(_gid uint16, dctype dispatcherclient.DispatcherClientType, conn net.Conn) {
+	gid = _gid
+	dispatcherNum = 1
+	dispatcherConns = []*dispatcherclient.DispatcherConnMgr{
+		dispatcherclient.NewLocalDispatcherConnMgr(gid, dctype, 1, conn),
+	}
+}
+
+// Flush flushes packets of all dispatcher connections
+func Flush(reason string) {
+	for _, dcm := range dispatcherConns {
+		dcm.GetDispatcherClientForSend().Flush(reason)
+	}
+}
+
 func SendNotifyDestroyEntity(id common.EntityID) error {
 	return SelectByEntityID(id).SendNotifyDestroyEntity(id)
 }
@@ -69,16 +87,16 @@ func SendNotifyCreateEntity(id common.EntityID) error {
 	}
 }
 
-func SendLoadEntityAnywhere(typeName string, entityID common.EntityID) error {
-	return SelectByEntityID(entityID).SendLoadEntitySomewhere(typeName, entityID, 0)
+func SendLoadEntityAnywhere(typeName string, entityID common.EntityID, world string) error {
+	return SelectByEntityID(entityID).SendLoadEntitySomewhere(typeName, entityID, world, 0)
 }
 
-func SendLoadEntityOnGame(typeName string, entityID common.EntityID, gameid uint16) error {
-	return SelectByEntityID(entityID).SendLoadEntitySomewhere(typeName, entityID, gameid)
+func SendLoadEntityOnGame(typeName string, entityID common.EntityID, world string, gameid uint16) error {
+	return SelectByEntityID(entityID).SendLoadEntitySomewhere(typeName, entityID, world, gameid)
 }
 
-func SendCreateEntitySomewhere(gameid uint16, entityid common.EntityID, typeName string, data map[string]interface{}) error {
-	return SelectByEntityID(entityid).SendCreateEntitySomewhere(gameid, entityid, typeName, data)
+func SendCreateEntitySomewhere(gameid uint16, entityid common.EntityID, typeName string, world string, data map[string]interface{}) error {
+	return SelectByEntityID(entityid).SendCreateEntitySomewhere(gameid, entityid, typeName, world, data)
 }
 
 func SendGameLBCInfo(lbcinfo proto.GameLBCInfo) {
@@ -87,17 +105,77 @@ func SendGameLBCInfo(lbcinfo proto.GameLBCInfo) {
 	packet.Release()
 }
 
-func SendStartFreezeGame() {
-	pkt := proto.AllocStartFreezeGamePacket()
+// SendStartFreezeGame notifies all dispatchers to block the game until it is restored, toAnotherHost is set if the game
+// is restored on another host
+func SendStartFreezeGame(toAnotherHost bool) {
+	pkt := proto.AllocStartFreezeGamePacket(toAnotherHost)
 	broadcast(pkt)
 	pkt.Release()
 	return
 }
 
+// SendNotifyGameShuttingDown notifies all dispatchers that the game is shutting down
+func SendNotifyGameShuttingDown() {
+	for _, dcm := range dispatcherConns {
+		dcm.GetDispatcherClientForSend().SendNotifyGameShuttingDown()
+	}
+}
+
 func SendSrvdisRegister(srvid string, info string, force bool) {
 	SelectBySrvID(srvid).SendSrvdisRegister(srvid, info, force)
 }
 
+func SendSuperviseServiceEntity(id common.EntityID, shardName string) error {
+	return SelectByEntityID(id).SendSuperviseServiceEntity(id, shardName)
+}
+
+func SendCallServiceRequest(id common.EntityID, method string, callerGameID uint16, requestID uint32, args []interface{}) error {
+	return SelectByEntityID(id).SendCallServiceRequest(id, method, callerGameID, requestID, args)
+}
+
+// SendServiceResponse sends the response through the dispatcher of the service entity, which dispatched the request
+func SendServiceResponse(id common.EntityID, callerGameID uint16, requestID uint32, code byte, errmsg string, result interface{}) error {
+	return SelectByEntityID(id).SendServiceResponse(callerGameID, requestID, code, errmsg, result)
+}
+
+// SendServiceSnapshot sends the snapshot of the service shard to the dispatcher selected by the shard name, which keeps
+// the snapshot
+func SendServiceSnapshot(shardName string, version uint64, data []byte) error {
+	return SelectBySrvID(shardName).SendServiceSnapshot(shardName, version, data)
+}
+
+// SendEmitEvent sends the event to the dispatcher selected by the event name, which broadcasts it to all games, so that
+// events of the same name are delivered in order
+func SendEmitEvent(name string, id string, data []byte) error {
+	return SelectBySrvID(name).SendEmitEvent(name, id, data)
+}
+
+// SendSyncBanList sends the ban or unban to the dispatcher selected by the banned value, which broadcasts it to all
+// gates, so that changes of the same value are applied in order
+func SendSyncBanList(kind string, value string, data string) error {
+	return SelectBySrvID(kind+":"+value).SendSyncBanList(kind, value, data)
+}
+
+// SendClaimAccountSession sends the claim to the dispatcher selected by the account, which keeps sessions of the account
+func SendClaimAccountSession(account string, id common.EntityID, gateid uint16, maxSessions int, kickOlder bool, refresh bool) error {
+	return SelectBySrvID(account).SendClaimAccountSession(account, id, gateid, maxSessions, kickOlder, refresh)
+}
+
+// SendQueryOnlinePlayer queries the online player of the account at the dispatcher selected by the account
+func SendQueryOnlinePlayer(account string, requestID uint32) error {
+	return SelectBySrvID(account).SendQueryOnlinePlayer(account, requestID)
+}
+
+// SendReleaseAccountSession releases the session at the dispatcher selected by the account
+func SendReleaseAccountSession(account string, id common.EntityID) error {
+	return SelectBySrvID(account).SendReleaseAccountSession(account, id)
+}
+
+// SendKickAccountSession sends the kick to the entity holding the session through the dispatcher of the entity
+func SendKickAccountSession(id common.EntityID, account string, reason string) error {
+	return SelectByEntityID(id).SendKickAccountSession(id, account, reason)
+}
+
 func SendCallNilSpaces(exceptGameID uint16, method string, args []interface{}) {
 	// construct one packet for multiple sending
 	packet := proto.AllocCallNilSpacesPacket(exceptGameID, method, args)
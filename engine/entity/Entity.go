@@ -1,6 +1,8 @@
 package entity
 
 import (
+	"context"
+	"encoding/base64"
 	"fmt"
 	"reflect"
 
@@ -13,30 +15,52 @@ import (
 	timer "github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/crontab"
 	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/simulation"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/timingwheel"
+	"github.com/xiaonanln/goworld/engine/webhook"
 	"github.com/xiaonanln/typeconv"
 )
 
+const (
+	_PERSISTENT_TIMERS_KEY = "_Timers" // key of persistent timers in persistent data
+)
+
 var (
-	saveInterval time.Duration
+	saveInterval             time.Duration
+	jitterTimers             bool            // randomize first intervals of repeat timers
+	useTimingWheel           bool            // entity timers are added to the timing wheel instead of goTimer
+	serviceMigratedInHandler func(e *Entity) // called when service entities are migrated in
 )
 
 // Yaw is the type of entity Yaw
 type Yaw float32
 
+// rawTimer is the timer of goTimer or the timing wheel
+type rawTimer interface {
+	Cancel()
+	IsActive() bool
+}
+
 type entityTimerInfo struct {
 	FireTime       time.Time
 	RepeatInterval time.Duration
 	Method         string
 	Args           []interface{}
 	Repeat         bool
-	rawTimer       *timer.Timer
+	Cron           string // cron expression of cron timers
+	Persistent     bool   // saved to entity storage
+	Jitter         bool   // the first interval of repeat timer is randomized
+	rawTimer       rawTimer
+	schedule       crontab.Schedule
 }
 
 // Entity is the basic execution unit in GoWorld server. Entities can be used to
@@ -44,9 +68,12 @@ type entityTimerInfo struct {
 type Entity struct {
 	ID                   common.EntityID
 	TypeName             string
+	world                string // world of the entity, "" for the default world
 	I                    IEntity
 	V                    reflect.Value
 	destroyed            bool
+	ctx                  context.Context
+	cancelCtx            context.CancelFunc
 	typeDesc             *EntityTypeDesc
 	Space                *Space
 	Position             Vector3
@@ -54,11 +81,20 @@ type Entity struct {
 	InterestedBy         EntitySet
 	aoi                  aoi.AOI
 	yaw                  Yaw
-	rawTimers            map[*timer.Timer]struct{}
+	rawTimers            map[rawTimer]struct{}
 	timers               map[EntityTimerID]*entityTimerInfo
 	lastTimerId          EntityTimerID
 	client               *GameClient
+	clientSession        *clientSession
 	syncingFromClient    bool
+	lastMoveTime         time.Time                     // time of the last position change, for validating moves from Client
+	moveStrikes          int                           // number of moves from Client rejected by the space
+	accountSession       string                        // account whose session is held by the entity
+	accountSessionClaim  *accountSessionClaim          // claim of the account session waiting for the dispatcher to ack
+	replay               *replaySession                // space record played to the client, nil if not spectating
+	attentionTarget      common.EntityID               // the entity the player focuses on, see SetAttentionTarget
+	interactions         map[common.EntityID]time.Time // times of recent interactions with other entities
+	deferredSyncs        EntitySet                     // neighbors whose position syncs are deferred by the client sync budget
 	Attrs                *MapAttr
 	syncInfoFlag         syncInfoFlag
 	enteringSpaceRequest struct {
@@ -70,8 +106,12 @@ type Entity struct {
 }
 
 type clientData struct {
-	ClientID common.ClientID
-	GateID   uint16
+	ClientID     common.ClientID
+	GateID       uint16
+	SessionToken string
+	Latency      time.Duration
+	GeoTag       string
+	AuthID       string
 }
 
 // entity info that should be migrated
@@ -79,6 +119,7 @@ type entityMigrateData struct {
 	Type              string                 `msgpack:"T"`
 	Attrs             map[string]interface{} `msgpack:"A"`
 	Client            *clientData            `msgpack:"C,omitempty"`
+	ClientSession     *clientSessionData     `msgpack:"CS,omitempty"`
 	Pos               Vector3                `msgpack:"Pos"`
 	Yaw               Yaw                    `msgpack:"Yaw"`
 	SpaceID           common.EntityID        `msgpack:"SP"`
@@ -86,6 +127,9 @@ type entityMigrateData struct {
 	FilterProps       map[string]string      `msgpack:"FP"`
 	SyncingFromClient bool                   `msgpack""SFC`
 	SyncInfoFlag      syncInfoFlag           `msgpack:"SIF"`
+	MoveStrikes       int                    `msgpack:"MS,omitempty"`
+	AccountSession    string                 `msgpack:"AS,omitempty"`
+	World             string                 `msgpack:"W,omitempty"`
 }
 
 type syncInfoFlag int
@@ -113,12 +157,28 @@ type IEntity interface {
 	OnEnterSpace()             // Called when entity leaves space
 	OnLeaveSpace(space *Space) // Called when entity enters space
 	// Client Notifications
-	OnClientConnected()    // Called when Client is connected to entity (become player)
-	OnClientDisconnected() // Called when Client disconnected
+	OnClientConnected()          // Called when Client is connected to entity (become player)
+	OnClientDisconnected()       // Called when Client disconnected
+	OnClientResumed()            // Called when disconnected Client reconnects and resumes its session
+	OnClientFlood(reason string) // Called when Client is kicked by gate for flooding, before Client disconnected
+	OnClientLatencyChanged()     // Called when latency of Client measured by gate is changed
+	// Called when the session of the account held by the entity is kicked by a newer session, e.g. logged in elsewhere
+	OnAccountSessionKicked(reason string)
+	// Called when the move from Client is rejected by ValidateMove of the space, strikes is the number of rejected moves
+	OnMoveRejected(from, to Vector3, strikes int)
 
 	DescribeEntityType(desc *EntityTypeDesc) // Define entity attributes in this function
 }
 
+// IAOIWatcher is implemented by entity types which are notified when other entities enter or leave their AOI
+//
+// The callbacks are called during AOI updates, so entities should not be moved or destroyed in the callbacks directly,
+// use Post instead.
+type IAOIWatcher interface {
+	OnEntityEnterAOI(other *Entity) // Called when other entity enters AOI of the entity
+	OnEntityLeaveAOI(other *Entity) // Called when other entity leaves AOI of the entity
+}
+
 func (e *Entity) String() string {
 	return fmt.Sprintf("%s<%s>", e.TypeName, e.ID)
 }
@@ -128,7 +188,7 @@ func (e *Entity) Destroy() {
 	if e.destroyed {
 		return
 	}
-	gwlog.Debugf("%s.Destroy ...", e)
+	logger.Debugf("%s.Destroy ...", e)
 	e.destroyEntity(false)
 	dispatchercluster.SendNotifyDestroyEntity(e.ID)
 }
@@ -142,10 +202,14 @@ func (e *Entity) destroyEntity(isMigrate bool) {
 		e.I.OnMigrateOut()
 	}
 
+	e.StopSpectatingReplay()
 	e.clearRawTimers()
-	e.rawTimers = nil // prohibit further use
+	e.rawTimers = nil     // prohibit further use
+	e.clientSession = nil // session timer is already cancelled
+	e.accountSessionClaim = nil
 
 	if !isMigrate {
+		e.ReleaseAccountSession()
 		e.SetClient(nil) // always set Client to nil before destroy
 		e.Save()
 	} else {
@@ -153,6 +217,9 @@ func (e *Entity) destroyEntity(isMigrate bool) {
 	}
 
 	e.destroyed = true
+	if e.cancelCtx != nil {
+		e.cancelCtx() // drop callbacks of outstanding operations started with the context
+	}
 	entityManager.del(e)
 }
 
@@ -161,6 +228,20 @@ func (e *Entity) IsDestroyed() bool {
 	return e.destroyed
 }
 
+// Context returns the context which is canceled when the entity is destroyed or migrated out
+//
+// Pass it to storage, KVDB, service requests and async jobs so that their callbacks are not called on the destroyed
+// entity.
+func (e *Entity) Context() context.Context {
+	if e.ctx == nil {
+		e.ctx, e.cancelCtx = context.WithCancel(context.Background())
+		if e.destroyed {
+			e.cancelCtx()
+		}
+	}
+	return e.ctx
+}
+
 // Save the entity
 func (e *Entity) Save() {
 	if !e.IsPersistent() {
@@ -168,12 +249,27 @@ func (e *Entity) Save() {
 	}
 
 	if consts.DEBUG_SAVE_LOAD {
-		gwlog.Debugf("SAVING %s ...", e)
+		logger.Debugf("SAVING %s ...", e)
 	}
 
 	data := e.getPersistentData()
 
-	storage.Save(e.TypeName, e.ID, data, nil)
+	storage.Save(storageTypeName(e.TypeName, e.world), e.ID, data, nil)
+}
+
+// SaveWithCallback saves entity fields to entity storage, and calls callback after the fields are written
+func (e *Entity) SaveWithCallback(callback func()) {
+	if !e.IsPersistent() {
+		gwlog.Panicf("%s is not persistent", e)
+	}
+
+	if consts.DEBUG_SAVE_LOAD {
+		logger.Debugf("SAVING %s ...", e)
+	}
+
+	data := e.getPersistentData()
+
+	storage.Save(storageTypeName(e.TypeName, e.world), e.ID, data, callback)
 }
 
 // IsSpaceEntity returns if the entity is actually a space
@@ -198,7 +294,7 @@ func (e *Entity) init(typeName string, entityid common.EntityID, entityInstance
 
 	e.typeDesc = registeredEntityTypes[typeName]
 
-	e.rawTimers = map[*timer.Timer]struct{}{}
+	e.rawTimers = map[rawTimer]struct{}{}
 	e.timers = map[EntityTimerID]*entityTimerInfo{}
 
 	attrs := NewMapAttr()
@@ -216,10 +312,21 @@ func (e *Entity) setupSaveTimer() {
 	e.addRawTimer(saveInterval, e.Save)
 }
 
+// SetJitterTimers sets if first intervals of all entity repeat timers are randomized, see AddJitteredTimer
+func SetJitterTimers(jitter bool) {
+	jitterTimers = jitter
+}
+
+// SetTimingWheel sets if entity timers are added to the hierarchical timing wheel, which is efficient for large numbers
+// of timers, existing timers are not moved
+func SetTimingWheel(enabled bool) {
+	useTimingWheel = enabled
+}
+
 // SetSaveInterval sets the save interval for entity system
 func SetSaveInterval(duration time.Duration) {
 	saveInterval = duration
-	gwlog.Infof("Save interval set to %s", saveInterval)
+	logger.Infof("Save interval set to %s", saveInterval)
 }
 
 // Space Operations related to aoi
@@ -234,15 +341,33 @@ func (e *Entity) OnLeaveAOI(otherAoi *aoi.AOI) {
 
 // Interests and Uninterest among entities
 func (e *Entity) interest(other *Entity) {
+	aoiLogger.Debugf("%s interest %s", e, other)
 	e.InterestedIn.Add(other)
 	other.InterestedBy.Add(e)
+	if quietInterests {
+		return
+	}
 	e.client.sendCreateEntity(other, false)
+	if w, ok := e.I.(IAOIWatcher); ok {
+		gwutils.RunPanicless(func() {
+			w.OnEntityEnterAOI(other)
+		})
+	}
 }
 
 func (e *Entity) uninterest(other *Entity) {
+	aoiLogger.Debugf("%s uninterest %s", e, other)
 	e.InterestedIn.Del(other)
 	other.InterestedBy.Del(e)
+	if quietInterests {
+		return
+	}
 	e.client.sendDestroyEntity(other)
+	if w, ok := e.I.(IAOIWatcher); ok {
+		gwutils.RunPanicless(func() {
+			w.OnEntityLeaveAOI(other)
+		})
+	}
 }
 
 // IsInterestedIn checks if other entity is interested by this entity
@@ -265,49 +390,200 @@ func (tid EntityTimerID) IsValid() bool {
 	return tid > 0
 }
 
-// AddCallback adds a one-time callback for the entity
+// EntityTimer is the handle of the entity callback or timer
+type EntityTimer struct {
+	e    *Entity
+	tid  EntityTimerID
+	info *entityTimerInfo
+}
+
+// ID returns the EntityTimerID of the timer
+func (t *EntityTimer) ID() EntityTimerID {
+	return t.tid
+}
+
+// Cancel cancels the timer, the timer is not fired after Cancel returns
+func (t *EntityTimer) Cancel() {
+	t.e.CancelTimer(t.tid)
+}
+
+// Reset restarts the timer to be fired after the duration (and every duration for repeat timers)
+//
+// Fired callbacks and cancelled timers are also restarted, unless the entity is destroyed. Cron timers are restarted
+// at the next time matching the cron expression, regardless of the duration.
+func (t *EntityTimer) Reset(d time.Duration) {
+	e := t.e
+	if e.destroyed || e.timers == nil {
+		return
+	}
+
+	if timerInfo := e.timers[t.tid]; timerInfo != nil {
+		e.cancelRawTimer(timerInfo.rawTimer)
+	}
+	e.timers[t.tid] = t.info
+	e.startTimer(t.tid, t.info, d)
+	logger.Debugf("%s.ResetTimer %s: %d", e, t.info.Method, t.tid)
+}
+
+// IsActive returns if the timer is not fired (for callbacks), not cancelled and the entity is not destroyed
+func (t *EntityTimer) IsActive() bool {
+	return !t.e.destroyed && t.e.timers[t.tid] == t.info
+}
+
+// AddCallback adds a one-time callback for the entity, and returns the timer ID for CancelTimer
 //
 // The callback will be cancelled if entity is destroyed
 func (e *Entity) AddCallback(d time.Duration, method string, args ...interface{}) EntityTimerID {
+	return e.addTimer(d, false, false, method, args).tid
+}
+
+// AddCallbackHandle adds a one-time callback for the entity like AddCallback, and returns the handle to cancel or reset
+// the callback
+func (e *Entity) AddCallbackHandle(d time.Duration, method string, args ...interface{}) *EntityTimer {
+	return e.addTimer(d, false, false, method, args)
+}
+
+// AddTimer adds a repeat timer for the entity, and returns the timer ID for CancelTimer
+//
+// The callback will be cancelled if entity is destroyed
+func (e *Entity) AddTimer(d time.Duration, method string, args ...interface{}) EntityTimerID {
+	return e.addTimer(d, true, false, method, args).tid
+}
+
+// AddTimerHandle adds a repeat timer for the entity like AddTimer, and returns the handle to cancel or reset the timer
+func (e *Entity) AddTimerHandle(d time.Duration, method string, args ...interface{}) *EntityTimer {
+	return e.addTimer(d, true, false, method, args)
+}
+
+// AddJitteredTimer adds a repeat timer for the entity, which is fired first after a random duration in (0, d]
+//
+// Jittered timers of entities added in the same frame are spread across the interval, instead of firing in the same
+// frame forever. All repeat timers are jittered if jitter_entity_timers is enabled in game config.
+func (e *Entity) AddJitteredTimer(d time.Duration, method string, args ...interface{}) *EntityTimer {
+	t := e.addTimer(d, true, false, method, args)
+	if !t.info.Jitter {
+		t.info.Jitter = true
+		t.Reset(d)
+	}
+	return t
+}
+
+// AddPersistentCallback adds a one-time callback for the entity, which is saved to entity storage with the entity
+//
+// Persistent timers are restored with the remaining time when the entity is loaded, e.g. after games restart. Timers
+// expired while the entity is not loaded are fired right after loading, and timers fired after the entity is saved last
+// time are fired again, so the method should save the entity if it matters. Arguments should be serializable by msgpack.
+func (e *Entity) AddPersistentCallback(d time.Duration, method string, args ...interface{}) *EntityTimer {
+	return e.addTimer(d, false, true, method, args)
+}
+
+// AddPersistentTimer adds a repeat timer for the entity, which is saved to entity storage with the entity
+//
+// Repeat timers missing multiple intervals while the entity is not loaded are fired once right after loading.
+func (e *Entity) AddPersistentTimer(d time.Duration, method string, args ...interface{}) *EntityTimer {
+	return e.addTimer(d, true, true, method, args)
+}
+
+func (e *Entity) addTimer(d time.Duration, repeat bool, persistent bool, method string, args []interface{}) *EntityTimer {
+	if persistent && !e.IsPersistent() {
+		gwlog.Panicf("%s is not persistent", e)
+	}
+
 	tid := e.genTimerId()
-	now := time.Now()
 	info := &entityTimerInfo{
-		FireTime: now.Add(d),
-		Method:   method,
-		Args:     args,
-		Repeat:   false,
+		Method:     method,
+		Args:       args,
+		Repeat:     repeat,
+		Persistent: persistent,
+		Jitter:     repeat && jitterTimers,
 	}
 	e.timers[tid] = info
-	info.rawTimer = e.addRawCallback(d, func() {
-		e.triggerTimer(tid, false)
-	})
-	gwlog.Debugf("%s.AddCallback %s: %d", e, method, tid)
-	return tid
+	e.startTimer(tid, info, d)
+	logger.Debugf("%s.AddTimer %s: %d, repeat=%v, persistent=%v", e, method, tid, repeat, persistent)
+	return &EntityTimer{e, tid, info}
 }
 
-// AddTimer adds a repeat timer for the entity
+// AddCron adds a timer for the entity which is fired at times matching the cron expression
 //
-// The callback will be cancelled if entity is destroyed
-func (e *Entity) AddTimer(d time.Duration, method string, args ...interface{}) EntityTimerID {
-	if d < time.Millisecond*10 { // minimal interval for repeat timer
-		d = time.Millisecond * 10
+// See crontab.ParseSchedule for the syntax of spec. Cron timers are kept when the entity migrates or the game is
+// restored from freezing, and cancelled if entity is destroyed.
+func (e *Entity) AddCron(spec string, method string, args ...interface{}) (*EntityTimer, error) {
+	schedule, err := crontab.ParseSchedule(spec)
+	if err != nil {
+		return nil, err
+	}
+	if schedule.Next(simulation.Now()).IsZero() {
+		return nil, errors.Errorf("cron %s never matches", spec)
 	}
 
 	tid := e.genTimerId()
-	now := time.Now()
 	info := &entityTimerInfo{
-		FireTime:       now.Add(d),
-		RepeatInterval: d,
-		Method:         method,
-		Args:           args,
-		Repeat:         true,
+		Method:   method,
+		Args:     args,
+		Cron:     spec,
+		schedule: schedule,
 	}
 	e.timers[tid] = info
+	e.startTimer(tid, info, 0)
+	logger.Debugf("%s.AddCron %s %s: %d", e, spec, method, tid)
+	return &EntityTimer{e, tid, info}, nil
+}
+
+func (e *Entity) startTimer(tid EntityTimerID, info *entityTimerInfo, d time.Duration) {
+	if info.Cron != "" {
+		e.startCronTimer(tid, info)
+		return
+	}
+
+	if !info.Repeat {
+		info.FireTime = simulation.Now().Add(d)
+		info.rawTimer = e.addRawCallback(d, func() {
+			e.triggerTimer(tid, false)
+		})
+		return
+	}
+
+	if d < time.Millisecond*10 { // minimal interval for repeat timer
+		d = time.Millisecond * 10
+	}
+	info.RepeatInterval = d
+	if info.Jitter {
+		// the repeat timer is started after the first firing by triggerTimer
+		first := time.Duration(simulation.Rand().Int63n(int64(d))) + 1
+		info.FireTime = simulation.Now().Add(first)
+		info.rawTimer = e.addRawCallback(first, func() {
+			e.triggerTimer(tid, false)
+		})
+		return
+	}
+
+	info.FireTime = simulation.Now().Add(d)
 	info.rawTimer = e.addRawTimer(d, func() {
 		e.triggerTimer(tid, true)
 	})
-	gwlog.Debugf("%s.AddTimer %s: %d", e, method, tid)
-	return tid
+}
+
+// startCronTimer starts the cron timer at the next matching time, the timer is removed if the time is not found
+func (e *Entity) startCronTimer(tid EntityTimerID, info *entityTimerInfo) {
+	if info.schedule == nil { // restored cron timer
+		schedule, err := crontab.ParseSchedule(info.Cron)
+		if err != nil {
+			logger.Errorf("%s: cron timer %s of %s is invalid: %s", e, info.Cron, info.Method, err)
+			delete(e.timers, tid)
+			return
+		}
+		info.schedule = schedule
+	}
+
+	now := simulation.Now()
+	info.FireTime = info.schedule.Next(now)
+	if info.FireTime.IsZero() {
+		delete(e.timers, tid)
+		return
+	}
+	info.rawTimer = e.addRawCallback(info.FireTime.Sub(now), func() {
+		e.triggerTimer(tid, false)
+	})
 }
 
 // CancelTimer cancels the Callback / Timer
@@ -322,7 +598,9 @@ func (e *Entity) CancelTimer(tid EntityTimerID) {
 
 func (e *Entity) triggerTimer(tid EntityTimerID, isRepeat bool) {
 	timerInfo := e.timers[tid] // should never be nil
-	if !timerInfo.Repeat {
+	if timerInfo.Cron != "" {
+		e.startCronTimer(tid, timerInfo) // schedule the next time before calling, so that the method can cancel it
+	} else if !timerInfo.Repeat {
 		delete(e.timers, tid)
 	} else {
 		if !isRepeat {
@@ -331,11 +609,11 @@ func (e *Entity) triggerTimer(tid EntityTimerID, isRepeat bool) {
 			})
 		}
 
-		now := time.Now()
+		now := simulation.Now()
 		timerInfo.FireTime = now.Add(timerInfo.RepeatInterval)
 	}
 
-	e.onCallFromLocal(timerInfo.Method, timerInfo.Args)
+	e.callFromLocal(opmon.HandlerEntityTimer, timerInfo.Method, timerInfo.Args)
 }
 
 func (e *Entity) genTimerId() EntityTimerID {
@@ -347,20 +625,28 @@ func (e *Entity) genTimerId() EntityTimerID {
 var timersPacker = netutil.MessagePackMsgPacker{}
 
 func (e *Entity) dumpTimers() []byte {
-	if len(e.timers) == 0 {
-		return nil
-	}
+	data := e.packTimers(false)
+	e.timers = nil // no more AddCallback or AddTimer
+	return data
+}
 
+// packTimers packs all timers, or only persistent timers if onlyPersistent is true
+func (e *Entity) packTimers(onlyPersistent bool) []byte {
 	timers := make([]*entityTimerInfo, 0, len(e.timers))
 	for _, t := range e.timers {
-		timers = append(timers, t)
+		if t.Persistent || !onlyPersistent {
+			timers = append(timers, t)
+		}
 	}
-	e.timers = nil // no more AddCallback or AddTimer
+	if len(timers) == 0 {
+		return nil
+	}
+
 	data, err := timersPacker.PackMsg(timers, nil)
 	if err != nil {
-		gwlog.TraceError("%s dump timers failed: %s", e, err)
+		logger.TraceError("%s dump timers failed: %s", e, err)
 	}
-	//gwlog.Infof("%s dump %d timers: %v", e, len(timers), data)
+	//logger.Infof("%s dump %d timers: %v", e, len(timers), data)
 	return data
 }
 
@@ -372,8 +658,8 @@ func (e *Entity) restoreTimers(data []byte) error {
 	if err := timersPacker.UnpackMsg(data, &timers); err != nil {
 		return err
 	}
-	gwlog.Debugf("%s: %d timers restored: %v", e, len(timers), timers)
-	now := time.Now()
+	logger.Debugf("%s: %d timers restored: %v", e, len(timers), timers)
+	now := simulation.Now()
 	for _, timer := range timers {
 		//if timer.rawTimer != nil {
 		//	gwlog.Panicf("raw timer should be nil")
@@ -389,23 +675,33 @@ func (e *Entity) restoreTimers(data []byte) error {
 	return nil
 }
 
-func (e *Entity) addRawCallback(d time.Duration, cb timer.CallbackFunc) *timer.Timer {
-	var t *timer.Timer
-	t = timer.AddCallback(d, func() {
+func (e *Entity) addRawCallback(d time.Duration, cb timer.CallbackFunc) rawTimer {
+	var t rawTimer
+	f := func() {
 		delete(e.rawTimers, t)
 		cb()
-	})
+	}
+	if useTimingWheel {
+		t = timingwheel.AddCallback(d, f)
+	} else {
+		t = timer.AddCallback(d, f)
+	}
 	e.rawTimers[t] = struct{}{}
 	return t
 }
 
-func (e *Entity) addRawTimer(d time.Duration, cb timer.CallbackFunc) *timer.Timer {
-	t := timer.AddTimer(d, cb)
+func (e *Entity) addRawTimer(d time.Duration, cb timer.CallbackFunc) rawTimer {
+	var t rawTimer
+	if useTimingWheel {
+		t = timingwheel.AddTimer(d, cb)
+	} else {
+		t = timer.AddTimer(d, cb)
+	}
 	e.rawTimers[t] = struct{}{}
 	return t
 }
 
-func (e *Entity) cancelRawTimer(t *timer.Timer) {
+func (e *Entity) cancelRawTimer(t rawTimer) {
 	delete(e.rawTimers, t)
 	t.Cancel()
 }
@@ -414,7 +710,7 @@ func (e *Entity) clearRawTimers() {
 	for t := range e.rawTimers {
 		t.Cancel()
 	}
-	e.rawTimers = map[*timer.Timer]struct{}{}
+	e.rawTimers = map[rawTimer]struct{}{}
 }
 
 // Post a function which will be executed immediately but not in the current stack frames
@@ -427,11 +723,41 @@ func (e *Entity) Call(id common.EntityID, method string, args ...interface{}) {
 	Call(id, method, args)
 }
 
+// PostWebhookEvent posts the entity event to webhooks configured in [webhook] section, data is encoded in JSON
+func (e *Entity) PostWebhookEvent(event string, data interface{}) {
+	webhook.PostEvent(e.ID, e.TypeName, event, data)
+}
+
 func (e *Entity) syncPositionYawFromClient(x, y, z Coord, yaw Yaw) {
-	//gwlog.Infof("%s.syncPositionYawFromClient: %v,%v,%v, Yaw %v, syncing %v", e, x, y, z, Yaw, e.SyncingFromClient)
-	if e.syncingFromClient {
-		e.setPositionYaw(Vector3{x, y, z}, yaw, true)
+	//logger.Infof("%s.syncPositionYawFromClient: %v,%v,%v, Yaw %v, syncing %v", e, x, y, z, Yaw, e.SyncingFromClient)
+	if !e.syncingFromClient {
+		return
+	}
+
+	pos := Vector3{x, y, z}
+	if e.Space != nil && !e.Space.IsNil() && pos != e.Position {
+		dt := simulation.Now().Sub(e.lastMoveTime)
+		if !e.Space.I.ValidateMove(e, e.Position, pos, dt) {
+			// the move is rejected, and the Client is pulled back to the position on server (rubber-banding)
+			e.moveStrikes += 1
+			e.syncInfoFlag |= sifSyncOwnClient
+			gwutils.RunPanicless(func() {
+				e.I.OnMoveRejected(e.Position, pos, e.moveStrikes)
+			})
+			return
+		}
 	}
+	e.setPositionYaw(pos, yaw, true)
+}
+
+// GetMoveStrikes returns the number of moves from Client rejected by ValidateMove of the space
+func (e *Entity) GetMoveStrikes() int {
+	return e.moveStrikes
+}
+
+// ResetMoveStrikes resets the number of rejected moves, e.g. after the player is punished
+func (e *Entity) ResetMoveStrikes() {
+	e.moveStrikes = 0
 }
 
 // SetClientSyncing set if entity infos (position, Yaw) is syncing with Client
@@ -440,10 +766,14 @@ func (e *Entity) SetClientSyncing(syncing bool) {
 }
 
 func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
+	e.callFromLocal(opmon.HandlerEntityRPC, methodName, args)
+}
+
+func (e *Entity) callFromLocal(kind string, methodName string, args []interface{}) {
 	defer func() {
 		err := recover() // recover from any error during RPC call
 		if err != nil {
-			gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+			logger.TraceError("%s.%s paniced: %s", e, methodName, err)
 		}
 	}()
 
@@ -477,21 +807,40 @@ func (e *Entity) onCallFromLocal(methodName string, args []interface{}) {
 		in[i+1] = reflect.Zero(argType)
 	}
 
-	rpcDesc.Func.Call(in)
+	e.callMethod(kind, methodName, rpcDesc, in)
+}
+
+// callMethod calls the entity method, records the duration of the call as handler of the kind, and dumps the crash if the method panics
+func (e *Entity) callMethod(kind string, methodName string, rpcDesc *rpcDesc, in []reflect.Value) []reflect.Value {
+	op := opmon.StartHandler(kind, e.TypeName+"."+methodName)
+	pushRunningCall(e, kind, methodName)
+	defer func() {
+		err := recover()
+		if err != nil {
+			DumpCrash(err) // dump before the stack of the panic is unwound
+			markPanicDumped(err)
+		}
+		popRunningCall()
+		op.FinishHandler()
+		if err != nil {
+			panic(err)
+		}
+	}()
+	return rpcDesc.Func.Call(in)
 }
 
 func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid common.ClientID) {
 	defer func() {
 		err := recover() // recover from any error during RPC call
 		if err != nil {
-			gwlog.TraceError("%s.%s paniced: %s", e, methodName, err)
+			logger.TraceError("%s.%s paniced: %s", e, methodName, err)
 		}
 	}()
 
 	rpcDesc := e.typeDesc.rpcDescs[methodName]
 	if rpcDesc == nil {
 		// rpc not found
-		gwlog.Errorf("%s.onCallFromRemote: Method %s is not a valid RPC, args=%v", e, methodName, args)
+		logger.Errorf("%s.onCallFromRemote: Method %s is not a valid RPC, args=%v", e, methodName, args)
 		return
 	}
 
@@ -512,7 +861,7 @@ func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid com
 	}
 
 	if rpcDesc.NumArgs < len(args) {
-		gwlog.Errorf("%s.onCallFromRemote: Method %s receives %d arguments, but given %d", e, methodName, rpcDesc.NumArgs, len(args))
+		logger.Errorf("%s.onCallFromRemote: Method %s receives %d arguments, but given %d", e, methodName, rpcDesc.NumArgs, len(args))
 		return
 	}
 
@@ -536,14 +885,21 @@ func (e *Entity) onCallFromRemote(methodName string, args [][]byte, clientid com
 		in[i+1] = reflect.Zero(argType)
 	}
 
-	rpcDesc.Func.Call(in)
+	if clientid != "" && rpcDesc.ArgSchemas != nil {
+		if err := rpcDesc.validateArgs(in[1:]); err != nil {
+			logger.Warnf("%s.onCallFromRemote: Method %s called by client %s is rejected: %s", e, methodName, clientid, err)
+			return
+		}
+	}
+
+	e.callMethod(opmon.HandlerEntityRPC, methodName, rpcDesc, in)
 }
 
 // OnInit is called when entity is initializing
 //
 // Can override this function in custom entity type
 func (e *Entity) OnInit() {
-	//gwlog.Warnf("%s.OnInit not implemented", e)
+	//logger.Warnf("%s.OnInit not implemented", e)
 }
 
 // OnAttrsReady is called when entity's attribute is ready
@@ -557,7 +913,7 @@ func (e *Entity) OnAttrsReady() {
 //
 // Can override this function in custom entity type
 func (e *Entity) OnCreated() {
-	//gwlog.Debugf("%s.OnCreated", e)
+	//logger.Debugf("%s.OnCreated", e)
 }
 
 // OnFreeze is called when entity is freezed
@@ -577,7 +933,7 @@ func (e *Entity) OnRestored() {
 // Can override this function in custom entity type
 func (e *Entity) OnEnterSpace() {
 	if consts.DEBUG_SPACES {
-		gwlog.Debugf("%s.OnEnterSpace >>> %s", e, e.Space)
+		logger.Debugf("%s.OnEnterSpace >>> %s", e, e.Space)
 	}
 }
 
@@ -586,7 +942,7 @@ func (e *Entity) OnEnterSpace() {
 // Can override this function in custom entity type
 func (e *Entity) OnLeaveSpace(space *Space) {
 	if consts.DEBUG_SPACES {
-		gwlog.Debugf("%s.OnLeaveSpace <<< %s", e, space)
+		logger.Debugf("%s.OnLeaveSpace <<< %s", e, space)
 	}
 }
 
@@ -607,16 +963,35 @@ func (e *Entity) IsPersistent() bool {
 
 // getPersistentData gets the persistent data
 //
-// Returns persistent attributes by default
+// Returns persistent attributes and persistent timers
 func (e *Entity) getPersistentData() map[string]interface{} {
-	return e.Attrs.ToMapWithFilter(e.typeDesc.persistentAttrs.Contains)
+	data := e.Attrs.ToMapWithFilter(e.typeDesc.persistentAttrs.Contains)
+	if timerData := e.packTimers(true); timerData != nil {
+		// encoded as string, which is supported by all storage backends
+		data[_PERSISTENT_TIMERS_KEY] = base64.StdEncoding.EncodeToString(timerData)
+	}
+	return data
 }
 
 // loadPersistentData loads persistent data
 //
-// Load persistent data to attributes
+// Load persistent data to attributes, and restore persistent timers
 func (e *Entity) loadPersistentData(data map[string]interface{}) {
+	timerData, hasTimers := data[_PERSISTENT_TIMERS_KEY]
+	if hasTimers {
+		delete(data, _PERSISTENT_TIMERS_KEY)
+	}
 	e.Attrs.AssignMap(data)
+
+	if hasTimers {
+		packedTimers, err := base64.StdEncoding.DecodeString(typeconv.String(timerData))
+		if err == nil {
+			err = e.restoreTimers(packedTimers)
+		}
+		if err != nil {
+			logger.Errorf("%s: restore persistent timers failed: %s", e, err)
+		}
+	}
 }
 
 func (e *Entity) getClientData() map[string]interface{} {
@@ -638,14 +1013,22 @@ func (e *Entity) GetMigrateData(spaceid common.EntityID) *entityMigrateData {
 		SpaceID:           spaceid,
 		SyncingFromClient: e.syncingFromClient,
 		SyncInfoFlag:      e.syncInfoFlag,
+		MoveStrikes:       e.moveStrikes,
+		AccountSession:    e.accountSession,
+		World:             e.world,
 	}
 
 	if e.client != nil {
 		md.Client = &clientData{
-			ClientID: e.client.clientid,
-			GateID:   e.client.gateid,
+			ClientID:     e.client.clientid,
+			GateID:       e.client.gateid,
+			SessionToken: e.client.sessionToken,
+			Latency:      e.client.latency,
+			GeoTag:       e.client.geoTag,
+			AuthID:       e.client.authID,
 		}
 	}
+	md.ClientSession = e.getClientSessionData()
 
 	return md
 }
@@ -696,20 +1079,13 @@ func (e *Entity) SetClient(client *GameClient) {
 		oldClient.sendDestroyEntity(e)
 	}
 
+	oldGateID := e.clientGateID()
 	e.assignClient(client) // remove old client, assign new client
+	e.updateAccountSessionGate(oldGateID)
 
 	if client != nil {
-		// send create entity to new client
-		dispatchercluster.SelectByEntityID(e.ID).SendClearClientFilterProp(client.gateid, client.clientid)
-		client.sendCreateEntity(e, true)
-
-		if !e.Space.IsNil() {
-			client.sendCreateEntity(&e.Space.Entity, false)
-		}
-
-		for neighbor := range e.InterestedBy {
-			client.sendCreateEntity(neighbor, false)
-		}
+		e.discardClientSession() // suspended session is useless since the entity has a new client
+		e.sendCreateEntitiesToClient(client)
 	}
 
 	if oldClient != nil && client == nil {
@@ -723,6 +1099,20 @@ func (e *Entity) SetClient(client *GameClient) {
 	}
 }
 
+// sendCreateEntitiesToClient sends the entity, its space and neighbors to the new client
+func (e *Entity) sendCreateEntitiesToClient(client *GameClient) {
+	dispatchercluster.SelectByEntityID(e.ID).SendClearClientFilterProp(client.gateid, client.clientid)
+	client.sendCreateEntity(e, true)
+
+	if !e.Space.IsNil() {
+		client.sendCreateEntity(&e.Space.Entity, false)
+	}
+
+	for neighbor := range e.InterestedBy {
+		client.sendCreateEntity(neighbor, false)
+	}
+}
+
 func (e *Entity) assignClient(client *GameClient) {
 	if e.client != nil {
 		e.client.ownerid = ""
@@ -751,12 +1141,12 @@ func (e *Entity) CallAllClients(method string, args ...interface{}) {
 // GiveClientTo gives Client to other entity
 func (e *Entity) GiveClientTo(other *Entity) {
 	if e.client == nil {
-		gwlog.Warnf("%s.GiveClientTo(%s): Client is nil", e, other)
+		logger.Warnf("%s.GiveClientTo(%s): Client is nil", e, other)
 		return
 	}
 
 	if consts.DEBUG_CLIENTS {
-		gwlog.Debugf("%s.GiveClientTo(%s): Client=%s", e, other, e.client)
+		logger.Debugf("%s.GiveClientTo(%s): Client=%s", e, other, e.client)
 	}
 	client := e.client
 	client.ownerid = other.ID // hack ownerid so that destroy entity messages will be synced with create entity messages
@@ -764,6 +1154,41 @@ func (e *Entity) GiveClientTo(other *Entity) {
 	other.SetClient(client)
 }
 
+// RedirectClientToGate asks the Client to reconnect to another gate and resume its session on the entity
+//
+// It can be used for rebalancing gate load or steering players to a gate closer to their region.
+// Session resuming should be enabled, otherwise the entity might lose the Client before it reconnects.
+func (e *Entity) RedirectClientToGate(gateid uint16) {
+	if e.client == nil {
+		logger.Warnf("%s.RedirectClientToGate(%d): Client is nil", e, gateid)
+		return
+	}
+	if e.client.gateid == gateid {
+		return // already connected to the gate
+	}
+
+	if consts.DEBUG_CLIENTS {
+		logger.Debugf("%s.RedirectClientToGate(%d): Client=%s", e, gateid, e.client)
+	}
+	e.client.sendRedirectToGate(gateid)
+}
+
+// KickClient kicks the Client with the reason, the Client is notified of the reason and disconnected by gate
+//
+// The session of the kicked Client is not kept for resuming.
+func (e *Entity) KickClient(reason string) {
+	if e.client == nil {
+		logger.Warnf("%s.KickClient(%s): Client is nil", e, reason)
+		return
+	}
+
+	if consts.DEBUG_CLIENTS {
+		logger.Debugf("%s.KickClient(%s): Client=%s", e, reason, e.client)
+	}
+	e.client.sessionToken = "" // the Client can not resume the session after kicked
+	e.client.sendKick(reason)
+}
+
 // ForAllClients visits all clients (own Client and clients of neighbors)
 func (e *Entity) ForAllClients(f func(client *GameClient)) {
 	if e.client != nil {
@@ -779,7 +1204,15 @@ func (e *Entity) ForAllClients(f func(client *GameClient)) {
 
 func (e *Entity) notifyClientDisconnected() {
 	// called when Client disconnected
+	sessionToken := e.client.sessionToken
+	oldGateID := e.clientGateID()
 	e.assignClient(nil)
+	e.updateAccountSessionGate(oldGateID)
+	if sessionResumeTimeout > 0 && sessionToken != "" {
+		// OnClientDisconnected is delayed until the session expires
+		e.suspendClientSession(sessionToken, sessionResumeTimeout)
+		return
+	}
 	e.I.OnClientDisconnected()
 }
 
@@ -788,7 +1221,7 @@ func (e *Entity) notifyClientDisconnected() {
 // Can override this function in custom entity type
 func (e *Entity) OnClientConnected() {
 	if consts.DEBUG_CLIENTS {
-		gwlog.Debugf("%s.OnClientConnected: %s, %d Neighbors", e, e.client, len(e.InterestedIn))
+		logger.Debugf("%s.OnClientConnected: %s, %d Neighbors", e, e.client, len(e.InterestedIn))
 	}
 }
 
@@ -797,7 +1230,33 @@ func (e *Entity) OnClientConnected() {
 // Can override this function in custom entity type
 func (e *Entity) OnClientDisconnected() {
 	if consts.DEBUG_CLIENTS {
-		gwlog.Debugf("%s.OnClientDisconnected: %s", e, e.client)
+		logger.Debugf("%s.OnClientDisconnected: %s", e, e.client)
+	}
+}
+
+// OnClientFlood is called when Client is kicked by gate for flooding
+//
+// Can override this function in custom entity type
+func (e *Entity) OnClientFlood(reason string) {
+	if consts.DEBUG_CLIENTS {
+		logger.Debugf("%s.OnClientFlood: %s: %s", e, e.client, reason)
+	}
+}
+
+// OnMoveRejected is called when the move from Client is rejected by ValidateMove of the space, the Client is pulled back
+// to the position on server
+//
+// Can override this function in custom entity type, e.g. to kick the player if there are too many strikes
+func (e *Entity) OnMoveRejected(from, to Vector3, strikes int) {
+	logger.Warnf("%s.OnMoveRejected: %s => %s, strikes=%d", e, from, to, strikes)
+}
+
+// OnClientLatencyChanged is called when latency of Client measured by gate is changed, use e.GetClient().Latency() to get the latest latency
+//
+// Can override this function in custom entity type
+func (e *Entity) OnClientLatencyChanged() {
+	if consts.DEBUG_CLIENTS {
+		logger.Debugf("%s.OnClientLatencyChanged: %s: %s", e, e.client, e.client.Latency())
 	}
 }
 
@@ -955,7 +1414,7 @@ func (e *Entity) GetListAttr(key string) *ListAttr {
 // EnterSpace let the entity enters space
 func (e *Entity) EnterSpace(spaceid common.EntityID, pos Vector3) {
 	if e.isEnteringSpace() {
-		gwlog.Errorf("%s is entering space %s, can not enter space %s", e, e.enteringSpaceRequest.SpaceID, spaceid)
+		logger.Errorf("%s is entering space %s, can not enter space %s", e, e.enteringSpaceRequest.SpaceID, spaceid)
 		e.I.OnEnterSpace()
 		return
 	}
@@ -975,7 +1434,7 @@ func (e *Entity) EnterSpace(spaceid common.EntityID, pos Vector3) {
 func (e *Entity) enterLocalSpace(space *Space, pos Vector3) {
 	if space == e.Space {
 		// space not changed
-		gwlog.TraceError("%s.enterLocalSpace: already in space %s", e, space)
+		logger.TraceError("%s.enterLocalSpace: already in space %s", e, space)
 		return
 	}
 
@@ -987,11 +1446,14 @@ func (e *Entity) enterLocalSpace(space *Space, pos Vector3) {
 		e.cancelEnterSpace()
 
 		if space.IsDestroyed() {
-			gwlog.Warnf("%s: space %s is destroyed, enter space cancelled", e, space.ID)
+			logger.Warnf("%s: space %s is destroyed, enter space cancelled", e, space.ID)
+			return
+		}
+		if !e.canEnterSpaceOfWorld(space) {
 			return
 		}
 
-		//gwlog.Infof("%s.enterLocalSpace ==> %s", e, space)
+		//logger.Infof("%s.enterLocalSpace ==> %s", e, space)
 		e.Space.leave(e)
 		space.enter(e, pos, false)
 	})
@@ -1025,30 +1487,30 @@ func (e *Entity) cancelEnterSpace() {
 // OnQuerySpaceGameIDForMigrateAck is called by engine when query entity gameid ACK is received
 func OnQuerySpaceGameIDForMigrateAck(entityid common.EntityID, spaceid common.EntityID, spaceGameID uint16) {
 
-	//gwlog.Infof("OnQuerySpaceGameIDForMigrateAck: entityid=%s, spaceid=%s, spaceGameID=%v", entityid, spaceid, spaceGameID)
+	//logger.Infof("OnQuerySpaceGameIDForMigrateAck: entityid=%s, spaceid=%s, spaceGameID=%v", entityid, spaceid, spaceGameID)
 
 	entity := entityManager.get(entityid)
 	if entity == nil {
 		//dispatcher_client.GetDispatcherClientForSend().SendCancelMigrateRequest(entityid)
-		gwlog.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is destroyed: entityid=%s, spaceid=%s", entityid, spaceid)
+		logger.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is destroyed: entityid=%s, spaceid=%s", entityid, spaceid)
 		return
 	}
 
 	if !entity.isEnteringSpace() {
 		// replay from dispatcher is too late ?
-		gwlog.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is not migrating: entity=%s, spaceid=%s", entity, spaceid)
+		logger.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is not migrating: entity=%s, spaceid=%s", entity, spaceid)
 		return
 	}
 
 	if entity.enteringSpaceRequest.SpaceID != spaceid {
 		// not entering this space ?
-		gwlog.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is enter other space: entity=%s, spaceid=%s, other space=%s", entity, spaceid, entity.enteringSpaceRequest.SpaceID)
+		logger.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is enter other space: entity=%s, spaceid=%s, other space=%s", entity, spaceid, entity.enteringSpaceRequest.SpaceID)
 		return
 	}
 
 	if spaceGameID == 0 {
 		// target space not found, migrate not started
-		gwlog.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since target space is not found: entity=%s, spaceid=%s", entity, spaceid)
+		logger.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since target space is not found: entity=%s, spaceid=%s", entity, spaceid)
 		entity.cancelEnterSpace()
 		return
 	}
@@ -1059,29 +1521,29 @@ func OnQuerySpaceGameIDForMigrateAck(entityid common.EntityID, spaceid common.En
 
 // OnMigrateRequestAck is called by engine when mgirate request Ack is received
 func OnMigrateRequestAck(entityid common.EntityID, spaceid common.EntityID, spaceGameID uint16) {
-	//gwlog.Infof("OnMigrateRequestAck: entityid=%s, spaceid=%s, spaceGameID=%v", entityid, spaceid, spaceGameID)
+	//logger.Infof("OnMigrateRequestAck: entityid=%s, spaceid=%s, spaceGameID=%v", entityid, spaceid, spaceGameID)
 	entity := entityManager.get(entityid)
 	if entity == nil {
 		//dispatcher_client.GetDispatcherClientForSend().SendCancelMigrateRequest(entityid)
-		gwlog.Errorf("Migrate failed since entity is destroyed: spaceid=%s, entityid=%s", spaceid, entityid)
+		logger.Errorf("Migrate failed since entity is destroyed: spaceid=%s, entityid=%s", spaceid, entityid)
 		return
 	}
 
 	if !entity.isEnteringSpace() {
 		// replay from dispatcher is too late ?
-		gwlog.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is not migrating: entity=%s, spaceid=%s", entity, spaceid)
+		logger.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is not migrating: entity=%s, spaceid=%s", entity, spaceid)
 		return
 	}
 
 	if entity.enteringSpaceRequest.SpaceID != spaceid {
 		// not entering this space ?
-		gwlog.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is enter other space: entity=%s, spaceid=%s, other space=%s", entity, spaceid, entity.enteringSpaceRequest.SpaceID)
+		logger.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since entity is enter other space: entity=%s, spaceid=%s, other space=%s", entity, spaceid, entity.enteringSpaceRequest.SpaceID)
 		return
 	}
 
 	if spaceGameID == 0 {
 		// target space not found, migrate not started
-		gwlog.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since target space is not found: entity=%s, spaceid=%s", entity, spaceid)
+		logger.Errorf("entity.OnQuerySpaceGameIDForMigrateAck: migrate failed since target space is not found: entity=%s, spaceid=%s", entity, spaceid)
 		entity.cancelEnterSpace()
 		return
 	}
@@ -1112,6 +1574,15 @@ func OnRealMigrate(entityid common.EntityID, data []byte) {
 	}
 
 	restoreEntity(entityid, &md, false)
+
+	if e := entityManager.get(entityid); e != nil && e.typeDesc.isService && serviceMigratedInHandler != nil {
+		serviceMigratedInHandler(e)
+	}
+}
+
+// SetServiceMigratedInHandler sets the handler which is called by engine when a service entity is migrated in
+func SetServiceMigratedInHandler(handler func(e *Entity)) {
+	serviceMigratedInHandler = handler
 }
 
 // OnMigrateOut is called when entity is migrating out
@@ -1119,7 +1590,7 @@ func OnRealMigrate(entityid common.EntityID, data []byte) {
 // Can override this function in custom entity type
 func (e *Entity) OnMigrateOut() {
 	if consts.DEBUG_MIGRATE {
-		gwlog.Debugf("%s.OnMigrateOut, space=%s, Client=%s", e, e.Space, e.client)
+		logger.Debugf("%s.OnMigrateOut, space=%s, Client=%s", e, e.Space, e.client)
 	}
 }
 
@@ -1128,7 +1599,7 @@ func (e *Entity) OnMigrateOut() {
 // Can override this function in custom entity type
 func (e *Entity) OnMigrateIn() {
 	if consts.DEBUG_MIGRATE {
-		gwlog.Debugf("%s.OnMigrateIn, space=%s, Client=%s", e, e.Space, e.client)
+		logger.Debugf("%s.OnMigrateIn, space=%s, Client=%s", e, e.Space, e.client)
 	}
 }
 
@@ -1189,12 +1660,13 @@ func (e *Entity) SetPosition(pos Vector3) {
 func (e *Entity) setPositionYaw(pos Vector3, yaw Yaw, fromClient bool) {
 	space := e.Space
 	if space == nil {
-		gwlog.Warnf("%s.SetPosition(%s): space is nil", e, pos)
+		logger.Warnf("%s.SetPosition(%s): space is nil", e, pos)
 		return
 	}
 
 	space.move(e, pos)
 	e.yaw = yaw
+	e.lastMoveTime = simulation.Now()
 
 	// mark the entity as needing sync
 	// Real sync packets will be sent before flushing dispatcher Client
@@ -1218,7 +1690,13 @@ func getEntitySyncInfosPacket(gateid uint16) *netutil.Packet {
 	return pkt
 }
 
+// _SYNC_INFO_SIZE is the size of entity sync info in MT_SYNC_POSITION_YAW_ON_CLIENTS packets: client ID, entity ID, position and yaw
+const _SYNC_INFO_SIZE = common.CLIENTID_LENGTH + common.ENTITYID_LENGTH + 4*4
+
 func CollectEntitySyncInfos() {
+	budget := getClientSyncBudget()
+	budgeted := budget > 0 || len(deferringObservers) > 0
+	var neighborSyncs []*Entity // entities synced to neighbors within the client sync budget
 	for eid, e := range entityManager.entities {
 		syncInfoFlag := e.syncInfoFlag
 		if syncInfoFlag == 0 {
@@ -1227,6 +1705,7 @@ func CollectEntitySyncInfos() {
 
 		e.syncInfoFlag = 0
 		syncInfo := e.getSyncInfo()
+		syncCount := 0
 		if syncInfoFlag&sifSyncOwnClient != 0 && e.client != nil {
 			gateid := e.client.gateid
 			packet := getEntitySyncInfosPacket(gateid)
@@ -1236,8 +1715,11 @@ func CollectEntitySyncInfos() {
 			packet.AppendFloat32(syncInfo.Y)
 			packet.AppendFloat32(syncInfo.Z)
 			packet.AppendFloat32(syncInfo.Yaw)
+			syncCount += 1
 		}
-		if syncInfoFlag&sifSyncNeighborClients != 0 {
+		if syncInfoFlag&sifSyncNeighborClients != 0 && budgeted {
+			neighborSyncs = append(neighborSyncs, e)
+		} else if syncInfoFlag&sifSyncNeighborClients != 0 {
 			for neighbor := range e.InterestedBy {
 				client := neighbor.client
 				if client != nil {
@@ -1249,15 +1731,23 @@ func CollectEntitySyncInfos() {
 					packet.AppendFloat32(syncInfo.Y)
 					packet.AppendFloat32(syncInfo.Z)
 					packet.AppendFloat32(syncInfo.Yaw)
+					syncCount += 1
 				}
 			}
 		}
+		if profiler != nil && syncCount > 0 {
+			profiler.addSyncBytes(eid, uint64(syncCount*_SYNC_INFO_SIZE))
+		}
+	}
+
+	if budgeted {
+		collectBudgetedNeighborSyncs(neighborSyncs, budget)
 	}
 
 	// send to dispatcher, one gate by one gate
 	if len(entitySyncInfosToGate) > 0 {
 		for gateid, packet := range entitySyncInfosToGate {
-			//gwlog.Infof("SYNC %d PAYLOAD %d", gateid, packet.GetPayloadLen())
+			//logger.Infof("SYNC %d PAYLOAD %d", gateid, packet.GetPayloadLen())
 			dispatchercluster.SelectByGateID(gateid).SendPacket(packet)
 			packet.Release()
 		}
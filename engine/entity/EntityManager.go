@@ -2,8 +2,11 @@ package entity
 
 import (
 	"reflect"
+	"sort"
 
 	"strings"
+	"sync/atomic"
+	"time"
 
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/goworld/engine/common"
@@ -18,6 +21,8 @@ import (
 var (
 	registeredEntityTypes = map[string]*EntityTypeDesc{}
 	entityManager         = newEntityManager()
+	logger                = gwlog.Module("entity")
+	aoiLogger             = gwlog.Module("aoi")
 )
 
 // EntityTypeDesc is the entity type description for registering entity types
@@ -31,6 +36,7 @@ type EntityTypeDesc struct {
 	allClientAttrs  common.StringSet
 	clientAttrs     common.StringSet
 	persistentAttrs common.StringSet
+	inspectorCalls  common.StringSet // methods which can be called from the entity inspector of admin server
 	//compositiveMethodComponentIndices map[string][]int
 	//definedAttrs                      bool
 }
@@ -59,7 +65,7 @@ func (desc *EntityTypeDesc) SetUseAOI(useAOI bool, aoiDistance Coord) *EntityTyp
 }
 
 func (desc *EntityTypeDesc) DefineAttr(attr string, defs ...string) *EntityTypeDesc {
-	gwlog.Infof("        Attr %s = %v", attr, defs)
+	logger.Infof("        Attr %s = %v", attr, defs)
 	isAllClient, isClient, isPersistent := false, false, false
 
 	for _, def := range defs {
@@ -96,6 +102,30 @@ func (desc *EntityTypeDesc) DefineAttr(attr string, defs ...string) *EntityTypeD
 	return desc
 }
 
+// AllowInspectorCall allows methods to be called from the entity inspector of admin server
+func (desc *EntityTypeDesc) AllowInspectorCall(methods ...string) *EntityTypeDesc {
+	for _, method := range methods {
+		if _, ok := desc.rpcDescs[method]; !ok {
+			gwlog.Panicf("entity type %s: method %s is not defined", desc.entityType.Name(), method)
+		}
+		logger.Infof("        Inspector call %s", method)
+		desc.inspectorCalls.Add(method)
+	}
+	return desc
+}
+
+// IsInspectorCallAllowed returns if the method can be called from the entity inspector of admin server
+func (desc *EntityTypeDesc) IsInspectorCallAllowed(method string) bool {
+	return desc.inspectorCalls.Contains(method)
+}
+
+// GetInspectorCalls returns methods which can be called from the entity inspector of admin server
+func (desc *EntityTypeDesc) GetInspectorCalls() []string {
+	methods := desc.inspectorCalls.ToList()
+	sort.Strings(methods)
+	return methods
+}
+
 type _EntityManager struct {
 	entities       EntityMap
 	entitiesByType map[string]EntityMap
@@ -171,6 +201,7 @@ func RegisterEntity(typeName string, entity IEntity, isService bool) *EntityType
 		clientAttrs:     common.StringSet{},
 		allClientAttrs:  common.StringSet{},
 		persistentAttrs: common.StringSet{},
+		inspectorCalls:  common.StringSet{},
 		//compositiveMethodComponentIndices: map[string][]int{},
 	}
 	registeredEntityTypes[typeName] = entityTypeDesc
@@ -182,7 +213,7 @@ func RegisterEntity(typeName string, entity IEntity, isService bool) *EntityType
 		rpcDescs.visit(method)
 	}
 
-	gwlog.Infof(">>> RegisterEntity %s => %s <<<", typeName, entityType.Name())
+	logger.Infof(">>> RegisterEntity %s => %s <<<", typeName, entityType.Name())
 	//// define entity Attrs
 	entity.DescribeEntityType(entityTypeDesc)
 	return entityTypeDesc
@@ -226,8 +257,8 @@ func isEntityType(t reflect.Type) bool {
 //	ccRestore
 //)
 
-func createEntity(typeName string, space *Space, pos Vector3, entityID common.EntityID, data map[string]interface{}) *Entity {
-	//gwlog.Debugf("createEntity: %s in Space %s", typeName, space)
+func createEntity(typeName string, world string, space *Space, pos Vector3, entityID common.EntityID, data map[string]interface{}) *Entity {
+	//logger.Debugf("createEntity: %s in Space %s", typeName, space)
 	entityTypeDesc, ok := registeredEntityTypes[typeName]
 	if !ok {
 		gwlog.Panicf("unknown entity type: %s", typeName)
@@ -243,6 +274,7 @@ func createEntity(typeName string, space *Space, pos Vector3, entityID common.En
 	entityInstance = reflect.New(entityTypeDesc.entityType)
 	entity = reflect.Indirect(entityInstance).FieldByName("Entity").Addr().Interface().(*Entity)
 	entity.init(typeName, entityID, entityInstance)
+	entity.world = resolveWorld(typeName, world)
 	entity.Space = nilSpace
 
 	entityManager.put(entity)
@@ -259,13 +291,13 @@ func createEntity(typeName string, space *Space, pos Vector3, entityID common.En
 
 	dispatchercluster.SendNotifyCreateEntity(entityID)
 
-	gwlog.Debugf("Entity %s created.", entity)
+	logger.Debugf("Entity %s created.", entity)
 	gwutils.RunPanicless(func() {
 		entity.I.OnAttrsReady()
 		entity.I.OnCreated()
 	})
 
-	if space != nil {
+	if space != nil && entity.canEnterSpaceOfWorld(space) {
 		space.enter(entity, pos, false)
 	}
 
@@ -273,7 +305,7 @@ func createEntity(typeName string, space *Space, pos Vector3, entityID common.En
 }
 
 func restoreEntity(entityID common.EntityID, mdata *entityMigrateData, isRestore bool) {
-	//gwlog.Debugf("restoring entity %s: mdata=%+v, isRestore=%v", entityID, mdata, isRestore)
+	//logger.Debugf("restoring entity %s: mdata=%+v, isRestore=%v", entityID, mdata, isRestore)
 	typeName := mdata.Type
 	entityTypeDesc, ok := registeredEntityTypes[typeName]
 	if !ok {
@@ -286,6 +318,7 @@ func restoreEntity(entityID common.EntityID, mdata *entityMigrateData, isRestore
 	entityInstance = reflect.New(entityTypeDesc.entityType)
 	entity = reflect.Indirect(entityInstance).FieldByName("Entity").Addr().Interface().(*Entity)
 	entity.init(typeName, entityID, entityInstance)
+	entity.world = mdata.World
 	entity.Space = nilSpace
 	entity.Position = mdata.Pos
 	entity.yaw = mdata.Yaw
@@ -305,25 +338,36 @@ func restoreEntity(entityID common.EntityID, mdata *entityMigrateData, isRestore
 
 	entity.syncInfoFlag = mdata.SyncInfoFlag
 	entity.syncingFromClient = mdata.SyncingFromClient
+	entity.moveStrikes = mdata.MoveStrikes
+	entity.accountSession = mdata.AccountSession
 
 	if mdata.Client != nil {
 		client := MakeGameClient(mdata.Client.ClientID, mdata.Client.GateID)
+		client.sessionToken = mdata.Client.SessionToken
+		client.latency = mdata.Client.Latency
+		client.geoTag = mdata.Client.GeoTag
+		client.authID = mdata.Client.AuthID
 		// assign Client to the newly created
 		entity.assignClient(client) // assign Client quietly
 	}
 
-	gwlog.Debugf("Entity %s created, Client=%s", entity, entity.client)
+	if mdata.ClientSession != nil {
+		entity.suspendClientSession(mdata.ClientSession.Token, mdata.ClientSession.Timeout)
+	}
+
+	logger.Debugf("Entity %s created, Client=%s", entity, entity.client)
 	gwutils.RunPanicless(func() {
 		entity.I.OnAttrsReady()
 	})
 
 	if !isRestore {
+		entity.refreshAccountSession() // the session is held by the entity on this game now
 		gwutils.RunPanicless(func() {
 			entity.I.OnMigrateIn()
 		})
 	}
 	space := spaceManager.getSpace(mdata.SpaceID)
-	if space != nil {
+	if space != nil && entity.canEnterSpaceOfWorld(space) {
 		space.enter(entity, mdata.Pos, isRestore)
 	}
 
@@ -334,9 +378,10 @@ func restoreEntity(entityID common.EntityID, mdata *entityMigrateData, isRestore
 	}
 }
 
-func loadEntityLocally(typeName string, entityID common.EntityID, space *Space, pos Vector3) {
+func loadEntityLocally(typeName string, world string, entityID common.EntityID, space *Space, pos Vector3) {
 	// load the data from storage
-	storage.Load(typeName, entityID, func(_data interface{}, err error) {
+	world = resolveWorld(typeName, world)
+	storage.Load(storageTypeName(typeName, world), entityID, func(_data interface{}, err error) {
 		// callback runs in main routine
 		if err != nil {
 			dispatchercluster.SendNotifyDestroyEntity(entityID) // load entity failed, tell dispatcher
@@ -366,50 +411,69 @@ func loadEntityLocally(typeName string, entityID common.EntityID, space *Space,
 		for _, f := range removeFields {
 			delete(data, f)
 		}
-		createEntity(typeName, space, pos, entityID, data)
+		createEntity(typeName, world, space, pos, entityID, data)
 	})
 }
 
-func createEntitySomewhere(gameid uint16, typeName string, data map[string]interface{}) common.EntityID {
+func createEntitySomewhere(gameid uint16, typeName string, world string, data map[string]interface{}) common.EntityID {
 	entityid := common.GenEntityID()
-	dispatchercluster.SendCreateEntitySomewhere(gameid, entityid, typeName, data)
+	dispatchercluster.SendCreateEntitySomewhere(gameid, entityid, typeName, world, data)
 	return entityid
 }
 
 // CreateEntityLocally creates new entity in the local game
 func CreateEntityLocally(typeName string, data map[string]interface{}) *Entity {
-	return createEntity(typeName, nil, Vector3{}, "", data)
+	return createEntity(typeName, "", nil, Vector3{}, "", data)
 }
 
 // CreateEntityLocallyWithEntityID creates new entity in the local game with specified entity ID
 func CreateEntityLocallyWithID(typeName string, data map[string]interface{}, id common.EntityID) *Entity {
-	return createEntity(typeName, nil, Vector3{}, id, data)
+	return createEntity(typeName, "", nil, Vector3{}, id, data)
 }
 
 // CreateEntitySomewhere creates new entity in any game
 func CreateEntitySomewhere(gameid uint16, typeName string) common.EntityID {
-	return createEntitySomewhere(gameid, typeName, nil)
+	return createEntitySomewhere(gameid, typeName, "", nil)
 }
 
 // OnCreateEntitySomewhere is called when CreateEntitySomewhere chooses this game
-func OnCreateEntitySomewhere(entityid common.EntityID, typeName string, data map[string]interface{}) {
-	createEntity(typeName, nil, Vector3{}, entityid, data)
+func OnCreateEntitySomewhere(entityid common.EntityID, typeName string, world string, data map[string]interface{}) {
+	createEntity(typeName, world, nil, Vector3{}, entityid, data)
 }
 
 // OnLoadEntitySomewhere loads entity in the local game.
-func OnLoadEntitySomewhere(typeName string, entityID common.EntityID) {
-	loadEntityLocally(typeName, entityID, nil, Vector3{})
+func OnLoadEntitySomewhere(typeName string, entityID common.EntityID, world string) {
+	loadEntityLocally(typeName, world, entityID, nil, Vector3{})
 }
 
 // LoadEntityAnywhere loads entity in the any game
 //
 // LoadEntityAnywhere has no effect if entity already exists on any game
 func LoadEntityAnywhere(typeName string, entityID common.EntityID) {
-	dispatchercluster.SendLoadEntityAnywhere(typeName, entityID)
+	dispatchercluster.SendLoadEntityAnywhere(typeName, entityID, "")
 }
 
 func LoadEntityOnGame(typeName string, entityID common.EntityID, gameid uint16) {
-	dispatchercluster.SendLoadEntityOnGame(typeName, entityID, gameid)
+	dispatchercluster.SendLoadEntityOnGame(typeName, entityID, "", gameid)
+}
+
+// OnClientFlood is called by engine when Client is kicked by gate for flooding
+func OnClientFlood(ownerID common.EntityID, clientid common.ClientID, reason string) {
+	owner := entityManager.get(ownerID)
+	if owner != nil && owner.client != nil && owner.client.clientid == clientid {
+		gwutils.RunPanicless(func() {
+			owner.I.OnClientFlood(reason)
+		})
+	}
+}
+
+// OnClientLatency is called by engine when the latency of Client is measured by gate
+func OnClientLatency(ownerID common.EntityID, clientid common.ClientID, latency time.Duration) {
+	owner := entityManager.get(ownerID)
+	if owner != nil && owner.client != nil && owner.client.clientid == clientid {
+		owner.client.latency = latency
+		gwutils.RunPanicless(owner.I.OnClientLatencyChanged)
+	}
 }
 
 // OnClientDisconnected is called by engine when Client is disconnected
@@ -419,10 +483,10 @@ func OnClientDisconnected(ownerID common.EntityID, clientid common.ClientID) {
 		if owner.client != nil && owner.client.clientid == clientid {
 			owner.notifyClientDisconnected()
 		} else {
-			gwlog.Warnf("client %s is disconnected, but owner entity %s has client %s", clientid, owner, owner.client)
+			logger.Warnf("client %s is disconnected, but owner entity %s has client %s", clientid, owner, owner.client)
 		}
 	} else {
-		gwlog.Warnf("owner entity %s not found for client %s, might already be destroyed", ownerID, clientid)
+		logger.Warnf("owner entity %s not found for client %s, might already be destroyed", ownerID, clientid)
 	}
 }
 
@@ -455,6 +519,7 @@ func OnCallNilSpaces(method string, args [][]byte) {
 }
 
 func callRemote(id common.EntityID, method string, args []interface{}) {
+	atomic.AddUint64(&sentEntityRPCs, 1)
 	dispatchercluster.SelectByEntityID(id).SendCallEntityMethod(id, method, args)
 }
 
@@ -466,7 +531,7 @@ func OnCall(id common.EntityID, method string, args [][]byte, clientID common.Cl
 	if e == nil {
 		// entity not found, may destroyed before call
 		if method != lastWarnedOnCallMethod {
-			gwlog.Warnf("OnCall: entity %s is not found while calling %s", id, method)
+			logger.Warnf("OnCall: entity %s is not found while calling %s", id, method)
 			lastWarnedOnCallMethod = method
 		}
 
@@ -481,7 +546,7 @@ func OnSyncPositionYawFromClient(eid common.EntityID, x, y, z Coord, yaw Yaw) {
 	e := entityManager.get(eid)
 	if e == nil {
 		// entity not found, may destroyed before call
-		//gwlog.Errorf("OnSyncPositionYawFromClient: entity %s is not found", eid)
+		//logger.Errorf("OnSyncPositionYawFromClient: entity %s is not found", eid)
 		return
 	}
 
@@ -509,18 +574,36 @@ func OnGameTerminating() {
 	}
 }
 
+// NotifyShutdownOnClients notifies Clients of all entities that the game is shutting down after the countdown
+func NotifyShutdownOnClients(reason string, countdown time.Duration) {
+	for _, e := range entityManager.entities {
+		e.client.sendNotifyShutdown(reason, countdown)
+	}
+}
+
+// CountEnteringSpaceEntities returns the number of entities entering spaces, which might be migrating to other games
+func CountEnteringSpaceEntities() int {
+	n := 0
+	for _, e := range entityManager.entities {
+		if e.isEnteringSpace() {
+			n += 1
+		}
+	}
+	return n
+}
+
 var gameIsReady bool
 
 // OnGameReady is called when all games are connected to dispatcher cluster
 func OnGameReady() {
 	if gameIsReady {
-		gwlog.Warnf("all games connected, but not for the first time")
-		//gwlog.Warnf("registered services: %+v", entityManager.registeredServices)
+		logger.Warnf("all games connected, but not for the first time")
+		//logger.Warnf("registered services: %+v", entityManager.registeredServices)
 		return
 	}
 
 	gameIsReady = true
-	gwlog.Infof("all games connected, nil space = %s", nilSpace)
+	logger.Infof("all games connected, nil space = %s", nilSpace)
 	if nilSpace != nil {
 		nilSpace.I.OnGameReady()
 	}
@@ -528,7 +611,7 @@ func OnGameReady() {
 
 // OnGateDisconnected is called when gate is down
 func OnGateDisconnected(gateid uint16) {
-	gwlog.Warnf("Gate %d disconnected", gateid)
+	logger.Warnf("Gate %d disconnected", gateid)
 	entityManager.onGateDisconnected(gateid)
 }
 
@@ -615,11 +698,15 @@ func RestoreFreezedEntities(freeze *FreezeData) (err error) {
 				var client *GameClient
 				if info.Client != nil {
 					client = MakeGameClient(info.Client.ClientID, info.Client.GateID)
+					client.sessionToken = info.Client.SessionToken
+					client.latency = info.Client.Latency
+					client.geoTag = info.Client.GeoTag
+					client.authID = info.Client.AuthID
 					clients[eid] = client // save the Client to the map
 					info.Client = nil
 				}
 				restoreEntity(eid, info, true)
-				gwlog.Debugf("Restored %s<%s> in space %s", typeName, eid, space)
+				logger.Debugf("Restored %s<%s> in space %s", typeName, eid, space)
 			}
 		}
 	}
@@ -644,7 +731,7 @@ func RestoreFreezedEntities(freeze *FreezeData) (err error) {
 		if e != nil {
 			e.assignClient(client) // assign Client quietly if migrate
 		} else {
-			gwlog.Errorf("entity %s restore failed? can not set Client %s", eid, client)
+			logger.Errorf("entity %s restore failed? can not set Client %s", eid, client)
 		}
 	}
 
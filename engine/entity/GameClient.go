@@ -2,6 +2,8 @@ package entity
 
 import (
 	"fmt"
+	"sync/atomic"
+	"time"
 
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
@@ -14,9 +16,13 @@ import (
 //
 // Each entity can have at most one GameClient, and GameClient can be given to other entities
 type GameClient struct {
-	clientid common.ClientID
-	gateid   uint16
-	ownerid  common.EntityID
+	clientid     common.ClientID
+	gateid       uint16
+	ownerid      common.EntityID
+	sessionToken string
+	latency      time.Duration // round trip time measured by gate
+	geoTag       string        // GeoIP tag of the Client address
+	authID       string        // ID authenticated by gate
 }
 
 // MakeGameClient creates a GameClient object using Client ID and Game ID
@@ -27,6 +33,51 @@ func MakeGameClient(clientid common.ClientID, gateid uint16) *GameClient {
 	}
 }
 
+// SetSessionToken sets the token which the Client can use to resume its session after reconnecting
+func (client *GameClient) SetSessionToken(sessionToken string) {
+	client.sessionToken = sessionToken
+}
+
+// Latency returns the round trip time between gate and the Client
+//
+// Latency is 0 if not measured yet
+func (client *GameClient) Latency() time.Duration {
+	if client == nil {
+		return 0
+	}
+	return client.latency
+}
+
+// SetGeoTag sets the GeoIP tag of the Client address
+func (client *GameClient) SetGeoTag(geoTag string) {
+	client.geoTag = geoTag
+}
+
+// GeoTag returns the GeoIP tag of the Client address, which is looked up by gate using geoip_file
+//
+// GeoTag is "" if GeoIP is not configured or the Client address is not found
+func (client *GameClient) GeoTag() string {
+	if client == nil {
+		return ""
+	}
+	return client.geoTag
+}
+
+// SetAuthID sets the ID of the Client authenticated by gate
+func (client *GameClient) SetAuthID(authID string) {
+	client.authID = authID
+}
+
+// AuthID returns the ID of the Client authenticated by gate using auth_method (e.g. the subject of JWT)
+//
+// AuthID is "" if authentication is not enabled on gate
+func (client *GameClient) AuthID() string {
+	if client == nil {
+		return ""
+	}
+	return client.authID
+}
+
 func (client *GameClient) String() string {
 	if client == nil {
 		return "GameClient<nil>"
@@ -48,60 +99,79 @@ func (client *GameClient) sendCreateEntity(entity *Entity, isPlayer bool) {
 
 	pos := entity.Position
 	yaw := entity.yaw
-	client.selectDispatcher().SendCreateEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID, isPlayer,
-		clientData, float32(pos.X), float32(pos.Y), float32(pos.Z), float32(yaw))
+	client.sendForEntity(entity.ID, func(dc *dispatcherclient.DispatcherClient) {
+		dc.SendCreateEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID, isPlayer,
+			clientData, float32(pos.X), float32(pos.Y), float32(pos.Z), float32(yaw))
+	})
 }
 
 func (client *GameClient) sendDestroyEntity(entity *Entity) {
 	if client != nil {
-		client.selectDispatcher().SendDestroyEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID)
+		client.sendForEntity(entity.ID, func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendDestroyEntityOnClient(client.gateid, client.clientid, entity.TypeName, entity.ID)
+		})
 	}
 }
 
 func (client *GameClient) call(entityID common.EntityID, method string, args []interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendCallEntityMethodOnClient(client.gateid, client.clientid, entityID, method, args)
+		atomic.AddUint64(&sentClientRPCs, 1)
+		client.sendForEntity(entityID, func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendCallEntityMethodOnClient(client.gateid, client.clientid, entityID, method, args)
+		})
 	}
 }
 
 // sendNotifyMapAttrChange updates MapAttr change to Client entity
 func (client *GameClient) sendNotifyMapAttrChange(entityID common.EntityID, path []interface{}, key string, val interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrChangeOnClient(client.gateid, client.clientid, entityID, path, key, val)
+		client.sendForEntity(entityID, func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyMapAttrChangeOnClient(client.gateid, client.clientid, entityID, path, key, val)
+		})
 	}
 }
 
 // sendNotifyMapAttrDel updates MapAttr delete to Client entity
 func (client *GameClient) sendNotifyMapAttrDel(entityID common.EntityID, path []interface{}, key string) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrDelOnClient(client.gateid, client.clientid, entityID, path, key)
+		client.sendForEntity(entityID, func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyMapAttrDelOnClient(client.gateid, client.clientid, entityID, path, key)
+		})
 	}
 }
 
 func (client *GameClient) sendNotifyMapAttrClear(entityID common.EntityID, path []interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyMapAttrClearOnClient(client.gateid, client.clientid, entityID, path)
+		client.sendForEntity(entityID, func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyMapAttrClearOnClient(client.gateid, client.clientid, entityID, path)
+		})
 	}
 }
 
 // sendNotifyListAttrChange notifies Client of ListAttr item changing
 func (client *GameClient) sendNotifyListAttrChange(entityID common.EntityID, path []interface{}, index uint32, val interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrChangeOnClient(client.gateid, client.clientid, entityID, path, index, val)
+		client.sendForEntity(entityID, func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyListAttrChangeOnClient(client.gateid, client.clientid, entityID, path, index, val)
+		})
 	}
 }
 
 // sendNotifyListAttrPop notify Client of ListAttr popping
 func (client *GameClient) sendNotifyListAttrPop(entityID common.EntityID, path []interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrPopOnClient(client.gateid, client.clientid, entityID, path)
+		client.sendForEntity(entityID, func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyListAttrPopOnClient(client.gateid, client.clientid, entityID, path)
+		})
 	}
 }
 
 // sendNotifyListAttrAppend notify entity of ListAttr appending
 func (client *GameClient) sendNotifyListAttrAppend(entityID common.EntityID, path []interface{}, val interface{}) {
 	if client != nil {
-		client.selectDispatcher().SendNotifyListAttrAppendOnClient(client.gateid, client.clientid, entityID, path, val)
+		client.sendForEntity(entityID, func(dc *dispatcherclient.DispatcherClient) {
+			dc.SendNotifyListAttrAppendOnClient(client.gateid, client.clientid, entityID, path, val)
+		})
 	}
 }
 
@@ -111,6 +181,43 @@ func (client *GameClient) sendSetClientFilterProp(key, val string) {
 	}
 }
 
+func (client *GameClient) sendNotifySessionResumed(ownerID common.EntityID, ok bool) {
+	if client != nil {
+		client.selectDispatcher().SendNotifySessionResumedOnClient(client.gateid, client.clientid, ownerID, ok)
+	}
+}
+
+func (client *GameClient) sendRedirectToGate(targetGateID uint16) {
+	if client != nil {
+		client.selectDispatcher().SendRedirectClientToGate(client.gateid, client.clientid, targetGateID)
+	}
+}
+
+func (client *GameClient) sendKick(reason string) {
+	if client != nil {
+		client.selectDispatcher().SendKickClient(client.gateid, client.clientid, reason)
+	}
+}
+
+func (client *GameClient) sendNotifyShutdown(reason string, countdown time.Duration) {
+	if client != nil {
+		client.selectDispatcher().SendNotifyShutdownOnClient(client.gateid, client.clientid, reason, countdown)
+	}
+}
+
+// sendForEntity sends to the client using the dispatcher, and attributes the sent bytes to the entity if entity profiling is running
+func (client *GameClient) sendForEntity(entityID common.EntityID, send func(dc *dispatcherclient.DispatcherClient)) {
+	dc := client.selectDispatcher()
+	if profiler == nil {
+		send(dc)
+		return
+	}
+
+	sentBytes := dc.GetSentBytes()
+	send(dc)
+	profiler.addSyncBytes(entityID, dc.GetSentBytes()-sentBytes)
+}
+
 func (client *GameClient) selectDispatcher() *dispatcherclient.DispatcherClient {
 	if consts.DEBUG_MODE {
 		if client.ownerid == "" {
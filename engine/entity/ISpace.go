@@ -1,5 +1,7 @@
 package entity
 
+import "time"
+
 // ISpace is the space delegate interface
 //
 // User custom space class can override these functions for their own game logic
@@ -12,6 +14,8 @@ type ISpace interface {
 	// Space Operations
 	OnEntityEnterSpace(entity *Entity) // Called when any entity enters space
 	OnEntityLeaveSpace(entity *Entity) // Called when any entity leaves space
+	// Called when the Client moves the entity in space, returns false to reject the move
+	ValidateMove(entity *Entity, from, to Vector3, dt time.Duration) bool
 	// Game releated callbacks on nil space only
 	OnGameReady()
 }
@@ -2,12 +2,14 @@ package entity
 
 import (
 	"fmt"
+	"time"
 
 	"github.com/xiaonanln/go-aoi"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/simulation"
 )
 
 const (
@@ -30,7 +32,8 @@ type Space struct {
 	Kind     int
 	I        ISpace
 
-	aoiMgr aoi.AOIManager
+	aoiMgr   aoi.AOIManager
+	recorder *spaceRecorder // nil if the space is not recording
 }
 
 func (space *Space) String() string {
@@ -75,7 +78,7 @@ func (space *Space) OnCreated() {
 	//dispatcher_client.GetDispatcherClientForSend().SendNotifyCreateEntity(space.ID)
 	space.onSpaceCreated()
 	if space.IsNil() {
-		gwlog.Infof("nil space is created: %s, all games connected: %v", space, gameIsReady)
+		logger.Infof("nil space is created: %s, all games connected: %v", space, gameIsReady)
 		if gameIsReady {
 			space.I.OnGameReady()
 		}
@@ -83,7 +86,7 @@ func (space *Space) OnCreated() {
 	}
 
 	if consts.DEBUG_SPACES {
-		gwlog.Debugf("%s.OnCreated", space)
+		logger.Debugf("%s.OnCreated", space)
 	}
 	space.I.OnSpaceCreated()
 }
@@ -103,6 +106,7 @@ func (space *Space) EnableAOI(defaultAOIDistance Coord) {
 
 	space.Attrs.SetFloat(_SPACE_ENABLE_AOI_KEY, float64(defaultAOIDistance))
 	space.aoiMgr = aoi.NewXZListAOIManager(aoi.Coord(defaultAOIDistance))
+	aoiLogger.Debugf("%s: AOI enabled, default AOI distance=%v", space, defaultAOIDistance)
 	//space.aoiMgr = aoi.NewTowerAOIManager(-500, 500, -500, 500, 10)
 }
 
@@ -117,7 +121,7 @@ func (space *Space) EnableAOI(defaultAOIDistance Coord) {
 // OnRestored is called when space entity is restored
 func (space *Space) OnRestored() {
 	space.onSpaceCreated()
-	//gwlog.Debugf("space %s restored: atts=%+v", space, space.Attrs)
+	//logger.Debugf("space %s restored: atts=%+v", space, space.Attrs)
 	aoidist := space.GetFloat(_SPACE_ENABLE_AOI_KEY)
 	if aoidist > 0 {
 		space.EnableAOI(Coord(aoidist))
@@ -134,7 +138,7 @@ func (space *Space) onSpaceCreated() {
 		}
 		nilSpace = space
 		nilSpace.Space = nilSpace
-		gwlog.Infof("Created nil space: %s", nilSpace)
+		logger.Infof("Created nil space: %s", nilSpace)
 		return
 	}
 }
@@ -144,13 +148,16 @@ func (space *Space) onSpaceCreated() {
 // Custom space type can override to provide custom logic
 func (space *Space) OnSpaceCreated() {
 	if consts.DEBUG_SPACES {
-		gwlog.Debugf("Space %s created", space)
+		logger.Debugf("Space %s created", space)
 	}
 }
 
 // OnDestroy is called when Space entity is destroyed
 func (space *Space) OnDestroy() {
 	space.I.OnSpaceDestroy()
+	if space.recorder != nil {
+		space.StopRecording()
+	}
 	// destroy all entities
 	for e := range space.entities {
 		e.Destroy()
@@ -164,7 +171,7 @@ func (space *Space) OnDestroy() {
 // Custom space type can override to provide custom logic
 func (space *Space) OnSpaceDestroy() {
 	if consts.DEBUG_SPACES {
-		gwlog.Debugf("Space %s created", space)
+		logger.Debugf("Space %s created", space)
 	}
 }
 
@@ -173,21 +180,21 @@ func (space *Space) IsNil() bool {
 	return space.Kind == 0
 }
 
-// CreateEntity creates a new local entity in this space
+// CreateEntity creates a new local entity in this space, the entity is in the world of the space
 func (space *Space) CreateEntity(typeName string, pos Vector3) {
-	createEntity(typeName, space, pos, "", nil)
+	createEntity(typeName, space.world, space, pos, "", nil)
 }
 
 // LoadEntity loads a entity of specified entityID to the space
 //
 // If the entity already exists on server, this call has no effect
 func (space *Space) LoadEntity(typeName string, entityID common.EntityID, pos Vector3) {
-	loadEntityLocally(typeName, entityID, space, pos)
+	loadEntityLocally(typeName, space.world, entityID, space, pos)
 }
 
 func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 	if consts.DEBUG_SPACES {
-		gwlog.Debugf("%s.enter <<< %s, avatar count=%d, monster count=%d", space, entity, space.CountEntities("Avatar"), space.CountEntities("Monster"))
+		logger.Debugf("%s.enter <<< %s, avatar count=%d, monster count=%d", space, entity, space.CountEntities("Avatar"), space.CountEntities("Monster"))
 	}
 
 	if entity.Space != nilSpace {
@@ -201,6 +208,7 @@ func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 	entity.Space = space
 	space.entities.Add(entity)
 	entity.Position = pos
+	entity.lastMoveTime = simulation.Now()
 
 	entity.syncInfoFlag |= sifSyncOwnClient | sifSyncNeighborClients
 
@@ -208,6 +216,7 @@ func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 		entity.client.sendCreateEntity(&space.Entity, false) // create Space entity before every other entities
 
 		if space.aoiMgr != nil && entity.IsUseAOI() {
+			aoiLogger.Debugf("%s: %s enter AOI at %v", space, entity, pos)
 			space.aoiMgr.Enter(&entity.aoi, aoi.Coord(pos.X), aoi.Coord(pos.Z))
 		}
 
@@ -218,6 +227,7 @@ func (space *Space) enter(entity *Entity, pos Vector3, isRestore bool) {
 	} else {
 		// restoring ...
 		if space.aoiMgr != nil && entity.IsUseAOI() {
+			aoiLogger.Debugf("%s: %s enter AOI at %v (restoring)", space, entity, pos)
 			space.aoiMgr.Enter(&entity.aoi, aoi.Coord(pos.X), aoi.Coord(pos.Z))
 		}
 
@@ -240,6 +250,7 @@ func (space *Space) leave(entity *Entity) {
 	entity.Space = nilSpace
 
 	if space.aoiMgr != nil && entity.IsUseAOI() {
+		aoiLogger.Debugf("%s: %s leave AOI", space, entity)
 		space.aoiMgr.Leave(&entity.aoi)
 	}
 
@@ -251,13 +262,13 @@ func (space *Space) leave(entity *Entity) {
 }
 
 func (space *Space) move(entity *Entity, newPos Vector3) {
-	if space.aoiMgr == nil {
+	entity.Position = newPos
+	if space.aoiMgr == nil || !entity.IsUseAOI() {
 		return
 	}
 
-	entity.Position = newPos
 	space.aoiMgr.Moved(&entity.aoi, aoi.Coord(newPos.X), aoi.Coord(newPos.Z))
-	gwlog.Debugf("%s: %s move to %v", space, entity, newPos)
+	aoiLogger.Debugf("%s: %s move to %v", space, entity, newPos)
 }
 
 // OnEntityEnterSpace is called when entity enters space
@@ -265,7 +276,7 @@ func (space *Space) move(entity *Entity, newPos Vector3) {
 // Custom space type can override this function
 func (space *Space) OnEntityEnterSpace(entity *Entity) {
 	if consts.DEBUG_SPACES {
-		gwlog.Debugf("%s ENTER SPACE %s", entity, space)
+		logger.Debugf("%s ENTER SPACE %s", entity, space)
 	}
 }
 
@@ -274,10 +285,19 @@ func (space *Space) OnEntityEnterSpace(entity *Entity) {
 // Custom space type can override this function
 func (space *Space) OnEntityLeaveSpace(entity *Entity) {
 	if consts.DEBUG_SPACES {
-		gwlog.Debugf("%s LEAVE SPACE %s", entity, space)
+		logger.Debugf("%s LEAVE SPACE %s", entity, space)
 	}
 }
 
+// ValidateMove is called when the Client moves the entity in the space, dt is the duration since the last position change of
+// the entity. Returns false to reject the move, the Client is pulled back to the position on server.
+//
+// All moves are valid by default, override it to stop speed and teleport hacks, e.g. check from.DistanceTo(to) against
+// the max speed of the entity.
+func (space *Space) ValidateMove(entity *Entity, from, to Vector3, dt time.Duration) bool {
+	return true
+}
+
 // CountEntities returns the number of entities of specified type in space
 func (space *Space) CountEntities(typeName string) int {
 	count := 0
@@ -322,5 +342,5 @@ func (space *Space) addToAOI(entity *Entity) {
 
 // OnGameReady is called when the game server is ready on NilSpace only
 func (space *Space) OnGameReady() {
-	gwlog.Warnf("Game server is ready. Override function %T.OnGameReady to write your own game logic!", space.I)
+	logger.Warnf("Game server is ready. Override function %T.OnGameReady to write your own game logic!", space.I)
 }
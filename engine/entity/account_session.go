@@ -0,0 +1,255 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/accountsession"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+const (
+	// _ACCOUNT_SESSION_KICK_REASON is the reason of kicking older sessions of the account
+	_ACCOUNT_SESSION_KICK_REASON = "logged in elsewhere"
+	// _FIND_ONLINE_PLAYER_TIMEOUT is the timeout of finding online players at dispatchers
+	_FIND_ONLINE_PLAYER_TIMEOUT = time.Second * 10
+)
+
+// OnlinePlayer is the player online by the session of the account, which is held by the entity on the game, and whose
+// client is connected to the gate (GateID is 0 if the entity has no client)
+type OnlinePlayer = accountsession.OnlinePlayer
+
+// Events emitted to the event bus of all games when players log in or out, with *OnlinePlayer payloads
+const (
+	PlayerLoginEvent  = accountsession.LoginEvent
+	PlayerLogoutEvent = accountsession.LogoutEvent
+)
+
+var (
+	// ErrAccountSessionRejected is returned if the account has too many sessions and new sessions are rejected
+	ErrAccountSessionRejected = errors.New("too many sessions of the account")
+	// ErrAccountSessionKicked is returned if the session is kicked by a newer session before the claim is completed
+	ErrAccountSessionKicked = errors.New("account session kicked by a newer session")
+	// ErrFindOnlinePlayerTimeout is returned if the dispatcher does not respond the query of FindOnlinePlayer in time
+	ErrFindOnlinePlayerTimeout = errors.New("find online player timeout")
+
+	maxAccountSessions       int  // max sessions of each account, 0 for unlimited
+	kickOlderAccountSessions bool // older sessions are kicked if the account has too many sessions, or new sessions are rejected
+)
+
+type onlinePlayerQuery struct {
+	callback func(player *OnlinePlayer, err error)
+	timer    *timer.Timer
+}
+
+var (
+	onlinePlayerQueries     = map[uint32]*onlinePlayerQuery{} // request ID -> query
+	lastOnlinePlayerQueryID uint32
+)
+
+// accountSessionClaim is the claim of the account session waiting for the dispatcher to ack
+type accountSessionClaim struct {
+	account  string
+	callback func(err error)
+}
+
+// SetAccountSessionPolicy sets the concurrent login policy of accounts
+//
+// Each account can have at most maxSessions sessions, or unlimited sessions if maxSessions is 0. If the account has too
+// many sessions, the oldest sessions are kicked if kickOlder is true, otherwise new sessions are rejected.
+func SetAccountSessionPolicy(maxSessions int, kickOlder bool) {
+	maxAccountSessions = maxSessions
+	kickOlderAccountSessions = kickOlder
+	logger.Infof("Account session policy set to max sessions %d, kick older sessions %v", maxSessions, kickOlder)
+}
+
+// ClaimAccountSession claims the session of the account for the entity, e.g. after the player logs in
+//
+// Sessions of the account are coordinated by the dispatcher selected by the account, so that simultaneous logins on
+// different gates and games are enforced by the policy set by max_account_sessions and account_session_conflict. If
+// older sessions are kicked, OnAccountSessionKicked is called on their entities. The callback is called with nil if the
+// session is claimed, or ErrAccountSessionRejected if the account has too many sessions. The callback is not called
+// if the entity is destroyed or migrated before the claim is completed.
+//
+// The session is released when the entity is destroyed, or by ReleaseAccountSession. It is kept if the entity is
+// migrated. While the session is held, the player is online: it is found by FindOnlinePlayer on any game, and
+// PlayerLoginEvent and PlayerLogoutEvent are emitted to the event bus when the session is claimed and released, so
+// sessions should be held by player entities (e.g. the avatar instead of the account entity giving the client to it).
+func (e *Entity) ClaimAccountSession(account string, callback func(err error)) {
+	if account == "" {
+		callback(errors.Errorf("%s: account is empty", e))
+		return
+	}
+	if e.accountSession != "" && e.accountSession != account {
+		e.ReleaseAccountSession()
+	}
+
+	e.accountSessionClaim = &accountSessionClaim{account: account, callback: callback}
+	dispatchercluster.SendClaimAccountSession(account, e.ID, e.clientGateID(), maxAccountSessions, kickOlderAccountSessions, false)
+}
+
+// AccountSession returns the account whose session is held by the entity, or "" if the entity holds no session
+func (e *Entity) AccountSession() string {
+	return e.accountSession
+}
+
+// ReleaseAccountSession releases the session of the account held by the entity, e.g. when the player logs out
+func (e *Entity) ReleaseAccountSession() {
+	e.accountSessionClaim = nil
+	if e.accountSession == "" {
+		return
+	}
+
+	dispatchercluster.SendReleaseAccountSession(e.accountSession, e.ID)
+	e.accountSession = ""
+}
+
+// refreshAccountSession adds the session held by the entity to the dispatcher, without enforcing the policy, or updates
+// the game and the gate of the session
+func (e *Entity) refreshAccountSession() {
+	if e.accountSession != "" {
+		dispatchercluster.SendClaimAccountSession(e.accountSession, e.ID, e.clientGateID(), 0, false, true)
+	}
+}
+
+// updateAccountSessionGate refreshes the gate of the session held by the entity if its client is changed to another gate
+func (e *Entity) updateAccountSessionGate(oldGateID uint16) {
+	if e.accountSession != "" && e.clientGateID() != oldGateID {
+		e.refreshAccountSession()
+	}
+}
+
+func (e *Entity) clientGateID() uint16 {
+	if e.client == nil {
+		return 0
+	}
+	return e.client.gateid
+}
+
+// OnAccountSessionKicked is called when the session of the account held by the entity is kicked by a newer session
+//
+// The Client is kicked with the reason by default, and the suspended session of the disconnected Client is expired.
+// Can override this function in custom entity type, e.g. to save and destroy the player.
+func (e *Entity) OnAccountSessionKicked(reason string) {
+	logger.Infof("%s.OnAccountSessionKicked: %s", e, reason)
+	if e.client != nil {
+		e.KickClient(reason)
+	} else if e.IsClientSessionSuspended() {
+		e.discardClientSession()
+		e.I.OnClientDisconnected()
+	}
+}
+
+// OnClaimAccountSessionAck is called by engine when the claim of the account session is acked by the dispatcher
+func OnClaimAccountSessionAck(account string, eid common.EntityID, ok bool, kicked common.EntityIDSet) {
+	for kickedEid := range kicked {
+		dispatchercluster.SendKickAccountSession(kickedEid, account, _ACCOUNT_SESSION_KICK_REASON)
+	}
+
+	e := entityManager.get(eid)
+	if e == nil || e.accountSessionClaim == nil || e.accountSessionClaim.account != account {
+		// the entity is destroyed or migrated, or the claim is canceled, so the session should not be held
+		if ok && (e == nil || e.accountSession != account) {
+			dispatchercluster.SendReleaseAccountSession(account, eid)
+		}
+		return
+	}
+
+	claim := e.accountSessionClaim
+	e.accountSessionClaim = nil
+	if !ok {
+		gwutils.RunPanicless(func() {
+			claim.callback(ErrAccountSessionRejected)
+		})
+		return
+	}
+
+	e.accountSession = account
+	gwutils.RunPanicless(func() {
+		claim.callback(nil)
+	})
+}
+
+// OnKickAccountSession is called by engine when the session of the account held by the entity is kicked
+func OnKickAccountSession(eid common.EntityID, account string, reason string) {
+	e := entityManager.get(eid)
+	if e == nil {
+		return
+	}
+
+	if claim := e.accountSessionClaim; claim != nil && claim.account == account {
+		// kicked by a newer session before the claim is acked
+		e.accountSessionClaim = nil
+		if e.accountSession == account {
+			e.accountSession = ""
+		}
+		gwutils.RunPanicless(func() {
+			claim.callback(ErrAccountSessionKicked)
+		})
+		return
+	}
+
+	if e.accountSession != account {
+		return
+	}
+	e.accountSession = ""
+	gwutils.RunPanicless(func() {
+		e.I.OnAccountSessionKicked(reason)
+	})
+}
+
+// RefreshAccountSessions adds sessions held by entities to the dispatcher, in case the dispatcher is restarted
+func RefreshAccountSessions(dispid uint16) {
+	for _, e := range entityManager.entities {
+		if e.accountSession != "" && dispatchercluster.SrvIDToDispatcherID(e.accountSession) == dispid {
+			e.refreshAccountSession()
+		}
+	}
+}
+
+// FindOnlinePlayer finds the online player of the account, whose session is claimed by ClaimAccountSession
+//
+// The callback is called with the player of the latest session of the account, or nil if the account is offline. The
+// player is found at the dispatcher selected by the account, and ErrFindOnlinePlayerTimeout is returned if the
+// dispatcher does not respond in time.
+func FindOnlinePlayer(account string, callback func(player *OnlinePlayer, err error)) {
+	lastOnlinePlayerQueryID++
+	requestID := lastOnlinePlayerQueryID
+	query := &onlinePlayerQuery{callback: callback}
+	onlinePlayerQueries[requestID] = query
+	query.timer = timer.AddCallback(_FIND_ONLINE_PLAYER_TIMEOUT, func() {
+		if onlinePlayerQueries[requestID] == query {
+			delete(onlinePlayerQueries, requestID)
+			gwutils.RunPanicless(func() {
+				callback(nil, ErrFindOnlinePlayerTimeout)
+			})
+		}
+	})
+	dispatchercluster.SendQueryOnlinePlayer(account, requestID)
+}
+
+// OnQueryOnlinePlayerAck is called by engine when the dispatcher responds the query of FindOnlinePlayer
+func OnQueryOnlinePlayerAck(requestID uint32, data []byte) {
+	query := onlinePlayerQueries[requestID]
+	if query == nil {
+		return // timeout
+	}
+	delete(onlinePlayerQueries, requestID)
+	query.timer.Cancel()
+
+	var player *OnlinePlayer
+	var err error
+	if len(data) > 0 {
+		player = &OnlinePlayer{}
+		if err = netutil.MSG_PACKER.UnpackMsg(data, player); err != nil {
+			player, err = nil, errors.Wrap(err, "unpack online player failed")
+		}
+	}
+	gwutils.RunPanicless(func() {
+		query.callback(player, err)
+	})
+}
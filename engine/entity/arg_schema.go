@@ -0,0 +1,265 @@
+package entity
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"unicode/utf8"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+type argKind int
+
+const (
+	argAny argKind = iota
+	argInt
+	argFloat
+	argString
+	argBool
+	argEnum
+	argList
+)
+
+// ArgSchema is the schema of an argument of methods callable by clients, see EntityTypeDesc.DefineArgs
+type ArgSchema struct {
+	kind               argKind
+	minInt, maxInt     int64   // range of integers
+	minFloat, maxFloat float64 // range of floats
+	minLen, maxLen     int     // range of lengths of strings (in characters) and lists
+	enum               []interface{}
+	elem               *ArgSchema // schema of list elements
+}
+
+// AnyArg accepts any argument
+func AnyArg() ArgSchema {
+	return ArgSchema{kind: argAny}
+}
+
+// IntArg accepts integers in [min, max]
+func IntArg(min, max int64) ArgSchema {
+	return ArgSchema{kind: argInt, minInt: min, maxInt: max}
+}
+
+// FloatArg accepts numbers in [min, max], NaN is not accepted
+func FloatArg(min, max float64) ArgSchema {
+	return ArgSchema{kind: argFloat, minFloat: min, maxFloat: max}
+}
+
+// StringArg accepts strings of [minLen, maxLen] characters
+func StringArg(minLen, maxLen int) ArgSchema {
+	return ArgSchema{kind: argString, minLen: minLen, maxLen: maxLen}
+}
+
+// BoolArg accepts bools
+func BoolArg() ArgSchema {
+	return ArgSchema{kind: argBool}
+}
+
+// EnumArg accepts strings or numbers in values
+func EnumArg(values ...interface{}) ArgSchema {
+	enum := make([]interface{}, len(values))
+	for i, v := range values {
+		enum[i] = normalizeEnumValue(reflect.ValueOf(v))
+		if enum[i] == nil {
+			gwlog.Panicf("enum value should be string or number, but is %T", v)
+		}
+	}
+	return ArgSchema{kind: argEnum, enum: enum}
+}
+
+// ListArg accepts lists of at most maxLen elements, and all elements should be accepted by elem
+func ListArg(maxLen int, elem ArgSchema) ArgSchema {
+	return ArgSchema{kind: argList, maxLen: maxLen, elem: &elem}
+}
+
+func (s ArgSchema) String() string {
+	switch s.kind {
+	case argInt:
+		return fmt.Sprintf("int[%d, %d]", s.minInt, s.maxInt)
+	case argFloat:
+		return fmt.Sprintf("float[%v, %v]", s.minFloat, s.maxFloat)
+	case argString:
+		return fmt.Sprintf("string[%d, %d]", s.minLen, s.maxLen)
+	case argBool:
+		return "bool"
+	case argEnum:
+		return fmt.Sprintf("enum%v", s.enum)
+	case argList:
+		return fmt.Sprintf("list[%d]<%s>", s.maxLen, s.elem)
+	default:
+		return "any"
+	}
+}
+
+// checkType checks if arguments of the type can be accepted by the schema
+func (s ArgSchema) checkType(t reflect.Type) error {
+	kind := t.Kind()
+	if kind == reflect.Interface || s.kind == argAny {
+		return nil
+	}
+
+	ok := false
+	switch s.kind {
+	case argInt:
+		ok = isIntKind(kind) || isUintKind(kind)
+	case argFloat, argEnum:
+		ok = isIntKind(kind) || isUintKind(kind) || kind == reflect.Float32 || kind == reflect.Float64 ||
+			(s.kind == argEnum && kind == reflect.String)
+	case argString:
+		ok = kind == reflect.String
+	case argBool:
+		ok = kind == reflect.Bool
+	case argList:
+		if kind == reflect.Slice || kind == reflect.Array {
+			return s.elem.checkType(t.Elem())
+		}
+	}
+	if !ok {
+		return errors.Errorf("schema %s can not be applied to %s", s, t)
+	}
+	return nil
+}
+
+// validate returns the error if the argument is not accepted by the schema
+func (s ArgSchema) validate(v reflect.Value) error {
+	if v.Kind() == reflect.Interface {
+		v = v.Elem()
+	}
+	if s.kind == argAny {
+		return nil
+	}
+	if !v.IsValid() {
+		return errors.Errorf("nil is not %s", s)
+	}
+
+	kind := v.Kind()
+	switch s.kind {
+	case argInt:
+		var n int64
+		if isIntKind(kind) {
+			n = v.Int()
+		} else if isUintKind(kind) && v.Uint() <= math.MaxInt64 {
+			n = int64(v.Uint())
+		} else if (kind == reflect.Float32 || kind == reflect.Float64) && v.Float() == math.Trunc(v.Float()) &&
+			math.Abs(v.Float()) < math.MaxInt64 {
+			n = int64(v.Float()) // integers may be decoded as floats if the argument type is interface{}
+		} else {
+			return errors.Errorf("%v is not %s", v, s)
+		}
+		if n < s.minInt || n > s.maxInt {
+			return errors.Errorf("%d is not %s", n, s)
+		}
+	case argFloat:
+		var f float64
+		if isIntKind(kind) {
+			f = float64(v.Int())
+		} else if isUintKind(kind) {
+			f = float64(v.Uint())
+		} else if kind == reflect.Float32 || kind == reflect.Float64 {
+			f = v.Float()
+		} else {
+			return errors.Errorf("%v is not %s", v, s)
+		}
+		if !(f >= s.minFloat && f <= s.maxFloat) {
+			return errors.Errorf("%v is not %s", f, s)
+		}
+	case argString:
+		if kind != reflect.String {
+			return errors.Errorf("%v is not %s", v, s)
+		}
+		if n := utf8.RuneCountInString(v.String()); n < s.minLen || n > s.maxLen {
+			return errors.Errorf("string of %d characters is not %s", n, s)
+		}
+	case argBool:
+		if kind != reflect.Bool {
+			return errors.Errorf("%v is not %s", v, s)
+		}
+	case argEnum:
+		value := normalizeEnumValue(v)
+		for _, allowed := range s.enum {
+			if value != nil && value == allowed {
+				return nil
+			}
+		}
+		return errors.Errorf("%v is not %s", v, s)
+	case argList:
+		if kind != reflect.Slice && kind != reflect.Array {
+			return errors.Errorf("%v is not %s", v, s)
+		}
+		if v.Len() > s.maxLen {
+			return errors.Errorf("list of %d elements is not %s", v.Len(), s)
+		}
+		for i := 0; i < v.Len(); i++ {
+			if err := s.elem.validate(v.Index(i)); err != nil {
+				return errors.Wrapf(err, "element %d", i)
+			}
+		}
+	}
+	return nil
+}
+
+// normalizeEnumValue converts strings to string and numbers to float64 for comparing enum values, or returns nil for
+// other values
+func normalizeEnumValue(v reflect.Value) interface{} {
+	switch kind := v.Kind(); {
+	case kind == reflect.String:
+		return v.String()
+	case isIntKind(kind):
+		return float64(v.Int())
+	case isUintKind(kind):
+		return float64(v.Uint())
+	case kind == reflect.Float32 || kind == reflect.Float64:
+		return v.Float()
+	default:
+		return nil
+	}
+}
+
+func isIntKind(kind reflect.Kind) bool {
+	return kind >= reflect.Int && kind <= reflect.Int64
+}
+
+func isUintKind(kind reflect.Kind) bool {
+	return kind >= reflect.Uint && kind <= reflect.Uint64
+}
+
+// DefineArgs defines schemas of leading arguments of the method callable by clients
+//
+// Calls from clients with arguments not accepted by schemas are rejected and logged before the method is called, e.g.
+//
+//	desc.DefineArgs("CastSkill", entity.EnumArg("fireball", "heal"), entity.IntArg(1, 10))
+//	desc.DefineArgs("Chat", entity.StringArg(1, 200))
+//	desc.DefineArgs("Equip", entity.ListArg(8, entity.IntArg(0, 999)))
+func (desc *EntityTypeDesc) DefineArgs(method string, schemas ...ArgSchema) *EntityTypeDesc {
+	rpcDesc, ok := desc.rpcDescs[method]
+	if !ok {
+		gwlog.Panicf("entity type %s: method %s is not defined", desc.entityType.Name(), method)
+	}
+	if rpcDesc.Flags&(rfOwnClient|rfOtherClient) == 0 {
+		gwlog.Panicf("entity type %s: method %s is not callable by clients", desc.entityType.Name(), method)
+	}
+	if len(schemas) > rpcDesc.NumArgs {
+		gwlog.Panicf("entity type %s: method %s receives %d arguments, but %d schemas are defined", desc.entityType.Name(), method, rpcDesc.NumArgs, len(schemas))
+	}
+	for i, schema := range schemas {
+		if err := schema.checkType(rpcDesc.MethodType.In(i + 1)); err != nil {
+			gwlog.Panicf("entity type %s: argument %d of method %s: %s", desc.entityType.Name(), i+1, method, err)
+		}
+	}
+
+	logger.Infof("        Args of %s = %v", method, schemas)
+	rpcDesc.ArgSchemas = schemas
+	return desc
+}
+
+// validateArgs returns the error if arguments are not accepted by schemas of the method
+func (rd *rpcDesc) validateArgs(in []reflect.Value) error {
+	for i, schema := range rd.ArgSchemas {
+		if err := schema.validate(in[i]); err != nil {
+			return errors.Wrapf(err, "argument %d", i+1)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+package entity
+
+import (
+	"math"
+	"reflect"
+	"testing"
+)
+
+func TestArgSchemaValidate(t *testing.T) {
+	for _, c := range []struct {
+		schema ArgSchema
+		arg    interface{}
+		ok     bool
+	}{
+		{IntArg(1, 10), 5, true},
+		{IntArg(1, 10), uint8(10), true},
+		{IntArg(1, 10), 0, false},
+		{IntArg(1, 10), float64(3), true}, // integers decoded as floats
+		{IntArg(1, 10), 3.5, false},
+		{IntArg(1, 10), "3", false},
+		{FloatArg(0, 1), 0.5, true},
+		{FloatArg(0, 1), 1, true},
+		{FloatArg(0, 1), 1.5, false},
+		{FloatArg(0, 1), math.NaN(), false},
+		{StringArg(1, 3), "abc", true},
+		{StringArg(1, 3), "中文字", true},
+		{StringArg(1, 3), "", false},
+		{StringArg(1, 3), "abcd", false},
+		{BoolArg(), true, true},
+		{BoolArg(), 1, false},
+		{EnumArg("fireball", "heal"), "heal", true},
+		{EnumArg("fireball", "heal"), "revive", false},
+		{EnumArg(1, 2, 3), int32(2), true},
+		{EnumArg(1, 2, 3), 2.0, true},
+		{EnumArg(1, 2, 3), "2", false},
+		{ListArg(2, IntArg(0, 9)), []int{1, 9}, true},
+		{ListArg(2, IntArg(0, 9)), []int{1, 2, 3}, false},
+		{ListArg(2, IntArg(0, 9)), []interface{}{1, "x"}, false},
+		{ListArg(2, IntArg(0, 9)), "not a list", false},
+		{AnyArg(), nil, true},
+		{IntArg(1, 10), nil, false},
+	} {
+		v := reflect.ValueOf(&c.arg).Elem() // arguments of interface{} type
+		if err := c.schema.validate(v); (err == nil) != c.ok {
+			t.Errorf("%s should accept %#v: %v, but got %v", c.schema, c.arg, c.ok, err)
+		}
+	}
+}
+
+func TestArgSchemaCheckType(t *testing.T) {
+	for _, c := range []struct {
+		schema ArgSchema
+		arg    interface{}
+		ok     bool
+	}{
+		{IntArg(1, 10), int(0), true},
+		{IntArg(1, 10), "", false},
+		{FloatArg(0, 1), float32(0), true},
+		{StringArg(1, 3), 0, false},
+		{EnumArg("a"), "", true},
+		{BoolArg(), "", false},
+		{ListArg(2, IntArg(0, 9)), []int{}, true},
+		{ListArg(2, IntArg(0, 9)), []string{}, false},
+		{ListArg(2, IntArg(0, 9)), 0, false},
+		{AnyArg(), struct{}{}, true},
+	} {
+		if err := c.schema.checkType(reflect.TypeOf(c.arg)); (err == nil) != c.ok {
+			t.Errorf("%s should be applied to %T: %v, but got %v", c.schema, c.arg, c.ok, err)
+		}
+	}
+
+	var iface interface{}
+	if err := StringArg(1, 3).checkType(reflect.TypeOf(&iface).Elem()); err != nil {
+		t.Errorf("schemas should be applied to interface{}: %v", err)
+	}
+}
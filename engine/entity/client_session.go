@@ -0,0 +1,138 @@
+package entity
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+var (
+	sessionResumeTimeout time.Duration
+)
+
+// clientSession is the session of a disconnected Client which can be resumed before expired
+type clientSession struct {
+	token      string
+	expireTime time.Time
+	timer      rawTimer
+}
+
+type clientSessionData struct {
+	Token   string
+	Timeout time.Duration
+}
+
+// SetSessionResumeTimeout sets how long the session of disconnected clients is kept for resuming
+//
+// Session resuming is disabled if timeout is 0
+func SetSessionResumeTimeout(timeout time.Duration) {
+	sessionResumeTimeout = timeout
+	logger.Infof("Session resume timeout set to %s", sessionResumeTimeout)
+}
+
+// IsClientSessionSuspended returns if the Client is disconnected, but the session is waiting for the Client to resume
+func (e *Entity) IsClientSessionSuspended() bool {
+	return e.clientSession != nil
+}
+
+func (e *Entity) suspendClientSession(token string, timeout time.Duration) {
+	if consts.DEBUG_CLIENTS {
+		logger.Debugf("%s: client session suspended for %s", e, timeout)
+	}
+
+	e.clientSession = &clientSession{
+		token:      token,
+		expireTime: time.Now().Add(timeout),
+	}
+	e.clientSession.timer = e.addRawCallback(timeout, e.expireClientSession)
+}
+
+func (e *Entity) expireClientSession() {
+	if e.clientSession == nil {
+		return
+	}
+
+	if consts.DEBUG_CLIENTS {
+		logger.Debugf("%s: client session expired", e)
+	}
+	e.clientSession = nil
+	gwutils.RunPanicless(e.I.OnClientDisconnected)
+}
+
+// discardClientSession discards the suspended session quietly
+func (e *Entity) discardClientSession() {
+	if e.clientSession == nil {
+		return
+	}
+
+	if e.rawTimers != nil {
+		e.cancelRawTimer(e.clientSession.timer)
+	}
+	e.clientSession = nil
+}
+
+func (e *Entity) canResumeClientSession(token string) bool {
+	if token == "" {
+		return false
+	}
+
+	if e.clientSession != nil {
+		return e.clientSession.token == token
+	}
+
+	// the old connection might not be detected as disconnected yet
+	return e.client != nil && e.client.sessionToken == token
+}
+
+func (e *Entity) resumeClientSession(client *GameClient) {
+	e.discardClientSession()
+	e.assignClient(client)
+	e.updateAccountSessionGate(0)
+	// resync all entities to the new client
+	e.sendCreateEntitiesToClient(client)
+	client.sendNotifySessionResumed(e.ID, true)
+
+	gwutils.RunPanicless(e.I.OnClientResumed)
+}
+
+func (e *Entity) getClientSessionData() *clientSessionData {
+	if e.clientSession == nil {
+		return nil
+	}
+
+	timeout := e.clientSession.expireTime.Sub(time.Now())
+	if timeout < 0 {
+		timeout = 0
+	}
+	return &clientSessionData{
+		Token:   e.clientSession.token,
+		Timeout: timeout,
+	}
+}
+
+// OnClientResumed is called when the disconnected Client reconnects and resumes its session
+//
+// Can override this function in custom entity type
+func (e *Entity) OnClientResumed() {
+	if consts.DEBUG_CLIENTS {
+		logger.Debugf("%s.OnClientResumed: %s", e, e.client)
+	}
+}
+
+// OnResumeClientSession is called by engine when a reconnected Client requests to resume its session on the owner entity
+func OnResumeClientSession(ownerID common.EntityID, token string, client *GameClient, bootEntityID common.EntityID) {
+	owner := entityManager.get(ownerID)
+	if owner == nil || !owner.canResumeClientSession(token) {
+		logger.Warnf("client %s can not resume session of %s", client, ownerID)
+		client.ownerid = bootEntityID // the client is still owned by the boot entity
+		client.sendNotifySessionResumed(ownerID, false)
+		return
+	}
+
+	owner.resumeClientSession(client)
+	// the boot entity created for the reconnected client loses its client
+	dispatchercluster.SelectByEntityID(bootEntityID).SendNotifyClientDisconnected(client.clientid, bootEntityID)
+}
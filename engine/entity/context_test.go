@@ -0,0 +1,33 @@
+package entity
+
+import (
+	"testing"
+)
+
+type TestContextEntity struct {
+	Entity
+}
+
+func (e *TestContextEntity) DescribeEntityType(*EntityTypeDesc) {
+}
+
+func TestEntityContext(t *testing.T) {
+	RegisterEntity("TestContextEntity", &TestContextEntity{}, false)
+	e := CreateEntityLocally("TestContextEntity", nil)
+	ctx := e.Context()
+	if ctx.Err() != nil || e.Context() != ctx {
+		t.Fatalf("context of alive entity should not be done")
+	}
+
+	e.destroyed = true // simulate destroying the entity, which requires the space
+	e.cancelCtx()
+	if ctx.Err() == nil {
+		t.Errorf("context should be canceled when the entity is destroyed")
+	}
+
+	e = CreateEntityLocally("TestContextEntity", nil)
+	e.destroyed = true
+	if e.Context().Err() == nil {
+		t.Errorf("context of destroyed entity should be canceled")
+	}
+}
@@ -0,0 +1,222 @@
+package entity
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime/debug"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/storage"
+)
+
+const (
+	// CrashDumpTypeName is the type name of crash dumps saved to entity storage
+	CrashDumpTypeName = "_CrashDump"
+
+	_CRASH_DUMP_TIME_FORMAT    = "20060102-150405.000"
+	_CRASH_DUMP_REPEAT_TIMEOUT = time.Minute // crash dumps of the same method are written at most once per minute
+)
+
+var (
+	crashDumpComponent string
+	crashDumpDir       string // crash dumps are not written to files if empty
+	crashDumpToStorage bool
+	runningCalls       []runningCall            // entity method calls running in the game routine, innermost last
+	lastCrashDumpTimes = map[string]time.Time{} // crash dump key -> time of the last crash dump
+	dumpedPanic        interface{}              // the panic already dumped by the entity method, which is re-panicked to outer handlers
+)
+
+type runningCall struct {
+	entity    *Entity
+	kind      string
+	method    string
+	startTime time.Time     // zero if entity profiling is not running when the call starts
+	childTime time.Duration // time of nested calls, for entity profiling
+}
+
+// CrashDump is the dump of panic with the context of entity method calls, for post-mortem analysis
+type CrashDump struct {
+	Component string           `json:"component"`
+	Time      time.Time        `json:"time"`
+	Panic     string           `json:"panic"`
+	Stack     string           `json:"stack"`
+	Entity    *CrashDumpEntity `json:"entity"`  // the entity running the panicking method, nil if no entity method is running
+	Callers   []CrashDumpCall  `json:"callers"` // outer entity method calls, outermost first
+}
+
+// CrashDumpEntity is the snapshot of entity when its method panics
+type CrashDumpEntity struct {
+	ID     common.EntityID        `json:"id"`
+	Type   string                 `json:"type"`
+	Kind   string                 `json:"kind"` // rpc or timer
+	Method string                 `json:"method"`
+	Space  common.EntityID        `json:"space"`
+	Attrs  map[string]interface{} `json:"attrs"`
+}
+
+// CrashDumpCall is the entity method call which is running when the panic occurs
+type CrashDumpCall struct {
+	ID     common.EntityID `json:"id"`
+	Type   string          `json:"type"`
+	Method string          `json:"method"`
+}
+
+// EnableCrashDump enables crash dumps of panics in the game routine
+//
+// Crash dumps are written to JSON files in dir if dir is not empty, and saved to entity storage as CrashDumpTypeName if toStorage is true.
+func EnableCrashDump(component string, dir string, toStorage bool) {
+	crashDumpComponent = component
+	crashDumpDir = dir
+	crashDumpToStorage = toStorage
+	if dir != "" {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			logger.Errorf("create crash dump dir %s failed: %s", dir, err)
+		}
+	}
+}
+
+func isCrashDumpEnabled() bool {
+	return crashDumpDir != "" || crashDumpToStorage
+}
+
+func pushRunningCall(e *Entity, kind string, method string) {
+	if len(runningCalls) == 0 {
+		dumpedPanic = nil // the dumped panic was recovered without reaching the panic handler
+	}
+	call := runningCall{entity: e, kind: kind, method: method}
+	if profiler != nil {
+		call.startTime = time.Now()
+	}
+	runningCalls = append(runningCalls, call)
+}
+
+func popRunningCall() {
+	n := len(runningCalls)
+	call := runningCalls[n-1]
+	runningCalls = runningCalls[:n-1]
+
+	if profiler != nil && !call.startTime.IsZero() {
+		elapsed := time.Since(call.startTime)
+		profiler.addCall(call.entity, elapsed-call.childTime)
+		if n > 1 {
+			runningCalls[n-2].childTime += elapsed
+		}
+	}
+}
+
+// DumpCrash writes the crash dump of the panic in the game routine, with the context of the running entity method
+//
+// It should be called in the deferred function recovering the panic, so that the stack of the panic is dumped.
+func DumpCrash(err interface{}) {
+	if !isCrashDumpEnabled() {
+		return
+	}
+	if isDumpedPanic(err) {
+		// the panic is re-panicked by entity methods after dumped, and outer handlers should not dump it again
+		return
+	}
+
+	dump := &CrashDump{
+		Component: crashDumpComponent,
+		Time:      time.Now(),
+		Panic:     fmt.Sprint(err),
+		Stack:     string(debug.Stack()),
+		Callers:   []CrashDumpCall{},
+	}
+
+	key := ""
+	if n := len(runningCalls); n > 0 {
+		call := runningCalls[n-1]
+		dump.Entity = newCrashDumpEntity(call)
+		for _, caller := range runningCalls[:n-1] {
+			dump.Callers = append(dump.Callers, CrashDumpCall{caller.entity.ID, caller.entity.TypeName, caller.method})
+		}
+		key = call.entity.TypeName + "." + call.method
+	}
+
+	// avoid flooding dumps if the same method keeps panicking
+	if lastTime, ok := lastCrashDumpTimes[key]; ok && dump.Time.Sub(lastTime) < _CRASH_DUMP_REPEAT_TIMEOUT {
+		return
+	}
+	lastCrashDumpTimes[key] = dump.Time
+
+	if crashDumpDir != "" {
+		if file, err := writeCrashDumpFile(dump); err != nil {
+			logger.Errorf("write crash dump failed: %s", err)
+		} else {
+			logger.Errorf("crash dump is written to %s", file)
+		}
+	}
+	if crashDumpToStorage {
+		saveCrashDump(dump)
+	}
+}
+
+// markPanicDumped marks the panic as dumped, before it is re-panicked to outer handlers
+func markPanicDumped(err interface{}) {
+	dumpedPanic = err
+}
+
+func isDumpedPanic(err interface{}) bool {
+	if dumpedPanic == nil || err == nil || !reflect.TypeOf(err).Comparable() {
+		return false
+	}
+	return err == dumpedPanic
+}
+
+func newCrashDumpEntity(call runningCall) (de *CrashDumpEntity) {
+	e := call.entity
+	de = &CrashDumpEntity{
+		ID:     e.ID,
+		Type:   e.TypeName,
+		Kind:   call.kind,
+		Method: call.method,
+	}
+	if e.Space != nil {
+		de.Space = e.Space.ID
+	}
+
+	defer func() {
+		if err := recover(); err != nil { // attrs might be corrupted by the panicking method
+			logger.Errorf("snapshot attrs of %s failed: %v", e, err)
+		}
+	}()
+	de.Attrs = e.Attrs.ToMap()
+	return
+}
+
+func writeCrashDumpFile(dump *CrashDump) (string, error) {
+	data, err := json.MarshalIndent(dump, "", "  ")
+	if err != nil {
+		return "", err
+	}
+
+	name := fmt.Sprintf("crash-%s-%s", crashDumpComponent, dump.Time.Format(_CRASH_DUMP_TIME_FORMAT))
+	if dump.Entity != nil {
+		name += "-" + string(dump.Entity.ID)
+	}
+	file := filepath.Join(crashDumpDir, name+".json")
+	return file, ioutil.WriteFile(file, data, 0644)
+}
+
+func saveCrashDump(dump *CrashDump) {
+	// storage backends expect map data
+	var data map[string]interface{}
+	if b, err := json.Marshal(dump); err != nil {
+		logger.Errorf("marshal crash dump failed: %s", err)
+		return
+	} else if err := json.Unmarshal(b, &data); err != nil {
+		logger.Errorf("unmarshal crash dump failed: %s", err)
+		return
+	}
+
+	dumpID := common.GenEntityID()
+	storage.Save(CrashDumpTypeName, dumpID, data, func() {
+		logger.Errorf("crash dump is saved to storage: %s.%s", CrashDumpTypeName, dumpID)
+	})
+}
@@ -0,0 +1,85 @@
+package entity
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestDumpCrash(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	EnableCrashDump("game1", dir, false)
+	defer EnableCrashDump("", "", false)
+
+	e := &Entity{ID: "TestCrashDumpEnt", TypeName: "Avatar", Attrs: NewMapAttr()}
+	e.Attrs.SetStr("name", "test")
+	pushRunningCall(e, "rpc", "Crash")
+	func() {
+		defer func() {
+			DumpCrash(recover())
+		}()
+		panic("crashed")
+	}()
+	popRunningCall()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "crash-game1-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("there should be 1 crash dump, but found %v", files)
+	}
+	data, err := ioutil.ReadFile(files[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var dump CrashDump
+	if err := json.Unmarshal(data, &dump); err != nil {
+		t.Fatal(err)
+	}
+	if dump.Panic != "crashed" || !strings.Contains(dump.Stack, "TestDumpCrash") {
+		t.Errorf("wrong panic or stack: %s\n%s", dump.Panic, dump.Stack)
+	}
+	if dump.Entity == nil || dump.Entity.ID != e.ID || dump.Entity.Method != "Crash" || dump.Entity.Attrs["name"] != "test" {
+		t.Errorf("wrong entity of crash dump: %+v", dump.Entity)
+	}
+}
+
+func TestDumpCrashOnce(t *testing.T) {
+	dir, err := ioutil.TempDir("", "crashdump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	EnableCrashDump("game1", dir, false)
+	defer EnableCrashDump("", "", false)
+
+	e := &Entity{ID: "TestCrashDumpOnce", TypeName: "Avatar", Attrs: NewMapAttr()}
+	func() {
+		defer func() {
+			DumpCrash(recover()) // the panic handler of the game routine
+		}()
+
+		// the entity method dumps and re-panics, as callMethod does
+		pushRunningCall(e, "rpc", "CrashOnce")
+		defer func() {
+			err := recover()
+			DumpCrash(err)
+			markPanicDumped(err)
+			popRunningCall()
+			panic(err)
+		}()
+		panic("crashed once")
+	}()
+
+	files, _ := filepath.Glob(filepath.Join(dir, "crash-game1-*.json"))
+	if len(files) != 1 {
+		t.Fatalf("there should be 1 crash dump, but found %v", files)
+	}
+}
@@ -0,0 +1,185 @@
+package entity
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/rbac"
+)
+
+// GMHandler executes the GM command on the target entity with arguments, and returns the output shown to the GM
+type GMHandler func(target *Entity, args []string) (string, error)
+
+type gmCommand struct {
+	name       string
+	handler    GMHandler
+	permission string
+}
+
+var (
+	// ErrUnknownGMCommand is returned if the GM command is not registered
+	ErrUnknownGMCommand = errors.New("unknown GM command")
+
+	gmCommands = map[string]*gmCommand{}
+)
+
+// RegisterGMCommand registers the GM command which can be run from chat, the admin API or the CLI
+//
+// permission is the action in [rbac] config which users should be allowed to run the command, or "gm:<name>" if empty.
+func RegisterGMCommand(name string, handler GMHandler, permission string) {
+	if name == "" || strings.ContainsAny(name, " \t\"@/") {
+		logger.Panicf("invalid GM command name: %#v", name)
+	}
+	if _, ok := gmCommands[name]; ok {
+		logger.Panicf("GM command %s is already registered", name)
+	}
+	if permission == "" {
+		permission = "gm:" + name
+	}
+	gmCommands[name] = &gmCommand{name: name, handler: handler, permission: permission}
+	logger.Infof("GM command %s registered with permission %s", name, permission)
+}
+
+// GetGMCommands returns names of registered GM commands in order
+func GetGMCommands() []string {
+	names := make([]string, 0, len(gmCommands))
+	for name := range gmCommands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// ParseGMCommand parses the GM command line in form of "[/]<name> [@<entity-id>] [args...]"
+//
+// Arguments are separated by spaces, and can be quoted by " to contain spaces. The target is the entity ID following @,
+// or nil if not given.
+func ParseGMCommand(line string) (target common.EntityID, name string, args []string, err error) {
+	words, err := splitGMCommandLine(strings.TrimPrefix(strings.TrimSpace(line), "/"))
+	if err != nil {
+		return
+	}
+	if len(words) == 0 {
+		err = errors.New("GM command is empty")
+		return
+	}
+
+	name, args = words[0], words[1:]
+	if len(args) > 0 && strings.HasPrefix(args[0], "@") {
+		target = common.EntityID(args[0][1:])
+		if len(target) != common.ENTITYID_LENGTH {
+			err = errors.Errorf("invalid target entity ID: %#v", string(target))
+			return
+		}
+		args = args[1:]
+	}
+	return
+}
+
+func splitGMCommandLine(line string) ([]string, error) {
+	var words []string
+	var word strings.Builder
+	inWord, quoted := false, false
+	for _, c := range line {
+		switch {
+		case c == '"':
+			quoted = !quoted
+			inWord = true
+		case (c == ' ' || c == '\t') && !quoted:
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(c)
+			inWord = true
+		}
+	}
+	if quoted {
+		return nil, errors.New("unterminated quote in GM command")
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words, nil
+}
+
+// ExecGMCommand runs the GM command on the target entity on this game if the user is allowed by [rbac] config
+//
+// source is where the command comes from, e.g. the remote address of admin requests. Authorization decisions are
+// audited, and rbac.ErrPermissionDenied is returned if the user is not allowed to run the command.
+func ExecGMCommand(user string, source string, target *Entity, name string, args []string) (string, error) {
+	cmd := gmCommands[name]
+	if cmd == nil {
+		return "", errors.Wrap(ErrUnknownGMCommand, name)
+	}
+	if err := rbac.Authorize(user, cmd.permission, source); err != nil {
+		return "", err
+	}
+	if target.IsDestroyed() {
+		return "", errors.Errorf("%s is destroyed", target)
+	}
+
+	output, err := cmd.handler(target, args)
+	if err != nil {
+		logger.Warnf("GM command %s%v on %s by %s from %s failed: %s", name, args, target, user, source, err)
+	} else {
+		logger.Infof("GM command %s%v on %s by %s from %s: %s", name, args, target, user, source, output)
+	}
+	return output, err
+}
+
+// RunGMCommand runs the GM command line typed by the client of the entity, e.g. chat messages starting with /
+//
+// The client should be authenticated as a user in [rbac] config. The command runs on the target entity given by
+// @<entity-id> on the game hosting it, or this entity if the target is not given. The output is sent to the client by
+// calling OnGMCommandResult(output, error) on the client.
+func (e *Entity) RunGMCommand(line string) {
+	user := ""
+	if e.client != nil {
+		user = e.client.AuthID()
+	}
+	if user == "" {
+		e.OnGMCommandResult("", "GM commands should be run by authenticated clients")
+		return
+	}
+
+	targetID, name, args, err := ParseGMCommand(line)
+	if err != nil {
+		e.OnGMCommandResult("", err.Error())
+		return
+	}
+	if targetID.IsNil() {
+		targetID = e.ID
+	}
+	if target := GetEntity(targetID); target != nil {
+		target.GMCommand(user, e.ID, name, args)
+	} else {
+		// run on the game hosting the target, which sends the result back
+		Call(targetID, "GMCommand", []interface{}{user, e.ID, name, args})
+	}
+}
+
+// GMCommand runs the GM command on this entity for the GM entity (issuer), and sends the result back to the issuer
+//
+// It is called by RunGMCommand on the game hosting this entity.
+func (e *Entity) GMCommand(user string, issuer common.EntityID, name string, args []string) {
+	output, err := ExecGMCommand(user, "chat:"+string(issuer), e, name, args)
+	errmsg := ""
+	if err != nil {
+		errmsg = err.Error()
+	}
+	if issuerEntity := GetEntity(issuer); issuerEntity != nil {
+		issuerEntity.OnGMCommandResult(output, errmsg)
+	} else {
+		Call(issuer, "OnGMCommandResult", []interface{}{output, errmsg})
+	}
+}
+
+// OnGMCommandResult sends the result of the GM command run by RunGMCommand to the client
+func (e *Entity) OnGMCommandResult(output string, errmsg string) {
+	e.CallClient("OnGMCommandResult", output, errmsg)
+}
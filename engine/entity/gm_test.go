@@ -0,0 +1,76 @@
+package entity
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/rbac"
+)
+
+type TestGMEntity struct {
+	Entity
+}
+
+func (e *TestGMEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("level", "Client")
+}
+
+func TestParseGMCommand(t *testing.T) {
+	target, name, args, err := ParseGMCommand(` /give_item @abcdefghijklmnop "iron sword"  1 ""`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if target != "abcdefghijklmnop" || name != "give_item" || !reflect.DeepEqual(args, []string{"iron sword", "1", ""}) {
+		t.Errorf("wrong parse result: %s %s %#v", target, name, args)
+	}
+
+	if target, name, args, err = ParseGMCommand("heal"); err != nil || !target.IsNil() || name != "heal" || len(args) != 0 {
+		t.Errorf("wrong parse result: %s %s %#v %v", target, name, args, err)
+	}
+	for _, line := range []string{"", " / ", "give_item @abc", `give_item "sword`} {
+		if _, _, _, err := ParseGMCommand(line); err == nil {
+			t.Errorf("%#v should not be parsed", line)
+		}
+	}
+}
+
+func TestExecGMCommand(t *testing.T) {
+	config.SetConfigFile("../../goworld.ini")
+	RegisterEntity("TestGMEntity", &TestGMEntity{}, false)
+	e := CreateEntityLocally("TestGMEntity", nil)
+	RegisterGMCommand("test_set_level", func(target *Entity, args []string) (string, error) {
+		if len(args) != 1 {
+			return "", errors.New("level is not given")
+		}
+		target.Attrs.SetStr("level", args[0])
+		return "level set to " + args[0], nil
+	}, "")
+
+	var records []rbac.AuditRecord
+	rbac.OnAudit(func(record rbac.AuditRecord) {
+		records = append(records, record)
+	})
+
+	output, err := ExecGMCommand(rbac.Superuser, "test", e, "test_set_level", []string{"10"})
+	if err != nil || output != "level set to 10" || e.Attrs.GetStr("level") != "10" {
+		t.Errorf("GM command should be executed, but got %#v, %v", output, err)
+	}
+	if _, err := ExecGMCommand(rbac.Superuser, "test", e, "test_set_level", nil); err == nil {
+		t.Errorf("error of GM command should be returned")
+	}
+	if _, err := ExecGMCommand("nobody", "test", e, "test_set_level", []string{"99"}); err != rbac.ErrPermissionDenied || e.Attrs.GetStr("level") != "10" {
+		t.Errorf("GM command should be denied, but got %v", err)
+	}
+	if _, err := ExecGMCommand(rbac.Superuser, "test", e, "test_unknown", nil); errors.Cause(err) != ErrUnknownGMCommand {
+		t.Errorf("unknown GM command should be rejected, but got %v", err)
+	}
+
+	if len(records) != 3 || records[2].User != "nobody" || records[2].Action != "gm:test_set_level" || records[2].Allowed {
+		t.Errorf("GM commands should be audited, but got %v", records)
+	}
+	if names := GetGMCommands(); len(names) != 1 || names[0] != "test_set_level" {
+		t.Errorf("registered GM commands should be listed, but got %v", names)
+	}
+}
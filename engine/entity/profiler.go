@@ -0,0 +1,144 @@
+package entity
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+const (
+	// ProfileSortByCPU sorts entities in profile report by CPU time
+	ProfileSortByCPU = "cpu"
+	// ProfileSortByBytes sorts entities in profile report by synced bytes
+	ProfileSortByBytes = "bytes"
+)
+
+var (
+	profiler *_EntityProfiler // nil if entity profiling is not running
+)
+
+// EntityProfile is the CPU time and synced bytes of entity or entity type during entity profiling
+type EntityProfile struct {
+	ID        common.EntityID `json:"id,omitempty"`
+	Type      string          `json:"type"`
+	Calls     uint64          `json:"calls"`      // number of method calls, including timers
+	CPUTime   time.Duration   `json:"cpu_time"`   // self time of method calls in nanoseconds, excluding nested calls of other entities
+	SyncBytes uint64          `json:"sync_bytes"` // bytes of RPCs, attributes and positions synced to clients
+}
+
+// ProfileReport is the result of entity profiling
+type ProfileReport struct {
+	StartTime time.Time       `json:"start_time"`
+	Duration  time.Duration   `json:"duration"`
+	CPUTime   time.Duration   `json:"cpu_time"`   // total CPU time of all entity method calls
+	SyncBytes uint64          `json:"sync_bytes"` // total bytes synced to clients
+	Types     []EntityProfile `json:"types"`      // all entity types sorted by CPU time
+	Entities  []EntityProfile `json:"entities"`   // top entities
+}
+
+type _EntityProfiler struct {
+	startTime time.Time
+	entities  map[common.EntityID]*EntityProfile
+}
+
+// StartProfiling starts attributing CPU time of method calls and bytes synced to clients to entities, which should be
+// called in the game routine
+func StartProfiling() error {
+	if profiler != nil {
+		return errors.New("entity profiling is already running")
+	}
+
+	profiler = &_EntityProfiler{
+		startTime: time.Now(),
+		entities:  map[common.EntityID]*EntityProfile{},
+	}
+	logger.Infof("entity profiling started")
+	return nil
+}
+
+// StopProfiling stops entity profiling and returns the report with top entities sorted by ProfileSortByCPU or
+// ProfileSortByBytes, which should be called in the game routine
+func StopProfiling(topEntities int, sortBy string) (*ProfileReport, error) {
+	if profiler == nil {
+		return nil, errors.New("entity profiling is not running")
+	}
+
+	p := profiler
+	profiler = nil
+	logger.Infof("entity profiling stopped, %d entities profiled", len(p.entities))
+	return p.report(topEntities, sortBy), nil
+}
+
+// IsProfiling returns if entity profiling is running
+func IsProfiling() bool {
+	return profiler != nil
+}
+
+func (p *_EntityProfiler) getEntityProfile(id common.EntityID, typeName string) *EntityProfile {
+	ep := p.entities[id]
+	if ep == nil {
+		ep = &EntityProfile{ID: id, Type: typeName}
+		p.entities[id] = ep
+	}
+	return ep
+}
+
+func (p *_EntityProfiler) addCall(e *Entity, cpuTime time.Duration) {
+	ep := p.getEntityProfile(e.ID, e.TypeName)
+	ep.Calls += 1
+	ep.CPUTime += cpuTime
+}
+
+func (p *_EntityProfiler) addSyncBytes(id common.EntityID, bytes uint64) {
+	typeName := ""
+	if e := entityManager.get(id); e != nil {
+		typeName = e.TypeName
+	}
+	p.getEntityProfile(id, typeName).SyncBytes += bytes
+}
+
+func (p *_EntityProfiler) report(topEntities int, sortBy string) *ProfileReport {
+	report := &ProfileReport{
+		StartTime: p.startTime,
+		Duration:  time.Since(p.startTime),
+		Types:     []EntityProfile{},
+		Entities:  make([]EntityProfile, 0, len(p.entities)),
+	}
+
+	types := map[string]*EntityProfile{}
+	for _, ep := range p.entities {
+		report.CPUTime += ep.CPUTime
+		report.SyncBytes += ep.SyncBytes
+		report.Entities = append(report.Entities, *ep)
+
+		tp := types[ep.Type]
+		if tp == nil {
+			tp = &EntityProfile{Type: ep.Type}
+			types[ep.Type] = tp
+		}
+		tp.Calls += ep.Calls
+		tp.CPUTime += ep.CPUTime
+		tp.SyncBytes += ep.SyncBytes
+	}
+	for _, tp := range types {
+		report.Types = append(report.Types, *tp)
+	}
+
+	sortEntityProfiles(report.Types, ProfileSortByCPU)
+	sortEntityProfiles(report.Entities, sortBy)
+	if topEntities >= 0 && len(report.Entities) > topEntities {
+		report.Entities = report.Entities[:topEntities]
+	}
+	return report
+}
+
+func sortEntityProfiles(profiles []EntityProfile, sortBy string) {
+	sort.Slice(profiles, func(i, j int) bool {
+		if sortBy == ProfileSortByBytes && profiles[i].SyncBytes != profiles[j].SyncBytes {
+			return profiles[i].SyncBytes > profiles[j].SyncBytes
+		}
+		return profiles[i].CPUTime > profiles[j].CPUTime
+	})
+}
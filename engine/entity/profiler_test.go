@@ -0,0 +1,43 @@
+package entity
+
+import (
+	"testing"
+	"time"
+)
+
+func TestEntityProfiler(t *testing.T) {
+	if err := StartProfiling(); err != nil {
+		t.Fatal(err)
+	}
+	if err := StartProfiling(); err == nil {
+		t.Errorf("StartProfiling should fail when profiling is running")
+	}
+
+	avatar := &Entity{ID: "TestProfileAvata", TypeName: "Avatar"}
+	monster := &Entity{ID: "TestProfileMonst", TypeName: "Monster"}
+	pushRunningCall(avatar, "rpc", "Attack")
+	time.Sleep(time.Millisecond * 2)
+	pushRunningCall(monster, "rpc", "OnAttacked")
+	time.Sleep(time.Millisecond * 10)
+	popRunningCall()
+	popRunningCall()
+	profiler.addSyncBytes(avatar.ID, 100)
+	profiler.addSyncBytes(monster.ID, 10)
+
+	report, err := StopProfiling(1, ProfileSortByBytes)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if IsProfiling() {
+		t.Errorf("profiling should be stopped")
+	}
+	if len(report.Types) != 2 || report.Types[0].Type != "Monster" || report.Types[1].Type != "Avatar" {
+		t.Fatalf("types should be sorted by CPU time (nested calls excluded): %+v", report.Types)
+	}
+	if len(report.Entities) != 1 || report.Entities[0].ID != avatar.ID || report.Entities[0].SyncBytes != 100 {
+		t.Errorf("top entity should be avatar sorted by bytes: %+v", report.Entities)
+	}
+	if report.SyncBytes != 110 || report.CPUTime < time.Millisecond*12 {
+		t.Errorf("wrong total sync bytes %d or CPU time %s", report.SyncBytes, report.CPUTime)
+	}
+}
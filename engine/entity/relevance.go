@@ -0,0 +1,188 @@
+package entity
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/simulation"
+)
+
+const (
+	// _INTERACTION_RELEVANCE_DURATION is how long recent interactions raise the relevance of entities
+	_INTERACTION_RELEVANCE_DURATION = time.Second * 10
+)
+
+// RelevanceScorer scores the relevance of the target entity to the observer entity owning the client, position syncs of
+// entities of higher scores are sent first when the client sync budget is exceeded
+type RelevanceScorer func(observer *Entity, target *Entity) float64
+
+var (
+	relevanceScorer     RelevanceScorer = DefaultRelevanceScore
+	clientSyncBudget    int             // max number of other entities synced to each client in each sync, 0 for no limit
+	overloadSyncBudget  int             // clientSyncBudget when the game is overloaded, 0 for clientSyncBudget
+	syncOverloaded      bool            // the game is overloaded, e.g. the last frame overruns the frame budget
+	deferredSyncsByGame uint64          // total number of position syncs deferred by sync budgets, accessed atomically
+	deferringObservers  = EntitySet{}   // entities with position syncs of neighbors deferred
+)
+
+// SetRelevanceScorer sets the scorer of relevance of entities to clients, or DefaultRelevanceScore if scorer is nil
+func SetRelevanceScorer(scorer RelevanceScorer) {
+	if scorer == nil {
+		scorer = DefaultRelevanceScore
+	}
+	relevanceScorer = scorer
+}
+
+// SetClientSyncBudget sets the max number of other entities whose positions are synced to each client in each sync
+//
+// Position syncs of the most relevant entities scored by the RelevanceScorer are sent, and the others are deferred to
+// following syncs. budget is used normally and overloadBudget is used when the game is overloaded (see
+// SetSyncOverloaded), 0 for no limit or the same as budget respectively.
+func SetClientSyncBudget(budget int, overloadBudget int) {
+	clientSyncBudget = budget
+	overloadSyncBudget = overloadBudget
+	logger.Infof("Client sync budget set to %d, %d when overloaded", budget, overloadBudget)
+}
+
+// SetSyncOverloaded sets if the game is overloaded, so that position syncs are limited by the overload budget
+func SetSyncOverloaded(overloaded bool) {
+	syncOverloaded = overloaded
+}
+
+// GetDeferredSyncs returns the total number of position syncs deferred by client sync budgets
+func GetDeferredSyncs() uint64 {
+	return atomic.LoadUint64(&deferredSyncsByGame)
+}
+
+func getClientSyncBudget() int {
+	if syncOverloaded && overloadSyncBudget > 0 {
+		return overloadSyncBudget
+	}
+	return clientSyncBudget
+}
+
+// DefaultRelevanceScore scores the target by the attention target of the observer, recent interactions and distance
+//
+// The attention target is always the most relevant, then entities interacted with recently (see NoteInteraction), and
+// nearer entities are more relevant.
+func DefaultRelevanceScore(observer *Entity, target *Entity) float64 {
+	score := 1 / (1 + float64(observer.Position.DistanceTo(target.Position)))
+	if observer.attentionTarget == target.ID {
+		score += 100
+	}
+	if t, ok := observer.interactions[target.ID]; ok {
+		if elapsed := simulation.Now().Sub(t); elapsed < _INTERACTION_RELEVANCE_DURATION {
+			score += 10 * (1 - float64(elapsed)/float64(_INTERACTION_RELEVANCE_DURATION))
+		}
+	}
+	return score
+}
+
+// SetAttentionTarget sets the entity which the player of this entity focuses on, e.g. the target of attacks or the NPC
+// talking to, which is the most relevant entity for DefaultRelevanceScore. The target is cleared if id is nil.
+func (e *Entity) SetAttentionTarget(id common.EntityID) {
+	e.attentionTarget = id
+}
+
+// GetAttentionTarget returns the attention target of the entity
+func (e *Entity) GetAttentionTarget() common.EntityID {
+	return e.attentionTarget
+}
+
+// NoteInteraction notes that this entity interacts with the other entity (e.g. attacks, trades or chats), which raises
+// the relevance of the other entity for DefaultRelevanceScore for a while
+func (e *Entity) NoteInteraction(other *Entity) {
+	now := simulation.Now()
+	if e.interactions == nil {
+		e.interactions = map[common.EntityID]time.Time{}
+	}
+	for id, t := range e.interactions {
+		if now.Sub(t) >= _INTERACTION_RELEVANCE_DURATION {
+			delete(e.interactions, id)
+		}
+	}
+	e.interactions[other.ID] = now
+}
+
+// collectBudgetedNeighborSyncs writes position syncs of neighbors to clients within the client sync budget
+//
+// changed are entities whose positions are changed since the last sync. Syncs exceeding the budget of each client are
+// deferred to the observer, and sent in following syncs if they are still relevant enough. All deferred syncs are sent
+// if budget is 0.
+func collectBudgetedNeighborSyncs(changed []*Entity, budget int) {
+	pending := map[*Entity]EntitySet{}
+	for _, e := range changed {
+		for observer := range e.InterestedBy {
+			if observer.client == nil {
+				continue
+			}
+			targets := pending[observer]
+			if targets == nil {
+				targets = EntitySet{}
+				pending[observer] = targets
+			}
+			targets.Add(e)
+		}
+	}
+	for observer := range deferringObservers {
+		if observer.IsDestroyed() || observer.client == nil {
+			observer.deferredSyncs = nil
+			continue
+		}
+		targets := pending[observer]
+		if targets == nil {
+			targets = EntitySet{}
+			pending[observer] = targets
+		}
+		for target := range observer.deferredSyncs {
+			if !target.IsDestroyed() && observer.InterestedIn.Contains(target) {
+				targets.Add(target)
+			}
+		}
+		observer.deferredSyncs = nil
+	}
+	deferringObservers = EntitySet{}
+
+	type scoredTarget struct {
+		target *Entity
+		score  float64
+	}
+	for observer, targets := range pending {
+		sorted := make([]scoredTarget, 0, len(targets))
+		for target := range targets {
+			sorted = append(sorted, scoredTarget{target: target})
+		}
+		if budget > 0 && len(sorted) > budget {
+			for i := range sorted {
+				sorted[i].score = relevanceScorer(observer, sorted[i].target)
+			}
+			sort.Slice(sorted, func(i, j int) bool {
+				return sorted[i].score > sorted[j].score
+			})
+			observer.deferredSyncs = EntitySet{}
+			for _, st := range sorted[budget:] {
+				observer.deferredSyncs.Add(st.target)
+			}
+			deferringObservers.Add(observer)
+			atomic.AddUint64(&deferredSyncsByGame, uint64(len(sorted)-budget))
+			sorted = sorted[:budget]
+		}
+
+		client := observer.client
+		packet := getEntitySyncInfosPacket(client.gateid)
+		for _, st := range sorted {
+			syncInfo := st.target.getSyncInfo()
+			packet.AppendClientID(client.clientid)
+			packet.AppendEntityID(st.target.ID)
+			packet.AppendFloat32(syncInfo.X)
+			packet.AppendFloat32(syncInfo.Y)
+			packet.AppendFloat32(syncInfo.Z)
+			packet.AppendFloat32(syncInfo.Yaw)
+			if profiler != nil {
+				profiler.addSyncBytes(st.target.ID, _SYNC_INFO_SIZE)
+			}
+		}
+	}
+}
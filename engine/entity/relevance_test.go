@@ -0,0 +1,112 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+type TestRelevanceEntity struct {
+	Entity
+}
+
+func (e *TestRelevanceEntity) DescribeEntityType(desc *EntityTypeDesc) {
+}
+
+// readSyncedEntities returns IDs of entities synced to clients in the sync packet of the gate, and clears sync packets
+func readSyncedEntities(t *testing.T, gateid uint16) []common.EntityID {
+	defer func() {
+		entitySyncInfosToGate = map[uint16]*netutil.Packet{}
+	}()
+
+	pkt := entitySyncInfosToGate[gateid]
+	if pkt == nil {
+		return nil
+	}
+	if msgtype := proto.MsgType(pkt.ReadUint16()); msgtype != proto.MT_SYNC_POSITION_YAW_ON_CLIENTS || pkt.ReadUint16() != gateid {
+		t.Fatalf("wrong sync packet")
+	}
+	var eids []common.EntityID
+	for pkt.HasUnreadPayload() {
+		pkt.ReadClientID()
+		eids = append(eids, pkt.ReadEntityID())
+		pkt.ReadBytes(proto.SYNC_INFO_SIZE_PER_ENTITY)
+	}
+	return eids
+}
+
+func TestClientSyncBudget(t *testing.T) {
+	RegisterEntity("TestRelevanceEntity", &TestRelevanceEntity{}, false)
+	observer := CreateEntityLocally("TestRelevanceEntity", nil)
+	observer.client = MakeGameClient(common.GenClientID(), 9)
+	var near, far, target *Entity
+	for i, pos := range []Vector3{{1, 0, 0}, {100, 0, 0}, {200, 0, 0}} {
+		e := CreateEntityLocally("TestRelevanceEntity", nil)
+		e.Position = pos
+		observer.InterestedIn.Add(e)
+		e.InterestedBy.Add(observer)
+		switch i {
+		case 0:
+			near = e
+		case 1:
+			far = e
+		case 2:
+			target = e
+		}
+	}
+	observer.SetAttentionTarget(target.ID)
+	defer SetClientSyncBudget(0, 0)
+
+	// the attention target and the nearest entity are synced first
+	SetClientSyncBudget(2, 1)
+	collectBudgetedNeighborSyncs([]*Entity{near, far, target}, getClientSyncBudget())
+	if eids := readSyncedEntities(t, 9); len(eids) != 2 || !contains(eids, target.ID) || !contains(eids, near.ID) {
+		t.Fatalf("target and near entity should be synced, but got %v", eids)
+	}
+	if !observer.deferredSyncs.Contains(far) || GetDeferredSyncs() == 0 {
+		t.Fatalf("sync of far entity should be deferred")
+	}
+
+	// deferred syncs are sent in following syncs
+	collectBudgetedNeighborSyncs(nil, getClientSyncBudget())
+	if eids := readSyncedEntities(t, 9); len(eids) != 1 || eids[0] != far.ID {
+		t.Fatalf("deferred sync of far entity should be sent, but got %v", eids)
+	}
+
+	// recent interactions raise relevance, and the overload budget is used when the game is overloaded
+	observer.SetAttentionTarget("")
+	observer.NoteInteraction(far)
+	SetSyncOverloaded(true)
+	defer SetSyncOverloaded(false)
+	collectBudgetedNeighborSyncs([]*Entity{near, far, target}, getClientSyncBudget())
+	if eids := readSyncedEntities(t, 9); len(eids) != 1 || eids[0] != far.ID {
+		t.Fatalf("entity interacted recently should be synced, but got %v", eids)
+	}
+
+	// custom scorer
+	SetRelevanceScorer(func(observer *Entity, e *Entity) float64 {
+		return float64(e.Position.X)
+	})
+	defer SetRelevanceScorer(nil)
+	collectBudgetedNeighborSyncs(nil, getClientSyncBudget())
+	if eids := readSyncedEntities(t, 9); len(eids) != 1 || eids[0] != target.ID {
+		t.Fatalf("entity of the highest score should be synced, but got %v", eids)
+	}
+
+	// all deferred syncs are sent without budget
+	collectBudgetedNeighborSyncs(nil, 0)
+	if eids := readSyncedEntities(t, 9); len(eids) != 1 || eids[0] != near.ID || len(deferringObservers) != 0 {
+		t.Fatalf("all deferred syncs should be sent, but got %v", eids)
+	}
+}
+
+func contains(eids []common.EntityID, eid common.EntityID) bool {
+	for _, id := range eids {
+		if id == eid {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,137 @@
+package entity
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// quietInterests is true while entities are being reloaded, so that clients are not notified of interest changes
+var quietInterests bool
+
+// ReloadEntityTypes replaces registered entity types by new types with the same names, e.g. loaded from Go plugins
+//
+// All entities of the types are swapped to instances of the new types in place, preserving their attrs, timers,
+// clients, spaces and positions like freezing and restoring the game: OnFreeze is called on the old instances, and
+// OnAttrsReady and OnRestored are called on the new instances. Entities stay on the game, and clients do not notice
+// the swap. Callbacks of operations started with Entity.Context before the swap are dropped, and pointers to the old
+// instances should not be kept. Space types can not be reloaded.
+func ReloadEntityTypes(types map[string]IEntity) error {
+	descs := map[string]EntityTypeDesc{}
+	for typeName, entityPtr := range types {
+		desc, err := newReloadedEntityTypeDesc(typeName, entityPtr)
+		if err != nil {
+			return err
+		}
+		descs[typeName] = desc
+	}
+
+	for typeName, desc := range descs {
+		*registeredEntityTypes[typeName] = desc // entities keep pointers to the type desc
+		logger.Infof(">>> ReloadEntityType %s => %s <<<", typeName, desc.entityType.Name())
+	}
+
+	var reloading []*Entity
+	for _, e := range entityManager.entities {
+		if _, ok := descs[e.TypeName]; ok {
+			reloading = append(reloading, e)
+		}
+	}
+	for _, e := range reloading {
+		e.reload()
+	}
+	logger.Infof("%d entity types reloaded, %d entities swapped", len(descs), len(reloading))
+	return nil
+}
+
+// newReloadedEntityTypeDesc describes the new type in the same way as RegisterEntity
+func newReloadedEntityTypeDesc(typeName string, entity IEntity) (EntityTypeDesc, error) {
+	oldDesc := registeredEntityTypes[typeName]
+	if oldDesc == nil {
+		return EntityTypeDesc{}, errors.Errorf("entity type %s is not registered", typeName)
+	}
+	if typeName == _SPACE_ENTITY_TYPE {
+		return EntityTypeDesc{}, errors.Errorf("space type can not be reloaded")
+	}
+
+	entityType := reflect.TypeOf(entity)
+	if entityType.Kind() == reflect.Ptr {
+		entityType = entityType.Elem()
+	}
+	if entityType == oldDesc.entityType {
+		return EntityTypeDesc{}, errors.Errorf("entity type %s is not changed", typeName)
+	}
+	if field, ok := entityType.FieldByName("Entity"); !ok || !field.Anonymous || field.Type != reflect.TypeOf(Entity{}) {
+		return EntityTypeDesc{}, errors.Errorf("%s does not embed Entity", entityType)
+	}
+
+	rpcDescs := rpcDescMap{}
+	desc := EntityTypeDesc{
+		isService:       oldDesc.isService,
+		entityType:      entityType,
+		rpcDescs:        rpcDescs,
+		clientAttrs:     common.StringSet{},
+		allClientAttrs:  common.StringSet{},
+		persistentAttrs: common.StringSet{},
+		inspectorCalls:  common.StringSet{},
+	}
+	entityPtrType := reflect.PtrTo(entityType)
+	for i := 0; i < entityPtrType.NumMethod(); i++ {
+		rpcDescs.visit(entityPtrType.Method(i))
+	}
+	entity.DescribeEntityType(&desc)
+
+	// entities are swapped in place, so they must stay in the same AOI and storage
+	if desc.IsPersistent != oldDesc.IsPersistent || desc.useAOI != oldDesc.useAOI || desc.aoiDistance != oldDesc.aoiDistance {
+		return EntityTypeDesc{}, errors.Errorf("persistence and AOI of entity type %s can not be changed by reloading", typeName)
+	}
+	return desc, nil
+}
+
+// reload swaps the entity to the instance of the reloaded type
+func (e *Entity) reload() {
+	gwutils.RunPanicless(func() {
+		e.I.OnFreeze()
+	})
+
+	var spaceid common.EntityID
+	if e.Space != nil {
+		spaceid = e.Space.ID
+	}
+	md := e.GetMigrateData(spaceid)
+	enteringSpaceRequest := e.enteringSpaceRequest
+	accountSessionClaim := e.accountSessionClaim
+
+	quietInterests = true
+	defer func() {
+		quietInterests = false
+	}()
+
+	if space := e.Space; space != nil && !space.IsNil() {
+		space.entities.Del(e)
+		e.Space = nilSpace
+		if space.aoiMgr != nil && e.IsUseAOI() {
+			aoiLogger.Debugf("%s: %s leave AOI for reloading", space, e)
+			space.aoiMgr.Leave(&e.aoi)
+		}
+	}
+	e.StopSpectatingReplay()
+	e.clearRawTimers()
+	e.rawTimers = nil
+	e.clientSession = nil // session timer is already cancelled
+	e.accountSessionClaim = nil
+	e.assignClient(nil)
+	e.destroyed = true
+	if e.cancelCtx != nil {
+		e.cancelCtx()
+	}
+	entityManager.del(e)
+
+	restoreEntity(e.ID, md, true)
+	if ne := entityManager.get(e.ID); ne != nil {
+		ne.enteringSpaceRequest = enteringSpaceRequest
+		ne.accountSessionClaim = accountSessionClaim
+	}
+}
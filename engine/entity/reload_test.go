@@ -0,0 +1,71 @@
+package entity
+
+import (
+	"testing"
+
+	timer "github.com/xiaonanln/goTimer"
+)
+
+type TestReloadEntity struct {
+	Entity
+}
+
+func (e *TestReloadEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("level", "Client")
+}
+
+func (e *TestReloadEntity) OnTimer() {
+	e.Attrs.SetInt("fired", 1)
+}
+
+type TestReloadEntityV2 struct {
+	Entity
+	restored bool
+}
+
+func (e *TestReloadEntityV2) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("level", "Client")
+	desc.DefineAttr("exp", "Client")
+}
+
+func (e *TestReloadEntityV2) OnRestored() {
+	e.restored = true
+}
+
+func (e *TestReloadEntityV2) OnTimer() {
+	e.Attrs.SetInt("fired", 2)
+}
+
+func TestReloadEntityTypes(t *testing.T) {
+	RegisterEntity("TestReloadEntity", &TestReloadEntity{}, false)
+	e := CreateEntityLocally("TestReloadEntity", nil)
+	e.Attrs.SetInt("level", 10)
+	e.AddCallback(0, "OnTimer")
+
+	if err := ReloadEntityTypes(map[string]IEntity{"TestReloadEntity": &TestReloadEntity{}}); err == nil {
+		t.Errorf("reloading the same type should fail")
+	}
+	if err := ReloadEntityTypes(map[string]IEntity{"NotRegistered": &TestReloadEntityV2{}}); err == nil {
+		t.Errorf("reloading unregistered type should fail")
+	}
+	if err := ReloadEntityTypes(map[string]IEntity{"TestReloadEntity": &TestReloadEntityV2{}}); err != nil {
+		t.Fatal(err)
+	}
+
+	ne := entityManager.get(e.ID)
+	if ne == nil || ne == e || !e.IsDestroyed() {
+		t.Fatalf("entity should be swapped to a new instance")
+	}
+	v2, ok := ne.I.(*TestReloadEntityV2)
+	if !ok || !v2.restored {
+		t.Fatalf("entity should be restored as the new type, but got %T", ne.I)
+	}
+	if ne.GetInt("level") != 10 || !ne.typeDesc.clientAttrs.Contains("exp") {
+		t.Errorf("attrs should be preserved and described by the new type")
+	}
+
+	timer.Tick()
+	if ne.GetInt("fired") != 2 {
+		t.Errorf("timer should be preserved and fired on the new type, but got %d", ne.GetInt("fired"))
+	}
+}
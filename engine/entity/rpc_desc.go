@@ -16,6 +16,7 @@ type rpcDesc struct {
 	Flags      uint
 	MethodType reflect.Type
 	NumArgs    int
+	ArgSchemas []ArgSchema // schemas of leading arguments validated for calls from clients, see EntityTypeDesc.DefineArgs
 }
 
 type rpcDescMap map[string]*rpcDesc
@@ -0,0 +1,105 @@
+package entity
+
+import (
+	"reflect"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/typeconv"
+)
+
+var (
+	// ErrEntityNotFound is returned if the requested entity is not on this game
+	ErrEntityNotFound = errors.New("entity not found")
+
+	errorType = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// CallRequestLocally calls the server method of the local entity and returns the result of the method
+//
+// The result is the first return value of the method which is not an error,
+// and err is the last return value of the method if it is an error.
+func CallRequestLocally(id common.EntityID, method string, args []interface{}) (result interface{}, err error) {
+	e, rpcDesc, err := getRequestRPC(id, method, len(args))
+	if err != nil {
+		return nil, err
+	}
+
+	methodType := rpcDesc.MethodType
+	in := make([]reflect.Value, rpcDesc.NumArgs+1)
+	in[0] = e.V // first argument is the bind instance (self)
+	for i := 0; i < rpcDesc.NumArgs; i++ {
+		argType := methodType.In(i + 1)
+		if i < len(args) {
+			in[i+1] = typeconv.Convert(args[i], argType)
+		} else {
+			in[i+1] = reflect.Zero(argType) // use zero value for missing arguments
+		}
+	}
+	return e.callRequest(method, rpcDesc, in)
+}
+
+// OnCallRequest is called by engine when service request reaches in the game, it returns the result like CallRequestLocally
+func OnCallRequest(id common.EntityID, method string, args [][]byte) (result interface{}, err error) {
+	e, rpcDesc, err := getRequestRPC(id, method, len(args))
+	if err != nil {
+		return nil, err
+	}
+
+	methodType := rpcDesc.MethodType
+	in := make([]reflect.Value, rpcDesc.NumArgs+1)
+	in[0] = e.V // first argument is the bind instance (self)
+	for i := 0; i < rpcDesc.NumArgs; i++ {
+		argType := methodType.In(i + 1)
+		if i >= len(args) {
+			in[i+1] = reflect.Zero(argType) // use zero value for missing arguments
+			continue
+		}
+
+		argValPtr := reflect.New(argType)
+		if err := netutil.MSG_PACKER.UnpackMsg(args[i], argValPtr.Interface()); err != nil {
+			return nil, errors.Wrapf(err, "convert argument %d of %s failed", i+1, method)
+		}
+		in[i+1] = reflect.Indirect(argValPtr)
+	}
+	return e.callRequest(method, rpcDesc, in)
+}
+
+func getRequestRPC(id common.EntityID, method string, numArgs int) (*Entity, *rpcDesc, error) {
+	e := entityManager.get(id)
+	if e == nil {
+		return nil, nil, ErrEntityNotFound
+	}
+
+	rpcDesc := e.typeDesc.rpcDescs[method]
+	if rpcDesc == nil || rpcDesc.Flags&rfServer == 0 {
+		return nil, nil, errors.Errorf("%s.%s is not a valid server RPC", e, method)
+	}
+	if rpcDesc.NumArgs < numArgs {
+		return nil, nil, errors.Errorf("%s.%s receives %d arguments, but given %d", e, method, rpcDesc.NumArgs, numArgs)
+	}
+	return e, rpcDesc, nil
+}
+
+func (e *Entity) callRequest(method string, rpcDesc *rpcDesc, in []reflect.Value) (result interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil { // recover from any error during RPC call
+			logger.TraceError("%s.%s paniced: %s", e, method, r)
+			err = errors.Errorf("%s.%s paniced: %v", e, method, r)
+		}
+	}()
+
+	out := e.callMethod(opmon.HandlerEntityRPC, method, rpcDesc, in)
+	if n := len(out); n > 0 && rpcDesc.MethodType.Out(n-1) == errorType {
+		if !out[n-1].IsNil() {
+			err = out[n-1].Interface().(error)
+		}
+		out = out[:n-1]
+	}
+	if len(out) > 0 {
+		result = out[0].Interface()
+	}
+	return
+}
@@ -13,7 +13,7 @@ func CreateSpaceLocally(kind int) *Space {
 	if kind == 0 {
 		gwlog.Panicf("Can not create nil space with kind=0. Game will create 1 nil space automatically.")
 	}
-	e := createEntity(_SPACE_ENTITY_TYPE, nil, Vector3{}, "", map[string]interface{}{
+	e := createEntity(_SPACE_ENTITY_TYPE, "", nil, Vector3{}, "", map[string]interface{}{
 		_SPACE_KIND_ATTR_KEY: kind,
 	})
 	return e.AsSpace()
@@ -24,7 +24,7 @@ func CreateSpaceSomewhere(gameid uint16, kind int) common.EntityID {
 	if kind == 0 {
 		gwlog.Panicf("Can not create nil space with kind=0. Game will create 1 nil space automatically.")
 	}
-	return createEntitySomewhere(gameid, _SPACE_ENTITY_TYPE, map[string]interface{}{
+	return createEntitySomewhere(gameid, _SPACE_ENTITY_TYPE, "", map[string]interface{}{
 		_SPACE_KIND_ATTR_KEY: kind,
 	})
 }
@@ -32,7 +32,7 @@ func CreateSpaceSomewhere(gameid uint16, kind int) common.EntityID {
 // CreateNilSpace creates the nil space
 func CreateNilSpace(gameid uint16) *Space {
 	spaceID := GetNilSpaceID(gameid)
-	e := createEntity(_SPACE_ENTITY_TYPE, nil, Vector3{}, spaceID, map[string]interface{}{
+	e := createEntity(_SPACE_ENTITY_TYPE, "", nil, Vector3{}, spaceID, map[string]interface{}{
 		_SPACE_KIND_ATTR_KEY: 0,
 	})
 	return e.AsSpace()
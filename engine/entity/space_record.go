@@ -0,0 +1,287 @@
+package entity
+
+import (
+	"os"
+	"reflect"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/simulation"
+	"github.com/xiaonanln/goworld/engine/spacerecord"
+)
+
+const (
+	// DefaultRecordInterval is the default interval of sampling states of recorded spaces
+	DefaultRecordInterval = time.Millisecond * 100
+
+	replayTickInterval = time.Millisecond * 100
+)
+
+// spaceRecorder samples states of entities in the space and writes changes to the record file
+type spaceRecorder struct {
+	file   *os.File
+	w      *spacerecord.Writer
+	attrs  common.StringSet // recorded attributes, nil for attributes synced to all clients
+	timer  rawTimer
+	states map[*Entity]*recordedState
+}
+
+type recordedState struct {
+	pos   Vector3
+	yaw   Yaw
+	attrs map[string]interface{}
+}
+
+// StartRecording records states of entities in the space to the file, which can be played by Entity.SpectateReplay
+//
+// Entities entering and leaving the space, positions and attributes are sampled every interval and changes are
+// recorded. attrs are names of recorded attributes, or attributes synced to all clients (visible to neighbors) if empty.
+func (space *Space) StartRecording(file string, attrs []string, interval time.Duration) error {
+	if space.IsNil() {
+		return errors.Errorf("%s can not be recorded", space)
+	}
+	if space.recorder != nil {
+		return errors.Errorf("%s is already recording", space)
+	}
+	if interval <= 0 {
+		interval = DefaultRecordInterval
+	}
+
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	w, err := spacerecord.NewWriter(f, spacerecord.Header{
+		SpaceID:   space.ID,
+		Kind:      space.Kind,
+		World:     space.world,
+		StartTime: simulation.Now(),
+	})
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	rec := &spaceRecorder{
+		file:   f,
+		w:      w,
+		states: map[*Entity]*recordedState{},
+	}
+	if len(attrs) > 0 {
+		rec.attrs = common.StringSet{}
+		for _, attr := range attrs {
+			rec.attrs.Add(attr)
+		}
+	}
+	space.recorder = rec
+	rec.timer = space.addRawTimer(interval, space.sampleRecording)
+	space.sampleRecording()
+	logger.Infof("%s: start recording to %s", space, file)
+	return nil
+}
+
+// StopRecording stops recording the space, returns the error of writing the record if any
+func (space *Space) StopRecording() error {
+	rec := space.recorder
+	if rec == nil {
+		return errors.Errorf("%s is not recording", space)
+	}
+
+	space.recorder = nil
+	if space.rawTimers != nil {
+		space.cancelRawTimer(rec.timer)
+	}
+	err := rec.w.Flush()
+	if cerr := rec.file.Close(); err == nil {
+		err = cerr
+	}
+	logger.Infof("%s: stop recording to %s", space, rec.file.Name())
+	return err
+}
+
+// IsRecording returns if the space is recording
+func (space *Space) IsRecording() bool {
+	return space.recorder != nil
+}
+
+// sampleRecording writes changes of entities since the last sample
+func (space *Space) sampleRecording() {
+	rec := space.recorder
+	now := simulation.Now()
+	for e := range space.entities {
+		attrs := rec.getAttrs(e)
+		st := rec.states[e]
+		if st == nil {
+			rec.states[e] = &recordedState{pos: e.Position, yaw: e.yaw, attrs: attrs}
+			rec.w.Write(now, &spacerecord.Event{
+				Type:     spacerecord.EventEnter,
+				EntityID: e.ID,
+				TypeName: e.TypeName,
+				X:        float32(e.Position.X),
+				Y:        float32(e.Position.Y),
+				Z:        float32(e.Position.Z),
+				Yaw:      float32(e.yaw),
+				Attrs:    attrs,
+			})
+			continue
+		}
+
+		if st.pos != e.Position || st.yaw != e.yaw {
+			st.pos, st.yaw = e.Position, e.yaw
+			rec.w.Write(now, &spacerecord.Event{
+				Type:     spacerecord.EventMove,
+				EntityID: e.ID,
+				X:        float32(e.Position.X),
+				Y:        float32(e.Position.Y),
+				Z:        float32(e.Position.Z),
+				Yaw:      float32(e.yaw),
+			})
+		}
+		if changed := diffAttrs(st.attrs, attrs); len(changed) > 0 {
+			st.attrs = attrs
+			rec.w.Write(now, &spacerecord.Event{
+				Type:     spacerecord.EventAttrs,
+				EntityID: e.ID,
+				Attrs:    changed,
+			})
+		}
+	}
+
+	for e := range rec.states {
+		if !space.entities.Contains(e) {
+			delete(rec.states, e)
+			rec.w.Write(now, &spacerecord.Event{Type: spacerecord.EventLeave, EntityID: e.ID})
+		}
+	}
+
+	// flush every sample, so that the record is complete if the game is killed or freezed
+	if err := rec.w.Flush(); err != nil {
+		logger.Errorf("%s: write record failed: %s", space, err)
+		space.StopRecording()
+	}
+}
+
+// getAttrs returns recorded attributes of the entity
+func (rec *spaceRecorder) getAttrs(e *Entity) map[string]interface{} {
+	if rec.attrs == nil {
+		return e.getAllClientData()
+	}
+	return e.Attrs.ToMapWithFilter(rec.attrs.Contains)
+}
+
+// diffAttrs returns attributes changed from old to new, deleted attributes are nil
+func diffAttrs(old, new map[string]interface{}) map[string]interface{} {
+	changed := map[string]interface{}{}
+	for key, val := range new {
+		if oldVal, ok := old[key]; !ok || !reflect.DeepEqual(oldVal, val) {
+			changed[key] = val
+		}
+	}
+	for key := range old {
+		if _, ok := new[key]; !ok {
+			changed[key] = nil
+		}
+	}
+	return changed
+}
+
+// replaySession plays the space record to the client of the spectator
+type replaySession struct {
+	file      *os.File
+	player    *spacerecord.Player
+	speed     float64
+	startTime time.Time
+	timer     rawTimer
+}
+
+// SpectateReplay plays the space record to the client of the entity at the speed (1 for real time)
+//
+// Events are sent to the client by calling OnSpaceReplay with the list of events every 100ms. Each event is a map of
+// "type" (enter, leave, move or attrs), "time" (milliseconds since the start of recording), "id", and "type_name", "x",
+// "y", "z", "yaw", "attrs" depending on the type. OnSpaceReplayEnd is called on the client after all events are played.
+func (e *Entity) SpectateReplay(file string, speed float64) error {
+	if e.replay != nil {
+		return errors.Errorf("%s is already spectating replay", e)
+	}
+	if speed <= 0 {
+		return errors.Errorf("replay speed should be positive, but is %v", speed)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	player, err := spacerecord.NewPlayer(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	e.replay = &replaySession{
+		file:      f,
+		player:    player,
+		speed:     speed,
+		startTime: simulation.Now(),
+	}
+	e.replay.timer = e.addRawTimer(replayTickInterval, e.playReplay)
+	e.playReplay()
+	return nil
+}
+
+// StopSpectatingReplay stops playing the space record to the client
+func (e *Entity) StopSpectatingReplay() {
+	rs := e.replay
+	if rs == nil {
+		return
+	}
+
+	e.replay = nil
+	if e.rawTimers != nil {
+		e.cancelRawTimer(rs.timer)
+	}
+	rs.file.Close()
+}
+
+// IsSpectatingReplay returns if the entity is spectating replay
+func (e *Entity) IsSpectatingReplay() bool {
+	return e.replay != nil
+}
+
+func (e *Entity) playReplay() {
+	rs := e.replay
+	elapsed := time.Duration(float64(simulation.Now().Sub(rs.startTime)) * rs.speed)
+	var events []interface{}
+	err := rs.player.PlayTo(elapsed, func(ev *spacerecord.Event) {
+		events = append(events, replayEventToClient(ev))
+	})
+	if len(events) > 0 {
+		e.CallClient("OnSpaceReplay", events)
+	}
+
+	if err != nil {
+		logger.Errorf("%s: play replay %s failed: %s", e, rs.file.Name(), err)
+	}
+	if err != nil || rs.player.Ended() {
+		e.StopSpectatingReplay()
+		e.CallClient("OnSpaceReplayEnd")
+	}
+}
+
+func replayEventToClient(ev *spacerecord.Event) map[string]interface{} {
+	m := map[string]interface{}{
+		"type": ev.Type.String(),
+		"time": int64(ev.Time / time.Millisecond),
+		"id":   string(ev.EntityID),
+	}
+	if ev.Type == spacerecord.EventEnter {
+		m["type_name"] = ev.TypeName
+	}
+	if ev.Type == spacerecord.EventEnter || ev.Type == spacerecord.EventMove {
+		m["x"], m["y"], m["z"], m["yaw"] = ev.X, ev.Y, ev.Z, ev.Yaw
+	}
+	if ev.Type == spacerecord.EventEnter || ev.Type == spacerecord.EventAttrs {
+		m["attrs"] = ev.Attrs
+	}
+	return m
+}
@@ -0,0 +1,89 @@
+package entity
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/spacerecord"
+)
+
+type TestRecordEntity struct {
+	Entity
+}
+
+func (e *TestRecordEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.DefineAttr("hp", "AllClients")
+	desc.DefineAttr("secret", "Client")
+}
+
+func TestSpaceRecording(t *testing.T) {
+	dir, err := ioutil.TempDir("", "spacerecord")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	file := filepath.Join(dir, "space"+spacerecord.FileExt)
+
+	RegisterSpace(&Space{})
+	RegisterEntity("TestRecordEntity", &TestRecordEntity{}, false)
+	space := CreateSpaceLocally(1)
+	if err := space.StartRecording(file, nil, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	e := CreateEntityLocally("TestRecordEntity", nil)
+	e.Attrs.SetInt("hp", 100)
+	e.Attrs.SetInt("secret", 1)
+	space.enter(e, Vector3{1, 0, 1}, false)
+	space.sampleRecording()
+	e.Attrs.SetInt("hp", 90)
+	e.Attrs.SetInt("secret", 2)
+	space.move(e, Vector3{2, 0, 1})
+	space.sampleRecording()
+	space.sampleRecording() // nothing changed
+	space.leave(e)
+	space.sampleRecording()
+	if err := space.StopRecording(); err != nil {
+		t.Fatal(err)
+	}
+
+	f, err := os.Open(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	p, err := spacerecord.NewPlayer(f)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var events []*spacerecord.Event
+	if err := p.PlayTo(time.Hour, func(ev *spacerecord.Event) { events = append(events, ev) }); err != nil {
+		t.Fatal(err)
+	}
+	expected := []spacerecord.EventType{spacerecord.EventEnter, spacerecord.EventMove, spacerecord.EventAttrs, spacerecord.EventLeave}
+	if len(events) != len(expected) {
+		t.Fatalf("%d events should be recorded, but got %d", len(expected), len(events))
+	}
+	for i, ev := range events {
+		if ev.Type != expected[i] || ev.EntityID != e.ID {
+			t.Errorf("event %d should be %s of %s, but is %s of %s", i, expected[i], e.ID, ev.Type, ev.EntityID)
+		}
+	}
+	if _, ok := events[0].Attrs["secret"]; ok || events[0].Attrs["hp"] != int64(100) {
+		t.Errorf("only attributes synced to all clients should be recorded, but got %v", events[0].Attrs)
+	}
+	if events[1].X != 2 || len(events[2].Attrs) != 1 || events[2].Attrs["hp"] != int64(90) {
+		t.Errorf("changes should be recorded, but got %+v and %+v", events[1], events[2])
+	}
+
+	spectator := CreateEntityLocally("TestRecordEntity", nil)
+	if err := spectator.SpectateReplay(file, 1e9); err != nil {
+		t.Fatal(err)
+	}
+	if spectator.IsSpectatingReplay() {
+		t.Errorf("replay should be stopped after all events are played")
+	}
+}
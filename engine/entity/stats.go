@@ -0,0 +1,35 @@
+package entity
+
+import (
+	"sync/atomic"
+)
+
+var (
+	sentEntityRPCs uint64 // RPC calls sent to entities on other games
+	sentClientRPCs uint64 // RPC calls sent to clients
+)
+
+// EntityStats are statistics of entities on the game
+type EntityStats struct {
+	EntityCounts map[string]int // number of entities by type
+	TimerCount   int            // number of entity timers
+}
+
+// GetEntityStats collects statistics of entities, which should be called in the game routine
+func GetEntityStats() EntityStats {
+	stats := EntityStats{
+		EntityCounts: make(map[string]int, len(entityManager.entitiesByType)),
+	}
+	for etype, entities := range entityManager.entitiesByType {
+		stats.EntityCounts[etype] = len(entities)
+	}
+	for _, e := range entityManager.entities {
+		stats.TimerCount += len(e.timers)
+	}
+	return stats
+}
+
+// GetSentRPCCounts returns the number of RPC calls sent to entities on other games and to clients, which is safe to call in any goroutine
+func GetSentRPCCounts() (toEntities uint64, toClients uint64) {
+	return atomic.LoadUint64(&sentEntityRPCs), atomic.LoadUint64(&sentClientRPCs)
+}
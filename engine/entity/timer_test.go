@@ -0,0 +1,177 @@
+package entity
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/timingwheel"
+)
+
+type TestTimerEntity struct {
+	Entity
+	fired int
+}
+
+func (e *TestTimerEntity) DescribeEntityType(*EntityTypeDesc) {
+}
+
+func (e *TestTimerEntity) OnTimer() {
+	e.fired++
+}
+
+func TestEntityTimer(t *testing.T) {
+	RegisterEntity("TestTimerEntity", &TestTimerEntity{}, false)
+	e := CreateEntityLocally("TestTimerEntity", nil)
+	te := e.I.(*TestTimerEntity)
+
+	cb := e.AddCallbackHandle(0, "OnTimer")
+	if !cb.IsActive() {
+		t.Errorf("callback should be active")
+	}
+	cb.Cancel()
+	timer.Tick()
+	if te.fired != 0 || cb.IsActive() {
+		t.Errorf("cancelled callback should not be fired")
+	}
+
+	cb.Reset(0)
+	timer.Tick()
+	if te.fired != 1 || cb.IsActive() {
+		t.Errorf("reset callback should be fired once, but fired %d times", te.fired)
+	}
+
+	if _, err := e.AddCron("invalid", "OnTimer"); err == nil {
+		t.Errorf("add invalid cron timer should fail")
+	}
+	cron, err := e.AddCron("0 5 * * *", "OnTimer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if fireTime := e.timers[cron.ID()].FireTime; fireTime.Hour() != 5 || fireTime.Minute() != 0 || !fireTime.After(time.Now()) {
+		t.Errorf("wrong fire time of cron timer: %s", fireTime)
+	}
+	timers := e.dumpTimers()
+	e.timers = map[EntityTimerID]*entityTimerInfo{}
+	e.clearRawTimers()
+	if err := e.restoreTimers(timers); err != nil {
+		t.Fatal(err)
+	}
+	for tid, info := range e.timers {
+		if info.Cron != "0 5 * * *" {
+			t.Errorf("cron timer is not restored: %+v", info)
+		}
+		e.CancelTimer(tid)
+	}
+
+	tm := e.AddTimerHandle(0, "OnTimer")
+	tm.Reset(0)
+	if len(e.timers) != 1 || len(e.rawTimers) != 1 {
+		t.Errorf("reset timer should replace the raw timer, but entity has %d timers and %d raw timers", len(e.timers), len(e.rawTimers))
+	}
+	e.clearRawTimers() // destroy without the space
+	e.rawTimers = nil
+	e.destroyed = true
+	if tm.IsActive() {
+		t.Errorf("timers should be cancelled when the entity is destroyed")
+	}
+	tm.Reset(0)
+	if tm.IsActive() {
+		t.Errorf("timers of destroyed entities should not be reset")
+	}
+}
+
+type TestPersistentTimerEntity struct {
+	Entity
+}
+
+func (e *TestPersistentTimerEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("level", "Persistent")
+}
+
+func (e *TestPersistentTimerEntity) OnUpgraded(level int) {
+}
+
+func TestPersistentTimer(t *testing.T) {
+	RegisterEntity("TestPersistentTimerEntity", &TestPersistentTimerEntity{}, false)
+	e := createEntity("TestPersistentTimerEntity", "", nil, Vector3{}, "", map[string]interface{}{})
+	e.Attrs.SetInt("level", 1)
+	upgrade := e.AddPersistentCallback(time.Hour, "OnUpgraded", 2)
+	e.AddCallback(time.Hour, "OnUpgraded", 3)
+
+	// persistent data is saved as JSON by filesystem storage
+	jsonData, err := json.Marshal(e.getPersistentData())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var data map[string]interface{}
+	if err := json.Unmarshal(jsonData, &data); err != nil {
+		t.Fatal(err)
+	}
+
+	loaded := createEntity("TestPersistentTimerEntity", "", nil, Vector3{}, "", data)
+	if loaded.Attrs.HasKey(_PERSISTENT_TIMERS_KEY) || !loaded.Attrs.HasKey("level") {
+		t.Errorf("wrong attrs loaded: %v", loaded.Attrs.ToMap())
+	}
+	if len(loaded.timers) != 1 {
+		t.Fatalf("only persistent timers should be restored, but restored %d timers", len(loaded.timers))
+	}
+	for _, info := range loaded.timers {
+		if !info.Persistent || info.Method != "OnUpgraded" || len(info.Args) != 1 || !info.FireTime.Equal(e.timers[upgrade.ID()].FireTime) {
+			t.Errorf("wrong timer restored: %+v", info)
+		}
+	}
+}
+
+func TestJitteredTimer(t *testing.T) {
+	e := CreateEntityLocally("TestTimerEntity", nil)
+	interval := time.Hour
+	fireTimes := map[time.Time]bool{}
+	for i := 0; i < 10; i++ {
+		tm := e.AddJitteredTimer(interval, "OnTimer")
+		info := e.timers[tm.ID()]
+		if !info.Jitter || info.RepeatInterval != interval || !info.FireTime.Before(time.Now().Add(interval)) {
+			t.Errorf("wrong jittered timer: %+v", info)
+		}
+		fireTimes[info.FireTime] = true
+		tm.Cancel()
+	}
+	if len(fireTimes) < 2 {
+		t.Errorf("jittered timers should be fired at different times")
+	}
+
+	SetJitterTimers(true)
+	defer SetJitterTimers(false)
+	if tid := e.AddTimer(interval, "OnTimer"); !e.timers[tid].Jitter {
+		t.Errorf("repeat timers should be jittered if jitter timers is enabled")
+	}
+	if tid := e.AddCallback(interval, "OnTimer"); e.timers[tid].Jitter {
+		t.Errorf("callbacks should never be jittered")
+	}
+}
+
+func TestTimingWheelTimer(t *testing.T) {
+	SetTimingWheel(true)
+	defer SetTimingWheel(false)
+
+	e := CreateEntityLocally("TestTimerEntity", nil)
+	te := e.I.(*TestTimerEntity)
+	tm := e.AddTimerHandle(time.Millisecond, "OnTimer")
+	cb := e.AddCallbackHandle(time.Millisecond, "OnTimer")
+	cb.Cancel()
+	time.Sleep(time.Millisecond * 12) // min interval of repeat timers is 10ms
+	timer.Tick()
+	if te.fired != 0 {
+		t.Fatalf("timers in the timing wheel should not be fired by goTimer")
+	}
+	timingwheel.Tick()
+	if te.fired != 1 || !tm.IsActive() {
+		t.Fatalf("timer should be fired by the timing wheel once, but fired %d times", te.fired)
+	}
+	tm.Cancel()
+	if len(e.rawTimers) != 0 {
+		t.Errorf("cancelled timers should be removed, but %d raw timers left", len(e.rawTimers))
+	}
+}
@@ -0,0 +1,112 @@
+package entity
+
+import (
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const worldSeparator = "@"
+
+// WorldTypeName returns the name of the entity type in the world, e.g. Avatar@s1 for Avatar in world s1
+//
+// Types of the default world "" are not qualified. Services registered in each world are entity types of qualified names.
+func WorldTypeName(typeName string, world string) string {
+	if world == "" {
+		return typeName
+	}
+	return typeName + worldSeparator + world
+}
+
+// worldOfTypeName returns the world of the qualified type name, or "" if the type name is not qualified
+func worldOfTypeName(typeName string) string {
+	if idx := strings.LastIndex(typeName, worldSeparator); idx >= 0 {
+		return typeName[idx+len(worldSeparator):]
+	}
+	return ""
+}
+
+// storageTypeName returns the type name for saving and loading entities of the type in the world
+//
+// Entities of each world are saved separately, so that data of worlds can be backed up or wiped independently. Entity IDs
+// are still global across worlds, since dispatchers route entities by IDs only: IDs generated by GenEntityID never
+// collide, and an entity ID should never be loaded in two worlds.
+func storageTypeName(typeName string, world string) string {
+	if world == "" || worldOfTypeName(typeName) == world {
+		return typeName
+	}
+	return WorldTypeName(typeName, world)
+}
+
+// resolveWorld returns the world of the new entity of the type, the world of qualified type names overrides the world
+func resolveWorld(typeName string, world string) string {
+	if typeWorld := worldOfTypeName(typeName); typeWorld != "" {
+		return typeWorld
+	}
+	return world
+}
+
+// World returns the world of the entity, "" for the default world
+func (e *Entity) World() string {
+	return e.world
+}
+
+// canEnterSpaceOfWorld checks if the entity can enter the space, entities can only enter spaces of the same world or nil spaces
+func (e *Entity) canEnterSpaceOfWorld(space *Space) bool {
+	if space.IsNil() || space.world == e.world {
+		return true
+	}
+	logger.Errorf("%s of world %q can not enter %s of world %q", e, e.world, space, space.world)
+	return false
+}
+
+// CreateEntityLocallyInWorld creates new entity of the world in the local game
+func CreateEntityLocallyInWorld(typeName string, world string, data map[string]interface{}) *Entity {
+	return createEntity(typeName, world, nil, Vector3{}, "", data)
+}
+
+// CreateEntityLocallyInWorldWithID creates new entity of the world in the local game with specified entity ID
+func CreateEntityLocallyInWorldWithID(typeName string, world string, data map[string]interface{}, id common.EntityID) *Entity {
+	return createEntity(typeName, world, nil, Vector3{}, id, data)
+}
+
+// CreateEntitySomewhereInWorld creates new entity of the world in any game
+func CreateEntitySomewhereInWorld(gameid uint16, typeName string, world string) common.EntityID {
+	return createEntitySomewhere(gameid, typeName, world, nil)
+}
+
+// LoadEntityAnywhereInWorld loads entity of the world in any game
+//
+// LoadEntityAnywhereInWorld has no effect if entity already exists on any game, even if it is in another world, since
+// entity IDs are global across worlds
+func LoadEntityAnywhereInWorld(typeName string, entityID common.EntityID, world string) {
+	dispatchercluster.SendLoadEntityAnywhere(typeName, entityID, world)
+}
+
+// LoadEntityOnGameInWorld loads entity of the world in the specified game
+func LoadEntityOnGameInWorld(typeName string, entityID common.EntityID, world string, gameid uint16) {
+	dispatchercluster.SendLoadEntityOnGame(typeName, entityID, world, gameid)
+}
+
+// CreateSpaceSomewhereInWorld creates a space of the world in any game server
+func CreateSpaceSomewhereInWorld(gameid uint16, kind int, world string) common.EntityID {
+	if kind == 0 {
+		gwlog.Panicf("Can not create nil space with kind=0. Game will create 1 nil space automatically.")
+	}
+	return createEntitySomewhere(gameid, _SPACE_ENTITY_TYPE, world, map[string]interface{}{
+		_SPACE_KIND_ATTR_KEY: kind,
+	})
+}
+
+// CreateSpaceLocallyInWorld creates a space of the world in the local game server
+func CreateSpaceLocallyInWorld(kind int, world string) *Space {
+	if kind == 0 {
+		gwlog.Panicf("Can not create nil space with kind=0. Game will create 1 nil space automatically.")
+	}
+	e := createEntity(_SPACE_ENTITY_TYPE, world, nil, Vector3{}, "", map[string]interface{}{
+		_SPACE_KIND_ATTR_KEY: kind,
+	})
+	return e.AsSpace()
+}
@@ -0,0 +1,59 @@
+package entity
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+type TestWorldEntity struct {
+	Entity
+}
+
+func (e *TestWorldEntity) DescribeEntityType(desc *EntityTypeDesc) {
+	desc.SetPersistent(true)
+}
+
+func TestStorageTypeName(t *testing.T) {
+	for _, c := range []struct {
+		typeName, world, expected string
+	}{
+		{"Avatar", "", "Avatar"},
+		{"Avatar", "s1", "Avatar@s1"},
+		{"Chat@s1", "s1", "Chat@s1"},
+		{"Chat@s1", "", "Chat@s1"},
+	} {
+		if name := storageTypeName(c.typeName, c.world); name != c.expected {
+			t.Errorf("storage type name of %s in world %q should be %s, but is %s", c.typeName, c.world, c.expected, name)
+		}
+	}
+}
+
+func TestEntityWorld(t *testing.T) {
+	RegisterEntity("TestWorldEntity", &TestWorldEntity{}, false)
+	RegisterEntity(WorldTypeName("TestWorldEntity", "s2"), &TestWorldEntity{}, true)
+
+	e := CreateEntityLocallyInWorld("TestWorldEntity", "s1", nil)
+	if e.World() != "s1" {
+		t.Fatalf("entity should be created in world s1, but is in %q", e.World())
+	}
+	if se := CreateEntityLocally(WorldTypeName("TestWorldEntity", "s2"), nil); se.World() != "s2" {
+		t.Fatalf("entity of qualified type should be created in world s2, but is in %q", se.World())
+	}
+
+	id := common.GenEntityID()
+	restoreEntity(id, e.GetMigrateData(""), false)
+	if me := GetEntity(id); me == nil || me.World() != "s1" {
+		t.Fatalf("world should be migrated, but got %v", me)
+	}
+
+	space := &Space{Kind: 1}
+	space.world = "s2"
+	if e.canEnterSpaceOfWorld(space) {
+		t.Errorf("entity should not enter space of another world")
+	}
+	space.world = "s1"
+	if !e.canEnterSpaceOfWorld(space) {
+		t.Errorf("entity should enter space of its world")
+	}
+}
@@ -0,0 +1,307 @@
+// Package eventbus publishes events to subscribers on all games of the cluster through dispatchers
+//
+// Events are emitted by Emit with payloads of any type packed by msgpack, and delivered to handlers subscribed by
+// Subscribe on every game, including the emitting game. Events of the same name are broadcasted by the dispatcher
+// selected by the name, so that they are delivered in order. Handlers are typed: the payload is unpacked to the type of
+// the argument of the handler, e.g. func(ev *LevelUpEvent).
+//
+// Events emitted by Emit are delivered at most once, and missed by games disconnected from the dispatcher. Events
+// emitted by EmitPersistent are stored in KVDB before they are delivered, and each game saves the offset of persistent
+// events it has handled in KVDB, so that events missed while the game is down or disconnected are replayed when the
+// game is ready or reconnected. Persistent events are delivered at least once: handlers might be called again with
+// events handled shortly before the game is restarted, so they should be idempotent.
+package eventbus
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwtimer"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/uuid"
+)
+
+const (
+	_KVDB_EVENT_KEY_PREFIX  = "_event_:"
+	_KVDB_OFFSET_KEY_PREFIX = "_event_offset_:"
+
+	// replayWindow is how long before the saved offset persistent events are replayed, since events emitted by
+	// different games might be delivered out of the order of their IDs, and offsets are saved periodically
+	replayWindow       = time.Second * 10
+	offsetSaveInterval = time.Second
+)
+
+// Subscription is the handler subscribed to events of the name
+type Subscription struct {
+	name      string
+	handler   reflect.Value
+	argType   reflect.Type // nil if the handler has no argument
+	cancelled bool
+}
+
+var (
+	gameid      uint16
+	snowflake   *uuid.Snowflake
+	ready       bool // persistent events are replayed after the game is ready
+	subscribers = map[string][]*Subscription{}
+
+	offsets      = map[string]string{} // event name -> the largest ID of persistent events handled
+	dirtyOffsets = common.StringSet{}
+	handled      = map[string]time.Time{} // IDs of persistent events handled recently -> times they are emitted
+	replaying    = common.StringSet{}
+	replayed     = common.StringSet{} // offsets are saved only after missed events are replayed
+)
+
+// Setup sets up the event bus of the game, which should be called after KVDB is initialized
+func Setup(gid uint16) {
+	gameid = gid
+	snowflake = uuid.NewSnowflake(gid)
+	gwtimer.AddTimer(offsetSaveInterval, saveOffsets)
+}
+
+// Subscribe subscribes the handler to events of the name on this game
+//
+// handler should be func(payload T) or func(), where T is the type which payloads of events are unpacked to.
+func Subscribe(name string, handler interface{}) *Subscription {
+	if err := checkEventName(name); err != nil {
+		gwlog.Panic(err)
+	}
+	handlerType := reflect.TypeOf(handler)
+	if handlerType == nil || handlerType.Kind() != reflect.Func || handlerType.NumIn() > 1 || handlerType.NumOut() != 0 {
+		gwlog.Panicf("handler of event %s should be func(payload T) or func(), but is %T", name, handler)
+	}
+
+	sub := &Subscription{name: name, handler: reflect.ValueOf(handler)}
+	if handlerType.NumIn() == 1 {
+		sub.argType = handlerType.In(0)
+	}
+	subscribers[name] = append(subscribers[name], sub)
+	if len(subscribers[name]) == 1 && ready {
+		replay(name)
+	}
+	return sub
+}
+
+// Cancel unsubscribes the handler, which is not called after Cancel returns
+func (sub *Subscription) Cancel() {
+	if sub.cancelled {
+		return
+	}
+	sub.cancelled = true
+
+	subs := subscribers[sub.name]
+	for i, s := range subs {
+		if s == sub {
+			subscribers[sub.name] = append(subs[:i:i], subs[i+1:]...)
+			break
+		}
+	}
+	if len(subscribers[sub.name]) == 0 {
+		delete(subscribers, sub.name)
+	}
+}
+
+// Emit emits the event to subscribers on all games, the event is delivered at most once
+func Emit(name string, payload interface{}) error {
+	data, err := packPayload(name, payload)
+	if err != nil {
+		return err
+	}
+	return dispatchercluster.SendEmitEvent(name, "", data)
+}
+
+// EmitPersistent stores the event in KVDB and emits it to subscribers on all games, the event is delivered at least once
+//
+// The event is emitted after it is stored. If KVDB fails, the error is logged and the event is still emitted, but it
+// is not replayed to games missing it.
+func EmitPersistent(name string, payload interface{}) error {
+	data, err := packPayload(name, payload)
+	if err != nil {
+		return err
+	}
+	if snowflake == nil {
+		return errors.Errorf("emit persistent event %s: event bus is not set up", name)
+	}
+
+	id := snowflake.Gen()
+	kvdb.Put(eventKey(name, id), string(data), func(err error) {
+		if err != nil {
+			gwlog.Errorf("eventbus: store persistent event %s %s failed: %s", name, id, err)
+		}
+		if err := dispatchercluster.SendEmitEvent(name, id, data); err != nil {
+			gwlog.Errorf("eventbus: emit persistent event %s %s failed: %s", name, id, err)
+		}
+	})
+	return nil
+}
+
+func packPayload(name string, payload interface{}) ([]byte, error) {
+	if err := checkEventName(name); err != nil {
+		return nil, err
+	}
+	data, err := netutil.MSG_PACKER.PackMsg(payload, nil)
+	if err != nil {
+		return nil, errors.Wrapf(err, "pack payload of event %s failed", name)
+	}
+	return data, nil
+}
+
+func checkEventName(name string) error {
+	if name == "" || strings.Contains(name, ":") {
+		return errors.Errorf("invalid event name: %#v", name)
+	}
+	return nil
+}
+
+// OnEvent is called when the event is broadcasted to this game, id is empty if the event is not persistent
+func OnEvent(name string, id string, data []byte) {
+	if id == "" {
+		deliver(name, data)
+		return
+	}
+
+	if _, ok := handled[id]; ok {
+		return
+	}
+	ts, ok := uuid.SnowflakeTime(id)
+	if !ok {
+		gwlog.Errorf("eventbus: invalid ID of persistent event %s: %s", name, id)
+		return
+	}
+	handled[id] = ts
+	deliver(name, data)
+	if id > offsets[name] {
+		offsets[name] = id
+		dirtyOffsets.Add(name)
+	}
+}
+
+func deliver(name string, data []byte) {
+	for _, sub := range subscribers[name] {
+		if sub.cancelled {
+			continue // cancelled by previous handlers
+		}
+
+		var args []reflect.Value
+		if sub.argType != nil {
+			arg := reflect.New(sub.argType)
+			if err := netutil.MSG_PACKER.UnpackMsg(data, arg.Interface()); err != nil {
+				gwlog.Errorf("eventbus: unpack payload of event %s to %s failed: %s", name, sub.argType, err)
+				continue
+			}
+			args = []reflect.Value{arg.Elem()}
+		}
+		gwutils.RunPanicless(func() {
+			sub.handler.Call(args)
+		})
+	}
+}
+
+// OnDeploymentReady replays persistent events missed by this game before it is ready
+func OnDeploymentReady() {
+	if ready {
+		return
+	}
+	ready = true
+	for name := range subscribers {
+		replay(name)
+	}
+}
+
+// OnDispatcherReconnected replays persistent events broadcasted by the dispatcher when this game is disconnected
+func OnDispatcherReconnected(dispid uint16) {
+	if !ready {
+		return
+	}
+	for name := range subscribers {
+		if dispatchercluster.SrvIDToDispatcherID(name) == dispid {
+			replay(name)
+		}
+	}
+}
+
+// replay delivers persistent events of the name emitted after the saved offset of this game
+func replay(name string) {
+	if replaying.Contains(name) || snowflake == nil {
+		return
+	}
+	replaying.Add(name)
+
+	kvdb.Get(offsetKey(name), func(offset string, err error) {
+		if err != nil {
+			replaying.Remove(name)
+			gwlog.Errorf("eventbus: get offset of event %s failed: %s", name, err)
+			return
+		}
+		if offset == "" {
+			// first subscribed on this game, events emitted before are not replayed
+			replaying.Remove(name)
+			replayed.Add(name)
+			if offsets[name] == "" {
+				offsets[name] = uuid.MinSnowflake(time.Now())
+				dirtyOffsets.Add(name)
+			}
+			return
+		}
+
+		ts, _ := uuid.SnowflakeTime(offset)
+		kvdb.GetRange(eventKey(name, uuid.MinSnowflake(ts.Add(-replayWindow))), eventKeyEnd(name), func(items []kvdbtypes.KVItem, err error) {
+			replaying.Remove(name)
+			if err != nil {
+				gwlog.Errorf("eventbus: replay persistent events %s failed: %s", name, err)
+				return
+			}
+			replayed.Add(name)
+
+			prefix := eventKey(name, "")
+			for _, item := range items {
+				OnEvent(name, strings.TrimPrefix(item.Key, prefix), []byte(item.Val))
+			}
+			gwlog.Infof("eventbus: replayed %d persistent events %s since %s", len(items), name, ts)
+		})
+	})
+}
+
+// saveOffsets saves offsets of persistent events handled since the last save, and forgets old handled events
+func saveOffsets() {
+	for name := range dirtyOffsets {
+		if !replayed.Contains(name) {
+			continue
+		}
+		dirtyOffsets.Remove(name)
+		key, offset := offsetKey(name), offsets[name]
+		kvdb.Put(key, offset, func(err error) {
+			if err != nil {
+				gwlog.Errorf("eventbus: save offset %s of event %s failed: %s", offset, name, err)
+			}
+		})
+	}
+
+	expire := time.Now().Add(-replayWindow * 2)
+	for id, ts := range handled {
+		if ts.Before(expire) {
+			delete(handled, id)
+		}
+	}
+}
+
+func eventKey(name string, id string) string {
+	return _KVDB_EVENT_KEY_PREFIX + name + ":" + id
+}
+
+func eventKeyEnd(name string) string {
+	return _KVDB_EVENT_KEY_PREFIX + name + ";" // ';' is next to ':'
+}
+
+func offsetKey(name string) string {
+	return fmt.Sprintf("%s%d:%s", _KVDB_OFFSET_KEY_PREFIX, gameid, name)
+}
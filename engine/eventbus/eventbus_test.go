@@ -0,0 +1,89 @@
+package eventbus
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/uuid"
+)
+
+type testEvent struct {
+	Name  string
+	Level int
+}
+
+func packTestEvent(t *testing.T, ev interface{}) []byte {
+	data, err := netutil.MSG_PACKER.PackMsg(ev, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDeliver(t *testing.T) {
+	var typed []testEvent
+	var generic []map[string]interface{}
+	var notified int
+	sub1 := Subscribe("test.deliver", func(ev *testEvent) { typed = append(typed, *ev) })
+	sub2 := Subscribe("test.deliver", func(ev map[string]interface{}) { generic = append(generic, ev) })
+	sub3 := Subscribe("test.deliver", func() { notified++ })
+	Subscribe("test.deliver", func(ev *testEvent) { panic("handlers panicking should not affect others") })
+
+	OnEvent("test.deliver", "", packTestEvent(t, &testEvent{Name: "alice", Level: 3}))
+	if len(typed) != 1 || typed[0] != (testEvent{Name: "alice", Level: 3}) {
+		t.Errorf("payload should be unpacked to the type of the handler, but got %+v", typed)
+	}
+	if len(generic) != 1 || generic[0]["Name"] != "alice" || notified != 1 {
+		t.Errorf("event should be delivered to all handlers, but got %+v, %d", generic, notified)
+	}
+
+	sub2.Cancel()
+	sub3.Cancel()
+	OnEvent("test.deliver", "", packTestEvent(t, &testEvent{Name: "bob"}))
+	if len(typed) != 2 || len(generic) != 1 || notified != 1 {
+		t.Errorf("cancelled handlers should not be called")
+	}
+	sub1.Cancel()
+	sub1.Cancel()
+}
+
+func TestPersistentEventDedup(t *testing.T) {
+	var received []int
+	sub := Subscribe("test.persistent", func(ev *testEvent) { received = append(received, ev.Level) })
+	defer sub.Cancel()
+
+	sf := uuid.NewSnowflake(1)
+	id1, id2 := sf.Gen(), sf.Gen()
+	OnEvent("test.persistent", id2, packTestEvent(t, &testEvent{Level: 2}))
+	OnEvent("test.persistent", id1, packTestEvent(t, &testEvent{Level: 1}))
+	OnEvent("test.persistent", id2, packTestEvent(t, &testEvent{Level: 2})) // replayed
+	OnEvent("test.persistent", "invalid", packTestEvent(t, &testEvent{Level: 3}))
+	if len(received) != 2 || received[0] != 2 || received[1] != 1 {
+		t.Errorf("persistent events should be delivered once on the game, but got %v", received)
+	}
+	if offsets["test.persistent"] != id2 || !dirtyOffsets.Contains("test.persistent") {
+		t.Errorf("offset should be the largest ID handled, but is %s", offsets["test.persistent"])
+	}
+
+	// handled events are forgotten after the replay window
+	handled[id1] = time.Now().Add(-replayWindow * 3)
+	saveOffsets()
+	if _, ok := handled[id1]; ok {
+		t.Errorf("old handled events should be forgotten")
+	}
+	if !dirtyOffsets.Contains("test.persistent") {
+		t.Errorf("offsets should not be saved before missed events are replayed")
+	}
+}
+
+func TestInvalidEventName(t *testing.T) {
+	for _, name := range []string{"", "a:b"} {
+		if err := Emit(name, nil); err == nil {
+			t.Errorf("event name %#v should be rejected", name)
+		}
+	}
+	if err := EmitPersistent("test.persistent", make(chan int)); err == nil {
+		t.Errorf("payloads which can not be packed should be rejected")
+	}
+}
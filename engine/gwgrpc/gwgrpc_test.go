@@ -0,0 +1,195 @@
+package gwgrpc
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"math/big"
+	"net"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/status"
+)
+
+type testEntityServiceServer struct {
+	lastCall *CallEntityRequest
+}
+
+func (s *testEntityServiceServer) CallEntity(ctx context.Context, req *CallEntityRequest) (*CallEntityReply, error) {
+	s.lastCall = req
+	return &CallEntityReply{}, nil
+}
+
+func (s *testEntityServiceServer) CallService(ctx context.Context, req *CallServiceRequest) (*CallServiceReply, error) {
+	return nil, status.Errorf(codes.NotFound, "service %s not found", req.ServiceName)
+}
+
+func (s *testEntityServiceServer) CreateEntity(ctx context.Context, req *CreateEntityRequest) (*CreateEntityReply, error) {
+	return &CreateEntityReply{EntityID: req.TypeName + "ID"}, nil
+}
+
+func (s *testEntityServiceServer) LoadEntity(ctx context.Context, req *LoadEntityRequest) (*LoadEntityReply, error) {
+	return &LoadEntityReply{}, nil
+}
+
+func TestEntityService(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &testEntityServiceServer{}
+	server := NewServer("secret", func(token string, action string) error {
+		if token != "support" {
+			return status.Errorf(codes.Unauthenticated, "invalid token")
+		} else if action != "call_entity:Pay" {
+			return status.Errorf(codes.PermissionDenied, "%s is not allowed", action)
+		}
+		return nil
+	}, nil)
+	RegisterEntityServiceServer(server, srv)
+	go server.Serve(ln)
+	defer server.Stop()
+
+	cc, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	client := NewEntityServiceClient(cc)
+
+	ctx := WithToken(context.Background(), "secret")
+	reply, err := client.CreateEntity(ctx, &CreateEntityRequest{TypeName: "Avatar", GameID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reply.EntityID != "AvatarID" {
+		t.Errorf("wrong reply: %v", reply)
+	}
+
+	if _, err := client.CallEntity(ctx, &CallEntityRequest{EntityID: "eid", Method: "Pay", ArgsJSON: "[100]"}); err != nil {
+		t.Fatal(err)
+	}
+	if srv.lastCall == nil || srv.lastCall.Method != "Pay" || srv.lastCall.ArgsJSON != "[100]" {
+		t.Errorf("wrong call: %v", srv.lastCall)
+	}
+
+	if _, err := client.CallService(ctx, &CallServiceRequest{ServiceName: "NoService"}); status.Code(err) != codes.NotFound {
+		t.Errorf("should return NotFound, but returns %v", err)
+	}
+
+	_, err = client.LoadEntity(WithToken(context.Background(), "wrong"), &LoadEntityRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("wrong token should be rejected, but returns %v", err)
+	}
+	_, err = client.LoadEntity(context.Background(), &LoadEntityRequest{})
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("missing token should be rejected, but returns %v", err)
+	}
+
+	// requests carrying other tokens are authorized by actions
+	supportCtx := WithToken(context.Background(), "support")
+	if _, err := client.CallEntity(supportCtx, &CallEntityRequest{EntityID: "eid", Method: "Pay"}); err != nil {
+		t.Errorf("allowed action should be served, but returns %v", err)
+	}
+	_, err = client.CreateEntity(supportCtx, &CreateEntityRequest{TypeName: "Avatar"})
+	if status.Code(err) != codes.PermissionDenied {
+		t.Errorf("action not allowed should be rejected, but returns %v", err)
+	}
+}
+
+// newTestCertificate creates a self-signed certificate of 127.0.0.1
+func newTestCertificate(t *testing.T) (tls.Certificate, *x509.CertPool) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IsCA:         true,
+
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(cert)
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}, pool
+}
+
+func TestEntityServiceTLS(t *testing.T) {
+	cert, rootCAs := newTestCertificate(t)
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := NewServer("secret", nil, &tls.Config{Certificates: []tls.Certificate{cert}})
+	RegisterEntityServiceServer(server, &testEntityServiceServer{})
+	go server.Serve(ln)
+	defer server.Stop()
+
+	cc, err := grpc.Dial(ln.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(&tls.Config{RootCAs: rootCAs})))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer cc.Close()
+	ctx, cancel := context.WithTimeout(WithToken(context.Background(), "secret"), time.Second*5)
+	defer cancel()
+	if _, err := NewEntityServiceClient(cc).CreateEntity(ctx, &CreateEntityRequest{TypeName: "Avatar"}); err != nil {
+		t.Fatalf("call using TLS failed: %v", err)
+	}
+
+	insecureCC, err := grpc.Dial(ln.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer insecureCC.Close()
+	ctx, cancel = context.WithTimeout(WithToken(context.Background(), "secret"), time.Second)
+	defer cancel()
+	if _, err := NewEntityServiceClient(insecureCC).CreateEntity(ctx, &CreateEntityRequest{TypeName: "Avatar"}); err == nil {
+		t.Errorf("call without TLS should fail")
+	}
+}
+
+func TestRequestAction(t *testing.T) {
+	if action := RequestAction(&CallServiceRequest{ServiceName: "MailService", Method: "Send"}); action != "call_service:MailService.Send" {
+		t.Errorf("wrong action: %s", action)
+	}
+	if action := RequestAction(&LoadEntityRequest{TypeName: "Avatar", EntityID: "eid"}); action != "load_entity:Avatar" {
+		t.Errorf("wrong action: %s", action)
+	}
+}
+
+func TestDecodeArgs(t *testing.T) {
+	args, err := DecodeArgs(`[1, 1.5, "abc", [2], {"k": 3}]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args[0] != int64(1) || args[1] != 1.5 || args[2] != "abc" {
+		t.Errorf("wrong args: %v", args)
+	}
+	if args[3].([]interface{})[0] != int64(2) || args[4].(map[string]interface{})["k"] != int64(3) {
+		t.Errorf("wrong nested args: %v", args)
+	}
+
+	if _, err := DecodeArgs(`{"not": "array"}`); err == nil {
+		t.Errorf("should fail to decode non-array args")
+	}
+}
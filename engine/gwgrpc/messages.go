@@ -0,0 +1,83 @@
+package gwgrpc
+
+import (
+	"github.com/golang/protobuf/proto"
+)
+
+// Messages defined in goworld.proto
+
+// CallEntityRequest is the request of EntityService.CallEntity
+type CallEntityRequest struct {
+	EntityID string `protobuf:"bytes,1,opt,name=entity_id,json=entityId,proto3" json:"entity_id,omitempty"`
+	Method   string `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	ArgsJSON string `protobuf:"bytes,3,opt,name=args_json,json=argsJson,proto3" json:"args_json,omitempty"`
+}
+
+func (m *CallEntityRequest) Reset()         { *m = CallEntityRequest{} }
+func (m *CallEntityRequest) String() string { return proto.CompactTextString(m) }
+func (*CallEntityRequest) ProtoMessage()    {}
+
+// CallEntityReply is the reply of EntityService.CallEntity
+type CallEntityReply struct {
+}
+
+func (m *CallEntityReply) Reset()         { *m = CallEntityReply{} }
+func (m *CallEntityReply) String() string { return proto.CompactTextString(m) }
+func (*CallEntityReply) ProtoMessage()    {}
+
+// CallServiceRequest is the request of EntityService.CallService
+type CallServiceRequest struct {
+	ServiceName string `protobuf:"bytes,1,opt,name=service_name,json=serviceName,proto3" json:"service_name,omitempty"`
+	Method      string `protobuf:"bytes,2,opt,name=method,proto3" json:"method,omitempty"`
+	ArgsJSON    string `protobuf:"bytes,3,opt,name=args_json,json=argsJson,proto3" json:"args_json,omitempty"`
+}
+
+func (m *CallServiceRequest) Reset()         { *m = CallServiceRequest{} }
+func (m *CallServiceRequest) String() string { return proto.CompactTextString(m) }
+func (*CallServiceRequest) ProtoMessage()    {}
+
+// CallServiceReply is the reply of EntityService.CallService
+type CallServiceReply struct {
+}
+
+func (m *CallServiceReply) Reset()         { *m = CallServiceReply{} }
+func (m *CallServiceReply) String() string { return proto.CompactTextString(m) }
+func (*CallServiceReply) ProtoMessage()    {}
+
+// CreateEntityRequest is the request of EntityService.CreateEntity
+type CreateEntityRequest struct {
+	TypeName string `protobuf:"bytes,1,opt,name=type_name,json=typeName,proto3" json:"type_name,omitempty"`
+	GameID   uint32 `protobuf:"varint,2,opt,name=game_id,json=gameId,proto3" json:"game_id,omitempty"`
+}
+
+func (m *CreateEntityRequest) Reset()         { *m = CreateEntityRequest{} }
+func (m *CreateEntityRequest) String() string { return proto.CompactTextString(m) }
+func (*CreateEntityRequest) ProtoMessage()    {}
+
+// CreateEntityReply is the reply of EntityService.CreateEntity
+type CreateEntityReply struct {
+	EntityID string `protobuf:"bytes,1,opt,name=entity_id,json=entityId,proto3" json:"entity_id,omitempty"`
+}
+
+func (m *CreateEntityReply) Reset()         { *m = CreateEntityReply{} }
+func (m *CreateEntityReply) String() string { return proto.CompactTextString(m) }
+func (*CreateEntityReply) ProtoMessage()    {}
+
+// LoadEntityRequest is the request of EntityService.LoadEntity
+type LoadEntityRequest struct {
+	TypeName string `protobuf:"bytes,1,opt,name=type_name,json=typeName,proto3" json:"type_name,omitempty"`
+	EntityID string `protobuf:"bytes,2,opt,name=entity_id,json=entityId,proto3" json:"entity_id,omitempty"`
+	GameID   uint32 `protobuf:"varint,3,opt,name=game_id,json=gameId,proto3" json:"game_id,omitempty"`
+}
+
+func (m *LoadEntityRequest) Reset()         { *m = LoadEntityRequest{} }
+func (m *LoadEntityRequest) String() string { return proto.CompactTextString(m) }
+func (*LoadEntityRequest) ProtoMessage()    {}
+
+// LoadEntityReply is the reply of EntityService.LoadEntity
+type LoadEntityReply struct {
+}
+
+func (m *LoadEntityReply) Reset()         { *m = LoadEntityReply{} }
+func (m *LoadEntityReply) String() string { return proto.CompactTextString(m) }
+func (*LoadEntityReply) ProtoMessage()    {}
@@ -0,0 +1,252 @@
+package gwgrpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/pkg/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// EntityServiceServer is the server API for EntityService defined in goworld.proto
+type EntityServiceServer interface {
+	CallEntity(context.Context, *CallEntityRequest) (*CallEntityReply, error)
+	CallService(context.Context, *CallServiceRequest) (*CallServiceReply, error)
+	CreateEntity(context.Context, *CreateEntityRequest) (*CreateEntityReply, error)
+	LoadEntity(context.Context, *LoadEntityRequest) (*LoadEntityReply, error)
+}
+
+// RegisterEntityServiceServer registers the EntityService implementation to the gRPC server
+func RegisterEntityServiceServer(s *grpc.Server, srv EntityServiceServer) {
+	s.RegisterService(&entityServiceDesc, srv)
+}
+
+// EntityServiceClient is the client API for EntityService, which can be used by external services written in Go
+type EntityServiceClient struct {
+	cc *grpc.ClientConn
+}
+
+// NewEntityServiceClient creates a EntityServiceClient using the client connection
+func NewEntityServiceClient(cc *grpc.ClientConn) *EntityServiceClient {
+	return &EntityServiceClient{cc}
+}
+
+// CallEntity calls EntityService.CallEntity
+func (c *EntityServiceClient) CallEntity(ctx context.Context, in *CallEntityRequest, opts ...grpc.CallOption) (*CallEntityReply, error) {
+	out := new(CallEntityReply)
+	err := c.cc.Invoke(ctx, "/goworld.EntityService/CallEntity", in, out, opts...)
+	return out, err
+}
+
+// CallService calls EntityService.CallService
+func (c *EntityServiceClient) CallService(ctx context.Context, in *CallServiceRequest, opts ...grpc.CallOption) (*CallServiceReply, error) {
+	out := new(CallServiceReply)
+	err := c.cc.Invoke(ctx, "/goworld.EntityService/CallService", in, out, opts...)
+	return out, err
+}
+
+// CreateEntity calls EntityService.CreateEntity
+func (c *EntityServiceClient) CreateEntity(ctx context.Context, in *CreateEntityRequest, opts ...grpc.CallOption) (*CreateEntityReply, error) {
+	out := new(CreateEntityReply)
+	err := c.cc.Invoke(ctx, "/goworld.EntityService/CreateEntity", in, out, opts...)
+	return out, err
+}
+
+// LoadEntity calls EntityService.LoadEntity
+func (c *EntityServiceClient) LoadEntity(ctx context.Context, in *LoadEntityRequest, opts ...grpc.CallOption) (*LoadEntityReply, error) {
+	out := new(LoadEntityReply)
+	err := c.cc.Invoke(ctx, "/goworld.EntityService/LoadEntity", in, out, opts...)
+	return out, err
+}
+
+// WithToken returns a new context which carries the token for authentication
+func WithToken(ctx context.Context, token string) context.Context {
+	return metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+token)
+}
+
+// Authorizer authorizes the action of the request carrying the token, which returns errors of codes.Unauthenticated or
+// codes.PermissionDenied if the request should be rejected, e.g. by roles of users in [rbac] config
+type Authorizer func(token string, action string) error
+
+// NewServer creates a gRPC server which authenticates all requests using the token
+//
+// Requests carrying other tokens are authorized by the authorizer with the action of the request (see RequestAction) if
+// authorizer is not nil. The server is served using TLS if tlsConfig is not nil, so that tokens are not sent in plain
+// text.
+func NewServer(token string, authorizer Authorizer, tlsConfig *tls.Config) *grpc.Server {
+	opts := []grpc.ServerOption{grpc.UnaryInterceptor(tokenAuthInterceptor(token, authorizer))}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+	return grpc.NewServer(opts...)
+}
+
+func tokenAuthInterceptor(token string, authorizer Authorizer) grpc.UnaryServerInterceptor {
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		reqToken := getToken(ctx)
+		if subtle.ConstantTimeCompare([]byte(reqToken), []byte(token)) == 1 {
+			return handler(ctx, req)
+		}
+		if authorizer == nil || reqToken == "" {
+			return nil, status.Errorf(codes.Unauthenticated, "invalid token")
+		}
+		if err := authorizer(reqToken, RequestAction(req)); err != nil {
+			return nil, err
+		}
+		return handler(ctx, req)
+	}
+}
+
+// getToken returns the bearer token in request metadata, or "" if not found
+func getToken(ctx context.Context) string {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return ""
+	}
+
+	for _, auth := range md.Get("authorization") {
+		if strings.HasPrefix(auth, "Bearer ") {
+			return strings.TrimPrefix(auth, "Bearer ")
+		}
+	}
+	return ""
+}
+
+// RequestAction returns the RBAC action of the request: call_entity:<method>, call_service:<service>.<method>,
+// create_entity:<type> or load_entity:<type>
+func RequestAction(req interface{}) string {
+	switch r := req.(type) {
+	case *CallEntityRequest:
+		return "call_entity:" + r.Method
+	case *CallServiceRequest:
+		return "call_service:" + r.ServiceName + "." + r.Method
+	case *CreateEntityRequest:
+		return "create_entity:" + r.TypeName
+	case *LoadEntityRequest:
+		return "load_entity:" + r.TypeName
+	default:
+		return fmt.Sprintf("unknown:%T", req)
+	}
+}
+
+// DecodeArgs decodes method arguments from JSON array
+//
+// Integers are decoded as int64 and other numbers are decoded as float64, so that arguments can be converted to
+// parameter types of entity methods.
+func DecodeArgs(argsJSON string) ([]interface{}, error) {
+	if argsJSON == "" {
+		return nil, nil
+	}
+
+	decoder := json.NewDecoder(strings.NewReader(argsJSON))
+	decoder.UseNumber()
+	var args []interface{}
+	if err := decoder.Decode(&args); err != nil {
+		return nil, errors.Wrap(err, "decode args failed")
+	}
+
+	for i, arg := range args {
+		args[i] = convertNumbers(arg)
+	}
+	return args, nil
+}
+
+func convertNumbers(v interface{}) interface{} {
+	switch val := v.(type) {
+	case json.Number:
+		if i, err := val.Int64(); err == nil {
+			return i
+		}
+		f, _ := val.Float64()
+		return f
+	case []interface{}:
+		for i, elem := range val {
+			val[i] = convertNumbers(elem)
+		}
+	case map[string]interface{}:
+		for k, elem := range val {
+			val[k] = convertNumbers(elem)
+		}
+	}
+	return v
+}
+
+var entityServiceDesc = grpc.ServiceDesc{
+	ServiceName: "goworld.EntityService",
+	HandlerType: (*EntityServiceServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "CallEntity",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(CallEntityRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(EntityServiceServer).CallEntity(ctx, req.(*CallEntityRequest))
+				}
+				return invoke(ctx, in, srv, "/goworld.EntityService/CallEntity", interceptor, call)
+			},
+		},
+		{
+			MethodName: "CallService",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(CallServiceRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(EntityServiceServer).CallService(ctx, req.(*CallServiceRequest))
+				}
+				return invoke(ctx, in, srv, "/goworld.EntityService/CallService", interceptor, call)
+			},
+		},
+		{
+			MethodName: "CreateEntity",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(CreateEntityRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(EntityServiceServer).CreateEntity(ctx, req.(*CreateEntityRequest))
+				}
+				return invoke(ctx, in, srv, "/goworld.EntityService/CreateEntity", interceptor, call)
+			},
+		},
+		{
+			MethodName: "LoadEntity",
+			Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+				in := new(LoadEntityRequest)
+				if err := dec(in); err != nil {
+					return nil, err
+				}
+				call := func(ctx context.Context, req interface{}) (interface{}, error) {
+					return srv.(EntityServiceServer).LoadEntity(ctx, req.(*LoadEntityRequest))
+				}
+				return invoke(ctx, in, srv, "/goworld.EntityService/LoadEntity", interceptor, call)
+			},
+		},
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "goworld.proto",
+}
+
+func invoke(ctx context.Context, in interface{}, srv interface{}, fullMethod string, interceptor grpc.UnaryServerInterceptor, handler grpc.UnaryHandler) (interface{}, error) {
+	if interceptor == nil {
+		return handler(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: fullMethod,
+	}
+	return interceptor(ctx, in, info, handler)
+}
@@ -28,6 +28,14 @@ var (
 	FatalLevel Level = Level(zap.FatalLevel)
 )
 
+// Log formats
+const (
+	// FormatConsole is the human readable log format
+	FormatConsole = "console"
+	// FormatJSON is the log format of JSON lines, which is searchable by log systems like ELK and Loki
+	FormatJSON = "json"
+)
+
 type logFormatFunc func(format string, args ...interface{})
 
 // Level is type of log levels
@@ -38,6 +46,7 @@ var (
 	logger       *zap.Logger
 	sugar        *zap.SugaredLogger
 	source       string
+	globalFields []interface{}
 	currentLevel Level
 )
 
@@ -75,6 +84,24 @@ func SetLevel(lv Level) {
 	cfg.Level.SetLevel(lv)
 }
 
+// SetFormat sets the log format: console or json
+func SetFormat(format string) {
+	format = strings.ToLower(format)
+	if format != FormatConsole && format != FormatJSON {
+		Errorf("SetFormat: unknown log format: %s", format)
+		return
+	}
+
+	cfg.Encoding = format
+	rebuildLoggerFromCfg()
+}
+
+// AddGlobalFields adds fields to all logs, e.g. AddGlobalFields("gameid", 1)
+func AddGlobalFields(keysAndValues ...interface{}) {
+	globalFields = append(globalFields, keysAndValues...)
+	rebuildLoggerFromCfg()
+}
+
 // GetLevel get the current log level
 func GetLevel() Level {
 	return currentLevel
@@ -112,7 +139,9 @@ func ParseLevel(s string) Level {
 }
 
 func rebuildLoggerFromCfg() {
-	if newLogger, err := cfg.Build(); err == nil {
+	buildCfg := cfg
+	buildCfg.OutputPaths = getOutputPaths(cfg.OutputPaths)
+	if newLogger, err := buildCfg.Build(); err == nil {
 		if logger != nil {
 			logger.Sync()
 		}
@@ -121,7 +150,7 @@ func rebuildLoggerFromCfg() {
 		if source != "" {
 			logger = logger.With(zap.String("source", source))
 		}
-		setSugar(logger.Sugar())
+		setSugar(logger.Sugar().With(globalFields...))
 	} else {
 		panic(err)
 	}
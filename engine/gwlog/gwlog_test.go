@@ -1,6 +1,14 @@
 package gwlog
 
-import "testing"
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
 
 func TestGWLog(t *testing.T) {
 	SetSource("gwlog_test")
@@ -46,3 +54,111 @@ func TestGWLog(t *testing.T) {
 		//Fatalf("this is a fatal %d", 5)
 	}()
 }
+
+func TestGWLogWith(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "gwlog_with_test.log")
+	SetOutput([]string{logFile})
+	SetFormat(FormatJSON)
+	SetLevel(DebugLevel)
+	defer func() {
+		SetFormat(FormatConsole)
+		SetOutput([]string{"stderr"})
+	}()
+
+	logger := With("entity", "E1").With("space", "S1")
+	ctxLogger := FromContext(NewContext(context.Background(), logger))
+	ctxLogger.Infof("entering space %d", 1)
+	logger.With("attr", "hp").Warnf("attr changed")
+	FromContext(context.Background()).Infof("no fields")
+
+	data, err := ioutil.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(data)), "\n")
+	if len(lines) != 3 {
+		t.Fatalf("expect 3 log lines, but got %d: %s", len(lines), data)
+	}
+
+	var entry map[string]interface{}
+	if err := json.Unmarshal([]byte(lines[0]), &entry); err != nil {
+		t.Fatalf("log is not JSON: %s", lines[0])
+	}
+	if entry["message"] != "entering space 1" || entry["entity"] != "E1" || entry["space"] != "S1" || entry["goroutine"] == nil {
+		t.Errorf("wrong log: %s", lines[0])
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &entry); err != nil || entry["attr"] != "hp" || entry["level"] != "warn" {
+		t.Errorf("wrong log: %s", lines[1])
+	}
+	entry = nil
+	if err := json.Unmarshal([]byte(lines[2]), &entry); err != nil || entry["entity"] != nil {
+		t.Errorf("wrong log: %s", lines[2])
+	}
+}
+
+func TestModuleLevel(t *testing.T) {
+	SetLevel(InfoLevel)
+	defer SetLevel(DebugLevel)
+
+	aoiLogger := Module("test_aoi").With("space", "S1")
+	storageLogger := Module("test_storage")
+	if aoiLogger.Enabled(DebugLevel) || storageLogger.Enabled(DebugLevel) {
+		t.Fatalf("modules should follow the global log level")
+	}
+
+	if !SetModuleLevel("test_aoi", DebugLevel) {
+		t.Fatalf("set module level failed")
+	}
+	if !aoiLogger.Enabled(DebugLevel) || storageLogger.Enabled(DebugLevel) {
+		t.Fatalf("only test_aoi should be enabled at debug level")
+	}
+	aoiLogger.Debugf("this is a debug of module %s", "test_aoi")
+
+	if !SetModuleLevel("test_storage", ErrorLevel) || storageLogger.Enabled(WarnLevel) {
+		t.Fatalf("test_storage should be disabled at warn level")
+	}
+	storageLogger.Warnf("SHOULD NOT SEE THIS!")
+
+	for _, ml := range GetModuleLevels() {
+		if ml.Module == "test_aoi" && (ml.Level != "debug" || !ml.IsSet) {
+			t.Errorf("wrong module level: %+v", ml)
+		}
+	}
+
+	if !ResetModuleLevel("test_aoi") || aoiLogger.Enabled(DebugLevel) {
+		t.Fatalf("test_aoi should follow the global log level after reset")
+	}
+	if SetModuleLevel("test_unknown", DebugLevel) {
+		t.Fatalf("set level of unknown module should fail")
+	}
+}
+
+func TestLoggerSugarCache(t *testing.T) {
+	logger := Module("test_cache").With("entity", "E1")
+	cached := func() *cachedSugar {
+		c, _ := logger.cached.Load().(*cachedSugar)
+		return c
+	}
+
+	logger.sugar()
+	c := cached()
+	if c == nil || c.base != sugar {
+		t.Fatalf("sugared logger should be cached")
+	}
+	logger.sugar()
+	if cached() != c {
+		t.Errorf("cached sugared logger should be reused")
+	}
+
+	SetSource("gwlog_test_cache")
+	logger.sugar()
+	if c2 := cached(); c2 == c || c2.base != sugar {
+		t.Errorf("cached sugared logger should be rebuilt after the global logger is rebuilt")
+	}
+}
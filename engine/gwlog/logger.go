@@ -0,0 +1,134 @@
+package gwlog
+
+import (
+	"bytes"
+	"context"
+	"runtime"
+	"runtime/debug"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Logger logs with contextual fields, e.g.
+//
+//	gwlog.With("entity", e.ID, "space", space.ID).Infof("entering space ...")
+type Logger struct {
+	fields []interface{} // alternating keys and values
+	module *moduleLevel  // level of module logger, nil if the logger follows the global log level
+	cached atomic.Value  // *cachedSugar built from the global sugared logger, rebuilt if the global one is rebuilt
+}
+
+type cachedSugar struct {
+	base  *zap.SugaredLogger
+	sugar *zap.SugaredLogger
+}
+
+type loggerContextKey struct{}
+
+// With returns the logger with contextual fields, which are alternating keys and values
+func With(keysAndValues ...interface{}) *Logger {
+	return (&Logger{}).With(keysAndValues...)
+}
+
+// With returns a new logger with fields of the logger and more contextual fields
+func (l *Logger) With(keysAndValues ...interface{}) *Logger {
+	fields := make([]interface{}, 0, len(l.fields)+len(keysAndValues))
+	fields = append(fields, l.fields...)
+	fields = append(fields, keysAndValues...)
+	return &Logger{fields: fields, module: l.module}
+}
+
+// NewContext returns the context carrying the logger, so that goroutines handling the context log with its fields
+func NewContext(ctx context.Context, l *Logger) context.Context {
+	return context.WithValue(ctx, loggerContextKey{}, l)
+}
+
+// FromContext returns the logger carried by the context, or the logger without fields if not found
+func FromContext(ctx context.Context) *Logger {
+	if l, ok := ctx.Value(loggerContextKey{}).(*Logger); ok {
+		return l
+	}
+	return &Logger{}
+}
+
+// Enabled checks if logs of the level are enabled for the logger
+func (l *Logger) Enabled(lv Level) bool {
+	if l.module != nil {
+		return l.module.Enabled(lv)
+	}
+	return cfg.Level.Enabled(lv)
+}
+
+// sugar returns the sugared logger with fields of the logger, the time and the goroutine ID
+func (l *Logger) sugar() *zap.SugaredLogger {
+	base := sugar
+	var s *zap.SugaredLogger
+	if c, ok := l.cached.Load().(*cachedSugar); ok && c.base == base {
+		s = c.sugar
+	} else {
+		s = base
+		if l.module != nil {
+			s = l.module.sugar(s)
+		}
+		s = s.With(l.fields...)
+		l.cached.Store(&cachedSugar{base: base, sugar: s})
+	}
+	return s.With(zap.Time("ts", time.Now()), zap.Int64("goroutine", getGoroutineID()))
+}
+
+func getGoroutineID() int64 {
+	var buf [64]byte
+	stack := buf[:runtime.Stack(buf[:], false)]
+	// the stack starts with "goroutine <ID> [running]:"
+	stack = bytes.TrimPrefix(stack, []byte("goroutine "))
+	if i := bytes.IndexByte(stack, ' '); i > 0 {
+		id, _ := strconv.ParseInt(string(stack[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+func (l *Logger) Debugf(format string, args ...interface{}) {
+	if l.Enabled(DebugLevel) {
+		l.sugar().Debugf(format, args...)
+	}
+}
+
+func (l *Logger) Infof(format string, args ...interface{}) {
+	if l.Enabled(InfoLevel) {
+		l.sugar().Infof(format, args...)
+	}
+}
+
+func (l *Logger) Warnf(format string, args ...interface{}) {
+	if l.Enabled(WarnLevel) {
+		l.sugar().Warnf(format, args...)
+	}
+}
+
+func (l *Logger) Errorf(format string, args ...interface{}) {
+	if l.Enabled(ErrorLevel) {
+		l.sugar().Errorf(format, args...)
+	}
+}
+
+func (l *Logger) Panicf(format string, args ...interface{}) {
+	l.sugar().Panicf(format, args...)
+}
+
+func (l *Logger) Fatalf(format string, args ...interface{}) {
+	debug.PrintStack()
+	l.sugar().Fatalf(format, args...)
+}
+
+// TraceError prints the stack and error with fields of the logger
+func (l *Logger) TraceError(format string, args ...interface{}) {
+	if l.Enabled(ErrorLevel) {
+		s := l.sugar()
+		s.Error(string(debug.Stack()))
+		s.Errorf(format, args...)
+	}
+}
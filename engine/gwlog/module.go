@@ -0,0 +1,130 @@
+package gwlog
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+const (
+	levelNotSet = int32(-128) // module follows the global log level
+)
+
+var (
+	modulesLock sync.RWMutex
+	modules     = map[string]*moduleLevel{}
+)
+
+// moduleLevel is the log level of module, which can be changed at runtime
+type moduleLevel struct {
+	level int32
+}
+
+// Enabled checks if logs of the level is enabled for the module
+func (ml *moduleLevel) Enabled(lv Level) bool {
+	level := atomic.LoadInt32(&ml.level)
+	if level == levelNotSet {
+		return cfg.Level.Enabled(lv)
+	}
+	return lv >= Level(level)
+}
+
+// levelCore filters logs by the level of module, which can be lower than the global log level
+type levelCore struct {
+	zapcore.Core
+	level zapcore.LevelEnabler
+}
+
+func (c *levelCore) Enabled(lv zapcore.Level) bool {
+	return c.level.Enabled(lv)
+}
+
+func (c *levelCore) With(fields []zapcore.Field) zapcore.Core {
+	return &levelCore{Core: c.Core.With(fields), level: c.level}
+}
+
+func (c *levelCore) Check(ent zapcore.Entry, ce *zapcore.CheckedEntry) *zapcore.CheckedEntry {
+	if c.Enabled(ent.Level) {
+		return ce.AddCore(ent, c)
+	}
+	return ce
+}
+
+// Module returns the logger of module, e.g. entity, storage, aoi
+//
+// Logs of the module are filtered by the module level if set by SetModuleLevel, otherwise by the global log level.
+func Module(name string) *Logger {
+	modulesLock.Lock()
+	ml := modules[name]
+	if ml == nil {
+		ml = &moduleLevel{level: levelNotSet}
+		modules[name] = ml
+	}
+	modulesLock.Unlock()
+
+	return &Logger{fields: []interface{}{"module", name}, module: ml}
+}
+
+// SetModuleLevel sets the log level of module, which is safe to call at runtime in any goroutine
+func SetModuleLevel(name string, lv Level) bool {
+	modulesLock.RLock()
+	ml := modules[name]
+	modulesLock.RUnlock()
+	if ml == nil {
+		return false
+	}
+
+	atomic.StoreInt32(&ml.level, int32(lv))
+	return true
+}
+
+// ResetModuleLevel makes the module follow the global log level again
+func ResetModuleLevel(name string) bool {
+	modulesLock.RLock()
+	ml := modules[name]
+	modulesLock.RUnlock()
+	if ml == nil {
+		return false
+	}
+
+	atomic.StoreInt32(&ml.level, levelNotSet)
+	return true
+}
+
+// ModuleLevel is the log level of module
+type ModuleLevel struct {
+	Module string `json:"module"`
+	Level  string `json:"level"`
+	IsSet  bool   `json:"is_set"` // false if the module follows the global log level
+}
+
+// GetModuleLevels returns log levels of all modules sorted by module names
+func GetModuleLevels() []ModuleLevel {
+	modulesLock.RLock()
+	levels := make([]ModuleLevel, 0, len(modules))
+	for name, ml := range modules {
+		level := atomic.LoadInt32(&ml.level)
+		if level == levelNotSet {
+			levels = append(levels, ModuleLevel{Module: name, Level: cfg.Level.Level().String()})
+		} else {
+			levels = append(levels, ModuleLevel{Module: name, Level: Level(level).String(), IsSet: true})
+		}
+	}
+	modulesLock.RUnlock()
+
+	sort.Slice(levels, func(i, j int) bool {
+		return levels[i].Module < levels[j].Module
+	})
+	return levels
+}
+
+func (ml *moduleLevel) wrapCore(core zapcore.Core) zapcore.Core {
+	return &levelCore{Core: core, level: ml}
+}
+
+func (ml *moduleLevel) sugar(s *zap.SugaredLogger) *zap.SugaredLogger {
+	return s.Desugar().WithOptions(zap.WrapCore(ml.wrapCore)).Sugar()
+}
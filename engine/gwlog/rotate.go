@@ -0,0 +1,285 @@
+package gwlog
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+const (
+	rotateSinkScheme  = "gwlogrotate"
+	rotateTimeFormat  = "20060102-150405"
+	compressSuffix    = ".gz"
+	rotateFileMode    = 0644
+	rotateFileFlags   = os.O_CREATE | os.O_WRONLY | os.O_APPEND
+	rotateDayDuration = time.Hour * 24
+)
+
+// RotateConfig configures rotation and retention of log files
+type RotateConfig struct {
+	MaxSize    int64         // log file is rotated when its size exceeds MaxSize bytes, 0 to disable
+	Interval   time.Duration // log file is rotated every interval aligned to local midnight, 0 to disable
+	MaxBackups int           // max number of rotated files to keep, 0 for unlimited
+	MaxAge     time.Duration // rotated files older than MaxAge are removed, 0 for unlimited
+	Compress   bool          // rotated files are compressed using gzip
+}
+
+func (rc *RotateConfig) enabled() bool {
+	return rc.MaxSize > 0 || rc.Interval > 0
+}
+
+var (
+	rotateConfig     RotateConfig
+	rotateFilesLock  sync.Mutex
+	rotateFiles      = map[string]*rotateFile{} // path -> file, files are shared by loggers rebuilt with the same outputs
+	rotateCleanupMux sync.Mutex                 // compression and cleanup of rotated files run one at a time
+)
+
+func init() {
+	if err := zap.RegisterSink(rotateSinkScheme, openRotateSink); err != nil {
+		panic(err)
+	}
+}
+
+// SetRotation sets rotation and retention of log files, which should be called before SetOutput
+func SetRotation(rc RotateConfig) {
+	rotateConfig = rc
+	rebuildLoggerFromCfg()
+}
+
+// getOutputPaths returns output paths for zap, log files are written by rotateFile if rotation is enabled
+func getOutputPaths(outputs []string) []string {
+	if !rotateConfig.enabled() {
+		return outputs
+	}
+
+	paths := make([]string, len(outputs))
+	for i, output := range outputs {
+		if output == "stdout" || output == "stderr" || strings.Contains(output, "://") {
+			paths[i] = output
+		} else {
+			paths[i] = rotateSinkScheme + ":?path=" + url.QueryEscape(output)
+		}
+	}
+	return paths
+}
+
+func openRotateSink(u *url.URL) (zap.Sink, error) {
+	path := u.Query().Get("path")
+	if path == "" {
+		return nil, fmt.Errorf("log file path is not specified: %s", u)
+	}
+
+	rotateFilesLock.Lock()
+	defer rotateFilesLock.Unlock()
+	if rf := rotateFiles[path]; rf != nil {
+		rf.setConfig(rotateConfig)
+		return rf, nil
+	}
+
+	rf := &rotateFile{path: path}
+	rf.setConfig(rotateConfig)
+	if err := rf.open(time.Now()); err != nil {
+		return nil, err
+	}
+	rotateFiles[path] = rf
+	return rf, nil
+}
+
+// rotateFile is the log file which is rotated by size and time
+type rotateFile struct {
+	sync.Mutex
+	path           string
+	config         RotateConfig
+	file           *os.File
+	size           int64
+	nextRotateTime time.Time // zero if time based rotation is disabled
+}
+
+func (rf *rotateFile) setConfig(rc RotateConfig) {
+	rf.Lock()
+	if rc.Interval != rf.config.Interval {
+		rf.nextRotateTime = getNextRotateTime(time.Now(), rc.Interval)
+	}
+	rf.config = rc
+	rf.Unlock()
+}
+
+func (rf *rotateFile) open(now time.Time) error {
+	file, err := os.OpenFile(rf.path, rotateFileFlags, rotateFileMode)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	rf.file = file
+	rf.size = info.Size()
+	rf.nextRotateTime = getNextRotateTime(now, rf.config.Interval)
+	return nil
+}
+
+// Write writes the log to the file, and rotates the file before writing if needed
+func (rf *rotateFile) Write(p []byte) (int, error) {
+	rf.Lock()
+	defer rf.Unlock()
+
+	now := time.Now()
+	if rf.size > 0 && ((rf.config.MaxSize > 0 && rf.size+int64(len(p)) > rf.config.MaxSize) ||
+		(!rf.nextRotateTime.IsZero() && !now.Before(rf.nextRotateTime))) {
+		if err := rf.rotate(now); err != nil {
+			fmt.Fprintf(os.Stderr, "gwlog: rotate log file %s failed: %s\n", rf.path, err)
+		}
+	}
+	if rf.file == nil {
+		if err := rf.open(now); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := rf.file.Write(p)
+	rf.size += int64(n)
+	return n, err
+}
+
+func (rf *rotateFile) rotate(now time.Time) error {
+	rf.file.Close()
+	rf.file = nil
+
+	backup := getBackupPath(rf.path, now)
+	if err := os.Rename(rf.path, backup); err != nil {
+		return err
+	}
+	if err := rf.open(now); err != nil {
+		return err
+	}
+
+	config := rf.config
+	go func() {
+		rotateCleanupMux.Lock()
+		defer rotateCleanupMux.Unlock()
+
+		if config.Compress {
+			if err := compressFile(backup); err != nil {
+				fmt.Fprintf(os.Stderr, "gwlog: compress log file %s failed: %s\n", backup, err)
+			}
+		}
+		removeExpiredBackups(rf.path, config, time.Now())
+	}()
+	return nil
+}
+
+// Sync flushes the log file
+func (rf *rotateFile) Sync() error {
+	rf.Lock()
+	defer rf.Unlock()
+	if rf.file == nil {
+		return nil
+	}
+	return rf.file.Sync()
+}
+
+// Close does not close the log file, since it is shared by loggers rebuilt with the same outputs
+func (rf *rotateFile) Close() error {
+	return rf.Sync()
+}
+
+// getNextRotateTime returns the next rotation time aligned to local midnight, or zero time if interval is 0
+func getNextRotateTime(now time.Time, interval time.Duration) time.Time {
+	if interval <= 0 {
+		return time.Time{}
+	}
+	if interval > rotateDayDuration {
+		return now.Add(interval)
+	}
+
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+	return midnight.Add((now.Sub(midnight)/interval + 1) * interval)
+}
+
+// getBackupPath returns path of the rotated file: <name>-<time><ext>
+func getBackupPath(path string, now time.Time) string {
+	ext := filepath.Ext(path)
+	prefix := strings.TrimSuffix(path, ext) + "-" + now.Format(rotateTimeFormat)
+	backup := prefix + ext
+	for i := 1; fileExists(backup) || fileExists(backup+compressSuffix); i++ {
+		backup = fmt.Sprintf("%s.%d%s", prefix, i, ext)
+	}
+	return backup
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func compressFile(path string) error {
+	src, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	dst, err := os.OpenFile(path+compressSuffix, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, rotateFileMode)
+	if err != nil {
+		return err
+	}
+
+	zw := gzip.NewWriter(dst)
+	if _, err = io.Copy(zw, src); err == nil {
+		err = zw.Close()
+	}
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(path + compressSuffix)
+		return err
+	}
+	return os.Remove(path)
+}
+
+// removeExpiredBackups removes rotated files exceeding MaxBackups or older than MaxAge
+func removeExpiredBackups(path string, config RotateConfig, now time.Time) {
+	if config.MaxBackups <= 0 && config.MaxAge <= 0 {
+		return
+	}
+
+	ext := filepath.Ext(path)
+	matches, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext + "*")
+	if err != nil {
+		return
+	}
+
+	type backupFile struct {
+		path    string
+		modTime time.Time
+	}
+	var backups []backupFile
+	for _, match := range matches {
+		if info, err := os.Stat(match); err == nil && !info.IsDir() {
+			backups = append(backups, backupFile{match, info.ModTime()})
+		}
+	}
+	sort.Slice(backups, func(i, j int) bool {
+		return backups[i].modTime.After(backups[j].modTime) // newest first
+	})
+
+	for i, backup := range backups {
+		if (config.MaxBackups > 0 && i >= config.MaxBackups) || (config.MaxAge > 0 && now.Sub(backup.modTime) > config.MaxAge) {
+			os.Remove(backup.path)
+		}
+	}
+}
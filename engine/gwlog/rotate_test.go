@@ -0,0 +1,77 @@
+package gwlog
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRotateFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gwlog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	logFile := filepath.Join(dir, "game.log")
+	rf := &rotateFile{path: logFile}
+	rf.setConfig(RotateConfig{MaxSize: 100, MaxBackups: 2, Compress: true})
+	if err := rf.open(time.Now()); err != nil {
+		t.Fatal(err)
+	}
+
+	line := []byte(strings.Repeat("x", 59) + "\n")
+	for i := 0; i < 5; i++ {
+		if _, err := rf.Write(line); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rf.Sync()
+
+	// wait for compression and cleanup of rotated files
+	var backups []string
+	for i := 0; i < 100; i++ {
+		rotateCleanupMux.Lock()
+		backups, _ = filepath.Glob(filepath.Join(dir, "game-*.log*"))
+		rotateCleanupMux.Unlock()
+		if len(backups) == 2 && strings.HasSuffix(backups[0], compressSuffix) && strings.HasSuffix(backups[1], compressSuffix) {
+			break
+		}
+		time.Sleep(time.Millisecond * 10)
+	}
+	if len(backups) != 2 {
+		t.Fatalf("expect 2 backups, but got %v", backups)
+	}
+	for _, backup := range backups {
+		if !strings.HasSuffix(backup, compressSuffix) {
+			t.Errorf("backup %s is not compressed", backup)
+		}
+	}
+
+	info, err := os.Stat(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != int64(len(line)) {
+		t.Errorf("log file size should be %d, but is %d", len(line), info.Size())
+	}
+}
+
+func TestGetNextRotateTime(t *testing.T) {
+	now := time.Date(2020, 1, 1, 10, 30, 0, 0, time.Local)
+	if next := getNextRotateTime(now, time.Hour*24); !next.Equal(time.Date(2020, 1, 2, 0, 0, 0, 0, time.Local)) {
+		t.Errorf("wrong next rotate time of daily rotation: %s", next)
+	}
+	if next := getNextRotateTime(now, time.Hour*6); !next.Equal(time.Date(2020, 1, 1, 12, 0, 0, 0, time.Local)) {
+		t.Errorf("wrong next rotate time of 6 hours rotation: %s", next)
+	}
+	if next := getNextRotateTime(now, time.Hour*48); !next.Equal(now.Add(time.Hour * 48)) {
+		t.Errorf("wrong next rotate time of 2 days rotation: %s", next)
+	}
+	if next := getNextRotateTime(now, 0); !next.IsZero() {
+		t.Errorf("next rotate time should be zero if disabled: %s", next)
+	}
+}
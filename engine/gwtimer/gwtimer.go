@@ -0,0 +1,105 @@
+// Package gwtimer provides cancelable timers fired in the game routine
+//
+// Timers are fired by ticks of the game routine. Cancel and Reset should be called in the game routine, and timers are
+// never fired after Cancel returns.
+package gwtimer
+
+import (
+	"reflect"
+	"runtime"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/timingwheel"
+)
+
+var useTimingWheel bool // timers are added to the timing wheel instead of goTimer
+
+// rawTimer is the timer of goTimer or the timing wheel
+type rawTimer interface {
+	Cancel()
+	IsActive() bool
+}
+
+// Timer is the handle of a callback or repeat timer
+type Timer struct {
+	rawTimer rawTimer
+	callback func()
+	repeat   bool
+}
+
+// SetTimingWheel sets if timers are added to the timing wheel instead of goTimer, e.g. to be fired by the logical time
+// in the deterministic mode. It should be called before any timer is added.
+func SetTimingWheel(enabled bool) {
+	useTimingWheel = enabled
+}
+
+// AddCallback adds a callback which is called after the duration
+func AddCallback(d time.Duration, callback func()) *Timer {
+	t := &Timer{callback: monitorCallback(callback)}
+	t.Reset(d)
+	return t
+}
+
+// AddTimer adds a repeat timer which is called every duration
+func AddTimer(d time.Duration, callback func()) *Timer {
+	t := &Timer{callback: monitorCallback(callback), repeat: true}
+	t.Reset(d)
+	return t
+}
+
+// Cancel cancels the timer, the callback is not called after Cancel returns
+func (t *Timer) Cancel() {
+	if t.rawTimer != nil {
+		t.rawTimer.Cancel()
+		t.rawTimer = nil
+	}
+}
+
+// Reset restarts the timer to be called after the duration (and every duration for repeat timers)
+//
+// Fired callbacks and cancelled timers are also restarted.
+func (t *Timer) Reset(d time.Duration) {
+	t.Cancel()
+	if t.repeat {
+		if useTimingWheel {
+			t.rawTimer = timingwheel.AddTimer(d, t.callback)
+		} else {
+			t.rawTimer = timer.AddTimer(d, t.callback)
+		}
+	} else {
+		var raw rawTimer
+		fire := func() {
+			if t.rawTimer == raw {
+				t.rawTimer = nil
+			}
+			t.callback()
+		}
+		if useTimingWheel {
+			raw = timingwheel.AddCallback(d, fire)
+		} else {
+			raw = timer.AddCallback(d, fire)
+		}
+		t.rawTimer = raw
+	}
+}
+
+// IsActive returns if the timer is not fired (for callbacks) and not cancelled
+func (t *Timer) IsActive() bool {
+	return t.rawTimer != nil && t.rawTimer.IsActive()
+}
+
+// monitorCallback wraps the callback to record its timing stats as handlers, so that slow callbacks are logged
+func monitorCallback(callback func()) func() {
+	name := "unknown"
+	if fn := runtime.FuncForPC(reflect.ValueOf(callback).Pointer()); fn != nil {
+		name = fn.Name()
+	}
+	return func() {
+		op := opmon.StartHandler(opmon.HandlerTimer, name)
+		gwutils.RunPanicless(callback)
+		op.FinishHandler()
+	}
+}
@@ -0,0 +1,83 @@
+package gwtimer
+
+import (
+	"testing"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/timingwheel"
+)
+
+func TestCallback(t *testing.T) {
+	fired := 0
+	cb := AddCallback(0, func() {
+		fired++
+	})
+	if !cb.IsActive() {
+		t.Errorf("callback should be active")
+	}
+	timer.Tick()
+	if fired != 1 || cb.IsActive() {
+		t.Errorf("callback should be fired once, but fired %d times, active = %v", fired, cb.IsActive())
+	}
+
+	cb.Reset(0)
+	cb.Cancel()
+	timer.Tick()
+	if fired != 1 || cb.IsActive() {
+		t.Errorf("cancelled callback should not be fired")
+	}
+
+	cb.Reset(time.Hour)
+	cb.Reset(0)
+	timer.Tick()
+	timer.Tick()
+	if fired != 2 {
+		t.Errorf("reset callback should be fired once, but fired %d times", fired-1)
+	}
+}
+
+func TestTimer(t *testing.T) {
+	fired := 0
+	var tm *Timer
+	tm = AddTimer(time.Millisecond, func() {
+		fired++
+		if fired == 3 {
+			tm.Cancel()
+		}
+	})
+	for i := 0; i < 5; i++ {
+		time.Sleep(time.Millisecond * 2)
+		timer.Tick()
+	}
+	if fired != 3 || tm.IsActive() {
+		t.Errorf("timer should be fired 3 times before cancelled, but fired %d times", fired)
+	}
+
+	tm.Reset(time.Millisecond)
+	time.Sleep(time.Millisecond * 2)
+	timer.Tick()
+	if fired != 4 || !tm.IsActive() {
+		t.Errorf("reset timer should be fired and active")
+	}
+	tm.Cancel()
+}
+
+func TestTimingWheel(t *testing.T) {
+	SetTimingWheel(true)
+	defer SetTimingWheel(false)
+
+	fired := 0
+	cb := AddCallback(0, func() {
+		fired++
+	})
+	timer.Tick()
+	if fired != 0 || !cb.IsActive() {
+		t.Errorf("callback should be added to the timing wheel")
+	}
+	time.Sleep(time.Millisecond * 2)
+	timingwheel.Tick()
+	if fired != 1 || cb.IsActive() {
+		t.Errorf("callback should be fired once by the timing wheel, but fired %d times, active = %v", fired, cb.IsActive())
+	}
+}
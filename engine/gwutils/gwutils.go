@@ -2,12 +2,30 @@ package gwutils
 
 import "github.com/xiaonanln/goworld/engine/gwlog"
 
+var (
+	panicHandler func(err interface{})
+)
+
+// SetPanicHandler sets the handler which is called when CatchPanic or RunPanicless recovers from a panic
+//
+// The handler is called before the stack of the panic is unwound, so it can dump the stack using debug.Stack.
+func SetPanicHandler(handler func(err interface{})) {
+	panicHandler = handler
+}
+
+func handlePanic(f func(), err interface{}) {
+	gwlog.TraceError("%s panic: %s", f, err)
+	if panicHandler != nil {
+		panicHandler(err)
+	}
+}
+
 // CatchPanic calls a function and returns the error if function paniced
 func CatchPanic(f func()) (err interface{}) {
 	defer func() {
 		err = recover()
 		if err != nil {
-			gwlog.TraceError("%s panic: %s", f, err)
+			handlePanic(f, err)
 		}
 	}()
 
@@ -21,7 +39,7 @@ func RunPanicless(f func()) (panicless bool) {
 		err := recover()
 		panicless = err == nil
 		if err != nil {
-			gwlog.TraceError("%s panic: %s", f, err)
+			handlePanic(f, err)
 		}
 	}()
 
@@ -13,3 +13,20 @@ func TestRunPanicless(t *testing.T) {
 		panic(fmt.Errorf("bad"))
 	})
 }
+
+func TestSetPanicHandler(t *testing.T) {
+	var handled interface{}
+	SetPanicHandler(func(err interface{}) {
+		handled = err
+	})
+	defer SetPanicHandler(nil)
+
+	if RunPanicless(func() {
+		panic("bad")
+	}) {
+		t.Errorf("RunPanicless should return false on panic")
+	}
+	if handled != "bad" {
+		t.Errorf("panic handler should be called with bad, but is called with %v", handled)
+	}
+}
@@ -48,6 +48,14 @@ func (kvdb *mongoKVDB) Put(key string, val string) error {
 	return err
 }
 
+func (kvdb *mongoKVDB) Delete(key string) error {
+	err := kvdb.c.RemoveId(key)
+	if err == mgo.ErrNotFound {
+		err = nil
+	}
+	return err
+}
+
 func (kvdb *mongoKVDB) Get(key string) (val string, err error) {
 	q := kvdb.c.FindId(key)
 	var doc map[string]string
@@ -0,0 +1,172 @@
+package kvdbmemory
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+// memoryKVDB keeps items in the memory of the game process, for tests and demos without databases
+//
+// Items are loaded from the snapshot file when opened, and saved to the snapshot file periodically and when closed, if
+// the snapshot file is set.
+type memoryKVDB struct {
+	lock         sync.Mutex
+	items        map[string]string
+	dirty        bool // items are changed after the last snapshot
+	snapshotFile string
+	snapshotLock sync.Mutex // serializes writing snapshots
+	stopped      chan struct{}
+	stopOnce     sync.Once
+}
+
+// OpenMemoryKVDB opens the KVDB engine in memory, items are loaded from and saved to the snapshot file every snapshot
+// interval if the snapshot file is not empty
+func OpenMemoryKVDB(snapshotFile string, snapshotInterval time.Duration) (kvdbtypes.KVDBEngine, error) {
+	kvdb := &memoryKVDB{
+		items:        map[string]string{},
+		snapshotFile: snapshotFile,
+		stopped:      make(chan struct{}),
+	}
+	if snapshotFile == "" {
+		return kvdb, nil
+	}
+
+	data, err := ioutil.ReadFile(snapshotFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &kvdb.items); err != nil {
+			return nil, err
+		}
+	}
+	if snapshotInterval > 0 {
+		go kvdb.snapshotRoutine(snapshotInterval)
+	}
+	return kvdb, nil
+}
+
+func (kvdb *memoryKVDB) Put(key string, val string) error {
+	kvdb.lock.Lock()
+	kvdb.items[key] = val
+	kvdb.dirty = true
+	kvdb.lock.Unlock()
+	return nil
+}
+
+func (kvdb *memoryKVDB) Delete(key string) error {
+	kvdb.lock.Lock()
+	if _, ok := kvdb.items[key]; ok {
+		delete(kvdb.items, key)
+		kvdb.dirty = true
+	}
+	kvdb.lock.Unlock()
+	return nil
+}
+
+func (kvdb *memoryKVDB) Get(key string) (val string, err error) {
+	kvdb.lock.Lock()
+	val = kvdb.items[key]
+	kvdb.lock.Unlock()
+	return
+}
+
+type memoryKVIterator struct {
+	items []kvdbtypes.KVItem
+}
+
+func (it *memoryKVIterator) Next() (kvdbtypes.KVItem, error) {
+	if len(it.items) == 0 {
+		return kvdbtypes.KVItem{}, io.EOF
+	}
+	item := it.items[0]
+	it.items = it.items[1:]
+	return item, nil
+}
+
+// Find returns items of keys in [beginKey, endKey) in the order of keys
+func (kvdb *memoryKVDB) Find(beginKey string, endKey string) (kvdbtypes.Iterator, error) {
+	var items []kvdbtypes.KVItem
+	kvdb.lock.Lock()
+	for key, val := range kvdb.items {
+		if key >= beginKey && key < endKey {
+			items = append(items, kvdbtypes.KVItem{Key: key, Val: val})
+		}
+	}
+	kvdb.lock.Unlock()
+
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].Key < items[j].Key
+	})
+	return &memoryKVIterator{items: items}, nil
+}
+
+func (kvdb *memoryKVDB) Close() {
+	kvdb.stopOnce.Do(func() {
+		close(kvdb.stopped)
+	})
+	if err := kvdb.snapshot(); err != nil {
+		gwlog.Errorf("memory KVDB: save snapshot %s failed: %s", kvdb.snapshotFile, err)
+	}
+}
+
+func (kvdb *memoryKVDB) IsConnectionError(err error) bool {
+	return false
+}
+
+// snapshot saves items to the snapshot file if items are changed
+func (kvdb *memoryKVDB) snapshot() error {
+	if kvdb.snapshotFile == "" {
+		return nil
+	}
+
+	kvdb.snapshotLock.Lock()
+	defer kvdb.snapshotLock.Unlock()
+	kvdb.lock.Lock()
+	if !kvdb.dirty {
+		kvdb.lock.Unlock()
+		return nil
+	}
+	data, err := json.Marshal(kvdb.items)
+	kvdb.dirty = false
+	kvdb.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write to the temp file and rename, so that the snapshot is never partially written
+	tempFile := kvdb.snapshotFile + ".tmp"
+	err = ioutil.WriteFile(tempFile, data, 0644)
+	if err == nil {
+		err = os.Rename(tempFile, kvdb.snapshotFile)
+	}
+	if err != nil {
+		kvdb.lock.Lock()
+		kvdb.dirty = true // retry in the next snapshot
+		kvdb.lock.Unlock()
+	}
+	return err
+}
+
+func (kvdb *memoryKVDB) snapshotRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := kvdb.snapshot(); err != nil {
+				gwlog.Errorf("memory KVDB: save snapshot %s failed: %s", kvdb.snapshotFile, err)
+			}
+		case <-kvdb.stopped:
+			return
+		}
+	}
+}
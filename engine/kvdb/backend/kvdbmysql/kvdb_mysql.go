@@ -68,6 +68,11 @@ func (sqlkvdb *mysqlKVDB) Put(key string, val string) (err error) {
 	return
 }
 
+func (sqlkvdb *mysqlKVDB) Delete(key string) (err error) {
+	_, err = sqlkvdb.db.Exec("DELETE FROM `__kv__` WHERE `key` = ?", key)
+	return
+}
+
 type sqlKVDBIterator struct {
 	rows *sql.Rows
 }
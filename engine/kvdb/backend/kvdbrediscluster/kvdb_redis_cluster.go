@@ -93,6 +93,11 @@ func (db *redisKVDB) Put(key string, val string) error {
 	return err
 }
 
+func (db *redisKVDB) Delete(key string) error {
+	_, err := db.c.Do("DEL", keyPrefix+key)
+	return err
+}
+
 type redisKVDBIterator struct {
 	db       *redisKVDB
 	leftKeys []string
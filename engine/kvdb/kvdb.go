@@ -1,6 +1,7 @@
 package kvdb
 
 import (
+	"context"
 	"time"
 
 	"io"
@@ -11,6 +12,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbmemory"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbmysql"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbredis"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbrediscluster"
@@ -31,6 +33,9 @@ type KVDBGetCallback func(val string, err error)
 // KVDBPutCallback is type of KVDB Get callback
 type KVDBPutCallback func(err error)
 
+// KVDBDeleteCallback is type of KVDB Delete callback
+type KVDBDeleteCallback func(err error)
+
 // KVDBGetRangeCallback is type of KVDB GetRange callback
 type KVDBGetRangeCallback func(items []kvdbtypes.KVItem, err error)
 
@@ -50,6 +55,16 @@ func Initialize() {
 	assureKVDBEngineReady()
 }
 
+// Shutdown closes the KVDB engine, it should be called after all KVDB operations are finished
+//
+// Called by game server engine
+func Shutdown() {
+	if kvdbEngine != nil {
+		kvdbEngine.Close()
+		kvdbEngine = nil
+	}
+}
+
 func assureKVDBEngineReady() (err error) {
 	if kvdbEngine != nil { // connection is valid
 		return
@@ -57,7 +72,9 @@ func assureKVDBEngineReady() (err error) {
 
 	kvdbCfg := config.GetKVDB()
 
-	if kvdbCfg.Type == "mongodb" {
+	if kvdbCfg.Type == "memory" {
+		kvdbEngine, err = kvdbmemory.OpenMemoryKVDB(kvdbCfg.SnapshotFile, kvdbCfg.SnapshotInterval)
+	} else if kvdbCfg.Type == "mongodb" {
 		kvdbEngine, err = kvdbmongo.OpenMongoKVDB(kvdbCfg.Url, kvdbCfg.DB, kvdbCfg.Collection)
 	} else if kvdbCfg.Type == "redis" {
 		var dbindex int = -1
@@ -84,6 +101,13 @@ func assureKVDBEngineReady() (err error) {
 
 // Get gets value of key from KVDB, returns in callback
 func Get(key string, callback KVDBGetCallback) {
+	GetContext(context.Background(), key, callback)
+}
+
+// GetContext gets value of key from KVDB with the context, returns in callback
+//
+// The callback receives the error of ctx if ctx is done before the operation starts, and is dropped if ctx is canceled.
+func GetContext(ctx context.Context, key string, callback KVDBGetCallback) {
 	var ac async.AsyncCallback
 	if callback != nil {
 		ac = func(res interface{}, err error) {
@@ -94,18 +118,20 @@ func Get(key string, callback KVDBGetCallback) {
 			}
 		}
 	}
-	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+	async.AppendAsyncJobContext(ctx, _KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
 		res, err = kvdbEngine.Get(key)
 		return
 	}), ac)
 }
 
-func kvdbRoutine(r func() (res interface{}, err error)) func() (res interface{}, err error) {
-	kvdbroutine := func() (res interface{}, err error) {
+func kvdbRoutine(r func() (res interface{}, err error)) async.ContextAsyncRoutine {
+	kvdbroutine := func(ctx context.Context) (res interface{}, err error) {
 		for {
 			err := assureKVDBEngineReady()
 			if err == nil {
 				break
+			} else if ctx.Err() != nil {
+				return nil, ctx.Err()
 			} else {
 				gwlog.Errorf("KVDB engine is not ready: %s", err)
 				time.Sleep(time.Second)
@@ -126,6 +152,13 @@ func kvdbRoutine(r func() (res interface{}, err error)) func() (res interface{},
 
 // Put puts key-value item to KVDB, returns in callback
 func Put(key string, val string, callback KVDBPutCallback) {
+	PutContext(context.Background(), key, val, callback)
+}
+
+// PutContext puts key-value item to KVDB with the context, returns in callback
+//
+// The item is not put if ctx is done before the operation starts.
+func PutContext(ctx context.Context, key string, val string, callback KVDBPutCallback) {
 	var ac async.AsyncCallback
 	if callback != nil {
 		ac = func(res interface{}, err error) {
@@ -133,14 +166,41 @@ func Put(key string, val string, callback KVDBPutCallback) {
 		}
 	}
 
-	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+	async.AppendAsyncJobContext(ctx, _KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
 		err = kvdbEngine.Put(key, val)
 		return
 	}), ac)
 }
 
+// Delete deletes the key from KVDB, returns in callback
+func Delete(key string, callback KVDBDeleteCallback) {
+	DeleteContext(context.Background(), key, callback)
+}
+
+// DeleteContext deletes the key from KVDB with the context, returns in callback
+//
+// The key is not deleted if ctx is done before the operation starts.
+func DeleteContext(ctx context.Context, key string, callback KVDBDeleteCallback) {
+	var ac async.AsyncCallback
+	if callback != nil {
+		ac = func(res interface{}, err error) {
+			callback(err)
+		}
+	}
+
+	async.AppendAsyncJobContext(ctx, _KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+		err = kvdbEngine.Delete(key)
+		return
+	}), ac)
+}
+
 // GetOrPut gets value of key from KVDB, if val not exists or is "", put key-value to KVDB.
 func GetOrPut(key string, val string, callback KVDBGetOrPutCallback) {
+	GetOrPutContext(context.Background(), key, val, callback)
+}
+
+// GetOrPutContext is GetOrPut with the context
+func GetOrPutContext(ctx context.Context, key string, val string, callback KVDBGetOrPutCallback) {
 	var ac async.AsyncCallback
 	if callback != nil {
 		ac = func(res interface{}, err error) {
@@ -152,7 +212,7 @@ func GetOrPut(key string, val string, callback KVDBGetOrPutCallback) {
 		}
 	}
 
-	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+	async.AppendAsyncJobContext(ctx, _KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
 		oldVal, err := kvdbEngine.Get(key)
 		if err == nil {
 			if oldVal == "" {
@@ -166,6 +226,11 @@ func GetOrPut(key string, val string, callback KVDBGetOrPutCallback) {
 
 // GetRange retrives key-value items of specified key range, returns in callback
 func GetRange(beginKey string, endKey string, callback KVDBGetRangeCallback) {
+	GetRangeContext(context.Background(), beginKey, endKey, callback)
+}
+
+// GetRangeContext is GetRange with the context
+func GetRangeContext(ctx context.Context, beginKey string, endKey string, callback KVDBGetRangeCallback) {
 	var ac async.AsyncCallback
 	if callback != nil {
 		ac = func(res interface{}, err error) {
@@ -177,7 +242,7 @@ func GetRange(beginKey string, endKey string, callback KVDBGetRangeCallback) {
 		}
 	}
 
-	async.AppendAsyncJob(_KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
+	async.AppendAsyncJobContext(ctx, _KVDB_ASYNC_JOB_GROUP, kvdbRoutine(func() (res interface{}, err error) {
 		it, err := kvdbEngine.Find(beginKey, endKey)
 		if err != nil {
 			return nil, err
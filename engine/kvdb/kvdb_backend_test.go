@@ -9,13 +9,19 @@ import (
 	"io"
 
 	"os"
+	"path/filepath"
 
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdb_mongodb"
+	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbmemory"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbmysql"
 	"github.com/xiaonanln/goworld/engine/kvdb/backend/kvdbredis"
 	. "github.com/xiaonanln/goworld/engine/kvdb/types"
 )
 
+func TestMemoryBackendSet(t *testing.T) {
+	testKVDBBackendSet(t, openTestMemoryKVDB(t, ""))
+}
+
 func TestMongoBackendSet(t *testing.T) {
 	testKVDBBackendSet(t, openTestMongoKVDB(t))
 }
@@ -54,6 +60,58 @@ func testKVDBBackendSet(t *testing.T, kvdb KVDBEngine) {
 
 }
 
+func TestMemoryBackendDelete(t *testing.T) {
+	testKVDBBackendDelete(t, openTestMemoryKVDB(t, ""))
+}
+
+func TestMongoBackendDelete(t *testing.T) {
+	testKVDBBackendDelete(t, openTestMongoKVDB(t))
+}
+
+func TestRedisBackendDelete(t *testing.T) {
+	testKVDBBackendDelete(t, openTestRedisKVDB(t))
+}
+
+func TestMySQLBackendDelete(t *testing.T) {
+	testKVDBBackendDelete(t, openTestMySQLKVDB(t))
+}
+
+func testKVDBBackendDelete(t *testing.T, kvdb KVDBEngine) {
+	if err := kvdb.Put("__key_to_delete__", "val"); err != nil {
+		t.Fatal(err)
+	}
+	if err := kvdb.Delete("__key_to_delete__"); err != nil {
+		t.Fatal(err)
+	}
+	if val, err := kvdb.Get("__key_to_delete__"); err != nil || val != "" {
+		t.Errorf("deleted key should not exist, but got %#v, %v", val, err)
+	}
+	if err := kvdb.Delete("__key_to_delete__"); err != nil {
+		t.Errorf("delete missing key should not fail: %s", err)
+	}
+}
+
+func TestMemoryBackendFind(t *testing.T) {
+	testBackendFind(t, openTestMemoryKVDB(t, ""))
+}
+
+func TestMemoryBackendSnapshot(t *testing.T) {
+	snapshotFile := filepath.Join(os.TempDir(), "goworld_test_kvdb.json")
+	os.Remove(snapshotFile)
+	defer os.Remove(snapshotFile)
+
+	kvdb := openTestMemoryKVDB(t, snapshotFile)
+	if err := kvdb.Put("key", "val"); err != nil {
+		t.Fatal(err)
+	}
+	kvdb.Close()
+
+	kvdb = openTestMemoryKVDB(t, snapshotFile)
+	if val, err := kvdb.Get("key"); err != nil || val != "val" {
+		t.Errorf("item should be loaded from the snapshot, but got %#v, %v", val, err)
+	}
+}
+
 func TestMongoBackendFind(t *testing.T) {
 	testBackendFind(t, openTestMongoKVDB(t))
 }
@@ -203,6 +261,14 @@ type _Fataler interface {
 	Fatal(args ...interface{})
 }
 
+func openTestMemoryKVDB(f _Fataler, snapshotFile string) KVDBEngine {
+	kvdb, err := kvdbmemory.OpenMemoryKVDB(snapshotFile, 0)
+	if err != nil {
+		f.Fatal(err)
+	}
+	return kvdb
+}
+
 func openTestMongoKVDB(f _Fataler) KVDBEngine {
 	kvdb, err := kvdbmongo.OpenMongoKVDB("mongodb://127.0.0.1:27017/goworld", "goworld", "__kv__")
 	if err != nil {
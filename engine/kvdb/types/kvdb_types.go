@@ -4,6 +4,7 @@ package kvdbtypes
 type KVDBEngine interface {
 	Get(key string) (val string, err error)
 	Put(key string, val string) (err error)
+	Delete(key string) (err error) // deleting missing keys is not an error
 	Find(beginKey string, endKey string) (Iterator, error)
 	Close()
 	IsConnectionError(err error) bool
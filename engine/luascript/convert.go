@@ -0,0 +1,122 @@
+package luascript
+
+import (
+	"fmt"
+	"math"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// toGo converts the Lua value to the Go value of attribute types
+//
+// Integral numbers are converted to int64, sequences to []interface{}, and other tables to map[string]interface{}.
+// Entities are converted to their IDs.
+func toGo(lv lua.LValue) interface{} {
+	switch v := lv.(type) {
+	case lua.LBool:
+		return bool(v)
+	case lua.LNumber:
+		if f := float64(v); f == math.Trunc(f) && math.Abs(f) < 1<<53 {
+			return int64(f)
+		}
+		return float64(v)
+	case lua.LString:
+		return string(v)
+	case *lua.LTable:
+		if n := v.MaxN(); n > 0 && n == v.Len() {
+			list := make([]interface{}, 0, n)
+			for i := 1; i <= n; i++ {
+				list = append(list, toGo(v.RawGetInt(i)))
+			}
+			return list
+		}
+		m := map[string]interface{}{}
+		v.ForEach(func(key lua.LValue, val lua.LValue) {
+			m[key.String()] = toGo(val)
+		})
+		return m
+	case *lua.LUserData:
+		if e, ok := v.Value.(*entity.Entity); ok {
+			return string(e.ID)
+		}
+	}
+	return nil
+}
+
+// toLua converts the Go value to the Lua value
+func toLua(L *lua.LState, v interface{}) lua.LValue {
+	switch gv := v.(type) {
+	case nil:
+		return lua.LNil
+	case bool:
+		return lua.LBool(gv)
+	case string:
+		return lua.LString(gv)
+	case common.EntityID:
+		return lua.LString(gv)
+	case int64:
+		return lua.LNumber(gv)
+	case int:
+		return lua.LNumber(gv)
+	case int32:
+		return lua.LNumber(gv)
+	case uint64:
+		return lua.LNumber(gv)
+	case uint32:
+		return lua.LNumber(gv)
+	case uint16:
+		return lua.LNumber(gv)
+	case uint8:
+		return lua.LNumber(gv)
+	case float64:
+		return lua.LNumber(gv)
+	case float32:
+		return lua.LNumber(gv)
+	case []interface{}:
+		tb := L.CreateTable(len(gv), 0)
+		for _, item := range gv {
+			tb.Append(toLua(L, item))
+		}
+		return tb
+	case map[string]interface{}:
+		tb := L.CreateTable(0, len(gv))
+		for key, val := range gv {
+			tb.RawSetString(key, toLua(L, val))
+		}
+		return tb
+	case map[interface{}]interface{}: // unpacked by msgpack
+		tb := L.CreateTable(0, len(gv))
+		for key, val := range gv {
+			tb.RawSetString(fmt.Sprint(key), toLua(L, val))
+		}
+		return tb
+	case *entity.MapAttr:
+		return toLua(L, gv.ToMap())
+	case *entity.ListAttr:
+		return toLua(L, gv.ToList())
+	case *entity.Entity:
+		return newEntityValue(L, gv)
+	default:
+		return lua.LString(fmt.Sprint(gv))
+	}
+}
+
+// argsToGo converts Lua arguments on the stack from the index to Go values
+func argsToGo(L *lua.LState, from int) []interface{} {
+	var args []interface{}
+	for i := from; i <= L.GetTop(); i++ {
+		args = append(args, toGo(L.Get(i)))
+	}
+	return args
+}
+
+// argsToLua converts Go arguments to Lua values
+func argsToLua(L *lua.LState, args []interface{}) []lua.LValue {
+	lvs := make([]lua.LValue, 0, len(args))
+	for _, arg := range args {
+		lvs = append(lvs, toLua(L, arg))
+	}
+	return lvs
+}
@@ -0,0 +1,102 @@
+package luascript
+
+import (
+	"github.com/xiaonanln/goworld/engine/entity"
+	lua "github.com/yuin/gopher-lua"
+)
+
+// ScriptEntity is the entity type whose behaviors are defined by the Lua script registered by RegisterEntity
+//
+// Hooks of the script are called with the entity as the first argument: on_created, on_destroy, on_migrate_in,
+// on_restored, on_enter_space, on_leave_space, on_client_connected, on_client_disconnected, and on_enter_aoi and
+// on_leave_aoi with the other entity. Struct types embedding ScriptEntity can add Go methods, and should call methods of
+// ScriptEntity if they override hooks.
+type ScriptEntity struct {
+	entity.Entity
+}
+
+// callScript calls the function of the script of the entity type with the entity and arguments
+func (e *ScriptEntity) callScript(name string, required bool, args ...lua.LValue) {
+	s := scripts[e.TypeName]
+	if s == nil {
+		logger.Errorf("%s: script of entity type %s is not registered", e, e.TypeName)
+		return
+	}
+
+	L := getState()
+	if err := s.call(name, required, append([]lua.LValue{newEntityValue(L, &e.Entity)}, args...)...); err != nil {
+		logger.Errorf("%s: call script %s failed: %s", e, name, err)
+	}
+}
+
+// DescribeEntityType calls the describe function of the script with the entity type desc
+func (e *ScriptEntity) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	if describingScript == nil {
+		logger.Panicf("ScriptEntity should be registered by luascript.RegisterEntity")
+	}
+	if err := describingScript.call("describe", false, newEntityTypeDescValue(getState(), desc)); err != nil {
+		logger.Panicf("call describe of script %s failed: %s", describingScript.file, err)
+	}
+}
+
+// Script calls the handler of the script with arguments, e.g. goworld.Call(id, "Script", "talk", []interface{}{"hello"})
+func (e *ScriptEntity) Script(handler string, args []interface{}) {
+	e.callScript(handler, true, argsToLua(getState(), args)...)
+}
+
+// ClientScript_Client calls the handler of the script with suffix "_client" from the own client
+//
+// Only handlers with the suffix can be called by clients, e.g. talk_client for ClientScript("talk", ["hello"])
+func (e *ScriptEntity) ClientScript_Client(handler string, args []interface{}) {
+	e.callScript(handler+"_client", true, argsToLua(getState(), args)...)
+}
+
+// OnCreated calls on_created of the script
+func (e *ScriptEntity) OnCreated() {
+	e.callScript("on_created", false)
+}
+
+// OnDestroy calls on_destroy of the script
+func (e *ScriptEntity) OnDestroy() {
+	e.callScript("on_destroy", false)
+}
+
+// OnMigrateIn calls on_migrate_in of the script
+func (e *ScriptEntity) OnMigrateIn() {
+	e.callScript("on_migrate_in", false)
+}
+
+// OnRestored calls on_restored of the script
+func (e *ScriptEntity) OnRestored() {
+	e.callScript("on_restored", false)
+}
+
+// OnEnterSpace calls on_enter_space of the script
+func (e *ScriptEntity) OnEnterSpace() {
+	e.callScript("on_enter_space", false)
+}
+
+// OnLeaveSpace calls on_leave_space of the script with the space ID
+func (e *ScriptEntity) OnLeaveSpace(space *entity.Space) {
+	e.callScript("on_leave_space", false, lua.LString(space.ID))
+}
+
+// OnClientConnected calls on_client_connected of the script
+func (e *ScriptEntity) OnClientConnected() {
+	e.callScript("on_client_connected", false)
+}
+
+// OnClientDisconnected calls on_client_disconnected of the script
+func (e *ScriptEntity) OnClientDisconnected() {
+	e.callScript("on_client_disconnected", false)
+}
+
+// OnEntityEnterAOI calls on_enter_aoi of the script with the other entity
+func (e *ScriptEntity) OnEntityEnterAOI(other *entity.Entity) {
+	e.callScript("on_enter_aoi", false, newEntityValue(getState(), other))
+}
+
+// OnEntityLeaveAOI calls on_leave_aoi of the script with the other entity
+func (e *ScriptEntity) OnEntityLeaveAOI(other *entity.Entity) {
+	e.callScript("on_leave_aoi", false, newEntityValue(getState(), other))
+}
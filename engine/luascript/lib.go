@@ -0,0 +1,255 @@
+package luascript
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/service"
+	lua "github.com/yuin/gopher-lua"
+)
+
+const (
+	_ENTITY_TYPE_NAME      = "goworld.entity"
+	_ENTITY_TYPE_DESC_NAME = "goworld.entity_type_desc"
+)
+
+// openGoworldLib registers the goworld table and metatables of entities and entity type descs
+func openGoworldLib(L *lua.LState) {
+	L.SetGlobal("goworld", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"get_entity":   luaGetEntity,
+		"call":         luaCall,
+		"call_service": luaCallService,
+		"log":          luaLog,
+	}))
+
+	mt := L.NewTypeMetatable(_ENTITY_TYPE_NAME)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"id":               luaEntityID,
+		"type_name":        luaEntityTypeName,
+		"is_destroyed":     luaEntityIsDestroyed,
+		"get":              luaEntityGet,
+		"set":              luaEntitySet,
+		"position":         luaEntityPosition,
+		"call":             luaEntityCall,
+		"call_client":      luaEntityCallClient,
+		"call_all_clients": luaEntityCallAllClients,
+		"add_timer":        luaEntityAddTimer,
+		"add_callback":     luaEntityAddCallback,
+		"cancel_timer":     luaEntityCancelTimer,
+		"destroy":          luaEntityDestroy,
+	}))
+	L.SetField(mt, "__tostring", L.NewFunction(func(L *lua.LState) int {
+		L.Push(lua.LString(checkEntity(L, 1).String()))
+		return 1
+	}))
+
+	mt = L.NewTypeMetatable(_ENTITY_TYPE_DESC_NAME)
+	L.SetField(mt, "__index", L.SetFuncs(L.NewTable(), map[string]lua.LGFunction{
+		"define_attr":    luaDescDefineAttr,
+		"set_persistent": luaDescSetPersistent,
+		"set_use_aoi":    luaDescSetUseAOI,
+	}))
+}
+
+// newEntityValue wraps the entity as Lua userdata
+func newEntityValue(L *lua.LState, e *entity.Entity) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = e
+	L.SetMetatable(ud, L.GetTypeMetatable(_ENTITY_TYPE_NAME))
+	return ud
+}
+
+// newEntityTypeDescValue wraps the entity type desc as Lua userdata
+func newEntityTypeDescValue(L *lua.LState, desc *entity.EntityTypeDesc) *lua.LUserData {
+	ud := L.NewUserData()
+	ud.Value = desc
+	L.SetMetatable(ud, L.GetTypeMetatable(_ENTITY_TYPE_DESC_NAME))
+	return ud
+}
+
+func checkEntity(L *lua.LState, n int) *entity.Entity {
+	if e, ok := L.CheckUserData(n).Value.(*entity.Entity); ok {
+		return e
+	}
+	L.ArgError(n, "entity expected")
+	return nil
+}
+
+// checkAliveEntity checks the argument is an entity which is not destroyed
+func checkAliveEntity(L *lua.LState, n int) *entity.Entity {
+	e := checkEntity(L, n)
+	if e.IsDestroyed() {
+		L.RaiseError("%s is destroyed", e)
+	}
+	return e
+}
+
+func checkEntityTypeDesc(L *lua.LState, n int) *entity.EntityTypeDesc {
+	if desc, ok := L.CheckUserData(n).Value.(*entity.EntityTypeDesc); ok {
+		return desc
+	}
+	L.ArgError(n, "entity type desc expected")
+	return nil
+}
+
+// checkDuration checks the argument is the duration in seconds
+func checkDuration(L *lua.LState, n int) time.Duration {
+	return time.Duration(float64(L.CheckNumber(n)) * float64(time.Second))
+}
+
+// goworld.get_entity(id) returns the entity on this game, or nil
+func luaGetEntity(L *lua.LState) int {
+	e := entity.GetEntity(common.EntityID(L.CheckString(1)))
+	if e == nil {
+		L.Push(lua.LNil)
+	} else {
+		L.Push(newEntityValue(L, e))
+	}
+	return 1
+}
+
+// goworld.call(id, method, ...) calls the method of any entity
+func luaCall(L *lua.LState) int {
+	entity.Call(common.EntityID(L.CheckString(1)), L.CheckString(2), argsToGo(L, 3))
+	return 0
+}
+
+// goworld.call_service(service, method, ...) calls the method of the service
+func luaCallService(L *lua.LState) int {
+	service.CallService(L.CheckString(1), L.CheckString(2), argsToGo(L, 3))
+	return 0
+}
+
+// goworld.log(...) logs arguments separated by spaces
+func luaLog(L *lua.LState) int {
+	var msgs []string
+	for i := 1; i <= L.GetTop(); i++ {
+		msgs = append(msgs, L.ToStringMeta(L.Get(i)).String())
+	}
+	logger.Infof("%s", strings.Join(msgs, " "))
+	return 0
+}
+
+func luaEntityID(L *lua.LState) int {
+	L.Push(lua.LString(checkEntity(L, 1).ID))
+	return 1
+}
+
+func luaEntityTypeName(L *lua.LState) int {
+	L.Push(lua.LString(checkEntity(L, 1).TypeName))
+	return 1
+}
+
+func luaEntityIsDestroyed(L *lua.LState) int {
+	L.Push(lua.LBool(checkEntity(L, 1).IsDestroyed()))
+	return 1
+}
+
+// e:get(key) returns the attribute, or nil if the attribute is not set
+func luaEntityGet(L *lua.LState) int {
+	e := checkEntity(L, 1)
+	key := L.CheckString(2)
+	attrs := e.Attrs.ToMapWithFilter(func(k string) bool {
+		return k == key
+	})
+	L.Push(toLua(L, attrs[key]))
+	return 1
+}
+
+// e:set(key, value) sets the attribute, or deletes the attribute if value is nil
+func luaEntitySet(L *lua.LState) int {
+	e := checkAliveEntity(L, 1)
+	key := L.CheckString(2)
+	val := toGo(L.Get(3))
+	if val == nil {
+		e.Attrs.Del(key)
+	} else {
+		e.Attrs.AssignMap(map[string]interface{}{key: val})
+	}
+	return 0
+}
+
+// e:position() returns x, y, z of the entity
+func luaEntityPosition(L *lua.LState) int {
+	pos := checkEntity(L, 1).GetPosition()
+	L.Push(lua.LNumber(pos.X))
+	L.Push(lua.LNumber(pos.Y))
+	L.Push(lua.LNumber(pos.Z))
+	return 3
+}
+
+// e:call(id, method, ...) calls the method of any entity
+func luaEntityCall(L *lua.LState) int {
+	e := checkAliveEntity(L, 1)
+	e.Call(common.EntityID(L.CheckString(2)), L.CheckString(3), argsToGo(L, 4)...)
+	return 0
+}
+
+// e:call_client(method, ...) calls the method of the client of the entity
+func luaEntityCallClient(L *lua.LState) int {
+	e := checkAliveEntity(L, 1)
+	e.CallClient(L.CheckString(2), argsToGo(L, 3)...)
+	return 0
+}
+
+// e:call_all_clients(method, ...) calls the method of clients of the entity and its neighbors
+func luaEntityCallAllClients(L *lua.LState) int {
+	e := checkAliveEntity(L, 1)
+	e.CallAllClients(L.CheckString(2), argsToGo(L, 3)...)
+	return 0
+}
+
+// e:add_timer(seconds, handler, ...) adds the repeat timer calling the handler of the script, returns the timer ID
+//
+// Timers are kept when the entity is migrated, freezed or restored.
+func luaEntityAddTimer(L *lua.LState) int {
+	e := checkAliveEntity(L, 1)
+	tid := e.AddTimer(checkDuration(L, 2), "Script", L.CheckString(3), argsToGo(L, 4))
+	L.Push(lua.LNumber(tid))
+	return 1
+}
+
+// e:add_callback(seconds, handler, ...) adds the one-time callback calling the handler of the script, returns the timer ID
+func luaEntityAddCallback(L *lua.LState) int {
+	e := checkAliveEntity(L, 1)
+	tid := e.AddCallback(checkDuration(L, 2), "Script", L.CheckString(3), argsToGo(L, 4))
+	L.Push(lua.LNumber(tid))
+	return 1
+}
+
+// e:cancel_timer(id) cancels the timer or callback
+func luaEntityCancelTimer(L *lua.LState) int {
+	e := checkEntity(L, 1)
+	e.CancelTimer(entity.EntityTimerID(L.CheckInt(2)))
+	return 0
+}
+
+func luaEntityDestroy(L *lua.LState) int {
+	checkEntity(L, 1).Destroy()
+	return 0
+}
+
+// desc:define_attr(name, ...) defines the attribute with properties like "Client", "AllClients" and "Persistent"
+func luaDescDefineAttr(L *lua.LState) int {
+	desc := checkEntityTypeDesc(L, 1)
+	var defs []string
+	for i := 3; i <= L.GetTop(); i++ {
+		defs = append(defs, L.CheckString(i))
+	}
+	desc.DefineAttr(L.CheckString(2), defs...)
+	return 0
+}
+
+// desc:set_persistent(persistent) sets if entities are saved to the storage
+func luaDescSetPersistent(L *lua.LState) int {
+	checkEntityTypeDesc(L, 1).SetPersistent(L.CheckBool(2))
+	return 0
+}
+
+// desc:set_use_aoi(use_aoi, distance) sets if entities use AOI and the AOI distance
+func luaDescSetUseAOI(L *lua.LState) int {
+	checkEntityTypeDesc(L, 1).SetUseAOI(L.CheckBool(2), entity.Coord(L.OptNumber(3, 0)))
+	return 0
+}
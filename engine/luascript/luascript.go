@@ -0,0 +1,119 @@
+// Package luascript binds entity behaviors to Lua scripts, so that logic like NPCs and quests can be changed by
+// reloading scripts without rebuilding and redeploying games.
+//
+// Each scripted entity type has a script returning a table of hooks and handlers:
+//
+//	local NPC = {}
+//	function NPC.describe(desc) desc:define_attr("hp", "AllClients", "Persistent") end
+//	function NPC.on_created(e) e:set("hp", 100) e:add_timer(1, "regen") end
+//	function NPC.regen(e) e:set("hp", math.min(e:get("hp") + 1, 100)) end
+//	function NPC.talk_client(e, text) e:call_client("OnTalk", "hello") end
+//	return NPC
+//
+// All scripts run in the same Lua state in the game routine.
+package luascript
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	lua "github.com/yuin/gopher-lua"
+)
+
+var (
+	logger = gwlog.Module("luascript")
+
+	state            *lua.LState
+	scripts          = map[string]*script{} // scripts by entity type names
+	describingScript *script                // script of the entity type being registered
+)
+
+// script is the Lua script of the entity type
+type script struct {
+	file   string
+	module *lua.LTable // table returned by the script, containing hooks and handlers
+}
+
+// getState returns the Lua state shared by all scripts
+func getState() *lua.LState {
+	if state == nil {
+		state = lua.NewState()
+		openGoworldLib(state)
+	}
+	return state
+}
+
+// loadModule runs the script file and returns the table returned by the script
+func loadModule(file string) (*lua.LTable, error) {
+	L := getState()
+	fn, err := L.LoadFile(file)
+	if err != nil {
+		return nil, errors.Wrapf(err, "load script %s failed", file)
+	}
+
+	L.Push(fn)
+	if err := L.PCall(0, 1, nil); err != nil {
+		return nil, errors.Wrapf(err, "run script %s failed", file)
+	}
+	ret := L.Get(-1)
+	L.Pop(1)
+
+	module, ok := ret.(*lua.LTable)
+	if !ok {
+		return nil, errors.Errorf("script %s should return a table, but returns %s", file, ret.Type())
+	}
+	return module, nil
+}
+
+// RegisterEntity registers the entity type whose behaviors are defined by the Lua script
+//
+// The entity should be *ScriptEntity, or a pointer of struct type embedding ScriptEntity. Attributes are defined by
+// the describe function of the script when the type is registered.
+func RegisterEntity(typeName string, entityPtr entity.IEntity, scriptFile string) *entity.EntityTypeDesc {
+	module, err := loadModule(scriptFile)
+	if err != nil {
+		gwlog.Fatalf("RegisterEntity %s: %s", typeName, err)
+	}
+
+	s := &script{file: scriptFile, module: module}
+	scripts[typeName] = s
+	describingScript = s
+	defer func() {
+		describingScript = nil
+	}()
+	return entity.RegisterEntity(typeName, entityPtr, false)
+}
+
+// ReloadScripts reloads scripts of all entity types
+//
+// Hooks and handlers of existing entities are replaced immediately, while attributes defined by describe functions can
+// not be changed. Scripts are not replaced if any script fails to load.
+func ReloadScripts() error {
+	modules := map[string]*lua.LTable{}
+	for typeName, s := range scripts {
+		module, err := loadModule(s.file)
+		if err != nil {
+			return err
+		}
+		modules[typeName] = module
+	}
+
+	for typeName, module := range modules {
+		scripts[typeName].module = module
+	}
+	logger.Infof("%d scripts reloaded", len(modules))
+	return nil
+}
+
+// call calls the function of the script with arguments, does nothing if the function is not defined unless required
+func (s *script) call(name string, required bool, args ...lua.LValue) error {
+	fn, ok := s.module.RawGetString(name).(*lua.LFunction)
+	if !ok {
+		if required {
+			return errors.Errorf("function %s is not defined in script %s", name, s.file)
+		}
+		return nil
+	}
+
+	return getState().CallByParam(lua.P{Fn: fn, NRet: 0, Protect: true}, args...)
+}
@@ -0,0 +1,96 @@
+package luascript
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	timer "github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+const testScript = `
+local M = {}
+function M.describe(desc)
+	desc:define_attr("hp", "AllClients")
+end
+function M.on_created(e)
+	e:set("hp", 100)
+	e:set("items", {"sword", "shield"})
+	e:add_callback(0, "regen", 5)
+end
+function M.regen(e, n)
+	e:set("hp", e:get("hp") + n)
+end
+function M.damage(e, n)
+	e:set("hp", e:get("hp") - n)
+end
+return M
+`
+
+func writeScript(t *testing.T, dir string, content string) string {
+	file := filepath.Join(dir, "test.lua")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return file
+}
+
+func TestScriptEntity(t *testing.T) {
+	dir, err := ioutil.TempDir("", "luascript")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	file := writeScript(t, dir, testScript)
+	RegisterEntity("TestScriptEntity", &ScriptEntity{}, file)
+	e := entity.CreateEntityLocally("TestScriptEntity", nil)
+	if e.GetInt("hp") != 100 || e.GetListAttr("items").Size() != 2 {
+		t.Fatalf("attrs should be set by on_created, but got %v", e.Attrs.ToMap())
+	}
+
+	timer.Tick()
+	if e.GetInt("hp") != 105 {
+		t.Fatalf("callback should call regen of the script, but hp is %d", e.GetInt("hp"))
+	}
+
+	se := e.I.(*ScriptEntity)
+	se.Script("damage", []interface{}{int64(30)})
+	if e.GetInt("hp") != 75 {
+		t.Fatalf("damage should be called with arguments, but hp is %d", e.GetInt("hp"))
+	}
+	se.ClientScript_Client("damage", []interface{}{int64(30)})
+	if e.GetInt("hp") != 75 {
+		t.Fatalf("handlers without _client suffix should not be called by clients")
+	}
+
+	writeScript(t, dir, "syntax error")
+	if err := ReloadScripts(); err == nil {
+		t.Fatalf("reloading invalid script should fail")
+	}
+	writeScript(t, dir, "return { damage = function(e, n) e:set('hp', e:get('hp') - n * 2) end }")
+	if err := ReloadScripts(); err != nil {
+		t.Fatal(err)
+	}
+	se.Script("damage", []interface{}{int64(30)})
+	if e.GetInt("hp") != 15 {
+		t.Fatalf("reloaded handler should be called, but hp is %d", e.GetInt("hp"))
+	}
+}
+
+func TestConvert(t *testing.T) {
+	L := getState()
+	m := toGo(toLua(L, map[string]interface{}{
+		"int":   int64(1),
+		"float": 1.5,
+		"list":  []interface{}{"a", true},
+	})).(map[string]interface{})
+	if m["int"] != int64(1) || m["float"] != 1.5 {
+		t.Errorf("numbers should be converted to int64 or float64, but got %#v", m)
+	}
+	if list, ok := m["list"].([]interface{}); !ok || len(list) != 2 || list[0] != "a" || list[1] != true {
+		t.Errorf("sequence should be converted to list, but got %#v", m["list"])
+	}
+}
@@ -0,0 +1,150 @@
+package netutil
+
+import (
+	"encoding/binary"
+
+	"github.com/pkg/errors"
+)
+
+// MaxMessagePackDepth is the maximum nesting depth of arrays and maps in MessagePack data to unpack
+const MaxMessagePackDepth = 100
+
+// ErrMalformedMessagePack is returned by CheckMessagePack if the data is not a well formed MessagePack value
+var ErrMalformedMessagePack = errors.New("malformed MessagePack data")
+
+// CheckMessagePack checks that data begins with a complete MessagePack value, and arrays and maps are nested at most
+// maxDepth levels
+//
+// Decoding MessagePack is recursive, and allocates arrays and maps of the lengths in data, so data received from
+// clients is checked (without recursion or allocation) before decoding, so that a small packet can not crash the
+// process by a deeply nested value or a huge length.
+//
+// Maps not keyed by strings are decoded to maps of the types of the first key and value, so the first key can not be
+// nil, an array or a map, and the first value can not be nil, otherwise the decoder panics.
+func CheckMessagePack(data []byte, maxDepth int) error {
+	var pending []uint64 // numbers of values left in outer arrays and maps
+	left := uint64(1)    // number of values left in the current array or map
+	pos := uint64(0)
+	end := uint64(len(data))
+	firstMapKey := false   // the value is the first key of a map
+	firstMapValue := false // the value is the first value of a map not keyed by strings
+
+	// readLen reads the big endian length of n bytes
+	readLen := func(n uint64) (uint64, error) {
+		if pos+n > end {
+			return 0, errors.Wrapf(ErrMalformedMessagePack, "truncated length at %d", pos)
+		}
+		b := data[pos : pos+n]
+		pos += n
+		switch n {
+		case 1:
+			return uint64(b[0]), nil
+		case 2:
+			return uint64(binary.BigEndian.Uint16(b)), nil
+		default:
+			return uint64(binary.BigEndian.Uint32(b)), nil
+		}
+	}
+
+	for {
+		for left == 0 {
+			if len(pending) == 0 {
+				return nil
+			}
+			left = pending[len(pending)-1]
+			pending = pending[:len(pending)-1]
+		}
+		left--
+
+		if pos >= end {
+			return errors.Wrapf(ErrMalformedMessagePack, "truncated value at %d", pos)
+		}
+		b := data[pos]
+		pos++
+
+		if firstMapKey {
+			firstMapKey = false
+			isStr := (b >= 0xa0 && b <= 0xbf) || (b >= 0xc4 && b <= 0xc6) || (b >= 0xd9 && b <= 0xdb)
+			if b == 0xc0 || (b >= 0x80 && b <= 0x9f) || (b >= 0xdc && b <= 0xdf) {
+				return errors.Wrapf(ErrMalformedMessagePack, "invalid map key format 0x%x at %d", b, pos-1)
+			}
+			firstMapValue = !isStr
+		} else if firstMapValue {
+			firstMapValue = false
+			if b == 0xc0 {
+				return errors.Wrapf(ErrMalformedMessagePack, "nil value of map not keyed by strings at %d", pos-1)
+			}
+		}
+
+		var size uint64  // size of the value after the format byte and length
+		var count uint64 // number of values in the array or map
+		var err error
+		switch {
+		case b <= 0x7f || b >= 0xe0 || b == 0xc0 || b == 0xc2 || b == 0xc3: // fixint, nil and bool
+		case b <= 0x8f: // fixmap
+			count = uint64(b&0x0f) * 2
+		case b <= 0x9f: // fixarray
+			count = uint64(b & 0x0f)
+		case b <= 0xbf: // fixstr
+			size = uint64(b & 0x1f)
+		case b == 0xc4 || b == 0xd9: // bin 8, str 8
+			size, err = readLen(1)
+		case b == 0xc5 || b == 0xda: // bin 16, str 16
+			size, err = readLen(2)
+		case b == 0xc6 || b == 0xdb: // bin 32, str 32
+			size, err = readLen(4)
+		case b == 0xc7: // ext 8
+			size, err = readLen(1)
+			size++ // ext type
+		case b == 0xc8: // ext 16
+			size, err = readLen(2)
+			size++
+		case b == 0xc9: // ext 32
+			size, err = readLen(4)
+			size++
+		case b == 0xca: // float 32
+			size = 4
+		case b == 0xcb: // float 64
+			size = 8
+		case b >= 0xcc && b <= 0xcf: // uint 8, 16, 32, 64
+			size = 1 << (b - 0xcc)
+		case b >= 0xd0 && b <= 0xd3: // int 8, 16, 32, 64
+			size = 1 << (b - 0xd0)
+		case b >= 0xd4 && b <= 0xd8: // fixext 1, 2, 4, 8, 16
+			size = 1 + 1<<(b-0xd4)
+		case b == 0xdc: // array 16
+			count, err = readLen(2)
+		case b == 0xdd: // array 32
+			count, err = readLen(4)
+		case b == 0xde: // map 16
+			count, err = readLen(2)
+			count *= 2
+		case b == 0xdf: // map 32
+			count, err = readLen(4)
+			count *= 2
+		default: // 0xc1 is never used
+			return errors.Wrapf(ErrMalformedMessagePack, "invalid format 0x%x at %d", b, pos-1)
+		}
+		if err != nil {
+			return err
+		}
+
+		if pos+size > end {
+			return errors.Wrapf(ErrMalformedMessagePack, "truncated value of %d bytes at %d", size, pos)
+		}
+		pos += size
+
+		if count > 0 {
+			// each value takes at least one byte
+			if pos+count > end {
+				return errors.Wrapf(ErrMalformedMessagePack, "truncated array or map of %d values at %d", count, pos)
+			}
+			if len(pending) >= maxDepth {
+				return errors.Wrapf(ErrMalformedMessagePack, "arrays and maps nested deeper than %d", maxDepth)
+			}
+			pending = append(pending, left)
+			left = count
+			firstMapKey = (b >= 0x80 && b <= 0x8f) || b == 0xde || b == 0xdf
+		}
+	}
+}
@@ -0,0 +1,71 @@
+package netutil
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestCheckMessagePack(t *testing.T) {
+	values := []interface{}{
+		nil, true, 1, -1, 300, -70000, 1 << 40, 1.5, float32(2.5), "", "abc", string(make([]byte, 300)),
+		[]byte{1, 2, 3}, make([]byte, 70000), []interface{}{}, []interface{}{1, "a", []interface{}{nil}},
+		make([]int, 20), map[string]interface{}{"a": 1, "b": map[string]interface{}{"c": []interface{}{1.5}}},
+		map[string]interface{}{"a": nil}, map[int]interface{}{1: "a"}, map[bool]interface{}{true: []interface{}{nil}},
+	}
+	for _, v := range values {
+		data, err := MSG_PACKER.PackMsg(v, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := CheckMessagePack(data, MaxMessagePackDepth); err != nil {
+			t.Errorf("check %#v failed: %s", v, err)
+		}
+		// every truncated data is malformed
+		for i := 0; i < len(data); i++ {
+			if err := CheckMessagePack(data[:i], MaxMessagePackDepth); errors.Cause(err) != ErrMalformedMessagePack {
+				t.Errorf("check %#v truncated to %d bytes should fail, but got %v", v, i, err)
+			}
+		}
+	}
+}
+
+func TestCheckMessagePackMalformed(t *testing.T) {
+	cases := map[string][]byte{
+		"never used":   {0xc1},
+		"huge array":   {0xdd, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"huge map":     {0xdf, 0x7f, 0xff, 0xff, 0xff, 0x01, 0x01},
+		"huge str":     {0xdb, 0xff, 0xff, 0xff, 0xff, 'a'},
+		"huge ext":     {0xc9, 0xff, 0xff, 0xff, 0xff, 0x01},
+		"short fixext": {0xd8, 0x01, 0x00},
+		"short map":    {0x81, 0xa1, 'a'},
+		"depth bomb":   append(bytes.Repeat([]byte{0x91}, MaxMessagePackDepth+1), 0xc0),
+		// the decoder panics on these maps
+		"nil map key":        {0x81, 0xc0, 0x01},
+		"array map key":      {0x81, 0x90, 0x01},
+		"map map key":        {0x81, 0x80, 0x01},
+		"nil map value":      {0x81, 0x01, 0xc0},
+		"nested nil map key": {0x91, 0xde, 0x00, 0x01, 0xc0, 0x01},
+	}
+	for name, data := range cases {
+		if err := CheckMessagePack(data, MaxMessagePackDepth); errors.Cause(err) != ErrMalformedMessagePack {
+			t.Errorf("%s: check should fail, but got %v", name, err)
+		}
+	}
+
+	nested := append(bytes.Repeat([]byte{0x91}, MaxMessagePackDepth), 0xc0)
+	if err := CheckMessagePack(nested, MaxMessagePackDepth); err != nil {
+		t.Errorf("check nested arrays of max depth failed: %s", err)
+	}
+
+	var v interface{}
+	for name, data := range cases {
+		if err := MSG_PACKER.UnpackMsg(data, &v); errors.Cause(err) != ErrMalformedMessagePack {
+			t.Errorf("%s: unpack should fail, but got %v", name, err)
+		}
+	}
+	if err := MSG_PACKER.UnpackMsg(append(bytes.Repeat([]byte{0x91}, 1000000), 0xc0), &v); errors.Cause(err) != ErrMalformedMessagePack {
+		t.Errorf("unpack depth bomb should fail, but got %v", err)
+	}
+}
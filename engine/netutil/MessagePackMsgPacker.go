@@ -23,7 +23,12 @@ func (mp MessagePackMsgPacker) PackMsg(msg interface{}, buf []byte) ([]byte, err
 }
 
 // UnpackMsg unpacksbytes in MessagePack format to message
+//
+// Data is checked by CheckMessagePack before unpacking, since data can be sent by clients.
 func (mp MessagePackMsgPacker) UnpackMsg(data []byte, msg interface{}) error {
+	if err := CheckMessagePack(data, MaxMessagePackDepth); err != nil {
+		return err
+	}
 	err := msgpack.Unmarshal(data, msg)
 	return err
 }
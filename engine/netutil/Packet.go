@@ -14,17 +14,22 @@ import (
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/netutil/compress"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
 )
 
 const (
 	_MIN_PAYLOAD_CAP = 128
 	_CAP_GROW_SHIFT  = uint(2)
 
-	_PAYLOAD_LEN_MASK            = 0x7FFFFFFF
+	_PAYLOAD_LEN_MASK            = 0x3FFFFFFF
 	_PAYLOAD_COMPRESSED_BIT_MASK = 0x80000000
+	_PAYLOAD_ENCRYPTED_BIT_MASK  = 0x40000000
 )
 
 var (
+	// ErrMalformedPacket is panicked by reading packets if the unread payload is shorter than what is read
+	ErrMalformedPacket = errors.New("malformed packet")
+
 	packetEndian               = binary.LittleEndian
 	predefinePayloadCapacities []uint32
 
@@ -89,6 +94,7 @@ type Packet struct {
 	readCursor uint32
 
 	notCompress  bool
+	urgent       bool
 	refcount     int64
 	bytes        []byte
 	initialBytes [_PREPAYLOAD_SIZE + _MIN_PAYLOAD_CAP]byte
@@ -98,8 +104,8 @@ func allocPacket() *Packet {
 	pkt := packetPool.Get().(*Packet)
 	pkt.refcount = 1
 
-	if pkt.notCompress {
-		gwlog.Panicf("notCompress should be false")
+	if pkt.notCompress || pkt.urgent {
+		gwlog.Panicf("notCompress and urgent should be false")
 	}
 
 	if consts.DEBUG_PACKET_ALLOC {
@@ -123,6 +129,16 @@ func (p *Packet) SetNotCompress() {
 	p.notCompress = true
 }
 
+// SetUrgent marks the packet as urgent, so that auto flushing connections flush it immediately
+func (p *Packet) SetUrgent() {
+	p.urgent = true
+}
+
+// IsUrgent returns if the packet is urgent
+func (p *Packet) IsUrgent() bool {
+	return p.urgent
+}
+
 func (p *Packet) AssureCapacity(need uint32) {
 	requireCap := p.GetPayloadLen() + need
 	oldCap := p.PayloadCap()
@@ -178,9 +194,17 @@ func (p *Packet) UnreadPayload() []byte {
 
 // HasUnreadPayload returns if all payload is read
 func (p *Packet) HasUnreadPayload() bool {
-	pos := p.readCursor + _PREPAYLOAD_SIZE
-	plen := p.GetPayloadLen()
-	return pos < plen
+	return p.readCursor < p.GetPayloadLen()
+}
+
+// checkUnread panics with ErrMalformedPacket if the unread payload is shorter than size
+//
+// Packets received from clients can be malformed, so reading never goes beyond the payload, and the size (which can
+// be read from the packet) is checked in uint64 to avoid overflows.
+func (p *Packet) checkUnread(size uint64) {
+	if uint64(p.readCursor)+size > uint64(p.GetPayloadLen()) {
+		panic(errors.Wrapf(ErrMalformedPacket, "reading %d bytes at %d, but payload length is %d", size, p.readCursor, p.GetPayloadLen()))
+	}
 }
 
 func (p *Packet) data() []byte {
@@ -207,6 +231,7 @@ func (p *Packet) Release() {
 		p.readCursor = 0
 		p.setPayloadLenCompressed(0, false)
 		p.notCompress = false
+		p.urgent = false
 		packetPool.Put(p)
 
 		if consts.DEBUG_PACKET_ALLOC {
@@ -232,6 +257,7 @@ func (p *Packet) AppendByte(b byte) {
 
 // ReadOneByte reads one byte from the beginning
 func (p *Packet) ReadOneByte() (v byte) {
+	p.checkUnread(1)
 	pos := p.readCursor + _PREPAYLOAD_SIZE
 	v = p.bytes[pos]
 	p.readCursor += 1
@@ -341,6 +367,7 @@ func (p *Packet) AppendVarBytes(v []byte) {
 
 // ReadUint16 reads one uint16 from the beginning of unread payload
 func (p *Packet) ReadUint16() (v uint16) {
+	p.checkUnread(2)
 	pos := p.readCursor + _PREPAYLOAD_SIZE
 	v = packetEndian.Uint16(p.bytes[pos : pos+2])
 	p.readCursor += 2
@@ -349,6 +376,7 @@ func (p *Packet) ReadUint16() (v uint16) {
 
 // ReadUint32 reads one uint32 from the beginning of unread payload
 func (p *Packet) ReadUint32() (v uint32) {
+	p.checkUnread(4)
 	pos := p.readCursor + _PREPAYLOAD_SIZE
 	v = packetEndian.Uint32(p.bytes[pos : pos+4])
 	p.readCursor += 4
@@ -357,6 +385,7 @@ func (p *Packet) ReadUint32() (v uint32) {
 
 // ReadUint64 reads one uint64 from the beginning of unread payload
 func (p *Packet) ReadUint64() (v uint64) {
+	p.checkUnread(8)
 	pos := p.readCursor + _PREPAYLOAD_SIZE
 	v = packetEndian.Uint64(p.bytes[pos : pos+8])
 	p.readCursor += 8
@@ -365,11 +394,8 @@ func (p *Packet) ReadUint64() (v uint64) {
 
 // ReadBytes reads bytes from the beginning of unread payload
 func (p *Packet) ReadBytes(size uint32) []byte {
+	p.checkUnread(uint64(size))
 	pos := p.readCursor + _PREPAYLOAD_SIZE
-	if pos > uint32(len(p.bytes)) || pos+size > uint32(len(p.bytes)) {
-		gwlog.Panicf("Packet %p bytes is %d, but reading %d+%d", p, len(p.bytes), pos, size)
-	}
-
 	bytes := p.bytes[pos : pos+size] // bytes are not copied
 	p.readCursor += size
 	return bytes
@@ -423,6 +449,7 @@ func (p *Packet) AppendMapStringString(m map[string]string) {
 
 func (p *Packet) ReadMapStringString() map[string]string {
 	size := p.ReadUint32()
+	p.checkUnread(uint64(size) * 8) // each item has the lengths of key and value
 	m := make(map[string]string, size)
 	for i := uint32(0); i < size; i++ {
 		k := p.ReadVarStr()
@@ -465,6 +492,7 @@ func (p *Packet) AppendArgs(args []interface{}) {
 // ReadArgs reads a number of arguments from the beginning of unread payload
 func (p *Packet) ReadArgs() [][]byte {
 	argCount := p.ReadUint16()
+	p.checkUnread(uint64(argCount) * 4) // each argument has its length
 	args := make([][]byte, argCount)
 	var i uint16
 	for i = 0; i < argCount; i++ {
@@ -484,6 +512,7 @@ func (p *Packet) AppendStringList(list []string) {
 // ReadStringList reads a list of strings from the beginning of unread payload
 func (p *Packet) ReadStringList() []string {
 	listlen := int(p.ReadUint16())
+	p.checkUnread(uint64(listlen) * 4) // each string has its length
 	list := make([]string, listlen)
 	for i := 0; i < listlen; i++ {
 		list[i] = p.ReadVarStr()
@@ -500,6 +529,7 @@ func (p *Packet) AppendEntityIDSet(eids common.EntityIDSet) {
 
 func (p *Packet) ReadEntityIDSet() common.EntityIDSet {
 	size := p.ReadUint32()
+	p.checkUnread(uint64(size) * common.ENTITYID_LENGTH)
 	eids := make(common.EntityIDSet, size)
 	for i := uint32(0); i < size; i++ {
 		eids.Add(p.ReadEntityID())
@@ -527,23 +557,21 @@ func (p *Packet) setPayloadLenCompressed(plen uint32, compressed bool) {
 	}
 }
 
-func (p *Packet) requireCompress() bool {
-	return !p.notCompress && !p.isCompressed() && p.GetPayloadLen() >= consts.PACKET_PAYLOAD_LEN_COMPRESS_THRESHOLD
+func (p *Packet) requireCompress(threshold uint32) bool {
+	return !p.notCompress && !p.isCompressed() && p.GetPayloadLen() >= threshold
 }
 
-func (p *Packet) compress(compressor compress.Compressor) {
-	if !p.requireCompress() {
-		return
-	}
-
-	payloadCap := p.PayloadCap()
-	compressedBuffer := packetBufferPools[payloadCap].Get().([]byte)
-	//w := bytes.NewBuffer(compressedBuffer[_PREPAYLOAD_SIZE:_PREPAYLOAD_SIZE])
-	//cw.Reset(w)
-
+// compress compresses the payload to a new packet and returns it, or returns nil if compress is not useful enough
+//
+// The packet itself is never modified because it might be shared by connections using different compressors
+func (p *Packet) compress(compressor compress.Compressor) *Packet {
 	oldPayload := p.Payload()
 	oldPayloadLen := len(oldPayload)
-	compressedPayload, err := compressor.Compress(oldPayload, compressedBuffer[_PREPAYLOAD_SIZE:_PREPAYLOAD_SIZE])
+
+	cp := allocPacket()
+	cp.AssureCapacity(uint32(oldPayloadLen))
+	compressedBuffer := cp.TotalPayload()
+	compressedPayload, err := compressor.Compress(oldPayload, compressedBuffer[:0])
 	if err != nil {
 		gwlog.Panic(errors.Wrap(err, "compress failed"))
 	}
@@ -553,21 +581,17 @@ func (p *Packet) compress(compressor compress.Compressor) {
 	//fmt.Printf("(%.1fKB=%.1f%%)", float64(oldPayloadLen)/1024.0, float64(compressedPayloadLen)*100.0/float64(oldPayloadLen))
 
 	if compressedPayloadLen >= oldPayloadLen-4 { // leave 4 bytes for AppendUint32 in the last
-		return // compress not useful enough, throw away
+		cp.Release()
+		return nil // compress not useful enough, throw away
 	}
 
-	if &compressedPayload[0] != &compressedBuffer[_PREPAYLOAD_SIZE] {
+	if &compressedPayload[0] != &compressedBuffer[0] {
 		gwlog.Panicf("should equal")
 	}
 
-	// reclaim the old payload buffer and use new compressed buffer
-	packetBufferPools[payloadCap].Put(p.bytes)
-	p.bytes = compressedBuffer
-	pplen := (*uint32)(unsafe.Pointer(&p.bytes[0]))
-	*pplen = _PAYLOAD_COMPRESSED_BIT_MASK | uint32(compressedPayloadLen)
-
-	p.AppendUint32(uint32(oldPayloadLen)) // append the size of old payload to the end of packet
-	return
+	cp.setPayloadLenCompressed(uint32(compressedPayloadLen), true)
+	cp.AppendUint32(uint32(oldPayloadLen)) // append the size of old payload to the end of packet
+	return cp
 }
 
 func (p *Packet) decompress(compressor compress.Compressor) {
@@ -599,3 +623,44 @@ func (p *Packet) decompress(compressor compress.Compressor) {
 func (p *Packet) isCompressed() bool {
 	return *(*uint32)(unsafe.Pointer(&p.bytes[0]))&_PAYLOAD_COMPRESSED_BIT_MASK != 0
 }
+
+// encrypt seals the payload to a new packet and returns it
+//
+// The packet header (payload length and flags) is authenticated as additional data
+func (p *Packet) encrypt(c *crypt.Cipher) *Packet {
+	plainPayload := p.Payload()
+	encryptedPayloadLen := uint32(len(plainPayload) + c.Overhead())
+
+	ep := allocPacket()
+	ep.AssureCapacity(encryptedPayloadLen)
+	pplen := (*uint32)(unsafe.Pointer(&ep.bytes[0]))
+	*pplen = encryptedPayloadLen | _PAYLOAD_ENCRYPTED_BIT_MASK
+	if p.isCompressed() {
+		*pplen |= _PAYLOAD_COMPRESSED_BIT_MASK
+	}
+
+	c.Seal(ep.bytes[_PREPAYLOAD_SIZE:_PREPAYLOAD_SIZE], plainPayload, ep.bytes[:_PREPAYLOAD_SIZE])
+	return ep
+}
+
+// decrypt opens the payload in place, the packet is still compressed if it was compressed before encrypted
+func (p *Packet) decrypt(c *crypt.Cipher) error {
+	header := p.bytes[:_PREPAYLOAD_SIZE]
+	encryptedPayload := p.Payload()
+	plainPayload, err := c.Open(encryptedPayload[:0], encryptedPayload, header)
+	if err != nil {
+		return err
+	}
+
+	p.setPayloadLenCompressed(uint32(len(plainPayload)), p.isCompressed())
+	return nil
+}
+
+func (p *Packet) setEncrypted() {
+	pplen := (*uint32)(unsafe.Pointer(&p.bytes[0]))
+	*pplen |= _PAYLOAD_ENCRYPTED_BIT_MASK
+}
+
+func (p *Packet) isEncrypted() bool {
+	return *(*uint32)(unsafe.Pointer(&p.bytes[0]))&_PAYLOAD_ENCRYPTED_BIT_MASK != 0
+}
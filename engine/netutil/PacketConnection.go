@@ -17,6 +17,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/gwioutil"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/netutil/compress"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
 	"github.com/xiaonanln/goworld/engine/opmon"
 )
 
@@ -31,6 +32,8 @@ var (
 	// NETWORK_ENDIAN is the network Endian of connections
 	NETWORK_ENDIAN = binary.LittleEndian
 	errRecvAgain   = _ErrRecvAgain{}
+	// ErrPayloadTooLarge is returned by RecvPacket if the payload length exceeds the max payload length
+	ErrPayloadTooLarge = errors.New("payload too large")
 	//compressWritersPool = xnsyncutil.NewNewlessPool()
 )
 
@@ -47,6 +50,18 @@ func init() {
 	//gwlog.Infof("%d compress writer created.", consts.COMPRESS_WRITER_POOL_SIZE)
 }
 
+// IntegrityError is returned by RecvPacket if the packet fails the integrity check after decryption is set, e.g. replayed,
+// forged or not encrypted. The packet is dropped, and the connection is not closed, so that the caller decides how to
+// handle the violation.
+type IntegrityError struct {
+	Reason string // replayed, forged or unencrypted
+	Err    error
+}
+
+func (err *IntegrityError) Error() string {
+	return "packet integrity violation: " + err.Err.Error()
+}
+
 type _ErrRecvAgain struct{}
 
 func (err _ErrRecvAgain) Error() string {
@@ -64,31 +79,99 @@ func (err _ErrRecvAgain) Timeout() bool {
 // PacketConnection is a connection that send and receive data packets upon a network stream connection
 type PacketConnection struct {
 	conn               Connection
-	compressed         bool
 	pendingPackets     []*Packet
 	pendingPacketsLock sync.Mutex
 
+	// compressor for sending packets, nil if packets are not compressed
+	compressor        compress.Compressor
+	compressThreshold uint32
+	// compressor switching which takes effect from pendingPackets[switchCompressorAt]
+	switchCompressor          bool
+	switchCompressorAt        int
+	switchToCompressor        compress.Compressor
+	switchToCompressThreshold uint32
+	// cipher for sending packets, nil if packets are not encrypted
+	encryptor *crypt.Cipher
+	// encryptor switching which takes effect from pendingPackets[switchEncryptorAt]
+	switchEncryptor   bool
+	switchEncryptorAt int
+	switchToEncryptor *crypt.Cipher
+
 	// buffers and infos for receiving a packet
 	payloadLenBuf         [_SIZE_FIELD_SIZE]byte
 	payloadLenBytesRecved int
 	recvCompressed        bool
+	recvEncrypted         bool
 	recvTotalPayloadLen   uint32
 	recvedPayloadLen      uint32
 	recvingPacket         *Packet
-	compressor            compress.Compressor
+	decompressor          compress.Compressor
+	decryptor             *crypt.Cipher
+	recvEncryptedOnly     bool // unencrypted packets are rejected after the first encrypted packet is received
+	maxRecvPayloadLen     uint32
 }
 
 // NewPacketConnection creates a packet connection based on network connection
 func NewPacketConnection(conn Connection, compressor compress.Compressor) *PacketConnection {
 	pc := &PacketConnection{
-		conn:       conn,
-		compressed: compressor != nil,
+		conn:              conn,
+		compressor:        compressor,
+		compressThreshold: consts.PACKET_PAYLOAD_LEN_COMPRESS_THRESHOLD,
+		decompressor:      compressor,
+		maxRecvPayloadLen: _MAX_PAYLOAD_LENGTH,
 	}
-
-	pc.compressor = compressor
 	return pc
 }
 
+// SetMaxRecvPayloadLength limits the payload length of received packets
+//
+// RecvPacket returns ErrPayloadTooLarge and closes the connection if the limit is exceeded
+func (pc *PacketConnection) SetMaxRecvPayloadLength(maxPayloadLen uint32) {
+	if maxPayloadLen == 0 || maxPayloadLen > _MAX_PAYLOAD_LENGTH {
+		maxPayloadLen = _MAX_PAYLOAD_LENGTH
+	}
+	pc.maxRecvPayloadLen = maxPayloadLen
+}
+
+// SetCompressor sets the compressor and compress threshold for sending packets
+//
+// Packets sent before SetCompressor are still compressed by the old compressor, so that the remote side can switch decompressor
+// accordingly. Use nil compressor to stop compressing.
+func (pc *PacketConnection) SetCompressor(compressor compress.Compressor, threshold uint32) {
+	pc.pendingPacketsLock.Lock()
+	pc.switchCompressor = true
+	pc.switchCompressorAt = len(pc.pendingPackets)
+	pc.switchToCompressor = compressor
+	pc.switchToCompressThreshold = threshold
+	pc.pendingPacketsLock.Unlock()
+}
+
+// SetDecompressor sets the decompressor for receiving packets
+//
+// SetDecompressor should be called in the goroutine which receives packets
+func (pc *PacketConnection) SetDecompressor(decompressor compress.Compressor) {
+	pc.decompressor = decompressor
+}
+
+// SetEncryptor sets the cipher for sending packets
+//
+// Packets sent before SetEncryptor are still encrypted by the old cipher, so that the remote side can switch decryptor
+// accordingly.
+func (pc *PacketConnection) SetEncryptor(encryptor *crypt.Cipher) {
+	pc.pendingPacketsLock.Lock()
+	pc.switchEncryptor = true
+	pc.switchEncryptorAt = len(pc.pendingPackets)
+	pc.switchToEncryptor = encryptor
+	pc.pendingPacketsLock.Unlock()
+}
+
+// SetDecryptor sets the cipher for receiving encrypted packets
+//
+// SetDecryptor should be called in the goroutine which receives packets
+func (pc *PacketConnection) SetDecryptor(decryptor *crypt.Cipher) {
+	pc.decryptor = decryptor
+}
+
 // NewPacket allocates a new packet (usually for sending)
 func (pc *PacketConnection) NewPacket() *Packet {
 	return allocPacket()
@@ -122,37 +205,36 @@ func (pc *PacketConnection) Flush(reason string) (err error) {
 	}
 	packets := make([]*Packet, 0, len(pc.pendingPackets))
 	packets, pc.pendingPackets = pc.pendingPackets, packets
+	switchCompressor, switchCompressorAt := pc.switchCompressor, pc.switchCompressorAt
+	switchToCompressor, switchToCompressThreshold := pc.switchToCompressor, pc.switchToCompressThreshold
+	pc.switchCompressor, pc.switchToCompressor = false, nil
+	switchEncryptor, switchEncryptorAt, switchToEncryptor := pc.switchEncryptor, pc.switchEncryptorAt, pc.switchToEncryptor
+	pc.switchEncryptor, pc.switchToEncryptor = false, nil
 	pc.pendingPacketsLock.Unlock()
 
 	// flush should only be called in one goroutine
 	op := opmon.StartOperation("FlushPackets-" + reason)
 	defer op.Finish(time.Millisecond * 300)
 
-	//var cw *flate.Writer
-
-	if len(packets) == 1 {
-		// only 1 packet to send, just send it directly, no need to use send buffer
-		packet := packets[0]
-
-		if pc.compressed && packet.requireCompress() {
-			packet.compress(pc.compressor)
+	for i, packet := range packets {
+		if switchCompressor && i == switchCompressorAt {
+			pc.compressor, pc.compressThreshold = switchToCompressor, switchToCompressThreshold
+		}
+		if switchEncryptor && i == switchEncryptorAt {
+			pc.encryptor = switchToEncryptor
 		}
 
-		err = gwioutil.WriteAll(pc.conn, packet.data())
-		packet.Release()
 		if err == nil {
-			err = pc.conn.Flush()
+			err = pc.writePacket(packet)
 		}
-		return
+		packet.Release()
 	}
 
-	for _, packet := range packets {
-		if pc.compressed && packet.requireCompress() {
-			packet.compress(pc.compressor)
-		}
-
-		gwioutil.WriteAll(pc.conn, packet.data())
-		packet.Release()
+	if switchCompressor && switchCompressorAt == len(packets) {
+		pc.compressor, pc.compressThreshold = switchToCompressor, switchToCompressThreshold
+	}
+	if switchEncryptor && switchEncryptorAt == len(packets) {
+		pc.encryptor = switchToEncryptor
 	}
 
 	// now we send all data in the send buffer
@@ -162,6 +244,29 @@ func (pc *PacketConnection) Flush(reason string) (err error) {
 	return
 }
 
+func (pc *PacketConnection) writePacket(packet *Packet) error {
+	if pc.compressor != nil && packet.requireCompress(pc.compressThreshold) {
+		if cp := packet.compress(pc.compressor); cp != nil {
+			err := pc.writePacketData(cp)
+			cp.Release()
+			return err
+		}
+	}
+
+	return pc.writePacketData(packet)
+}
+
+func (pc *PacketConnection) writePacketData(packet *Packet) error {
+	if pc.encryptor != nil {
+		ep := packet.encrypt(pc.encryptor)
+		err := gwioutil.WriteAll(pc.conn, ep.data())
+		ep.Release()
+		return err
+	}
+
+	return gwioutil.WriteAll(pc.conn, packet.data())
+}
+
 // SetRecvDeadline sets the receive deadline
 func (pc *PacketConnection) SetRecvDeadline(deadline time.Time) error {
 	return pc.conn.SetReadDeadline(deadline)
@@ -185,9 +290,14 @@ func (pc *PacketConnection) RecvPacket() (*Packet, error) {
 		if pc.recvCompressed {
 			gwlog.Panicf("should be false")
 		}
-		if pc.recvTotalPayloadLen&_PAYLOAD_COMPRESSED_BIT_MASK != 0 {
-			pc.recvTotalPayloadLen &= _PAYLOAD_LEN_MASK
-			pc.recvCompressed = true
+		pc.recvCompressed = pc.recvTotalPayloadLen&_PAYLOAD_COMPRESSED_BIT_MASK != 0
+		pc.recvEncrypted = pc.recvTotalPayloadLen&_PAYLOAD_ENCRYPTED_BIT_MASK != 0
+		pc.recvTotalPayloadLen &= _PAYLOAD_LEN_MASK
+
+		if pc.recvTotalPayloadLen > pc.maxRecvPayloadLen && pc.recvTotalPayloadLen <= _MAX_PAYLOAD_LENGTH {
+			pc.resetRecvStates()
+			pc.Close()
+			return nil, ErrPayloadTooLarge
 		}
 
 		if pc.recvTotalPayloadLen == 0 || pc.recvTotalPayloadLen > _MAX_PAYLOAD_LENGTH {
@@ -210,8 +320,18 @@ func (pc *PacketConnection) RecvPacket() (*Packet, error) {
 		// full packet received, return the packet
 		packet := pc.recvingPacket
 		packet.setPayloadLenCompressed(pc.recvTotalPayloadLen, pc.recvCompressed)
+		encrypted := pc.recvEncrypted
 		pc.resetRecvStates()
-		packet.decompress(pc.compressor)
+		if encrypted || pc.recvEncryptedOnly {
+			if err := pc.decryptPacket(packet, encrypted); err != nil {
+				packet.Release()
+				if _, ok := err.(*IntegrityError); !ok {
+					pc.Close()
+				}
+				return nil, err
+			}
+		}
+		packet.decompress(pc.decompressor)
 
 		return packet, nil
 	}
@@ -221,12 +341,31 @@ func (pc *PacketConnection) RecvPacket() (*Packet, error) {
 	}
 	return nil, err
 }
+func (pc *PacketConnection) decryptPacket(packet *Packet, encrypted bool) error {
+	if !encrypted {
+		return &IntegrityError{Reason: "unencrypted", Err: errors.Errorf("unencrypted packet received after encryption is enabled")}
+	}
+	if pc.decryptor == nil {
+		return errors.Errorf("encrypted packet received, but decryptor is not set")
+	}
+
+	pc.recvEncryptedOnly = true
+	packet.setEncrypted()
+	if err := packet.decrypt(pc.decryptor); err == crypt.ErrReplayedPacket {
+		return &IntegrityError{Reason: "replayed", Err: err}
+	} else if err != nil {
+		return &IntegrityError{Reason: "forged", Err: err}
+	}
+	return nil
+}
+
 func (pc *PacketConnection) resetRecvStates() {
 	pc.payloadLenBytesRecved = 0
 	pc.recvTotalPayloadLen = 0
 	pc.recvedPayloadLen = 0
 	pc.recvingPacket = nil
 	pc.recvCompressed = false
+	pc.recvEncrypted = false
 }
 
 // Close the connection
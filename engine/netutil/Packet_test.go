@@ -0,0 +1,61 @@
+package netutil
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func readMalformedPacket(payload []byte, read func(p *Packet)) (err error) {
+	p := NewPacket()
+	defer p.Release()
+	p.AppendBytes(payload)
+	defer func() {
+		if r := recover(); r != nil {
+			err = r.(error)
+		}
+	}()
+	read(p)
+	return nil
+}
+
+func TestPacketReadMalformed(t *testing.T) {
+	cases := map[string]struct {
+		payload []byte
+		read    func(p *Packet)
+	}{
+		"byte":          {[]byte{}, func(p *Packet) { p.ReadOneByte() }},
+		"uint16":        {[]byte{1}, func(p *Packet) { p.ReadUint16() }},
+		"uint32":        {[]byte{1, 2, 3}, func(p *Packet) { p.ReadUint32() }},
+		"uint64":        {[]byte{1, 2, 3, 4, 5, 6, 7}, func(p *Packet) { p.ReadUint64() }},
+		"var bytes":     {[]byte{5, 0, 0, 0, 'a'}, func(p *Packet) { p.ReadVarBytes() }},
+		"overflow":      {[]byte{0xff, 0xff, 0xff, 0xff, 'a'}, func(p *Packet) { p.ReadVarBytes() }},
+		"map":           {[]byte{0xff, 0xff, 0xff, 0xff}, func(p *Packet) { p.ReadMapStringString() }},
+		"entity ID set": {[]byte{0xff, 0xff, 0xff, 0xff}, func(p *Packet) { p.ReadEntityIDSet() }},
+		"args":          {[]byte{0xff, 0xff, 0, 0, 0, 0}, func(p *Packet) { p.ReadArgs() }},
+		"string list":   {[]byte{0xff, 0xff}, func(p *Packet) { p.ReadStringList() }},
+	}
+	for name, c := range cases {
+		if err := readMalformedPacket(c.payload, c.read); errors.Cause(err) != ErrMalformedPacket {
+			t.Errorf("%s: read should panic with ErrMalformedPacket, but got %v", name, err)
+		}
+	}
+}
+
+func TestPacketHasUnreadPayload(t *testing.T) {
+	p := NewPacket()
+	defer p.Release()
+	p.AppendUint32(1)
+	p.AppendUint16(2)
+	if !p.HasUnreadPayload() {
+		t.Fatalf("should have unread payload")
+	}
+	p.ReadUint32()
+	if !p.HasUnreadPayload() {
+		t.Fatalf("should have unread payload")
+	}
+	p.ReadUint16()
+	if p.HasUnreadPayload() {
+		t.Fatalf("should have no unread payload")
+	}
+}
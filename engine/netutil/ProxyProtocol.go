@@ -0,0 +1,124 @@
+package netutil
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net"
+	"time"
+
+	"github.com/pkg/errors"
+)
+
+const (
+	_PROXY_PROTOCOL_V2_HEADER_SIZE = 16
+	_PROXY_PROTOCOL_V2_MAX_LENGTH  = 4096 // max length of addresses and TLVs, enough for all known TLVs
+
+	_PROXY_PROTOCOL_V2_CMD_LOCAL = 0x0
+	_PROXY_PROTOCOL_V2_CMD_PROXY = 0x1
+
+	_PROXY_PROTOCOL_V2_FAMILY_TCP4 = 0x11
+	_PROXY_PROTOCOL_V2_FAMILY_UDP4 = 0x12
+	_PROXY_PROTOCOL_V2_FAMILY_TCP6 = 0x21
+	_PROXY_PROTOCOL_V2_FAMILY_UDP6 = 0x22
+)
+
+var (
+	proxyProtocolV2Signature = []byte("\r\n\r\n\x00\r\nQUIT\n")
+
+	// ErrInvalidProxyProtocolHeader is returned when the PROXY protocol header is missing or malformed
+	ErrInvalidProxyProtocolHeader = errors.New("invalid PROXY protocol v2 header")
+)
+
+// proxyProtocolConn is a net.Conn whose remote address is the source address in PROXY protocol header
+type proxyProtocolConn struct {
+	net.Conn
+	remoteAddr net.Addr
+}
+
+func (pc *proxyProtocolConn) RemoteAddr() net.Addr {
+	return pc.remoteAddr
+}
+
+// ReadProxyProtocolHeader reads PROXY protocol v2 header from the connection, and returns a net.Conn
+// whose RemoteAddr is the real client address sent by the proxy (e.g. L4 load balancer)
+//
+// The header must be received within timeout. If the proxy sends LOCAL command or unsupported address family,
+// the original connection is returned.
+func ReadProxyProtocolHeader(conn net.Conn, timeout time.Duration) (net.Conn, error) {
+	if timeout > 0 {
+		conn.SetReadDeadline(time.Now().Add(timeout))
+		defer conn.SetReadDeadline(time.Time{})
+	}
+
+	srcAddr, err := readProxyProtocolV2(conn)
+	if err != nil {
+		return nil, err
+	}
+
+	if srcAddr == nil {
+		return conn, nil
+	}
+	return &proxyProtocolConn{Conn: conn, remoteAddr: srcAddr}, nil
+}
+
+// readProxyProtocolV2 reads the header and returns the source address, or nil if the source address is not available
+func readProxyProtocolV2(r io.Reader) (net.Addr, error) {
+	var header [_PROXY_PROTOCOL_V2_HEADER_SIZE]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		return nil, err
+	}
+
+	if !bytes.Equal(header[:12], proxyProtocolV2Signature) {
+		return nil, ErrInvalidProxyProtocolHeader
+	}
+
+	verCmd, family := header[12], header[13]
+	if verCmd>>4 != 2 {
+		return nil, errors.Wrapf(ErrInvalidProxyProtocolHeader, "unsupported version %d", verCmd>>4)
+	}
+
+	length := int(binary.BigEndian.Uint16(header[14:16]))
+	if length > _PROXY_PROTOCOL_V2_MAX_LENGTH {
+		return nil, errors.Wrapf(ErrInvalidProxyProtocolHeader, "length too large: %d", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := io.ReadFull(r, payload); err != nil {
+		return nil, err
+	}
+
+	switch verCmd & 0x0F {
+	case _PROXY_PROTOCOL_V2_CMD_LOCAL:
+		// connection established by the proxy itself (e.g. health checks)
+		return nil, nil
+	case _PROXY_PROTOCOL_V2_CMD_PROXY:
+		break
+	default:
+		return nil, errors.Wrapf(ErrInvalidProxyProtocolHeader, "unsupported command %d", verCmd&0x0F)
+	}
+
+	switch family {
+	case _PROXY_PROTOCOL_V2_FAMILY_TCP4, _PROXY_PROTOCOL_V2_FAMILY_UDP4:
+		if length < 12 {
+			return nil, errors.Wrapf(ErrInvalidProxyProtocolHeader, "length too small for IPv4: %d", length)
+		}
+		return makeProxyProtocolAddr(family, net.IP(payload[0:4]), binary.BigEndian.Uint16(payload[8:10])), nil
+	case _PROXY_PROTOCOL_V2_FAMILY_TCP6, _PROXY_PROTOCOL_V2_FAMILY_UDP6:
+		if length < 36 {
+			return nil, errors.Wrapf(ErrInvalidProxyProtocolHeader, "length too small for IPv6: %d", length)
+		}
+		return makeProxyProtocolAddr(family, net.IP(payload[0:16]), binary.BigEndian.Uint16(payload[32:34])), nil
+	default:
+		// AF_UNSPEC or AF_UNIX, source address is not available
+		return nil, nil
+	}
+}
+
+func makeProxyProtocolAddr(family byte, ip net.IP, port uint16) net.Addr {
+	ip = append(net.IP(nil), ip...) // copy the IP, so that payload is not referenced
+	if family&0x0F == 0x2 {
+		return &net.UDPAddr{IP: ip, Port: int(port)}
+	}
+	return &net.TCPAddr{IP: ip, Port: int(port)}
+}
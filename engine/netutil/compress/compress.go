@@ -28,6 +28,8 @@ func NewCompressor(compressFormat string) Compressor {
 		return NewLzwCompressor()
 	} else if compressFormat == "flate" {
 		return NewFlateCompressor()
+	} else if compressFormat == "zstd" {
+		return NewZstdCompressor()
 	} else {
 		gwlog.Panicf("unknown compress format: %s", compressFormat)
 		return nil
@@ -25,6 +25,10 @@ func BenchmarkFlateCompressor(b *testing.B) {
 	benchmarkCompressor(b, NewFlateCompressor())
 }
 
+func BenchmarkZstdCompressor(b *testing.B) {
+	benchmarkCompressor(b, NewZstdCompressor())
+}
+
 func BenchmarkZLibCompressor(b *testing.B) {
 	benchmarkCompressor(b, NewZlibCompressor())
 }
@@ -72,6 +76,10 @@ func TestZlibCompressor(t *testing.T) {
 	testCompressor(t, NewZlibCompressor())
 }
 
+func TestZstdCompressor(t *testing.T) {
+	testCompressor(t, NewZstdCompressor())
+}
+
 func TestLzwCompressor(t *testing.T) {
 	testCompressor(t, NewLzwCompressor())
 }
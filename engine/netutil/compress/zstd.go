@@ -0,0 +1,51 @@
+package compress
+
+import (
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+var (
+	zstdOnce    sync.Once
+	zstdEncoder *zstd.Encoder
+	zstdDecoder *zstd.Decoder
+)
+
+// NewZstdCompressor creates a new Compressor in zstd format
+// zstd compresses much better than snappy formats with acceptable cost, which is good for large sync packets
+func NewZstdCompressor() Compressor {
+	zstdOnce.Do(func() {
+		var err error
+		// EncodeAll & DecodeAll can be used concurrently, so all zstd compressors share the same encoder and decoder
+		if zstdEncoder, err = zstd.NewWriter(nil, zstd.WithEncoderLevel(zstd.SpeedFastest), zstd.WithEncoderConcurrency(1)); err != nil {
+			gwlog.Fatalf("create zstd encoder failed: %v", err)
+		}
+		if zstdDecoder, err = zstd.NewReader(nil, zstd.WithDecoderConcurrency(1)); err != nil {
+			gwlog.Fatalf("create zstd decoder failed: %v", err)
+		}
+	})
+	return zstdCompressor{}
+}
+
+type zstdCompressor struct{}
+
+func (zc zstdCompressor) Compress(b []byte, c []byte) ([]byte, error) {
+	return zstdEncoder.EncodeAll(b, c), nil
+}
+
+func (zc zstdCompressor) Decompress(c []byte, b []byte) error {
+	rb, err := zstdDecoder.DecodeAll(c, b[:0])
+	if err != nil {
+		return err
+	}
+	if len(rb) != len(b) {
+		return errors.Errorf("zstd: decompressed size is %d, but should be %d", len(rb), len(b))
+	}
+	if &rb[0] != &b[0] {
+		copy(b, rb)
+	}
+	return nil
+}
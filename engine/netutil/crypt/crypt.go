@@ -0,0 +1,138 @@
+package crypt
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/binary"
+	"io"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"golang.org/x/crypto/chacha20poly1305"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// KeySize is the size of cipher keys
+	KeySize = 32
+	// RekeyInterval is the number of packets sealed (or opened) by one key before the key is rotated
+	RekeyInterval = 1 << 20
+)
+
+var (
+	rekeyInfo = []byte("goworld rekey")
+
+	// ErrReplayedPacket is returned by Open if the packet is sealed before, which is only detected by hmac-sha256
+	ErrReplayedPacket = errors.New("replayed packet")
+	// ErrForgedPacket is returned by Open if the packet is not sealed by the peer, or sealed for another position
+	ErrForgedPacket = errors.New("forged packet")
+)
+
+// Cipher seals and opens packet payloads in one direction of a connection
+//
+// Nonces are implicit sequence numbers, so packets must be opened in the same order as they are sealed.
+// The key is rotated every RekeyInterval packets on both sides without extra messages.
+type Cipher struct {
+	format string
+	key    []byte
+	aead   cipher.AEAD
+	seq    uint64
+	nonce  []byte
+}
+
+// IsFormatSupported returns if the cipher format is supported
+func IsFormatSupported(format string) bool {
+	format = strings.ToLower(format)
+	return format == "aes-gcm" || format == "chacha20-poly1305" || format == "hmac-sha256"
+}
+
+// NewCipher creates a new Cipher in specified format ("aes-gcm", "chacha20-poly1305" or "hmac-sha256") using the key
+//
+// hmac-sha256 only authenticates packets with sequence numbers, which protects packets from being forged or replayed
+// without the cost of encryption.
+func NewCipher(format string, key []byte) (*Cipher, error) {
+	if len(key) != KeySize {
+		return nil, errors.Errorf("invalid key size: %d", len(key))
+	}
+
+	c := &Cipher{
+		format: strings.ToLower(format),
+		key:    append([]byte(nil), key...),
+	}
+	if err := c.setupAEAD(); err != nil {
+		return nil, err
+	}
+	c.nonce = make([]byte, c.aead.NonceSize())
+	return c, nil
+}
+
+func (c *Cipher) setupAEAD() (err error) {
+	if c.format == "aes-gcm" {
+		var block cipher.Block
+		if block, err = aes.NewCipher(c.key); err != nil {
+			return
+		}
+		c.aead, err = cipher.NewGCM(block)
+	} else if c.format == "chacha20-poly1305" {
+		c.aead, err = chacha20poly1305.New(c.key)
+	} else if c.format == "hmac-sha256" {
+		c.aead = newHMACAEAD(c.key)
+	} else {
+		err = errors.Errorf("unknown cipher format: %s", c.format)
+	}
+	return
+}
+
+// Overhead returns the number of bytes added to each sealed payload
+func (c *Cipher) Overhead() int {
+	return c.aead.Overhead()
+}
+
+// Seal encrypts and authenticates plaintext and additional data, appends the result to dst and returns the updated slice
+func (c *Cipher) Seal(dst, plaintext, additionalData []byte) []byte {
+	res := c.aead.Seal(dst, c.nextNonce(), plaintext, additionalData)
+	c.advance()
+	return res
+}
+
+// Open decrypts and authenticates ciphertext and additional data, appends the plaintext to dst and returns the updated slice
+//
+// ErrReplayedPacket or ErrForgedPacket is returned if the packet is not authenticated, and the sequence number is not
+// advanced, so that following packets can still be opened.
+func (c *Cipher) Open(dst, ciphertext, additionalData []byte) ([]byte, error) {
+	res, err := c.aead.Open(dst, c.nextNonce(), ciphertext, additionalData)
+	if err == ErrReplayedPacket {
+		return nil, err
+	} else if err != nil {
+		return nil, ErrForgedPacket
+	}
+	c.advance()
+	return res, nil
+}
+
+func (c *Cipher) nextNonce() []byte {
+	binary.LittleEndian.PutUint64(c.nonce, c.seq)
+	return c.nonce
+}
+
+func (c *Cipher) advance() {
+	c.seq += 1
+	if c.seq >= RekeyInterval {
+		c.rekey()
+	}
+}
+
+// rekey derives the next key from the current key and resets the sequence number
+func (c *Cipher) rekey() {
+	newKey := make([]byte, KeySize)
+	if _, err := io.ReadFull(hkdf.Expand(sha256.New, c.key, rekeyInfo), newKey); err != nil {
+		gwlog.Panic(errors.Wrap(err, "derive new key failed"))
+	}
+	c.key = newKey
+	if err := c.setupAEAD(); err != nil {
+		gwlog.Panic(errors.Wrap(err, "setup cipher failed"))
+	}
+	c.seq = 0
+}
@@ -0,0 +1,168 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"testing"
+)
+
+func TestKeyExchange(t *testing.T) {
+	clientKeyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverKeyPair, err := GenerateKeyPair()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clientSendKey, clientRecvKey, err := clientKeyPair.DeriveKeys(serverKeyPair.PublicKey[:], true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSendKey, serverRecvKey, err := serverKeyPair.DeriveKeys(clientKeyPair.PublicKey[:], false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(clientSendKey, serverRecvKey) || !bytes.Equal(clientRecvKey, serverSendKey) {
+		t.Errorf("derived keys mismatch")
+	}
+	if bytes.Equal(clientSendKey, clientRecvKey) {
+		t.Errorf("keys of both directions should be different")
+	}
+
+	if _, _, err := clientKeyPair.DeriveKeys(make([]byte, PublicKeySize), true); err == nil {
+		t.Errorf("zero public key should be rejected")
+	}
+}
+
+func TestSignKeyExchange(t *testing.T) {
+	rsaKey, _ := rsa.GenerateKey(rand.Reader, 2048)
+	ecdsaKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	_, ed25519Key, _ := ed25519.GenerateKey(rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+
+	clientKeyPair, _ := GenerateKeyPair()
+	gateKeyPair, _ := GenerateKeyPair()
+	otherKeyPair, _ := GenerateKeyPair()
+	clientPub, gatePub := clientKeyPair.PublicKey[:], gateKeyPair.PublicKey[:]
+	for _, signer := range []crypto.Signer{rsaKey, ecdsaKey, ed25519Key} {
+		sig, err := SignKeyExchange(signer, "aes-gcm", clientPub, gatePub)
+		if err != nil {
+			t.Fatalf("%T: sign failed: %v", signer, err)
+		}
+		if err := VerifyKeyExchange(signer.Public(), "aes-gcm", clientPub, gatePub, sig); err != nil {
+			t.Errorf("%T: verify failed: %v", signer, err)
+		}
+		if VerifyKeyExchange(signer.Public(), "hmac-sha256", clientPub, gatePub, sig) == nil {
+			t.Errorf("%T: signature of other cipher format should be rejected", signer)
+		}
+		if VerifyKeyExchange(signer.Public(), "aes-gcm", otherKeyPair.PublicKey[:], gatePub, sig) == nil {
+			t.Errorf("%T: signature for other client should be rejected", signer)
+		}
+		if VerifyKeyExchange(signer.Public(), "aes-gcm", clientPub, otherKeyPair.PublicKey[:], sig) == nil {
+			t.Errorf("%T: substituted gate public key should be rejected", signer)
+		}
+		if VerifyKeyExchange(otherKey.Public(), "aes-gcm", clientPub, gatePub, sig) == nil {
+			t.Errorf("%T: signature should be rejected by other pinned key", signer)
+		}
+		if VerifyKeyExchange(signer.Public(), "aes-gcm", clientPub, gatePub, nil) == nil {
+			t.Errorf("%T: unsigned key exchange should be rejected", signer)
+		}
+	}
+}
+
+func TestLoadPublicKey(t *testing.T) {
+	pub, err := LoadPublicKey("../../../rsa.crt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := pub.(*rsa.PublicKey); !ok {
+		t.Errorf("public key of rsa.crt should be RSA, but is %T", pub)
+	}
+	if _, err := LoadPublicKey("../../../rsa.key"); err == nil {
+		t.Errorf("private key should not be loaded as public key")
+	}
+}
+
+func TestCipher(t *testing.T) {
+	testCipher(t, "aes-gcm")
+	testCipher(t, "chacha20-poly1305")
+	testCipher(t, "hmac-sha256")
+}
+
+func TestCipherReplay(t *testing.T) {
+	for _, format := range []string{"aes-gcm", "chacha20-poly1305", "hmac-sha256"} {
+		key := bytes.Repeat([]byte{1}, KeySize)
+		sealer, _ := NewCipher(format, key)
+		opener, _ := NewCipher(format, key)
+		ad := []byte("header")
+
+		first := sealer.Seal(nil, []byte("first"), ad)
+		second := sealer.Seal(nil, []byte("second"), ad)
+		if _, err := opener.Open(nil, first, ad); err != nil {
+			t.Fatalf("%s: open failed: %v", format, err)
+		}
+		expectedErr := ErrForgedPacket
+		if format == "hmac-sha256" {
+			expectedErr = ErrReplayedPacket
+		}
+		if _, err := opener.Open(nil, first, ad); err != expectedErr {
+			t.Errorf("%s: replayed packet should fail with %v, but got %v", format, expectedErr, err)
+		}
+		forged := append([]byte(nil), second...)
+		forged[len(forged)-1] ^= 1
+		if _, err := opener.Open(nil, forged, ad); err != ErrForgedPacket {
+			t.Errorf("%s: forged packet should fail with %v, but got %v", format, ErrForgedPacket, err)
+		}
+
+		// failed packets are dropped, and the next packet can still be opened in place
+		res, err := opener.Open(second[:0], second, ad)
+		if err != nil || string(res) != "second" {
+			t.Errorf("%s: open in place failed: %q, %v", format, res, err)
+		}
+	}
+}
+
+func testCipher(t *testing.T, format string) {
+	key := bytes.Repeat([]byte{1}, KeySize)
+	sealer, err := NewCipher(format, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opener, _ := NewCipher(format, key)
+
+	ad := []byte("header")
+	for i := 0; i < RekeyInterval+10; i++ {
+		plaintext := []byte("hello goworld")
+		ciphertext := sealer.Seal(nil, plaintext, ad)
+		if i != RekeyInterval-1 && i != RekeyInterval && i%10000 != 0 {
+			// open only some packets in the same order to save time
+			if _, err := opener.Open(nil, ciphertext, ad); err != nil {
+				t.Fatalf("%s: open packet %d failed: %v", format, i, err)
+			}
+			continue
+		}
+
+		if _, err := opener.Open(nil, ciphertext, []byte("tampered")); err == nil {
+			t.Fatalf("%s: tampered additional data should not be opened", format)
+		}
+		res, err := opener.Open(nil, ciphertext, ad)
+		if err != nil {
+			t.Fatalf("%s: open packet %d failed: %v", format, i, err)
+		}
+		if !bytes.Equal(res, plaintext) {
+			t.Fatalf("%s: plaintext mismatch", format)
+		}
+	}
+
+	if _, err := NewCipher("rc4", key); err == nil {
+		t.Errorf("unknown cipher format should fail")
+	}
+}
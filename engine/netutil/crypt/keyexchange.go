@@ -0,0 +1,76 @@
+package crypt
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"io"
+
+	"github.com/pkg/errors"
+	"golang.org/x/crypto/curve25519"
+	"golang.org/x/crypto/hkdf"
+)
+
+const (
+	// PublicKeySize is the size of X25519 public keys
+	PublicKeySize = 32
+)
+
+var (
+	clientToServerInfo = []byte("goworld client to server")
+	serverToClientInfo = []byte("goworld server to client")
+)
+
+// KeyPair is the X25519 key pair used for key exchange
+type KeyPair struct {
+	privateKey [32]byte
+	PublicKey  [PublicKeySize]byte
+}
+
+// GenerateKeyPair generates a random X25519 key pair
+func GenerateKeyPair() (*KeyPair, error) {
+	kp := &KeyPair{}
+	if _, err := io.ReadFull(rand.Reader, kp.privateKey[:]); err != nil {
+		return nil, errors.Wrap(err, "generate private key failed")
+	}
+	curve25519.ScalarBaseMult(&kp.PublicKey, &kp.privateKey)
+	return kp, nil
+}
+
+// DeriveKeys derives the keys for both directions from the key pair and the public key of the remote side
+//
+// sendKey is used to seal packets sent by the local side, and recvKey is used to open packets received from the remote side
+func (kp *KeyPair) DeriveKeys(peerPublicKey []byte, isClient bool) (sendKey, recvKey []byte, err error) {
+	if len(peerPublicKey) != PublicKeySize {
+		return nil, nil, errors.Errorf("invalid public key size: %d", len(peerPublicKey))
+	}
+
+	var peerKey, shared [32]byte
+	copy(peerKey[:], peerPublicKey)
+	curve25519.ScalarMult(&shared, &kp.privateKey, &peerKey)
+	var zero [32]byte
+	if subtle.ConstantTimeCompare(shared[:], zero[:]) == 1 {
+		return nil, nil, errors.Errorf("invalid public key")
+	}
+
+	// both public keys are used as salt, so that keys are bound to this exchange
+	var salt []byte
+	if isClient {
+		salt = append(append(salt, kp.PublicKey[:]...), peerKey[:]...)
+	} else {
+		salt = append(append(salt, peerKey[:]...), kp.PublicKey[:]...)
+	}
+
+	c2sKey, s2cKey := make([]byte, KeySize), make([]byte, KeySize)
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared[:], salt, clientToServerInfo), c2sKey); err != nil {
+		return nil, nil, err
+	}
+	if _, err = io.ReadFull(hkdf.New(sha256.New, shared[:], salt, serverToClientInfo), s2cKey); err != nil {
+		return nil, nil, err
+	}
+
+	if isClient {
+		return c2sKey, s2cKey, nil
+	}
+	return s2cKey, c2sKey, nil
+}
@@ -0,0 +1,97 @@
+package crypt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+
+	"github.com/pkg/errors"
+)
+
+var keyExchangeSignContext = []byte("goworld key exchange")
+
+// keyExchangeDigest returns the digest signed by gate, which binds the gate's public key to the client's public key and
+// the negotiated cipher format, so that the signature can not be replayed to other clients or downgraded formats
+func keyExchangeDigest(cipherFormat string, clientPublicKey, gatePublicKey []byte) []byte {
+	h := sha256.New()
+	h.Write(keyExchangeSignContext)
+	h.Write([]byte{byte(len(cipherFormat))})
+	h.Write([]byte(cipherFormat))
+	h.Write(clientPublicKey)
+	h.Write(gatePublicKey)
+	return h.Sum(nil)
+}
+
+// SignKeyExchange signs the gate's public key of the key exchange using the private key of gate certificate
+func SignKeyExchange(signer crypto.Signer, cipherFormat string, clientPublicKey, gatePublicKey []byte) ([]byte, error) {
+	digest := keyExchangeDigest(cipherFormat, clientPublicKey, gatePublicKey)
+	if _, ok := signer.Public().(ed25519.PublicKey); ok {
+		// ed25519 signs the message itself
+		return signer.Sign(rand.Reader, digest, crypto.Hash(0))
+	}
+	return signer.Sign(rand.Reader, digest, crypto.SHA256)
+}
+
+// VerifyKeyExchange verifies the signature of the gate's public key using the pinned public key of gate certificate
+func VerifyKeyExchange(pinned crypto.PublicKey, cipherFormat string, clientPublicKey, gatePublicKey, signature []byte) error {
+	if len(signature) == 0 {
+		return errors.Errorf("key exchange is not signed")
+	}
+
+	digest := keyExchangeDigest(cipherFormat, clientPublicKey, gatePublicKey)
+	switch pub := pinned.(type) {
+	case *rsa.PublicKey:
+		if err := rsa.VerifyPKCS1v15(pub, crypto.SHA256, digest, signature); err != nil {
+			return errors.Wrap(err, "invalid key exchange signature")
+		}
+	case *ecdsa.PublicKey:
+		var sig struct{ R, S *big.Int }
+		if _, err := asn1.Unmarshal(signature, &sig); err != nil || !ecdsa.Verify(pub, digest, sig.R, sig.S) {
+			return errors.Errorf("invalid key exchange signature")
+		}
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, signature) {
+			return errors.Errorf("invalid key exchange signature")
+		}
+	default:
+		return errors.Errorf("unsupported public key type: %T", pinned)
+	}
+	return nil
+}
+
+// LoadPublicKey loads the public key from the PEM file of gate certificate or public key, for clients to pin the gate
+func LoadPublicKey(file string) (crypto.PublicKey, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.Errorf("%s: no PEM data found", file)
+	}
+	switch block.Type {
+	case "CERTIFICATE":
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "%s: parse certificate failed", file)
+		}
+		return cert.PublicKey, nil
+	case "PUBLIC KEY":
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		return pub, errors.Wrapf(err, "%s: parse public key failed", file)
+	case "RSA PUBLIC KEY":
+		pub, err := x509.ParsePKCS1PublicKey(block.Bytes)
+		return pub, errors.Wrapf(err, "%s: parse public key failed", file)
+	default:
+		return nil, errors.Errorf("%s: unsupported PEM type %s", file, block.Type)
+	}
+}
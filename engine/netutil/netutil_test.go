@@ -1,6 +1,7 @@
 package netutil
 
 import (
+	"bytes"
 	"net"
 	"testing"
 	"time"
@@ -11,6 +12,8 @@ import (
 
 	"github.com/xiaonanln/goworld/engine/gwioutil"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil/compress"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
 )
 
 type testEchoTcpServer struct {
@@ -125,3 +128,202 @@ func TestPacketConnection(t *testing.T) {
 	}
 
 }
+
+func TestCompressedPacketConnection(t *testing.T) {
+	_conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", PORT))
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+
+	conn := NewPacketConnection(NetConnection{_conn}, nil)
+	conn.SetCompressor(compress.NewZstdCompressor(), 64)
+	conn.SetDecompressor(compress.NewZstdCompressor())
+
+	for _, payloadLen := range []uint32{10, 100, 10000} {
+		packet := conn.NewPacket()
+		for j := uint32(0); j < payloadLen; j++ {
+			packet.AppendByte(byte('a' + rand.Intn(3)))
+		}
+		conn.SendPacket(packet)
+		conn.Flush("Test")
+		if packet.isCompressed() || packet.GetPayloadLen() != payloadLen {
+			t.Errorf("sent packet should not be modified by compression")
+		}
+
+		var recvPacket *Packet
+		for recvPacket == nil {
+			if recvPacket, err = conn.RecvPacket(); err != nil && err != errRecvAgain {
+				t.Fatal(err)
+			}
+		}
+		if string(packet.Payload()) != string(recvPacket.Payload()) {
+			t.Errorf("send packet and recv packet mismatch: payload len %d", payloadLen)
+		}
+		packet.Release()
+		recvPacket.Release()
+	}
+}
+
+func TestEncryptedPacketConnection(t *testing.T) {
+	_conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", PORT))
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+
+	key := make([]byte, crypt.KeySize)
+	rand.Read(key)
+	// the echo server sends back the same bytes, so the same key is used in both directions
+	encryptor, _ := crypt.NewCipher("chacha20-poly1305", key)
+	decryptor, _ := crypt.NewCipher("chacha20-poly1305", key)
+	conn := NewPacketConnection(NetConnection{_conn}, nil)
+	conn.SetCompressor(compress.NewZstdCompressor(), 64)
+	conn.SetDecompressor(compress.NewZstdCompressor())
+	conn.SetEncryptor(encryptor)
+	conn.SetDecryptor(decryptor)
+
+	for _, payloadLen := range []uint32{10, 100, 10000} {
+		packet := conn.NewPacket()
+		for j := uint32(0); j < payloadLen; j++ {
+			packet.AppendByte(byte('a' + rand.Intn(3)))
+		}
+		conn.SendPacket(packet)
+		conn.Flush("Test")
+		if packet.isEncrypted() || packet.GetPayloadLen() != payloadLen {
+			t.Errorf("sent packet should not be modified by encryption")
+		}
+
+		var recvPacket *Packet
+		for recvPacket == nil {
+			if recvPacket, err = conn.RecvPacket(); err != nil && err != errRecvAgain {
+				t.Fatal(err)
+			}
+		}
+		if string(packet.Payload()) != string(recvPacket.Payload()) {
+			t.Errorf("send packet and recv packet mismatch: payload len %d", payloadLen)
+		}
+		packet.Release()
+		recvPacket.Release()
+	}
+}
+
+// recordingConnection records bytes written to the connection
+type recordingConnection struct {
+	NetConnection
+	written bytes.Buffer
+}
+
+func (c *recordingConnection) Write(b []byte) (int, error) {
+	c.written.Write(b)
+	return c.NetConnection.Write(b)
+}
+
+func TestPacketIntegrity(t *testing.T) {
+	_conn, err := net.Dial("tcp", fmt.Sprintf("localhost:%d", PORT))
+	if err != nil {
+		t.Fatalf("connect error: %s", err)
+	}
+
+	key := make([]byte, crypt.KeySize)
+	rand.Read(key)
+	encryptor, _ := crypt.NewCipher("hmac-sha256", key)
+	decryptor, _ := crypt.NewCipher("hmac-sha256", key)
+	rc := &recordingConnection{NetConnection: NetConnection{_conn}}
+	conn := NewPacketConnection(rc, nil)
+	conn.SetEncryptor(encryptor)
+	conn.SetDecryptor(decryptor)
+	defer conn.Close()
+
+	recv := func() (*Packet, error) {
+		for {
+			packet, err := conn.RecvPacket()
+			if err != errRecvAgain {
+				return packet, err
+			}
+		}
+	}
+	send := func(payload string) {
+		packet := conn.NewPacket()
+		packet.AppendBytes([]byte(payload))
+		conn.SendPacket(packet)
+		conn.Flush("Test")
+		packet.Release()
+	}
+
+	send("first")
+	if packet, err := recv(); err != nil || string(packet.Payload()) != "first" {
+		t.Fatalf("recv failed: %v", err)
+	}
+
+	// replay the sealed packet, which is echoed back
+	gwioutil.WriteAll(_conn, rc.written.Bytes())
+	if _, err := recv(); err == nil || err.(*IntegrityError).Reason != "replayed" {
+		t.Fatalf("replayed packet should be rejected, but got %v", err)
+	}
+
+	// unencrypted packets are rejected after decryption is set
+	conn.SetEncryptor(nil)
+	send("unencrypted")
+	if _, err := recv(); err == nil || err.(*IntegrityError).Reason != "unencrypted" {
+		t.Fatalf("unencrypted packet should be rejected, but got %v", err)
+	}
+
+	// the connection is still usable after violations
+	encryptor, _ = crypt.NewCipher("hmac-sha256", key)
+	encryptor.Seal(nil, nil, nil) // the sequence number of the first packet is used
+	conn.SetEncryptor(encryptor)
+	send("second")
+	if packet, err := recv(); err != nil || string(packet.Payload()) != "second" {
+		t.Fatalf("recv failed after violations: %v", err)
+	}
+}
+
+func makeProxyProtocolV2Header(verCmd byte, family byte, addrs []byte) []byte {
+	header := append([]byte{}, proxyProtocolV2Signature...)
+	header = append(header, verCmd, family, byte(len(addrs)>>8), byte(len(addrs)))
+	return append(header, addrs...)
+}
+
+func TestProxyProtocolV2(t *testing.T) {
+	// TCP over IPv4: 192.168.1.2:12345 -> 10.0.0.1:14000, followed by a TLV which should be skipped
+	addrs := []byte{192, 168, 1, 2, 10, 0, 0, 1, 0x30, 0x39, 0x36, 0xb0, 0x04, 0x00, 0x01, 0xff}
+	r := bytes.NewReader(append(makeProxyProtocolV2Header(0x21, 0x11, addrs), 'x'))
+	addr, err := readProxyProtocolV2(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "192.168.1.2:12345" {
+		t.Fatalf("wrong source address: %s", addr)
+	}
+	if b, _ := r.ReadByte(); b != 'x' {
+		t.Fatalf("data after header is consumed")
+	}
+
+	// TCP over IPv6
+	addrs = make([]byte, 36)
+	copy(addrs, net.ParseIP("2001:db8::1"))
+	addrs[32], addrs[33] = 0x01, 0xbb
+	addr, err = readProxyProtocolV2(bytes.NewReader(makeProxyProtocolV2Header(0x21, 0x21, addrs)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if addr.String() != "[2001:db8::1]:443" {
+		t.Fatalf("wrong source address: %s", addr)
+	}
+
+	// LOCAL command has no source address
+	addr, err = readProxyProtocolV2(bytes.NewReader(makeProxyProtocolV2Header(0x20, 0x00, nil)))
+	if err != nil || addr != nil {
+		t.Fatalf("LOCAL command should return nil address, but returns %v, %v", addr, err)
+	}
+
+	// invalid headers
+	if _, err = readProxyProtocolV2(bytes.NewReader([]byte("PROXY TCP4 192.168.1.2 10.0.0.1 12345 14000\r\n"))); err == nil {
+		t.Fatalf("PROXY protocol v1 header should be rejected")
+	}
+	if _, err = readProxyProtocolV2(bytes.NewReader(makeProxyProtocolV2Header(0x11, 0x11, addrs[:12]))); err == nil {
+		t.Fatalf("wrong version should be rejected")
+	}
+	if _, err = readProxyProtocolV2(bytes.NewReader(makeProxyProtocolV2Header(0x21, 0x21, addrs[:12]))); err == nil {
+		t.Fatalf("short IPv6 addresses should be rejected")
+	}
+}
@@ -0,0 +1,165 @@
+package opmon
+
+import (
+	"bytes"
+	"runtime"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	maxDeadlineCheckInterval = time.Millisecond * 100
+	maxStackDumpSize         = 1 << 20
+)
+
+var (
+	handlerDeadline   int64 // in nanoseconds, 0 to disable monitoring running handlers
+	deadlineCheckerOn int32
+	mainRoutineID     int64 // ID of the goroutine running handlers, whose stack is logged when handlers exceed the deadline
+
+	runningLock    sync.Mutex
+	runningDepth   int // handlers might be nested, e.g. entity methods called in posted functions
+	runningHandler struct {
+		kind      string
+		name      string
+		startTime time.Time
+		reported  bool
+	}
+)
+
+// SetHandlerDeadline sets the execution time cap of handlers, 0 to disable
+//
+// Handlers running longer than the deadline are logged with the stack of the main routine while they are still
+// running, so that runaway handlers (e.g. infinite loops in game code) are identified. Exceeded handlers are counted in
+// handler stats.
+func SetHandlerDeadline(deadline time.Duration) {
+	atomic.StoreInt64(&handlerDeadline, int64(deadline))
+	if deadline > 0 && atomic.CompareAndSwapInt32(&deadlineCheckerOn, 0, 1) {
+		go deadlineCheckRoutine()
+	}
+}
+
+// GetHandlerDeadline returns the execution time cap of handlers
+func GetHandlerDeadline() time.Duration {
+	return time.Duration(atomic.LoadInt64(&handlerDeadline))
+}
+
+// SetMainRoutine sets the current goroutine as the main routine running handlers, it should be called by the main
+// routine
+func SetMainRoutine() {
+	atomic.StoreInt64(&mainRoutineID, getGoroutineID())
+}
+
+// IsMainRoutine returns if the current goroutine is the main routine
+func IsMainRoutine() bool {
+	id := atomic.LoadInt64(&mainRoutineID)
+	return id != 0 && id == getGoroutineID()
+}
+
+// IsOffMainRoutine returns if the current goroutine is not the main routine
+//
+// Before the main routine is set (e.g. when entity types are registered in main, or in tests), no goroutine is
+// considered to be off the main routine.
+func IsOffMainRoutine() bool {
+	id := atomic.LoadInt64(&mainRoutineID)
+	return id != 0 && id != getGoroutineID()
+}
+
+func getGoroutineID() int64 {
+	var buf [64]byte
+	stack := buf[:runtime.Stack(buf[:], false)]
+	// the stack starts with "goroutine <ID> [running]:"
+	stack = bytes.TrimPrefix(stack, []byte("goroutine "))
+	if i := bytes.IndexByte(stack, ' '); i > 0 {
+		id, _ := strconv.ParseInt(string(stack[:i]), 10, 64)
+		return id
+	}
+	return 0
+}
+
+func enterHandler(op *Operation) {
+	runningLock.Lock()
+	if runningDepth == 0 {
+		runningHandler.kind, runningHandler.name = op.kind, op.name
+		runningHandler.startTime = op.startTime
+		runningHandler.reported = false
+	}
+	runningDepth += 1
+	runningLock.Unlock()
+}
+
+func leaveHandler() {
+	runningLock.Lock()
+	if runningDepth > 0 {
+		runningDepth -= 1
+	}
+	runningLock.Unlock()
+}
+
+func deadlineCheckRoutine() {
+	for {
+		deadline := GetHandlerDeadline()
+		interval := deadline / 4
+		if interval <= 0 || interval > maxDeadlineCheckInterval {
+			interval = maxDeadlineCheckInterval
+		}
+		time.Sleep(interval)
+		if deadline > 0 {
+			checkHandlerDeadline(deadline, time.Now())
+		}
+	}
+}
+
+// checkHandlerDeadline logs the running handler and the stack of main routine if the handler exceeds the deadline
+func checkHandlerDeadline(deadline time.Duration, now time.Time) {
+	runningLock.Lock()
+	if runningDepth == 0 || runningHandler.reported || now.Sub(runningHandler.startTime) < deadline {
+		runningLock.Unlock()
+		return
+	}
+	runningHandler.reported = true
+	key := handlerKey{runningHandler.kind, runningHandler.name}
+	elapsed := now.Sub(runningHandler.startTime)
+	runningLock.Unlock()
+
+	handlerStatsLock.Lock()
+	info := handlerStats[key]
+	if info == nil {
+		info = &_OpInfo{}
+		handlerStats[key] = info
+	}
+	info.deadlineExceeded += 1
+	handlerStatsLock.Unlock()
+
+	gwlog.Errorf("opmon: %s handler %s exceeds deadline %s, running for %s:\n%s", key.kind, key.name, deadline, elapsed, getMainRoutineStack())
+}
+
+// getMainRoutineStack returns the stack of the main routine
+func getMainRoutineStack() []byte {
+	id := atomic.LoadInt64(&mainRoutineID)
+	if id == 0 {
+		return []byte("stack not available: main routine is not set")
+	}
+
+	buf := make([]byte, 64*1024)
+	for {
+		n := runtime.Stack(buf, true)
+		if n < len(buf) || len(buf) >= maxStackDumpSize {
+			buf = buf[:n]
+			break
+		}
+		buf = make([]byte, len(buf)*2)
+	}
+
+	prefix := []byte("goroutine " + strconv.FormatInt(id, 10) + " ")
+	for _, stack := range bytes.Split(buf, []byte("\n\n")) {
+		if bytes.HasPrefix(stack, prefix) {
+			return stack
+		}
+	}
+	return []byte("stack not available: main routine is not found")
+}
@@ -0,0 +1,111 @@
+package opmon
+
+import (
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Kinds of handlers running in the main routine
+const (
+	HandlerEntityRPC   = "rpc"          // entity methods called by RPC
+	HandlerEntityTimer = "timer"        // entity methods called by timers
+	HandlerPost        = "post"         // functions posted by post.Post
+	HandlerTimer       = "global_timer" // callbacks of global timers added by gwtimer
+)
+
+var (
+	handlerBudget    int64 // in nanoseconds, 0 to disable logging slow handlers
+	handlerStatsLock sync.Mutex
+	handlerStats     = map[handlerKey]*_OpInfo{} // never cleared, so that stats can be exported as counters
+)
+
+type handlerKey struct {
+	kind string
+	name string
+}
+
+// HandlerStats are the aggregate timing stats of handler
+type HandlerStats struct {
+	Kind             string
+	Name             string
+	Count            uint64
+	TotalDuration    time.Duration
+	MaxDuration      time.Duration
+	DeadlineExceeded uint64 // number of calls exceeding the handler deadline
+}
+
+// SetHandlerBudget sets the timing budget of handlers, handlers exceeding the budget are logged, 0 to disable logging
+func SetHandlerBudget(budget time.Duration) {
+	atomic.StoreInt64(&handlerBudget, int64(budget))
+}
+
+// GetHandlerBudget returns the timing budget of handlers
+func GetHandlerBudget() time.Duration {
+	return time.Duration(atomic.LoadInt64(&handlerBudget))
+}
+
+// StartHandler creates a new operation of handler, e.g. entity method Avatar.EnterSpace called by RPC
+func StartHandler(kind string, name string) *Operation {
+	op := StartOperation(name)
+	op.kind = kind
+	if atomic.LoadInt32(&deadlineCheckerOn) != 0 {
+		enterHandler(op)
+	}
+	return op
+}
+
+// FinishHandler finishes the handler, records the duration and logs the handler if it exceeds the handler budget
+func (op *Operation) FinishHandler() {
+	takeTime := time.Now().Sub(op.startTime)
+	key := handlerKey{op.kind, op.name}
+	if atomic.LoadInt32(&deadlineCheckerOn) != 0 {
+		leaveHandler()
+	}
+
+	handlerStatsLock.Lock()
+	info := handlerStats[key]
+	if info == nil {
+		info = &_OpInfo{}
+		handlerStats[key] = info
+	}
+	info.count += 1
+	info.totalDuration += takeTime
+	if takeTime > info.maxDuration {
+		info.maxDuration = takeTime
+	}
+	handlerStatsLock.Unlock()
+
+	if budget := GetHandlerBudget(); budget > 0 && takeTime >= budget {
+		gwlog.Warnf("opmon: slow %s handler %s takes %s > %s", op.kind, op.name, takeTime, budget)
+	}
+	operationAllocPool.Put(op)
+}
+
+// GetHandlerStats returns the aggregate timing stats of all handlers sorted by kind and name
+func GetHandlerStats() []HandlerStats {
+	handlerStatsLock.Lock()
+	stats := make([]HandlerStats, 0, len(handlerStats))
+	for key, info := range handlerStats {
+		stats = append(stats, HandlerStats{
+			Kind:             key.kind,
+			Name:             key.name,
+			Count:            info.count,
+			TotalDuration:    info.totalDuration,
+			MaxDuration:      info.maxDuration,
+			DeadlineExceeded: info.deadlineExceeded,
+		})
+	}
+	handlerStatsLock.Unlock()
+
+	sort.Slice(stats, func(i, j int) bool {
+		if stats[i].Kind != stats[j].Kind {
+			return stats[i].Kind < stats[j].Kind
+		}
+		return stats[i].Name < stats[j].Name
+	})
+	return stats
+}
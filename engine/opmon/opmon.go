@@ -35,9 +35,10 @@ func init() {
 }
 
 type _OpInfo struct {
-	count         uint64
-	totalDuration time.Duration
-	maxDuration   time.Duration
+	count            uint64
+	totalDuration    time.Duration
+	maxDuration      time.Duration
+	deadlineExceeded uint64 // number of handlers exceeding the handler deadline
 }
 
 type _Monitor struct {
@@ -96,6 +97,7 @@ func (monitor *_Monitor) Dump() {
 
 // Operation is the type of operation to be monitored
 type Operation struct {
+	kind      string // kind of handler, empty for other operations
 	name      string
 	startTime time.Time
 }
@@ -103,6 +105,7 @@ type Operation struct {
 // StartOperation creates a new operation
 func StartOperation(operationName string) *Operation {
 	op := operationAllocPool.Get().(*Operation)
+	op.kind = ""
 	op.name = operationName
 	op.startTime = time.Now()
 	return op
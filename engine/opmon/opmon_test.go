@@ -1,6 +1,8 @@
 package opmon
 
 import (
+	"strings"
+	"sync/atomic"
 	"testing"
 	"time"
 )
@@ -10,3 +12,75 @@ func TestOpMon(t *testing.T) {
 	op.Finish(time.Millisecond)
 	monitor.Dump()
 }
+
+func TestHandlerStats(t *testing.T) {
+	SetHandlerBudget(time.Millisecond)
+	defer SetHandlerBudget(0)
+
+	for i := 0; i < 2; i++ {
+		op := StartHandler(HandlerEntityRPC, "Avatar.Test")
+		time.Sleep(time.Millisecond * time.Duration(i*2))
+		op.FinishHandler()
+	}
+
+	for _, stats := range GetHandlerStats() {
+		if stats.Kind == HandlerEntityRPC && stats.Name == "Avatar.Test" {
+			if stats.Count != 2 {
+				t.Errorf("handler count should be 2, but is %d", stats.Count)
+			}
+			if stats.MaxDuration < time.Millisecond*2 || stats.TotalDuration < stats.MaxDuration {
+				t.Errorf("wrong handler durations: total %s, max %s", stats.TotalDuration, stats.MaxDuration)
+			}
+			return
+		}
+	}
+	t.Errorf("stats of handler Avatar.Test not found")
+}
+
+func TestHandlerDeadline(t *testing.T) {
+	SetMainRoutine()
+	if stack := string(getMainRoutineStack()); !strings.Contains(stack, "TestHandlerDeadline") {
+		t.Fatalf("stack of main routine should be found, but is %s", stack)
+	}
+
+	SetHandlerDeadline(time.Millisecond * 5)
+	defer SetHandlerDeadline(0)
+
+	op := StartHandler(HandlerPost, "TestHandlerDeadline.runaway")
+	inner := StartHandler(HandlerEntityRPC, "TestHandlerDeadline.inner")
+	time.Sleep(time.Millisecond * 30)
+	inner.FinishHandler()
+	op.FinishHandler()
+
+	op = StartHandler(HandlerPost, "TestHandlerDeadline.fast")
+	op.FinishHandler()
+	time.Sleep(time.Millisecond * 30)
+
+	exceeded := map[string]uint64{}
+	for _, stats := range GetHandlerStats() {
+		exceeded[stats.Name] = stats.DeadlineExceeded
+	}
+	if exceeded["TestHandlerDeadline.runaway"] != 1 || exceeded["TestHandlerDeadline.inner"] != 0 || exceeded["TestHandlerDeadline.fast"] != 0 {
+		t.Errorf("only the outermost runaway handler should exceed the deadline once: %v", exceeded)
+	}
+}
+
+func TestIsMainRoutine(t *testing.T) {
+	atomic.StoreInt64(&mainRoutineID, 0)
+	if IsMainRoutine() || IsOffMainRoutine() {
+		t.Fatalf("goroutines should be neither on nor off the main routine before it is set")
+	}
+
+	SetMainRoutine()
+	defer atomic.StoreInt64(&mainRoutineID, 0)
+	if !IsMainRoutine() || IsOffMainRoutine() {
+		t.Fatalf("the goroutine calling SetMainRoutine should be the main routine")
+	}
+	other := make(chan bool)
+	go func() {
+		other <- IsMainRoutine() || !IsOffMainRoutine()
+	}()
+	if <-other {
+		t.Fatalf("other goroutines should be off the main routine")
+	}
+}
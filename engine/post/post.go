@@ -1,18 +1,31 @@
 package post
 
 import (
+	"context"
+	"reflect"
+	"runtime"
 	"sync"
+	"time"
 
 	//"github.com/xiaonanln/goworld/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/opmon"
 )
 
 // PostCallback is the type of functions to be posted
 type PostCallback func()
 
+type postedCallback struct {
+	f        PostCallback
+	postTime time.Time
+}
+
 var (
-	callbacks []PostCallback
+	callbacks []postedCallback
 	lock      sync.Mutex
+
+	budget     time.Duration // max duration of running callbacks in each frame, 0 for no limit
+	frameSpent time.Duration // duration of running callbacks in the current frame
 )
 
 // Post a callback which will be executed when other things are done in the main game routine
@@ -20,12 +33,67 @@ var (
 // Post might be called from other goroutine, so we use a lock to protect the data
 func Post(f PostCallback) {
 	lock.Lock()
-	callbacks = append(callbacks, f)
+	callbacks = append(callbacks, postedCallback{f, time.Now()})
 	lock.Unlock()
 }
 
-// Tick is called by the main game routine to run all posted functions
+// PostContext posts a callback which is dropped if ctx is canceled before the callback is executed
+//
+// Callbacks of engine operations with contexts are posted by PostContext, so that callbacks of operations started by
+// entities are never called after the entities are destroyed, see Entity.Context.
+func PostContext(ctx context.Context, f PostCallback) {
+	Post(func() {
+		if !IsCanceled(ctx) {
+			f()
+		}
+	})
+}
+
+// IsCanceled returns if ctx is canceled, deadline exceeded contexts are not canceled
+func IsCanceled(ctx context.Context) bool {
+	return ctx != nil && ctx.Err() == context.Canceled
+}
+
+// SetBudget sets the max duration of running posted functions in each frame, 0 for no limit
+//
+// Posted functions exceeding the budget spill over to following frames in the posted order.
+func SetBudget(d time.Duration) {
+	budget = d
+}
+
+// StartFrame is called by the main game routine at the start of each frame to reset the budget
+func StartFrame() {
+	frameSpent = 0
+}
+
+// Tick is called by the main game routine to run posted functions within the budget of current frame
+//
+// At least one posted function is run in each frame, so that posted functions are never starved.
 func Tick() {
+	if budget <= 0 {
+		Flush()
+		return
+	}
+
+	for frameSpent < budget {
+		lock.Lock()
+		if len(callbacks) == 0 {
+			lock.Unlock()
+			break
+		}
+		c := callbacks[0]
+		callbacks[0] = postedCallback{}
+		callbacks = callbacks[1:]
+		lock.Unlock()
+
+		startTime := time.Now()
+		run(c.f)
+		frameSpent += time.Since(startTime)
+	}
+}
+
+// Flush runs all posted functions regardless of the budget
+func Flush() {
 	for { // loop until there is no callbacks posted anymore
 		lock.Lock() // lock to check number of callbacks
 		if len(callbacks) == 0 {
@@ -34,11 +102,43 @@ func Tick() {
 		}
 		// switch callbacks in locked section
 		callbacksCopy := callbacks
-		callbacks = make([]PostCallback, 0, len(callbacks))
+		callbacks = make([]postedCallback, 0, len(callbacks))
 		lock.Unlock()
 
-		for _, f := range callbacksCopy {
-			gwutils.RunPanicless(f)
+		for _, c := range callbacksCopy {
+			run(c.f)
 		}
 	}
 }
+
+func run(f PostCallback) {
+	op := opmon.StartHandler(opmon.HandlerPost, getCallbackName(f))
+	gwutils.RunPanicless(f)
+	op.FinishHandler()
+}
+
+// GetQueueLen returns the number of posted functions waiting to run
+func GetQueueLen() int {
+	lock.Lock()
+	n := len(callbacks)
+	lock.Unlock()
+	return n
+}
+
+// GetQueueAge returns how long the oldest posted function has been waiting, 0 if no function is waiting
+func GetQueueAge() time.Duration {
+	lock.Lock()
+	defer lock.Unlock()
+	if len(callbacks) == 0 {
+		return 0
+	}
+	return time.Since(callbacks[0].postTime)
+}
+
+// getCallbackName returns the function name of the callback, e.g. github.com/xiaonanln/goworld/engine/entity.Call.func1
+func getCallbackName(f PostCallback) string {
+	if fn := runtime.FuncForPC(reflect.ValueOf(f).Pointer()); fn != nil {
+		return fn.Name()
+	}
+	return "unknown"
+}
@@ -1,6 +1,10 @@
 package post
 
-import "testing"
+import (
+	"context"
+	"testing"
+	"time"
+)
 
 func TestPost(t *testing.T) {
 	var a int
@@ -12,3 +16,60 @@ func TestPost(t *testing.T) {
 		t.Errorf("t should be 1")
 	}
 }
+
+func TestPostContext(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	deadlineCtx, deadlineCancel := context.WithDeadline(context.Background(), time.Now())
+	defer deadlineCancel()
+
+	var called, deadlineCalled bool
+	PostContext(ctx, func() {
+		called = true
+	})
+	PostContext(deadlineCtx, func() {
+		deadlineCalled = true
+	})
+	cancel()
+	Tick()
+	if called || !deadlineCalled {
+		t.Errorf("only callbacks of canceled contexts should be dropped")
+	}
+}
+
+func TestPostBudget(t *testing.T) {
+	SetBudget(time.Millisecond * 10)
+	defer SetBudget(0)
+
+	var order []int
+	for i := 0; i < 5; i++ {
+		i := i
+		Post(func() {
+			order = append(order, i)
+			time.Sleep(time.Millisecond * 4)
+		})
+	}
+
+	StartFrame()
+	Tick()
+	if len(order) != 3 || GetQueueLen() != 2 || GetQueueAge() <= 0 {
+		t.Fatalf("posts exceeding the budget should spill over: ran %v, queue length %d", order, GetQueueLen())
+	}
+	Tick()
+	if len(order) != 3 {
+		t.Fatalf("no more posts should run in the frame after the budget is spent: ran %v", order)
+	}
+
+	Post(func() {
+		order = append(order, 5)
+	})
+	StartFrame()
+	Tick()
+	for i, n := range order {
+		if n != i {
+			t.Fatalf("posts should run in order: %v", order)
+		}
+	}
+	if len(order) != 6 || GetQueueLen() != 0 || GetQueueAge() != 0 {
+		t.Fatalf("all posts should run in the next frame: ran %v, queue length %d", order, GetQueueLen())
+	}
+}
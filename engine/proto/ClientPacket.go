@@ -0,0 +1,71 @@
+package proto
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// ErrMalformedClientPacket is returned by CheckClientPacket if the packet sent by the client is malformed
+var ErrMalformedClientPacket = errors.New("malformed client packet")
+
+// CheckClientPacket checks that the payload (after the message type) of the packet sent by the client is well formed
+//
+// Gates check packets received from clients before handling or forwarding them, so that a malformed packet only
+// closes the client instead of crashing the gate or the game. The payload is not modified, and RPC arguments are
+// checked by netutil.CheckMessagePack. Unknown message types and trailing bytes are rejected, since gates append the
+// client ID to forwarded packets.
+func CheckClientPacket(msgtype MsgType, payload []byte) (err error) {
+	pkt := netutil.NewPacket()
+	defer pkt.Release()
+	pkt.AppendBytes(payload)
+
+	defer func() {
+		if r := recover(); r != nil {
+			e, ok := r.(error)
+			if !ok || errors.Cause(e) != netutil.ErrMalformedPacket {
+				panic(r)
+			}
+			err = errors.Wrapf(ErrMalformedClientPacket, "message type %d: %s", msgtype, e)
+		}
+	}()
+
+	switch msgtype {
+	case MT_CALL_ENTITY_METHOD_FROM_CLIENT:
+		_ = pkt.ReadEntityID()
+		_ = pkt.ReadVarStr() // method
+		for i, arg := range pkt.ReadArgs() {
+			if err := netutil.CheckMessagePack(arg, netutil.MaxMessagePackDepth); err != nil {
+				return errors.Wrapf(ErrMalformedClientPacket, "message type %d: argument %d: %s", msgtype, i, err)
+			}
+		}
+	case MT_SYNC_POSITION_YAW_FROM_CLIENT:
+		_ = pkt.ReadEntityID()
+		_ = pkt.ReadBytes(SYNC_INFO_SIZE_PER_ENTITY)
+	case MT_HEARTBEAT_FROM_CLIENT:
+	case MT_NEGOTIATE_COMPRESSION_FROM_CLIENT:
+		_ = pkt.ReadStringList() // compress formats
+	case MT_RESUME_SESSION_FROM_CLIENT:
+		_ = pkt.ReadEntityID() // owner entity
+		_ = pkt.ReadVarStr()   // session token
+	case MT_KEY_EXCHANGE_FROM_CLIENT:
+		_ = pkt.ReadStringList() // cipher formats
+		_ = pkt.ReadVarBytes()   // public key
+	case MT_PONG_FROM_CLIENT:
+		_ = pkt.ReadUint64() // ping sequence number
+	case MT_AUTH_FROM_CLIENT:
+		_ = pkt.ReadVarStr() // token
+	case MT_PROTOCOL_VERSION_FROM_CLIENT:
+		_ = pkt.ReadUint16()
+	case MT_DEVICE_ID_FROM_CLIENT:
+		_ = pkt.ReadVarStr() // device ID
+	case MT_WORLD_FROM_CLIENT:
+		_ = pkt.ReadVarStr() // world
+	default:
+		return errors.Wrapf(ErrMalformedClientPacket, "unknown message type %d", msgtype)
+	}
+
+	if pkt.HasUnreadPayload() {
+		return errors.Wrapf(ErrMalformedClientPacket, "message type %d: %d trailing bytes", msgtype, len(pkt.UnreadPayload()))
+	}
+	return nil
+}
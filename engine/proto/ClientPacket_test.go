@@ -0,0 +1,99 @@
+package proto
+
+import (
+	"bytes"
+	"math/rand"
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+var testEntityID = common.EntityID("0123456789abcdef")
+
+// testClientPackets returns well formed packets of all message types sent by clients
+func testClientPackets() map[MsgType][]byte {
+	packets := map[MsgType][]byte{}
+	add := func(msgtype MsgType, write func(p *netutil.Packet)) {
+		p := netutil.NewPacket()
+		write(p)
+		packets[msgtype] = append([]byte{}, p.Payload()...)
+		p.Release()
+	}
+	add(MT_CALL_ENTITY_METHOD_FROM_CLIENT, func(p *netutil.Packet) {
+		p.AppendEntityID(testEntityID)
+		p.AppendVarStr("Method")
+		p.AppendArgs([]interface{}{1, "a", []interface{}{1.5, nil}, map[string]interface{}{"b": true}})
+	})
+	add(MT_SYNC_POSITION_YAW_FROM_CLIENT, func(p *netutil.Packet) {
+		p.AppendEntityID(testEntityID)
+		p.AppendBytes(make([]byte, SYNC_INFO_SIZE_PER_ENTITY))
+	})
+	add(MT_HEARTBEAT_FROM_CLIENT, func(p *netutil.Packet) {})
+	add(MT_NEGOTIATE_COMPRESSION_FROM_CLIENT, func(p *netutil.Packet) { p.AppendStringList([]string{"gwsnappy", "lz4"}) })
+	add(MT_RESUME_SESSION_FROM_CLIENT, func(p *netutil.Packet) {
+		p.AppendEntityID(testEntityID)
+		p.AppendVarStr("token")
+	})
+	add(MT_KEY_EXCHANGE_FROM_CLIENT, func(p *netutil.Packet) {
+		p.AppendStringList([]string{"aes"})
+		p.AppendVarBytes(make([]byte, 32))
+	})
+	add(MT_PONG_FROM_CLIENT, func(p *netutil.Packet) { p.AppendUint64(12345) })
+	add(MT_AUTH_FROM_CLIENT, func(p *netutil.Packet) { p.AppendVarStr("token") })
+	add(MT_PROTOCOL_VERSION_FROM_CLIENT, func(p *netutil.Packet) { p.AppendUint16(CLIENT_PROTOCOL_VERSION) })
+	add(MT_DEVICE_ID_FROM_CLIENT, func(p *netutil.Packet) { p.AppendVarStr("device") })
+	add(MT_WORLD_FROM_CLIENT, func(p *netutil.Packet) { p.AppendVarStr("s1") })
+	return packets
+}
+
+func TestCheckClientPacket(t *testing.T) {
+	for msgtype, payload := range testClientPackets() {
+		if err := CheckClientPacket(msgtype, payload); err != nil {
+			t.Errorf("check message type %d failed: %s", msgtype, err)
+		}
+		for i := 0; i < len(payload); i++ {
+			if err := CheckClientPacket(msgtype, payload[:i]); errors.Cause(err) != ErrMalformedClientPacket {
+				t.Errorf("check message type %d truncated to %d bytes should fail, but got %v", msgtype, i, err)
+			}
+		}
+		if err := CheckClientPacket(msgtype, append(payload, 0)); errors.Cause(err) != ErrMalformedClientPacket {
+			t.Errorf("check message type %d with trailing bytes should fail, but got %v", msgtype, err)
+		}
+	}
+
+	if err := CheckClientPacket(MT_CALL_ENTITY_METHOD, nil); errors.Cause(err) != ErrMalformedClientPacket {
+		t.Errorf("check unknown message type should fail, but got %v", err)
+	}
+}
+
+func TestCheckClientPacketMalformedArgs(t *testing.T) {
+	p := netutil.NewPacket()
+	defer p.Release()
+	p.AppendEntityID(testEntityID)
+	p.AppendVarStr("Method")
+	p.AppendUint16(1)
+	p.AppendVarBytes(append(bytes.Repeat([]byte{0x91}, 100000), 0xc0)) // depth bomb
+	if err := CheckClientPacket(MT_CALL_ENTITY_METHOD_FROM_CLIENT, p.Payload()); errors.Cause(err) != ErrMalformedClientPacket {
+		t.Errorf("check depth bomb should fail, but got %v", err)
+	}
+}
+
+// TestCheckClientPacketRandom checks random mutations of well formed packets never panic
+func TestCheckClientPacketRandom(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for msgtype, payload := range testClientPackets() {
+		for i := 0; i < 1000; i++ {
+			data := append([]byte{}, payload...)
+			for j := r.Intn(4); j >= 0 && len(data) > 0; j-- {
+				data[r.Intn(len(data))] = byte(r.Intn(256))
+			}
+			if r.Intn(2) == 0 {
+				data = data[:r.Intn(len(data)+1)]
+			}
+			_ = CheckClientPacket(msgtype, data)
+			_ = Fuzz(append([]byte{byte(msgtype), byte(msgtype >> 8)}, data...))
+		}
+	}
+}
@@ -2,22 +2,32 @@ package proto
 
 import (
 	"net"
+	"sync/atomic"
 
 	"time"
 
+	"github.com/pkg/errors"
 	"github.com/xiaonanln/go-xnsyncutil/xnsyncutil"
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/netutil"
 	"github.com/xiaonanln/goworld/engine/netutil/compress"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
 )
 
 // GoWorldConnection is the network protocol implementation of GoWorld components (dispatcher, gate, game)
 type GoWorldConnection struct {
+	sentBytes    uint64 // payload bytes of sent packets, accessed atomically
 	packetConn   *netutil.PacketConnection
 	closed       xnsyncutil.AtomicBool
 	autoFlushing bool
+	flushRequest chan struct{}
+
+	faultInjection  bool
+	heldPacket      *netutil.Packet // packet held for reordering by fault injection
+	heldMsgType     MsgType
+	heldPacketReady bool // if the held packet should be delivered by the next Recv
 }
 
 // NewGoWorldConnection creates a GoWorldConnection using network connection
@@ -28,7 +38,8 @@ func NewGoWorldConnection(conn netutil.Connection, compressConnection bool, comp
 	}
 
 	return &GoWorldConnection{
-		packetConn: netutil.NewPacketConnection(conn, compressor),
+		packetConn:   netutil.NewPacketConnection(conn, compressor),
+		flushRequest: make(chan struct{}, 1),
 	}
 }
 
@@ -75,11 +86,49 @@ func (gwc *GoWorldConnection) SendNotifyDestroyEntity(id common.EntityID) error
 }
 
 // SendNotifyClientConnected sends MT_NOTIFY_CLIENT_CONNECTED message
-func (gwc *GoWorldConnection) SendNotifyClientConnected(id common.ClientID, bootEid common.EntityID) error {
+func (gwc *GoWorldConnection) SendNotifyClientConnected(id common.ClientID, bootEid common.EntityID, sessionToken string, geoTag string, authID string, world string) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_NOTIFY_CLIENT_CONNECTED)
 	packet.AppendClientID(id)
 	packet.AppendEntityID(bootEid)
+	packet.AppendVarStr(sessionToken)
+	packet.AppendVarStr(geoTag)
+	packet.AppendVarStr(authID)
+	packet.AppendVarStr(world)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendNotifyClientFlood sends MT_NOTIFY_CLIENT_FLOOD message
+func (gwc *GoWorldConnection) SendNotifyClientFlood(id common.ClientID, ownerEntityID common.EntityID, reason string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_CLIENT_FLOOD)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendClientID(id)
+	packet.AppendVarStr(reason)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendNotifyClientLatency sends MT_NOTIFY_CLIENT_LATENCY message
+func (gwc *GoWorldConnection) SendNotifyClientLatency(id common.ClientID, ownerEntityID common.EntityID, latency time.Duration) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_CLIENT_LATENCY)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendClientID(id)
+	packet.AppendUint32(uint32(latency / time.Microsecond))
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendResumeClientSession sends MT_RESUME_CLIENT_SESSION message
+func (gwc *GoWorldConnection) SendResumeClientSession(ownerEntityID common.EntityID, sessionToken string, id common.ClientID, newSessionToken string, bootEid common.EntityID, geoTag string, authID string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RESUME_CLIENT_SESSION)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendVarStr(sessionToken)
+	packet.AppendClientID(id)
+	packet.AppendVarStr(newSessionToken)
+	packet.AppendEntityID(bootEid)
+	packet.AppendVarStr(geoTag)
+	packet.AppendVarStr(authID)
 	return gwc.SendPacketRelease(packet)
 }
 
@@ -93,23 +142,25 @@ func (gwc *GoWorldConnection) SendNotifyClientDisconnected(id common.ClientID, o
 }
 
 // SendCreateEntitySomewhere sends MT_CREATE_ENTITY_SOMEWHERE message
-func (gwc *GoWorldConnection) SendCreateEntitySomewhere(gameid uint16, entityid common.EntityID, typeName string, data map[string]interface{}) error {
+func (gwc *GoWorldConnection) SendCreateEntitySomewhere(gameid uint16, entityid common.EntityID, typeName string, world string, data map[string]interface{}) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_CREATE_ENTITY_SOMEWHERE)
 	packet.AppendUint16(gameid)
 	packet.AppendEntityID(entityid)
 	packet.AppendVarStr(typeName)
 	packet.AppendData(data)
+	packet.AppendVarStr(world)
 	return gwc.SendPacketRelease(packet)
 }
 
 // SendLoadEntitySomewhere sends MT_LOAD_ENTITY_SOMEWHERE message
-func (gwc *GoWorldConnection) SendLoadEntitySomewhere(typeName string, entityID common.EntityID, gameid uint16) error {
+func (gwc *GoWorldConnection) SendLoadEntitySomewhere(typeName string, entityID common.EntityID, world string, gameid uint16) error {
 	packet := gwc.packetConn.NewPacket()
 	packet.AppendUint16(MT_LOAD_ENTITY_SOMEWHERE)
 	packet.AppendUint16(gameid)
 	packet.AppendEntityID(entityID)
 	packet.AppendVarStr(typeName)
+	packet.AppendVarStr(world)
 	return gwc.SendPacketRelease(packet)
 }
 
@@ -123,6 +174,157 @@ func (gwc *GoWorldConnection) SendSrvdisRegister(srvid string, info string, forc
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendSuperviseServiceEntity sends MT_SUPERVISE_SERVICE_ENTITY message
+func (gwc *GoWorldConnection) SendSuperviseServiceEntity(id common.EntityID, shardName string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SUPERVISE_SERVICE_ENTITY)
+	packet.AppendEntityID(id)
+	packet.AppendVarStr(shardName)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendNotifyServiceCallFailed sends MT_NOTIFY_SERVICE_CALL_FAILED message
+func (gwc *GoWorldConnection) SendNotifyServiceCallFailed(shardName string, id common.EntityID, method string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_SERVICE_CALL_FAILED)
+	packet.AppendVarStr(shardName)
+	packet.AppendEntityID(id)
+	packet.AppendVarStr(method)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendCallServiceRequest sends MT_CALL_SERVICE_REQUEST message
+func (gwc *GoWorldConnection) SendCallServiceRequest(id common.EntityID, method string, callerGameID uint16, requestID uint32, args []interface{}) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CALL_SERVICE_REQUEST)
+	packet.AppendEntityID(id)
+	packet.AppendVarStr(method)
+	packet.AppendUint16(callerGameID)
+	packet.AppendUint32(requestID)
+	packet.AppendArgs(args)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendServiceResponse sends MT_SERVICE_RESPONSE message
+func (gwc *GoWorldConnection) SendServiceResponse(callerGameID uint16, requestID uint32, code byte, errmsg string, result interface{}) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SERVICE_RESPONSE)
+	packet.AppendUint16(callerGameID)
+	packet.AppendUint32(requestID)
+	packet.AppendByte(code)
+	packet.AppendVarStr(errmsg)
+	packet.AppendData(result)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendServiceSnapshot sends MT_SERVICE_SNAPSHOT message of the service shard
+func (gwc *GoWorldConnection) SendServiceSnapshot(shardName string, version uint64, data []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SERVICE_SNAPSHOT)
+	packet.AppendVarStr(shardName)
+	packet.AppendUint64(version)
+	packet.AppendVarBytes(data)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendEmitEvent sends MT_EMIT_EVENT message
+func (gwc *GoWorldConnection) SendEmitEvent(name string, id string, data []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_EMIT_EVENT)
+	packet.AppendVarStr(name)
+	packet.AppendVarStr(id)
+	packet.AppendVarBytes(data)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSyncBanList sends MT_SYNC_BAN_LIST message, data is the encoded ban, or empty if the value is unbanned
+func (gwc *GoWorldConnection) SendSyncBanList(kind string, value string, data string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SYNC_BAN_LIST)
+	packet.AppendVarStr(kind)
+	packet.AppendVarStr(value)
+	packet.AppendVarStr(data)
+	return gwc.SendPacketRelease(packet)
+}
+
+// MakeEmitEventPacket makes the MT_EMIT_EVENT packet, which is broadcasted to all games by dispatcher
+func MakeEmitEventPacket(name string, id string, data []byte) *netutil.Packet {
+	packet := netutil.NewPacket()
+	packet.AppendUint16(MT_EMIT_EVENT)
+	packet.AppendVarStr(name)
+	packet.AppendVarStr(id)
+	packet.AppendVarBytes(data)
+	return packet
+}
+
+// SendClaimAccountSession sends MT_CLAIM_ACCOUNT_SESSION message
+func (gwc *GoWorldConnection) SendClaimAccountSession(account string, id common.EntityID, gateid uint16, maxSessions int, kickOlder bool, refresh bool) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLAIM_ACCOUNT_SESSION)
+	packet.AppendVarStr(account)
+	packet.AppendEntityID(id)
+	packet.AppendUint16(gateid)
+	packet.AppendUint32(uint32(maxSessions))
+	packet.AppendBool(kickOlder)
+	packet.AppendBool(refresh)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendClaimAccountSessionAck sends MT_CLAIM_ACCOUNT_SESSION_ACK message
+func (gwc *GoWorldConnection) SendClaimAccountSessionAck(account string, id common.EntityID, ok bool, kicked common.EntityIDSet) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_CLAIM_ACCOUNT_SESSION_ACK)
+	packet.AppendVarStr(account)
+	packet.AppendEntityID(id)
+	packet.AppendBool(ok)
+	packet.AppendEntityIDSet(kicked)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendReleaseAccountSession sends MT_RELEASE_ACCOUNT_SESSION message
+func (gwc *GoWorldConnection) SendReleaseAccountSession(account string, id common.EntityID) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RELEASE_ACCOUNT_SESSION)
+	packet.AppendVarStr(account)
+	packet.AppendEntityID(id)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendKickAccountSession sends MT_KICK_ACCOUNT_SESSION message
+func (gwc *GoWorldConnection) SendKickAccountSession(id common.EntityID, account string, reason string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_KICK_ACCOUNT_SESSION)
+	packet.AppendEntityID(id)
+	packet.AppendVarStr(account)
+	packet.AppendVarStr(reason)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendQueryOnlinePlayer sends MT_QUERY_ONLINE_PLAYER message
+func (gwc *GoWorldConnection) SendQueryOnlinePlayer(account string, requestID uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_QUERY_ONLINE_PLAYER)
+	packet.AppendVarStr(account)
+	packet.AppendUint32(requestID)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendQueryOnlinePlayerAck sends MT_QUERY_ONLINE_PLAYER_ACK message, data is the packed online player or nil
+func (gwc *GoWorldConnection) SendQueryOnlinePlayerAck(requestID uint32, data []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_QUERY_ONLINE_PLAYER_ACK)
+	packet.AppendUint32(requestID)
+	packet.AppendVarBytes(data)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendNotifyGameShuttingDown sends MT_NOTIFY_GAME_SHUTTING_DOWN message
+func (gwc *GoWorldConnection) SendNotifyGameShuttingDown() error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_GAME_SHUTTING_DOWN)
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendCallEntityMethod sends MT_CALL_ENTITY_METHOD message
 func (gwc *GoWorldConnection) SendCallEntityMethod(id common.EntityID, method string, args []interface{}) error {
 	packet := gwc.packetConn.NewPacket()
@@ -187,6 +389,173 @@ func (gwc *GoWorldConnection) SetHeartbeatFromClient() error {
 
 }
 
+// SendSetClientSessionToken sends MT_SET_CLIENT_SESSION_TOKEN message
+func (gwc *GoWorldConnection) SendSetClientSessionToken(sessionToken string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_SESSION_TOKEN)
+	packet.AppendVarStr(sessionToken)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendNotifyGateDraining sends MT_NOTIFY_GATE_DRAINING message with the gate to reconnect and the token for resuming
+// the session of the owner entity
+func (gwc *GoWorldConnection) SendNotifyGateDraining(gateAddr string, ownerEntityID common.EntityID, sessionToken string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_GATE_DRAINING)
+	packet.AppendVarStr(gateAddr)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendVarStr(sessionToken)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendResumeSessionFromClient sends MT_RESUME_SESSION_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendResumeSessionFromClient(ownerEntityID common.EntityID, sessionToken string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_RESUME_SESSION_FROM_CLIENT)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendVarStr(sessionToken)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendNegotiateCompressionFromClient sends MT_NEGOTIATE_COMPRESSION_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendNegotiateCompressionFromClient(compressFormats []string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NEGOTIATE_COMPRESSION_FROM_CLIENT)
+	packet.AppendStringList(compressFormats)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSetClientCompression sends MT_SET_CLIENT_COMPRESSION message
+func (gwc *GoWorldConnection) SendSetClientCompression(compressFormat string, threshold uint32) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_COMPRESSION)
+	packet.AppendVarStr(compressFormat)
+	packet.AppendUint32(threshold)
+	packet.SetNotCompress()
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendKeyExchangeFromClient sends MT_KEY_EXCHANGE_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendKeyExchangeFromClient(cipherFormats []string, publicKey []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_KEY_EXCHANGE_FROM_CLIENT)
+	packet.AppendStringList(cipherFormats)
+	packet.AppendVarBytes(publicKey)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSetClientCipher sends MT_SET_CLIENT_CIPHER message, signature is omitted if the key exchange is not signed
+func (gwc *GoWorldConnection) SendSetClientCipher(cipherFormat string, publicKey []byte, signature []byte) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_CIPHER)
+	packet.AppendVarStr(cipherFormat)
+	packet.AppendVarBytes(publicKey)
+	if len(signature) > 0 {
+		// older clients ignore the trailing signature
+		packet.AppendVarBytes(signature)
+	}
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendPingToClient sends MT_PING_TO_CLIENT message
+func (gwc *GoWorldConnection) SendPingToClient(seq uint64) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_PING_TO_CLIENT)
+	packet.AppendUint64(seq)
+	packet.SetNotCompress()
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendPongFromClient sends MT_PONG_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendPongFromClient(seq uint64) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_PONG_FROM_CLIENT)
+	packet.AppendUint64(seq)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendAuthFromClient sends MT_AUTH_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendAuthFromClient(token string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_AUTH_FROM_CLIENT)
+	packet.AppendVarStr(token)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendDeviceIDFromClient sends MT_DEVICE_ID_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendDeviceIDFromClient(deviceID string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_DEVICE_ID_FROM_CLIENT)
+	packet.AppendVarStr(deviceID)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendWorldFromClient sends MT_WORLD_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendWorldFromClient(world string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_WORLD_FROM_CLIENT)
+	packet.AppendVarStr(world)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendAuthResultOnClient sends MT_AUTH_RESULT_ON_CLIENT message
+func (gwc *GoWorldConnection) SendAuthResultOnClient(ok bool, reason string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_AUTH_RESULT_ON_CLIENT)
+	packet.AppendBool(ok)
+	packet.AppendVarStr(reason)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendKickedOnClient sends MT_KICKED_ON_CLIENT message
+func (gwc *GoWorldConnection) SendKickedOnClient(reason string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_KICKED_ON_CLIENT)
+	packet.AppendVarStr(reason)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendProtocolVersionFromClient sends MT_PROTOCOL_VERSION_FROM_CLIENT message
+func (gwc *GoWorldConnection) SendProtocolVersionFromClient(version uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_PROTOCOL_VERSION_FROM_CLIENT)
+	packet.AppendUint16(version)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendSetClientProtocolVersion sends MT_SET_CLIENT_PROTOCOL_VERSION message
+func (gwc *GoWorldConnection) SendSetClientProtocolVersion(accepted bool, version uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_SET_CLIENT_PROTOCOL_VERSION)
+	packet.AppendBool(accepted)
+	packet.AppendUint16(version)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendRedirectToGateOnClient sends MT_REDIRECT_TO_GATE_ON_CLIENT message
+func (gwc *GoWorldConnection) SendRedirectToGateOnClient(gateAddr string, ownerEntityID common.EntityID, sessionToken string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REDIRECT_TO_GATE_ON_CLIENT)
+	packet.AppendVarStr(gateAddr)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendVarStr(sessionToken)
+	packet.SetUrgent()
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendDestroyEntityOnClient sends MT_DESTROY_ENTITY_ON_CLIENT message
 func (gwc *GoWorldConnection) SendDestroyEntityOnClient(gateid uint16, clientid common.ClientID, typeName string, entityid common.EntityID) error {
 	packet := gwc.packetConn.NewPacket()
@@ -302,6 +671,48 @@ func (gwc *GoWorldConnection) SendClearClientFilterProp(gateid uint16, clientid
 	return gwc.SendPacketRelease(packet)
 }
 
+// SendNotifySessionResumedOnClient sends MT_NOTIFY_SESSION_RESUMED_ON_CLIENT message
+func (gwc *GoWorldConnection) SendNotifySessionResumedOnClient(gateid uint16, clientid common.ClientID, ownerEntityID common.EntityID, ok bool) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_SESSION_RESUMED_ON_CLIENT)
+	packet.AppendUint16(gateid)
+	packet.AppendClientID(clientid)
+	packet.AppendEntityID(ownerEntityID)
+	packet.AppendBool(ok)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendRedirectClientToGate sends MT_REDIRECT_CLIENT_TO_GATE message
+func (gwc *GoWorldConnection) SendRedirectClientToGate(gateid uint16, clientid common.ClientID, targetGateID uint16) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_REDIRECT_CLIENT_TO_GATE)
+	packet.AppendUint16(gateid)
+	packet.AppendClientID(clientid)
+	packet.AppendUint16(targetGateID)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendKickClient sends MT_KICK_CLIENT message
+func (gwc *GoWorldConnection) SendKickClient(gateid uint16, clientid common.ClientID, reason string) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_KICK_CLIENT)
+	packet.AppendUint16(gateid)
+	packet.AppendClientID(clientid)
+	packet.AppendVarStr(reason)
+	return gwc.SendPacketRelease(packet)
+}
+
+// SendNotifyShutdownOnClient sends MT_NOTIFY_SHUTDOWN_ON_CLIENT message
+func (gwc *GoWorldConnection) SendNotifyShutdownOnClient(gateid uint16, clientid common.ClientID, reason string, countdown time.Duration) error {
+	packet := gwc.packetConn.NewPacket()
+	packet.AppendUint16(MT_NOTIFY_SHUTDOWN_ON_CLIENT)
+	packet.AppendUint16(gateid)
+	packet.AppendClientID(clientid)
+	packet.AppendVarStr(reason)
+	packet.AppendUint32(uint32(countdown / time.Second))
+	return gwc.SendPacketRelease(packet)
+}
+
 // SendCallFilterClientProxies sends MT_CALL_FILTERED_CLIENTS message
 func AllocCallFilterClientProxiesPacket(op FilterClientsOpType, key, val string, method string, args []interface{}) *netutil.Packet {
 	packet := netutil.NewPacket()
@@ -375,9 +786,12 @@ func (gwc *GoWorldConnection) SendRealMigrate(eid common.EntityID, targetGame ui
 //	return gwc.SendPacketRelease(packet)
 //}
 
-func AllocStartFreezeGamePacket() *netutil.Packet {
+// AllocStartFreezeGamePacket allocates the MT_START_FREEZE_GAME packet, toAnotherHost is set if the game is restored on
+// another host, which takes longer
+func AllocStartFreezeGamePacket(toAnotherHost bool) *netutil.Packet {
 	packet := netutil.NewPacket()
 	packet.AppendUint16(MT_START_FREEZE_GAME)
+	packet.AppendBool(toAnotherHost)
 	return packet
 }
 
@@ -424,12 +838,22 @@ func (gwc *GoWorldConnection) SendSetGameIDAck(dispid uint16, isDeploymentReady
 
 // SendPacket send a packet to remote
 func (gwc *GoWorldConnection) SendPacket(packet *netutil.Packet) error {
-	return gwc.packetConn.SendPacket(packet)
+	atomic.AddUint64(&gwc.sentBytes, uint64(packet.GetPayloadLen()))
+	err := gwc.packetConn.SendPacket(packet)
+	if packet.IsUrgent() {
+		gwc.RequestFlush()
+	}
+	return err
+}
+
+// GetSentBytes returns the payload bytes of all packets sent by the connection
+func (gwc *GoWorldConnection) GetSentBytes() uint64 {
+	return atomic.LoadUint64(&gwc.sentBytes)
 }
 
 // SendPacketRelease send a packet to remote and then release the packet
 func (gwc *GoWorldConnection) SendPacketRelease(packet *netutil.Packet) error {
-	err := gwc.packetConn.SendPacket(packet)
+	err := gwc.SendPacket(packet)
 	packet.Release()
 	return err
 }
@@ -447,8 +871,13 @@ func (gwc *GoWorldConnection) SetAutoFlush(interval time.Duration) {
 	gwc.autoFlushing = true
 	go func() {
 		//defer gwlog.Debugf("%s: auto flush routine quited", gwc)
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
 		for !gwc.IsClosed() {
-			time.Sleep(interval)
+			select {
+			case <-ticker.C:
+			case <-gwc.flushRequest:
+			}
 			err := gwc.Flush("AutoFlush")
 			if err != nil {
 				break
@@ -457,13 +886,79 @@ func (gwc *GoWorldConnection) SetAutoFlush(interval time.Duration) {
 	}()
 }
 
+// RequestFlush requests the auto flush goroutine to flush connection writes immediately without waiting for the interval
+func (gwc *GoWorldConnection) RequestFlush() {
+	select {
+	case gwc.flushRequest <- struct{}{}:
+	default: // flush is already requested, or the connection is not auto flushing
+	}
+}
+
+// SetCompression sets compress format and threshold for sending packets, empty compress format means no compression
+func (gwc *GoWorldConnection) SetCompression(compressFormat string, threshold uint32) {
+	var compressor compress.Compressor
+	if compressFormat != "" {
+		compressor = compress.NewCompressor(compressFormat)
+	}
+	gwc.packetConn.SetCompressor(compressor, threshold)
+}
+
+// SetDecompression sets compress format for receiving packets, empty compress format means no compression
+//
+// SetDecompression should be called in the goroutine which receives packets
+func (gwc *GoWorldConnection) SetDecompression(compressFormat string) {
+	var compressor compress.Compressor
+	if compressFormat != "" {
+		compressor = compress.NewCompressor(compressFormat)
+	}
+	gwc.packetConn.SetDecompressor(compressor)
+}
+
+// SetEncryption sets cipher format and key for sending packets
+func (gwc *GoWorldConnection) SetEncryption(cipherFormat string, key []byte) error {
+	cipher, err := crypt.NewCipher(cipherFormat, key)
+	if err != nil {
+		return err
+	}
+	gwc.packetConn.SetEncryptor(cipher)
+	return nil
+}
+
+// SetDecryption sets cipher format and key for receiving packets
+//
+// SetDecryption should be called in the goroutine which receives packets
+func (gwc *GoWorldConnection) SetDecryption(cipherFormat string, key []byte) error {
+	cipher, err := crypt.NewCipher(cipherFormat, key)
+	if err != nil {
+		return err
+	}
+	gwc.packetConn.SetDecryptor(cipher)
+	return nil
+}
+
+// SetMaxRecvPayloadLength limits the payload length of received packets
+func (gwc *GoWorldConnection) SetMaxRecvPayloadLength(maxPayloadLen uint32) {
+	gwc.packetConn.SetMaxRecvPayloadLength(maxPayloadLen)
+}
+
 // Recv receives the next packet and retrive the message type
 func (gwc *GoWorldConnection) Recv(msgtype *MsgType) (*netutil.Packet, error) {
+	if gwc.faultInjection {
+		return gwc.recvWithFaults(msgtype)
+	}
+	return gwc.recv(msgtype)
+}
+
+func (gwc *GoWorldConnection) recv(msgtype *MsgType) (*netutil.Packet, error) {
 	pkt, err := gwc.packetConn.RecvPacket()
 	if err != nil {
 		return nil, err
 	}
 
+	if pkt.GetPayloadLen() < 2 {
+		pkt.Release()
+		return nil, errors.Wrap(netutil.ErrMalformedPacket, "message type is missing")
+	}
 	*msgtype = MsgType(pkt.ReadUint16())
 	if consts.DEBUG_PACKETS {
 		gwlog.Infof("%s: Recv msgtype=%v, payload size=%d", gwc, *msgtype, pkt.GetPayloadLen())
@@ -479,6 +974,7 @@ func (gwc *GoWorldConnection) SetRecvDeadline(deadline time.Time) error {
 // Close this connection
 func (gwc *GoWorldConnection) Close() error {
 	gwc.closed.Store(true)
+	gwc.disableFaultInjection()
 	return gwc.packetConn.Close()
 }
 
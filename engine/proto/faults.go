@@ -0,0 +1,153 @@
+package proto
+
+import (
+	"fmt"
+	"math/rand"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// ErrFaultDisconnected is returned by Recv if the connection is closed by the injected fault
+var ErrFaultDisconnected = errors.New("connection closed by injected fault")
+
+// Faults are faults injected to packets received by connections between components (dispatchers, games and gates), which
+// are used to verify that migrations, failovers and reconnections survive bad networks
+type Faults struct {
+	Latency        time.Duration // delay of each received packet
+	Jitter         time.Duration // max random delay added to Latency
+	DropRate       float64       // probability of dropping the packet
+	ReorderRate    float64       // probability of delivering the packet after the next packet
+	DisconnectRate float64       // probability of closing the connection when receiving the packet
+}
+
+// IsZero returns if no fault is injected
+func (f Faults) IsZero() bool {
+	return f == Faults{}
+}
+
+func (f Faults) String() string {
+	if f.IsZero() {
+		return "Faults<none>"
+	}
+	return fmt.Sprintf("Faults<latency=%s, jitter=%s, drop=%v, reorder=%v, disconnect=%v>",
+		f.Latency, f.Jitter, f.DropRate, f.ReorderRate, f.DisconnectRate)
+}
+
+func (f Faults) delay() time.Duration {
+	d := f.Latency
+	if f.Jitter > 0 {
+		d += time.Duration(rand.Int63n(int64(f.Jitter)))
+	}
+	return d
+}
+
+var (
+	injectedFaults atomic.Value // Faults
+
+	faultyConnsLock sync.Mutex
+	faultyConns     = map[*GoWorldConnection]struct{}{}
+)
+
+func init() {
+	injectedFaults.Store(Faults{})
+}
+
+// SetFaults sets faults injected to packets received by connections with fault injection enabled
+func SetFaults(f Faults) {
+	gwlog.Warnf("Injected faults: %s", f)
+	injectedFaults.Store(f)
+}
+
+// GetFaults returns the injected faults
+func GetFaults() Faults {
+	return injectedFaults.Load().(Faults)
+}
+
+// DisconnectFaultyConnections closes all connections with fault injection enabled, and returns the number of them
+func DisconnectFaultyConnections() int {
+	faultyConnsLock.Lock()
+	conns := make([]*GoWorldConnection, 0, len(faultyConns))
+	for gwc := range faultyConns {
+		conns = append(conns, gwc)
+	}
+	faultyConnsLock.Unlock()
+
+	for _, gwc := range conns {
+		gwlog.Warnf("Injected fault: disconnect %s", gwc)
+		gwc.Close()
+	}
+	return len(conns)
+}
+
+// EnableFaultInjection injects faults set by SetFaults to packets received by the connection, which should only be
+// enabled on connections between components
+func (gwc *GoWorldConnection) EnableFaultInjection() {
+	faultyConnsLock.Lock()
+	faultyConns[gwc] = struct{}{}
+	faultyConnsLock.Unlock()
+	gwc.faultInjection = true
+}
+
+func (gwc *GoWorldConnection) disableFaultInjection() {
+	if !gwc.faultInjection {
+		return
+	}
+
+	faultyConnsLock.Lock()
+	delete(faultyConns, gwc)
+	faultyConnsLock.Unlock()
+}
+
+// recvWithFaults receives the next packet with faults injected, the packet held for reordering is delivered after the
+// next packet is received
+func (gwc *GoWorldConnection) recvWithFaults(msgtype *MsgType) (*netutil.Packet, error) {
+	if gwc.heldPacket != nil && gwc.heldPacketReady {
+		pkt := gwc.heldPacket
+		*msgtype = gwc.heldMsgType
+		gwc.heldPacket, gwc.heldPacketReady = nil, false
+		return pkt, nil
+	}
+
+	for {
+		pkt, err := gwc.recv(msgtype)
+		if err != nil {
+			return nil, err
+		}
+
+		f := GetFaults()
+		if f.IsZero() {
+			if gwc.heldPacket != nil {
+				gwc.heldPacketReady = true
+			}
+			return pkt, nil
+		}
+
+		if f.DisconnectRate > 0 && rand.Float64() < f.DisconnectRate {
+			pkt.Release()
+			gwlog.Warnf("Injected fault: disconnect %s", gwc)
+			gwc.Close()
+			return nil, ErrFaultDisconnected
+		}
+		if f.DropRate > 0 && rand.Float64() < f.DropRate {
+			pkt.Release()
+			continue
+		}
+		if d := f.delay(); d > 0 {
+			time.Sleep(d)
+		}
+		if gwc.heldPacket != nil {
+			gwc.heldPacketReady = true
+			return pkt, nil
+		}
+		if f.ReorderRate > 0 && rand.Float64() < f.ReorderRate {
+			gwc.heldPacket, gwc.heldMsgType = pkt, *msgtype
+			continue
+		}
+		return pkt, nil
+	}
+}
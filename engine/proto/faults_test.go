@@ -0,0 +1,139 @@
+package proto
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwioutil"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// newFaultyConnPair returns the connection sending packets and the connection receiving packets with faults injected
+func newFaultyConnPair(f Faults) (*GoWorldConnection, *GoWorldConnection) {
+	SetFaults(f)
+	c1, c2 := net.Pipe()
+	sender := NewGoWorldConnection(netutil.NetConnection{Conn: c1}, false, "")
+	receiver := NewGoWorldConnection(netutil.NewBufferedConnection(netutil.NetConnection{Conn: c2}), false, "")
+	receiver.EnableFaultInjection()
+	return sender, receiver
+}
+
+func sendGateIDs(gwc *GoWorldConnection, ids ...uint16) {
+	go func() {
+		for _, id := range ids {
+			gwc.SendSetGateID(id)
+		}
+		gwc.Flush("test")
+	}()
+}
+
+// clearFaultsAndSend clears faults and sends packets after packets sent before are received with faults
+func clearFaultsAndSend(gwc *GoWorldConnection, ids ...uint16) {
+	go func() {
+		time.Sleep(time.Millisecond * 20)
+		SetFaults(Faults{})
+		sendGateIDs(gwc, ids...)
+	}()
+}
+
+func recvGateID(t *testing.T, gwc *GoWorldConnection) uint16 {
+	for {
+		var msgtype MsgType
+		pkt, err := gwc.Recv(&msgtype)
+		if err != nil {
+			if gwioutil.IsTimeoutError(err) {
+				continue
+			}
+			t.Fatalf("recv failed: %v", err)
+		}
+		if msgtype != MT_SET_GATE_ID {
+			t.Fatalf("wrong msgtype: %v", msgtype)
+		}
+		id := pkt.ReadUint16()
+		pkt.Release()
+		return id
+	}
+}
+
+func TestFaultsLatency(t *testing.T) {
+	defer SetFaults(Faults{})
+	sender, receiver := newFaultyConnPair(Faults{Latency: time.Millisecond * 50})
+	defer sender.Close()
+	defer receiver.Close()
+
+	t0 := time.Now()
+	sendGateIDs(sender, 1)
+	if id := recvGateID(t, receiver); id != 1 {
+		t.Errorf("received %d, expected 1", id)
+	}
+	if d := time.Since(t0); d < time.Millisecond*50 {
+		t.Errorf("packet is received in %s, latency is not injected", d)
+	}
+}
+
+func TestFaultsReorder(t *testing.T) {
+	defer SetFaults(Faults{})
+	sender, receiver := newFaultyConnPair(Faults{ReorderRate: 1})
+	defer sender.Close()
+	defer receiver.Close()
+
+	sendGateIDs(sender, 1, 2, 3, 4)
+	var ids []uint16
+	for i := 0; i < 4; i++ {
+		ids = append(ids, recvGateID(t, receiver))
+	}
+	if ids[0] != 2 || ids[1] != 1 || ids[2] != 4 || ids[3] != 3 {
+		t.Errorf("packets should be swapped in pairs, but received %v", ids)
+	}
+
+	// the held packet is delivered after the next packet, even if faults are cleared
+	sendGateIDs(sender, 5)
+	clearFaultsAndSend(sender, 6)
+	if id1, id2 := recvGateID(t, receiver), recvGateID(t, receiver); id1 != 6 || id2 != 5 {
+		t.Errorf("received %d, %d, expected 6, 5", id1, id2)
+	}
+}
+
+func TestFaultsDrop(t *testing.T) {
+	defer SetFaults(Faults{})
+	sender, receiver := newFaultyConnPair(Faults{DropRate: 1})
+	defer sender.Close()
+	defer receiver.Close()
+
+	sendGateIDs(sender, 1, 2)
+	clearFaultsAndSend(sender, 3)
+	if id := recvGateID(t, receiver); id != 3 {
+		t.Errorf("received %d, packets should be dropped", id)
+	}
+}
+
+func TestFaultsDisconnect(t *testing.T) {
+	defer SetFaults(Faults{})
+	sender, receiver := newFaultyConnPair(Faults{DisconnectRate: 1})
+	defer sender.Close()
+
+	sendGateIDs(sender, 1)
+	var msgtype MsgType
+	if _, err := receiver.Recv(&msgtype); err != ErrFaultDisconnected {
+		t.Fatalf("connection should be disconnected, but got %v", err)
+	}
+	if !receiver.IsClosed() {
+		t.Errorf("connection should be closed")
+	}
+}
+
+func TestDisconnectFaultyConnections(t *testing.T) {
+	sender, receiver := newFaultyConnPair(Faults{})
+	defer sender.Close()
+
+	if n := DisconnectFaultyConnections(); n != 1 {
+		t.Fatalf("%d connections are disconnected, expected 1", n)
+	}
+	if !receiver.IsClosed() {
+		t.Errorf("connection should be closed")
+	}
+	if n := DisconnectFaultyConnections(); n != 0 {
+		t.Errorf("closed connections should not be disconnected again")
+	}
+}
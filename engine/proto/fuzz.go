@@ -0,0 +1,34 @@
+package proto
+
+import (
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// Fuzz is the fuzzing entry of decoding packets sent by clients, in the format of go-fuzz
+//
+// Data is the message type (little endian uint16) followed by the payload. Packets passing CheckClientPacket are
+// decoded as the gate and the game do, including RPC arguments, so any panic is a bug. Fuzz returns 1 for well formed
+// packets and 0 otherwise.
+func Fuzz(data []byte) int {
+	if len(data) < 2 {
+		return 0
+	}
+	msgtype := MsgType(netutil.NETWORK_ENDIAN.Uint16(data))
+	payload := data[2:]
+	if err := CheckClientPacket(msgtype, payload); err != nil {
+		return 0
+	}
+
+	if msgtype == MT_CALL_ENTITY_METHOD_FROM_CLIENT {
+		pkt := netutil.NewPacket()
+		defer pkt.Release()
+		pkt.AppendBytes(payload)
+		_ = pkt.ReadEntityID()
+		_ = pkt.ReadVarStr()
+		for _, arg := range pkt.ReadArgs() {
+			var v interface{}
+			_ = netutil.MSG_PACKER.UnpackMsg(arg, &v)
+		}
+	}
+	return 1
+}
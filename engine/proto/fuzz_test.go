@@ -0,0 +1,18 @@
+//go:build go1.18
+// +build go1.18
+
+package proto
+
+import (
+	"testing"
+)
+
+// FuzzClientPacket fuzzes decoding packets sent by clients with go test -fuzz=FuzzClientPacket
+func FuzzClientPacket(f *testing.F) {
+	for msgtype, payload := range testClientPackets() {
+		f.Add(append([]byte{byte(msgtype), byte(msgtype >> 8)}, payload...))
+	}
+	f.Fuzz(func(t *testing.T, data []byte) {
+		Fuzz(data)
+	})
+}
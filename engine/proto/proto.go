@@ -73,6 +73,38 @@ const (
 	MT_NOTIFY_DEPLOYMENT_READY
 	// MT_GAME_LBC_INFO contains game load balacing info
 	MT_GAME_LBC_INFO
+	// MT_RESUME_CLIENT_SESSION is sent by gate to resume the client session of owner entity for a reconnected client
+	MT_RESUME_CLIENT_SESSION
+	// MT_NOTIFY_CLIENT_FLOOD is sent by gate to notify the owner entity that the client is kicked for flooding
+	MT_NOTIFY_CLIENT_FLOOD
+	// MT_NOTIFY_CLIENT_LATENCY is sent by gate to notify the owner entity of the measured latency of the client
+	MT_NOTIFY_CLIENT_LATENCY
+	// MT_SUPERVISE_SERVICE_ENTITY is sent by game to let dispatcher keep calls to the service entity if the game is down
+	MT_SUPERVISE_SERVICE_ENTITY
+	// MT_NOTIFY_SERVICE_CALL_FAILED is sent by dispatcher to the calling game if the call to a lost service entity is failed
+	MT_NOTIFY_SERVICE_CALL_FAILED
+	// MT_CALL_SERVICE_REQUEST is sent by game to call the service entity and wait for the response
+	MT_CALL_SERVICE_REQUEST
+	// MT_SERVICE_RESPONSE is sent by the game of service entity to respond the service request
+	MT_SERVICE_RESPONSE
+	// MT_SERVICE_SNAPSHOT is sent by game to publish the snapshot of service, and broadcasted to all games by dispatcher
+	MT_SERVICE_SNAPSHOT
+	// MT_CLAIM_ACCOUNT_SESSION is sent by game to the dispatcher selected by the account to claim the session of the account for the entity
+	MT_CLAIM_ACCOUNT_SESSION
+	// MT_CLAIM_ACCOUNT_SESSION_ACK is sent by dispatcher to the claiming game with the claim result and the kicked sessions
+	MT_CLAIM_ACCOUNT_SESSION_ACK
+	// MT_RELEASE_ACCOUNT_SESSION is sent by game to release the session of the account held by the entity
+	MT_RELEASE_ACCOUNT_SESSION
+	// MT_KICK_ACCOUNT_SESSION is sent by game to the entity whose session of the account is kicked by a newer session
+	MT_KICK_ACCOUNT_SESSION
+	// MT_NOTIFY_GAME_SHUTTING_DOWN is sent by game to all dispatchers, so that new entities are not created on the game
+	MT_NOTIFY_GAME_SHUTTING_DOWN
+	// MT_EMIT_EVENT is sent by game to emit the event of the event bus, and broadcasted to all games by dispatcher
+	MT_EMIT_EVENT
+	// MT_QUERY_ONLINE_PLAYER is sent by game to the dispatcher selected by the account to find the online player of the account
+	MT_QUERY_ONLINE_PLAYER
+	// MT_QUERY_ONLINE_PLAYER_ACK is sent by dispatcher to the querying game with the online player of the account
+	MT_QUERY_ONLINE_PLAYER_ACK
 )
 
 // Alias message types
@@ -109,6 +141,14 @@ const (
 	MT_CLEAR_CLIENTPROXY_FILTER_PROPS
 	// MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT message type
 	MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT
+	// MT_NOTIFY_SESSION_RESUMED_ON_CLIENT message type
+	MT_NOTIFY_SESSION_RESUMED_ON_CLIENT
+	// MT_REDIRECT_CLIENT_TO_GATE message type: the client proxy is asked to reconnect to another gate
+	MT_REDIRECT_CLIENT_TO_GATE
+	// MT_KICK_CLIENT message type: the client proxy is notified of the kick reason and closed
+	MT_KICK_CLIENT
+	// MT_NOTIFY_SHUTDOWN_ON_CLIENT message type: the client is notified that the game is shutting down after the countdown
+	MT_NOTIFY_SHUTDOWN_ON_CLIENT
 	// MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP message type
 	MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP = 1499
 )
@@ -118,6 +158,8 @@ const (
 	MT_CALL_FILTERED_CLIENTS = 1501 + iota
 	// MT_SYNC_POSITION_YAW_ON_CLIENTS message type
 	MT_SYNC_POSITION_YAW_ON_CLIENTS
+	// MT_SYNC_BAN_LIST is sent by gate or game with the ban or unban of the value, and broadcasted to all gates by dispatcher
+	MT_SYNC_BAN_LIST
 	// MT_GATE_SERVICE_MSG_TYPE_STOP message type
 	MT_GATE_SERVICE_MSG_TYPE_STOP = 1999
 )
@@ -130,6 +172,60 @@ const (
 	MT_UDP_SYNC_CONN_NOTIFY_CLIENTID_ACK
 	// MT_HEARTBEAT_FROM_CLIENT is sent by client to notify the gate server that the client is alive
 	MT_HEARTBEAT_FROM_CLIENT
+	// MT_NEGOTIATE_COMPRESSION_FROM_CLIENT is sent by client with its supported compress formats to negotiate packet compression
+	MT_NEGOTIATE_COMPRESSION_FROM_CLIENT
+	// MT_SET_CLIENT_COMPRESSION is sent to client to set the negotiated compress format and threshold
+	MT_SET_CLIENT_COMPRESSION
+	// MT_SET_CLIENT_SESSION_TOKEN is sent to client to set the token for resuming session after reconnecting
+	MT_SET_CLIENT_SESSION_TOKEN
+	// MT_RESUME_SESSION_FROM_CLIENT is sent by reconnected client to resume its session on the owner entity
+	MT_RESUME_SESSION_FROM_CLIENT
+	// MT_NOTIFY_GATE_DRAINING is sent to client when gate is draining with the gate address to reconnect (empty if there
+	// is no other gate) and the token for resuming session, client should reconnect to the gate and resume the session
+	MT_NOTIFY_GATE_DRAINING
+	// MT_KEY_EXCHANGE_FROM_CLIENT is sent by client with its supported cipher formats and public key to enable packet encryption
+	MT_KEY_EXCHANGE_FROM_CLIENT
+	// MT_SET_CLIENT_CIPHER is sent to client to set the negotiated cipher format with the gate's public key, and the
+	// signature of the key exchange if sign_key_exchange is enabled at gate
+	MT_SET_CLIENT_CIPHER
+	// MT_PING_TO_CLIENT is sent to client with a sequence number to measure the latency, client should reply MT_PONG_FROM_CLIENT immediately
+	MT_PING_TO_CLIENT
+	// MT_PONG_FROM_CLIENT is sent by client to reply MT_PING_TO_CLIENT with the same sequence number
+	MT_PONG_FROM_CLIENT
+	// MT_AUTH_FROM_CLIENT is sent by client with the auth token, if authentication is enabled at gate
+	MT_AUTH_FROM_CLIENT
+	// MT_AUTH_RESULT_ON_CLIENT is sent to client with the authentication result
+	MT_AUTH_RESULT_ON_CLIENT
+	// MT_PROTOCOL_VERSION_FROM_CLIENT is sent by client with its protocol version, which should be the first message of the client
+	MT_PROTOCOL_VERSION_FROM_CLIENT
+	// MT_SET_CLIENT_PROTOCOL_VERSION is sent to client with the negotiated protocol version, or the minimum version if rejected
+	MT_SET_CLIENT_PROTOCOL_VERSION
+	// MT_REDIRECT_TO_GATE_ON_CLIENT is sent to client with the gate address to reconnect and the token for resuming session
+	MT_REDIRECT_TO_GATE_ON_CLIENT
+	// MT_DEVICE_ID_FROM_CLIENT is sent by client with its device ID for checking banned devices, which should be sent before the auth token
+	MT_DEVICE_ID_FROM_CLIENT
+	// MT_KICKED_ON_CLIENT is sent to client with the reason before the client is kicked, e.g. logged in elsewhere
+	MT_KICKED_ON_CLIENT
+	// MT_WORLD_FROM_CLIENT is sent by client with the world to login, if worlds are configured in the deployment
+	MT_WORLD_FROM_CLIENT
+)
+
+// Protocol versions between gate and client
+const (
+	// CLIENT_PROTOCOL_VERSION_1 is the original protocol, which is assumed for clients not sending their protocol version
+	CLIENT_PROTOCOL_VERSION_1 = 1
+	// CLIENT_PROTOCOL_VERSION_2 adds session token, gate draining notification, latency ping and protocol version negotiation
+	CLIENT_PROTOCOL_VERSION_2 = 2
+	// CLIENT_PROTOCOL_VERSION_3 adds redirecting clients to other gates
+	CLIENT_PROTOCOL_VERSION_3 = 3
+	// CLIENT_PROTOCOL_VERSION_4 adds kick reasons
+	CLIENT_PROTOCOL_VERSION_4 = 4
+	// CLIENT_PROTOCOL_VERSION_5 adds shutdown notices
+	CLIENT_PROTOCOL_VERSION_5 = 5
+	// CLIENT_PROTOCOL_VERSION_6 adds the gate address and the session token to gate draining notifications
+	CLIENT_PROTOCOL_VERSION_6 = 6
+	// CLIENT_PROTOCOL_VERSION is the latest protocol version supported by gate
+	CLIENT_PROTOCOL_VERSION = CLIENT_PROTOCOL_VERSION_6
 )
 
 const (
@@ -165,4 +261,5 @@ func init() {
 // GameLBCInfo defines the info for game load balancing
 type GameLBCInfo struct {
 	CPUPercent float64 `msgpack:"cp"`
+	Draining   bool    `msgpack:"dr"` // the game is drained by watchdog, other games are chosen for new entities if available
 }
@@ -0,0 +1,158 @@
+// Package rbac authorizes admin API requests, GM commands and calls bridged by HTTP bridge or gRPC of games by roles
+// defined in [rbac] config, and audits all authorization decisions.
+//
+// Actions are:
+//
+//	admin:<path>                       admin API endpoints, e.g. admin:drain, admin:debug/pprof/heap
+//	call_entity:<method>               entity calls through HTTP bridge or gRPC
+//	call_service:<service>.<method>    service calls through HTTP bridge or gRPC
+//	create_entity:<type>               entity creations through gRPC
+//	load_entity:<type>                 entity loads through gRPC
+//	gm:<command>                       GM commands registered by goworld.RegisterGMCommand, or authorized by game logic using goworld.Authorize
+//
+// Permissions of roles are actions, or action prefixes ending with *, e.g. admin:*, call_service:MailService.*
+package rbac
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Superuser is the user of tokens which are allowed to do all actions, i.e. [admin].token, [bridge].token and
+// grpc_token of games, or client certificates of admin servers if RBAC is not enabled
+const Superuser = "superuser"
+
+var (
+	// ErrPermissionDenied is returned by Authorize if the user is not allowed to do the action
+	ErrPermissionDenied = errors.New("permission denied")
+
+	auditCallbacksLock sync.RWMutex
+	auditCallbacks     []func(record AuditRecord)
+)
+
+// AuditRecord is the authorization decision of the action done by the user
+type AuditRecord struct {
+	Time    time.Time
+	User    string
+	Action  string
+	Source  string // where the action comes from, e.g. the remote address of admin requests
+	Allowed bool
+}
+
+func (r AuditRecord) String() string {
+	decision := "denied"
+	if r.Allowed {
+		decision = "allowed"
+	}
+	return fmt.Sprintf("audit: %s %s %s from %s", r.User, decision, r.Action, r.Source)
+}
+
+// OnAudit registers the callback which is called with records of all authorization decisions
+//
+// Callbacks are called in goroutines authorizing actions, e.g. goroutines of admin HTTP servers.
+func OnAudit(cb func(record AuditRecord)) {
+	auditCallbacksLock.Lock()
+	auditCallbacks = append(auditCallbacks, cb)
+	auditCallbacksLock.Unlock()
+}
+
+// Enabled returns if any user is defined in [rbac] config
+func Enabled() bool {
+	return len(config.GetRBAC().Users) > 0
+}
+
+// Authenticate returns the user of the token defined in [rbac] config
+func Authenticate(token string) (string, bool) {
+	return authenticate(config.GetRBAC(), token)
+}
+
+func authenticate(cfg *config.RBACConfig, token string) (string, bool) {
+	if token == "" {
+		return "", false
+	}
+	for name, user := range cfg.Users {
+		if user.Token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(user.Token)) == 1 {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+// IsUser returns if the user is defined in [rbac] config, e.g. the common name of client certificates or the auth ID of
+// clients running GM commands
+func IsUser(user string) bool {
+	_, ok := config.GetRBAC().Users[strings.ToLower(user)]
+	return ok
+}
+
+// Allowed returns if the user is allowed to do the action by permissions of its roles
+func Allowed(user string, action string) bool {
+	return allowed(config.GetRBAC(), user, action)
+}
+
+func allowed(cfg *config.RBACConfig, user string, action string) bool {
+	if user == Superuser {
+		return true
+	}
+
+	u := cfg.Users[strings.ToLower(user)]
+	if u == nil {
+		return false
+	}
+	for _, role := range u.Roles {
+		for _, perm := range cfg.Roles[role] {
+			if permits(perm, action) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func permits(perm string, action string) bool {
+	if strings.HasSuffix(perm, "*") {
+		return strings.HasPrefix(action, perm[:len(perm)-1])
+	}
+	return perm == action
+}
+
+// Authorize checks if the user is allowed to do the action, and audits the decision
+//
+// ErrPermissionDenied is returned if the action is not allowed.
+func Authorize(user string, action string, source string) error {
+	record := AuditRecord{
+		Time:    time.Now(),
+		User:    user,
+		Action:  action,
+		Source:  source,
+		Allowed: Allowed(user, action),
+	}
+	audit(record)
+	if !record.Allowed {
+		return ErrPermissionDenied
+	}
+	return nil
+}
+
+func audit(record AuditRecord) {
+	logger := gwlog.With("audit", true, "user", record.User, "action", record.Action, "source", record.Source, "allowed", record.Allowed)
+	if record.Allowed {
+		logger.Infof("%s", record)
+	} else {
+		logger.Warnf("%s", record)
+	}
+
+	auditCallbacksLock.RLock()
+	callbacks := auditCallbacks
+	auditCallbacksLock.RUnlock()
+	for _, cb := range callbacks {
+		cb(record)
+	}
+}
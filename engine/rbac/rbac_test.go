@@ -0,0 +1,54 @@
+package rbac
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+var testConfig = &config.RBACConfig{
+	Roles: map[string][]string{
+		"operator": {"admin:*", "gm:*"},
+		"support":  {"admin:stats", "call_service:MailService.*"},
+	},
+	Users: map[string]*config.RBACUser{
+		"alice": {Roles: []string{"operator"}, Token: "alice-token"},
+		"bob":   {Roles: []string{"support"}, Token: "bob-token"},
+		"carol": {Roles: []string{"support"}},
+	},
+}
+
+func TestAuthenticate(t *testing.T) {
+	if user, ok := authenticate(testConfig, "bob-token"); !ok || user != "bob" {
+		t.Errorf("bob-token should be authenticated as bob, but got %s, %v", user, ok)
+	}
+	if _, ok := authenticate(testConfig, "wrong-token"); ok {
+		t.Errorf("wrong token should not be authenticated")
+	}
+	if _, ok := authenticate(testConfig, ""); ok {
+		t.Errorf("users without tokens should not be authenticated by empty token")
+	}
+}
+
+func TestAllowed(t *testing.T) {
+	for _, c := range []struct {
+		user    string
+		action  string
+		allowed bool
+	}{
+		{"alice", "admin:drain", true},
+		{"alice", "gm:give_item", true},
+		{"alice", "call_entity:Pay", false},
+		{"Bob", "admin:stats", true},
+		{"bob", "admin:drain", false},
+		{"bob", "call_service:MailService.Send", true},
+		{"bob", "call_service:MailServiceX.Send", false},
+		{"carol", "admin:stats", true},
+		{"dave", "admin:stats", false},
+		{Superuser, "admin:terminate", true},
+	} {
+		if allowed(testConfig, c.user, c.action) != c.allowed {
+			t.Errorf("%s should be allowed to %s: %v", c.user, c.action, c.allowed)
+		}
+	}
+}
@@ -0,0 +1,79 @@
+package service
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/srvdis"
+)
+
+const (
+	handoffTimeout = time.Second * 30
+)
+
+var (
+	handingOffShards = map[string]time.Time{} // ShardName -> Deadline of shards migrating from this game
+	handedInShards   = common.StringSet{}     // shards migrated to this game, but not registered on dispatchers yet
+)
+
+// Handoff migrates all service entities on this game to the target game, e.g. before restarting this game
+//
+// Service entities are migrated with their states, calls to them are buffered by dispatchers during migration,
+// and service shards are registered to the target game once the service entities are migrated in.
+func Handoff(targetGame uint16) error {
+	if targetGame == gameid || targetGame < 1 || int(targetGame) > config.GetDeployment().DesiredGames {
+		return errors.Errorf("handoff services: invalid target game%d", targetGame)
+	}
+
+	for shardName, eid := range localShardEntityIDs {
+		if _, ok := handingOffShards[shardName]; ok {
+			continue
+		}
+		e := entity.GetEntity(eid)
+		if e == nil {
+			continue
+		}
+		if serviceName, _ := parseShardName(shardName); isWorkerService(serviceName) && !isWorkerGame(targetGame) {
+			// workers are stateless, and registered by other worker games after this game is gone
+			gwlog.Infof("service %s: game%d is not a worker game, %s is not handed off", shardName, targetGame, e)
+			continue
+		}
+
+		gwlog.Infof("service %s: handing off %s to game%d ...", shardName, e, targetGame)
+		handingOffShards[shardName] = time.Now().Add(handoffTimeout)
+		e.EnterSpace(entity.GetNilSpaceID(targetGame), entity.Vector3{})
+	}
+	return nil
+}
+
+// isHandingOff returns if the service shard is migrating to other game, and clears the shard if the handoff timed out
+func isHandingOff(shardName string) bool {
+	deadline, ok := handingOffShards[shardName]
+	if ok && time.Now().After(deadline) {
+		gwlog.Warnf("service %s: handoff timeout", shardName)
+		delete(handingOffShards, shardName)
+		return false
+	}
+	return ok
+}
+
+func onServiceMigratedIn(e *entity.Entity) {
+	for shardName, info := range knownServices {
+		if info.EntityID != e.ID {
+			continue
+		}
+
+		gwlog.Infof("service %s: %s is handed off to this game", shardName, e)
+		localShardEntityIDs[shardName] = e.ID
+		handedInShards.Add(shardName)
+		srvdis.Register(getSrvID(shardName), fmt.Sprintf("game%d", gameid), true)
+		return
+	}
+
+	gwlog.Warnf("service entity %s is migrated in, but it is not a service shard", e)
+}
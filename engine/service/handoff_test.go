@@ -0,0 +1,25 @@
+package service
+
+import (
+	"testing"
+	"time"
+)
+
+func TestIsHandingOff(t *testing.T) {
+	if isHandingOff("RankService#0") {
+		t.Fatalf("shard should not be handing off")
+	}
+
+	handingOffShards["RankService#0"] = time.Now().Add(time.Minute)
+	if !isHandingOff("RankService#0") {
+		t.Fatalf("shard should be handing off")
+	}
+
+	handingOffShards["RankService#0"] = time.Now().Add(-time.Second)
+	if isHandingOff("RankService#0") {
+		t.Fatalf("shard should not be handing off after timeout")
+	}
+	if _, ok := handingOffShards["RankService#0"]; ok {
+		t.Fatalf("shard should be cleared after handoff timeout")
+	}
+}
@@ -0,0 +1,141 @@
+package service
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/srvdis"
+)
+
+const (
+	healthCheckInterval = time.Second * 10
+	healthDetailSep     = ":"
+)
+
+// Health status of service shards
+const (
+	HealthUnknown   = "unknown"   // service entity is not created or has not reported health yet
+	HealthHealthy   = "healthy"   // service entity is healthy
+	HealthUnhealthy = "unhealthy" // service entity reported an error in OnHealthCheck
+	HealthLost      = "lost"      // the game of service entity is down, service entity is being re-created
+)
+
+// IHealthChecker can be implemented by service entities to report health status
+//
+// OnHealthCheck is called periodically on the game hosting the service entity. Service entities not implementing
+// IHealthChecker are always healthy when they are alive.
+type IHealthChecker interface {
+	OnHealthCheck() error
+}
+
+// ServiceInfo describes a service shard registered on dispatchers
+type ServiceInfo struct {
+	Name         string          `json:"name"`
+	ShardIndex   int             `json:"shard_index"`
+	ShardCount   int             `json:"shard_count"`
+	GameID       uint16          `json:"gameid"`
+	EntityID     common.EntityID `json:"entity_id"`
+	Health       string          `json:"health"`
+	HealthDetail string          `json:"health_detail,omitempty"`
+}
+
+// ListServices returns all service shards registered on dispatchers, sorted by name and shard index
+func ListServices() []ServiceInfo {
+	shardCounts := map[string]int{}
+	for shardName := range knownServices {
+		serviceName, shardIndex := parseShardName(shardName)
+		if shardIndex+1 > shardCounts[serviceName] {
+			shardCounts[serviceName] = shardIndex + 1
+		}
+	}
+
+	services := make([]ServiceInfo, 0, len(knownServices))
+	for shardName, info := range knownServices {
+		serviceName, shardIndex := parseShardName(shardName)
+		shardCount := shardCounts[serviceName]
+		if registeredShardCount, ok := registeredServices[serviceName]; ok {
+			shardCount = registeredShardCount
+		}
+
+		health, detail := parseHealth(info.Health)
+		if !info.Registered {
+			health, detail = HealthLost, ""
+		} else if info.EntityID.IsNil() {
+			health, detail = HealthUnknown, ""
+		}
+
+		services = append(services, ServiceInfo{
+			Name:         serviceName,
+			ShardIndex:   shardIndex,
+			ShardCount:   shardCount,
+			GameID:       info.GameID,
+			EntityID:     info.EntityID,
+			Health:       health,
+			HealthDetail: detail,
+		})
+	}
+
+	sort.Slice(services, func(i, j int) bool {
+		if services[i].Name != services[j].Name {
+			return services[i].Name < services[j].Name
+		}
+		return services[i].ShardIndex < services[j].ShardIndex
+	})
+	return services
+}
+
+// checkServicesHealth checks health of local service entities and registers changed health to dispatchers
+func checkServicesHealth() {
+	for shardName, eid := range localShardEntityIDs {
+		e := entity.GetEntity(eid)
+		if e == nil {
+			continue
+		}
+
+		health := checkEntityHealth(e)
+		if info := knownServices[shardName]; info != nil && info.Health == health {
+			continue
+		}
+
+		gwlog.Infof("service %s: health is %s", shardName, health)
+		srvdis.Register(getSrvID(shardName)+"/Health", health, true)
+	}
+}
+
+func checkEntityHealth(e *entity.Entity) (health string) {
+	checker, ok := e.I.(IHealthChecker)
+	if !ok {
+		return HealthHealthy
+	}
+
+	var err error
+	if panicErr := gwutils.CatchPanic(func() {
+		err = checker.OnHealthCheck()
+	}); panicErr != nil {
+		err = fmt.Errorf("OnHealthCheck panic: %v", panicErr)
+	}
+
+	if err != nil {
+		return HealthUnhealthy + healthDetailSep + err.Error()
+	}
+	return HealthHealthy
+}
+
+// parseHealth parses the health registered in srvdis: Status[:Detail]
+func parseHealth(health string) (string, string) {
+	if health == "" {
+		return HealthUnknown, ""
+	}
+
+	sep := strings.Index(health, healthDetailSep)
+	if sep < 0 {
+		return health, ""
+	}
+	return health[:sep], health[sep+1:]
+}
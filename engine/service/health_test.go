@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func TestParseHealth(t *testing.T) {
+	if health, detail := parseHealth(""); health != HealthUnknown || detail != "" {
+		t.Fatalf("empty health should be unknown, but is %s, %s", health, detail)
+	}
+	if health, detail := parseHealth(HealthHealthy); health != HealthHealthy || detail != "" {
+		t.Fatalf("parse healthy failed: %s, %s", health, detail)
+	}
+	if health, detail := parseHealth(HealthUnhealthy + ":db: timeout"); health != HealthUnhealthy || detail != "db: timeout" {
+		t.Fatalf("parse unhealthy failed: %s, %s", health, detail)
+	}
+}
+
+func TestListServices(t *testing.T) {
+	defer func(services map[string]*serviceInfo) {
+		knownServices = services
+	}(knownServices)
+
+	knownServices = map[string]*serviceInfo{
+		"RankService#1": {Registered: true, GameID: 2, EntityID: common.GenEntityID(), Health: HealthHealthy},
+		"RankService#0": {Registered: false, GameID: 0, EntityID: common.GenEntityID(), Health: HealthHealthy},
+		"MailService":   {Registered: true, GameID: 1, EntityID: common.GenEntityID(), Health: HealthUnhealthy + ":full"},
+		"OnlineService": {Registered: true, GameID: 1},
+		"RankService#3": {Registered: true, GameID: 1, EntityID: common.GenEntityID()},
+	}
+
+	services := ListServices()
+	if len(services) != 5 {
+		t.Fatalf("should list 5 services, but is %d", len(services))
+	}
+
+	expected := []struct {
+		name       string
+		shardIndex int
+		shardCount int
+		health     string
+	}{
+		{"MailService", 0, 1, HealthUnhealthy},
+		{"OnlineService", 0, 1, HealthUnknown},
+		{"RankService", 0, 4, HealthLost},
+		{"RankService", 1, 4, HealthHealthy},
+		{"RankService", 3, 4, HealthUnknown},
+	}
+	for i, e := range expected {
+		s := services[i]
+		if s.Name != e.name || s.ShardIndex != e.shardIndex || s.ShardCount != e.shardCount || s.Health != e.health {
+			t.Errorf("service %d should be %+v, but is %+v", i, e, s)
+		}
+	}
+	if services[0].HealthDetail != "full" {
+		t.Errorf("health detail should be full, but is %s", services[0].HealthDetail)
+	}
+}
@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goTimer"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+)
+
+const (
+	defaultRequestTimeout = time.Second * 10
+)
+
+// Response codes of service requests
+const (
+	responseOK             = byte(0)
+	responseMethodError    = byte(1) // the method returned an error or paniced
+	responseEntityNotFound = byte(2) // the service entity is not on the game, might be moved or destroyed
+)
+
+var (
+	// ErrServiceNotFound is returned if the service entity is not created yet
+	ErrServiceNotFound = errors.New("service not found")
+	// ErrServiceTimeout is returned if the service does not respond before the deadline
+	ErrServiceTimeout = errors.New("service request timeout")
+	// ErrServiceLost is returned if the service entity is lost before responding
+	ErrServiceLost = errors.New("service entity lost")
+)
+
+// ServiceMethodError is returned if the service method returns an error
+type ServiceMethodError struct {
+	Service string
+	Method  string
+	Message string
+}
+
+func (err *ServiceMethodError) Error() string {
+	return fmt.Sprintf("service %s.%s failed: %s", err.Service, err.Method, err.Message)
+}
+
+// ServiceRequestOptions are options of service requests
+type ServiceRequestOptions struct {
+	Timeout    time.Duration // deadline of each attempt, defaults to 10 seconds
+	Retries    int           // max number of retries on failures other than ServiceMethodError
+	Idempotent bool          // only idempotent methods are retried, since the method might be called more than once
+	ShardKey   string        // send the request to the shard of key if not empty, and retry on the same shard
+	// Context cancels the request or limits its deadline if not nil, the callback is dropped if the context is canceled
+	Context context.Context
+}
+
+// ServiceRequestCallback is called with the result of the service method, or the error if the request failed
+type ServiceRequestCallback func(result interface{}, err error)
+
+type serviceRequest struct {
+	serviceName string
+	method      string
+	args        []interface{}
+	opts        ServiceRequestOptions
+	callback    ServiceRequestCallback
+	shardCount  int
+	shardIndex  int
+	attempts    int
+	eid         common.EntityID // the service entity of the current attempt
+	timer       *timer.Timer
+}
+
+var (
+	pendingRequests = map[uint32]*serviceRequest{} // Request ID -> Request
+	lastRequestID   uint32
+)
+
+// CallServiceRequest calls the service method and calls back with its result
+//
+// The result is the first return value of the method which is not an error, and the last error return value of the
+// method is returned as ServiceMethodError. If the request times out or the service is not available,
+// idempotent requests are retried on a different shard if the service is sharded and ShardKey is not specified.
+func CallServiceRequest(serviceName string, method string, args []interface{}, opts ServiceRequestOptions, cb ServiceRequestCallback) {
+	if opts.Timeout <= 0 {
+		opts.Timeout = defaultRequestTimeout
+	}
+
+	req := &serviceRequest{
+		serviceName: serviceName,
+		method:      method,
+		args:        args,
+		opts:        opts,
+		callback:    cb,
+		shardCount:  GetServiceShardCount(serviceName),
+	}
+	if opts.ShardKey != "" {
+		req.shardIndex = getShardIndexByKey(opts.ShardKey, req.shardCount)
+	} else if isWorkerService(serviceName) {
+		req.shardIndex = selectWorker(serviceName, req.shardCount)
+	} else if req.shardCount > 1 {
+		req.shardIndex = rand.Intn(req.shardCount)
+	}
+	sendServiceRequest(req)
+}
+
+func sendServiceRequest(req *serviceRequest) {
+	if err := req.contextErr(); err != nil {
+		req.finish(nil, err)
+		return
+	}
+
+	req.attempts += 1
+	shardName := getShardName(req.serviceName, req.shardCount, req.shardIndex)
+	req.eid = serviceMap[shardName]
+	if req.eid.IsNil() {
+		onServiceRequestFailed(req, ErrServiceNotFound)
+		return
+	}
+
+	lastRequestID += 1
+	requestID := lastRequestID
+	pendingRequests[requestID] = req
+	timeout := req.opts.Timeout
+	if req.opts.Context != nil {
+		if deadline, ok := req.opts.Context.Deadline(); ok && time.Until(deadline) < timeout {
+			timeout = time.Until(deadline)
+		}
+	}
+	req.timer = timer.AddCallback(timeout, func() {
+		if pendingRequests[requestID] == req {
+			delete(pendingRequests, requestID)
+			onServiceRequestFailed(req, ErrServiceTimeout)
+		}
+	})
+
+	if consts.OPTIMIZE_LOCAL_ENTITY_CALL && entity.GetEntity(req.eid) != nil {
+		// the service entity is local, call it directly
+		post.Post(func() {
+			result, err := entity.CallRequestLocally(req.eid, req.method, req.args)
+			code, errmsg := makeServiceResponseCode(err)
+			if err == nil {
+				// convert the result as if it is sent through network, so that results are of the same types
+				result, err = convertLocalResult(result)
+				if err != nil {
+					code, errmsg = responseMethodError, err.Error()
+				}
+			}
+			OnServiceResponse(requestID, code, errmsg, result)
+		})
+	} else {
+		dispatchercluster.SendCallServiceRequest(req.eid, req.method, gameid, requestID, req.args)
+	}
+}
+
+// onServiceRequestFailed retries the request on a different shard if possible, or fails the request
+func onServiceRequestFailed(req *serviceRequest, err error) {
+	if ctxErr := req.contextErr(); ctxErr != nil {
+		err = ctxErr
+	} else if _, ok := err.(*ServiceMethodError); !ok && req.opts.Idempotent && req.attempts <= req.opts.Retries {
+		gwlog.Warnf("CallServiceRequest %s.%s: attempt %d failed: %s, retrying ...", req.serviceName, req.method, req.attempts, err)
+		if req.shardCount > 1 && req.opts.ShardKey == "" {
+			// choose any shard except the failed one
+			req.shardIndex = (req.shardIndex + 1 + rand.Intn(req.shardCount-1)) % req.shardCount
+		}
+		sendServiceRequest(req)
+		return
+	}
+
+	req.finish(nil, err)
+}
+
+func (req *serviceRequest) contextErr() error {
+	if req.opts.Context == nil {
+		return nil
+	}
+	return req.opts.Context.Err()
+}
+
+// finish calls back with the result of request, unless the context of request is canceled
+func (req *serviceRequest) finish(result interface{}, err error) {
+	if req.opts.Context != nil && post.IsCanceled(req.opts.Context) {
+		return
+	}
+	req.callback(result, err)
+}
+
+// OnServiceRequest is called when the service request reaches the game of service entity
+func OnServiceRequest(eid common.EntityID, method string, callerGameID uint16, requestID uint32, args [][]byte) {
+	result, err := entity.OnCallRequest(eid, method, args)
+	code, errmsg := makeServiceResponseCode(err)
+	dispatchercluster.SendServiceResponse(eid, callerGameID, requestID, code, errmsg, result)
+}
+
+func makeServiceResponseCode(err error) (byte, string) {
+	if err == nil {
+		return responseOK, ""
+	} else if err == entity.ErrEntityNotFound {
+		return responseEntityNotFound, err.Error()
+	} else {
+		return responseMethodError, err.Error()
+	}
+}
+
+func convertLocalResult(result interface{}) (interface{}, error) {
+	data, err := netutil.MSG_PACKER.PackMsg(result, nil)
+	if err != nil {
+		return nil, errors.Wrap(err, "pack result failed")
+	}
+
+	var converted interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(data, &converted); err != nil {
+		return nil, errors.Wrap(err, "unpack result failed")
+	}
+	return converted, nil
+}
+
+// OnServiceResponse is called when the response of service request is received
+func OnServiceResponse(requestID uint32, code byte, errmsg string, result interface{}) {
+	req := pendingRequests[requestID]
+	if req == nil {
+		// the request is already timeout
+		return
+	}
+
+	delete(pendingRequests, requestID)
+	req.timer.Cancel()
+	switch code {
+	case responseOK:
+		req.finish(result, nil)
+	case responseEntityNotFound:
+		onServiceRequestFailed(req, ErrServiceLost)
+	default:
+		onServiceRequestFailed(req, &ServiceMethodError{Service: req.serviceName, Method: req.method, Message: errmsg})
+	}
+}
+
+// failServiceRequestToLostEntity fails the earliest pending request to the lost service entity
+func failServiceRequestToLostEntity(eid common.EntityID, method string) bool {
+	var requestID uint32
+	var req *serviceRequest
+	for id, r := range pendingRequests {
+		if r.eid == eid && r.method == method && (req == nil || id < requestID) {
+			requestID, req = id, r
+		}
+	}
+	if req == nil {
+		return false
+	}
+
+	delete(pendingRequests, requestID)
+	req.timer.Cancel()
+	onServiceRequestFailed(req, ErrServiceLost)
+	return true
+}
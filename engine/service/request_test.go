@@ -0,0 +1,95 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	"github.com/pkg/errors"
+)
+
+func TestServiceRequestRetry(t *testing.T) {
+	var callbackErr error
+	callbacks := 0
+	req := &serviceRequest{
+		serviceName: "NotExistService",
+		method:      "Test",
+		opts:        ServiceRequestOptions{Retries: 2, Idempotent: true},
+		callback: func(result interface{}, err error) {
+			callbacks += 1
+			callbackErr = err
+		},
+		shardCount: 4,
+	}
+	sendServiceRequest(req)
+	if callbacks != 1 || callbackErr != ErrServiceNotFound || req.attempts != 3 {
+		t.Fatalf("idempotent request should be tried 3 times: callbacks=%d, err=%v, attempts=%d", callbacks, callbackErr, req.attempts)
+	}
+
+	req.attempts, callbacks = 0, 0
+	req.opts.Idempotent = false
+	sendServiceRequest(req)
+	if callbacks != 1 || req.attempts != 1 {
+		t.Fatalf("non-idempotent request should not be retried: callbacks=%d, attempts=%d", callbacks, req.attempts)
+	}
+
+	req.attempts, callbacks = 1, 0
+	req.opts.Idempotent = true
+	onServiceRequestFailed(req, &ServiceMethodError{Service: req.serviceName, Method: req.method, Message: "failed"})
+	if _, ok := callbackErr.(*ServiceMethodError); callbacks != 1 || !ok || req.attempts != 1 {
+		t.Fatalf("request should not be retried if the method failed: callbacks=%d, err=%v, attempts=%d", callbacks, callbackErr, req.attempts)
+	}
+}
+
+func TestServiceRequestRetryShardKey(t *testing.T) {
+	req := &serviceRequest{
+		serviceName: "NotExistService",
+		method:      "Test",
+		opts:        ServiceRequestOptions{Retries: 3, Idempotent: true, ShardKey: "key"},
+		callback:    func(result interface{}, err error) {},
+		shardCount:  4,
+		shardIndex:  getShardIndexByKey("key", 4),
+	}
+	shardIndex := req.shardIndex
+	sendServiceRequest(req)
+	if req.attempts != 4 || req.shardIndex != shardIndex {
+		t.Fatalf("request with shard key should be retried on the same shard: attempts=%d, shard=%d, expected shard=%d", req.attempts, req.shardIndex, shardIndex)
+	}
+}
+
+func TestServiceRequestContext(t *testing.T) {
+	var callbackErr error
+	callbacks := 0
+	ctx, cancel := context.WithTimeout(context.Background(), 0)
+	defer cancel()
+	req := &serviceRequest{
+		serviceName: "NotExistService",
+		method:      "Test",
+		opts:        ServiceRequestOptions{Retries: 2, Idempotent: true, Context: ctx},
+		callback: func(result interface{}, err error) {
+			callbacks += 1
+			callbackErr = err
+		},
+		shardCount: 1,
+	}
+	sendServiceRequest(req)
+	if callbacks != 1 || callbackErr != context.DeadlineExceeded || req.attempts != 0 {
+		t.Fatalf("request should fail before the first attempt if the deadline is exceeded: callbacks=%d, err=%v, attempts=%d", callbacks, callbackErr, req.attempts)
+	}
+
+	ctx, cancel = context.WithCancel(context.Background())
+	req.opts.Context, req.attempts, callbacks = ctx, 0, 0
+	cancel()
+	sendServiceRequest(req)
+	if callbacks != 0 || req.attempts != 0 {
+		t.Fatalf("callback of canceled request should be dropped: callbacks=%d, attempts=%d", callbacks, req.attempts)
+	}
+}
+
+func TestMakeServiceResponseCode(t *testing.T) {
+	if code, errmsg := makeServiceResponseCode(nil); code != responseOK || errmsg != "" {
+		t.Fatalf("wrong response code of nil error: %d, %s", code, errmsg)
+	}
+	if code, errmsg := makeServiceResponseCode(errors.New("failed")); code != responseMethodError || errmsg != "failed" {
+		t.Fatalf("wrong response code of method error: %d, %s", code, errmsg)
+	}
+}
@@ -13,6 +13,7 @@ import (
 	"github.com/pkg/errors"
 	"github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
 	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/gwvar"
@@ -25,33 +26,59 @@ const (
 	serviceSrvdisPrefix     = "Service/"
 	serviceSrvdisPrefixLen  = len(serviceSrvdisPrefix)
 	checkServicesLaterDelay = time.Millisecond * 200
+	// preferredGameRegisterAdvance is how much earlier the preferred game registers a service shard than other games
+	preferredGameRegisterAdvance = time.Millisecond * 100
 )
 
 var (
-	registeredServices = common.StringSet{}
-	gameid             uint16
-	serviceMap         = map[string]common.EntityID{} // ServiceName -> Entity ID
-	checkTimer         *timer.Timer
+	registeredServices  = map[string]int{} // ServiceName -> Shard Count
+	gameid              uint16
+	serviceMap          = map[string]common.EntityID{} // ShardName -> Entity ID
+	localShardEntityIDs = map[string]common.EntityID{} // ShardName -> Entity ID of shards on this game
+	knownServices       = map[string]*serviceInfo{}    // ShardName -> Service info registered on dispatchers
+	checkTimer          *timer.Timer
+	callFailedCallback  ServiceCallFailedCallback
 )
 
+// ServiceCallFailedCallback is called when a call to the service is failed because the service entity is lost
+type ServiceCallFailedCallback func(serviceName string, shardIndex int, method string)
+
 func RegisterService(typeName string, entityPtr entity.IEntity) {
+	RegisterServiceSharded(typeName, entityPtr, 1)
+}
+
+// RegisterServiceSharded registers a service type with multiple shards
+//
+// Each shard is a service entity, shards are distributed across games
+func RegisterServiceSharded(typeName string, entityPtr entity.IEntity, shardCount int) {
+	if shardCount < 1 {
+		gwlog.Panicf("service %s: shard count should be positive, but is %d", typeName, shardCount)
+	}
+	if strings.Contains(typeName, shardNameSeparator) {
+		gwlog.Panicf("service %s: name should not contain %s", typeName, shardNameSeparator)
+	}
+
 	entity.RegisterEntity(typeName, entityPtr, true)
-	registeredServices.Add(typeName)
+	registeredServices[typeName] = shardCount
 }
 
 func Setup(gameid_ uint16) {
 	gameid = gameid_
 	srvdis.AddPostCallback(checkServicesLater)
+	entity.SetServiceMigratedInHandler(onServiceMigratedIn)
 }
 
 func OnDeploymentReady() {
 	timer.AddTimer(checkServicesInterval, checkServices)
+	timer.AddTimer(healthCheckInterval, checkServicesHealth)
 	checkServicesLater()
 }
 
 type serviceInfo struct {
 	Registered bool
+	GameID     uint16
 	EntityID   common.EntityID
+	Health     string
 }
 
 func checkServicesLater() {
@@ -69,15 +96,15 @@ func checkServices() {
 		return
 	}
 	gwlog.Infof("service: checking services ...")
-	dispRegisteredServices := map[string]*serviceInfo{} // all services that are registered on dispatchers
-	needLocalServiceEntities := common.StringSet{}
+	dispRegisteredServices := map[string]*serviceInfo{} // all service shards that are registered on dispatchers
+	needLocalServiceShards := common.StringSet{}
 	newServiceMap := make(map[string]common.EntityID, len(registeredServices))
 
-	getServiceInfo := func(serviceName string) *serviceInfo {
-		info := dispRegisteredServices[serviceName]
+	getServiceInfo := func(shardName string) *serviceInfo {
+		info := dispRegisteredServices[shardName]
 		if info == nil {
 			info = &serviceInfo{}
-			dispRegisteredServices[serviceName] = info
+			dispRegisteredServices[shardName] = info
 		}
 		return info
 	}
@@ -87,25 +114,28 @@ func checkServices() {
 		//gwlog.Infof("service: found service %v = %+v", servicePath, srvinfo)
 
 		if len(servicePath) == 1 {
-			// ServiceName = gameX
-			serviceName := servicePath[0]
+			// ShardName = gameX
+			shardName := servicePath[0]
 			targetGameID, err := strconv.Atoi(srvinfo[4:])
 			if err != nil {
 				gwlog.Panic(errors.Wrap(err, "parse targetGameID failed"))
 			}
 			// XxxService = gameX
-			getServiceInfo(serviceName).Registered = true
+			getServiceInfo(shardName).Registered = true
+			getServiceInfo(shardName).GameID = uint16(targetGameID)
 
 			if int(gameid) == targetGameID {
-				needLocalServiceEntities.Add(serviceName)
+				needLocalServiceShards.Add(shardName)
 			}
 		} else if len(servicePath) == 2 {
-			// ServiceName/EntityID = Xxxx
-			serviceName := servicePath[0]
+			// ShardName/EntityID = Xxxx
+			shardName := servicePath[0]
 			fieldName := servicePath[1]
 			switch fieldName {
 			case "EntityID":
-				getServiceInfo(serviceName).EntityID = common.EntityID(srvinfo)
+				getServiceInfo(shardName).EntityID = common.EntityID(srvinfo)
+			case "Health":
+				getServiceInfo(shardName).Health = srvinfo
 			default:
 				gwlog.Warnf("unknown srvdis info: %s = %s", srvid, srvinfo)
 			}
@@ -114,63 +144,113 @@ func checkServices() {
 		}
 	})
 
-	for serviceName, info := range dispRegisteredServices {
-		if info.Registered && !info.EntityID.IsNil() {
-			newServiceMap[serviceName] = info.EntityID
+	for shardName, info := range dispRegisteredServices {
+		// the entity ID is kept when the game of service is down, so that calls are kept by dispatcher during failover
+		if !info.EntityID.IsNil() {
+			newServiceMap[shardName] = info.EntityID
 		}
 	}
 	serviceMap = newServiceMap
+	knownServices = dispRegisteredServices
+
+	// forget all shards that is on this game, but is not verified by dispatcher
+	for shardName := range localShardEntityIDs {
+		if needLocalServiceShards.Contains(shardName) {
+			handedInShards.Remove(shardName)
+		} else if !handedInShards.Contains(shardName) {
+			delete(localShardEntityIDs, shardName)
+			delete(handingOffShards, shardName) // handoff is done
+		}
+	}
+	// adopt local service entities that are registered on dispatchers (e.g. restored after game reloading)
+	for shardName := range needLocalServiceShards {
+		if _, ok := localShardEntityIDs[shardName]; ok {
+			continue
+		}
+		if eid := getServiceInfo(shardName).EntityID; !eid.IsNil() && entity.GetEntity(eid) != nil {
+			localShardEntityIDs[shardName] = eid
+		}
+	}
 
-	// destroy all service entities that is on this game, but is not verified by dispatcher
+	// destroy all service entities that is on this game, but is not a verified shard
+	localEntityIDs := common.EntityIDSet{}
+	for _, eid := range localShardEntityIDs {
+		localEntityIDs.Add(eid)
+	}
 	for serviceName := range registeredServices {
-		if !needLocalServiceEntities.Contains(serviceName) {
-			// this service should not be local
-			serviceEntities := entity.GetEntitiesByType(serviceName)
-			for _, e := range serviceEntities {
+		serviceEntities := entity.GetEntitiesByType(serviceName)
+		for _, e := range serviceEntities {
+			if !localEntityIDs.Contains(e.ID) {
 				e.Destroy()
 			}
 		}
 	}
 
-	// create all service entities that should be created on this game
-	for serviceName := range needLocalServiceEntities {
-		serviceEntities := entity.GetEntitiesByType(serviceName)
-		if len(serviceEntities) == 0 {
-			createServiceEntity(serviceName)
-		} else if len(serviceEntities) == 1 {
-			// make sure the current service entity is the
-			localEid := serviceEntities.Keys()[0]
-			//gwlog.Infof("service %s: found service entity: %s, service info: %+v", serviceName, serviceEntities.Values()[0], getServiceInfo(serviceName))
-			if localEid != getServiceInfo(serviceName).EntityID {
-				// might happen if dispatchers recover from crash
-				gwlog.Warnf("service %s: local entity is %s, but has %s on dispatchers", serviceName, localEid, getServiceInfo(serviceName).EntityID)
-				srvdis.Register(getSrvID(serviceName)+"/EntityID", string(localEid), true)
-			}
-		} else {
-			// multiple service entities ? should never happen! so just destroy all invalid service entities
-			correctEid := getServiceInfo(serviceName).EntityID
-			for _, e := range serviceEntities {
-				if e.ID != correctEid {
-					e.Destroy()
-				}
-			}
+	// create all service shards that should be created on this game
+	for shardName := range needLocalServiceShards {
+		serviceName, _ := parseShardName(shardName)
+		if _, ok := registeredServices[serviceName]; !ok {
+			gwlog.Errorf("service %s: should be created on this game, but is not registered", serviceName)
+			continue
+		}
+
+		if isHandingOff(shardName) {
+			// the shard is migrating to other game
+			continue
+		}
+
+		localEid := localShardEntityIDs[shardName]
+		if localEid.IsNil() || entity.GetEntity(localEid) == nil {
+			createServiceEntity(serviceName, shardName)
+		} else if localEid != getServiceInfo(shardName).EntityID {
+			// might happen if dispatchers recover from crash
+			gwlog.Warnf("service %s: local entity is %s, but has %s on dispatchers", shardName, localEid, getServiceInfo(shardName).EntityID)
+			srvdis.Register(getSrvID(shardName)+"/EntityID", string(localEid), true)
 		}
 	}
 
-	// register all service types that are not registered to dispatcher yet
-	for serviceName := range registeredServices {
-		if !getServiceInfo(serviceName).Registered {
-			gwlog.Warnf("service: %s not found, registering srvdis ...", serviceName)
+	// let dispatchers keep calls to local service entities if this game is down
+	for shardName, eid := range localShardEntityIDs {
+		if entity.GetEntity(eid) != nil {
+			dispatchercluster.SendSuperviseServiceEntity(eid, shardName)
+		}
+	}
+	checkServicesHealth()
+
+	// register all service shards that are not registered to dispatcher yet
+	for serviceName, shardCount := range registeredServices {
+		isWorker := isWorkerService(serviceName)
+		if isWorker && !isWorkerGame(gameid) {
+			// workers are only registered by worker games
+			continue
+		}
+
+		for shardIndex := 0; shardIndex < shardCount; shardIndex++ {
+			shardName := getShardName(serviceName, shardCount, shardIndex)
+			if getServiceInfo(shardName).Registered {
+				continue
+			}
+
+			gwlog.Warnf("service: %s not found, registering srvdis ...", shardName)
 			// delay for a random time so that each game might register servcie randomly
 			randomDelay := time.Millisecond * time.Duration(rand.Intn(100))
-			_serviceName := serviceName
+			preferredGameID := getPreferredGameID(shardIndex)
+			if isWorker {
+				preferredGameID = getPreferredWorkerGameID(shardIndex)
+			}
+			if shardCount > 1 && preferredGameID != gameid {
+				// the preferred game registers the shard first, so that shards are distributed across games
+				randomDelay += preferredGameRegisterAdvance
+			}
+			_shardName := shardName
 			timer.AddCallback(randomDelay, func() {
-				srvdis.Register(getSrvID(_serviceName), fmt.Sprintf("game%d", gameid), false)
+				srvdis.Register(getSrvID(_shardName), fmt.Sprintf("game%d", gameid), false)
 			})
 		}
 	}
 }
-func createServiceEntity(serviceName string) {
+
+func createServiceEntity(serviceName string, shardName string) {
 	desc := entity.GetEntityTypeDesc(serviceName)
 	if desc == nil {
 		gwlog.Panicf("create service entity locally failed: service %s is not registered", serviceName)
@@ -178,10 +258,13 @@ func createServiceEntity(serviceName string) {
 
 	if !desc.IsPersistent {
 		e := entity.CreateEntityLocally(serviceName, nil)
-		gwlog.Infof("Created service entity: %s: %s", serviceName, e)
-		srvdis.Register(getSrvID(serviceName)+"/EntityID", string(e.ID), true)
-	} else {
+		gwlog.Infof("Created service entity: %s: %s", shardName, e)
+		localShardEntityIDs[shardName] = e.ID
+		srvdis.Register(getSrvID(shardName)+"/EntityID", string(e.ID), true)
+	} else if registeredServices[serviceName] == 1 {
 		createPersistentServiceEntity(serviceName)
+	} else {
+		createPersistentServiceShardEntity(serviceName, shardName)
 	}
 }
 
@@ -208,24 +291,118 @@ func createPersistentServiceEntity(serviceName string) {
 			gwlog.Infof("Loading service entity: %s: %s", serviceName, eid)
 		}
 
+		localShardEntityIDs[serviceName] = eid
 		srvdis.Register(getSrvID(serviceName)+"/EntityID", string(eid), true)
 	})
 }
 
+// createPersistentServiceShardEntity creates or loads the shard entity, which has a fixed entity ID for each shard
+func createPersistentServiceShardEntity(serviceName string, shardName string) {
+	eid := getShardEntityID(shardName)
+	storage.Exists(serviceName, eid, func(exists bool, err error) {
+		if err != nil {
+			gwlog.Panic(errors.Wrap(err, "storage.Exists failed"))
+		}
+
+		if entity.GetEntity(eid) != nil {
+			// shard exists now
+			gwlog.Warnf("Was creating service %s, but found existing: %s", shardName, eid)
+			return
+		}
+
+		if !exists {
+			entity.CreateEntityLocallyWithID(serviceName, nil, eid)
+			gwlog.Infof("Created service entity: %s: %s", shardName, eid)
+		} else {
+			// try to load entity on the current game, but we need to tell dispatcher first
+			entity.LoadEntityOnGame(serviceName, eid, gameid)
+			gwlog.Infof("Loading service entity: %s: %s", shardName, eid)
+		}
+
+		localShardEntityIDs[shardName] = eid
+		srvdis.Register(getSrvID(shardName)+"/EntityID", string(eid), true)
+	})
+}
+
 func getSrvID(serviceName string) string {
 	return serviceSrvdisPrefix + serviceName
 }
 
+// CallService calls the service entity
+//
+// A random shard is called if the service is sharded, and calls are balanced among workers if it is a worker service.
 func CallService(serviceName string, method string, args []interface{}) {
-	serviceEid := serviceMap[serviceName]
+	shardCount := GetServiceShardCount(serviceName)
+	shardIndex := 0
+	if isWorkerService(serviceName) {
+		shardIndex = selectWorker(serviceName, shardCount)
+	} else if shardCount > 1 {
+		shardIndex = rand.Intn(shardCount)
+	}
+	callServiceShard(serviceName, shardCount, shardIndex, method, args)
+}
+
+// CallServiceShardKey calls the shard of service selected by the shard key
+//
+// Calls with the same shard key are always routed to the same shard
+func CallServiceShardKey(serviceName string, shardKey string, method string, args []interface{}) {
+	shardCount := GetServiceShardCount(serviceName)
+	callServiceShard(serviceName, shardCount, getShardIndexByKey(shardKey, shardCount), method, args)
+}
+
+// CallServiceShardIndex calls the specified shard of service
+func CallServiceShardIndex(serviceName string, shardIndex int, method string, args []interface{}) {
+	shardCount := GetServiceShardCount(serviceName)
+	if shardIndex < 0 || shardIndex >= shardCount {
+		gwlog.Errorf("CallService %s.%s: shard index %d out of range [0, %d)", serviceName, method, shardIndex, shardCount)
+		return
+	}
+	callServiceShard(serviceName, shardCount, shardIndex, method, args)
+}
+
+func callServiceShard(serviceName string, shardCount int, shardIndex int, method string, args []interface{}) {
+	shardName := getShardName(serviceName, shardCount, shardIndex)
+	serviceEid := serviceMap[shardName]
 	if serviceEid.IsNil() {
-		gwlog.Errorf("CallService %s.%s: service entity is not created yet!", serviceName, method)
+		gwlog.Errorf("CallService %s.%s: service entity is not created yet!", shardName, method)
 		return
 	}
 
 	entity.Call(serviceEid, method, args)
 }
 
+// GetServiceEntityID returns the entity ID of the service, or the first shard if the service is sharded
 func GetServiceEntityID(serviceName string) common.EntityID {
-	return serviceMap[serviceName]
+	return GetServiceShardEntityID(serviceName, 0)
+}
+
+// GetServiceShardEntityID returns the entity ID of the specified shard of service
+func GetServiceShardEntityID(serviceName string, shardIndex int) common.EntityID {
+	return serviceMap[getShardName(serviceName, GetServiceShardCount(serviceName), shardIndex)]
+}
+
+// GetServiceShardCount returns the shard count of service, which is 1 if the service is not sharded
+func GetServiceShardCount(serviceName string) int {
+	if shardCount, ok := registeredServices[serviceName]; ok {
+		return shardCount
+	}
+	return 1 // service not registered on this game is called as a non-sharded service
+}
+
+// SetServiceCallFailedCallback sets the callback which is called when a call to the service is failed
+func SetServiceCallFailedCallback(cb ServiceCallFailedCallback) {
+	callFailedCallback = cb
+}
+
+// OnServiceCallFailed is called when dispatcher fails the call to a lost service entity
+func OnServiceCallFailed(shardName string, eid common.EntityID, method string) {
+	if failServiceRequestToLostEntity(eid, method) {
+		return
+	}
+
+	serviceName, shardIndex := parseShardName(shardName)
+	gwlog.Errorf("CallService %s.%s: service entity %s is lost, call failed", shardName, method, eid)
+	if callFailedCallback != nil {
+		callFailedCallback(serviceName, shardIndex, method)
+	}
 }
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"crypto/md5"
+	"hash/fnv"
+	"strconv"
+	"strings"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/uuid"
+)
+
+const (
+	shardNameSeparator = "#"
+)
+
+// getShardName returns the name of service shard registered in srvdis: ServiceName#ShardIndex
+//
+// Non-sharded services use the service name, which is compatible with services registered before sharding.
+func getShardName(serviceName string, shardCount int, shardIndex int) string {
+	if shardCount <= 1 {
+		return serviceName
+	}
+	return serviceName + shardNameSeparator + strconv.Itoa(shardIndex)
+}
+
+// parseShardName returns the service name and shard index of the shard name
+func parseShardName(shardName string) (string, int) {
+	sep := strings.LastIndex(shardName, shardNameSeparator)
+	if sep < 0 {
+		return shardName, 0
+	}
+
+	shardIndex, err := strconv.Atoi(shardName[sep+1:])
+	if err != nil {
+		return shardName, 0
+	}
+	return shardName[:sep], shardIndex
+}
+
+// getShardIndexByKey maps the shard key to a shard using jump consistent hash
+//
+// Only about 1/n of keys are moved to other shards when the shard count grows to n.
+func getShardIndexByKey(shardKey string, shardCount int) int {
+	h := fnv.New64a()
+	h.Write([]byte(shardKey))
+	return jumpConsistentHash(h.Sum64(), shardCount)
+}
+
+// jumpConsistentHash implements "A Fast, Minimal Memory, Consistent Hash Algorithm" by Lamping and Veach
+func jumpConsistentHash(key uint64, numBuckets int) int {
+	var b, j int64 = -1, 0
+	for j < int64(numBuckets) {
+		b = j
+		key = key*2862933555777941757 + 1
+		j = int64(float64(b+1) * (float64(int64(1)<<31) / float64((key>>33)+1)))
+	}
+	return int(b)
+}
+
+// getShardEntityID returns the fixed entity ID of the persistent service shard
+func getShardEntityID(shardName string) common.EntityID {
+	sum := md5.Sum([]byte(shardName))
+	return common.EntityID(uuid.GenFixedUUID(sum[:]))
+}
+
+// getPreferredGameID returns the game which should host the shard, so that shards are distributed across games
+func getPreferredGameID(shardIndex int) uint16 {
+	return uint16(shardIndex%config.GetDeployment().DesiredGames + 1)
+}
@@ -0,0 +1,68 @@
+package service
+
+import (
+	"strconv"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func TestShardName(t *testing.T) {
+	if name := getShardName("RankService", 1, 0); name != "RankService" {
+		t.Fatalf("non-sharded service should use service name, but is %s", name)
+	}
+
+	name := getShardName("RankService", 4, 3)
+	serviceName, shardIndex := parseShardName(name)
+	if serviceName != "RankService" || shardIndex != 3 {
+		t.Fatalf("parse shard name %s failed: %s, %d", name, serviceName, shardIndex)
+	}
+
+	serviceName, shardIndex = parseShardName("RankService")
+	if serviceName != "RankService" || shardIndex != 0 {
+		t.Fatalf("parse non-sharded service name failed: %s, %d", serviceName, shardIndex)
+	}
+}
+
+func TestShardIndexByKey(t *testing.T) {
+	const shardCount = 8
+	counts := make([]int, shardCount)
+	moved := 0
+	for i := 0; i < 10000; i++ {
+		key := "player" + strconv.Itoa(i)
+		shardIndex := getShardIndexByKey(key, shardCount)
+		if shardIndex < 0 || shardIndex >= shardCount {
+			t.Fatalf("shard index %d out of range", shardIndex)
+		}
+		if getShardIndexByKey(key, shardCount) != shardIndex {
+			t.Fatalf("shard key %s is not routed deterministically", key)
+		}
+		counts[shardIndex]++
+
+		if newShardIndex := getShardIndexByKey(key, shardCount+1); newShardIndex != shardIndex {
+			if newShardIndex != shardCount {
+				t.Fatalf("shard key %s moved from shard %d to %d, but should only move to the new shard", key, shardIndex, newShardIndex)
+			}
+			moved++
+		}
+	}
+
+	for shardIndex, count := range counts {
+		if count < 1000 || count > 1500 {
+			t.Errorf("shard %d has %d keys, which is not balanced", shardIndex, count)
+		}
+	}
+	if moved < 800 || moved > 1500 {
+		t.Errorf("%d keys moved after adding a shard, which should be about 1/9", moved)
+	}
+}
+
+func TestShardEntityID(t *testing.T) {
+	eid := getShardEntityID("RankService#1")
+	if len(eid) != common.ENTITYID_LENGTH {
+		t.Fatalf("shard entity ID %s has wrong length", eid)
+	}
+	if getShardEntityID("RankService#1") != eid || getShardEntityID("RankService#2") == eid {
+		t.Fatalf("shard entity ID should be fixed for each shard")
+	}
+}
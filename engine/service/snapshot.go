@@ -0,0 +1,145 @@
+package service
+
+import (
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// ServiceSnapshotWatcher is called when a new version of service snapshot is replicated to this game
+type ServiceSnapshotWatcher func(serviceName string, version uint64)
+
+type serviceSnapshot struct {
+	version uint64
+	data    []byte
+	decoded interface{} // cache of the snapshot decoded to generic types
+}
+
+var (
+	serviceSnapshots   = map[string]*serviceSnapshot{} // ShardName -> Latest snapshot replicated to this game
+	publishedSnapshots = map[string]*serviceSnapshot{} // ShardName -> Latest snapshot published by this game
+	snapshotWatchers   = map[string][]ServiceSnapshotWatcher{}
+)
+
+// PublishServiceSnapshot publishes a read-only snapshot of the service, which is replicated to all games
+//
+// It should be called on the game hosting the service, and returns the version of the published snapshot. Each shard of
+// sharded services publishes its own snapshot using PublishServiceShardSnapshot.
+func PublishServiceSnapshot(serviceName string, data interface{}) (uint64, error) {
+	if shardCount := GetServiceShardCount(serviceName); shardCount > 1 {
+		return 0, errors.Errorf("publish snapshot of service %s: service has %d shards, use PublishServiceShardSnapshot", serviceName, shardCount)
+	}
+	return PublishServiceShardSnapshot(serviceName, 0, data)
+}
+
+// PublishServiceShardSnapshot publishes a read-only snapshot of the shard of service, which is replicated to all games
+//
+// It should be called on the game hosting the shard, and returns the version of the published snapshot.
+func PublishServiceShardSnapshot(serviceName string, shardIndex int, data interface{}) (uint64, error) {
+	shardName := getShardName(serviceName, GetServiceShardCount(serviceName), shardIndex)
+	if _, ok := localShardEntityIDs[shardName]; !ok {
+		return 0, errors.Errorf("publish snapshot of service %s: service is not on this game", shardName)
+	}
+
+	packed, err := netutil.MSG_PACKER.PackMsg(data, nil)
+	if err != nil {
+		return 0, errors.Wrapf(err, "publish snapshot of service %s: pack failed", shardName)
+	}
+
+	// continue the version of the snapshot published by the previous game of the shard
+	var version uint64
+	if snapshot := serviceSnapshots[shardName]; snapshot != nil {
+		version = snapshot.version
+	}
+	if snapshot := publishedSnapshots[shardName]; snapshot != nil && snapshot.version > version {
+		version = snapshot.version
+	}
+	version += 1
+
+	publishedSnapshots[shardName] = &serviceSnapshot{version: version, data: packed}
+	dispatchercluster.SendServiceSnapshot(shardName, version, packed)
+	return version, nil
+}
+
+// RepublishServiceSnapshots republishes snapshots kept by the dispatcher, in case the dispatcher is restarted
+func RepublishServiceSnapshots(dispid uint16) {
+	for shardName, snapshot := range publishedSnapshots {
+		if _, ok := localShardEntityIDs[shardName]; !ok {
+			// shard is moved to other game
+			delete(publishedSnapshots, shardName)
+			continue
+		}
+
+		if dispatchercluster.SrvIDToDispatcherID(shardName) == dispid {
+			dispatchercluster.SendServiceSnapshot(shardName, snapshot.version, snapshot.data)
+		}
+	}
+}
+
+// OnServiceSnapshot is called when the snapshot of the service shard is replicated to this game
+func OnServiceSnapshot(shardName string, version uint64, data []byte) {
+	if snapshot := serviceSnapshots[shardName]; snapshot != nil && snapshot.version >= version {
+		return
+	}
+
+	serviceSnapshots[shardName] = &serviceSnapshot{
+		version: version,
+		data:    append([]byte(nil), data...), // data is in the packet buffer
+	}
+	serviceName, _ := parseShardName(shardName)
+	for _, watcher := range snapshotWatchers[shardName] {
+		watcher(serviceName, version)
+	}
+}
+
+// GetServiceSnapshot returns the latest snapshot of service decoded to generic types, and its version
+//
+// Version is 0 if no snapshot is published.
+func GetServiceSnapshot(serviceName string) (interface{}, uint64) {
+	return GetServiceShardSnapshot(serviceName, 0)
+}
+
+// GetServiceShardSnapshot returns the latest snapshot of the shard of service decoded to generic types, and its version
+func GetServiceShardSnapshot(serviceName string, shardIndex int) (interface{}, uint64) {
+	shardName := getShardName(serviceName, GetServiceShardCount(serviceName), shardIndex)
+	snapshot := serviceSnapshots[shardName]
+	if snapshot == nil {
+		return nil, 0
+	}
+
+	if snapshot.decoded == nil {
+		if err := netutil.MSG_PACKER.UnpackMsg(snapshot.data, &snapshot.decoded); err != nil {
+			gwlog.Errorf("decode snapshot of service %s failed: %s", shardName, err)
+			return nil, snapshot.version
+		}
+	}
+	return snapshot.decoded, snapshot.version
+}
+
+// DecodeServiceSnapshot decodes the latest snapshot of service to v, and returns its version
+func DecodeServiceSnapshot(serviceName string, v interface{}) (uint64, error) {
+	return DecodeServiceShardSnapshot(serviceName, 0, v)
+}
+
+// DecodeServiceShardSnapshot decodes the latest snapshot of the shard of service to v, and returns its version
+func DecodeServiceShardSnapshot(serviceName string, shardIndex int, v interface{}) (uint64, error) {
+	shardName := getShardName(serviceName, GetServiceShardCount(serviceName), shardIndex)
+	snapshot := serviceSnapshots[shardName]
+	if snapshot == nil {
+		return 0, errors.Errorf("snapshot of service %s is not published", shardName)
+	}
+	return snapshot.version, netutil.MSG_PACKER.UnpackMsg(snapshot.data, v)
+}
+
+// WatchServiceSnapshot adds a watcher which is called when a new version of service snapshot is replicated to this game
+func WatchServiceSnapshot(serviceName string, watcher ServiceSnapshotWatcher) {
+	WatchServiceShardSnapshot(serviceName, 0, watcher)
+}
+
+// WatchServiceShardSnapshot adds a watcher which is called when a new version of the snapshot of the shard of service is
+// replicated to this game
+func WatchServiceShardSnapshot(serviceName string, shardIndex int, watcher ServiceSnapshotWatcher) {
+	shardName := getShardName(serviceName, GetServiceShardCount(serviceName), shardIndex)
+	snapshotWatchers[shardName] = append(snapshotWatchers[shardName], watcher)
+}
@@ -0,0 +1,86 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+type testConfigSnapshot struct {
+	MaxLevel int
+}
+
+func replicateTestSnapshot(t *testing.T, version uint64, maxLevel int) {
+	data, err := netutil.MSG_PACKER.PackMsg(testConfigSnapshot{MaxLevel: maxLevel}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	OnServiceSnapshot("ConfigService", version, data)
+}
+
+func TestServiceSnapshot(t *testing.T) {
+	defer delete(serviceSnapshots, "ConfigService")
+	defer delete(snapshotWatchers, "ConfigService")
+
+	if data, version := GetServiceSnapshot("ConfigService"); data != nil || version != 0 {
+		t.Fatalf("snapshot should not exist: %v, %d", data, version)
+	}
+
+	var notifiedVersions []uint64
+	WatchServiceSnapshot("ConfigService", func(serviceName string, version uint64) {
+		notifiedVersions = append(notifiedVersions, version)
+	})
+
+	replicateTestSnapshot(t, 1, 60)
+	replicateTestSnapshot(t, 2, 70)
+	replicateTestSnapshot(t, 1, 50) // stale snapshot should be ignored
+
+	var cfg testConfigSnapshot
+	version, err := DecodeServiceSnapshot("ConfigService", &cfg)
+	if err != nil || version != 2 || cfg.MaxLevel != 70 {
+		t.Fatalf("decode snapshot failed: %v, version %d, %+v", err, version, cfg)
+	}
+	if decoded, version := GetServiceSnapshot("ConfigService"); decoded == nil || version != 2 {
+		t.Fatalf("get snapshot failed: %v, version %d", decoded, version)
+	}
+	if len(notifiedVersions) != 2 || notifiedVersions[0] != 1 || notifiedVersions[1] != 2 {
+		t.Fatalf("watcher should be notified of version 1 & 2, but is %v", notifiedVersions)
+	}
+}
+
+func TestServiceShardSnapshot(t *testing.T) {
+	registeredServices["RankService"] = 2
+	defer delete(registeredServices, "RankService")
+	defer delete(serviceSnapshots, "RankService#0")
+	defer delete(serviceSnapshots, "RankService#1")
+	defer delete(snapshotWatchers, "RankService#1")
+
+	var notifiedVersions []uint64
+	WatchServiceShardSnapshot("RankService", 1, func(serviceName string, version uint64) {
+		if serviceName != "RankService" {
+			t.Errorf("watcher should be notified of RankService, but is %s", serviceName)
+		}
+		notifiedVersions = append(notifiedVersions, version)
+	})
+
+	for shardIndex, maxLevel := range []int{60, 70} {
+		data, _ := netutil.MSG_PACKER.PackMsg(testConfigSnapshot{MaxLevel: maxLevel}, nil)
+		OnServiceSnapshot(getShardName("RankService", 2, shardIndex), 1, data)
+	}
+
+	for shardIndex, maxLevel := range []int{60, 70} {
+		var cfg testConfigSnapshot
+		if version, err := DecodeServiceShardSnapshot("RankService", shardIndex, &cfg); err != nil || version != 1 || cfg.MaxLevel != maxLevel {
+			t.Errorf("shard %d should have its own snapshot, but got %v, version %d, %+v", shardIndex, err, version, cfg)
+		}
+	}
+	if len(notifiedVersions) != 1 {
+		t.Errorf("watcher of shard 1 should only be notified of shard 1, but is notified %d times", len(notifiedVersions))
+	}
+	if _, err := PublishServiceSnapshot("RankService", testConfigSnapshot{}); err == nil {
+		t.Errorf("snapshot of sharded service should be published by shards")
+	}
+	if _, err := PublishServiceShardSnapshot("RankService", 0, testConfigSnapshot{}); err == nil {
+		t.Errorf("snapshot of shard which is not on this game should not be published")
+	}
+}
@@ -0,0 +1,84 @@
+package service
+
+import (
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+var (
+	workerServices = map[string]int{} // ServiceName -> index of the last called worker
+)
+
+// RegisterWorkerService registers a stateless worker service with multiple identical instances
+//
+// Worker instances are distributed across worker_games of [deployment] (or all games if not set) like shards, and
+// CallService balances calls among healthy instances. Worker services should not be persistent, since any instance
+// might handle any call.
+func RegisterWorkerService(typeName string, entityPtr entity.IEntity, instanceCount int) {
+	RegisterServiceSharded(typeName, entityPtr, instanceCount)
+	if entity.GetEntityTypeDesc(typeName).IsPersistent {
+		gwlog.Panicf("worker service %s: should not be persistent", typeName)
+	}
+	workerServices[typeName] = -1
+}
+
+func isWorkerService(serviceName string) bool {
+	_, ok := workerServices[serviceName]
+	return ok
+}
+
+// isWorkerGame returns if worker instances can be placed on the game
+func isWorkerGame(gameid uint16) bool {
+	workerGames := config.GetDeployment().WorkerGames
+	if len(workerGames) == 0 {
+		return true
+	}
+	for _, workerGame := range workerGames {
+		if workerGame == gameid {
+			return true
+		}
+	}
+	return false
+}
+
+// getPreferredWorkerGameID returns the game which should host the worker instance, so that workers are distributed
+// across worker games
+func getPreferredWorkerGameID(workerIndex int) uint16 {
+	workerGames := config.GetDeployment().WorkerGames
+	if len(workerGames) == 0 {
+		return getPreferredGameID(workerIndex)
+	}
+	return workerGames[workerIndex%len(workerGames)]
+}
+
+// selectWorker selects the next available worker instance in a round robin way
+//
+// Workers which are lost or unhealthy are skipped, unless all workers are unavailable.
+func selectWorker(serviceName string, instanceCount int) int {
+	lastIndex := workerServices[serviceName]
+	selected := -1
+	for i := 1; i <= instanceCount; i++ {
+		workerIndex := (lastIndex + i) % instanceCount
+		if isWorkerAvailable(getShardName(serviceName, instanceCount, workerIndex)) {
+			selected = workerIndex
+			break
+		}
+	}
+
+	if selected < 0 {
+		selected = (lastIndex + 1) % instanceCount
+	}
+	workerServices[serviceName] = selected
+	return selected
+}
+
+func isWorkerAvailable(shardName string) bool {
+	info := knownServices[shardName]
+	if info == nil || !info.Registered || info.EntityID.IsNil() {
+		return false
+	}
+
+	health, _ := parseHealth(info.Health)
+	return health != HealthUnhealthy
+}
@@ -0,0 +1,65 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+)
+
+func TestSelectWorker(t *testing.T) {
+	defer func(services map[string]*serviceInfo) {
+		knownServices = services
+	}(knownServices)
+	defer delete(workerServices, "PathService")
+
+	workerServices["PathService"] = -1
+	knownServices = map[string]*serviceInfo{
+		"PathService#0": {Registered: true, EntityID: common.GenEntityID(), Health: HealthHealthy},
+		"PathService#1": {Registered: false, EntityID: common.GenEntityID(), Health: HealthHealthy},
+		"PathService#2": {Registered: true, EntityID: common.GenEntityID(), Health: HealthUnhealthy + ":busy"},
+		"PathService#3": {Registered: true, EntityID: common.GenEntityID()},
+	}
+
+	for i, expected := range []int{0, 3, 0, 3} {
+		if workerIndex := selectWorker("PathService", 4); workerIndex != expected {
+			t.Fatalf("call %d should select worker %d, but selected %d", i, expected, workerIndex)
+		}
+	}
+
+	// all workers are unavailable, select in round robin
+	knownServices = map[string]*serviceInfo{}
+	for i, expected := range []int{0, 1, 2, 3, 0} {
+		if workerIndex := selectWorker("PathService", 4); workerIndex != expected {
+			t.Fatalf("call %d should select worker %d when all workers are unavailable, but selected %d", i, expected, workerIndex)
+		}
+	}
+}
+
+func TestWorkerGames(t *testing.T) {
+	config.SetConfigFile("../../goworld.ini")
+	deployment := config.GetDeployment()
+	defer func(desiredGames int, workerGames []uint16) {
+		deployment.DesiredGames, deployment.WorkerGames = desiredGames, workerGames
+	}(deployment.DesiredGames, deployment.WorkerGames)
+
+	deployment.DesiredGames, deployment.WorkerGames = 4, nil
+	for gameid := uint16(1); gameid <= 4; gameid++ {
+		if !isWorkerGame(gameid) {
+			t.Errorf("game%d should host workers if worker_games is not set", gameid)
+		}
+	}
+	if gameid := getPreferredWorkerGameID(5); gameid != 2 {
+		t.Errorf("worker 5 should prefer game2 if worker_games is not set, but prefers game%d", gameid)
+	}
+
+	deployment.WorkerGames = []uint16{3, 4}
+	if isWorkerGame(1) || isWorkerGame(2) || !isWorkerGame(3) || !isWorkerGame(4) {
+		t.Errorf("only worker games should host workers")
+	}
+	for workerIndex, expected := range []uint16{3, 4, 3, 4} {
+		if gameid := getPreferredWorkerGameID(workerIndex); gameid != expected {
+			t.Errorf("worker %d should prefer game%d, but prefers game%d", workerIndex, expected, gameid)
+		}
+	}
+}
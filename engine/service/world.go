@@ -0,0 +1,64 @@
+package service
+
+import (
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+type worldService struct {
+	entityPtr  entity.IEntity
+	shardCount int
+}
+
+var (
+	worldServices = map[string]worldService{} // ServiceName -> service registered in each world
+	worlds        []string
+	worldsSet     bool
+)
+
+// RegisterWorldService registers a service with an instance in each world hosted by the cluster
+//
+// The service of each world is registered as the qualified type name, e.g. Chat@s1 for service Chat in world s1, and
+// should be called by CallWorldService. The service is registered as a normal service if no worlds are configured.
+func RegisterWorldService(typeName string, entityPtr entity.IEntity, shardCount int) {
+	if worldsSet {
+		gwlog.Panicf("world service %s: should be registered before the game is started", typeName)
+	}
+	if _, ok := worldServices[typeName]; ok {
+		gwlog.Panicf("world service %s: already registered", typeName)
+	}
+	worldServices[typeName] = worldService{entityPtr, shardCount}
+}
+
+// SetWorlds registers services of each world, which is called by the engine after the deployment config is loaded
+func SetWorlds(worlds_ []string) {
+	if worldsSet {
+		gwlog.Panicf("worlds are already set")
+	}
+	worlds, worldsSet = worlds_, true
+
+	for typeName, ws := range worldServices {
+		if len(worlds) == 0 {
+			RegisterServiceSharded(typeName, ws.entityPtr, ws.shardCount)
+			continue
+		}
+		for _, world := range worlds {
+			RegisterServiceSharded(entity.WorldTypeName(typeName, world), ws.entityPtr, ws.shardCount)
+		}
+	}
+}
+
+// GetWorlds returns worlds hosted by the cluster, which is empty for the single default world
+func GetWorlds() []string {
+	return worlds
+}
+
+// CallWorldService calls the service of the world, a random shard is called if the service is sharded
+func CallWorldService(world string, serviceName string, method string, args []interface{}) {
+	CallService(entity.WorldTypeName(serviceName, world), method, args)
+}
+
+// CallWorldServiceShardKey calls the shard of service of the world selected by the shard key
+func CallWorldServiceShardKey(world string, serviceName string, shardKey string, method string, args []interface{}) {
+	CallServiceShardKey(entity.WorldTypeName(serviceName, world), shardKey, method, args)
+}
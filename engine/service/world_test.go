@@ -0,0 +1,31 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+type testWorldService struct {
+	entity.Entity
+}
+
+func (s *testWorldService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+func TestSetWorlds(t *testing.T) {
+	defer func() {
+		worlds, worldsSet = nil, false
+	}()
+
+	RegisterWorldService("TestWorldService", &testWorldService{}, 2)
+	SetWorlds([]string{"s1", "s2"})
+	for _, serviceName := range []string{"TestWorldService@s1", "TestWorldService@s2"} {
+		if GetServiceShardCount(serviceName) != 2 || entity.GetEntityTypeDesc(serviceName) == nil {
+			t.Errorf("service %s should be registered with 2 shards", serviceName)
+		}
+	}
+	if _, ok := registeredServices["TestWorldService"]; ok {
+		t.Errorf("service should not be registered without world if worlds are configured")
+	}
+}
@@ -0,0 +1,116 @@
+// Package simulation implements the deterministic simulation mode of games
+//
+// In the deterministic mode, the time of the game is the logical time which is advanced by a fixed tick in every tick of
+// the game routine instead of following the wall clock. Timers of entities and the game are fired by the logical time,
+// and random numbers and entity IDs are generated by the RNG of the seed, so the same sequence of inputs produces the
+// same world state. This makes bug reports reproducible and complex interactions of entities testable.
+//
+// Only the logic running in the game routine is deterministic. Callbacks of storage, kvdb and async jobs, and anything
+// reading the wall clock directly are not. IDs of entities repeat across runs of the same seed, so the deterministic
+// mode should not be used with the production storage.
+package simulation
+
+import (
+	"math/rand"
+	"sync"
+	"time"
+)
+
+// StartTime is the logical time when the simulation starts
+var StartTime = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+
+var (
+	lock    sync.RWMutex
+	enabled bool
+	tick    time.Duration
+	now     time.Time
+	rng     = newRand(time.Now().UnixNano())
+)
+
+// Enable enables the deterministic mode with the RNG of the seed and the logical time advanced by the tick
+//
+// Enable should be called before the game starts, timers and entities created before are not deterministic.
+func Enable(seed int64, tickInterval time.Duration) {
+	lock.Lock()
+	enabled = true
+	tick = tickInterval
+	now = StartTime
+	rng = newRand(seed)
+	lock.Unlock()
+}
+
+// Enabled returns if the deterministic mode is enabled
+func Enabled() bool {
+	lock.RLock()
+	e := enabled
+	lock.RUnlock()
+	return e
+}
+
+// Now returns the logical time in the deterministic mode, or the wall clock otherwise
+func Now() time.Time {
+	lock.RLock()
+	e, t := enabled, now
+	lock.RUnlock()
+	if !e {
+		return time.Now()
+	}
+	return t
+}
+
+// Tick advances the logical time by one tick, it is called by the game routine in every tick
+func Tick() {
+	lock.Lock()
+	now = now.Add(tick)
+	lock.Unlock()
+}
+
+// Advance advances the logical time by the duration, which is used by tests to run the game faster than ticks
+func Advance(d time.Duration) {
+	if d < 0 {
+		return
+	}
+	lock.Lock()
+	now = now.Add(d)
+	lock.Unlock()
+}
+
+// Rand returns the RNG which should be used by the game logic, it is seeded by the seed in the deterministic mode
+//
+// The RNG is safe for concurrent use except Read, but only calls from the game routine are deterministic.
+func Rand() *rand.Rand {
+	lock.RLock()
+	r := rng
+	lock.RUnlock()
+	return r
+}
+
+func newRand(seed int64) *rand.Rand {
+	return rand.New(&lockedSource{src: rand.NewSource(seed).(rand.Source64)})
+}
+
+// lockedSource is the source of the RNG which is safe for concurrent use
+type lockedSource struct {
+	lock sync.Mutex
+	src  rand.Source64
+}
+
+func (s *lockedSource) Int63() int64 {
+	s.lock.Lock()
+	n := s.src.Int63()
+	s.lock.Unlock()
+	return n
+}
+
+func (s *lockedSource) Uint64() uint64 {
+	s.lock.Lock()
+	n := s.src.Uint64()
+	s.lock.Unlock()
+	return n
+}
+
+func (s *lockedSource) Seed(seed int64) {
+	s.lock.Lock()
+	s.src.Seed(seed)
+	s.lock.Unlock()
+}
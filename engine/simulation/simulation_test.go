@@ -0,0 +1,41 @@
+package simulation
+
+import (
+	"testing"
+	"time"
+)
+
+func TestSimulation(t *testing.T) {
+	defer func() {
+		enabled = false
+	}()
+
+	if Enabled() || time.Since(Now()) > time.Second {
+		t.Fatalf("Now should return the wall clock if not enabled")
+	}
+
+	Enable(1, time.Millisecond*5)
+	if !Enabled() || !Now().Equal(StartTime) {
+		t.Fatalf("simulation should start at %s, but now is %s", StartTime, Now())
+	}
+	Tick()
+	Tick()
+	Advance(time.Second)
+	if elapsed := Now().Sub(StartTime); elapsed != time.Second+time.Millisecond*10 {
+		t.Errorf("logical time should be advanced by %s, but advanced by %s", time.Second+time.Millisecond*10, elapsed)
+	}
+
+	var seq []int64
+	for i := 0; i < 10; i++ {
+		seq = append(seq, Rand().Int63())
+	}
+	Enable(1, time.Millisecond*5)
+	for i, n := range seq {
+		if m := Rand().Int63(); m != n {
+			t.Fatalf("random number %d should be %d in every run of the same seed, but got %d", i, n, m)
+		}
+	}
+	if !Now().Equal(StartTime) {
+		t.Errorf("logical time should be reset by Enable")
+	}
+}
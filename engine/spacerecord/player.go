@@ -0,0 +1,124 @@
+package spacerecord
+
+import (
+	"io"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+// EntityState is the state of the entity in the space at the current time of the player
+type EntityState struct {
+	ID       common.EntityID
+	TypeName string
+	X, Y, Z  float32
+	Yaw      float32
+	Attrs    map[string]interface{}
+}
+
+// Player plays the record of the space and keeps the state of entities in the space
+type Player struct {
+	r        *Reader
+	now      time.Duration
+	next     *Event // the event read but not played yet
+	entities map[common.EntityID]*EntityState
+	ended    bool
+}
+
+// NewPlayer reads the header and creates the player of the record
+func NewPlayer(r io.Reader) (*Player, error) {
+	rr, err := NewReader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Player{
+		r:        rr,
+		entities: map[common.EntityID]*EntityState{},
+	}, nil
+}
+
+// Header returns the header of the record
+func (p *Player) Header() Header {
+	return p.r.Header()
+}
+
+// Time returns the time of the record played to
+func (p *Player) Time() time.Duration {
+	return p.now
+}
+
+// Ended returns if all events are played
+func (p *Player) Ended() bool {
+	return p.ended
+}
+
+// Entities returns states of entities in the space, which should not be modified
+func (p *Player) Entities() map[common.EntityID]*EntityState {
+	return p.entities
+}
+
+// PlayTo plays events till the time, and calls f with each event after it is applied to states of entities if f is not nil
+func (p *Player) PlayTo(t time.Duration, f func(ev *Event)) error {
+	for !p.ended {
+		if p.next == nil {
+			ev, err := p.r.Read()
+			if err == io.EOF {
+				p.ended = true
+				break
+			} else if err != nil {
+				return err
+			}
+			p.next = ev
+		}
+		if p.next.Time > t {
+			break
+		}
+
+		ev := p.next
+		p.next = nil
+		p.apply(ev)
+		if f != nil {
+			f(ev)
+		}
+	}
+
+	if t > p.now {
+		p.now = t
+	}
+	return nil
+}
+
+func (p *Player) apply(ev *Event) {
+	switch ev.Type {
+	case EventEnter:
+		attrs := make(map[string]interface{}, len(ev.Attrs)) // events passed to callers should not be changed
+		for key, val := range ev.Attrs {
+			attrs[key] = val
+		}
+		p.entities[ev.EntityID] = &EntityState{
+			ID:       ev.EntityID,
+			TypeName: ev.TypeName,
+			X:        ev.X,
+			Y:        ev.Y,
+			Z:        ev.Z,
+			Yaw:      ev.Yaw,
+			Attrs:    attrs,
+		}
+	case EventLeave:
+		delete(p.entities, ev.EntityID)
+	case EventMove:
+		if es := p.entities[ev.EntityID]; es != nil {
+			es.X, es.Y, es.Z, es.Yaw = ev.X, ev.Y, ev.Z, ev.Yaw
+		}
+	case EventAttrs:
+		if es := p.entities[ev.EntityID]; es != nil {
+			for key, val := range ev.Attrs {
+				if val == nil {
+					delete(es.Attrs, key)
+				} else {
+					es.Attrs[key] = val
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,320 @@
+// Package spacerecord reads, writes and plays records of the state of spaces
+//
+// Games record the state of selected spaces (see Space.StartRecording and /record_space of the game admin server):
+// entities entering and leaving the space, their positions and selected attributes, which are sampled periodically and
+// written as changes. Records can be played to spectators (see Entity.SpectateReplay) or dumped for offline analysis
+// (see goworld dump-space-record), e.g. for esports replays and cheating investigations.
+//
+// Entities are referred by indexes assigned when they enter, and positions are written as float32, so records are
+// compact enough to be kept for long matches.
+package spacerecord
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"math"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+const (
+	magic = "GWSPACE1"
+	// FileExt is the extension of space record files
+	FileExt = ".gwspace"
+
+	maxPayloadLen = 64 * 1024 * 1024
+)
+
+// EventType is the type of events in space records
+type EventType uint8
+
+const (
+	// EventEnter is recorded when the entity enters the space, with its type, position, yaw and attributes
+	EventEnter EventType = 1 + iota
+	// EventLeave is recorded when the entity leaves the space
+	EventLeave
+	// EventMove is recorded when the position or yaw of the entity is changed
+	EventMove
+	// EventAttrs is recorded when attributes of the entity are changed, with changed attributes (nil if deleted)
+	EventAttrs
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventEnter:
+		return "enter"
+	case EventLeave:
+		return "leave"
+	case EventMove:
+		return "move"
+	case EventAttrs:
+		return "attrs"
+	default:
+		return "unknown"
+	}
+}
+
+// Header is the header of the record of a space
+type Header struct {
+	SpaceID   common.EntityID
+	Kind      int
+	World     string
+	StartTime time.Time
+}
+
+// Event is the change of the state of the space
+type Event struct {
+	Time     time.Duration // time since the start of recording
+	Type     EventType
+	EntityID common.EntityID
+	TypeName string                 // type of the entity, only for EventEnter
+	X, Y, Z  float32                // position, for EventEnter and EventMove
+	Yaw      float32                // yaw, for EventEnter and EventMove
+	Attrs    map[string]interface{} // attributes for EventEnter, and changed attributes for EventAttrs
+}
+
+// Writer writes events of the space
+type Writer struct {
+	w         *bufio.Writer
+	startTime time.Time
+	indexes   map[common.EntityID]uint64 // indexes of entities in the space
+	nextIndex uint64
+	err       error // the first error of writing, following writes are ignored
+}
+
+// NewWriter writes the header and creates the writer of events
+func NewWriter(w io.Writer, header Header) (*Writer, error) {
+	rw := &Writer{
+		w:         bufio.NewWriter(w),
+		startTime: header.StartTime,
+		indexes:   map[common.EntityID]uint64{},
+	}
+	rw.w.WriteString(magic)
+	rw.writeBytes([]byte(header.SpaceID))
+	rw.writeVarint(int64(header.Kind))
+	rw.writeBytes([]byte(header.World))
+	rw.writeVarint(header.StartTime.UnixNano())
+	if err := rw.w.Flush(); err != nil {
+		return nil, err
+	}
+	return rw, nil
+}
+
+// Write writes the event at the time, events of entities not entered are ignored
+func (w *Writer) Write(now time.Time, ev *Event) error {
+	if w.err != nil {
+		return w.err
+	}
+
+	index, ok := w.indexes[ev.EntityID]
+	if ev.Type == EventEnter {
+		index = w.nextIndex
+		w.nextIndex++
+		w.indexes[ev.EntityID] = index
+	} else if !ok {
+		return nil
+	}
+
+	w.writeVarint(int64(now.Sub(w.startTime)))
+	w.w.WriteByte(byte(ev.Type))
+	w.writeUvarint(index)
+	switch ev.Type {
+	case EventEnter:
+		w.writeBytes([]byte(ev.EntityID))
+		w.writeBytes([]byte(ev.TypeName))
+		w.writePosYaw(ev)
+		w.writeAttrs(ev.Attrs)
+	case EventLeave:
+		delete(w.indexes, ev.EntityID)
+	case EventMove:
+		w.writePosYaw(ev)
+	case EventAttrs:
+		w.writeAttrs(ev.Attrs)
+	}
+	return w.err
+}
+
+// Flush writes buffered events to the underlying writer
+func (w *Writer) Flush() error {
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+	return w.err
+}
+
+func (w *Writer) writePosYaw(ev *Event) {
+	var buf [16]byte
+	for i, f := range []float32{ev.X, ev.Y, ev.Z, ev.Yaw} {
+		binary.LittleEndian.PutUint32(buf[i*4:], math.Float32bits(f))
+	}
+	w.w.Write(buf[:])
+}
+
+func (w *Writer) writeAttrs(attrs map[string]interface{}) {
+	data, err := netutil.MSG_PACKER.PackMsg(attrs, nil)
+	if err != nil {
+		w.err = errors.Wrap(err, "pack attrs")
+		return
+	}
+	w.writeBytes(data)
+}
+
+func (w *Writer) writeVarint(v int64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.w.Write(buf[:binary.PutVarint(buf[:], v)])
+}
+
+func (w *Writer) writeUvarint(v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	w.w.Write(buf[:binary.PutUvarint(buf[:], v)])
+}
+
+func (w *Writer) writeBytes(b []byte) {
+	w.writeUvarint(uint64(len(b)))
+	if _, err := w.w.Write(b); err != nil && w.err == nil {
+		w.err = err
+	}
+}
+
+// Reader reads events of the space
+type Reader struct {
+	r         *bufio.Reader
+	header    Header
+	entityIDs map[uint64]common.EntityID
+}
+
+// NewReader reads the header and creates the reader of events
+func NewReader(r io.Reader) (*Reader, error) {
+	rr := &Reader{
+		r:         bufio.NewReader(r),
+		entityIDs: map[uint64]common.EntityID{},
+	}
+	head := make([]byte, len(magic))
+	if _, err := io.ReadFull(rr.r, head); err != nil || string(head) != magic {
+		return nil, errors.Errorf("not a space record")
+	}
+
+	spaceID, err := rr.readBytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	kind, err := binary.ReadVarint(rr.r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	world, err := rr.readBytes()
+	if err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	startTime, err := binary.ReadVarint(rr.r)
+	if err != nil {
+		return nil, errors.Wrap(err, "read header")
+	}
+	rr.header = Header{
+		SpaceID:   common.EntityID(spaceID),
+		Kind:      int(kind),
+		World:     string(world),
+		StartTime: time.Unix(0, startTime),
+	}
+	return rr, nil
+}
+
+// Header returns the header of the record
+func (r *Reader) Header() Header {
+	return r.header
+}
+
+// Read reads the next event, io.EOF is returned if there are no more events
+func (r *Reader) Read() (*Event, error) {
+	t, err := binary.ReadVarint(r.r)
+	if err != nil {
+		return nil, err // io.EOF if the record ends
+	}
+	evtype, err := r.r.ReadByte()
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	index, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+
+	ev := &Event{Time: time.Duration(t), Type: EventType(evtype)}
+	if ev.Type == EventEnter {
+		id, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		typeName, err := r.readBytes()
+		if err != nil {
+			return nil, err
+		}
+		ev.EntityID, ev.TypeName = common.EntityID(id), string(typeName)
+		r.entityIDs[index] = ev.EntityID
+	} else if id, ok := r.entityIDs[index]; ok {
+		ev.EntityID = id
+	} else {
+		return nil, errors.Errorf("%s event of unknown entity index %d", ev.Type, index)
+	}
+
+	switch ev.Type {
+	case EventEnter:
+		if err = r.readPosYaw(ev); err == nil {
+			ev.Attrs, err = r.readAttrs()
+		}
+	case EventLeave:
+		delete(r.entityIDs, index)
+	case EventMove:
+		err = r.readPosYaw(ev)
+	case EventAttrs:
+		ev.Attrs, err = r.readAttrs()
+	default:
+		err = errors.Errorf("unknown event type %d", evtype)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ev, nil
+}
+
+func (r *Reader) readPosYaw(ev *Event) error {
+	var buf [16]byte
+	if _, err := io.ReadFull(r.r, buf[:]); err != nil {
+		return io.ErrUnexpectedEOF
+	}
+	for i, f := range []*float32{&ev.X, &ev.Y, &ev.Z, &ev.Yaw} {
+		*f = math.Float32frombits(binary.LittleEndian.Uint32(buf[i*4:]))
+	}
+	return nil
+}
+
+func (r *Reader) readAttrs() (map[string]interface{}, error) {
+	data, err := r.readBytes()
+	if err != nil {
+		return nil, err
+	}
+	var attrs map[string]interface{}
+	if err := netutil.MSG_PACKER.UnpackMsg(data, &attrs); err != nil {
+		return nil, errors.Wrap(err, "unpack attrs")
+	}
+	return attrs, nil
+}
+
+func (r *Reader) readBytes() ([]byte, error) {
+	n, err := binary.ReadUvarint(r.r)
+	if err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	if n > maxPayloadLen {
+		return nil, errors.Errorf("payload too large: %d", n)
+	}
+	b := make([]byte, n)
+	if _, err := io.ReadFull(r.r, b); err != nil {
+		return nil, io.ErrUnexpectedEOF
+	}
+	return b, nil
+}
@@ -0,0 +1,87 @@
+package spacerecord
+
+import (
+	"bytes"
+	"encoding/json"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestRecord(t *testing.T) {
+	var buf bytes.Buffer
+	header := Header{
+		SpaceID:   "abcdefghijklmnop",
+		Kind:      2,
+		World:     "s1",
+		StartTime: time.Unix(1600000000, 123),
+	}
+	w, err := NewWriter(&buf, header)
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := []Event{
+		{Time: 0, Type: EventEnter, EntityID: "avatar0000000001", TypeName: "Avatar", X: 1, Y: 2, Z: 3, Yaw: 0.5,
+			Attrs: map[string]interface{}{"hp": int64(100), "equips": map[string]interface{}{"weapon": "sword"}}},
+		{Time: time.Millisecond * 100, Type: EventMove, EntityID: "avatar0000000001", X: 2, Y: 2, Z: 3, Yaw: 0.5},
+		{Time: time.Millisecond * 100, Type: EventEnter, EntityID: "monster000000001", TypeName: "Monster", Attrs: map[string]interface{}{}},
+		{Time: time.Millisecond * 200, Type: EventAttrs, EntityID: "avatar0000000001", Attrs: map[string]interface{}{"hp": int64(90), "buff": nil}},
+		{Time: time.Millisecond * 300, Type: EventLeave, EntityID: "avatar0000000001"},
+	}
+	for _, ev := range events {
+		ev := ev
+		if err := w.Write(header.StartTime.Add(ev.Time), &ev); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// events of entities not in the space are ignored
+	w.Write(header.StartTime, &Event{Type: EventMove, EntityID: "avatar0000000001"})
+	if err := w.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := NewPlayer(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if h := p.Header(); h.SpaceID != header.SpaceID || h.Kind != header.Kind || h.World != header.World || !h.StartTime.Equal(header.StartTime) {
+		t.Errorf("header should be %+v, but is %+v", header, h)
+	}
+
+	var played []*Event
+	if err := p.PlayTo(time.Millisecond*200, func(ev *Event) { played = append(played, ev) }); err != nil {
+		t.Fatal(err)
+	}
+	if len(played) != 4 || p.Ended() {
+		t.Fatalf("4 events should be played till 200ms, but played %d", len(played))
+	}
+	for i, ev := range played {
+		expected := events[i]
+		if expected.Type == EventAttrs {
+			continue // attrs of nil values are compared below
+		}
+		if !reflect.DeepEqual(*ev, expected) {
+			t.Errorf("event %d should be %+v, but is %+v", i, expected, *ev)
+		}
+	}
+	avatar := p.Entities()["avatar0000000001"]
+	if avatar == nil || avatar.X != 2 || avatar.Attrs["hp"] != int64(90) || len(avatar.Attrs) != 2 {
+		t.Fatalf("state of avatar is wrong: %+v", avatar)
+	}
+	if _, err := json.Marshal(avatar.Attrs); err != nil {
+		t.Errorf("attrs should be marshalled to JSON: %s", err)
+	}
+
+	if err := p.PlayTo(time.Second, nil); err != nil {
+		t.Fatal(err)
+	}
+	if !p.Ended() || len(p.Entities()) != 1 || p.Entities()["monster000000001"] == nil {
+		t.Errorf("only monster should be in the space after all events are played, but got %+v", p.Entities())
+	}
+}
+
+func TestReaderInvalid(t *testing.T) {
+	if _, err := NewReader(bytes.NewReader([]byte("not a record"))); err == nil {
+		t.Errorf("reader should reject invalid records")
+	}
+}
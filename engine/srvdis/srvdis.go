@@ -30,7 +30,12 @@ func TraverseByPrefix(prefix string, cb func(srvid string, srvinfo string)) {
 
 func WatchSrvdisRegister(srvid string, srvinfo string) {
 	gwlog.Infof("srvdis: watch %s = %s", srvid, srvinfo)
-	srvmap[srvid] = srvinfo
+	if srvinfo != "" {
+		srvmap[srvid] = srvinfo
+	} else {
+		// empty srvinfo is registered by dispatcher when the game of srvid is down
+		delete(srvmap, srvid)
+	}
 
 	for _, c := range postCallbacks {
 		post.Post(c)
@@ -0,0 +1,174 @@
+package entitystoragememory
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/storage/storage_common"
+)
+
+// MemoryEntityStorage is an implementation of Entity Storage in memory, for tests and demos without databases
+//
+// Entities are kept in the memory of the game process, so entities saved by other games are not found. If the snapshot
+// file is set, entities are loaded from the snapshot file when opened, and saved to the snapshot file periodically and
+// when closed, so that entities are kept when the game is restarted or reloaded.
+type MemoryEntityStorage struct {
+	lock         sync.Mutex
+	entities     map[string]map[common.EntityID]json.RawMessage // type name -> entity ID -> data
+	dirty        bool                                           // entities are changed after the last snapshot
+	snapshotFile string
+	snapshotLock sync.Mutex // serializes writing snapshots
+	stopped      chan struct{}
+	stopOnce     sync.Once
+}
+
+// Write writes entity data to entity storage
+func (es *MemoryEntityStorage) Write(typeName string, entityID common.EntityID, data interface{}) error {
+	// data is kept in JSON like the filesystem storage, so that data read is not shared with the writer
+	dataBytes, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	es.lock.Lock()
+	entities := es.entities[typeName]
+	if entities == nil {
+		entities = map[common.EntityID]json.RawMessage{}
+		es.entities[typeName] = entities
+	}
+	entities[entityID] = dataBytes
+	es.dirty = true
+	es.lock.Unlock()
+	return nil
+}
+
+// Read reads entity data from entity storage
+func (es *MemoryEntityStorage) Read(typeName string, entityID common.EntityID) (interface{}, error) {
+	es.lock.Lock()
+	dataBytes, ok := es.entities[typeName][entityID]
+	es.lock.Unlock()
+	if !ok {
+		return nil, nil
+	}
+
+	var data interface{}
+	if err := json.Unmarshal(dataBytes, &data); err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Exists checks if entity is in entity storage
+func (es *MemoryEntityStorage) Exists(typeName string, entityID common.EntityID) (bool, error) {
+	es.lock.Lock()
+	_, ok := es.entities[typeName][entityID]
+	es.lock.Unlock()
+	return ok, nil
+}
+
+// List retrives all entity IDs in entity storage of specified type
+func (es *MemoryEntityStorage) List(typeName string) ([]common.EntityID, error) {
+	es.lock.Lock()
+	res := make([]common.EntityID, 0, len(es.entities[typeName]))
+	for entityID := range es.entities[typeName] {
+		res = append(res, entityID)
+	}
+	es.lock.Unlock()
+	return res, nil
+}
+
+// Close the entity storage, entities are saved to the snapshot file
+func (es *MemoryEntityStorage) Close() {
+	es.stopOnce.Do(func() {
+		close(es.stopped)
+	})
+	if err := es.Snapshot(); err != nil {
+		gwlog.Errorf("memory storage: save snapshot %s failed: %s", es.snapshotFile, err)
+	}
+}
+
+// IsEOF check if the error is an EOF error
+func (es *MemoryEntityStorage) IsEOF(err error) bool {
+	return false
+}
+
+// Snapshot saves entities to the snapshot file if entities are changed
+func (es *MemoryEntityStorage) Snapshot() error {
+	if es.snapshotFile == "" {
+		return nil
+	}
+
+	es.snapshotLock.Lock()
+	defer es.snapshotLock.Unlock()
+	es.lock.Lock()
+	if !es.dirty {
+		es.lock.Unlock()
+		return nil
+	}
+	dataBytes, err := json.Marshal(es.entities)
+	es.dirty = false
+	es.lock.Unlock()
+	if err != nil {
+		return err
+	}
+
+	// write to the temp file and rename, so that the snapshot is never partially written
+	tempFile := es.snapshotFile + ".tmp"
+	err = ioutil.WriteFile(tempFile, dataBytes, 0644)
+	if err == nil {
+		err = os.Rename(tempFile, es.snapshotFile)
+	}
+	if err != nil {
+		es.lock.Lock()
+		es.dirty = true // retry in the next snapshot
+		es.lock.Unlock()
+	}
+	return err
+}
+
+func (es *MemoryEntityStorage) snapshotRoutine(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			if err := es.Snapshot(); err != nil {
+				gwlog.Errorf("memory storage: save snapshot %s failed: %s", es.snapshotFile, err)
+			}
+		case <-es.stopped:
+			return
+		}
+	}
+}
+
+// OpenMemory opens the entity storage in memory, entities are loaded from and saved to the snapshot file every
+// snapshot interval if the snapshot file is not empty
+func OpenMemory(snapshotFile string, snapshotInterval time.Duration) (storagecommon.EntityStorage, error) {
+	es := &MemoryEntityStorage{
+		entities:     map[string]map[common.EntityID]json.RawMessage{},
+		snapshotFile: snapshotFile,
+		stopped:      make(chan struct{}),
+	}
+	if snapshotFile == "" {
+		return es, nil
+	}
+
+	dataBytes, err := ioutil.ReadFile(snapshotFile)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(dataBytes, &es.entities); err != nil {
+			return nil, err
+		}
+	}
+	if snapshotInterval > 0 {
+		go es.snapshotRoutine(snapshotInterval)
+	}
+	return es, nil
+}
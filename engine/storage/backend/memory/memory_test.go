@@ -0,0 +1,68 @@
+package entitystoragememory
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/xiaonanln/goworld/engine/common"
+)
+
+func TestMemoryEntityStorage(t *testing.T) {
+	es, err := OpenMemory("", 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entityID := common.GenEntityID()
+	if data, err := es.Read("Avatar", entityID); data != nil || err != nil {
+		t.Errorf("should be nil")
+	}
+
+	testData := map[string]interface{}{
+		"a": 1,
+		"b": "2",
+	}
+	if err := es.Write("Avatar", entityID, testData); err != nil {
+		t.Fatal(err)
+	}
+	testData["a"] = 2 // data written should not be changed
+	verifyData, err := es.Read("Avatar", entityID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if verifyData.(map[string]interface{})["a"].(float64) != 1 || verifyData.(map[string]interface{})["b"].(string) != "2" {
+		t.Errorf("read wrong data: %v", verifyData)
+	}
+	if exists, _ := es.Exists("Avatar", entityID); !exists {
+		t.Errorf("entity should exist")
+	}
+	if ids, _ := es.List("Avatar"); len(ids) != 1 || ids[0] != entityID {
+		t.Errorf("list wrong entity IDs: %v", ids)
+	}
+	if ids, _ := es.List("Monster"); len(ids) != 0 {
+		t.Errorf("list wrong entity IDs: %v", ids)
+	}
+}
+
+func TestMemoryEntityStorageSnapshot(t *testing.T) {
+	snapshotFile := filepath.Join(os.TempDir(), "goworld_test_entity_storage.json")
+	os.Remove(snapshotFile)
+	defer os.Remove(snapshotFile)
+
+	es, err := OpenMemory(snapshotFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	entityID := common.GenEntityID()
+	es.Write("Avatar", entityID, map[string]interface{}{"name": "test"})
+	es.Close()
+
+	es, err = OpenMemory(snapshotFile, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := es.Read("Avatar", entityID)
+	if err != nil || data == nil || data.(map[string]interface{})["name"] != "test" {
+		t.Errorf("entity should be loaded from the snapshot, but got %v, %v", data, err)
+	}
+}
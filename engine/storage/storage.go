@@ -1,6 +1,7 @@
 package storage
 
 import (
+	"context"
 	"time"
 
 	"strconv"
@@ -13,6 +14,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/storage/backend/filesystem"
+	"github.com/xiaonanln/goworld/engine/storage/backend/memory"
 	"github.com/xiaonanln/goworld/engine/storage/backend/mongodb"
 	"github.com/xiaonanln/goworld/engine/storage/backend/mysql"
 	"github.com/xiaonanln/goworld/engine/storage/backend/redis"
@@ -24,6 +26,7 @@ var (
 	storageEngine            storagecommon.EntityStorage
 	operationQueue           = xnsyncutil.NewSyncQueue()
 	storageRoutineTerminated = xnsyncutil.NewOneTimeCond()
+	logger                   = gwlog.Module("storage")
 )
 
 type saveRequest struct {
@@ -34,18 +37,21 @@ type saveRequest struct {
 }
 
 type loadRequest struct {
+	Ctx      context.Context
 	TypeName string
 	EntityID common.EntityID
 	Callback LoadCallbackFunc
 }
 
 type existsRequest struct {
+	Ctx      context.Context
 	TypeName string
 	EntityID common.EntityID
 	Callback ExistsCallbackFunc
 }
 
 type listEntityIDsRequest struct {
+	Ctx      context.Context
 	TypeName string
 	Callback ListCallbackFunc
 }
@@ -63,6 +69,8 @@ type ExistsCallbackFunc func(exists bool, err error)
 type ListCallbackFunc func([]common.EntityID, error)
 
 // Save saves entity data to storage
+//
+// Save takes no context since entity data is always saved, even if the entity is destroyed
 func Save(typeName string, entityID common.EntityID, data interface{}, callback SaveCallbackFunc) {
 	operationQueue.Push(saveRequest{
 		TypeName: typeName,
@@ -75,7 +83,16 @@ func Save(typeName string, entityID common.EntityID, data interface{}, callback
 
 // Load loads entity data from storage
 func Load(typeName string, entityID common.EntityID, callback LoadCallbackFunc) {
+	LoadContext(context.Background(), typeName, entityID, callback)
+}
+
+// LoadContext loads entity data from storage with the context
+//
+// The load is skipped and callback receives the error of ctx if ctx is done before the load starts. The callback is
+// dropped if ctx is canceled.
+func LoadContext(ctx context.Context, typeName string, entityID common.EntityID, callback LoadCallbackFunc) {
 	operationQueue.Push(loadRequest{
+		Ctx:      ctx,
 		TypeName: typeName,
 		EntityID: entityID,
 		Callback: callback,
@@ -85,7 +102,13 @@ func Load(typeName string, entityID common.EntityID, callback LoadCallbackFunc)
 
 // Exists checks if entity of specified ID exists in storage
 func Exists(typeName string, entityID common.EntityID, callback ExistsCallbackFunc) {
+	ExistsContext(context.Background(), typeName, entityID, callback)
+}
+
+// ExistsContext checks if entity of specified ID exists in storage with the context
+func ExistsContext(ctx context.Context, typeName string, entityID common.EntityID, callback ExistsCallbackFunc) {
 	operationQueue.Push(existsRequest{
+		Ctx:      ctx,
 		TypeName: typeName,
 		EntityID: entityID,
 		Callback: callback,
@@ -97,7 +120,13 @@ func Exists(typeName string, entityID common.EntityID, callback ExistsCallbackFu
 //
 // Return values can be large for common entity types
 func ListEntityIDs(typeName string, callback ListCallbackFunc) {
+	ListEntityIDsContext(context.Background(), typeName, callback)
+}
+
+// ListEntityIDsContext returns all entity IDs in storage with the context
+func ListEntityIDsContext(ctx context.Context, typeName string, callback ListCallbackFunc) {
 	operationQueue.Push(listEntityIDsRequest{
+		Ctx:      ctx,
 		TypeName: typeName,
 		Callback: callback,
 	})
@@ -106,10 +135,15 @@ func ListEntityIDs(typeName string, callback ListCallbackFunc) {
 
 var recentWarnedQueueLen = 0
 
+// GetQueueLen returns the number of storage operations waiting to run
+func GetQueueLen() int {
+	return operationQueue.Len()
+}
+
 func checkOperationQueueLen() {
 	qlen := operationQueue.Len()
 	if qlen > 100 && qlen%100 == 0 && recentWarnedQueueLen != qlen {
-		gwlog.Warnf("Storage operation queue length = %d", qlen)
+		logger.Warnf("Storage operation queue length = %d", qlen)
 		recentWarnedQueueLen = qlen
 	}
 }
@@ -134,24 +168,31 @@ func assureStorageEngineReady() (err error) {
 		return
 	}
 
-	cfg := config.GetStorage()
+	storageEngine, err = OpenStorageEngine(config.GetStorage())
+	return
+}
+
+// OpenStorageEngine opens the entity storage backend of the storage config
+func OpenStorageEngine(cfg *config.StorageConfig) (es storagecommon.EntityStorage, err error) {
 	if cfg.Type == "filesystem" {
-		storageEngine, err = entitystoragefilesystem.OpenDirectory(cfg.Directory)
+		es, err = entitystoragefilesystem.OpenDirectory(cfg.Directory)
+	} else if cfg.Type == "memory" {
+		es, err = entitystoragememory.OpenMemory(cfg.SnapshotFile, cfg.SnapshotInterval)
 	} else if cfg.Type == "mongodb" {
-		storageEngine, err = entitystoragemongodb.OpenMongoDB(cfg.Url, cfg.DB)
+		es, err = entitystoragemongodb.OpenMongoDB(cfg.Url, cfg.DB)
 	} else if cfg.Type == "redis" {
 		var dbindex int = -1
 		if cfg.DB != "" {
 			if dbindex, err = strconv.Atoi(cfg.DB); err != nil {
-				return err
+				return nil, err
 			}
 		}
-		storageEngine, err = entitystorageredis.OpenRedis(cfg.Url, dbindex)
+		es, err = entitystorageredis.OpenRedis(cfg.Url, dbindex)
 	} else if cfg.Type == "redis_cluster" {
-		storageEngine, err = entitystoragerediscluster.OpenRedisCluster(cfg.StartNodes.ToList())
+		es, err = entitystoragerediscluster.OpenRedisCluster(cfg.StartNodes.ToList())
 	} else if cfg.Type == "sql" {
 		if cfg.Driver == "mysql" {
-			storageEngine, err = entitystoragemysql.OpenMySQL(cfg.Url)
+			es, err = entitystoragemysql.OpenMySQL(cfg.Url)
 		} else {
 			gwlog.Panicf("unknown sql driver: %s", cfg.Driver)
 		}
@@ -166,7 +207,7 @@ func storageRoutine() {
 	defer func() {
 		err := recover()
 		if err != nil {
-			gwlog.TraceError("storage routine paniced: %s, restarting ...", err)
+			logger.TraceError("storage routine paniced: %s, restarting ...", err)
 			go storageRoutine() // restart the storage routine
 		} else {
 			// normal quit
@@ -178,7 +219,7 @@ func storageRoutine() {
 	for {
 		err := assureStorageEngineReady()
 		if err != nil {
-			gwlog.Errorf("Storage engine is not ready: %s", err)
+			logger.Errorf("Storage engine is not ready: %s", err)
 			time.Sleep(time.Second)
 			continue
 		}
@@ -194,11 +235,11 @@ func storageRoutine() {
 			monop = opmon.StartOperation("storage.save")
 			for {
 				if consts.DEBUG_SAVE_LOAD {
-					gwlog.Debugf("storage: SAVING %s %s ...", saveReq.TypeName, saveReq.EntityID)
+					logger.Debugf("storage: SAVING %s %s ...", saveReq.TypeName, saveReq.EntityID)
 				}
 				err := assureStorageEngineReady()
 				if err != nil {
-					gwlog.Errorf("Storage engine is not ready: %s", err)
+					logger.Errorf("Storage engine is not ready: %s", err)
 					time.Sleep(time.Second) // wait for 1 second to retry
 					continue
 				}
@@ -210,7 +251,7 @@ func storageRoutine() {
 				err = storageEngine.Write(saveReq.TypeName, saveReq.EntityID, saveReq.Data)
 				if err != nil {
 					// save failed ?
-					gwlog.Errorf("storage: save failed: %s", err)
+					logger.Errorf("storage: save failed: %s", err)
 
 					if err != nil && storageEngine.IsEOF(err) {
 						storageEngine.Close()
@@ -230,18 +271,22 @@ func storageRoutine() {
 			}
 		} else if loadReq, ok := op.(loadRequest); ok {
 			// handle load request
-			gwlog.Debugf("storage: LOADING %s %s ...", loadReq.TypeName, loadReq.EntityID)
+			logger.Debugf("storage: LOADING %s %s ...", loadReq.TypeName, loadReq.EntityID)
 			monop = opmon.StartOperation("storage.load")
-			data, err := storageEngine.Read(loadReq.TypeName, loadReq.EntityID)
+			var data interface{}
+			err := loadReq.Ctx.Err()
+			if err == nil {
+				data, err = storageEngine.Read(loadReq.TypeName, loadReq.EntityID)
+			}
 			if err != nil {
 				// save failed ?
-				gwlog.TraceError("storage: load %s %s failed: %s", loadReq.TypeName, loadReq.EntityID, err)
+				logger.TraceError("storage: load %s %s failed: %s", loadReq.TypeName, loadReq.EntityID, err)
 				data = nil
 			}
 
 			monop.Finish(time.Millisecond * 100)
 			if loadReq.Callback != nil {
-				post.Post(func() {
+				post.PostContext(loadReq.Ctx, func() {
 					loadReq.Callback(data, err)
 				})
 			}
@@ -252,10 +297,14 @@ func storageRoutine() {
 			}
 		} else if existsReq, ok := op.(existsRequest); ok {
 			monop = opmon.StartOperation("storage.exists")
-			exists, err := storageEngine.Exists(existsReq.TypeName, existsReq.EntityID)
+			var exists bool
+			err := existsReq.Ctx.Err()
+			if err == nil {
+				exists, err = storageEngine.Exists(existsReq.TypeName, existsReq.EntityID)
+			}
 			monop.Finish(time.Millisecond * 100)
 			if existsReq.Callback != nil {
-				post.Post(func() {
+				post.PostContext(existsReq.Ctx, func() {
 					existsReq.Callback(exists, err)
 				})
 			}
@@ -265,13 +314,17 @@ func storageRoutine() {
 			}
 		} else if listReq, ok := op.(listEntityIDsRequest); ok {
 			monop = opmon.StartOperation("storage.list")
-			eids, err := storageEngine.List(listReq.TypeName)
+			var eids []common.EntityID
+			err := listReq.Ctx.Err()
+			if err == nil {
+				eids, err = storageEngine.List(listReq.TypeName)
+			}
 			if err != nil {
-				gwlog.TraceError("ListEntityIDs %s failed: %s", listReq.TypeName, err)
+				logger.TraceError("ListEntityIDs %s failed: %s", listReq.TypeName, err)
 			}
 			monop.Finish(time.Millisecond * 1000)
 			if listReq.Callback != nil {
-				post.Post(func() {
+				post.PostContext(listReq.Ctx, func() {
 					listReq.Callback(eids, err)
 				})
 			}
@@ -0,0 +1,299 @@
+// Package timingwheel implements the hierarchical timing wheel for large numbers of timers
+//
+// Timers are kept in 5 levels of wheels (256, 64, 64, 64 and 64 slots) like timers of the Linux kernel. Adding and
+// cancelling timers are O(1), and each tick only runs the expired slot and occasionally cascades timers of the higher
+// level to lower levels, so the cost of ticks does not grow with the number of timers. Unlike goTimer, cancelled timers
+// are removed immediately instead of staying in the heap until they expire.
+//
+// Timers are never fired before their durations, but might be fired up to one resolution later.
+package timingwheel
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+const (
+	rootBits  = 8
+	levelBits = 6
+	rootSize  = 1 << rootBits
+	levelSize = 1 << levelBits
+	rootMask  = rootSize - 1
+	levelMask = levelSize - 1
+	numLevels = 4 // levels above the root wheel
+
+	maxTicks = 1<<(rootBits+numLevels*levelBits) - 1 // timers of longer durations are re-added when cascaded
+)
+
+// DefaultResolution is the resolution of the default wheel, which is the same as the min interval of goTimer
+const DefaultResolution = time.Millisecond
+
+// Timer is the handle of a callback or repeat timer in the wheel
+type Timer struct {
+	wheel      *Wheel
+	expire     int64 // tick to fire the timer
+	interval   int64 // ticks between fires of repeat timers
+	callback   func()
+	prev, next *Timer // linked in the slot if prev is not nil
+}
+
+// Cancel cancels the timer, the callback is not called after Cancel returns
+func (t *Timer) Cancel() {
+	w := t.wheel
+	w.lock.Lock()
+	t.callback = nil
+	if t.prev != nil {
+		t.unlink()
+		w.count -= 1
+	}
+	w.lock.Unlock()
+}
+
+// IsActive returns if the timer is not fired (for callbacks) and not cancelled
+func (t *Timer) IsActive() bool {
+	w := t.wheel
+	w.lock.Lock()
+	active := t.callback != nil
+	w.lock.Unlock()
+	return active
+}
+
+func (t *Timer) unlink() {
+	t.prev.next = t.next
+	t.next.prev = t.prev
+	t.prev, t.next = nil, nil
+}
+
+// slot is the circular list of timers with the sentinel
+type slot struct {
+	Timer
+}
+
+func (s *slot) init() {
+	s.prev, s.next = &s.Timer, &s.Timer
+}
+
+func (s *slot) push(t *Timer) {
+	t.prev, t.next = s.prev, &s.Timer
+	s.prev.next = t
+	s.prev = t
+}
+
+func (s *slot) empty() bool {
+	return s.next == &s.Timer
+}
+
+// moveTo moves all timers of the slot to the empty slot
+func (s *slot) moveTo(to *slot) {
+	if s.empty() {
+		return
+	}
+	to.next, to.prev = s.next, s.prev
+	to.next.prev, to.prev.next = &to.Timer, &to.Timer
+	s.init()
+}
+
+// Wheel is the hierarchical timing wheel, it is safe to add or cancel timers from other goroutines
+type Wheel struct {
+	lock       sync.Mutex
+	resolution time.Duration
+	startTime  time.Time
+	clock      func() time.Time // returns the current time, time.Now by default
+	current    int64            // next tick to run
+	count      int              // number of timers in the wheel
+	root       [rootSize]slot
+	levels     [numLevels][levelSize]slot
+	expired    slot // timers to fire in the running tick, so that they can still be cancelled by callbacks
+}
+
+// New creates the wheel of the resolution
+func New(resolution time.Duration) *Wheel {
+	if resolution <= 0 {
+		resolution = DefaultResolution
+	}
+	w := &Wheel{
+		resolution: resolution,
+		startTime:  time.Now(),
+		clock:      time.Now,
+	}
+	for i := range w.root {
+		w.root[i].init()
+	}
+	w.expired.init()
+	for l := range w.levels {
+		for i := range w.levels[l] {
+			w.levels[l][i].init()
+		}
+	}
+	return w
+}
+
+// AddCallback adds a callback which is called after the duration
+func (w *Wheel) AddCallback(d time.Duration, callback func()) *Timer {
+	return w.add(d, 0, callback)
+}
+
+// AddTimer adds a repeat timer which is called every duration
+func (w *Wheel) AddTimer(d time.Duration, callback func()) *Timer {
+	interval := int64(d / w.resolution)
+	if interval < 1 {
+		interval = 1
+	}
+	return w.add(d, interval, callback)
+}
+
+func (w *Wheel) add(d time.Duration, interval int64, callback func()) *Timer {
+	if d < 0 {
+		d = 0
+	}
+	t := &Timer{
+		wheel:    w,
+		interval: interval,
+		callback: callback,
+	}
+	w.lock.Lock()
+	elapsed := w.clock().Sub(w.startTime) + d
+	t.expire = int64((elapsed + w.resolution - 1) / w.resolution) // round up so that timers are never fired early
+	w.addTimer(t)
+	w.count += 1
+	w.lock.Unlock()
+	return t
+}
+
+// addTimer adds the timer to the slot of its expire tick, must be called with the lock
+func (w *Wheel) addTimer(t *Timer) {
+	ticks := t.expire - w.current
+	if ticks < 0 {
+		// already expired, fire in the next tick
+		w.root[w.current&rootMask].push(t)
+	} else if ticks < rootSize {
+		w.root[t.expire&rootMask].push(t)
+	} else {
+		expire := t.expire
+		if ticks > maxTicks {
+			expire = w.current + maxTicks // re-added when cascaded
+		}
+		for l := 0; l < numLevels; l++ {
+			if ticks < 1<<(rootBits+(l+1)*levelBits) || l == numLevels-1 {
+				w.levels[l][(expire>>(rootBits+l*levelBits))&levelMask].push(t)
+				break
+			}
+		}
+	}
+}
+
+// cascade re-adds timers in the current slot of the level to lower levels, and returns the index of the slot
+func (w *Wheel) cascade(l int) int64 {
+	index := (w.current >> (rootBits + l*levelBits)) & levelMask
+	var cascaded slot
+	cascaded.init()
+	w.levels[l][index].moveTo(&cascaded)
+	for !cascaded.empty() {
+		t := cascaded.next
+		t.unlink()
+		w.addTimer(t)
+	}
+	return index
+}
+
+// Tick fires all timers expired before now
+func (w *Wheel) Tick(now time.Time) {
+	target := int64(now.Sub(w.startTime) / w.resolution)
+	w.lock.Lock()
+	for w.current <= target {
+		if w.current&rootMask == 0 {
+			for l := 0; l < numLevels && w.cascade(l) == 0; l++ {
+			}
+		}
+
+		w.root[w.current&rootMask].moveTo(&w.expired)
+		w.current += 1
+		for !w.expired.empty() {
+			t := w.expired.next
+			t.unlink()
+			if t.expire >= w.current {
+				// clamped timers of long durations are not expired yet
+				w.addTimer(t)
+				continue
+			}
+
+			callback := t.callback
+			if t.interval > 0 {
+				t.expire += t.interval
+				if t.expire <= target {
+					t.expire = target + t.interval
+				}
+				w.addTimer(t)
+			} else {
+				t.callback = nil
+				w.count -= 1
+			}
+			// unlock to run the callback, since the callback might add or cancel timers
+			w.lock.Unlock()
+			gwutils.RunPanicless(callback)
+			w.lock.Lock()
+		}
+	}
+	w.lock.Unlock()
+}
+
+// SetClock sets the function returning the current time, which is used to virtualize time
+//
+// The wheel is rebased to the current time of the clock, so that pending timers keep their remaining ticks. The clock
+// should never go back, and Tick should be called with the time of the clock.
+func (w *Wheel) SetClock(clock func() time.Time) {
+	w.lock.Lock()
+	w.clock = clock
+	w.startTime = clock().Add(-time.Duration(w.current) * w.resolution)
+	w.lock.Unlock()
+}
+
+// Now returns the current time of the clock
+func (w *Wheel) Now() time.Time {
+	w.lock.Lock()
+	clock := w.clock
+	w.lock.Unlock()
+	return clock()
+}
+
+// Len returns the number of timers in the wheel
+func (w *Wheel) Len() int {
+	w.lock.Lock()
+	n := w.count
+	w.lock.Unlock()
+	return n
+}
+
+var defaultWheel = New(DefaultResolution)
+
+// AddCallback adds a callback to the default wheel, which is called after the duration
+func AddCallback(d time.Duration, callback func()) *Timer {
+	return defaultWheel.AddCallback(d, callback)
+}
+
+// AddTimer adds a repeat timer to the default wheel, which is called every duration
+func AddTimer(d time.Duration, callback func()) *Timer {
+	return defaultWheel.AddTimer(d, callback)
+}
+
+// Tick fires all expired timers of the default wheel, it is called by the game routine in every tick
+func Tick() {
+	defaultWheel.Tick(defaultWheel.Now())
+}
+
+// SetClock sets the clock of the default wheel, which is used to virtualize time
+func SetClock(clock func() time.Time) {
+	defaultWheel.SetClock(clock)
+}
+
+// Now returns the current time of the clock of the default wheel
+func Now() time.Time {
+	return defaultWheel.Now()
+}
+
+// Len returns the number of timers in the default wheel
+func Len() int {
+	return defaultWheel.Len()
+}
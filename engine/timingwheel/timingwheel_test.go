@@ -0,0 +1,160 @@
+package timingwheel
+
+import (
+	"math/rand"
+	"testing"
+	"time"
+
+	timer "github.com/xiaonanln/goTimer"
+)
+
+func TestWheel(t *testing.T) {
+	w := New(time.Millisecond)
+	var now time.Duration
+	durations := make([]time.Duration, 10000)
+	fireTimes := make([]time.Duration, len(durations))
+	for i := range durations {
+		i := i
+		durations[i] = time.Duration(rand.Int63n(int64(time.Minute * 2)))
+		w.AddCallback(durations[i], func() {
+			if fireTimes[i] != 0 {
+				t.Errorf("callback %d fired twice", i)
+			}
+			fireTimes[i] = now
+		})
+	}
+	cancelled := w.AddCallback(time.Second, func() {
+		t.Errorf("cancelled callback fired")
+	})
+	cancelled.Cancel()
+	if w.Len() != len(durations) {
+		t.Fatalf("wheel should have %d timers, but has %d", len(durations), w.Len())
+	}
+
+	for now = 0; now <= time.Minute*2+time.Millisecond*10; now += time.Millisecond * 5 {
+		tickAt(w, now)
+	}
+	for i, d := range durations {
+		if fireTimes[i] < d || fireTimes[i] > d+time.Millisecond*10 {
+			t.Errorf("callback of %s fired at %s", d, fireTimes[i])
+		}
+	}
+	if w.Len() != 0 {
+		t.Errorf("all timers should be fired, but %d left", w.Len())
+	}
+}
+
+func TestWheelRepeat(t *testing.T) {
+	w := New(time.Millisecond)
+	fired := 0
+	var tm *Timer
+	tm = w.AddTimer(time.Millisecond*300, func() {
+		fired++
+		if fired == 3 {
+			tm.Cancel()
+		}
+	})
+	var other *Timer
+	w.AddCallback(time.Millisecond*300, func() {
+		other.Cancel() // cancel the timer expiring in the same tick
+	})
+	other = w.AddCallback(time.Millisecond*300, func() {
+		t.Errorf("callback cancelled by other callbacks fired")
+	})
+
+	for now := time.Duration(0); now <= time.Second*2; now += time.Millisecond * 5 {
+		tickAt(w, now)
+	}
+	if fired != 3 || tm.IsActive() || w.Len() != 0 {
+		t.Errorf("timer should be fired 3 times before cancelled, but fired %d times, %d timers left", fired, w.Len())
+	}
+}
+
+// tickAt ticks the wheel as if the duration elapsed since the wheel is created
+func tickAt(w *Wheel, elapsed time.Duration) {
+	w.Tick(w.startTime.Add(elapsed))
+}
+
+// benchmarks run with 500000 pending buff/cooldown timers of 1 minute to 1 hour
+const benchmarkTimers = 500000
+
+var (
+	benchmarkWheel       *Wheel
+	benchmarkGoTimerInit bool
+)
+
+func setupBenchmarkWheel() *Wheel {
+	if benchmarkWheel == nil {
+		benchmarkWheel = New(time.Millisecond)
+		for i := 0; i < benchmarkTimers; i++ {
+			benchmarkWheel.AddTimer(time.Minute+time.Duration(rand.Int63n(int64(time.Hour))), func() {})
+		}
+	}
+	return benchmarkWheel
+}
+
+func setupBenchmarkGoTimer() {
+	if !benchmarkGoTimerInit {
+		benchmarkGoTimerInit = true
+		for i := 0; i < benchmarkTimers; i++ {
+			timer.AddTimer(time.Minute+time.Duration(rand.Int63n(int64(time.Hour))), func() {})
+		}
+	}
+}
+
+func BenchmarkWheelAddCancel(b *testing.B) {
+	w := setupBenchmarkWheel()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.AddCallback(time.Duration(rand.Int63n(int64(time.Hour))), func() {}).Cancel()
+	}
+}
+
+func BenchmarkGoTimerAddCancel(b *testing.B) {
+	setupBenchmarkGoTimer()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timer.AddCallback(time.Duration(rand.Int63n(int64(time.Hour))), func() {}).Cancel()
+	}
+}
+
+// BenchmarkWheelExpire measures adding and firing callbacks of random durations within 1 second
+func BenchmarkWheelExpire(b *testing.B) {
+	w := setupBenchmarkWheel()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w.AddCallback(time.Duration(rand.Int63n(int64(time.Second))), func() {})
+	}
+	w.Tick(time.Now().Add(time.Second))
+}
+
+func BenchmarkGoTimerExpire(b *testing.B) {
+	setupBenchmarkGoTimer()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		timer.AddCallback(-time.Duration(rand.Int63n(int64(time.Second))), func() {})
+	}
+	timer.Tick()
+}
+
+func TestWheelClock(t *testing.T) {
+	w := New(time.Millisecond)
+	now := time.Date(2000, 1, 1, 0, 0, 0, 0, time.UTC) // the wheel is rebased to the clock
+	w.SetClock(func() time.Time {
+		return now
+	})
+	fired := false
+	w.AddCallback(time.Hour, func() {
+		fired = true
+	})
+	now = now.Add(time.Hour - time.Millisecond)
+	w.Tick(w.Now())
+	if fired {
+		t.Fatalf("callback fired before the clock advanced")
+	}
+	now = now.Add(time.Millisecond * 2)
+	w.Tick(w.Now())
+	if !fired {
+		t.Errorf("callback should be fired after the clock advanced")
+	}
+}
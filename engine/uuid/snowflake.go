@@ -0,0 +1,85 @@
+package uuid
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"math/rand"
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/simulation"
+)
+
+const (
+	// encodeOrderedUUID has the same characters as encodeUUID in ascending order, so that encoded UUIDs are ordered as bytes
+	encodeOrderedUUID = ".0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZ_abcdefghijklmnopqrstuvwxyz"
+)
+
+var (
+	_orderedUUIDEncoding = base64.NewEncoding(encodeOrderedUUID).WithPadding(base64.NoPadding)
+)
+
+// Snowflake generates time-ordered UUIDs embedding the node ID
+//
+// Each UUID consists of the timestamp in milliseconds (6 bytes), the node ID (2 bytes) and the counter (4 bytes), so
+// UUIDs generated by the same node are strictly ordered, and UUIDs generated by different nodes never
+// conflict. The counter is never reset, so that last characters of UUIDs are evenly distributed.
+type Snowflake struct {
+	node uint16
+
+	lock      sync.Mutex
+	timestamp int64
+	counter   uint32
+}
+
+// NewSnowflake creates the Snowflake of the node, which should be unique among all nodes generating UUIDs
+func NewSnowflake(node uint16) *Snowflake {
+	return &Snowflake{
+		node:    node,
+		counter: rand.Uint32(), // avoid conflicts with UUIDs generated before restarting in the same millisecond
+	}
+}
+
+// Gen generates a new UUID
+func (sf *Snowflake) Gen() string {
+	now := time.Now()
+	if simulation.Enabled() {
+		now = simulation.Now()
+	}
+
+	sf.lock.Lock()
+	ts := now.UnixNano() / int64(time.Millisecond)
+	if ts < sf.timestamp {
+		ts = sf.timestamp // clock goes backward, keep UUIDs ordered
+	}
+	sf.counter++
+	if sf.counter == 0 {
+		ts++ // counter wraps around, keep UUIDs ordered
+	}
+	sf.timestamp = ts
+	counter := sf.counter
+	sf.lock.Unlock()
+
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(ts)<<16|uint64(sf.node))
+	binary.BigEndian.PutUint32(b[8:], counter)
+	return _orderedUUIDEncoding.EncodeToString(b[:])
+}
+
+// SnowflakeTime returns the time when the UUID is generated by Snowflake
+func SnowflakeTime(uuid string) (time.Time, bool) {
+	b, err := _orderedUUIDEncoding.DecodeString(uuid)
+	if err != nil || len(b) != 12 {
+		return time.Time{}, false
+	}
+	ts := int64(binary.BigEndian.Uint64(b[:8]) >> 16)
+	return time.Unix(0, ts*int64(time.Millisecond)), true
+}
+
+// MinSnowflake returns the smallest UUID generated by Snowflake at or after the time, which is the lower bound of ranges
+// of UUIDs generated after the time
+func MinSnowflake(t time.Time) string {
+	var b [12]byte
+	binary.BigEndian.PutUint64(b[:8], uint64(t.UnixNano()/int64(time.Millisecond))<<16)
+	return _orderedUUIDEncoding.EncodeToString(b[:])
+}
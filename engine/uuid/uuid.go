@@ -10,6 +10,8 @@ import (
 	"os"
 	"sync/atomic"
 	"time"
+
+	"github.com/xiaonanln/goworld/engine/simulation"
 )
 
 const (
@@ -26,6 +28,12 @@ var (
 // GenUUID generates a new unique ObjectId.
 func GenUUID() string {
 	var b = make([]byte, 12)
+	if simulation.Enabled() {
+		// logical time and the seeded RNG in the deterministic mode, so that the same UUIDs are generated in every run
+		binary.BigEndian.PutUint32(b[:], uint32(simulation.Now().Unix()))
+		binary.BigEndian.PutUint64(b[4:], simulation.Rand().Uint64())
+		return _UUIDEncoding.EncodeToString(b)
+	}
 	// Timestamp, 4 bytes, big endian
 	binary.BigEndian.PutUint32(b[:], uint32(time.Now().Unix()))
 	// Machine, first 3 bytes of md5(hostname)
@@ -3,6 +3,7 @@ package uuid
 import (
 	"strconv"
 	"testing"
+	"time"
 )
 
 func TestGenUUID(t *testing.T) {
@@ -32,3 +33,41 @@ func TestGenFixedUUID(t *testing.T) {
 		t.Logf("GenFixedUUID: %v => %v", i, u1)
 	}
 }
+
+func TestSnowflake(t *testing.T) {
+	sf := NewSnowflake(3)
+	prev := sf.Gen()
+	lastChars := map[string]bool{}
+	for i := 0; i < 1000; i++ {
+		uuid := sf.Gen()
+		if len(uuid) != UUID_LENGTH {
+			t.Fatalf("wrong length of snowflake UUID: %s", uuid)
+		}
+		if uuid <= prev {
+			t.Fatalf("snowflake UUIDs should be ordered, but %s <= %s", uuid, prev)
+		}
+		prev = uuid
+		lastChars[uuid[14:]] = true
+	}
+	if len(lastChars) < 500 {
+		t.Errorf("last characters of snowflake UUIDs should be distributed, but got %d distinct values", len(lastChars))
+	}
+
+	if ts, ok := SnowflakeTime(prev); !ok || time.Since(ts) > time.Minute || time.Since(ts) < -time.Minute {
+		t.Errorf("wrong time of snowflake UUID: %s", ts)
+	}
+}
+
+func TestMinSnowflake(t *testing.T) {
+	now := time.Now()
+	min := MinSnowflake(now.Add(-time.Millisecond))
+	if uuid := NewSnowflake(0xffff).Gen(); uuid <= min {
+		t.Errorf("UUIDs generated after the time should be larger than %s, but got %s", min, uuid)
+	}
+	if uuid := MinSnowflake(now.Add(time.Second)); uuid <= min {
+		t.Errorf("MinSnowflake should be ordered by time")
+	}
+	if ts, ok := SnowflakeTime(min); !ok || ts.UnixNano()/int64(time.Millisecond) != now.Add(-time.Millisecond).UnixNano()/int64(time.Millisecond) {
+		t.Errorf("wrong time of MinSnowflake: %s", ts)
+	}
+}
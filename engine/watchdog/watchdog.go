@@ -0,0 +1,285 @@
+// Package watchdog monitors goroutines, heap size and main loop stalls of the process, notifies callbacks when
+// thresholds are reached, and runs mitigations (force GC, dump pprof profiles, drain) at critical thresholds before
+// the process is killed by the OOM killer.
+package watchdog
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"runtime/debug"
+	"runtime/pprof"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Level is the alert level of monitored metrics
+type Level int
+
+const (
+	// LevelNormal means the metric is below the warn threshold
+	LevelNormal Level = iota
+	// LevelWarn means the metric reaches the warn threshold
+	LevelWarn
+	// LevelCritical means the metric reaches the critical threshold, mitigations are run
+	LevelCritical
+)
+
+func (lv Level) String() string {
+	switch lv {
+	case LevelNormal:
+		return "normal"
+	case LevelWarn:
+		return "warn"
+	case LevelCritical:
+		return "critical"
+	default:
+		return fmt.Sprintf("Level(%d)", int(lv))
+	}
+}
+
+// Monitored metrics
+const (
+	MetricGoroutines = "goroutines" // number of goroutines
+	MetricHeap       = "heap"       // allocated heap objects in bytes
+	MetricStall      = "stall"      // time since the last heartbeat of main loop in milliseconds
+)
+
+// Mitigations run when any metric reaches the critical threshold
+const (
+	ActionGC      = "gc"      // force GC and return memory to the OS
+	ActionProfile = "profile" // dump heap and goroutine profiles
+	ActionDrain   = "drain"   // call the drain handler of the component
+)
+
+// Thresholds are the warn and critical thresholds of metric, 0 to disable
+type Thresholds struct {
+	Warn     int64
+	Critical int64
+}
+
+func (t Thresholds) level(value int64) (Level, int64) {
+	if t.Critical > 0 && value >= t.Critical {
+		return LevelCritical, t.Critical
+	} else if t.Warn > 0 && value >= t.Warn {
+		return LevelWarn, t.Warn
+	}
+	return LevelNormal, t.Warn
+}
+
+func (t Thresholds) enabled() bool {
+	return t.Warn > 0 || t.Critical > 0
+}
+
+// Config configures the watchdog
+type Config struct {
+	CheckInterval   time.Duration
+	Goroutines      Thresholds
+	Heap            Thresholds // in bytes
+	Stall           Thresholds // in milliseconds
+	CriticalActions []string   // ActionGC, ActionProfile or ActionDrain
+	ProfileDir      string
+	ActionCooldown  time.Duration // min interval between mitigations
+}
+
+func (c *Config) enabled() bool {
+	return c.Goroutines.enabled() || c.Heap.enabled() || c.Stall.enabled()
+}
+
+// Alert is the change of alert level of metric
+type Alert struct {
+	Metric    string
+	Level     Level
+	Value     int64
+	Threshold int64 // the threshold reached, or the warn threshold if the level is back to normal
+}
+
+func (a Alert) String() string {
+	return fmt.Sprintf("watchdog: %s is %s: %d (threshold %d)", a.Metric, a.Level, a.Value, a.Threshold)
+}
+
+var (
+	lock           sync.Mutex
+	alertCallbacks []func(alert Alert)
+	drainHandler   func(draining bool)
+	lastHeartbeat  int64 // unix time in nanoseconds, 0 if main loop is not monitored
+)
+
+// OnAlert registers the callback which is called when the alert level of any metric changes
+//
+// Callbacks are called in the watchdog goroutine, since the main loop might be stalled.
+func OnAlert(cb func(alert Alert)) {
+	lock.Lock()
+	alertCallbacks = append(alertCallbacks, cb)
+	lock.Unlock()
+}
+
+// SetDrainHandler sets the handler of drain action, which is called in the watchdog goroutine
+//
+// The handler is called with true when the drain action runs, and with false when no metric is critical any more. Since
+// the main loop might be stalled, the handler should start draining without waiting for the main loop, e.g. by
+// notifying dispatchers directly.
+func SetDrainHandler(handler func(draining bool)) {
+	lock.Lock()
+	drainHandler = handler
+	lock.Unlock()
+}
+
+// Heartbeat should be called by the main loop periodically, so that stalls of main loop can be detected
+func Heartbeat() {
+	atomic.StoreInt64(&lastHeartbeat, time.Now().UnixNano())
+}
+
+// Start starts the watchdog in a new goroutine, the watchdog is not started if no threshold is set
+func Start(component string, config Config) {
+	if !config.enabled() {
+		return
+	}
+
+	w := newWatchdog(component, config)
+	gwlog.Infof("watchdog of %s started: %+v", component, config)
+	go func() {
+		for {
+			time.Sleep(config.CheckInterval)
+			w.check(time.Now())
+		}
+	}()
+}
+
+type _Watchdog struct {
+	component      string
+	config         Config
+	levels         map[string]Level
+	lastActionTime time.Time
+	draining       bool // the drain action ran, and metrics are not back from critical yet
+}
+
+func newWatchdog(component string, config Config) *_Watchdog {
+	return &_Watchdog{
+		component: component,
+		config:    config,
+		levels:    map[string]Level{},
+	}
+}
+
+func (w *_Watchdog) check(now time.Time) {
+	critical := false
+	if w.config.Goroutines.enabled() {
+		critical = w.checkMetric(MetricGoroutines, int64(runtime.NumGoroutine()), w.config.Goroutines) || critical
+	}
+	if w.config.Heap.enabled() {
+		var ms runtime.MemStats
+		runtime.ReadMemStats(&ms)
+		critical = w.checkMetric(MetricHeap, int64(ms.HeapAlloc), w.config.Heap) || critical
+	}
+	if heartbeat := atomic.LoadInt64(&lastHeartbeat); w.config.Stall.enabled() && heartbeat != 0 {
+		stall := now.Sub(time.Unix(0, heartbeat)) / time.Millisecond
+		critical = w.checkMetric(MetricStall, int64(stall), w.config.Stall) || critical
+	}
+
+	if critical && now.Sub(w.lastActionTime) >= w.config.ActionCooldown {
+		w.lastActionTime = now
+		w.mitigate(now)
+	} else if !critical && w.draining {
+		w.draining = false
+		gwlog.Infof("%s: watchdog stops draining", w.component)
+		w.callDrainHandler(false)
+	}
+}
+
+// checkMetric notifies the alert if the level of metric is changed, and returns if the metric is critical
+func (w *_Watchdog) checkMetric(metric string, value int64, thresholds Thresholds) bool {
+	level, threshold := thresholds.level(value)
+	if level != w.levels[metric] {
+		w.levels[metric] = level
+		w.notify(Alert{Metric: metric, Level: level, Value: value, Threshold: threshold})
+	}
+	return level == LevelCritical
+}
+
+func (w *_Watchdog) notify(alert Alert) {
+	if alert.Level == LevelNormal {
+		gwlog.Infof("%s: %s", w.component, alert)
+	} else {
+		gwlog.Warnf("%s: %s", w.component, alert)
+	}
+
+	lock.Lock()
+	callbacks := alertCallbacks
+	lock.Unlock()
+	for _, cb := range callbacks {
+		runCallback(func() {
+			cb(alert)
+		})
+	}
+}
+
+func (w *_Watchdog) mitigate(now time.Time) {
+	for _, action := range w.config.CriticalActions {
+		gwlog.Warnf("%s: watchdog runs mitigation: %s", w.component, action)
+		switch action {
+		case ActionGC:
+			debug.FreeOSMemory()
+		case ActionProfile:
+			if err := w.dumpProfiles(now); err != nil {
+				gwlog.Errorf("%s: watchdog dump profiles failed: %s", w.component, err)
+			}
+		case ActionDrain:
+			w.draining = true
+			w.callDrainHandler(true)
+		default:
+			gwlog.Errorf("%s: watchdog unknown mitigation: %s", w.component, action)
+		}
+	}
+}
+
+func (w *_Watchdog) callDrainHandler(draining bool) {
+	lock.Lock()
+	handler := drainHandler
+	lock.Unlock()
+	if handler != nil {
+		runCallback(func() {
+			handler(draining)
+		})
+	} else {
+		gwlog.Warnf("%s: watchdog drain handler is not set", w.component)
+	}
+}
+
+// dumpProfiles writes heap and goroutine profiles to <profile_dir>/<component>-<time>-<profile>.pprof
+func (w *_Watchdog) dumpProfiles(now time.Time) error {
+	if err := os.MkdirAll(w.config.ProfileDir, 0755); err != nil {
+		return err
+	}
+
+	for _, name := range []string{"heap", "goroutine"} {
+		file := filepath.Join(w.config.ProfileDir, fmt.Sprintf("%s-%s-%s.pprof", w.component, now.Format("20060102-150405"), name))
+		f, err := os.Create(file)
+		if err != nil {
+			return err
+		}
+		err = pprof.Lookup(name).WriteTo(f, 0)
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+		if err != nil {
+			return err
+		}
+		gwlog.Infof("%s: watchdog dumped %s profile to %s", w.component, name, file)
+	}
+	return nil
+}
+
+// runCallback runs the callback panic-freely, gwutils.RunPanicless is not used since its panic handler is for the main loop
+func runCallback(f func()) {
+	defer func() {
+		if err := recover(); err != nil {
+			gwlog.TraceError("watchdog callback paniced: %v", err)
+		}
+	}()
+	f()
+}
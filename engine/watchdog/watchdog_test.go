@@ -0,0 +1,66 @@
+package watchdog
+
+import (
+	"testing"
+	"time"
+)
+
+func TestThresholds(t *testing.T) {
+	thresholds := Thresholds{Warn: 10, Critical: 20}
+	for value, expected := range map[int64]Level{0: LevelNormal, 9: LevelNormal, 10: LevelWarn, 19: LevelWarn, 20: LevelCritical, 100: LevelCritical} {
+		if level, _ := thresholds.level(value); level != expected {
+			t.Errorf("level of %d should be %s, but is %s", value, expected, level)
+		}
+	}
+	if level, _ := (Thresholds{Critical: 20}).level(15); level != LevelNormal {
+		t.Errorf("level should be normal if warn threshold is disabled, but is %s", level)
+	}
+}
+
+func TestStallAlert(t *testing.T) {
+	var alerts []Alert
+	OnAlert(func(alert Alert) {
+		alerts = append(alerts, alert)
+	})
+	drained, undrained := 0, 0
+	SetDrainHandler(func(draining bool) {
+		if draining {
+			drained++
+		} else {
+			undrained++
+		}
+	})
+	defer func() {
+		alertCallbacks = nil
+		drainHandler = nil
+		lastHeartbeat = 0
+	}()
+
+	w := newWatchdog("test", Config{
+		Stall:           Thresholds{Warn: 100, Critical: 1000},
+		CriticalActions: []string{ActionDrain},
+		ActionCooldown:  time.Minute,
+	})
+	Heartbeat()
+	now := time.Now()
+	w.check(now.Add(time.Millisecond * 200))
+	w.check(now.Add(time.Millisecond * 300))
+	w.check(now.Add(time.Millisecond * 2000))
+	w.check(now.Add(time.Millisecond * 3000))
+
+	if len(alerts) != 2 || alerts[0].Level != LevelWarn || alerts[1].Level != LevelCritical || alerts[1].Metric != MetricStall {
+		t.Fatalf("should alert warn and critical once, but alerts are %v", alerts)
+	}
+	if drained != 1 || undrained != 0 {
+		t.Errorf("should drain once in cooldown, but drained %d times, undrained %d times", drained, undrained)
+	}
+
+	Heartbeat()
+	w.check(time.Now())
+	if len(alerts) != 3 || alerts[2].Level != LevelNormal {
+		t.Errorf("should alert normal after heartbeat, but alerts are %v", alerts)
+	}
+	if undrained != 1 {
+		t.Errorf("should stop draining after heartbeat, but undrained %d times", undrained)
+	}
+}
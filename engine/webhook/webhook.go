@@ -0,0 +1,193 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	_ASYNC_JOB_GROUP = "_webhook"
+	// _RETRY_DELAY is the delay before the first retry of the failed request, which is doubled for each retry
+	_RETRY_DELAY = time.Second
+	// _MAX_RETRY_DELAY is the max delay between retries
+	_MAX_RETRY_DELAY = time.Second * 30
+
+	// SignatureHeader is the HTTP header of the HMAC-SHA256 signature of the timestamp, the nonce and the request body
+	SignatureHeader = "X-GoWorld-Signature"
+	// TimestampHeader is the HTTP header of the unix time in seconds when the request is signed
+	TimestampHeader = "X-GoWorld-Timestamp"
+	// NonceHeader is the HTTP header of the random nonce of the request
+	NonceHeader = "X-GoWorld-Nonce"
+	// EventHeader is the HTTP header of the event name
+	EventHeader = "X-GoWorld-Event"
+)
+
+// Event is the entity event delivered to webhooks in JSON
+type Event struct {
+	Event      string          `json:"event"`
+	EntityID   common.EntityID `json:"entity_id"`
+	EntityType string          `json:"entity_type"`
+	Time       int64           `json:"time"` // unix time in milliseconds
+	Data       interface{}     `json:"data"`
+}
+
+// PostEvent posts the entity event to all configured webhooks asynchronously, events are dropped silently if webhook
+// urls are not configured
+func PostEvent(entityID common.EntityID, entityType string, event string, data interface{}) {
+	cfg := config.GetWebhook()
+	if len(cfg.URLs) == 0 {
+		return
+	}
+
+	body, err := json.Marshal(&Event{
+		Event:      event,
+		EntityID:   entityID,
+		EntityType: entityType,
+		Time:       time.Now().UnixNano() / int64(time.Millisecond),
+		Data:       data,
+	})
+	if err != nil {
+		gwlog.Errorf("webhook: marshal event %s of %s<%s> failed: %v", event, entityType, entityID, err)
+		return
+	}
+
+	for _, url := range cfg.URLs {
+		url := url
+		// each URL has its own job group, so that retries of the failing URL do not delay events to other URLs
+		async.AppendAsyncJob(_ASYNC_JOB_GROUP+":"+url, func() (res interface{}, err error) {
+			delay := _RETRY_DELAY
+			for i := 0; i <= cfg.Retries; i++ {
+				if i > 0 {
+					time.Sleep(delay)
+					if delay *= 2; delay > _MAX_RETRY_DELAY {
+						delay = _MAX_RETRY_DELAY
+					}
+				}
+				if err = deliver(url, event, body, cfg.Secret, cfg.Timeout); err == nil {
+					return
+				}
+			}
+			return
+		}, func(res interface{}, err error) {
+			if err != nil {
+				gwlog.Errorf("webhook: deliver event %s of %s<%s> to %s failed: %v", event, entityType, entityID, url, err)
+			}
+		})
+	}
+}
+
+// Sign returns the HMAC-SHA256 signature of the timestamp, the nonce and the body in hex
+func Sign(secret string, timestamp string, nonce string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write([]byte(nonce))
+	mac.Write([]byte{'.'})
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func newNonce() string {
+	nonce := make([]byte, 16)
+	if _, err := rand.Read(nonce); err != nil {
+		gwlog.Panic(err)
+	}
+	return hex.EncodeToString(nonce)
+}
+
+// Verifier verifies signatures of webhook requests for receivers written in Go, requests signed out of the window or
+// replayed in the window are rejected
+//
+// Verifier can be used in multiple goroutines.
+type Verifier struct {
+	secret string
+	window time.Duration
+
+	lock   sync.Mutex
+	nonces map[string]time.Time // nonce -> signing time of requests in the window
+}
+
+// NewVerifier creates the verifier of requests signed by the secret, which accepts requests signed within the window
+// of current time
+func NewVerifier(secret string, window time.Duration) *Verifier {
+	return &Verifier{
+		secret: secret,
+		window: window,
+		nonces: map[string]time.Time{},
+	}
+}
+
+// Verify verifies the signature, the timestamp and the nonce of the request
+func (v *Verifier) Verify(header http.Header, body []byte) error {
+	return v.verify(header, body, time.Now())
+}
+
+func (v *Verifier) verify(header http.Header, body []byte, now time.Time) error {
+	timestamp, nonce := header.Get(TimestampHeader), header.Get(NonceHeader)
+	unix, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil || nonce == "" {
+		return errors.Errorf("timestamp or nonce is missing")
+	}
+	signature := Sign(v.secret, timestamp, nonce, body)
+	if !hmac.Equal([]byte(signature), []byte(header.Get(SignatureHeader))) {
+		return errors.Errorf("invalid signature")
+	}
+
+	signTime := time.Unix(unix, 0)
+	if signTime.Before(now.Add(-v.window)) || signTime.After(now.Add(v.window)) {
+		return errors.Errorf("request is signed at %s, which is out of the window", signTime.Format(time.RFC3339))
+	}
+
+	v.lock.Lock()
+	defer v.lock.Unlock()
+	for n, t := range v.nonces {
+		if t.Before(now.Add(-v.window)) {
+			delete(v.nonces, n)
+		}
+	}
+	if _, ok := v.nonces[nonce]; ok {
+		return errors.Errorf("request is replayed")
+	}
+	v.nonces[nonce] = signTime
+	return nil
+}
+
+// deliver signs the request with the current time and a new nonce, and posts it to the URL
+func deliver(url string, event string, body []byte, secret string, timeout time.Duration) error {
+	req, err := http.NewRequest("POST", url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp, nonce := strconv.FormatInt(time.Now().Unix(), 10), newNonce()
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(EventHeader, event)
+	req.Header.Set(TimestampHeader, timestamp)
+	req.Header.Set(NonceHeader, nonce)
+	req.Header.Set(SignatureHeader, Sign(secret, timestamp, nonce, body))
+
+	client := http.Client{Timeout: timeout}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return errors.Errorf("status %s", resp.Status)
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+package webhook
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestDeliver(t *testing.T) {
+	var recvBody []byte
+	var recvHeader http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		recvBody, _ = ioutil.ReadAll(r.Body)
+		recvHeader = r.Header
+	}))
+	defer server.Close()
+
+	body := []byte(`{"event":"pay"}`)
+	if err := deliver(server.URL, "pay", body, "secret", time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if string(recvBody) != string(body) || recvHeader.Get(EventHeader) != "pay" {
+		t.Errorf("wrong request: body %s, event %s", recvBody, recvHeader.Get(EventHeader))
+	}
+	if err := NewVerifier("secret", time.Minute).Verify(recvHeader, recvBody); err != nil {
+		t.Errorf("request should be verified, but got %v", err)
+	}
+	if err := NewVerifier("other", time.Minute).Verify(recvHeader, recvBody); err == nil {
+		t.Errorf("request signed by other secret should not be verified")
+	}
+
+	failServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer failServer.Close()
+	if err := deliver(failServer.URL, "pay", body, "secret", time.Second); err == nil {
+		t.Errorf("deliver should fail if webhook returns error status")
+	}
+}
+
+func signedHeader(secret string, signTime time.Time, nonce string, body []byte) http.Header {
+	timestamp := strconv.FormatInt(signTime.Unix(), 10)
+	header := http.Header{}
+	header.Set(TimestampHeader, timestamp)
+	header.Set(NonceHeader, nonce)
+	header.Set(SignatureHeader, Sign(secret, timestamp, nonce, body))
+	return header
+}
+
+func TestVerifier(t *testing.T) {
+	v := NewVerifier("secret", time.Minute)
+	now := time.Now()
+	body := []byte(`{"event":"pay"}`)
+
+	header := signedHeader("secret", now, "nonce1", body)
+	if err := v.verify(header, body, now); err != nil {
+		t.Fatalf("request should be verified, but got %v", err)
+	}
+	if err := v.verify(header, body, now.Add(time.Second)); err == nil {
+		t.Errorf("replayed request should be rejected")
+	}
+	if err := v.verify(header, []byte(`{"event":"refund"}`), now); err == nil {
+		t.Errorf("request of tampered body should be rejected")
+	}
+
+	header.Set(TimestampHeader, strconv.FormatInt(now.Add(time.Second).Unix(), 10))
+	if err := v.verify(header, body, now); err == nil {
+		t.Errorf("request of tampered timestamp should be rejected")
+	}
+
+	if err := v.verify(signedHeader("secret", now.Add(-time.Minute*2), "nonce2", body), body, now); err == nil {
+		t.Errorf("request signed before the window should be rejected")
+	}
+	if err := v.verify(signedHeader("secret", now.Add(time.Minute*2), "nonce3", body), body, now); err == nil {
+		t.Errorf("request signed after the window should be rejected")
+	}
+	if err := v.verify(http.Header{}, body, now); err == nil {
+		t.Errorf("request without timestamp and nonce should be rejected")
+	}
+
+	// nonces out of the window are forgotten, since requests of the nonces are rejected by timestamps
+	if err := v.verify(signedHeader("secret", now.Add(time.Minute*2), "nonce4", body), body, now.Add(time.Minute*2)); err != nil {
+		t.Errorf("request should be verified, but got %v", err)
+	}
+	if _, ok := v.nonces["nonce1"]; ok {
+		t.Errorf("nonces out of the window should be removed")
+	}
+}
@@ -0,0 +1,130 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/rand"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/binutil"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
+	"github.com/xiaonanln/goworld/ext/botclient"
+)
+
+const requestTimeout = time.Second * 30
+
+var (
+	gates          string
+	numBots        int
+	startID        int
+	rampUp         time.Duration
+	duration       time.Duration
+	reportInterval time.Duration
+	useWebSocket   bool
+	useTLS         bool
+	compress       string
+	encrypt        string
+	authToken      string
+	gateKeyFile    string
+	loglevel       string
+)
+
+func parseArgs() {
+	flag.StringVar(&gates, "gates", "localhost:14001", "addresses of gates separated by comma")
+	flag.IntVar(&numBots, "N", 1000, "number of bots")
+	flag.IntVar(&startID, "S", 1, "start ID of bots")
+	flag.DurationVar(&rampUp, "rampup", time.Minute, "start bots evenly in the duration")
+	flag.DurationVar(&duration, "duration", time.Minute*10, "stop bots after the duration")
+	flag.DurationVar(&reportInterval, "report", time.Second*10, "interval of reports")
+	flag.BoolVar(&useWebSocket, "ws", false, "use WebSocket to connect gates")
+	flag.BoolVar(&useTLS, "tls", false, "use TLS to connect gates")
+	flag.StringVar(&compress, "compress", "", "negotiate packet compression with gates using compress formats (e.x. zstd,snappy)")
+	flag.StringVar(&encrypt, "encrypt", "", "exchange keys with gates and encrypt packets using cipher formats (e.x. chacha20-poly1305,aes-gcm)")
+	flag.StringVar(&authToken, "token", "", "authenticate with gates using the token")
+	flag.StringVar(&gateKeyFile, "gatekey", "", "verify key exchanges signed by gates using the pinned gate certificate (e.x. rsa.crt)")
+	flag.StringVar(&loglevel, "log", "warn", "set log level")
+	flag.Parse()
+}
+
+func main() {
+	parseArgs()
+	binutil.SetupGWLog("test_bots", loglevel, "test_bots.log", true, "")
+
+	opts := botclient.Options{WebSocket: useWebSocket, TLS: useTLS, AuthToken: authToken}
+	if compress != "" {
+		opts.CompressFormats = strings.Split(compress, ",")
+	}
+	if encrypt != "" {
+		opts.CipherFormats = strings.Split(encrypt, ",")
+	}
+	if gateKeyFile != "" {
+		gatePublicKey, err := crypt.LoadPublicKey(gateKeyFile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "load gate key failed: %v\n", err)
+			os.Exit(1)
+		}
+		opts.GatePublicKey = gatePublicKey
+	}
+
+	runner := &botclient.Runner{
+		Addrs:          strings.Split(gates, ","),
+		Options:        opts,
+		NumBots:        numBots,
+		StartID:        startID,
+		RampUp:         rampUp,
+		Duration:       duration,
+		ReportInterval: reportInterval,
+		OnReport: func(r *botclient.Report) {
+			fmt.Fprint(os.Stdout, r)
+		},
+		Script: playTestGame,
+	}
+	fmt.Fprintf(os.Stdout, "final report:\n%s", runner.Run())
+}
+
+// playTestGame logins the Avatar of test_game, and then moves around and gets mails randomly
+func playTestGame(bot *botclient.Bot) error {
+	account, err := bot.WaitPlayer("Account", requestTimeout)
+	if err != nil {
+		return err
+	}
+
+	err = bot.Measure("login", func() error {
+		username := fmt.Sprintf("test%d", bot.ID)
+		call, err := bot.Request(account.ID, "Login", []interface{}{username, "123456"}, "OnLogin", requestTimeout)
+		if err != nil {
+			return err
+		}
+		var ok bool
+		if err := call.Arg(0, &ok); err != nil || !ok {
+			return errors.Errorf("login failed: %v", err)
+		}
+		_, err = bot.WaitPlayer("Avatar", requestTimeout)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	var pos entity.Vector3
+	for bot.Sleep(time.Millisecond * 100) {
+		avatar := bot.Player()
+		if avatar == nil {
+			return errors.New("avatar is destroyed")
+		}
+
+		pos.X += entity.Coord(rand.Float32() - 0.5)
+		pos.Z += entity.Coord(rand.Float32() - 0.5)
+		bot.SyncPositionYaw(pos, entity.Yaw(rand.Float32()*3.14))
+		if rand.Intn(100) == 0 {
+			if _, err := bot.Request(avatar.ID, "GetMails", nil, "OnGetMails", requestTimeout); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
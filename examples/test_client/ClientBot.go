@@ -14,6 +14,8 @@ import (
 
 	"crypto/tls"
 
+	"strings"
+
 	"github.com/xiaonanln/goworld/engine/common"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/consts"
@@ -21,6 +23,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/gwioutil"
 	"github.com/xiaonanln/goworld/engine/gwlog"
 	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
 	"github.com/xiaonanln/goworld/engine/post"
 	"github.com/xiaonanln/goworld/engine/proto"
 	"github.com/xtaci/kcp-go"
@@ -54,6 +57,8 @@ type ClientBot struct {
 	useWebSocket       bool
 	noEntitySync       bool
 	packetQueue        chan proto.Message
+	sessionToken       string
+	keyPair            *crypt.KeyPair
 }
 
 func newClientBot(id int, useWebSocket bool, useKCP bool, noEntitySync bool, waiter *sync.WaitGroup, waitAllConnected *sync.WaitGroup) *ClientBot {
@@ -109,6 +114,25 @@ func (bot *ClientBot) run() {
 		bot.conn.SetHeartbeatFromClient()
 	}
 
+	bot.conn.SendProtocolVersionFromClient(proto.CLIENT_PROTOCOL_VERSION)
+
+	if compress != "" {
+		bot.conn.SendNegotiateCompressionFromClient(strings.Split(compress, ","))
+	}
+
+	if encrypt != "" {
+		keyPair, err := crypt.GenerateKeyPair()
+		if err != nil {
+			gwlog.Panic(err)
+		}
+		bot.keyPair = keyPair
+		bot.conn.SendKeyExchangeFromClient(strings.Split(encrypt, ","), keyPair.PublicKey[:])
+	}
+
+	if authToken != "" {
+		bot.conn.SendAuthFromClient(authToken)
+	}
+
 	go bot.recvLoop()
 	bot.waitAllConnected.Done()
 
@@ -186,12 +210,60 @@ func (bot *ClientBot) connectServerByWebsocket(cfg *config.GateConfig) (net.Conn
 	}
 }
 
+func (bot *ClientBot) setupCipher(cipherFormat string, gatePublicKey []byte, signature []byte) {
+	if pinnedGateKey != nil {
+		// packets are not protected if the gate negotiates no cipher format, which can be forged to downgrade the connection
+		if cipherFormat == "" {
+			gwlog.Panicf("%s: gate negotiated no cipher format, but gate key is pinned", bot)
+		}
+		if err := crypt.VerifyKeyExchange(pinnedGateKey, cipherFormat, bot.keyPair.PublicKey[:], gatePublicKey, signature); err != nil {
+			gwlog.Panic(err)
+		}
+	}
+	sendKey, recvKey, err := bot.keyPair.DeriveKeys(gatePublicKey, true)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	if err := bot.conn.SetDecryption(cipherFormat, recvKey); err != nil {
+		gwlog.Panic(err)
+	}
+	if err := bot.conn.SetEncryption(cipherFormat, sendKey); err != nil {
+		gwlog.Panic(err)
+	}
+}
+
 func (bot *ClientBot) recvLoop() {
 	var msgtype proto.MsgType
 
 	for {
 		pkt, err := bot.conn.Recv(&msgtype)
-		if pkt != nil {
+		if pkt != nil && msgtype == proto.MT_SET_CLIENT_COMPRESSION {
+			// switch compression in the receiving goroutine, because following packets are compressed in the new format
+			compressFormat := pkt.ReadVarStr()
+			threshold := pkt.ReadUint32()
+			gwlog.Debugf("%s: set compression: format %#v, threshold %d", bot, compressFormat, threshold)
+			bot.conn.SetDecompression(compressFormat)
+			bot.conn.SetCompression(compressFormat, threshold)
+			pkt.Release()
+		} else if pkt != nil && msgtype == proto.MT_SET_CLIENT_CIPHER {
+			// switch encryption in the receiving goroutine, because following packets are encrypted
+			cipherFormat := pkt.ReadVarStr()
+			gatePublicKey := pkt.ReadVarBytes()
+			var signature []byte
+			if pkt.HasUnreadPayload() {
+				signature = pkt.ReadVarBytes()
+			}
+			pkt.Release()
+			gwlog.Debugf("%s: set cipher: format %#v", bot, cipherFormat)
+			if cipherFormat != "" || pinnedGateKey != nil {
+				bot.setupCipher(cipherFormat, gatePublicKey, signature)
+			}
+		} else if pkt != nil && msgtype == proto.MT_PING_TO_CLIENT {
+			// reply ping in the receiving goroutine, so that latency is not affected by the packet queue
+			seq := pkt.ReadUint64()
+			pkt.Release()
+			bot.conn.SendPongFromClient(seq)
+		} else if pkt != nil {
 			//fmt.Fprintf(os.Stderr, "P")
 			bot.packetQueue <- proto.Message{msgtype, pkt}
 		} else if err != nil && !gwioutil.IsTimeoutError(err) {
@@ -369,6 +441,45 @@ func (bot *ClientBot) handlePacket(msgtype proto.MsgType, packet *netutil.Packet
 		//} else if msgtype == proto.MT_SET_CLIENT_CLIENTID {
 		//	clientid := packet.ReadClientID()
 		//	bot.setClientID(clientid)
+	} else if msgtype == proto.MT_SET_CLIENT_SESSION_TOKEN {
+		bot.sessionToken = packet.ReadVarStr()
+	} else if msgtype == proto.MT_NOTIFY_GATE_DRAINING {
+		gateAddr := packet.ReadVarStr()
+		ownerID := packet.ReadEntityID()
+		sessionToken := packet.ReadVarStr()
+		gwlog.Warnf("%s: gate is draining, should reconnect to gate %s and resume session of %s using token %s", bot, gateAddr, ownerID, sessionToken)
+	} else if msgtype == proto.MT_AUTH_RESULT_ON_CLIENT {
+		ok := packet.ReadBool()
+		reason := packet.ReadVarStr()
+		if !ok {
+			Errorf("%s: authentication failed: %s", bot, reason)
+		} else {
+			gwlog.Infof("%s: authenticated", bot)
+		}
+	} else if msgtype == proto.MT_SET_CLIENT_PROTOCOL_VERSION {
+		accepted := packet.ReadBool()
+		version := packet.ReadUint16()
+		if !accepted {
+			Errorf("%s: protocol version %d rejected, min protocol version is %d", bot, proto.CLIENT_PROTOCOL_VERSION, version)
+		} else {
+			gwlog.Infof("%s: protocol version %d", bot, version)
+		}
+	} else if msgtype == proto.MT_REDIRECT_TO_GATE_ON_CLIENT {
+		gateAddr := packet.ReadVarStr()
+		ownerID := packet.ReadEntityID()
+		sessionToken := packet.ReadVarStr()
+		gwlog.Warnf("%s: redirected to gate %s, should reconnect and resume session of %s using token %s", bot, gateAddr, ownerID, sessionToken)
+	} else if msgtype == proto.MT_NOTIFY_SESSION_RESUMED_ON_CLIENT {
+		ownerID := packet.ReadEntityID()
+		ok := packet.ReadBool()
+		gwlog.Infof("%s: resume session of %s: %v", bot, ownerID, ok)
+	} else if msgtype == proto.MT_NOTIFY_SHUTDOWN_ON_CLIENT {
+		reason := packet.ReadVarStr()
+		countdown := packet.ReadUint32()
+		gwlog.Warnf("%s: game is shutting down in %d seconds: %s", bot, countdown, reason)
+	} else if msgtype == proto.MT_KICKED_ON_CLIENT {
+		reason := packet.ReadVarStr()
+		gwlog.Warnf("%s: kicked: %s", bot, reason)
 	} else {
 		gwlog.Panicf("unknown msgtype: %v", msgtype)
 	}
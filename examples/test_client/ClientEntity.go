@@ -171,6 +171,7 @@ var (
 		//{"DoGetMails", 1, time.Second * 5},
 		{"DoSayInWorldChannel", 1, time.Second * 5},
 		{"DoSayInProfChannel", 1, time.Second * 5},
+		{"DoChatInWorldChannel", 1, time.Second * 5},
 		{"DoTestListField", 1, time.Second * 5},
 		//{"DoTestPublish", 1, time.Second * 5},
 		{"DoTestAOI", 1, time.Second * 5},
@@ -331,6 +332,26 @@ func (e *clientEntity) OnSay(senderID common.EntityID, senderName string, channe
 	}
 }
 
+func (e *clientEntity) DoChatInWorldChannel() {
+	e.CallServer("ChatSend", "world", "this is a chat message in world channel")
+}
+
+func (e *clientEntity) OnChatMessage(channel string, senderID common.EntityID, senderName string, text string, sendTime int64) {
+	if channel == "world" && senderID == e.ID {
+		e.notifyThingDone("DoChatInWorldChannel")
+	}
+}
+
+func (e *clientEntity) OnChatHistory(channel string, messages []interface{}) {
+	gwlog.Debugf("OnChatHistory: channel=%s, messages=%d", channel, len(messages))
+}
+
+func (e *clientEntity) OnChatRejected(reason string) {
+	// messages might be rejected by rate limiting
+	gwlog.Debugf("OnChatRejected: %s", reason)
+	e.notifyThingDone("DoChatInWorldChannel")
+}
+
 func (e *clientEntity) DoTestListField() {
 	e.CallServer("TestListField")
 }
@@ -1,6 +1,7 @@
 package main
 
 import (
+	"crypto"
 	"flag"
 
 	"sync"
@@ -8,14 +9,13 @@ import (
 	"math/rand"
 	"time"
 
-	_ "net/http/pprof"
-
 	"os"
 
 	"github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/engine/binutil"
 	"github.com/xiaonanln/goworld/engine/config"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
 )
 
 var (
@@ -30,11 +30,17 @@ var (
 	strictMode    bool
 	duration      int
 	loglevel      string
+	compress      string
+	encrypt       string
+	authToken     string
+	gateKeyFile   string
+	pinnedGateKey crypto.PublicKey // pinned public key of gate for verifying key exchanges, nil if not pinned
 )
 
 func parseArgs() {
 	flag.BoolVar(&quiet, "quiet", false, "run client quietly with much less output")
 	flag.StringVar(&configFile, "configfile", "", "set config file path")
+	flag.Var(config.OverrideFlag{}, "config", "override config value in the form of section.key=value, e.g. -config game1.http_addr=:25001, can be repeated")
 	flag.IntVar(&numClients, "N", 1000, "Number of clients")
 	flag.IntVar(&startClientId, "S", 1, "Start ID of clients")
 	flag.StringVar(&serverHost, "server", "localhost", "replace server address")
@@ -44,6 +50,10 @@ func parseArgs() {
 	flag.BoolVar(&strictMode, "strict", false, "enable strict mode")
 	flag.IntVar(&duration, "duration", 0, "run for a specified duration (seconds)")
 	flag.StringVar(&loglevel, "log", "info", "set log level (info by default)")
+	flag.StringVar(&compress, "compress", "", "negotiate packet compression with gate using compress formats (e.x. zstd,snappy)")
+	flag.StringVar(&encrypt, "encrypt", "", "exchange keys with gate and encrypt packets using cipher formats (e.x. chacha20-poly1305,aes-gcm)")
+	flag.StringVar(&authToken, "token", "", "authenticate with gate using the token")
+	flag.StringVar(&gateKeyFile, "gatekey", "", "verify key exchanges signed by gate using the pinned gate certificate (e.x. rsa.crt)")
 	flag.Parse()
 }
 
@@ -54,12 +64,23 @@ func main() {
 		config.SetConfigFile(configFile)
 	}
 
-	binutil.SetupGWLog("test_client", loglevel, "test_client.log", true)
+	binutil.SetupGWLog("test_client", loglevel, "test_client.log", true, "")
 	binutil.SetupHTTPServer("localhost:18888", nil)
 	if useWebSocket && useKCP {
 		gwlog.Errorf("Can not use both websocket and KCP")
 		os.Exit(1)
 	}
+	if gateKeyFile != "" {
+		if encrypt == "" {
+			gwlog.Errorf("Gate key is pinned, but -encrypt is not set")
+			os.Exit(1)
+		}
+		var err error
+		if pinnedGateKey, err = crypt.LoadPublicKey(gateKeyFile); err != nil {
+			gwlog.Errorf("Load gate key failed: %v", err)
+			os.Exit(1)
+		}
+	}
 
 	if useWebSocket {
 		gwlog.Infof("Using websocket clients ...")
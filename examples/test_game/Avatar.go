@@ -12,6 +12,7 @@ import (
 	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/entity"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/ext/chat"
 	"github.com/xiaonanln/goworld/ext/pubsub"
 	"github.com/xiaonanln/typeconv"
 )
@@ -19,6 +20,7 @@ import (
 // Avatar entity which is the player itself
 type Avatar struct {
 	entity.Entity // Entity type should always inherit entity.Entity
+	chat.Chatter  // Avatar can chat in channels
 }
 
 func (a *Avatar) DescribeEntityType(desc *entity.EntityTypeDesc) {
@@ -34,6 +36,7 @@ func (a *Avatar) DescribeEntityType(desc *entity.EntityTypeDesc) {
 	desc.DefineAttr("enteringNilSpace")
 	desc.DefineAttr("testCallAllN")
 	desc.DefineAttr("complexAttr", "Client")
+	desc.AllowInspectorCall("EnterSpace")
 }
 
 func (a *Avatar) OnInit() {
@@ -42,6 +45,7 @@ func (a *Avatar) OnInit() {
 
 func (a *Avatar) OnAttrsReady() {
 	a.setDefaultAttrs()
+	a.InitChatter(&a.Entity, a.GetStr("name"))
 	gwlog.Debugf("Avatar %s is ready: client=%s, mails=%d", a, a.GetClient(), a.Attrs.GetMapAttr("mails").Size())
 	//a.Msgbox.SetMsgHandler(a.handleMsgboxMsg)
 }
@@ -53,6 +57,7 @@ func (a *Avatar) OnCreated() {
 	for _, subject := range _TEST_PUBLISH_SUBSCRIBE_SUBJECTS { // subscribe all subjects
 		goworld.CallService(pubsub.ServiceName, "Subscribe", a.ID, subject)
 	}
+	a.JoinChannel(chat.WorldChannel)
 
 	//a.AddTimer(time.Second, "PerSecondTick", 1, "")
 }
@@ -177,6 +182,7 @@ func (a *Avatar) OnDestroy() {
 	goworld.CallService("OnlineService", "CheckOut", a.ID)
 	// unsubscribe all subjects
 	goworld.CallService(pubsub.ServiceName, "UnsubscribeAll", a.ID)
+	a.LeaveAllChannels()
 }
 
 // SendMail_Client is a client RPC to send mail to others
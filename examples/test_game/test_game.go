@@ -6,6 +6,7 @@ import (
 	"github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld"
 	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/ext/chat"
 	"github.com/xiaonanln/goworld/ext/pubsub"
 )
 
@@ -15,6 +16,7 @@ var (
 		"SpaceService",
 		"MailService",
 		pubsub.ServiceName,
+		chat.ServiceName,
 	}
 )
 
@@ -33,6 +35,7 @@ func main() {
 	goworld.RegisterService("MailService", &MailService{})
 
 	pubsub.RegisterService()
+	chat.RegisterService()
 
 	// Register Monster type and define attributes
 	goworld.RegisterEntity("Monster", &Monster{})
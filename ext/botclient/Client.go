@@ -0,0 +1,744 @@
+// Package botclient is the Go client of GoWorld and the runner of scripted bots for load testing
+package botclient
+
+import (
+	"crypto"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwioutil"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/netutil/crypt"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"golang.org/x/net/websocket"
+)
+
+const (
+	spaceEntityType = "__space__"
+	dialTimeout     = time.Second * 10
+)
+
+var (
+	// ErrClosed is returned when waiting on closed clients
+	ErrClosed = errors.New("client closed")
+	// ErrTimeout is returned when waiting for calls or players times out
+	ErrTimeout = errors.New("timeout")
+
+	logger = gwlog.Module("botclient")
+)
+
+// Options configures how clients connect to gates
+type Options struct {
+	WebSocket       bool     // connect to the HTTP address of gate by WebSocket instead of TCP
+	TLS             bool     // connect by TLS, should be set if encrypt_connection is enabled at gate
+	CompressFormats []string // negotiate packet compression with gate using the compress formats, e.g. zstd,snappy
+	CipherFormats   []string // exchange keys with gate and encrypt packets using the cipher formats, e.g. aes-gcm
+	AuthToken       string   // authenticate with gate using the token, if authentication is enabled at gate
+	DeviceID        string   // device ID sent to gate for checking banned devices
+	World           string   // world to login, if worlds are configured in the deployment
+
+	// GatePublicKey is the pinned public key of gate certificate, e.g. loaded by crypt.LoadPublicKey("rsa.crt"), to verify
+	// key exchanges signed by gate if sign_key_exchange is enabled. Key exchanges not signed or not negotiating a cipher
+	// format are rejected if it is set.
+	GatePublicKey crypto.PublicKey
+}
+
+// Call is the entity method called on the client by the server
+type Call struct {
+	EntityID common.EntityID
+	Method   string
+	Args     [][]byte
+	Time     time.Time // time when the call is received
+}
+
+// Arg unpacks the argument of the index to v
+func (call *Call) Arg(index int, v interface{}) error {
+	if index >= len(call.Args) {
+		return errors.Errorf("%s: argument %d is missing", call.Method, index)
+	}
+	return netutil.MSG_PACKER.UnpackMsg(call.Args[index], v)
+}
+
+// CallHandler handles calls from the server, it is called in the receiving goroutine of the client
+type CallHandler func(call *Call)
+
+type callWaiter struct {
+	method string
+	c      chan *Call
+}
+
+// Client is the Go client of GoWorld, which connects to the gate, syncs entities from the server and calls entity
+// methods on the server
+//
+// Entities are updated in the receiving goroutine of the client, so fields of entities should be accessed in Do.
+type Client struct {
+	conn          *proto.GoWorldConnection
+	opts          Options
+	keyPair       *crypt.KeyPair
+	gatePublicKey crypto.PublicKey // pinned public key of gate, nil if key exchanges are not verified
+	stats         *Stats
+
+	lock         sync.Mutex
+	entities     map[common.EntityID]*Entity
+	created      map[string][]common.EntityID // IDs of entities created on the client by types, in the order of creation
+	player       *Entity
+	space        *Entity
+	sessionToken string
+	ownerID      common.EntityID // owner entity of the session, which is resumed after reconnected
+	redirectAddr string          // address of the gate to reconnect to, set if the gate is draining or redirects the client
+	handlers     map[string]CallHandler
+	waiters      []*callWaiter
+	changed      chan struct{} // closed and renewed when entities are created or destroyed
+	closed       chan struct{}
+	closeOnce    sync.Once
+	err          error
+}
+
+// Dial connects to the gate, addr is the listen address of gate, or the HTTP address if WebSocket is used
+func Dial(addr string, opts Options) (*Client, error) {
+	return dial(addr, opts, NewStats())
+}
+
+func dial(addr string, opts Options, stats *Stats) (*Client, error) {
+	if opts.GatePublicKey != nil && len(opts.CipherFormats) == 0 {
+		return nil, errors.Errorf("gate public key is pinned, but cipher formats are not set")
+	}
+
+	var netconn net.Conn
+	var err error
+	if opts.WebSocket {
+		netconn, err = dialWebSocket(addr, opts.TLS)
+	} else {
+		netconn, err = net.DialTimeout("tcp", addr, dialTimeout)
+		if err == nil && opts.TLS {
+			netconn = tls.Client(netconn, &tls.Config{InsecureSkipVerify: true})
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Client{
+		conn:          proto.NewGoWorldConnection(netutil.NewBufferedConnection(netutil.NetConnection{Conn: netconn}), false, ""),
+		opts:          opts,
+		gatePublicKey: opts.GatePublicKey,
+		stats:         stats,
+		entities:      map[common.EntityID]*Entity{},
+		created:       map[string][]common.EntityID{},
+		handlers:      map[string]CallHandler{},
+		changed:       make(chan struct{}),
+		closed:        make(chan struct{}),
+	}
+	c.conn.SetAutoFlush(consts.CLIENT_PROXY_WRITE_FLUSH_INTERVAL)
+	c.conn.SendProtocolVersionFromClient(proto.CLIENT_PROTOCOL_VERSION)
+	if len(opts.CompressFormats) > 0 {
+		c.conn.SendNegotiateCompressionFromClient(opts.CompressFormats)
+	}
+	if len(opts.CipherFormats) > 0 {
+		if c.keyPair, err = crypt.GenerateKeyPair(); err != nil {
+			c.conn.Close()
+			return nil, err
+		}
+		c.conn.SendKeyExchangeFromClient(opts.CipherFormats, c.keyPair.PublicKey[:])
+	}
+	if opts.DeviceID != "" {
+		c.conn.SendDeviceIDFromClient(opts.DeviceID)
+	}
+	if opts.World != "" {
+		c.conn.SendWorldFromClient(opts.World)
+	}
+	if opts.AuthToken != "" {
+		c.conn.SendAuthFromClient(opts.AuthToken)
+	}
+	c.conn.RequestFlush()
+
+	go c.recvLoop()
+	return c, nil
+}
+
+func dialWebSocket(addr string, useTLS bool) (net.Conn, error) {
+	originProto, wsProto := "http", "ws"
+	if useTLS {
+		originProto, wsProto = "https", "wss"
+	}
+	cfg, err := websocket.NewConfig(fmt.Sprintf("%s://%s/ws", wsProto, addr), fmt.Sprintf("%s://%s/", originProto, addr))
+	if err != nil {
+		return nil, err
+	}
+	cfg.TlsConfig = &tls.Config{InsecureSkipVerify: true}
+	cfg.Dialer = &net.Dialer{Timeout: dialTimeout}
+	return websocket.DialConfig(cfg)
+}
+
+func (c *Client) String() string {
+	return fmt.Sprintf("Client<%s>", c.conn.RemoteAddr())
+}
+
+// Close closes the connection to the gate
+func (c *Client) Close() error {
+	c.close(ErrClosed)
+	return nil
+}
+
+func (c *Client) close(err error) {
+	c.closeOnce.Do(func() {
+		c.lock.Lock()
+		c.err = err
+		c.lock.Unlock()
+		c.conn.Close()
+		close(c.closed)
+	})
+}
+
+// Closed returns a channel which is closed when the client is closed
+func (c *Client) Closed() <-chan struct{} {
+	return c.closed
+}
+
+// Err returns the error closing the client
+func (c *Client) Err() error {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.err
+}
+
+// Stats returns stats of the client, which are shared by all bots of the runner
+func (c *Client) Stats() *Stats {
+	return c.stats
+}
+
+// Do runs the function with entities locked, so that entities are not updated by the server while f is running
+func (c *Client) Do(f func()) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	f()
+}
+
+// Player returns the player entity of the client, nil if the player is not created
+func (c *Client) Player() *Entity {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.player
+}
+
+// Space returns the space of the player, nil if the player is not in any space
+func (c *Client) Space() *Entity {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.space
+}
+
+// Entity returns the entity of the ID, nil if the entity is not created on the client
+func (c *Client) Entity(id common.EntityID) *Entity {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.entities[id]
+}
+
+// NumEntities returns the number of entities on the client
+func (c *Client) NumEntities() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return len(c.entities)
+}
+
+// SessionToken returns the session token set by gate, which is used to resume the session after reconnected
+func (c *Client) SessionToken() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.sessionToken
+}
+
+// RedirectAddr returns the address of the gate to reconnect to, which is set if the gate is draining or redirects the
+// client, empty if the client is not redirected or it should choose any other gate
+func (c *Client) RedirectAddr() string {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.redirectAddr
+}
+
+// Reconnect closes the client, connects to the gate using the same options and resumes the session of the player
+//
+// addr is the address of the gate, the redirect address is used if it is empty. Entities are created on the new client
+// after the session is resumed, handlers of calls should be set on the new client.
+func (c *Client) Reconnect(addr string) (*Client, error) {
+	c.lock.Lock()
+	if addr == "" {
+		addr = c.redirectAddr
+	}
+	ownerID, sessionToken := c.ownerID, c.sessionToken
+	c.lock.Unlock()
+	if addr == "" {
+		return nil, errors.New("gate address to reconnect is unknown")
+	}
+	if ownerID.IsNil() || sessionToken == "" {
+		return nil, errors.New("no session to resume")
+	}
+
+	c.Close()
+	nc, err := dial(addr, c.opts, c.stats)
+	if err != nil {
+		return nil, err
+	}
+	if err := nc.ResumeSession(ownerID, sessionToken); err != nil {
+		nc.Close()
+		return nil, err
+	}
+	return nc, nil
+}
+
+// Handle sets the handler of calls of the method
+func (c *Client) Handle(method string, handler CallHandler) {
+	c.lock.Lock()
+	c.handlers[method] = handler
+	c.lock.Unlock()
+}
+
+// CallServer calls the method of the entity on the server, e.g. method "Login" calls Login_Client of the entity
+func (c *Client) CallServer(id common.EntityID, method string, args ...interface{}) error {
+	err := c.conn.SendCallEntityMethodFromClient(id, method, args)
+	c.conn.RequestFlush()
+	return err
+}
+
+// Request calls the method of the entity on the server and waits for the reply method called on the client
+//
+// The latency of the request is recorded in stats of the runner by the name of the method.
+func (c *Client) Request(id common.EntityID, method string, args []interface{}, replyMethod string, timeout time.Duration) (*Call, error) {
+	w := c.addCallWaiter(replyMethod)
+	defer c.removeCallWaiter(w)
+
+	startTime := time.Now()
+	if err := c.CallServer(id, method, args...); err != nil {
+		return nil, err
+	}
+	call, err := c.waitCall(w, timeout)
+	if err != nil {
+		c.stats.recordError(method + ": " + err.Error())
+		return nil, err
+	}
+	c.stats.recordLatency(method, call.Time.Sub(startTime))
+	return call, nil
+}
+
+// WaitCall waits for the method called on the client by the server
+func (c *Client) WaitCall(method string, timeout time.Duration) (*Call, error) {
+	w := c.addCallWaiter(method)
+	defer c.removeCallWaiter(w)
+	return c.waitCall(w, timeout)
+}
+
+func (c *Client) waitCall(w *callWaiter, timeout time.Duration) (*Call, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	select {
+	case call := <-w.c:
+		return call, nil
+	case <-c.closed:
+		return nil, c.Err()
+	case <-timer.C:
+		return nil, errors.Wrapf(ErrTimeout, "wait for %s", w.method)
+	}
+}
+
+func (c *Client) addCallWaiter(method string) *callWaiter {
+	w := &callWaiter{method: method, c: make(chan *Call, 1)}
+	c.lock.Lock()
+	c.waiters = append(c.waiters, w)
+	c.lock.Unlock()
+	return w
+}
+
+func (c *Client) removeCallWaiter(w *callWaiter) {
+	c.lock.Lock()
+	for i, waiter := range c.waiters {
+		if waiter == w {
+			c.waiters = append(c.waiters[:i], c.waiters[i+1:]...)
+			break
+		}
+	}
+	c.lock.Unlock()
+}
+
+// WaitPlayer waits until the player entity of the type is created on the client, any type if typeName is empty
+func (c *Client) WaitPlayer(typeName string, timeout time.Duration) (*Entity, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		c.lock.Lock()
+		player, changed := c.player, c.changed
+		c.lock.Unlock()
+		if player != nil && (typeName == "" || player.TypeName == typeName) {
+			return player, nil
+		}
+
+		select {
+		case <-changed:
+		case <-c.closed:
+			return nil, c.Err()
+		case <-timer.C:
+			return nil, errors.Wrapf(ErrTimeout, "wait for player %s", typeName)
+		}
+	}
+}
+
+// waitCreated waits until the entity of the type is created on the client for the index-th time, and returns its ID
+func (c *Client) waitCreated(typeName string, index int, timeout time.Duration) (common.EntityID, error) {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+	for {
+		c.lock.Lock()
+		created, changed := c.created[typeName], c.changed
+		c.lock.Unlock()
+		if index < len(created) {
+			return created[index], nil
+		}
+
+		select {
+		case <-changed:
+		case <-c.closed:
+			return "", c.Err()
+		case <-timer.C:
+			return "", errors.Wrapf(ErrTimeout, "wait for %s #%d", typeName, index+1)
+		}
+	}
+}
+
+// SyncPositionYaw syncs the position and yaw of the player to the server
+func (c *Client) SyncPositionYaw(pos entity.Vector3, yaw entity.Yaw) error {
+	c.lock.Lock()
+	player := c.player
+	if player != nil {
+		player.Position, player.Yaw = pos, yaw
+	}
+	c.lock.Unlock()
+	if player == nil {
+		return errors.New("player not created")
+	}
+
+	atomic.AddUint64(&c.stats.syncsSent, 1)
+	return c.conn.SendSyncPositionYawFromClient(player.ID, float32(pos.X), float32(pos.Y), float32(pos.Z), float32(yaw))
+}
+
+// ResumeSession resumes the session of the owner entity after reconnected to gates
+func (c *Client) ResumeSession(ownerID common.EntityID, sessionToken string) error {
+	err := c.conn.SendResumeSessionFromClient(ownerID, sessionToken)
+	c.conn.RequestFlush()
+	return err
+}
+
+func (c *Client) recvLoop() {
+	for {
+		var msgtype proto.MsgType
+		pkt, err := c.conn.Recv(&msgtype)
+		if err != nil {
+			if gwioutil.IsTimeoutError(err) {
+				continue
+			}
+			c.close(err)
+			return
+		}
+
+		switch msgtype {
+		case proto.MT_SET_CLIENT_COMPRESSION:
+			// switch compression in the receiving goroutine, because following packets are compressed in the new format
+			compressFormat := pkt.ReadVarStr()
+			threshold := pkt.ReadUint32()
+			c.conn.SetDecompression(compressFormat)
+			c.conn.SetCompression(compressFormat, threshold)
+		case proto.MT_SET_CLIENT_CIPHER:
+			// switch encryption in the receiving goroutine, because following packets are encrypted
+			cipherFormat := pkt.ReadVarStr()
+			gatePublicKey := pkt.ReadVarBytes()
+			var signature []byte
+			if pkt.HasUnreadPayload() {
+				signature = pkt.ReadVarBytes()
+			}
+			if cipherFormat != "" || c.gatePublicKey != nil {
+				if err := c.setupCipher(cipherFormat, gatePublicKey, signature); err != nil {
+					pkt.Release()
+					c.close(err)
+					return
+				}
+			}
+		case proto.MT_PING_TO_CLIENT:
+			// reply ping in the receiving goroutine, so that the latency is not affected by handling packets
+			seq := pkt.ReadUint64()
+			c.conn.SendPongFromClient(seq)
+			c.conn.RequestFlush()
+		default:
+			atomic.AddUint64(&c.stats.packetsRecved, 1)
+			c.handlePacket(msgtype, pkt)
+		}
+		pkt.Release()
+	}
+}
+
+func (c *Client) setupCipher(cipherFormat string, gatePublicKey []byte, signature []byte) error {
+	if c.keyPair == nil {
+		return errors.Errorf("cipher %s is set by gate, but keys are not exchanged", cipherFormat)
+	}
+	if c.gatePublicKey != nil {
+		// packets are not protected if the gate negotiates no cipher format, which can be forged to downgrade the connection
+		if cipherFormat == "" {
+			return errors.Errorf("gate negotiated no cipher format, but gate public key is pinned")
+		}
+		if err := crypt.VerifyKeyExchange(c.gatePublicKey, cipherFormat, c.keyPair.PublicKey[:], gatePublicKey, signature); err != nil {
+			return err
+		}
+	}
+	sendKey, recvKey, err := c.keyPair.DeriveKeys(gatePublicKey, true)
+	if err != nil {
+		return err
+	}
+	if err := c.conn.SetDecryption(cipherFormat, recvKey); err != nil {
+		return err
+	}
+	return c.conn.SetEncryption(cipherFormat, sendKey)
+}
+
+func (c *Client) handlePacket(msgtype proto.MsgType, pkt *netutil.Packet) {
+	if msgtype >= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_START && msgtype <= proto.MT_REDIRECT_TO_GATEPROXY_MSG_TYPE_STOP {
+		_ = pkt.ReadUint16()   // gateid
+		_ = pkt.ReadClientID() // clientid
+	}
+
+	switch msgtype {
+	case proto.MT_CREATE_ENTITY_ON_CLIENT:
+		isPlayer := pkt.ReadBool()
+		eid := pkt.ReadEntityID()
+		typeName := pkt.ReadVarStr()
+		x := entity.Coord(pkt.ReadFloat32())
+		y := entity.Coord(pkt.ReadFloat32())
+		z := entity.Coord(pkt.ReadFloat32())
+		yaw := entity.Yaw(pkt.ReadFloat32())
+		var clientData map[string]interface{}
+		pkt.ReadData(&clientData)
+		if clientData == nil {
+			clientData = map[string]interface{}{}
+		}
+		c.createEntity(&Entity{
+			ID:       eid,
+			TypeName: typeName,
+			IsPlayer: isPlayer,
+			Attrs:    clientData,
+			Position: entity.Vector3{X: x, Y: y, Z: z},
+			Yaw:      yaw,
+		})
+	case proto.MT_DESTROY_ENTITY_ON_CLIENT:
+		_ = pkt.ReadVarStr() // typeName
+		eid := pkt.ReadEntityID()
+		c.destroyEntity(eid)
+	case proto.MT_NOTIFY_MAP_ATTR_CHANGE_ON_CLIENT:
+		eid := pkt.ReadEntityID()
+		var path []interface{}
+		pkt.ReadData(&path)
+		key := pkt.ReadVarStr()
+		var val interface{}
+		pkt.ReadData(&val)
+		c.updateAttrs(eid, func(e *Entity) error {
+			return e.applyMapAttrChange(path, key, val)
+		})
+	case proto.MT_NOTIFY_MAP_ATTR_DEL_ON_CLIENT:
+		eid := pkt.ReadEntityID()
+		var path []interface{}
+		pkt.ReadData(&path)
+		key := pkt.ReadVarStr()
+		c.updateAttrs(eid, func(e *Entity) error {
+			return e.applyMapAttrDel(path, key)
+		})
+	case proto.MT_NOTIFY_MAP_ATTR_CLEAR_ON_CLIENT:
+		eid := pkt.ReadEntityID()
+		var path []interface{}
+		pkt.ReadData(&path)
+		c.updateAttrs(eid, func(e *Entity) error {
+			return e.applyMapAttrClear(path)
+		})
+	case proto.MT_NOTIFY_LIST_ATTR_CHANGE_ON_CLIENT:
+		eid := pkt.ReadEntityID()
+		var path []interface{}
+		pkt.ReadData(&path)
+		index := pkt.ReadUint32()
+		var val interface{}
+		pkt.ReadData(&val)
+		c.updateAttrs(eid, func(e *Entity) error {
+			return e.applyListAttrChange(path, int(index), val)
+		})
+	case proto.MT_NOTIFY_LIST_ATTR_APPEND_ON_CLIENT:
+		eid := pkt.ReadEntityID()
+		var path []interface{}
+		pkt.ReadData(&path)
+		var val interface{}
+		pkt.ReadData(&val)
+		c.updateAttrs(eid, func(e *Entity) error {
+			return e.applyListAttrAppend(path, val)
+		})
+	case proto.MT_NOTIFY_LIST_ATTR_POP_ON_CLIENT:
+		eid := pkt.ReadEntityID()
+		var path []interface{}
+		pkt.ReadData(&path)
+		c.updateAttrs(eid, func(e *Entity) error {
+			return e.applyListAttrPop(path)
+		})
+	case proto.MT_CALL_ENTITY_METHOD_ON_CLIENT:
+		eid := pkt.ReadEntityID()
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		c.onCall(&Call{EntityID: eid, Method: method, Args: args, Time: time.Now()})
+	case proto.MT_CALL_FILTERED_CLIENTS:
+		_ = pkt.ReadOneByte() // op
+		_ = pkt.ReadVarStr()  // key
+		_ = pkt.ReadVarStr()  // val
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		c.lock.Lock()
+		player := c.player
+		c.lock.Unlock()
+		if player != nil {
+			c.onCall(&Call{EntityID: player.ID, Method: method, Args: args, Time: time.Now()})
+		}
+	case proto.MT_SYNC_POSITION_YAW_ON_CLIENTS:
+		c.lock.Lock()
+		for pkt.HasUnreadPayload() {
+			eid := pkt.ReadEntityID()
+			x := entity.Coord(pkt.ReadFloat32())
+			y := entity.Coord(pkt.ReadFloat32())
+			z := entity.Coord(pkt.ReadFloat32())
+			yaw := entity.Yaw(pkt.ReadFloat32())
+			if e := c.entities[eid]; e != nil {
+				e.Position, e.Yaw = entity.Vector3{X: x, Y: y, Z: z}, yaw
+			}
+			atomic.AddUint64(&c.stats.syncsRecved, 1)
+		}
+		c.lock.Unlock()
+	case proto.MT_SET_CLIENT_SESSION_TOKEN:
+		sessionToken := pkt.ReadVarStr()
+		c.lock.Lock()
+		c.sessionToken = sessionToken
+		c.lock.Unlock()
+	case proto.MT_AUTH_RESULT_ON_CLIENT:
+		if ok, reason := pkt.ReadBool(), pkt.ReadVarStr(); !ok {
+			c.close(errors.Errorf("authentication failed: %s", reason))
+		}
+	case proto.MT_SET_CLIENT_PROTOCOL_VERSION:
+		if accepted, version := pkt.ReadBool(), pkt.ReadUint16(); !accepted {
+			c.close(errors.Errorf("protocol version %d rejected, min protocol version is %d", proto.CLIENT_PROTOCOL_VERSION, version))
+		}
+	case proto.MT_NOTIFY_GATE_DRAINING:
+		gateAddr, ownerID, sessionToken := pkt.ReadVarStr(), pkt.ReadEntityID(), pkt.ReadVarStr()
+		c.setRedirect(gateAddr, ownerID, sessionToken)
+		logger.Warnf("%s: gate is draining, should reconnect to gate %s", c, gateAddr)
+	case proto.MT_REDIRECT_TO_GATE_ON_CLIENT:
+		gateAddr, ownerID, sessionToken := pkt.ReadVarStr(), pkt.ReadEntityID(), pkt.ReadVarStr()
+		c.setRedirect(gateAddr, ownerID, sessionToken)
+		logger.Warnf("%s: redirected to gate %s", c, gateAddr)
+	case proto.MT_NOTIFY_SHUTDOWN_ON_CLIENT:
+		reason := pkt.ReadVarStr()
+		countdown := pkt.ReadUint32()
+		logger.Warnf("%s: game is shutting down in %d seconds: %s", c, countdown, reason)
+	case proto.MT_KICKED_ON_CLIENT:
+		c.close(errors.Errorf("kicked: %s", pkt.ReadVarStr()))
+	case proto.MT_NOTIFY_SESSION_RESUMED_ON_CLIENT:
+		ownerID := pkt.ReadEntityID()
+		ok := pkt.ReadBool()
+		logger.Infof("%s: resume session of %s: %v", c, ownerID, ok)
+	default:
+		logger.Debugf("%s: ignore msgtype %v", c, msgtype)
+	}
+}
+
+// setRedirect records the gate to reconnect to and the session to resume
+func (c *Client) setRedirect(gateAddr string, ownerID common.EntityID, sessionToken string) {
+	c.lock.Lock()
+	c.redirectAddr = gateAddr
+	if !ownerID.IsNil() {
+		c.ownerID = ownerID
+	}
+	if sessionToken != "" {
+		c.sessionToken = sessionToken
+	}
+	c.lock.Unlock()
+}
+
+func (c *Client) createEntity(e *Entity) {
+	atomic.AddUint64(&c.stats.entitiesCreated, 1)
+	c.lock.Lock()
+	c.created[e.TypeName] = append(c.created[e.TypeName], e.ID)
+	if e.TypeName == spaceEntityType {
+		c.space = e
+	} else {
+		c.entities[e.ID] = e
+		if e.IsPlayer {
+			c.player = e
+			c.ownerID = e.ID
+		}
+	}
+	c.notifyChanged()
+	c.lock.Unlock()
+}
+
+func (c *Client) destroyEntity(eid common.EntityID) {
+	atomic.AddUint64(&c.stats.entitiesDestroyed, 1)
+	c.lock.Lock()
+	if c.space != nil && c.space.ID == eid {
+		c.space = nil
+	} else {
+		if c.player != nil && c.player.ID == eid {
+			c.player = nil
+		}
+		delete(c.entities, eid)
+	}
+	c.notifyChanged()
+	c.lock.Unlock()
+}
+
+// notifyChanged wakes up goroutines waiting for changes of entities, must be called with the lock
+func (c *Client) notifyChanged() {
+	close(c.changed)
+	c.changed = make(chan struct{})
+}
+
+func (c *Client) updateAttrs(eid common.EntityID, update func(e *Entity) error) {
+	atomic.AddUint64(&c.stats.attrChanges, 1)
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	e := c.entities[eid]
+	if e == nil && c.space != nil && c.space.ID == eid {
+		e = c.space
+	}
+	if e == nil {
+		logger.Warnf("%s: entity %s not found while updating attrs", c, eid)
+		return
+	}
+	if err := update(e); err != nil {
+		logger.Errorf("%s: update attrs of %s failed: %s", c, e, err)
+	}
+}
+
+func (c *Client) onCall(call *Call) {
+	atomic.AddUint64(&c.stats.callsRecved, 1)
+	c.lock.Lock()
+	handler := c.handlers[call.Method]
+	for _, w := range c.waiters {
+		if w.method == call.Method {
+			select {
+			case w.c <- call:
+			default:
+			}
+		}
+	}
+	c.lock.Unlock()
+
+	if handler != nil {
+		handler(call)
+	}
+}
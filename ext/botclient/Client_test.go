@@ -0,0 +1,295 @@
+package botclient
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/gwioutil"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+const testTimeout = time.Second * 5
+
+// fakeGate accepts clients and lets tests play the gate
+type fakeGate struct {
+	ln net.Listener
+}
+
+func newFakeGate(t *testing.T) *fakeGate {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &fakeGate{ln: ln}
+}
+
+func (g *fakeGate) addr() string {
+	return g.ln.Addr().String()
+}
+
+// accept accepts the connection of the client
+func (g *fakeGate) accept(t *testing.T) *gateConn {
+	conn, err := g.ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetDeadline(time.Now().Add(testTimeout))
+	return &gateConn{proto.NewGoWorldConnection(netutil.NewBufferedConnection(netutil.NetConnection{Conn: conn}), false, "")}
+}
+
+func (g *fakeGate) close() {
+	g.ln.Close()
+}
+
+type gateConn struct {
+	*proto.GoWorldConnection
+}
+
+// recv receives the packet of the message type from the client, other packets are skipped
+func (gc *gateConn) recv(t *testing.T, msgtype proto.MsgType) *netutil.Packet {
+	for {
+		var mt proto.MsgType
+		pkt, err := gc.Recv(&mt)
+		if err != nil {
+			if gwioutil.IsTimeoutError(err) {
+				t.Fatalf("wait for msgtype %v timeout", msgtype)
+			}
+			t.Fatalf("recv failed: %s", err)
+		}
+		if mt == msgtype {
+			return pkt
+		}
+		pkt.Release()
+	}
+}
+
+func (gc *gateConn) flush() {
+	gc.Flush("test")
+}
+
+// login finishes the handshake of the client, and creates the player entity on the client
+func (gc *gateConn) login(t *testing.T, clientid common.ClientID, playerID common.EntityID, sessionToken string) {
+	pkt := gc.recv(t, proto.MT_PROTOCOL_VERSION_FROM_CLIENT)
+	if version := pkt.ReadUint16(); version != proto.CLIENT_PROTOCOL_VERSION {
+		t.Errorf("client should send protocol version %d, but sent %d", proto.CLIENT_PROTOCOL_VERSION, version)
+	}
+	pkt.Release()
+
+	gc.SendSetClientProtocolVersion(true, proto.CLIENT_PROTOCOL_VERSION)
+	gc.SendSetClientSessionToken(sessionToken)
+	gc.SendCreateEntityOnClient(1, clientid, "Avatar", playerID, true, map[string]interface{}{"name": "bot"}, 1, 2, 3, 0)
+	gc.flush()
+}
+
+func TestHandshake(t *testing.T) {
+	gate := newFakeGate(t)
+	defer gate.close()
+
+	c, err := Dial(gate.addr(), Options{DeviceID: "device1", AuthToken: "token1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+
+	gc := gate.accept(t)
+	defer gc.Close()
+	if pkt := gc.recv(t, proto.MT_DEVICE_ID_FROM_CLIENT); pkt.ReadVarStr() != "device1" {
+		t.Errorf("client should send the device ID")
+	}
+	if pkt := gc.recv(t, proto.MT_AUTH_FROM_CLIENT); pkt.ReadVarStr() != "token1" {
+		t.Errorf("client should send the auth token")
+	}
+	gc.SendAuthResultOnClient(true, "")
+
+	playerID := common.GenEntityID()
+	gc.SendSetClientSessionToken("session1")
+	gc.SendCreateEntityOnClient(1, common.GenClientID(), "Avatar", playerID, true, map[string]interface{}{"name": "bot"}, 1, 2, 3, 0)
+	gc.flush()
+
+	player, err := c.WaitPlayer("Avatar", testTimeout)
+	if err != nil {
+		t.Fatalf("wait player failed: %s", err)
+	}
+	if player.ID != playerID || player.Attrs["name"] != "bot" || player.Position.Y != 2 {
+		t.Errorf("wrong player: %s %v %v", player, player.Attrs, player.Position)
+	}
+	if c.SessionToken() != "session1" {
+		t.Errorf("session token should be set by gate, but is %#v", c.SessionToken())
+	}
+
+	// ping is replied in the receiving goroutine
+	gc.SendPingToClient(7)
+	gc.flush()
+	if seq := gc.recv(t, proto.MT_PONG_FROM_CLIENT).ReadUint64(); seq != 7 {
+		t.Errorf("pong should reply seq 7, but got %d", seq)
+	}
+}
+
+func TestHandshakeRejected(t *testing.T) {
+	gate := newFakeGate(t)
+	defer gate.close()
+
+	for _, reject := range []func(gc *gateConn){
+		func(gc *gateConn) { gc.SendSetClientProtocolVersion(false, proto.CLIENT_PROTOCOL_VERSION+1) },
+		func(gc *gateConn) { gc.SendAuthResultOnClient(false, "invalid token") },
+		func(gc *gateConn) { gc.SendKickedOnClient("banned") },
+	} {
+		c, err := Dial(gate.addr(), Options{AuthToken: "token1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		gc := gate.accept(t)
+		reject(gc)
+		gc.flush()
+
+		select {
+		case <-c.Closed():
+			if c.Err() == nil || c.Err() == ErrClosed {
+				t.Errorf("client should be closed by the rejection, but got %v", c.Err())
+			}
+		case <-time.After(testTimeout):
+			t.Fatalf("client should be closed after rejected")
+		}
+		gc.Close()
+	}
+}
+
+func TestRPC(t *testing.T) {
+	gate := newFakeGate(t)
+	defer gate.close()
+
+	c, err := Dial(gate.addr(), Options{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	gc := gate.accept(t)
+	defer gc.Close()
+
+	clientid, playerID := common.GenClientID(), common.GenEntityID()
+	gc.login(t, clientid, playerID, "session1")
+	if _, err := c.WaitPlayer("", testTimeout); err != nil {
+		t.Fatalf("wait player failed: %s", err)
+	}
+
+	// calls from the server are dispatched to handlers by methods
+	handled := make(chan *Call, 1)
+	c.Handle("OnHello", func(call *Call) {
+		handled <- call
+	})
+	gc.SendCallEntityMethodOnClient(1, clientid, playerID, "OnUnknown", nil)
+	gc.SendCallEntityMethodOnClient(1, clientid, playerID, "OnHello", []interface{}{"world", 3})
+	gc.flush()
+	select {
+	case call := <-handled:
+		var name string
+		var count int
+		if call.EntityID != playerID || call.Arg(0, &name) != nil || call.Arg(1, &count) != nil || name != "world" || count != 3 {
+			t.Errorf("wrong call: %+v", call)
+		}
+		if err := call.Arg(2, &name); err == nil {
+			t.Errorf("missing argument should fail")
+		}
+	case <-time.After(testTimeout):
+		t.Fatalf("handler of OnHello should be called")
+	}
+
+	// requests wait for the reply methods
+	type result struct {
+		call *Call
+		err  error
+	}
+	results := make(chan result, 1)
+	go func() {
+		call, err := c.Request(playerID, "LevelUp", []interface{}{9}, "LevelUpReply", testTimeout)
+		results <- result{call, err}
+	}()
+	pkt := gc.recv(t, proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT)
+	eid, method, args := pkt.ReadEntityID(), pkt.ReadVarStr(), pkt.ReadArgs()
+	pkt.Release()
+	var level int
+	if eid != playerID || method != "LevelUp" || len(args) != 1 || netutil.MSG_PACKER.UnpackMsg(args[0], &level) != nil {
+		t.Fatalf("wrong call from client: %s.%s(%d args)", eid, method, len(args))
+	}
+	gc.SendCallEntityMethodOnClient(1, clientid, eid, method+"Reply", []interface{}{level + 1})
+	gc.flush()
+
+	res := <-results
+	if res.err != nil {
+		t.Fatalf("request failed: %s", res.err)
+	}
+	if err := res.call.Arg(0, &level); err != nil || level != 10 {
+		t.Errorf("reply should be level 10, but got %d, %v", level, err)
+	}
+	if report := c.Stats().Report(); len(report.Latencies) != 1 || report.Latencies[0].Name != "LevelUp" || report.Latencies[0].Count != 1 {
+		t.Errorf("latency of the request should be recorded: %+v", report.Latencies)
+	}
+
+	if _, err := c.WaitCall("Never", time.Millisecond*10); err == nil {
+		t.Errorf("waiting for calls not received should time out")
+	}
+}
+
+func TestReconnect(t *testing.T) {
+	gate1, gate2 := newFakeGate(t), newFakeGate(t)
+	defer gate1.close()
+	defer gate2.close()
+
+	c, err := Dial(gate1.addr(), Options{DeviceID: "device1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	gc1 := gate1.accept(t)
+	defer gc1.Close()
+
+	clientid, playerID := common.GenClientID(), common.GenEntityID()
+	gc1.login(t, clientid, playerID, "session1")
+	if _, err := c.WaitPlayer("", testTimeout); err != nil {
+		t.Fatalf("wait player failed: %s", err)
+	}
+	if _, err := c.Reconnect(""); err == nil {
+		t.Fatalf("reconnect should fail if the gate address is unknown")
+	}
+
+	gc1.SendNotifyGateDraining(gate2.addr(), playerID, "session2")
+	gc1.flush()
+	deadline := time.Now().Add(testTimeout)
+	for c.RedirectAddr() == "" && time.Now().Before(deadline) {
+		time.Sleep(time.Millisecond)
+	}
+	if c.RedirectAddr() != gate2.addr() || c.SessionToken() != "session2" {
+		t.Fatalf("client should be redirected to %s with the new session token, but got %s, %s", gate2.addr(), c.RedirectAddr(), c.SessionToken())
+	}
+
+	nc, err := c.Reconnect("")
+	if err != nil {
+		t.Fatalf("reconnect failed: %s", err)
+	}
+	defer nc.Close()
+	select {
+	case <-c.Closed():
+	case <-time.After(testTimeout):
+		t.Errorf("old client should be closed after reconnected")
+	}
+
+	gc2 := gate2.accept(t)
+	defer gc2.Close()
+	if pkt := gc2.recv(t, proto.MT_DEVICE_ID_FROM_CLIENT); pkt.ReadVarStr() != "device1" {
+		t.Errorf("new client should use the options of the old client")
+	}
+	pkt := gc2.recv(t, proto.MT_RESUME_SESSION_FROM_CLIENT)
+	if ownerID, sessionToken := pkt.ReadEntityID(), pkt.ReadVarStr(); ownerID != playerID || sessionToken != "session2" {
+		t.Errorf("new client should resume session of %s by session2, but got %s, %s", playerID, ownerID, sessionToken)
+	}
+
+	gc2.SendNotifySessionResumedOnClient(2, clientid, playerID, true)
+	gc2.SendCreateEntityOnClient(1, clientid, "Avatar", playerID, true, nil, 0, 0, 0, 0)
+	gc2.flush()
+	if player, err := nc.WaitPlayer("Avatar", testTimeout); err != nil || player.ID != playerID {
+		t.Errorf("player should be created on the new client after resumed: %v", err)
+	}
+}
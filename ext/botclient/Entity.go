@@ -0,0 +1,141 @@
+package botclient
+
+import (
+	"fmt"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+// Entity is the entity synced to the client
+type Entity struct {
+	ID       common.EntityID
+	TypeName string
+	IsPlayer bool
+	Attrs    map[string]interface{} // client attrs of the entity
+	Position entity.Vector3
+	Yaw      entity.Yaw
+}
+
+func (e *Entity) String() string {
+	return fmt.Sprintf("%s<%s>", e.TypeName, e.ID)
+}
+
+func (e *Entity) applyMapAttrChange(path []interface{}, key string, val interface{}) error {
+	attr, _, _, err := e.findAttrByPath(path)
+	if err != nil {
+		return err
+	}
+	mapattr, ok := attr.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("attr %v is not a map: %T", path, attr)
+	}
+	mapattr[key] = val
+	return nil
+}
+
+func (e *Entity) applyMapAttrDel(path []interface{}, key string) error {
+	attr, _, _, err := e.findAttrByPath(path)
+	if err != nil {
+		return err
+	}
+	mapattr, ok := attr.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("attr %v is not a map: %T", path, attr)
+	}
+	delete(mapattr, key)
+	return nil
+}
+
+func (e *Entity) applyMapAttrClear(path []interface{}) error {
+	attr, _, _, err := e.findAttrByPath(path)
+	if err != nil {
+		return err
+	}
+	mapattr, ok := attr.(map[string]interface{})
+	if !ok {
+		return errors.Errorf("attr %v is not a map: %T", path, attr)
+	}
+	for k := range mapattr {
+		delete(mapattr, k)
+	}
+	return nil
+}
+
+func (e *Entity) applyListAttrChange(path []interface{}, index int, val interface{}) error {
+	attr, _, _, err := e.findAttrByPath(path)
+	if err != nil {
+		return err
+	}
+	listattr, ok := attr.([]interface{})
+	if !ok {
+		return errors.Errorf("attr %v is not a list: %T", path, attr)
+	}
+	if index < 0 || index >= len(listattr) {
+		return errors.Errorf("index %d of attr %v out of range %d", index, path, len(listattr))
+	}
+	listattr[index] = val
+	return nil
+}
+
+func (e *Entity) applyListAttrAppend(path []interface{}, val interface{}) error {
+	attr, parent, pkey, err := e.findAttrByPath(path)
+	if err != nil {
+		return err
+	}
+	listattr, ok := attr.([]interface{})
+	if !ok {
+		return errors.Errorf("attr %v is not a list: %T", path, attr)
+	}
+	return setAttr(parent, pkey, append(listattr, val))
+}
+
+func (e *Entity) applyListAttrPop(path []interface{}) error {
+	attr, parent, pkey, err := e.findAttrByPath(path)
+	if err != nil {
+		return err
+	}
+	listattr, ok := attr.([]interface{})
+	if !ok || len(listattr) == 0 {
+		return errors.Errorf("attr %v is not a non-empty list: %v", path, attr)
+	}
+	return setAttr(parent, pkey, listattr[:len(listattr)-1])
+}
+
+// findAttrByPath returns the attr of the path, and its parent and key in the parent. Note that the path is reversed.
+func (e *Entity) findAttrByPath(path []interface{}) (attr interface{}, parent interface{}, pkey interface{}, err error) {
+	attr = e.Attrs
+	for i := len(path) - 1; i >= 0; i-- {
+		parent, pkey = attr, path[i]
+		switch a := attr.(type) {
+		case map[string]interface{}:
+			key, ok := pkey.(string)
+			if !ok {
+				return nil, nil, nil, errors.Errorf("invalid key of map attr: %v", pkey)
+			}
+			attr = a[key]
+		case []interface{}:
+			index, ok := pkey.(int64)
+			if !ok || index < 0 || int(index) >= len(a) {
+				return nil, nil, nil, errors.Errorf("invalid index of list attr: %v", pkey)
+			}
+			attr = a[index]
+		default:
+			return nil, nil, nil, errors.Errorf("attr is neither map nor list: %T", attr)
+		}
+	}
+	return
+}
+
+func setAttr(parent interface{}, key interface{}, val interface{}) error {
+	switch p := parent.(type) {
+	case map[string]interface{}:
+		p[key.(string)] = val
+	case []interface{}:
+		p[key.(int64)] = val
+	default:
+		return errors.Errorf("root attr can not be replaced")
+	}
+	return nil
+}
@@ -0,0 +1,99 @@
+package botclient
+
+import (
+	"io"
+	"sync/atomic"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/clientrecord"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/proto"
+)
+
+// Replay sends packets recorded by the gate (see clientrecord) to the server, as the recorded client sent them
+//
+// Packets are sent at the recorded times scaled by speed, e.g. speed 2 replays twice as fast. Entity IDs in packets are
+// mapped to entities created on the client in the same order as recorded entities of the same types, and the replay
+// waits up to timeout for each recorded entity to be created before sending following packets. Handshake packets
+// (protocol version, compression, keys, authentication and session resuming) are not replayed, since the client
+// negotiates its own connection.
+func (c *Client) Replay(r *clientrecord.Reader, speed float64, timeout time.Duration) error {
+	if speed <= 0 {
+		speed = 1
+	}
+	entityIDs := map[common.EntityID]common.EntityID{} // recorded entity ID -> entity ID created on the client
+	created := map[string]int{}                         // number of recorded entities created by types
+	startTime := time.Now()
+	for {
+		rec, err := r.Read()
+		if err == io.EOF {
+			return nil
+		} else if err != nil {
+			return errors.Wrap(err, "read record")
+		}
+
+		if d := time.Until(startTime.Add(time.Duration(float64(rec.Time) / speed))); d > 0 {
+			timer := time.NewTimer(d)
+			select {
+			case <-timer.C:
+			case <-c.closed:
+				timer.Stop()
+				return c.Err()
+			}
+		}
+
+		if rec.Outbound {
+			if rec.MsgType != proto.MT_CREATE_ENTITY_ON_CLIENT {
+				continue
+			}
+			eid, typeName := readCreatedEntity(rec.Payload)
+			liveID, err := c.waitCreated(typeName, created[typeName], timeout)
+			if err != nil {
+				return err
+			}
+			created[typeName] += 1
+			entityIDs[eid] = liveID
+			continue
+		}
+
+		switch rec.MsgType {
+		case proto.MT_CALL_ENTITY_METHOD_FROM_CLIENT, proto.MT_SYNC_POSITION_YAW_FROM_CLIENT:
+			if len(rec.Payload) < common.ENTITYID_LENGTH {
+				return errors.Errorf("invalid payload of message type %d", rec.MsgType)
+			}
+			if liveID, ok := entityIDs[common.EntityID(rec.Payload[:common.ENTITYID_LENGTH])]; ok {
+				copy(rec.Payload, liveID)
+			}
+			if rec.MsgType == proto.MT_SYNC_POSITION_YAW_FROM_CLIENT {
+				atomic.AddUint64(&c.stats.syncsSent, 1)
+			}
+		case proto.MT_HEARTBEAT_FROM_CLIENT:
+		default:
+			continue // handshake packets
+		}
+
+		pkt := netutil.NewPacket()
+		pkt.AppendUint16(uint16(rec.MsgType))
+		pkt.AppendBytes(rec.Payload)
+		err = c.conn.SendPacketRelease(pkt)
+		c.conn.RequestFlush()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// readCreatedEntity reads the ID and the type name of the entity from the payload of MT_CREATE_ENTITY_ON_CLIENT
+func readCreatedEntity(payload []byte) (common.EntityID, string) {
+	pkt := netutil.NewPacket()
+	defer pkt.Release()
+	pkt.AppendBytes(payload)
+	_ = pkt.ReadUint16()   // gateid
+	_ = pkt.ReadClientID() // clientid
+	_ = pkt.ReadBool()     // isPlayer
+	eid := pkt.ReadEntityID()
+	typeName := pkt.ReadVarStr()
+	return eid, typeName
+}
@@ -0,0 +1,183 @@
+package botclient
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+// Script is the logic of bots, the bot is closed when the script returns
+type Script func(bot *Bot) error
+
+// Bot is the client run by the runner
+type Bot struct {
+	*Client
+	ID     int // ID of the bot, starting from Runner.StartID
+	runner *Runner
+}
+
+func (bot *Bot) String() string {
+	return fmt.Sprintf("Bot<%d>", bot.ID)
+}
+
+// Sleep sleeps for the duration, and returns false if the runner is stopped or the client is closed
+func (bot *Bot) Sleep(d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return true
+	case <-bot.runner.stopped:
+		return false
+	case <-bot.Closed():
+		return false
+	}
+}
+
+// Stopped returns a channel which is closed when the runner is stopped
+func (bot *Bot) Stopped() <-chan struct{} {
+	return bot.runner.stopped
+}
+
+// Measure runs the function and records its latency by the name, e.g. to measure the time to login
+func (bot *Bot) Measure(name string, f func() error) error {
+	startTime := time.Now()
+	err := f()
+	if err != nil {
+		bot.runner.stats.recordError(name + ": " + err.Error())
+	} else {
+		bot.runner.stats.recordLatency(name, time.Since(startTime))
+	}
+	return err
+}
+
+// Runner runs scripts of a number of bots connecting to gates
+type Runner struct {
+	Addrs          []string // addresses of gates, bots connect to gates in turn
+	Options        Options
+	NumBots        int
+	StartID        int           // ID of the first bot
+	RampUp         time.Duration // bots are started evenly in the duration
+	Duration       time.Duration // the runner is stopped after the duration, 0 to wait for all scripts to finish
+	ReportInterval time.Duration // interval of calling OnReport, 0 to disable
+	OnReport       func(r *Report)
+	Script         Script
+
+	stats       *Stats
+	stopped     chan struct{}
+	stopOnce    sync.Once
+	startTime   time.Time
+	botsStarted int64
+	botsOnline  int64
+	botsFailed  int64
+}
+
+// Run starts bots and waits until the runner is stopped or all scripts finish, and returns the final report
+func (r *Runner) Run() *Report {
+	if len(r.Addrs) == 0 || r.Script == nil {
+		gwlog.Panicf("botclient: runner should have gate addresses and the script")
+	}
+	r.stats = NewStats()
+	r.stopped = make(chan struct{})
+	r.startTime = time.Now()
+
+	if r.Duration > 0 {
+		stopTimer := time.AfterFunc(r.Duration, r.Stop)
+		defer stopTimer.Stop()
+	}
+	if r.ReportInterval > 0 && r.OnReport != nil {
+		go r.reportRoutine()
+	}
+
+	var wait sync.WaitGroup
+	for i := 0; i < r.NumBots && !r.isStopped(); i++ {
+		if i > 0 && r.RampUp > 0 {
+			select {
+			case <-time.After(r.RampUp / time.Duration(r.NumBots)):
+			case <-r.stopped:
+				continue
+			}
+		}
+		wait.Add(1)
+		go func(id int) {
+			defer wait.Done()
+			r.runBot(id)
+		}(r.StartID + i)
+	}
+	wait.Wait()
+	r.Stop()
+	return r.Report()
+}
+
+// Stop stops the runner, scripts should return when bots are stopped
+func (r *Runner) Stop() {
+	r.stopOnce.Do(func() {
+		close(r.stopped)
+	})
+}
+
+func (r *Runner) isStopped() bool {
+	select {
+	case <-r.stopped:
+		return true
+	default:
+		return false
+	}
+}
+
+// Report returns the current report of the runner
+func (r *Runner) Report() *Report {
+	report := r.stats.Report()
+	report.Elapsed = time.Since(r.startTime)
+	report.BotsStarted = int(atomic.LoadInt64(&r.botsStarted))
+	report.BotsOnline = int(atomic.LoadInt64(&r.botsOnline))
+	report.BotsFailed = int(atomic.LoadInt64(&r.botsFailed))
+	return report
+}
+
+func (r *Runner) reportRoutine() {
+	ticker := time.NewTicker(r.ReportInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			r.OnReport(r.Report())
+		case <-r.stopped:
+			return
+		}
+	}
+}
+
+func (r *Runner) runBot(id int) {
+	atomic.AddInt64(&r.botsStarted, 1)
+	addr := r.Addrs[id%len(r.Addrs)]
+	startTime := time.Now()
+	client, err := dial(addr, r.Options, r.stats)
+	if err != nil {
+		atomic.AddInt64(&r.botsFailed, 1)
+		r.stats.recordError("connect: " + err.Error())
+		return
+	}
+	r.stats.recordLatency("connect", time.Since(startTime))
+
+	bot := &Bot{Client: client, ID: id, runner: r}
+	atomic.AddInt64(&r.botsOnline, 1)
+	defer func() {
+		atomic.AddInt64(&r.botsOnline, -1)
+		client.Close()
+		if err := recover(); err != nil {
+			atomic.AddInt64(&r.botsFailed, 1)
+			r.stats.recordError(fmt.Sprintf("panic: %v", err))
+			gwlog.Errorf("%s panic: %v", bot, err)
+		}
+	}()
+
+	if err := r.Script(bot); err != nil {
+		atomic.AddInt64(&r.botsFailed, 1)
+		r.stats.recordError("script: " + err.Error())
+		gwlog.Debugf("%s failed: %s", bot, err)
+	}
+}
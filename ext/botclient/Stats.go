@@ -0,0 +1,142 @@
+package botclient
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const maxErrorKinds = 100
+
+// Stats collects latencies of requests and counts of synced messages of clients
+type Stats struct {
+	// counters are accessed atomically
+	packetsRecved     uint64
+	callsRecved       uint64
+	syncsRecved       uint64
+	syncsSent         uint64
+	attrChanges       uint64
+	entitiesCreated   uint64
+	entitiesDestroyed uint64
+
+	lock      sync.Mutex
+	latencies map[string][]time.Duration
+	errors    map[string]int
+}
+
+// NewStats creates an empty Stats
+func NewStats() *Stats {
+	return &Stats{
+		latencies: map[string][]time.Duration{},
+		errors:    map[string]int{},
+	}
+}
+
+func (s *Stats) recordLatency(name string, d time.Duration) {
+	s.lock.Lock()
+	s.latencies[name] = append(s.latencies[name], d)
+	s.lock.Unlock()
+}
+
+func (s *Stats) recordError(err string) {
+	s.lock.Lock()
+	if _, ok := s.errors[err]; ok || len(s.errors) < maxErrorKinds {
+		s.errors[err] += 1
+	} else {
+		s.errors["other errors"] += 1
+	}
+	s.lock.Unlock()
+}
+
+// LatencyReport is the latency distribution of requests of the same name
+type LatencyReport struct {
+	Name                    string
+	Count                   int
+	Avg, P50, P90, P99, Max time.Duration
+}
+
+// Report is the snapshot of Stats
+type Report struct {
+	Elapsed                 time.Duration
+	BotsStarted, BotsOnline int
+	BotsFailed              int // bots failed to connect or running scripts
+	Latencies               []LatencyReport
+	Errors                  map[string]int
+
+	PacketsRecved     uint64
+	CallsRecved       uint64
+	SyncsRecved       uint64 // position and yaw syncs of entities received
+	SyncsSent         uint64 // position and yaw syncs of players sent
+	AttrChanges       uint64
+	EntitiesCreated   uint64
+	EntitiesDestroyed uint64
+}
+
+// Report returns the report of stats
+func (s *Stats) Report() *Report {
+	r := &Report{
+		Errors:            map[string]int{},
+		PacketsRecved:     atomic.LoadUint64(&s.packetsRecved),
+		CallsRecved:       atomic.LoadUint64(&s.callsRecved),
+		SyncsRecved:       atomic.LoadUint64(&s.syncsRecved),
+		SyncsSent:         atomic.LoadUint64(&s.syncsSent),
+		AttrChanges:       atomic.LoadUint64(&s.attrChanges),
+		EntitiesCreated:   atomic.LoadUint64(&s.entitiesCreated),
+		EntitiesDestroyed: atomic.LoadUint64(&s.entitiesDestroyed),
+	}
+
+	s.lock.Lock()
+	for name, latencies := range s.latencies {
+		sorted := make([]time.Duration, len(latencies))
+		copy(sorted, latencies)
+		r.Latencies = append(r.Latencies, makeLatencyReport(name, sorted))
+	}
+	for err, count := range s.errors {
+		r.Errors[err] = count
+	}
+	s.lock.Unlock()
+
+	sort.Slice(r.Latencies, func(i, j int) bool {
+		return r.Latencies[i].Name < r.Latencies[j].Name
+	})
+	return r
+}
+
+func makeLatencyReport(name string, latencies []time.Duration) LatencyReport {
+	sort.Slice(latencies, func(i, j int) bool {
+		return latencies[i] < latencies[j]
+	})
+	var total time.Duration
+	for _, d := range latencies {
+		total += d
+	}
+	percentile := func(p int) time.Duration {
+		return latencies[(len(latencies)-1)*p/100]
+	}
+	return LatencyReport{
+		Name:  name,
+		Count: len(latencies),
+		Avg:   total / time.Duration(len(latencies)),
+		P50:   percentile(50),
+		P90:   percentile(90),
+		P99:   percentile(99),
+		Max:   latencies[len(latencies)-1],
+	}
+}
+
+func (r *Report) String() string {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "elapsed %s, bots: %d started, %d online, %d failed\n", r.Elapsed.Round(time.Second), r.BotsStarted, r.BotsOnline, r.BotsFailed)
+	for _, l := range r.Latencies {
+		fmt.Fprintf(&buf, "> %-32s *%d AVG %s P50 %s P90 %s P99 %s MAX %s\n", l.Name, l.Count, l.Avg, l.P50, l.P90, l.P99, l.Max)
+	}
+	fmt.Fprintf(&buf, "packets %d, calls %d, syncs recv %d send %d, attr changes %d, entities created %d destroyed %d\n",
+		r.PacketsRecved, r.CallsRecved, r.SyncsRecved, r.SyncsSent, r.AttrChanges, r.EntitiesCreated, r.EntitiesDestroyed)
+	for err, count := range r.Errors {
+		fmt.Fprintf(&buf, "! %s *%d\n", err, count)
+	}
+	return buf.String()
+}
@@ -0,0 +1,328 @@
+package chat
+
+import (
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// ServiceName is the service name of chat service
+	ServiceName = "ChatService"
+
+	// WorldChannel is the channel of all chatters in the world
+	WorldChannel = "world"
+	// ZoneChannelPrefix is the prefix of zone channels: zone.<ZoneID>
+	ZoneChannelPrefix = "zone."
+	// GuildChannelPrefix is the prefix of guild channels: guild.<GuildID>
+	GuildChannelPrefix = "guild."
+)
+
+// Client RPC methods called by chat
+const (
+	// ClientOnMessage is called on clients when a message is received: OnChatMessage(channel, senderID, senderName, text, time)
+	// channel is empty for private messages
+	ClientOnMessage = "OnChatMessage"
+	// ClientOnHistory is called on clients with the history of channel: OnChatHistory(channel, messages)
+	ClientOnHistory = "OnChatHistory"
+	// ClientOnRejected is called on clients when the message is rejected: OnChatRejected(reason)
+	ClientOnRejected = "OnChatRejected"
+)
+
+// Filter checks the message before it is sent, and returns the filtered text, or ok = false to reject the message
+type Filter func(sender common.EntityID, channel string, text string) (filtered string, ok bool)
+
+var (
+	historySize     = 50
+	maxMessageLen   = 256
+	rateLimitCount  = 5
+	rateLimitPeriod = time.Second * 10
+	filter          Filter
+)
+
+// SetHistorySize sets the number of messages kept in history for each channel, 0 to disable history
+func SetHistorySize(size int) {
+	historySize = size
+}
+
+// SetMaxMessageLen sets the max length of messages
+func SetMaxMessageLen(n int) {
+	maxMessageLen = n
+}
+
+// SetRateLimit limits each chatter to send at most count messages in period, count = 0 to disable rate limiting
+func SetRateLimit(count int, period time.Duration) {
+	rateLimitCount = count
+	rateLimitPeriod = period
+}
+
+// SetFilter sets the filter for all messages, e.g. for profanity filtering
+func SetFilter(f Filter) {
+	filter = f
+}
+
+// ZoneChannel returns the channel of zone
+func ZoneChannel(zoneID string) string {
+	return ZoneChannelPrefix + zoneID
+}
+
+// GuildChannel returns the channel of guild
+func GuildChannel(guildID string) string {
+	return GuildChannelPrefix + guildID
+}
+
+type rateLimitState struct {
+	windowStart time.Time
+	count       int
+}
+
+// ChatService is the service entity for chatting in world, zone, guild channels and private chatting
+//
+// Channel members are kept in attrs which are migrated and freezed with the service, but not persistent, since
+// chatters join channels again when they login. History of channels is persistent.
+type ChatService struct {
+	entity.Entity
+
+	channels    map[string]common.EntityIDSet // Channel -> Members
+	memberships map[common.EntityID]common.StringSet
+	chatters    common.EntityIDSet // chatters joined channels, which are kept until LeaveAll
+	rateLimits  map[common.EntityID]*rateLimitState
+}
+
+// RegisterService registeres ChatService to goworld
+func RegisterService() {
+	goworld.RegisterService(ServiceName, &ChatService{})
+}
+
+func (cs *ChatService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("history", "Persistent")
+}
+
+// OnInit initialize ChatService fields
+func (cs *ChatService) OnInit() {
+	cs.channels = map[string]common.EntityIDSet{}
+	cs.memberships = map[common.EntityID]common.StringSet{}
+	cs.chatters = common.EntityIDSet{}
+	cs.rateLimits = map[common.EntityID]*rateLimitState{}
+}
+
+// OnCreated is called when ChatService is created
+func (cs *ChatService) OnCreated() {
+	if !cs.Attrs.HasKey("history") {
+		cs.Attrs.SetMapAttr("history", goworld.MapAttr())
+	}
+	if !cs.Attrs.HasKey("members") {
+		cs.Attrs.SetMapAttr("members", goworld.MapAttr())
+	}
+}
+
+// OnRestored restores channel members from attrs
+func (cs *ChatService) OnRestored() {
+	cs.restoreMembers()
+}
+
+// OnMigrateIn restores channel members after the service is handed off to this game
+func (cs *ChatService) OnMigrateIn() {
+	cs.restoreMembers()
+}
+
+func (cs *ChatService) restoreMembers() {
+	cs.GetMapAttr("members").ForEach(func(channel string, val interface{}) {
+		val.(*entity.MapAttr).ForEachKey(func(eid string) {
+			cs.join(common.EntityID(eid), channel)
+		})
+	})
+}
+
+// Join lets the chatter join the channel
+func (cs *ChatService) Join(chatter common.EntityID, channel string) {
+	if channel == "" {
+		gwlog.Errorf("%s: %s joining empty channel", cs, chatter)
+		return
+	}
+	cs.join(chatter, channel)
+}
+
+func (cs *ChatService) join(chatter common.EntityID, channel string) {
+	cs.chatters.Add(chatter)
+
+	members := cs.channels[channel]
+	if members == nil {
+		members = common.EntityIDSet{}
+		cs.channels[channel] = members
+	}
+	members.Add(chatter)
+
+	membersAttr := cs.GetMapAttr("members")
+	if !membersAttr.HasKey(channel) {
+		membersAttr.SetMapAttr(channel, goworld.MapAttr())
+	}
+	membersAttr.GetMapAttr(channel).SetInt(string(chatter), 1)
+
+	channels := cs.memberships[chatter]
+	if channels == nil {
+		channels = common.StringSet{}
+		cs.memberships[chatter] = channels
+	}
+	channels.Add(channel)
+}
+
+// Leave lets the chatter leave the channel
+func (cs *ChatService) Leave(chatter common.EntityID, channel string) {
+	if members := cs.channels[channel]; members != nil {
+		members.Del(chatter)
+		membersAttr := cs.GetMapAttr("members")
+		if len(members) == 0 {
+			delete(cs.channels, channel)
+			membersAttr.Del(channel)
+		} else {
+			membersAttr.GetMapAttr(channel).Del(string(chatter))
+		}
+	}
+	if channels := cs.memberships[chatter]; channels != nil {
+		channels.Remove(channel)
+		if len(channels) == 0 {
+			delete(cs.memberships, chatter)
+		}
+	}
+}
+
+// LeaveAll lets the chatter leave all channels, which should be called when the chatter is offline
+func (cs *ChatService) LeaveAll(chatter common.EntityID) {
+	for channel := range cs.memberships[chatter] {
+		cs.Leave(chatter, channel)
+	}
+	delete(cs.rateLimits, chatter)
+	cs.chatters.Del(chatter)
+}
+
+// Send sends the message to all members of the channel, the sender should be a member of the channel
+func (cs *ChatService) Send(sender common.EntityID, senderName string, channel string, text string) {
+	members := cs.channels[channel]
+	if !members.Contains(sender) {
+		cs.Call(sender, "OnChatRejected", "not in channel")
+		return
+	}
+
+	text, ok := cs.checkMessage(sender, channel, text)
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	for eid := range members {
+		cs.Call(eid, "OnChatMessage", channel, sender, senderName, text, now)
+	}
+	cs.appendHistory(channel, sender, senderName, text, now)
+}
+
+// SendPrivate sends the message to the target chatter, and echos to the sender
+//
+// The target should be a chatter which joined channels, so that clients can not call arbitrary entities by the target
+func (cs *ChatService) SendPrivate(sender common.EntityID, senderName string, target common.EntityID, text string) {
+	if !cs.chatters.Contains(target) {
+		cs.Call(sender, "OnChatRejected", "target not found")
+		return
+	}
+
+	text, ok := cs.checkMessage(sender, "", text)
+	if !ok {
+		return
+	}
+
+	now := time.Now().Unix()
+	cs.Call(target, "OnChatMessage", "", sender, senderName, text, now)
+	if target != sender {
+		cs.Call(sender, "OnChatMessage", "", sender, senderName, text, now)
+	}
+}
+
+// GetHistory sends the history of the channel to the requester, which should be a member of the channel
+func (cs *ChatService) GetHistory(requester common.EntityID, channel string) {
+	if !cs.channels[channel].Contains(requester) {
+		cs.Call(requester, "OnChatRejected", "not in channel")
+		return
+	}
+
+	var messages []interface{}
+	if history := cs.GetMapAttr("history").GetListAttr(channel); history != nil {
+		messages = history.ToList()
+		if len(messages) > historySize {
+			messages = messages[len(messages)-historySize:]
+		}
+	}
+	cs.Call(requester, "OnChatHistory", channel, messages)
+}
+
+// checkMessage checks the length, rate limit and filter of the message
+func (cs *ChatService) checkMessage(sender common.EntityID, channel string, text string) (string, bool) {
+	text = strings.TrimSpace(text)
+	if text == "" || len(text) > maxMessageLen {
+		cs.Call(sender, "OnChatRejected", "invalid message")
+		return "", false
+	}
+
+	if !cs.checkRateLimit(sender) {
+		cs.Call(sender, "OnChatRejected", "too many messages")
+		return "", false
+	}
+
+	if filter != nil {
+		filtered, ok := filter(sender, channel, text)
+		if !ok {
+			cs.Call(sender, "OnChatRejected", "message filtered")
+			return "", false
+		}
+		text = filtered
+	}
+	return text, true
+}
+
+func (cs *ChatService) checkRateLimit(sender common.EntityID) bool {
+	if rateLimitCount <= 0 {
+		return true
+	}
+
+	now := time.Now()
+	state := cs.rateLimits[sender]
+	if state == nil || now.Sub(state.windowStart) >= rateLimitPeriod {
+		state = &rateLimitState{windowStart: now}
+		cs.rateLimits[sender] = state
+	}
+
+	state.count += 1
+	return state.count <= rateLimitCount
+}
+
+func (cs *ChatService) appendHistory(channel string, sender common.EntityID, senderName string, text string, now int64) {
+	if historySize <= 0 {
+		return
+	}
+
+	historyAttr := cs.GetMapAttr("history")
+	history := historyAttr.GetListAttr(channel)
+	if history == nil {
+		history = goworld.ListAttr()
+		historyAttr.SetListAttr(channel, history)
+	}
+
+	msg := goworld.MapAttr()
+	msg.SetStr("sender", string(sender))
+	msg.SetStr("name", senderName)
+	msg.SetStr("text", text)
+	msg.SetInt("time", now)
+	history.AppendMapAttr(msg)
+
+	if history.Size() >= historySize*2 {
+		// ListAttr can only pop from the tail, so trim the history by replacing it with the latest messages
+		messages := history.ToList()
+		trimmed := goworld.ListAttr()
+		trimmed.AssignList(messages[len(messages)-historySize:])
+		historyAttr.SetListAttr(channel, trimmed)
+	}
+}
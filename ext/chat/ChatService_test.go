@@ -0,0 +1,213 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/goworldtest"
+)
+
+type testSpace struct {
+	entity.Space
+}
+
+type testChatter struct {
+	entity.Entity
+	Chatter
+}
+
+func (c *testChatter) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+func (c *testChatter) OnAttrsReady() {
+	c.InitChatter(&c.Entity, "chatter")
+}
+
+var w *goworldtest.World
+
+func init() {
+	entity.RegisterSpace(&testSpace{})
+	entity.RegisterEntity("testChatter", &testChatter{}, false)
+	entity.RegisterEntity(ServiceName, &ChatService{}, false)
+	w = goworldtest.Setup()
+}
+
+func newService() *ChatService {
+	return w.CreateEntity(ServiceName).I.(*ChatService)
+}
+
+// join connects a chatter, and joins the channels like Chatter.JoinChannel does, since calls to services are not
+// delivered by goworldtest
+func join(cs *ChatService, channels ...string) *goworldtest.Client {
+	c := w.Connect("testChatter")
+	for _, channel := range channels {
+		w.Call(cs.ID, "Join", c.OwnerID, channel)
+	}
+	return c
+}
+
+func send(cs *ChatService, c *goworldtest.Client, channel string, text string) {
+	w.Call(cs.ID, "Send", c.OwnerID, "chatter", channel, text)
+}
+
+func messagesOf(c *goworldtest.Client) []string {
+	var texts []string
+	for _, call := range c.CallsOf(ClientOnMessage) {
+		texts = append(texts, call.Args[0].(string)+":"+call.Args[3].(string))
+	}
+	return texts
+}
+
+func rejectionsOf(c *goworldtest.Client) []string {
+	var reasons []string
+	for _, call := range c.CallsOf(ClientOnRejected) {
+		reasons = append(reasons, call.Args[0].(string))
+	}
+	return reasons
+}
+
+func TestChannelMessages(t *testing.T) {
+	cs := newService()
+	c1, c2 := join(cs, WorldChannel, GuildChannel("g1")), join(cs, WorldChannel)
+	c3 := join(cs, GuildChannel("g1"))
+
+	send(cs, c1, WorldChannel, " hello ")
+	send(cs, c1, GuildChannel("g1"), "guild")
+	if msgs := messagesOf(c1); strings.Join(msgs, ",") != "world:hello,guild.g1:guild" {
+		t.Errorf("sender should receive messages of its channels, but got %v", msgs)
+	}
+	if msgs := messagesOf(c2); strings.Join(msgs, ",") != "world:hello" {
+		t.Errorf("members should receive messages of the channel, but got %v", msgs)
+	}
+	if msgs := messagesOf(c3); strings.Join(msgs, ",") != "guild.g1:guild" {
+		t.Errorf("members should only receive messages of joined channels, but got %v", msgs)
+	}
+	if call := c2.CallsOf(ClientOnMessage)[0]; common.EntityID(call.Args[1].(string)) != c1.OwnerID || call.Args[2] != "chatter" {
+		t.Errorf("message should be sent with the sender, but got %v", call.Args)
+	}
+
+	send(cs, c3, WorldChannel, "not joined")
+	if reasons := rejectionsOf(c3); len(reasons) != 1 || reasons[0] != "not in channel" || len(messagesOf(c1)) != 2 {
+		t.Errorf("messages to channels not joined should be rejected, but got %v", reasons)
+	}
+
+	w.Call(cs.ID, "Leave", c2.OwnerID, WorldChannel)
+	send(cs, c1, WorldChannel, "bye")
+	if msgs := messagesOf(c2); len(msgs) != 1 {
+		t.Errorf("members left should not receive messages, but got %v", msgs)
+	}
+	if cs.GetMapAttr("members").GetMapAttr(WorldChannel).HasKey(string(c2.OwnerID)) {
+		t.Errorf("members left should be removed from attrs")
+	}
+
+	w.Call(cs.ID, "GetHistory", c1.OwnerID, WorldChannel)
+	history := c1.CallsOf(ClientOnHistory)
+	if len(history) != 1 || history[0].Args[0] != WorldChannel || len(history[0].Args[1].([]interface{})) != 2 {
+		t.Fatalf("history of the channel should be sent, but got %v", history)
+	}
+	w.Call(cs.ID, "GetHistory", c3.OwnerID, WorldChannel)
+	if len(c3.CallsOf(ClientOnHistory)) != 0 {
+		t.Errorf("history should only be sent to members")
+	}
+}
+
+func TestPrivateMessages(t *testing.T) {
+	cs := newService()
+	c1, c2 := join(cs, WorldChannel), join(cs, GuildChannel("g2"))
+	stranger := w.Connect("testChatter")
+
+	w.Call(cs.ID, "SendPrivate", c1.OwnerID, "chatter", stranger.OwnerID, "hi")
+	if reasons := rejectionsOf(c1); len(reasons) != 1 || reasons[0] != "target not found" || len(stranger.Calls) != 0 {
+		t.Errorf("private messages to entities which are not chatters should be rejected, but got %v", reasons)
+	}
+
+	w.Call(cs.ID, "SendPrivate", c1.OwnerID, "chatter", c2.OwnerID, "hi")
+	if msgs := messagesOf(c2); len(msgs) != 1 || msgs[0] != ":hi" {
+		t.Errorf("target should receive the private message, but got %v", msgs)
+	}
+	if msgs := messagesOf(c1); len(msgs) != 1 || msgs[0] != ":hi" {
+		t.Errorf("private message should be echoed to the sender, but got %v", msgs)
+	}
+
+	w.Call(cs.ID, "LeaveAll", c2.OwnerID)
+	w.Call(cs.ID, "SendPrivate", c1.OwnerID, "chatter", c2.OwnerID, "hi")
+	if len(messagesOf(c2)) != 1 || len(rejectionsOf(c1)) != 2 {
+		t.Errorf("private messages to chatters left all channels should be rejected")
+	}
+	if _, ok := cs.memberships[c2.OwnerID]; ok || cs.channels[GuildChannel("g2")] != nil {
+		t.Errorf("chatters left all channels should be removed")
+	}
+}
+
+func TestMessageChecks(t *testing.T) {
+	defer SetRateLimit(rateLimitCount, rateLimitPeriod)
+	defer SetMaxMessageLen(maxMessageLen)
+	SetRateLimit(3, time.Hour)
+	SetMaxMessageLen(8)
+	cs := newService()
+	c := join(cs, WorldChannel)
+
+	send(cs, c, WorldChannel, "   ")
+	send(cs, c, WorldChannel, "too long message")
+	if reasons := rejectionsOf(c); strings.Join(reasons, ",") != "invalid message,invalid message" {
+		t.Errorf("empty and long messages should be rejected, but got %v", reasons)
+	}
+
+	SetFilter(func(sender common.EntityID, channel string, text string) (string, bool) {
+		return strings.Replace(text, "bad", "***", -1), text != "worse"
+	})
+	defer SetFilter(nil)
+	send(cs, c, WorldChannel, "so bad")
+	send(cs, c, WorldChannel, "worse")
+	if msgs := messagesOf(c); len(msgs) != 1 || msgs[0] != "world:so ***" || rejectionsOf(c)[2] != "message filtered" {
+		t.Errorf("messages should be filtered, but got %v", msgs)
+	}
+
+	// rejected messages of length check are not counted, but filtered messages are
+	send(cs, c, WorldChannel, "ok")
+	send(cs, c, WorldChannel, "flood")
+	if msgs, reasons := messagesOf(c), rejectionsOf(c); len(msgs) != 2 || reasons[len(reasons)-1] != "too many messages" {
+		t.Errorf("messages over the rate limit should be rejected, but got %v %v", msgs, reasons)
+	}
+}
+
+func TestHistory(t *testing.T) {
+	defer SetHistorySize(historySize)
+	SetHistorySize(2)
+	cs := newService()
+	c := join(cs, WorldChannel)
+	for _, text := range []string{"1", "2", "3", "4", "5"} {
+		send(cs, c, WorldChannel, text)
+	}
+	if n := cs.GetMapAttr("history").GetListAttr(WorldChannel).Size(); n >= 4 {
+		t.Errorf("history should be trimmed, but has %d messages", n)
+	}
+
+	w.Call(cs.ID, "GetHistory", c.OwnerID, WorldChannel)
+	messages := c.CallsOf(ClientOnHistory)[0].Args[1].([]interface{})
+	if len(messages) != 2 || messages[0].(map[string]interface{})["text"] != "4" || messages[1].(map[string]interface{})["text"] != "5" {
+		t.Errorf("latest messages should be sent as history, but got %v", messages)
+	}
+}
+
+func TestRestoreMembers(t *testing.T) {
+	cs := newService()
+	c1, c2 := join(cs, WorldChannel, GuildChannel("g3")), join(cs, WorldChannel)
+
+	// channel members are restored from attrs when the service is restored or migrated
+	cs.OnInit()
+	cs.OnMigrateIn()
+	if len(cs.channels[WorldChannel]) != 2 || !cs.channels[GuildChannel("g3")].Contains(c1.OwnerID) {
+		t.Fatalf("channel members should be restored, but got %v", cs.channels)
+	}
+	if !cs.chatters.Contains(c2.OwnerID) || len(cs.memberships[c1.OwnerID]) != 2 {
+		t.Errorf("chatters should be restored, but got %v", cs.memberships)
+	}
+	send(cs, c2, WorldChannel, "restored")
+	if msgs := messagesOf(c1); len(msgs) != 1 || msgs[0] != "world:restored" {
+		t.Errorf("restored members should receive messages, but got %v", msgs)
+	}
+}
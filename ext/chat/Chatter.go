@@ -0,0 +1,91 @@
+package chat
+
+import (
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+// Chatter is the mixin of chatting entities, e.g. Avatar, which should be embedded in the entity struct
+//
+// Chatter provides client RPCs for clients to chat, and forwards messages from ChatService to clients:
+//
+//	type Avatar struct {
+//		entity.Entity
+//		chat.Chatter
+//	}
+//
+//	func (a *Avatar) OnAttrsReady() {
+//		a.InitChatter(&a.Entity, a.GetStr("name"))
+//	}
+//
+//	func (a *Avatar) OnCreated() {
+//		a.JoinChannel(chat.WorldChannel)
+//	}
+//
+//	func (a *Avatar) OnDestroy() {
+//		a.LeaveAllChannels()
+//	}
+type Chatter struct {
+	owner *entity.Entity
+	name  string
+}
+
+// InitChatter initialize the chatter with the owner entity and the name shown in messages
+//
+// InitChatter should be called in OnAttrsReady, so that the chatter is initialized after the entity is migrated or restored
+func (c *Chatter) InitChatter(owner *entity.Entity, name string) {
+	c.owner = owner
+	c.name = name
+}
+
+// SetChatterName sets the name shown in messages
+func (c *Chatter) SetChatterName(name string) {
+	c.name = name
+}
+
+// JoinChannel joins the channel, e.g. WorldChannel, ZoneChannel(zoneID) or GuildChannel(guildID)
+func (c *Chatter) JoinChannel(channel string) {
+	goworld.CallService(ServiceName, "Join", c.owner.ID, channel)
+}
+
+// LeaveChannel leaves the channel
+func (c *Chatter) LeaveChannel(channel string) {
+	goworld.CallService(ServiceName, "Leave", c.owner.ID, channel)
+}
+
+// LeaveAllChannels leaves all channels, which should be called when the chatter is destroyed
+func (c *Chatter) LeaveAllChannels() {
+	goworld.CallService(ServiceName, "LeaveAll", c.owner.ID)
+}
+
+// ChatSend_Client is called by clients to send message to the channel
+func (c *Chatter) ChatSend_Client(channel string, text string) {
+	goworld.CallService(ServiceName, "Send", c.owner.ID, c.name, channel, text)
+}
+
+// ChatPrivate_Client is called by clients to send private message to the target chatter, which should have joined
+// channels, otherwise the message is rejected
+func (c *Chatter) ChatPrivate_Client(target common.EntityID, text string) {
+	goworld.CallService(ServiceName, "SendPrivate", c.owner.ID, c.name, target, text)
+}
+
+// ChatHistory_Client is called by clients to get the history of the channel
+func (c *Chatter) ChatHistory_Client(channel string) {
+	goworld.CallService(ServiceName, "GetHistory", c.owner.ID, channel)
+}
+
+// OnChatMessage is called by ChatService when a message is received
+func (c *Chatter) OnChatMessage(channel string, sender common.EntityID, senderName string, text string, sendTime int64) {
+	c.owner.CallClient(ClientOnMessage, channel, sender, senderName, text, sendTime)
+}
+
+// OnChatHistory is called by ChatService with the history of the channel
+func (c *Chatter) OnChatHistory(channel string, messages []interface{}) {
+	c.owner.CallClient(ClientOnHistory, channel, messages)
+}
+
+// OnChatRejected is called by ChatService when the message is rejected
+func (c *Chatter) OnChatRejected(reason string) {
+	c.owner.CallClient(ClientOnRejected, reason)
+}
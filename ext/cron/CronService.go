@@ -0,0 +1,302 @@
+package cron
+
+import (
+	"sort"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+const (
+	// ServiceName is the service name of cron service
+	ServiceName = "CronService"
+
+	tickInterval = time.Second
+)
+
+// Results of job runs
+const (
+	ResultSuccess     = "success"
+	ResultFailed      = "failed"
+	ResultInterrupted = "interrupted" // the game crashed or the service moved to other game while the job was running
+)
+
+// JobHandler runs the job scheduled at the time
+type JobHandler func(scheduledTime time.Time) error
+
+// JobResult is the result of a job run
+type JobResult struct {
+	Name          string
+	ScheduledTime time.Time
+	Duration      time.Duration
+	Result        string
+	Error         string
+}
+
+// JobStatus is the status of job
+type JobStatus struct {
+	Name          string `msgpack:"name"`
+	Spec          string `msgpack:"spec"`
+	LastScheduled int64  `msgpack:"last_scheduled"` // scheduled time of the last run
+	NextRun       int64  `msgpack:"next_run"`
+	Running       bool   `msgpack:"running"`
+	LastResult    string `msgpack:"last_result"`
+	LastError     string `msgpack:"last_error"`
+	Runs          int64  `msgpack:"runs"`
+	Failures      int64  `msgpack:"failures"`
+}
+
+type job struct {
+	name     string
+	spec     string
+	schedule Schedule
+	handler  JobHandler
+}
+
+var (
+	jobs           = map[string]*job{}
+	location       = time.Local
+	resultCallback func(result JobResult)
+)
+
+// RegisterJob registers the job with the cron expression, which should be called on all games before goworld.Run
+//
+// See ParseSchedule for the syntax of spec.
+func RegisterJob(name string, spec string, handler JobHandler) {
+	schedule, err := ParseSchedule(spec)
+	if err != nil {
+		gwlog.Panicf("register cron job %s failed: %s", name, err)
+	}
+	if jobs[name] != nil {
+		gwlog.Panicf("cron job %s is already registered", name)
+	}
+
+	jobs[name] = &job{name: name, spec: spec, schedule: schedule, handler: handler}
+}
+
+// SetLocation sets the time zone of cron expressions, defaults to local time zone
+func SetLocation(loc *time.Location) {
+	location = loc
+}
+
+// SetResultCallback sets the callback of job results, which is called on the game of cron service
+func SetResultCallback(cb func(result JobResult)) {
+	resultCallback = cb
+}
+
+// RegisterService registeres CronService to goworld
+func RegisterService() {
+	goworld.RegisterService(ServiceName, &CronService{})
+}
+
+// GetJobStatuses gets statuses of all jobs
+func GetJobStatuses(cb func(statuses []JobStatus, err error)) {
+	goworld.CallServiceRequest(ServiceName, "GetJobStatuses", nil, goworld.ServiceRequestOptions{Idempotent: true, Retries: 1}, func(result interface{}, err error) {
+		var statuses []JobStatus
+		if err == nil && result != nil {
+			err = decodeResult(result, &statuses)
+		}
+		cb(statuses, err)
+	})
+}
+
+// CronService is the service entity running scheduled jobs
+//
+// The service entity is created on exactly one game of the cluster, which is the leader running all jobs.
+// Each run is claimed by saving its scheduled time to entity storage before the job runs, so that jobs never run twice,
+// even if the service is recreated on other game. Runs interrupted by crashes are reported, but not retried.
+// Runs missed while the service is down are caught up once, with the latest missed scheduled time.
+type CronService struct {
+	entity.Entity
+
+	claiming map[string]bool // jobs waiting for claims to be saved
+}
+
+func (cs *CronService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("jobs", "Persistent")
+}
+
+// OnInit initialize CronService fields
+func (cs *CronService) OnInit() {
+	cs.claiming = map[string]bool{}
+}
+
+// OnAttrsReady reports runs interrupted by crashes or migration
+func (cs *CronService) OnAttrsReady() {
+	if !cs.Attrs.HasKey("jobs") {
+		cs.Attrs.SetMapAttr("jobs", goworld.MapAttr())
+	}
+
+	cs.GetMapAttr("jobs").ForEach(func(name string, val interface{}) {
+		state := val.(*entity.MapAttr)
+		if state.GetBool("running") {
+			scheduledTime := time.Unix(state.GetInt("scheduled"), 0)
+			gwlog.Warnf("%s: job %s scheduled at %s was interrupted", cs, name, scheduledTime)
+			cs.finishRun(name, state, scheduledTime, 0, ResultInterrupted, "job interrupted")
+		}
+	})
+}
+
+// OnCreated is called when CronService is created
+func (cs *CronService) OnCreated() {
+	cs.AddTimer(tickInterval, "Tick")
+}
+
+// Tick runs jobs which are due
+func (cs *CronService) Tick() {
+	now := time.Now().In(location)
+	names := make([]string, 0, len(jobs))
+	for name := range jobs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !cs.claiming[name] {
+			cs.checkJob(jobs[name], now)
+		}
+	}
+}
+
+func (cs *CronService) checkJob(j *job, now time.Time) {
+	state := cs.getJobState(j.name)
+	if !state.HasKey("scheduled") {
+		// new job runs at the next scheduled time
+		state.SetInt("scheduled", now.Unix())
+		return
+	}
+
+	scheduledTime := cs.getDueTime(j, time.Unix(state.GetInt("scheduled"), 0).In(location), now)
+	if scheduledTime.IsZero() {
+		return
+	}
+
+	// claim the run before running the job, so that the run is never repeated
+	cs.claiming[j.name] = true
+	state.SetInt("scheduled", scheduledTime.Unix())
+	state.SetBool("running", true)
+	cs.SaveWithCallback(func() {
+		delete(cs.claiming, j.name)
+		if cs.IsDestroyed() {
+			// service is migrated, the run is reported as interrupted by the new service entity
+			return
+		}
+		cs.runJob(j, state, scheduledTime)
+	})
+}
+
+// getDueTime returns the latest scheduled time of the job before now, or zero time if the job is not due
+func (cs *CronService) getDueTime(j *job, lastScheduled time.Time, now time.Time) time.Time {
+	next := j.schedule.Next(lastScheduled)
+	if next.IsZero() || next.After(now) {
+		return time.Time{}
+	}
+
+	// catch up missed runs only once
+	for {
+		after := j.schedule.Next(next)
+		if after.IsZero() || after.After(now) {
+			return next
+		}
+		next = after
+	}
+}
+
+func (cs *CronService) runJob(j *job, state *entity.MapAttr, scheduledTime time.Time) {
+	gwlog.Infof("%s: running job %s scheduled at %s ...", cs, j.name, scheduledTime)
+	startTime := time.Now()
+	var err error
+	if perr := gwutils.CatchPanic(func() {
+		err = j.handler(scheduledTime)
+	}); perr != nil {
+		err = errors.Errorf("job paniced: %v", perr)
+	}
+
+	duration := time.Since(startTime)
+	if err != nil {
+		gwlog.Errorf("%s: job %s scheduled at %s failed: %s", cs, j.name, scheduledTime, err)
+		cs.finishRun(j.name, state, scheduledTime, duration, ResultFailed, err.Error())
+	} else {
+		gwlog.Infof("%s: job %s scheduled at %s succeed, takes %s", cs, j.name, scheduledTime, duration)
+		cs.finishRun(j.name, state, scheduledTime, duration, ResultSuccess, "")
+	}
+}
+
+func (cs *CronService) finishRun(name string, state *entity.MapAttr, scheduledTime time.Time, duration time.Duration, result string, errmsg string) {
+	state.SetBool("running", false)
+	state.SetStr("result", result)
+	state.SetStr("error", errmsg)
+	state.SetInt("runs", state.GetInt("runs")+1)
+	if result != ResultSuccess {
+		state.SetInt("failures", state.GetInt("failures")+1)
+	}
+	cs.Save()
+
+	if resultCallback != nil {
+		gwutils.RunPanicless(func() {
+			resultCallback(JobResult{
+				Name:          name,
+				ScheduledTime: scheduledTime,
+				Duration:      duration,
+				Result:        result,
+				Error:         errmsg,
+			})
+		})
+	}
+}
+
+func (cs *CronService) getJobState(name string) *entity.MapAttr {
+	jobsAttr := cs.GetMapAttr("jobs")
+	if !jobsAttr.HasKey(name) {
+		jobsAttr.SetMapAttr(name, goworld.MapAttr())
+	}
+	return jobsAttr.GetMapAttr(name)
+}
+
+// GetJobStatuses returns statuses of all registered jobs
+func (cs *CronService) GetJobStatuses() []JobStatus {
+	jobsAttr := cs.GetMapAttr("jobs")
+	statuses := []JobStatus{}
+	for name, j := range jobs {
+		state := goworld.MapAttr()
+		if jobsAttr.HasKey(name) {
+			state = jobsAttr.GetMapAttr(name)
+		}
+		status := JobStatus{
+			Name:          name,
+			Spec:          j.spec,
+			LastScheduled: state.GetInt("scheduled"),
+			Running:       state.GetBool("running"),
+			LastResult:    state.GetStr("result"),
+			LastError:     state.GetStr("error"),
+			Runs:          state.GetInt("runs"),
+			Failures:      state.GetInt("failures"),
+		}
+		if status.LastScheduled > 0 {
+			if next := j.schedule.Next(time.Unix(status.LastScheduled, 0).In(location)); !next.IsZero() {
+				status.NextRun = next.Unix()
+			}
+		}
+		statuses = append(statuses, status)
+	}
+
+	sort.Slice(statuses, func(i, j int) bool {
+		return statuses[i].Name < statuses[j].Name
+	})
+	return statuses
+}
+
+// decodeResult decodes the result of service request, which is decoded to generic types, to typed value
+func decodeResult(result interface{}, v interface{}) error {
+	data, err := netutil.MSG_PACKER.PackMsg(result, nil)
+	if err != nil {
+		return errors.Wrap(err, "decode job statuses failed")
+	}
+	return errors.Wrap(netutil.MSG_PACKER.UnpackMsg(data, v), "decode job statuses failed")
+}
@@ -0,0 +1,191 @@
+package cron
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/goworldtest"
+)
+
+type testSpace struct {
+	entity.Space
+}
+
+var (
+	w       *goworldtest.World
+	results []JobResult
+)
+
+func init() {
+	entity.RegisterSpace(&testSpace{})
+	entity.RegisterEntity(ServiceName, &CronService{}, false)
+	SetLocation(time.UTC)
+	SetResultCallback(func(result JobResult) {
+		results = append(results, result)
+	})
+	w = goworldtest.Setup()
+}
+
+func newService() *CronService {
+	results = nil
+	return w.CreateEntity(ServiceName).I.(*CronService)
+}
+
+// claimed runs the claimed job like the service does after the claim is saved, since entity storage is not initialized
+// by goworldtest
+func claimed(cs *CronService, j *job) {
+	state := cs.getJobState(j.name)
+	delete(cs.claiming, j.name)
+	cs.runJob(j, state, time.Unix(state.GetInt("scheduled"), 0).In(location))
+}
+
+func TestRunOnce(t *testing.T) {
+	var runs []time.Time
+	RegisterJob("testRunOnce", "*/10 * * * *", func(scheduledTime time.Time) error {
+		runs = append(runs, scheduledTime)
+		return nil
+	})
+	j := jobs["testRunOnce"]
+	cs := newService()
+	state := cs.getJobState(j.name)
+
+	now := time.Date(2026, 1, 1, 10, 5, 30, 0, time.UTC)
+	cs.checkJob(j, now)
+	if state.GetInt("scheduled") != now.Unix() || cs.claiming[j.name] {
+		t.Fatalf("new job should not run until the next scheduled time")
+	}
+	cs.checkJob(j, now.Add(time.Minute*4))
+	if cs.claiming[j.name] {
+		t.Fatalf("job should not run before the scheduled time")
+	}
+
+	// runs missed are caught up once with the latest scheduled time
+	now = time.Date(2026, 1, 1, 10, 35, 0, 0, time.UTC)
+	scheduledTime := time.Date(2026, 1, 1, 10, 30, 0, 0, time.UTC)
+	cs.checkJob(j, now)
+	if !cs.claiming[j.name] || !state.GetBool("running") || state.GetInt("scheduled") != scheduledTime.Unix() || len(runs) != 0 {
+		t.Fatalf("job should be claimed before it runs")
+	}
+	cs.Tick()
+	if len(runs) != 0 || state.GetInt("scheduled") != scheduledTime.Unix() {
+		t.Fatalf("job should not be checked again while it is being claimed")
+	}
+
+	claimed(cs, j)
+	if len(runs) != 1 || !runs[0].Equal(scheduledTime) {
+		t.Fatalf("job should run once at %s, but runs at %v", scheduledTime, runs)
+	}
+	if state.GetBool("running") || state.GetStr("result") != ResultSuccess || state.GetInt("runs") != 1 || state.GetInt("failures") != 0 {
+		t.Errorf("job should be finished, but state is %v", state.ToMap())
+	}
+	if len(results) != 1 || results[0].Name != j.name || results[0].Result != ResultSuccess || !results[0].ScheduledTime.Equal(scheduledTime) {
+		t.Errorf("result should be reported, but got %+v", results)
+	}
+
+	cs.checkJob(j, now.Add(time.Minute*4))
+	if cs.claiming[j.name] {
+		t.Errorf("job should not run twice at the same scheduled time")
+	}
+}
+
+func TestJobFailures(t *testing.T) {
+	var fail error
+	RegisterJob("testJobFailures", "* * * * *", func(scheduledTime time.Time) error {
+		if fail != nil {
+			return fail
+		}
+		panic("crashed")
+	})
+	j := jobs["testJobFailures"]
+	cs := newService()
+	state := cs.getJobState(j.name)
+
+	now := time.Date(2026, 1, 1, 10, 0, 30, 0, time.UTC)
+	cs.checkJob(j, now)
+	cs.checkJob(j, now.Add(time.Minute))
+	claimed(cs, j)
+	if state.GetStr("result") != ResultFailed || !strings.Contains(state.GetStr("error"), "crashed") || state.GetInt("failures") != 1 {
+		t.Errorf("panic of job should be reported as failure, but state is %v", state.ToMap())
+	}
+
+	fail = errors.New("no connection")
+	cs.checkJob(j, now.Add(time.Minute*2))
+	claimed(cs, j)
+	if state.GetStr("error") != "no connection" || state.GetInt("failures") != 2 || state.GetInt("runs") != 2 {
+		t.Errorf("error of job should be reported as failure, but state is %v", state.ToMap())
+	}
+	if len(results) != 2 || results[1].Result != ResultFailed || results[1].Error != "no connection" {
+		t.Errorf("failures should be reported, but got %+v", results)
+	}
+}
+
+func TestInterruptedRuns(t *testing.T) {
+	var runs int
+	RegisterJob("testInterruptedRuns", "0 * * * *", func(scheduledTime time.Time) error {
+		runs += 1
+		return nil
+	})
+	j := jobs["testInterruptedRuns"]
+	cs := newService()
+	state := cs.getJobState(j.name)
+
+	scheduledTime := time.Date(2026, 1, 1, 10, 0, 0, 0, time.UTC)
+	cs.checkJob(j, scheduledTime.Add(-time.Minute))
+	cs.checkJob(j, scheduledTime)
+	if !state.GetBool("running") {
+		t.Fatalf("job should be claimed")
+	}
+
+	// the claim is saved, but the game crashes before the job finishes, so the run is reported by the restored service
+	cs.OnAttrsReady()
+	if state.GetBool("running") || state.GetStr("result") != ResultInterrupted || state.GetInt("failures") != 1 || runs != 0 {
+		t.Fatalf("interrupted run should be reported, but state is %v", state.ToMap())
+	}
+	if len(results) != 1 || results[0].Result != ResultInterrupted || !results[0].ScheduledTime.Equal(scheduledTime) {
+		t.Errorf("interrupted run should be reported, but got %+v", results)
+	}
+
+	delete(cs.claiming, j.name) // the restored service is not claiming
+	cs.checkJob(j, scheduledTime.Add(time.Minute*30))
+	if cs.claiming[j.name] {
+		t.Errorf("interrupted run should not be retried")
+	}
+}
+
+func TestGetJobStatuses(t *testing.T) {
+	RegisterJob("testGetJobStatuses", "30 2 * * *", func(scheduledTime time.Time) error {
+		return nil
+	})
+	cs := newService()
+	lastScheduled := time.Date(2026, 1, 1, 2, 30, 0, 0, time.UTC)
+	state := cs.getJobState("testGetJobStatuses")
+	state.SetInt("scheduled", lastScheduled.Unix())
+	state.SetInt("runs", 3)
+
+	result, err := w.Request(cs.ID, "GetJobStatuses")
+	if err != nil {
+		t.Fatalf("get job statuses failed: %s", err)
+	}
+	var statuses []JobStatus
+	if err := decodeResult(result, &statuses); err != nil {
+		t.Fatalf("decode job statuses failed: %s", err)
+	}
+	if len(statuses) != len(jobs) {
+		t.Fatalf("statuses of all jobs should be returned, but got %+v", statuses)
+	}
+	for i, status := range statuses {
+		if i > 0 && statuses[i-1].Name >= status.Name {
+			t.Errorf("statuses should be sorted by name, but got %+v", statuses)
+		}
+		if status.Name != "testGetJobStatuses" {
+			continue
+		}
+		if status.Spec != "30 2 * * *" || status.Runs != 3 || status.LastScheduled != lastScheduled.Unix() ||
+			status.NextRun != lastScheduled.Add(time.Hour*24).Unix() {
+			t.Errorf("wrong status: %+v", status)
+		}
+	}
+}
@@ -0,0 +1,15 @@
+package cron
+
+import (
+	"github.com/xiaonanln/goworld/engine/crontab"
+)
+
+// Schedule decides when jobs run
+type Schedule = crontab.Schedule
+
+// ParseSchedule parses the cron expression with 5 fields: minute hour day-of-month month day-of-week
+//
+// See crontab.ParseSchedule for the syntax of spec.
+func ParseSchedule(spec string) (Schedule, error) {
+	return crontab.ParseSchedule(spec)
+}
@@ -0,0 +1,201 @@
+package delayedjob
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+const (
+	tickInterval = time.Second
+)
+
+var jobPacker = netutil.MSG_PACKER
+
+// DelayedJobService is the service entity executing delayed jobs
+//
+// Jobs are sharded by job IDs, and each shard saves its jobs to entity storage as the attr of the shard entity, so that
+// jobs survive restarts of games. Jobs calling entities are removed and saved before the call, so they are never
+// executed twice. Jobs calling services are marked running and saved before the request, and interrupted requests are
+// retried by the recreated service.
+type DelayedJobService struct {
+	entity.Entity
+
+	jobs  map[string]*Job // Job ID -> Job
+	queue jobQueue        // jobs waiting to execute ordered by execute time
+}
+
+func (s *DelayedJobService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("jobs", "Persistent")
+}
+
+// OnInit initialize DelayedJobService fields
+func (s *DelayedJobService) OnInit() {
+	s.jobs = map[string]*Job{}
+}
+
+// OnAttrsReady loads saved jobs
+func (s *DelayedJobService) OnAttrsReady() {
+	if !s.Attrs.HasKey("jobs") {
+		s.Attrs.SetMapAttr("jobs", goworld.MapAttr())
+	}
+
+	s.GetMapAttr("jobs").ForEach(func(id string, val interface{}) {
+		job, err := decodeJob(val.(string))
+		if err != nil {
+			gwlog.Errorf("%s: invalid job %s: %s", s, id, err)
+			return
+		}
+		s.jobs[id] = job
+		if job.Running {
+			gwlog.Warnf("%s: job %s calling %s.%s was interrupted", s, id, job.Service, job.Method)
+			s.onServiceJobFailed(job, errors.New("job interrupted"))
+		} else {
+			s.queue.push(job)
+		}
+	})
+	gwlog.Infof("%s: %d delayed jobs loaded", s, len(s.jobs))
+}
+
+// OnCreated is called when DelayedJobService is created
+func (s *DelayedJobService) OnCreated() {
+	s.AddTimer(tickInterval, "Tick")
+}
+
+// Schedule saves the job, which is ignored if the job already exists
+func (s *DelayedJobService) Schedule(job Job) error {
+	if err := validateJob(&job); err != nil {
+		gwlog.Errorf("%s: reject job %s: %s", s, job.ID, err)
+		return err
+	}
+	if s.jobs[job.ID] != nil {
+		return nil
+	}
+	s.putJob(&job)
+	s.Save()
+	return nil
+}
+
+// Cancel removes the job if it is not executed yet
+func (s *DelayedJobService) Cancel(id string) {
+	job := s.jobs[id]
+	if job == nil || job.Running {
+		return
+	}
+	s.removeJob(id)
+	s.Save()
+}
+
+// Tick executes jobs which are due
+func (s *DelayedJobService) Tick() {
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for _, entry := range s.queue.popDue(now) {
+		job := s.jobs[entry.id]
+		if job == nil || job.Running || job.ExecuteAt != entry.executeAt {
+			continue // the job is cancelled, running or rescheduled
+		}
+
+		if job.EntityID.IsNil() {
+			s.runServiceJob(job)
+		} else {
+			s.runEntityJob(job)
+		}
+	}
+}
+
+func (s *DelayedJobService) runEntityJob(job *Job) {
+	s.removeJob(job.ID)
+	s.SaveWithCallback(func() {
+		goworld.LoadEntityAnywhere(job.EntityType, job.EntityID)
+		s.Call(job.EntityID, job.Method, job.Args...)
+	})
+}
+
+func (s *DelayedJobService) runServiceJob(job *Job) {
+	job.Attempts += 1
+	job.Running = true
+	s.putJob(job)
+	s.SaveWithCallback(func() {
+		if s.IsDestroyed() {
+			// service is migrated, the request is retried by the new service entity
+			return
+		}
+
+		goworld.CallServiceRequest(job.Service, job.Method, job.Args, goworld.ServiceRequestOptions{}, func(result interface{}, err error) {
+			if s.IsDestroyed() || s.jobs[job.ID] != job {
+				return
+			}
+			if err != nil {
+				s.onServiceJobFailed(job, err)
+				return
+			}
+			s.removeJob(job.ID)
+			s.Save()
+		})
+	})
+}
+
+// onServiceJobFailed retries the job later, or removes the job if all retries failed
+func (s *DelayedJobService) onServiceJobFailed(job *Job, err error) {
+	job.Running = false
+	if job.Attempts <= job.Retries {
+		delay := getRetryDelay(job.Attempts)
+		gwlog.Warnf("%s: job %s calling %s.%s failed: %s, retry after %s", s, job.ID, job.Service, job.Method, err, delay)
+		job.ExecuteAt = time.Now().Add(delay).UnixNano() / int64(time.Millisecond)
+		s.putJob(job)
+		s.Save()
+		return
+	}
+
+	gwlog.Errorf("%s: job %s calling %s.%s failed after %d attempts: %s", s, job.ID, job.Service, job.Method, job.Attempts, err)
+	s.removeJob(job.ID)
+	s.Save()
+	if failureCallback != nil {
+		gwutils.RunPanicless(func() {
+			failureCallback(*job, err)
+		})
+	}
+}
+
+func (s *DelayedJobService) putJob(job *Job) {
+	data, err := encodeJob(job)
+	if err != nil {
+		gwlog.Errorf("%s: save job %s calling %s.%s failed: %s", s, job.ID, job.Service+string(job.EntityID), job.Method, err)
+		return
+	}
+	s.jobs[job.ID] = job
+	s.GetMapAttr("jobs").SetStr(job.ID, data)
+	if !job.Running {
+		s.queue.push(job)
+	}
+}
+
+func (s *DelayedJobService) removeJob(id string) {
+	delete(s.jobs, id)
+	s.GetMapAttr("jobs").Del(id)
+}
+
+// encodeJob encodes the job as string, since args of jobs might not be valid attr values
+func encodeJob(job *Job) (string, error) {
+	data, err := jobPacker.PackMsg(job, nil)
+	if err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(data), nil
+}
+
+func decodeJob(s string) (*Job, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	job := &Job{}
+	return job, jobPacker.UnpackMsg(data, job)
+}
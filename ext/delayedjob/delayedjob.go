@@ -0,0 +1,137 @@
+package delayedjob
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/uuid"
+)
+
+const (
+	// ServiceName is the service name of delayed job service
+	ServiceName = "DelayedJobService"
+
+	maxRetryInterval = time.Hour
+)
+
+// Job calls the method of the entity or the service at the execute time
+//
+// Jobs calling entities are executed at most once, and entities of EntityType are loaded if they are not loaded, so
+// that jobs can call offline players. EntityType is required and should be persistent, since entities which can not
+// be loaded might not exist when the job is executed. Jobs calling services are service requests, which are retried
+// on failures until Retries is reached, so methods of services should be idempotent.
+type Job struct {
+	ID         string          `msgpack:"id"`
+	EntityType string          `msgpack:"et"` // type of the entity to load before calling
+	EntityID   common.EntityID `msgpack:"eid"`
+	Service    string          `msgpack:"svc"` // service to call if EntityID is empty
+	Method     string          `msgpack:"m"`
+	Args       []interface{}   `msgpack:"a"`
+	ExecuteAt  int64           `msgpack:"at"` // unix time in milliseconds
+	Retries    int             `msgpack:"r"`  // max number of retries of failed service requests
+	Attempts   int             `msgpack:"n"`
+	Running    bool            `msgpack:"run"` // the service request is sent but not responded
+}
+
+// ExecuteTime returns the time to execute the job
+func (job *Job) ExecuteTime() time.Time {
+	return time.Unix(0, job.ExecuteAt*int64(time.Millisecond))
+}
+
+var (
+	retryInterval   = time.Second * 10
+	failureCallback func(job Job, err error)
+)
+
+// SetRetryInterval sets the interval before the first retry of failed service requests, which is doubled for following
+// retries up to 1 hour
+func SetRetryInterval(d time.Duration) {
+	retryInterval = d
+}
+
+// SetFailureCallback sets the callback of jobs failed after all retries, which is called on the game of the service
+func SetFailureCallback(cb func(job Job, err error)) {
+	failureCallback = cb
+}
+
+// RegisterService registeres DelayedJobService to goworld, jobs are distributed to shards by job IDs
+func RegisterService(shardCount int) {
+	goworld.RegisterServiceSharded(ServiceName, &DelayedJobService{}, shardCount)
+}
+
+// validateJob checks if the job calls an entity which can be loaded or a service
+func validateJob(job *Job) error {
+	if job.EntityID.IsNil() {
+		if job.Service == "" {
+			return errors.Errorf("delayed job %s should call an entity or a service", job.Method)
+		}
+		return nil
+	}
+
+	if job.EntityType == "" {
+		return errors.Errorf("delayed job %s calling entity %s should specify the entity type", job.Method, job.EntityID)
+	}
+	if desc := entity.GetEntityTypeDesc(job.EntityType); desc != nil && !desc.IsPersistent {
+		return errors.Errorf("delayed job %s calling entity %s: entity type %s is not persistent", job.Method, job.EntityID, job.EntityType)
+	}
+	return nil
+}
+
+// RunAt schedules the job to execute at the time, and returns the job ID
+//
+// The job is executed even if the game scheduling the job is restarted, since jobs are saved to entity storage by the
+// service.
+func RunAt(t time.Time, job Job) string {
+	if err := validateJob(&job); err != nil {
+		gwlog.Panic(err)
+	}
+	job.ID = uuid.GenUUID()
+	job.ExecuteAt = t.UnixNano() / int64(time.Millisecond)
+	job.Attempts, job.Running = 0, false
+
+	// the job is scheduled only once even if the request is retried, since the service ignores existing job IDs
+	opts := goworld.ServiceRequestOptions{Idempotent: true, Retries: 3, ShardKey: job.ID}
+	goworld.CallServiceRequest(ServiceName, "Schedule", []interface{}{job}, opts, func(result interface{}, err error) {
+		if err != nil {
+			gwlog.Errorf("delayed job: schedule %s.%s failed: %s", job.Service+string(job.EntityID), job.Method, err)
+		}
+	})
+	return job.ID
+}
+
+// RunAfter schedules the job to execute after the duration, and returns the job ID
+func RunAfter(d time.Duration, job Job) string {
+	return RunAt(time.Now().Add(d), job)
+}
+
+// CallEntityAfter calls the method of the entity after the duration, the entity of the persistent entityType is loaded
+// if it is not loaded
+func CallEntityAfter(d time.Duration, entityType string, eid common.EntityID, method string, args ...interface{}) string {
+	return RunAfter(d, Job{EntityType: entityType, EntityID: eid, Method: method, Args: args})
+}
+
+// CallServiceAfter calls the method of the service after the duration, and retries the request if it failed
+func CallServiceAfter(d time.Duration, retries int, serviceName string, method string, args ...interface{}) string {
+	return RunAfter(d, Job{Service: serviceName, Method: method, Args: args, Retries: retries})
+}
+
+// Cancel cancels the job if it is not executed yet
+func Cancel(id string) {
+	goworld.CallServiceShardKey(ServiceName, id, "Cancel", id)
+}
+
+// getRetryDelay returns the delay before the retry of job
+func getRetryDelay(attempts int) time.Duration {
+	d := retryInterval
+	for i := 1; i < attempts && d < maxRetryInterval; i++ {
+		d *= 2
+	}
+	if d > maxRetryInterval {
+		d = maxRetryInterval
+	}
+	return d
+}
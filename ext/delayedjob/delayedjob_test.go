@@ -0,0 +1,108 @@
+package delayedjob
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+type testPlayer struct {
+	entity.Entity
+}
+
+func (p *testPlayer) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.SetPersistent(true)
+}
+
+type testMonster struct {
+	entity.Entity
+}
+
+func (m *testMonster) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+func init() {
+	entity.RegisterEntity("testPlayer", &testPlayer{}, false)
+	entity.RegisterEntity("testMonster", &testMonster{}, false)
+}
+
+func TestValidateJob(t *testing.T) {
+	eid := common.GenEntityID()
+	for _, c := range []struct {
+		job   Job
+		valid bool
+	}{
+		{Job{Service: "MailService", Method: "Deliver"}, true},
+		{Job{EntityType: "testPlayer", EntityID: eid, Method: "Reward"}, true},
+		{Job{EntityType: "Account", EntityID: eid, Method: "Reward"}, true}, // entity types of other games are not checked
+		{Job{Method: "Reward"}, false},
+		{Job{EntityID: eid, Method: "Reward"}, false},
+		{Job{EntityType: "testMonster", EntityID: eid, Method: "Reward"}, false},
+	} {
+		if err := validateJob(&c.job); (err == nil) != c.valid {
+			t.Errorf("job %+v should be valid: %v, but got error %v", c.job, c.valid, err)
+		}
+	}
+}
+
+func TestJobQueue(t *testing.T) {
+	var q jobQueue
+	for i, at := range []int64{300, 100, 500, 200, 400} {
+		q.push(&Job{ID: string(rune('a' + i)), ExecuteAt: at})
+	}
+
+	if due := q.popDue(50); len(due) != 0 {
+		t.Fatalf("no job should be due, but got %v", due)
+	}
+	due := q.popDue(300)
+	if len(due) != 3 || due[0].id != "b" || due[1].id != "d" || due[2].id != "a" {
+		t.Fatalf("due jobs should be ordered by execute time, but got %v", due)
+	}
+	if q.Len() != 2 {
+		t.Errorf("due jobs should be removed from the queue, but %d jobs are left", q.Len())
+	}
+
+	// retried jobs are pushed again at the new execute time
+	q.push(&Job{ID: "a", ExecuteAt: 450})
+	due = q.popDue(1000)
+	if len(due) != 3 || due[0].id != "e" || due[1].id != "a" || due[1].executeAt != 450 || due[2].id != "c" {
+		t.Errorf("wrong due jobs: %v", due)
+	}
+}
+
+func TestEncodeJob(t *testing.T) {
+	job := &Job{ID: "job1", EntityType: "testPlayer", EntityID: common.GenEntityID(), Method: "Reward", Args: []interface{}{"gold", 100},
+		ExecuteAt: time.Now().UnixNano() / int64(time.Millisecond), Retries: 3, Attempts: 1, Running: true}
+	data, err := encodeJob(job)
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, err := decodeJob(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if decoded.ID != job.ID || decoded.EntityType != job.EntityType || decoded.EntityID != job.EntityID || decoded.Method != job.Method ||
+		len(decoded.Args) != 2 || decoded.ExecuteAt != job.ExecuteAt || decoded.Retries != 3 || decoded.Attempts != 1 || !decoded.Running {
+		t.Errorf("decoded job %+v is different from %+v", decoded, job)
+	}
+	if _, err := decodeJob("not base64"); err == nil {
+		t.Errorf("decoding invalid job should fail")
+	}
+}
+
+func TestGetRetryDelay(t *testing.T) {
+	defer SetRetryInterval(retryInterval)
+	SetRetryInterval(time.Second * 10)
+	for attempts, delay := range map[int]time.Duration{
+		1:  time.Second * 10,
+		2:  time.Second * 20,
+		4:  time.Second * 80,
+		20: maxRetryInterval,
+	} {
+		if d := getRetryDelay(attempts); d != delay {
+			t.Errorf("retry delay of attempt %d should be %s, but is %s", attempts, delay, d)
+		}
+	}
+}
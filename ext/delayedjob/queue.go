@@ -0,0 +1,52 @@
+package delayedjob
+
+import (
+	"container/heap"
+)
+
+// queueEntry is the entry of job in the queue, which is stale if the job is removed, running or rescheduled
+type queueEntry struct {
+	executeAt int64
+	id        string
+}
+
+// jobQueue is the min heap of job entries ordered by execute time, so that due jobs are found without iterating all jobs
+type jobQueue []queueEntry
+
+func (q jobQueue) Len() int {
+	return len(q)
+}
+
+func (q jobQueue) Less(i, j int) bool {
+	return q[i].executeAt < q[j].executeAt
+}
+
+func (q jobQueue) Swap(i, j int) {
+	q[i], q[j] = q[j], q[i]
+}
+
+func (q *jobQueue) Push(x interface{}) {
+	*q = append(*q, x.(queueEntry))
+}
+
+func (q *jobQueue) Pop() interface{} {
+	old := *q
+	n := len(old)
+	x := old[n-1]
+	*q = old[0 : n-1]
+	return x
+}
+
+// push adds the job to the queue at its execute time
+func (q *jobQueue) push(job *Job) {
+	heap.Push(q, queueEntry{executeAt: job.ExecuteAt, id: job.ID})
+}
+
+// popDue removes and returns entries due at now, ordered by execute time
+func (q *jobQueue) popDue(now int64) []queueEntry {
+	var due []queueEntry
+	for q.Len() > 0 && (*q)[0].executeAt <= now {
+		due = append(due, heap.Pop(q).(queueEntry))
+	}
+	return due
+}
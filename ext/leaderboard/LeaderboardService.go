@@ -0,0 +1,204 @@
+package leaderboard
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// ServiceName is the service name of leaderboard service
+	ServiceName = "LeaderboardService"
+)
+
+var (
+	boardCapacity = 10000
+	maxQuerySize  = 100
+)
+
+// Entry is an entry on the leaderboard
+type Entry struct {
+	Member string `msgpack:"m"`
+	Score  int64  `msgpack:"s"`
+	Rank   int    `msgpack:"r"` // starting from 1, 0 if the member is not on the board
+}
+
+// SetBoardCapacity sets the max number of entries kept on each board, entries of the lowest ranks are dropped if the board is full
+func SetBoardCapacity(capacity int) {
+	boardCapacity = capacity
+}
+
+// SetMaxQuerySize sets the max number of entries returned by GetTop and GetAroundMe
+func SetMaxQuerySize(n int) {
+	maxQuerySize = n
+}
+
+// LeaderboardService is the service entity keeping leaderboards
+//
+// Boards are distributed among shards of the service by board names. Boards are kept in attrs, so they are persistent,
+// and are migrated with the service entity. Boards are indexed as sorted sets in memory once attrs are ready.
+type LeaderboardService struct {
+	entity.Entity
+
+	boards map[string]*board
+}
+
+// RegisterService registeres LeaderboardService to goworld, boards are distributed among shards
+func RegisterService(shardCount int) {
+	goworld.RegisterServiceSharded(ServiceName, &LeaderboardService{}, shardCount)
+}
+
+func (ls *LeaderboardService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.SetPersistent(true)
+	desc.DefineAttr("boards", "Persistent")
+}
+
+// OnInit initialize LeaderboardService fields
+func (ls *LeaderboardService) OnInit() {
+	ls.boards = map[string]*board{}
+}
+
+// OnAttrsReady builds sorted sets of boards from attrs
+func (ls *LeaderboardService) OnAttrsReady() {
+	if !ls.Attrs.HasKey("boards") {
+		ls.Attrs.SetMapAttr("boards", goworld.MapAttr())
+	}
+
+	ls.GetMapAttr("boards").ForEach(func(name string, val interface{}) {
+		b := newBoard()
+		val.(*entity.MapAttr).ForEach(func(member string, val interface{}) {
+			entryAttr := val.(*entity.MapAttr)
+			b.set(member, entryAttr.GetInt("s"), entryAttr.GetInt("t"))
+		})
+		ls.boards[name] = b
+	})
+	gwlog.Infof("%s: %d boards loaded", ls, len(ls.boards))
+}
+
+// Submit submits the score of member, the score is updated only if it is higher than the current score
+func (ls *LeaderboardService) Submit(boardName string, member string, score int64) {
+	if e := ls.getBoard(boardName).get(member); e != nil && e.score >= score {
+		return
+	}
+	ls.setScore(boardName, member, score)
+}
+
+// SetScore sets the score of member
+func (ls *LeaderboardService) SetScore(boardName string, member string, score int64) {
+	ls.setScore(boardName, member, score)
+}
+
+func (ls *LeaderboardService) setScore(boardName string, member string, score int64) {
+	b := ls.getBoard(boardName)
+	if boardCapacity > 0 && b.size() >= boardCapacity && b.get(member) == nil {
+		if last := b.last(); last.score >= score {
+			// the board is full, and the score is not high enough
+			return
+		}
+	}
+
+	now := time.Now().UnixNano()
+	b.set(member, score, now)
+	boardAttr := ls.getBoardAttr(boardName)
+	entryAttr := goworld.MapAttr()
+	entryAttr.SetInt("s", score)
+	entryAttr.SetInt("t", now)
+	boardAttr.SetMapAttr(member, entryAttr)
+
+	for boardCapacity > 0 && b.size() > boardCapacity {
+		last := b.last()
+		b.remove(last.member)
+		boardAttr.Del(last.member)
+	}
+}
+
+// Remove removes member from the board
+func (ls *LeaderboardService) Remove(boardName string, member string) {
+	b := ls.boards[boardName]
+	if b == nil || !b.remove(member) {
+		return
+	}
+
+	boardAttr := ls.getBoardAttr(boardName)
+	boardAttr.Del(member)
+	if b.size() == 0 {
+		ls.deleteBoard(boardName)
+	}
+}
+
+// ClearBoard removes all entries of the board
+func (ls *LeaderboardService) ClearBoard(boardName string) {
+	if ls.boards[boardName] != nil {
+		ls.deleteBoard(boardName)
+	}
+}
+
+// GetRank returns the entry of member, Rank of the entry is 0 if member is not on the board
+func (ls *LeaderboardService) GetRank(boardName string, member string) Entry {
+	entry := Entry{Member: member}
+	b := ls.boards[boardName]
+	if b == nil {
+		return entry
+	}
+
+	if e := b.get(member); e != nil {
+		entry.Score = e.score
+		entry.Rank = b.rank(member)
+	}
+	return entry
+}
+
+// GetTop returns the top n entries of the board
+func (ls *LeaderboardService) GetTop(boardName string, n int) []Entry {
+	b := ls.boards[boardName]
+	if b == nil {
+		return nil
+	}
+	return b.slice(0, limitQuerySize(n))
+}
+
+// GetAroundMe returns entries of n ranks above and below the member, or nil if member is not on the board
+func (ls *LeaderboardService) GetAroundMe(boardName string, member string, n int) []Entry {
+	b := ls.boards[boardName]
+	if b == nil {
+		return nil
+	}
+
+	rank := b.rank(member)
+	if rank == 0 {
+		return nil
+	}
+	n = limitQuerySize(n*2+1) / 2
+	return b.slice(rank-1-n, rank+n)
+}
+
+func limitQuerySize(n int) int {
+	if maxQuerySize > 0 && n > maxQuerySize {
+		return maxQuerySize
+	}
+	return n
+}
+
+func (ls *LeaderboardService) getBoard(boardName string) *board {
+	b := ls.boards[boardName]
+	if b == nil {
+		b = newBoard()
+		ls.boards[boardName] = b
+	}
+	return b
+}
+
+func (ls *LeaderboardService) getBoardAttr(boardName string) *entity.MapAttr {
+	boardsAttr := ls.GetMapAttr("boards")
+	if !boardsAttr.HasKey(boardName) {
+		boardsAttr.SetMapAttr(boardName, goworld.MapAttr())
+	}
+	return boardsAttr.GetMapAttr(boardName)
+}
+
+func (ls *LeaderboardService) deleteBoard(boardName string) {
+	delete(ls.boards, boardName)
+	ls.GetMapAttr("boards").Del(boardName)
+}
@@ -0,0 +1,106 @@
+package leaderboard
+
+import "sort"
+
+type boardEntry struct {
+	member string
+	score  int64
+	time   int64 // time of reaching the score, earlier entries rank higher with the same score
+}
+
+// before returns if entry e ranks higher than entry o
+func (e *boardEntry) before(o *boardEntry) bool {
+	if e.score != o.score {
+		return e.score > o.score
+	}
+	if e.time != o.time {
+		return e.time < o.time
+	}
+	return e.member < o.member
+}
+
+// board is a sorted set of members ordered by scores
+type board struct {
+	entries []*boardEntry // sorted by rank
+	index   map[string]*boardEntry
+}
+
+func newBoard() *board {
+	return &board{index: map[string]*boardEntry{}}
+}
+
+func (b *board) size() int {
+	return len(b.entries)
+}
+
+// search returns the position of the first entry which does not rank higher than e
+func (b *board) search(e *boardEntry) int {
+	return sort.Search(len(b.entries), func(i int) bool {
+		return !b.entries[i].before(e)
+	})
+}
+
+func (b *board) get(member string) *boardEntry {
+	return b.index[member]
+}
+
+// set sets the score of member, and returns the rank of member, starting from 1
+func (b *board) set(member string, score int64, time int64) int {
+	b.remove(member)
+
+	e := &boardEntry{member: member, score: score, time: time}
+	pos := b.search(e)
+	b.entries = append(b.entries, nil)
+	copy(b.entries[pos+1:], b.entries[pos:])
+	b.entries[pos] = e
+	b.index[member] = e
+	return pos + 1
+}
+
+func (b *board) remove(member string) bool {
+	e := b.index[member]
+	if e == nil {
+		return false
+	}
+
+	pos := b.search(e)
+	copy(b.entries[pos:], b.entries[pos+1:])
+	b.entries[len(b.entries)-1] = nil
+	b.entries = b.entries[:len(b.entries)-1]
+	delete(b.index, member)
+	return true
+}
+
+// last returns the entry of the lowest rank
+func (b *board) last() *boardEntry {
+	if len(b.entries) == 0 {
+		return nil
+	}
+	return b.entries[len(b.entries)-1]
+}
+
+// rank returns the rank of member starting from 1, or 0 if member is not on the board
+func (b *board) rank(member string) int {
+	e := b.index[member]
+	if e == nil {
+		return 0
+	}
+	return b.search(e) + 1
+}
+
+// slice returns entries of ranks in [start, end), starting from 0
+func (b *board) slice(start, end int) []Entry {
+	if start < 0 {
+		start = 0
+	}
+	if end > len(b.entries) {
+		end = len(b.entries)
+	}
+
+	var entries []Entry
+	for i := start; i < end; i++ {
+		e := b.entries[i]
+		entries = append(entries, Entry{Member: e.member, Score: e.score, Rank: i + 1})
+	}
+	return entries
+}
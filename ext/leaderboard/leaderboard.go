@@ -0,0 +1,94 @@
+package leaderboard
+
+import (
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+var (
+	queryTimeout = time.Second * 5
+)
+
+// SetQueryTimeout sets the timeout of queries
+func SetQueryTimeout(timeout time.Duration) {
+	queryTimeout = timeout
+}
+
+// Submit submits the score of member to the board, the score is updated only if it is higher than the current score
+func Submit(boardName string, member string, score int64) {
+	goworld.CallServiceShardKey(ServiceName, boardName, "Submit", boardName, member, score)
+}
+
+// SetScore sets the score of member on the board
+func SetScore(boardName string, member string, score int64) {
+	goworld.CallServiceShardKey(ServiceName, boardName, "SetScore", boardName, member, score)
+}
+
+// Remove removes member from the board
+func Remove(boardName string, member string) {
+	goworld.CallServiceShardKey(ServiceName, boardName, "Remove", boardName, member)
+}
+
+// ClearBoard removes all entries of the board
+func ClearBoard(boardName string) {
+	goworld.CallServiceShardKey(ServiceName, boardName, "ClearBoard", boardName)
+}
+
+// GetRank gets the entry of member on the board, Rank of the entry is 0 if member is not on the board
+func GetRank(boardName string, member string, cb func(entry Entry, err error)) {
+	query(boardName, "GetRank", []interface{}{boardName, member}, func(result interface{}, err error) {
+		var entry Entry
+		if err == nil {
+			err = decodeResult(result, &entry)
+		}
+		cb(entry, err)
+	})
+}
+
+// GetTop gets the top n entries of the board
+func GetTop(boardName string, n int, cb func(entries []Entry, err error)) {
+	query(boardName, "GetTop", []interface{}{boardName, n}, func(result interface{}, err error) {
+		var entries []Entry
+		if err == nil {
+			err = decodeResult(result, &entries)
+		}
+		cb(entries, err)
+	})
+}
+
+// GetAroundMe gets entries of n ranks above and below the member on the board
+func GetAroundMe(boardName string, member string, n int, cb func(entries []Entry, err error)) {
+	query(boardName, "GetAroundMe", []interface{}{boardName, member, n}, func(result interface{}, err error) {
+		var entries []Entry
+		if err == nil {
+			err = decodeResult(result, &entries)
+		}
+		cb(entries, err)
+	})
+}
+
+func query(boardName string, method string, args []interface{}, cb func(result interface{}, err error)) {
+	opts := goworld.ServiceRequestOptions{
+		Timeout:    queryTimeout,
+		Retries:    1,
+		Idempotent: true,
+		ShardKey:   boardName,
+	}
+	goworld.CallServiceRequest(ServiceName, method, args, opts, cb)
+}
+
+// decodeResult decodes the result of service request, which is decoded to generic types, to typed value
+func decodeResult(result interface{}, v interface{}) error {
+	if result == nil {
+		return nil
+	}
+
+	data, err := netutil.MSG_PACKER.PackMsg(result, nil)
+	if err != nil {
+		return errors.Wrap(err, "decode leaderboard result failed")
+	}
+	return errors.Wrap(netutil.MSG_PACKER.UnpackMsg(data, v), "decode leaderboard result failed")
+}
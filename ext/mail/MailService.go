@@ -0,0 +1,376 @@
+package mail
+
+import (
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/kvdb/types"
+)
+
+const (
+	mailboxPrefix    = "mailbox$"    // mailbox$<PlayerID>$<MailID>
+	globalMailPrefix = "mailglobal$" // mailglobal$<MailID>
+)
+
+// Player RPC methods called by mail service
+const (
+	playerOnMailsLoaded   = "OnMailsLoaded"
+	playerOnMailReceived  = "OnMailReceived"
+	playerOnMailClaimed   = "OnMailClaimed"
+	playerOnMailClaimDone = "OnMailClaimDone"
+	playerOnMailDeleted   = "OnMailDeleted"
+	playerOnMailError     = "OnMailError"
+)
+
+// globalMail is the mail sent to a segment of players
+type globalMail struct {
+	Segment string `msgpack:"s"`
+	Mail    Mail   `msgpack:"m"`
+}
+
+// mailboxOp is an operation on the mailbox, which calls done when it is finished
+type mailboxOp func(done func())
+
+type mailboxOps struct {
+	running bool
+	ops     []mailboxOp
+}
+
+// MailService is the service entity delivering mails to mailboxes of players
+//
+// Mails are saved in KVDB by players, so that mails can be sent to offline players. Mailboxes are distributed among
+// shards of the service by players, and operations on each mailbox are serialized, so that attachments are claimed
+// only once. Mails sent to segments are saved once, and copied to mailboxes of players when players get mails.
+//
+// Claimed attachments are saved as granting before they are granted by the player, and the claim is completed when the
+// player confirms the grant after the player is saved. Grants which are not confirmed are sent to the player again when
+// the player gets mails, and the player grants attachments of each mail only once (see Mailbox.OnMailClaimed).
+type MailService struct {
+	entity.Entity
+
+	globalMails   map[string]*globalMail // Mail ID -> Global mail
+	globalsLoaded bool
+	mailboxes     map[common.EntityID]*mailboxOps
+}
+
+// RegisterService registeres MailService to goworld, mailboxes are distributed among shards
+func RegisterService(shardCount int) {
+	goworld.RegisterServiceSharded(ServiceName, &MailService{}, shardCount)
+}
+
+func (ms *MailService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+// OnInit initialize MailService fields
+func (ms *MailService) OnInit() {
+	ms.globalMails = map[string]*globalMail{}
+	ms.mailboxes = map[common.EntityID]*mailboxOps{}
+}
+
+// OnAttrsReady loads mails sent to segments
+func (ms *MailService) OnAttrsReady() {
+	kvdb.GetRange(globalMailPrefix, prefixEnd(globalMailPrefix), func(items []kvdbtypes.KVItem, err error) {
+		if err != nil {
+			gwlog.Panicf("%s: load global mails failed: %s", ms, err)
+		}
+
+		now := time.Now()
+		for _, item := range items {
+			gm := &globalMail{}
+			if err := mailPacker.UnpackMsg([]byte(item.Val), gm); err != nil {
+				gwlog.Errorf("%s: invalid global mail %s: %s", ms, item.Key, err)
+				continue
+			}
+			if !gm.Mail.IsExpired(now) {
+				ms.globalMails[gm.Mail.ID] = gm
+			}
+		}
+
+		gwlog.Infof("%s: %d global mails loaded", ms, len(ms.globalMails))
+		ms.globalsLoaded = true
+		for player := range ms.mailboxes {
+			ms.runNext(player)
+		}
+	})
+}
+
+// runExclusive runs the operation after all previous operations on the mailbox of player are finished
+func (ms *MailService) runExclusive(player common.EntityID, op mailboxOp) {
+	mb := ms.mailboxes[player]
+	if mb == nil {
+		mb = &mailboxOps{}
+		ms.mailboxes[player] = mb
+	}
+
+	mb.ops = append(mb.ops, op)
+	if !mb.running && ms.globalsLoaded {
+		ms.runNext(player)
+	}
+}
+
+func (ms *MailService) runNext(player common.EntityID) {
+	mb := ms.mailboxes[player]
+	if len(mb.ops) == 0 {
+		delete(ms.mailboxes, player)
+		return
+	}
+
+	op := mb.ops[0]
+	mb.ops = mb.ops[1:]
+	mb.running = true
+	op(func() {
+		mb.running = false
+		ms.runNext(player)
+	})
+}
+
+// Deliver delivers the mail to the mailbox of player
+func (ms *MailService) Deliver(player common.EntityID, mail Mail) {
+	ms.runExclusive(player, func(done func()) {
+		ms.putMail(player, &mail, func(err error) {
+			if err == nil {
+				ms.Call(player, playerOnMailReceived, mail)
+			}
+			done()
+		})
+	})
+}
+
+// AddGlobalMail adds the mail sent to the segment, which is delivered to players when they get mails
+func (ms *MailService) AddGlobalMail(segment string, mail Mail) {
+	ms.globalMails[mail.ID] = &globalMail{Segment: segment, Mail: mail}
+}
+
+// GetMails sends all mails in the mailbox to the player, and delivers global mails of player segments
+func (ms *MailService) GetMails(player common.EntityID, playerSegments []string) {
+	ms.runExclusive(player, func(done func()) {
+		ms.loadMailbox(player, playerSegments, func(mails []*Mail, err error) {
+			if err != nil {
+				gwlog.Errorf("%s: load mailbox of %s failed: %s", ms, player, err)
+				ms.Call(player, playerOnMailError, "", "load mails failed")
+				done()
+				return
+			}
+
+			now := time.Now()
+			visibleMails := []Mail{}
+			for _, mail := range mails {
+				if mail.Granting {
+					// grant attachments which are claimed but not confirmed by the player, even if the mail is expired
+					ms.Call(player, playerOnMailClaimed, *mail)
+				}
+				if !mail.Deleted && !mail.IsExpired(now) {
+					visibleMails = append(visibleMails, *mail)
+				}
+			}
+			sort.Slice(visibleMails, func(i, j int) bool {
+				if visibleMails[i].SendTime != visibleMails[j].SendTime {
+					return visibleMails[i].SendTime < visibleMails[j].SendTime
+				}
+				return visibleMails[i].ID < visibleMails[j].ID
+			})
+			ms.Call(player, playerOnMailsLoaded, visibleMails)
+			done()
+		})
+	})
+}
+
+// ReadMail marks the mail as read
+func (ms *MailService) ReadMail(player common.EntityID, mailID string) {
+	ms.runExclusive(player, func(done func()) {
+		ms.updateMail(player, mailID, done, func(mail *Mail) string {
+			mail.Read = true
+			return ""
+		}, nil)
+	})
+}
+
+// ClaimMail claims attachments of the mail, attachments are granted to the player only once
+func (ms *MailService) ClaimMail(player common.EntityID, mailID string) {
+	ms.runExclusive(player, func(done func()) {
+		ms.updateMail(player, mailID, done, func(mail *Mail) string {
+			if mail.IsExpired(time.Now()) {
+				return "mail expired"
+			} else if len(mail.Attachments) == 0 {
+				return "no attachments"
+			} else if mail.Claimed || mail.Granting {
+				return "already claimed"
+			}
+
+			mail.Granting, mail.Read = true, true
+			return ""
+		}, func(mail *Mail) {
+			// attachments are granted after the claim is saved, and the player confirms the grant by ConfirmClaim
+			ms.Call(player, playerOnMailClaimed, *mail)
+		})
+	})
+}
+
+// ConfirmClaim is called by the player after attachments of the mail are granted and saved, and completes the claim
+func (ms *MailService) ConfirmClaim(player common.EntityID, mailID string) {
+	ms.runExclusive(player, func(done func()) {
+		ms.updateMail(player, mailID, done, func(mail *Mail) string {
+			if !mail.Granting {
+				return "not claiming"
+			}
+
+			mail.Granting, mail.Claimed = false, true
+			return ""
+		}, func(mail *Mail) {
+			// the grant is never sent again, so that the player can forget the grant
+			ms.Call(player, playerOnMailClaimDone, mail.ID)
+		})
+	})
+}
+
+// DeleteMail deletes the mail, mails with unclaimed attachments can not be deleted unless expired
+func (ms *MailService) DeleteMail(player common.EntityID, mailID string) {
+	ms.runExclusive(player, func(done func()) {
+		ms.updateMail(player, mailID, done, func(mail *Mail) string {
+			if mail.HasUnclaimedAttachments() && !mail.IsExpired(time.Now()) {
+				return "attachments not claimed"
+			}
+
+			mail.Deleted = true
+			return ""
+		}, func(mail *Mail) {
+			ms.Call(player, playerOnMailDeleted, mail.ID)
+		})
+	})
+}
+
+// updateMail loads the mail, updates it by update which returns the error reason if the mail can not be updated,
+// and saves the mail before calling onSaved
+func (ms *MailService) updateMail(player common.EntityID, mailID string, done func(), update func(mail *Mail) string, onSaved func(mail *Mail)) {
+	ms.getMail(player, mailID, func(mail *Mail, err error) {
+		if err != nil {
+			gwlog.Errorf("%s: load mail %s of %s failed: %s", ms, mailID, player, err)
+			ms.Call(player, playerOnMailError, mailID, "load mail failed")
+			done()
+			return
+		}
+		if mail == nil || mail.Deleted {
+			ms.Call(player, playerOnMailError, mailID, "mail not found")
+			done()
+			return
+		}
+
+		if reason := update(mail); reason != "" {
+			ms.Call(player, playerOnMailError, mailID, reason)
+			done()
+			return
+		}
+
+		ms.putMail(player, mail, func(err error) {
+			if err != nil {
+				ms.Call(player, playerOnMailError, mailID, "save mail failed")
+			} else if onSaved != nil {
+				onSaved(mail)
+			}
+			done()
+		})
+	})
+}
+
+func (ms *MailService) loadMailbox(player common.EntityID, playerSegments []string, cb func(mails []*Mail, err error)) {
+	prefix := getMailboxPrefix(player)
+	kvdb.GetRange(prefix, prefixEnd(prefix), func(items []kvdbtypes.KVItem, err error) {
+		if err != nil {
+			cb(nil, err)
+			return
+		}
+
+		var mails []*Mail
+		delivered := common.StringSet{}
+		for _, item := range items {
+			mail := &Mail{}
+			if err := mailPacker.UnpackMsg([]byte(item.Val), mail); err != nil {
+				gwlog.Errorf("%s: invalid mail %s: %s", ms, item.Key, err)
+				continue
+			}
+			mails = append(mails, mail)
+			delivered.Add(mail.ID)
+		}
+
+		// copy global mails of player segments to the mailbox
+		now := time.Now()
+		for mailID, gm := range ms.globalMails {
+			if gm.Mail.IsExpired(now) {
+				delete(ms.globalMails, mailID)
+				continue
+			}
+			if delivered.Contains(mailID) || !isInSegments(gm.Segment, playerSegments) {
+				continue
+			}
+
+			mail := gm.Mail
+			ms.putMail(player, &mail, nil)
+			mails = append(mails, &mail)
+		}
+		cb(mails, nil)
+	})
+}
+
+func isInSegments(segment string, playerSegments []string) bool {
+	for _, s := range playerSegments {
+		if s == segment {
+			return true
+		}
+	}
+	return false
+}
+
+func (ms *MailService) getMail(player common.EntityID, mailID string, cb func(mail *Mail, err error)) {
+	kvdb.Get(getMailKey(player, mailID), func(val string, err error) {
+		if err != nil || val == "" {
+			cb(nil, err)
+			return
+		}
+
+		mail := &Mail{}
+		if err := mailPacker.UnpackMsg([]byte(val), mail); err != nil {
+			cb(nil, err)
+			return
+		}
+		cb(mail, nil)
+	})
+}
+
+func (ms *MailService) putMail(player common.EntityID, mail *Mail, cb func(err error)) {
+	data, err := mailPacker.PackMsg(mail, nil)
+	if err != nil {
+		gwlog.Errorf("%s: pack mail %s of %s failed: %s", ms, mail.ID, player, err)
+		if cb != nil {
+			cb(err)
+		}
+		return
+	}
+
+	kvdb.Put(getMailKey(player, mail.ID), string(data), func(err error) {
+		if err != nil {
+			gwlog.Errorf("%s: save mail %s of %s failed: %s", ms, mail.ID, player, err)
+		}
+		if cb != nil {
+			cb(err)
+		}
+	})
+}
+
+func getMailboxPrefix(player common.EntityID) string {
+	return mailboxPrefix + string(player) + "$"
+}
+
+func getMailKey(player common.EntityID, mailID string) string {
+	return getMailboxPrefix(player) + mailID
+}
+
+// prefixEnd returns the end key of range of all keys with the prefix
+func prefixEnd(prefix string) string {
+	return strings.TrimSuffix(prefix, "$") + "%" // '%' is the next character of '$'
+}
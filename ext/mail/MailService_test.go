@@ -0,0 +1,163 @@
+package mail
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/config"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/goworldtest"
+)
+
+type testSpace struct {
+	entity.Space
+}
+
+type testPlayer struct {
+	entity.Entity
+	Mailbox
+	granted int
+	errors  []string
+}
+
+func (p *testPlayer) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+func (p *testPlayer) OnAttrsReady() {
+	p.InitMailbox(&p.Entity)
+}
+
+func (p *testPlayer) OnMailError(mailID string, reason string) {
+	p.errors = append(p.errors, reason)
+}
+
+var w *goworldtest.World
+
+func init() {
+	config.SetConfigFile("../../goworld.ini")
+	kvdb.Initialize()
+	entity.RegisterSpace(&testSpace{})
+	entity.RegisterEntity("testPlayer", &testPlayer{}, false)
+	entity.RegisterEntity(ServiceName, &MailService{}, false)
+	SetAttachmentGranter(func(player *entity.Entity, mail *Mail) {
+		player.I.(*testPlayer).granted += len(mail.Attachments)
+	})
+	w = goworldtest.Setup()
+}
+
+// wait waits for KVDB operations and handles their callbacks
+func wait() {
+	for async.WaitClear() {
+		w.Step()
+	}
+	w.Step()
+}
+
+func newService() *MailService {
+	ms := w.CreateEntity(ServiceName).I.(*MailService)
+	wait()
+	return ms
+}
+
+func newPlayerWithMail(ms *MailService, expireTime int64) (*testPlayer, string) {
+	p := w.CreateEntity("testPlayer").I.(*testPlayer)
+	mail := Mail{Title: "reward", Attachments: []Attachment{{Kind: "gold", Amount: 100}}}
+	prepareMail(&mail)
+	if expireTime != 0 {
+		mail.ExpireTime = expireTime
+	}
+	w.Call(ms.ID, "Deliver", p.ID, mail)
+	wait()
+	return p, mail.ID
+}
+
+func getMail(ms *MailService, player common.EntityID, mailID string) *Mail {
+	var mail *Mail
+	ms.getMail(player, mailID, func(_mail *Mail, err error) {
+		mail = _mail
+	})
+	wait()
+	return mail
+}
+
+// confirmClaim confirms the claim like the player, since calls to services are not delivered by goworldtest
+func confirmClaim(ms *MailService, p *testPlayer, mailID string) {
+	w.Call(ms.ID, "ConfirmClaim", p.ID, mailID)
+	wait()
+}
+
+func TestClaimOnce(t *testing.T) {
+	ms := newService()
+	p, mailID := newPlayerWithMail(ms, 0)
+
+	w.Call(ms.ID, "ClaimMail", p.ID, mailID)
+	wait()
+	if p.granted != 1 {
+		t.Fatalf("attachments should be granted once, but granted %d", p.granted)
+	}
+	if mail := getMail(ms, p.ID, mailID); !mail.Granting || mail.Claimed {
+		t.Fatalf("mail should be granting until the grant is confirmed: %+v", mail)
+	}
+	if !p.GetMapAttr(GrantedMailsAttr).HasKey(mailID) {
+		t.Fatalf("grant should be recorded by the player")
+	}
+
+	confirmClaim(ms, p, mailID)
+	if mail := getMail(ms, p.ID, mailID); mail.Granting || !mail.Claimed {
+		t.Fatalf("mail should be claimed after the grant is confirmed: %+v", mail)
+	}
+	if p.GetMapAttr(GrantedMailsAttr).HasKey(mailID) {
+		t.Errorf("grant should be forgotten by the player after the claim is done")
+	}
+
+	w.Call(ms.ID, "ClaimMail", p.ID, mailID)
+	wait()
+	if p.granted != 1 || len(p.errors) != 1 || p.errors[0] != "already claimed" {
+		t.Errorf("claimed mail should not be claimed again, granted %d, errors %v", p.granted, p.errors)
+	}
+}
+
+func TestClaimExpiredMail(t *testing.T) {
+	ms := newService()
+	p, mailID := newPlayerWithMail(ms, time.Now().Add(-time.Second).Unix())
+
+	w.Call(ms.ID, "ClaimMail", p.ID, mailID)
+	wait()
+	if p.granted != 0 || len(p.errors) != 1 || p.errors[0] != "mail expired" {
+		t.Errorf("expired mail should not be claimed, granted %d, errors %v", p.granted, p.errors)
+	}
+	if mail := getMail(ms, p.ID, mailID); mail.Granting || mail.Claimed {
+		t.Errorf("expired mail should not be claimed: %+v", mail)
+	}
+}
+
+func TestDoubleClaim(t *testing.T) {
+	ms := newService()
+	p, mailID := newPlayerWithMail(ms, 0)
+
+	w.Call(ms.ID, "ClaimMail", p.ID, mailID)
+	w.Call(ms.ID, "ClaimMail", p.ID, mailID)
+	wait()
+	if p.granted != 1 || len(p.errors) != 1 || p.errors[0] != "already claimed" {
+		t.Fatalf("mail should be claimed once, granted %d, errors %v", p.granted, p.errors)
+	}
+
+	// the grant is sent again when the player gets mails, since the confirmation is lost
+	w.Call(ms.ID, "GetMails", p.ID, []string{SegmentAll})
+	wait()
+	if p.granted != 1 {
+		t.Fatalf("attachments granted should not be granted again, but granted %d", p.granted)
+	}
+
+	confirmClaim(ms, p, mailID)
+	confirmClaim(ms, p, mailID)
+	if mail := getMail(ms, p.ID, mailID); mail.Granting || !mail.Claimed {
+		t.Errorf("mail should be claimed after the grant is confirmed: %+v", mail)
+	}
+	if p.granted != 1 {
+		t.Errorf("attachments should be granted once, but granted %d", p.granted)
+	}
+}
@@ -0,0 +1,141 @@
+package mail
+
+import (
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+// GrantedMailsAttr is the attr of players recording mails whose attachments are granted but not confirmed by MailService,
+// which should be defined as Persistent by persistent players, so that attachments are never granted twice
+const GrantedMailsAttr = "grantedMails"
+
+// Client RPC methods called by Mailbox
+const (
+	// ClientOnMails is called on clients with all mails in the mailbox: OnMails(mails)
+	ClientOnMails = "OnMails"
+	// ClientOnMailReceived is called on clients when a new mail is received: OnMailReceived(mail)
+	ClientOnMailReceived = "OnMailReceived"
+	// ClientOnMailClaimed is called on clients when attachments of the mail are granted: OnMailClaimed(mailID)
+	ClientOnMailClaimed = "OnMailClaimed"
+	// ClientOnMailDeleted is called on clients when the mail is deleted: OnMailDeleted(mailID)
+	ClientOnMailDeleted = "OnMailDeleted"
+	// ClientOnMailError is called on clients when the mail operation failed: OnMailError(mailID, reason)
+	ClientOnMailError = "OnMailError"
+)
+
+// Mailbox is the mixin of players, which should be embedded in the entity struct
+//
+// Mailbox provides client RPCs for clients to get, read, claim and delete mails, and grants claimed attachments
+// to the player by the AttachmentGranter:
+//
+//	type Avatar struct {
+//		entity.Entity
+//		mail.Mailbox
+//	}
+//
+//	func (a *Avatar) DescribeEntityType(desc *entity.EntityTypeDesc) {
+//		desc.SetPersistent(true)
+//		desc.DefineAttr(mail.GrantedMailsAttr, "Persistent")
+//	}
+//
+//	func (a *Avatar) OnAttrsReady() {
+//		a.InitMailbox(&a.Entity)
+//	}
+type Mailbox struct {
+	owner *entity.Entity
+}
+
+// InitMailbox initialize the mailbox with the owner entity
+//
+// InitMailbox should be called in OnAttrsReady, so that the mailbox is initialized after the entity is migrated or restored
+func (mb *Mailbox) InitMailbox(owner *entity.Entity) {
+	mb.owner = owner
+}
+
+func (mb *Mailbox) callMailService(method string, args ...interface{}) {
+	args = append([]interface{}{mb.owner.ID}, args...)
+	goworld.CallServiceShardKey(ServiceName, string(mb.owner.ID), method, args...)
+}
+
+// GetMails_Client is called by clients to get all mails
+func (mb *Mailbox) GetMails_Client() {
+	mb.callMailService("GetMails", getPlayerSegments(mb.owner))
+}
+
+// ReadMail_Client is called by clients to mark the mail as read
+func (mb *Mailbox) ReadMail_Client(mailID string) {
+	mb.callMailService("ReadMail", mailID)
+}
+
+// ClaimMail_Client is called by clients to claim attachments of the mail
+func (mb *Mailbox) ClaimMail_Client(mailID string) {
+	mb.callMailService("ClaimMail", mailID)
+}
+
+// DeleteMail_Client is called by clients to delete the mail
+func (mb *Mailbox) DeleteMail_Client(mailID string) {
+	mb.callMailService("DeleteMail", mailID)
+}
+
+// OnMailsLoaded is called by MailService with all mails in the mailbox
+func (mb *Mailbox) OnMailsLoaded(mails []Mail) {
+	mb.owner.CallClient(ClientOnMails, mails)
+}
+
+// OnMailReceived is called by MailService when a new mail is delivered
+func (mb *Mailbox) OnMailReceived(mail Mail) {
+	mb.owner.CallClient(ClientOnMailReceived, mail)
+}
+
+// OnMailClaimed is called by MailService when the claim of attachments is saved, and grants attachments to the player
+//
+// The grant is recorded in GrantedMailsAttr and confirmed to MailService after the player is saved, so that attachments
+// are granted only once even if MailService sends the grant again.
+func (mb *Mailbox) OnMailClaimed(mail Mail) {
+	if granter == nil {
+		gwlog.Errorf("%s: attachments of mail %s are claimed, but attachment granter is not set", mb.owner, mail.ID)
+		return
+	}
+
+	grantedMails := mb.getGrantedMails()
+	if !grantedMails.HasKey(mail.ID) {
+		gwlog.Infof("%s: granting attachments of mail %s: %v", mb.owner, mail.ID, mail.Attachments)
+		gwutils.RunPanicless(func() {
+			granter(mb.owner, &mail)
+		})
+		grantedMails.SetInt(mail.ID, 1)
+		mb.owner.CallClient(ClientOnMailClaimed, mail.ID)
+	}
+
+	if mb.owner.IsPersistent() {
+		mb.owner.SaveWithCallback(func() {
+			mb.callMailService("ConfirmClaim", mail.ID)
+		})
+	} else {
+		mb.callMailService("ConfirmClaim", mail.ID)
+	}
+}
+
+// OnMailClaimDone is called by MailService when the grant is confirmed, so that the grant is no longer recorded
+func (mb *Mailbox) OnMailClaimDone(mailID string) {
+	mb.getGrantedMails().Del(mailID)
+}
+
+func (mb *Mailbox) getGrantedMails() *entity.MapAttr {
+	if !mb.owner.Attrs.HasKey(GrantedMailsAttr) {
+		mb.owner.Attrs.SetMapAttr(GrantedMailsAttr, goworld.MapAttr())
+	}
+	return mb.owner.GetMapAttr(GrantedMailsAttr)
+}
+
+// OnMailDeleted is called by MailService when the mail is deleted
+func (mb *Mailbox) OnMailDeleted(mailID string) {
+	mb.owner.CallClient(ClientOnMailDeleted, mailID)
+}
+
+// OnMailError is called by MailService when the mail operation failed
+func (mb *Mailbox) OnMailError(mailID string, reason string) {
+	mb.owner.CallClient(ClientOnMailError, mailID, reason)
+}
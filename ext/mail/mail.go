@@ -0,0 +1,149 @@
+package mail
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/uuid"
+)
+
+const (
+	// ServiceName is the service name of mail service
+	ServiceName = "MailService"
+
+	// SegmentAll is the segment of all players
+	SegmentAll = "all"
+)
+
+// Attachment is an item or currency attached to mail
+type Attachment struct {
+	Kind   string `msgpack:"k"` // e.g. item, gold
+	ID     string `msgpack:"i"` // e.g. item ID, empty for currencies
+	Amount int64  `msgpack:"a"`
+}
+
+// Mail is a mail in the mailbox of player
+type Mail struct {
+	ID          string          `msgpack:"id"`
+	Sender      common.EntityID `msgpack:"sid"` // empty for system mails
+	SenderName  string          `msgpack:"sn"`
+	Title       string          `msgpack:"t"`
+	Content     string          `msgpack:"c"`
+	Attachments []Attachment    `msgpack:"at"`
+	SendTime    int64           `msgpack:"st"`
+	ExpireTime  int64           `msgpack:"et"` // mail is removed from the mailbox after expire time, 0 for never
+	Read        bool            `msgpack:"r"`
+	Claimed     bool            `msgpack:"cl"` // attachments are claimed
+	Granting    bool            `msgpack:"g"`  // attachments are being granted, until the player confirms the grant
+	Deleted     bool            `msgpack:"d"`
+}
+
+// IsExpired returns if the mail is expired at the time
+func (m *Mail) IsExpired(now time.Time) bool {
+	return m.ExpireTime > 0 && now.Unix() >= m.ExpireTime
+}
+
+// HasUnclaimedAttachments returns if the mail has attachments which are not claimed
+func (m *Mail) HasUnclaimedAttachments() bool {
+	return len(m.Attachments) > 0 && !m.Claimed
+}
+
+// AttachmentGranter grants claimed attachments of mail to the player, it is called on the game of player
+type AttachmentGranter func(player *entity.Entity, mail *Mail)
+
+// Segment checks if the player is in the segment, it is called on the game of player
+type Segment func(player *entity.Entity) bool
+
+var (
+	mailTTL    = time.Hour * 24 * 30
+	granter    AttachmentGranter
+	segments   = map[string]Segment{}
+	mailPacker = netutil.MSG_PACKER
+)
+
+// SetMailTTL sets the default time before mails are expired, mails never expire if ttl is 0
+func SetMailTTL(ttl time.Duration) {
+	mailTTL = ttl
+}
+
+// SetAttachmentGranter sets the granter of attachments, which should be called on all games before goworld.Run
+func SetAttachmentGranter(g AttachmentGranter) {
+	granter = g
+}
+
+// RegisterSegment registers the segment of players for bulk sending, which should be called on all games before goworld.Run
+func RegisterSegment(name string, segment Segment) {
+	if name == SegmentAll {
+		gwlog.Panicf("mail segment %s is reserved", name)
+	}
+	segments[name] = segment
+}
+
+// getPlayerSegments returns segments of the player
+func getPlayerSegments(player *entity.Entity) []string {
+	playerSegments := []string{SegmentAll}
+	for name, segment := range segments {
+		if segment(player) {
+			playerSegments = append(playerSegments, name)
+		}
+	}
+	return playerSegments
+}
+
+// prepareMail fills ID, time and expire time of the new mail
+func prepareMail(mail *Mail) {
+	now := time.Now()
+	mail.ID = uuid.GenUUID()
+	mail.SendTime = now.Unix()
+	if mail.ExpireTime == 0 && mailTTL > 0 {
+		mail.ExpireTime = now.Add(mailTTL).Unix()
+	}
+	mail.Read, mail.Claimed, mail.Granting, mail.Deleted = false, false, false, false
+}
+
+// Send sends the mail to the player, the player receives the mail when online
+func Send(target common.EntityID, mail Mail) {
+	prepareMail(&mail)
+	goworld.CallServiceShardKey(ServiceName, string(target), "Deliver", target, mail)
+}
+
+// SendToPlayers sends the mail to each of players
+func SendToPlayers(targets []common.EntityID, mail Mail) {
+	prepareMail(&mail)
+	for _, target := range targets {
+		goworld.CallServiceShardKey(ServiceName, string(target), "Deliver", target, mail)
+	}
+}
+
+// SendToSegment sends the mail to all players in the segment, including offline players
+//
+// The mail is delivered to players when they get mails.
+func SendToSegment(segment string, mail Mail) {
+	if segment != SegmentAll && segments[segment] == nil {
+		gwlog.Errorf("mail: send to unknown segment %s", segment)
+		return
+	}
+
+	prepareMail(&mail)
+	data, err := mailPacker.PackMsg(globalMail{Segment: segment, Mail: mail}, nil)
+	if err != nil {
+		gwlog.Errorf("mail: pack mail to segment %s failed: %s", segment, err)
+		return
+	}
+
+	kvdb.Put(globalMailPrefix+mail.ID, string(data), func(err error) {
+		if err != nil {
+			gwlog.Errorf("mail: save mail to segment %s failed: %s", segment, err)
+			return
+		}
+
+		for i := 0; i < goworld.GetServiceShardCount(ServiceName); i++ {
+			goworld.CallServiceShardIndex(ServiceName, i, "AddGlobalMail", segment, mail)
+		}
+	})
+}
@@ -0,0 +1,74 @@
+package matchmaking
+
+import (
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+)
+
+// Client RPC methods called by MatchPlayer
+const (
+	// ClientOnMatchFound is called on clients when the match is found, before entering the match space: OnMatchFound(queue)
+	ClientOnMatchFound = "OnMatchFound"
+	// ClientOnMatchTimeout is called on clients when the player waits in the queue for too long: OnMatchTimeout(queue)
+	ClientOnMatchTimeout = "OnMatchTimeout"
+	// ClientOnMatchCanceled is called on clients when the player is removed from the queue: OnMatchCanceled(queue)
+	ClientOnMatchCanceled = "OnMatchCanceled"
+)
+
+// MatchPlayer is the mixin of players, which should be embedded in the entity struct
+//
+// MatchPlayer enters the match space when the match is found, and notifies the client of matchmaking results:
+//
+//	type Avatar struct {
+//		entity.Entity
+//		matchmaking.MatchPlayer
+//	}
+//
+//	func (a *Avatar) OnAttrsReady() {
+//		a.InitMatchPlayer(&a.Entity)
+//	}
+//
+//	func (a *Avatar) OnDestroy() {
+//		a.CancelMatch()
+//	}
+type MatchPlayer struct {
+	owner *entity.Entity
+}
+
+// InitMatchPlayer initialize the match player with the owner entity
+//
+// InitMatchPlayer should be called in OnAttrsReady, so that the match player is initialized after the entity is migrated or restored
+func (mp *MatchPlayer) InitMatchPlayer(owner *entity.Entity) {
+	mp.owner = owner
+}
+
+// EnqueueMatch puts the player into the queue with attributes for matching
+func (mp *MatchPlayer) EnqueueMatch(queue string, attrs map[string]interface{}) {
+	Enqueue(queue, mp.owner.ID, attrs)
+}
+
+// CancelMatch removes the player from the queue, which should be called when the player is destroyed
+func (mp *MatchPlayer) CancelMatch() {
+	Cancel(mp.owner.ID)
+}
+
+// CancelMatch_Client is called by clients to cancel matchmaking
+func (mp *MatchPlayer) CancelMatch_Client() {
+	mp.CancelMatch()
+}
+
+// OnMatchFound is called by MatchmakingService when the match space is ready
+func (mp *MatchPlayer) OnMatchFound(queue string, spaceID common.EntityID) {
+	mp.owner.CallClient(ClientOnMatchFound, queue)
+	mp.owner.EnterSpace(spaceID, entity.Vector3{})
+}
+
+// OnMatchTimeout is called by MatchmakingService when the player waits in the queue for too long
+func (mp *MatchPlayer) OnMatchTimeout(queue string) {
+	mp.owner.CallClient(ClientOnMatchTimeout, queue)
+}
+
+// OnMatchCanceled is called by MatchmakingService when the player is removed from the queue
+func (mp *MatchPlayer) OnMatchCanceled(queue string) {
+	mp.owner.CallClient(ClientOnMatchCanceled, queue)
+}
@@ -0,0 +1,271 @@
+package matchmaking
+
+import (
+	"sort"
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwutils"
+)
+
+const (
+	tickInterval = time.Second
+)
+
+// Player RPC methods called by matchmaking service
+const (
+	// PlayerOnMatchFound is called on players when the match space is ready: OnMatchFound(queue, spaceID)
+	PlayerOnMatchFound = "OnMatchFound"
+	// PlayerOnMatchTimeout is called on players when the player waits in the queue for too long: OnMatchTimeout(queue)
+	PlayerOnMatchTimeout = "OnMatchTimeout"
+	// PlayerOnMatchCanceled is called on players when the player is removed from the queue: OnMatchCanceled(queue)
+	PlayerOnMatchCanceled = "OnMatchCanceled"
+)
+
+type pendingMatch struct {
+	queue    string
+	spaceID  common.EntityID
+	tickets  []*Ticket
+	deadline time.Time
+}
+
+// MatchmakingService is the service entity matching players in queues
+//
+// Matched players wait for the match space to be created, and are teleported to the space once NotifySpaceReady is
+// called by the space. If the space is not ready in time, it is destroyed and players are put back to the queue.
+// Queues are kept in memory, since players enqueue again after they login.
+type MatchmakingService struct {
+	entity.Entity
+
+	waiting        map[string][]*Ticket              // Queue -> Tickets sorted by enqueue time
+	playerQueues   map[common.EntityID]string        // Player -> Queue of waiting players
+	pendingMatches map[common.EntityID]*pendingMatch // Space ID -> Match waiting for the space
+	matchedPlayers map[common.EntityID]common.EntityID
+	expiredSpaces  map[common.EntityID]time.Time // Space ID -> Time to forget the space of the requeued match
+}
+
+// RegisterService registeres MatchmakingService to goworld
+func RegisterService() {
+	goworld.RegisterService(ServiceName, &MatchmakingService{})
+}
+
+func (ms *MatchmakingService) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+// OnInit initialize MatchmakingService fields
+func (ms *MatchmakingService) OnInit() {
+	ms.waiting = map[string][]*Ticket{}
+	ms.playerQueues = map[common.EntityID]string{}
+	ms.pendingMatches = map[common.EntityID]*pendingMatch{}
+	ms.matchedPlayers = map[common.EntityID]common.EntityID{}
+	ms.expiredSpaces = map[common.EntityID]time.Time{}
+}
+
+// OnCreated is called when MatchmakingService is created
+func (ms *MatchmakingService) OnCreated() {
+	ms.AddTimer(tickInterval, "Tick")
+}
+
+// Enqueue puts the player into the queue
+func (ms *MatchmakingService) Enqueue(queue string, playerID common.EntityID, attrs map[string]interface{}) {
+	if queues[queue] == nil {
+		gwlog.Errorf("%s: %s enqueue to unknown queue %s", ms, playerID, queue)
+		return
+	}
+
+	ms.remove(playerID)
+	ms.waiting[queue] = append(ms.waiting[queue], &Ticket{
+		PlayerID:    playerID,
+		Attrs:       attrs,
+		EnqueueTime: goworld.Now(),
+	})
+	ms.playerQueues[playerID] = queue
+}
+
+// Cancel removes the player from the queue or the match waiting for space creation
+func (ms *MatchmakingService) Cancel(playerID common.EntityID) {
+	if queue, ok := ms.remove(playerID); ok {
+		ms.Call(playerID, PlayerOnMatchCanceled, queue)
+	}
+}
+
+func (ms *MatchmakingService) remove(playerID common.EntityID) (string, bool) {
+	if queue, ok := ms.playerQueues[playerID]; ok {
+		delete(ms.playerQueues, playerID)
+		ms.waiting[queue] = removeTicket(ms.waiting[queue], playerID)
+		return queue, true
+	}
+
+	if spaceID, ok := ms.matchedPlayers[playerID]; ok {
+		delete(ms.matchedPlayers, playerID)
+		match := ms.pendingMatches[spaceID]
+		match.tickets = removeTicket(match.tickets, playerID)
+		return match.queue, true
+	}
+	return "", false
+}
+
+func removeTicket(tickets []*Ticket, playerID common.EntityID) []*Ticket {
+	for i, ticket := range tickets {
+		if ticket.PlayerID == playerID {
+			return append(tickets[:i], tickets[i+1:]...)
+		}
+	}
+	return tickets
+}
+
+// Tick matches players in queues, and checks timeouts
+func (ms *MatchmakingService) Tick() {
+	now := goworld.Now()
+	for queue, tickets := range ms.waiting {
+		config := queues[queue]
+		if config.Timeout > 0 {
+			tickets = ms.removeTimeoutTickets(queue, tickets, now.Add(-config.Timeout))
+		}
+		ms.waiting[queue] = ms.match(queue, config, tickets, now)
+	}
+
+	for spaceID, match := range ms.pendingMatches {
+		if now.After(match.deadline) {
+			gwlog.Warnf("%s: space %s of match in queue %s is not ready, put players back to queue", ms, spaceID, match.queue)
+			delete(ms.pendingMatches, spaceID)
+			ms.requeue(match, now)
+		}
+	}
+
+	for spaceID, forgetTime := range ms.expiredSpaces {
+		if now.After(forgetTime) {
+			delete(ms.expiredSpaces, spaceID)
+		}
+	}
+}
+
+func (ms *MatchmakingService) removeTimeoutTickets(queue string, tickets []*Ticket, enqueueBefore time.Time) []*Ticket {
+	var n int
+	for _, ticket := range tickets {
+		if ticket.EnqueueTime.Before(enqueueBefore) {
+			delete(ms.playerQueues, ticket.PlayerID)
+			ms.Call(ticket.PlayerID, PlayerOnMatchTimeout, queue)
+		} else {
+			tickets[n] = ticket
+			n += 1
+		}
+	}
+	return tickets[:n]
+}
+
+// match groups tickets by the matcher, and creates match spaces, it returns tickets which are not matched
+func (ms *MatchmakingService) match(queue string, config *QueueConfig, tickets []*Ticket, now time.Time) []*Ticket {
+	if len(tickets) == 0 {
+		return tickets
+	}
+
+	var matches [][]*Ticket
+	if err := gwutils.CatchPanic(func() {
+		matches = config.Matcher(queue, append([]*Ticket(nil), tickets...))
+	}); err != nil {
+		gwlog.TraceError("%s: matcher of queue %s paniced: %v", ms, queue, err)
+		return tickets
+	}
+
+	matched := map[common.EntityID]bool{}
+	for _, group := range matches {
+		if !ms.isValidGroup(queue, group, matched) {
+			gwlog.Errorf("%s: matcher of queue %s returns invalid match: %v", ms, queue, group)
+			continue
+		}
+
+		spaceID := goworld.CreateSpaceAnywhere(config.SpaceKind)
+		ms.pendingMatches[spaceID] = &pendingMatch{
+			queue:    queue,
+			spaceID:  spaceID,
+			tickets:  group,
+			deadline: now.Add(spaceReadyTimeout),
+		}
+		for _, ticket := range group {
+			matched[ticket.PlayerID] = true
+			delete(ms.playerQueues, ticket.PlayerID)
+			ms.matchedPlayers[ticket.PlayerID] = spaceID
+		}
+	}
+
+	if len(matched) == 0 {
+		return tickets
+	}
+
+	var n int
+	for _, ticket := range tickets {
+		if !matched[ticket.PlayerID] {
+			tickets[n] = ticket
+			n += 1
+		}
+	}
+	return tickets[:n]
+}
+
+// isValidGroup checks if all tickets of the group are waiting in the queue, and not matched yet
+func (ms *MatchmakingService) isValidGroup(queue string, group []*Ticket, matched map[common.EntityID]bool) bool {
+	if len(group) == 0 {
+		return false
+	}
+
+	for i, ticket := range group {
+		if ticket == nil || matched[ticket.PlayerID] || ms.playerQueues[ticket.PlayerID] != queue {
+			return false
+		}
+		for _, other := range group[:i] {
+			if other.PlayerID == ticket.PlayerID {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// requeue puts players of the match back to the queue with their original enqueue time, and destroys the space of the match
+func (ms *MatchmakingService) requeue(match *pendingMatch, now time.Time) {
+	tickets := ms.waiting[match.queue]
+	for _, ticket := range match.tickets {
+		delete(ms.matchedPlayers, ticket.PlayerID)
+		ms.playerQueues[ticket.PlayerID] = match.queue
+		tickets = append(tickets, ticket)
+	}
+
+	sort.SliceStable(tickets, func(i, j int) bool {
+		return tickets[i].EnqueueTime.Before(tickets[j].EnqueueTime)
+	})
+	ms.waiting[match.queue] = tickets
+	ms.destroySpace(match.spaceID, now)
+}
+
+// destroySpace destroys the space of the requeued match, the space is also destroyed if it gets ready later, since the
+// destroy call is dropped if the space is not created yet
+func (ms *MatchmakingService) destroySpace(spaceID common.EntityID, now time.Time) {
+	ms.Call(spaceID, "Destroy")
+	ms.expiredSpaces[spaceID] = now.Add(spaceReadyTimeout)
+}
+
+// OnSpaceReady is called by NotifySpaceReady when the space is created, and teleports players of the match to the space
+func (ms *MatchmakingService) OnSpaceReady(spaceID common.EntityID) {
+	if _, ok := ms.expiredSpaces[spaceID]; ok {
+		gwlog.Warnf("%s: space %s is ready after players are put back to queue, destroy it", ms, spaceID)
+		delete(ms.expiredSpaces, spaceID)
+		ms.Call(spaceID, "Destroy")
+		return
+	}
+
+	match := ms.pendingMatches[spaceID]
+	if match == nil {
+		return
+	}
+
+	delete(ms.pendingMatches, spaceID)
+	gwlog.Infof("%s: match in queue %s is ready in space %s, players: %d", ms, match.queue, spaceID, len(match.tickets))
+	for _, ticket := range match.tickets {
+		delete(ms.matchedPlayers, ticket.PlayerID)
+		ms.Call(ticket.PlayerID, PlayerOnMatchFound, match.queue, spaceID)
+	}
+}
@@ -0,0 +1,186 @@
+package matchmaking
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/goworldtest"
+)
+
+const testSpaceKind = 1
+
+type testSpace struct {
+	entity.Space
+}
+
+type testPlayer struct {
+	entity.Entity
+	matchedSpace common.EntityID
+	timeouts     int
+	canceled     int
+}
+
+func (p *testPlayer) DescribeEntityType(desc *entity.EntityTypeDesc) {
+}
+
+func (p *testPlayer) OnMatchFound(queue string, spaceID common.EntityID) {
+	p.matchedSpace = spaceID
+}
+
+func (p *testPlayer) OnMatchTimeout(queue string) {
+	p.timeouts++
+}
+
+func (p *testPlayer) OnMatchCanceled(queue string) {
+	p.canceled++
+}
+
+var w *goworldtest.World
+
+func init() {
+	entity.RegisterSpace(&testSpace{})
+	entity.RegisterEntity("testPlayer", &testPlayer{}, false)
+	entity.RegisterEntity(ServiceName, &MatchmakingService{}, false)
+	RegisterQueue("duel", QueueConfig{SpaceKind: testSpaceKind, Matcher: FixedSizeMatcher(2)})
+	RegisterQueue("trio", QueueConfig{SpaceKind: testSpaceKind, Matcher: FixedSizeMatcher(3), Timeout: time.Second * 5})
+	w = goworldtest.Setup()
+}
+
+func newService() *MatchmakingService {
+	return w.CreateEntity(ServiceName).I.(*MatchmakingService)
+}
+
+func enqueuePlayers(ms *MatchmakingService, queue string, n int) []*testPlayer {
+	var players []*testPlayer
+	for i := 0; i < n; i++ {
+		p := w.CreateEntity("testPlayer").I.(*testPlayer)
+		w.Call(ms.ID, "Enqueue", queue, p.ID, map[string]interface{}(nil))
+		w.Advance(goworldtest.TickInterval) // players are enqueued in order
+		players = append(players, p)
+	}
+	return players
+}
+
+func waitingPlayers(ms *MatchmakingService, queue string) []common.EntityID {
+	var ids []common.EntityID
+	for _, ticket := range ms.waiting[queue] {
+		ids = append(ids, ticket.PlayerID)
+	}
+	return ids
+}
+
+func TestMatchGrouping(t *testing.T) {
+	ms := newService()
+	players := enqueuePlayers(ms, "duel", 5)
+	w.Advance(tickInterval)
+
+	if len(ms.pendingMatches) != 2 {
+		t.Fatalf("players should be grouped into 2 matches, but got %d", len(ms.pendingMatches))
+	}
+	if waiting := waitingPlayers(ms, "duel"); len(waiting) != 1 || waiting[0] != players[4].ID {
+		t.Fatalf("the last player should be waiting in the queue, but got %v", waiting)
+	}
+
+	for spaceID := range ms.pendingMatches {
+		if entity.GetEntity(spaceID) == nil {
+			t.Fatalf("space %s of the match should be created", spaceID)
+		}
+		w.Call(ms.ID, "OnSpaceReady", spaceID)
+	}
+	if len(ms.pendingMatches) != 0 || len(ms.matchedPlayers) != 0 {
+		t.Fatalf("matches should be done when spaces are ready")
+	}
+	for i := 0; i < 4; i += 2 {
+		if players[i].matchedSpace.IsNil() || players[i].matchedSpace != players[i+1].matchedSpace {
+			t.Errorf("player %d and %d should be matched in the same space by the enqueue order", i, i+1)
+		}
+	}
+	if players[0].matchedSpace == players[2].matchedSpace {
+		t.Errorf("matches should be in different spaces")
+	}
+	if !players[4].matchedSpace.IsNil() {
+		t.Errorf("the last player should not be matched")
+	}
+}
+
+func TestMatchTimeout(t *testing.T) {
+	ms := newService()
+	players := enqueuePlayers(ms, "trio", 2)
+	w.Advance(time.Second * 4)
+	if players[0].timeouts != 0 {
+		t.Fatalf("players should not time out before the timeout")
+	}
+
+	w.Advance(time.Second * 2)
+	for i, p := range players {
+		if p.timeouts != 1 {
+			t.Errorf("player %d should time out once, but got %d", i, p.timeouts)
+		}
+	}
+	if len(ms.waiting["trio"]) != 0 || len(ms.playerQueues) != 0 {
+		t.Errorf("timed out players should be removed from the queue")
+	}
+}
+
+func TestCancel(t *testing.T) {
+	ms := newService()
+	players := enqueuePlayers(ms, "trio", 2)
+	w.Call(ms.ID, "Cancel", players[0].ID)
+	w.Call(ms.ID, "Cancel", players[0].ID)
+	if players[0].canceled != 1 {
+		t.Errorf("player should be canceled once, but got %d", players[0].canceled)
+	}
+	if waiting := waitingPlayers(ms, "trio"); len(waiting) != 1 || waiting[0] != players[1].ID {
+		t.Errorf("only the other player should be waiting, but got %v", waiting)
+	}
+}
+
+func TestRequeue(t *testing.T) {
+	SetSpaceReadyTimeout(time.Second * 3)
+	defer SetSpaceReadyTimeout(time.Second * 30)
+
+	ms := newService()
+	players := enqueuePlayers(ms, "duel", 2)
+	w.Advance(tickInterval)
+	if len(ms.pendingMatches) != 1 {
+		t.Fatalf("players should be matched, but got %d matches", len(ms.pendingMatches))
+	}
+	var spaceID common.EntityID
+	for spaceID = range ms.pendingMatches {
+	}
+
+	// the match is still waiting for the space when the third player is enqueued
+	players = append(players, enqueuePlayers(ms, "duel", 1)...)
+	w.Advance(spaceReadyTimeout + tickInterval*2)
+	if _, ok := ms.pendingMatches[spaceID]; ok {
+		t.Fatalf("match should be requeued when the space is not ready in time")
+	}
+	if entity.GetEntity(spaceID) != nil {
+		t.Errorf("space of the requeued match should be destroyed")
+	}
+
+	// requeued players are matched again before the third player by their original enqueue time
+	if len(ms.pendingMatches) != 1 {
+		t.Fatalf("requeued players should be matched again, but got %d matches", len(ms.pendingMatches))
+	}
+	for newSpaceID, match := range ms.pendingMatches {
+		if match.tickets[0].PlayerID != players[0].ID || match.tickets[1].PlayerID != players[1].ID {
+			t.Errorf("requeued players should be matched in the original order")
+		}
+		w.Call(ms.ID, "OnSpaceReady", newSpaceID)
+	}
+	if waiting := waitingPlayers(ms, "duel"); len(waiting) != 1 || waiting[0] != players[2].ID {
+		t.Errorf("the third player should be waiting in the queue, but got %v", waiting)
+	}
+
+	// the space of the requeued match is destroyed if it gets ready later
+	space := entity.CreateSpaceLocally(testSpaceKind)
+	w.Step()
+	ms.expiredSpaces[space.ID] = w.Now().Add(time.Minute)
+	w.Call(ms.ID, "OnSpaceReady", space.ID)
+	if entity.GetEntity(space.ID) != nil {
+		t.Errorf("space which gets ready after the match is requeued should be destroyed")
+	}
+}
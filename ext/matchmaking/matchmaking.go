@@ -0,0 +1,90 @@
+package matchmaking
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+)
+
+const (
+	// ServiceName is the service name of matchmaking service
+	ServiceName = "MatchmakingService"
+)
+
+// Ticket is a player waiting in the queue
+type Ticket struct {
+	PlayerID    common.EntityID
+	Attrs       map[string]interface{} // attributes of the player for matching, e.g. level, region
+	EnqueueTime time.Time
+}
+
+// Matcher groups tickets waiting in the queue into matches
+//
+// Tickets are sorted by enqueue time. Matcher returns groups of tickets for new matches,
+// and tickets which are not grouped stay in the queue.
+type Matcher func(queue string, tickets []*Ticket) [][]*Ticket
+
+// QueueConfig is the config of matchmaking queue
+type QueueConfig struct {
+	SpaceKind int           // kind of spaces created for matches
+	Matcher   Matcher       // matcher of the queue
+	Timeout   time.Duration // max time of waiting in the queue, 0 for no timeout
+}
+
+var (
+	queues            = map[string]*QueueConfig{}
+	spaceKinds        = map[int]bool{}
+	spaceReadyTimeout = time.Second * 30
+)
+
+// RegisterQueue registers the matchmaking queue, which should be called on all games before goworld.Run
+func RegisterQueue(name string, config QueueConfig) {
+	if config.SpaceKind == 0 {
+		gwlog.Panicf("matchmaking queue %s: can not create nil spaces for matches", name)
+	}
+	if config.Matcher == nil {
+		gwlog.Panicf("matchmaking queue %s: matcher is nil", name)
+	}
+
+	queues[name] = &config
+	spaceKinds[config.SpaceKind] = true
+}
+
+// SetSpaceReadyTimeout sets the max time of waiting for match spaces to be created, players are put back to queue on timeout
+func SetSpaceReadyTimeout(timeout time.Duration) {
+	spaceReadyTimeout = timeout
+}
+
+// NotifySpaceReady should be called in OnSpaceCreated of spaces, so that players of the match are teleported to the space
+//
+// It is ignored if the space is not of any matchmaking queue.
+func NotifySpaceReady(space *entity.Space) {
+	if spaceKinds[space.Kind] {
+		goworld.CallService(ServiceName, "OnSpaceReady", space.ID)
+	}
+}
+
+// FixedSizeMatcher matches every size tickets in the queue by the enqueue order
+func FixedSizeMatcher(size int) Matcher {
+	return func(queue string, tickets []*Ticket) [][]*Ticket {
+		var matches [][]*Ticket
+		for len(tickets) >= size {
+			matches = append(matches, tickets[:size])
+			tickets = tickets[size:]
+		}
+		return matches
+	}
+}
+
+// Enqueue puts the player into the queue, the player is removed from the previous queue if any
+func Enqueue(queue string, playerID common.EntityID, attrs map[string]interface{}) {
+	goworld.CallService(ServiceName, "Enqueue", queue, playerID, attrs)
+}
+
+// Cancel removes the player from the queue or the match waiting for space creation
+func Cancel(playerID common.EntityID) {
+	goworld.CallService(ServiceName, "Cancel", playerID)
+}
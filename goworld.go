@@ -1,17 +1,32 @@
 package goworld
 
 import (
+	"context"
+	"fmt"
+	"math/rand"
+	"sync"
 	"time"
 
-	"github.com/xiaonanln/goTimer"
 	"github.com/xiaonanln/goworld/components/game"
+	"github.com/xiaonanln/goworld/engine/async"
+	"github.com/xiaonanln/goworld/engine/banlist"
 	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/consts"
 	"github.com/xiaonanln/goworld/engine/crontab"
 	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/eventbus"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwtimer"
 	"github.com/xiaonanln/goworld/engine/kvdb"
+	"github.com/xiaonanln/goworld/engine/luascript"
+	"github.com/xiaonanln/goworld/engine/opmon"
 	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/rbac"
 	"github.com/xiaonanln/goworld/engine/service"
+	"github.com/xiaonanln/goworld/engine/simulation"
 	"github.com/xiaonanln/goworld/engine/storage"
+	"github.com/xiaonanln/goworld/engine/uuid"
+	"github.com/xiaonanln/goworld/engine/watchdog"
 )
 
 // Export useful types
@@ -27,6 +42,43 @@ type Space = entity.Space
 // EntityID is unique in the whole game server, and also unique across multiple games.
 type EntityID = common.EntityID
 
+// FrameOverrunInfo describes the game frame exceeding frame_budget_ms
+type FrameOverrunInfo = game.FrameOverrunInfo
+
+// WatchdogAlert is the change of alert level of goroutines, heap size or main loop stall monitored by watchdog
+type WatchdogAlert = watchdog.Alert
+
+// AuditRecord is the authorization decision of the action done by the user in [rbac] config
+type AuditRecord = rbac.AuditRecord
+
+// EventSubscription is the handler subscribed to events by OnEvent
+type EventSubscription = eventbus.Subscription
+
+// GMHandler executes the GM command on the target entity with arguments, and returns the output shown to the GM
+type GMHandler = entity.GMHandler
+
+// BanInfo is the ban of the client IP or CIDR, account or device enforced by gates
+type BanInfo = banlist.Ban
+
+// Kinds of bans
+const (
+	BanByIP      = banlist.KindIP      // client IPs, or CIDRs like 10.0.0.0/8
+	BanByAccount = banlist.KindAccount // auth IDs of clients authenticated by gates
+	BanByDevice  = banlist.KindDevice  // device IDs sent by clients
+)
+
+// Timer is the handle of callbacks and timers added by AddCallbackHandle and AddTimerHandle
+type Timer = gwtimer.Timer
+
+// Cron is the handle of callbacks added by AddCron
+type Cron = crontab.Cron
+
+// EntityTimer is the handle of entity callbacks and timers added by Entity.AddCallbackHandle and Entity.AddTimerHandle
+type EntityTimer = entity.EntityTimer
+
+// ServiceInfo describes a service shard, including its hosting game, entity ID and health
+type ServiceInfo = service.ServiceInfo
+
 // Run runs the server endless loop
 //
 // This is the main routine for the server and all entity logic,
@@ -35,6 +87,26 @@ func Run() {
 	game.Run()
 }
 
+// SetEntityIDGenerator installs the generator of IDs of entities created on this game, see common.SetEntityIDGenerator
+//
+// It should be called before Run. IDs of boot entities are generated by gates with the default generator.
+func SetEntityIDGenerator(gen func() EntityID) {
+	common.SetEntityIDGenerator(gen)
+}
+
+// SnowflakeEntityIDs returns the generator of time-ordered entity IDs embedding the game ID, which improves locality of
+// storage indexes and shows creation time of entities, e.g. goworld.SetEntityIDGenerator(goworld.SnowflakeEntityIDs())
+func SnowflakeEntityIDs() func() EntityID {
+	var once sync.Once
+	var sf *uuid.Snowflake
+	return func() EntityID {
+		once.Do(func() {
+			sf = uuid.NewSnowflake(game.GetGameID())
+		})
+		return EntityID(sf.Gen())
+	}
+}
+
 // RegisterEntity registers the entity type so that entities can be created or loaded
 //
 // returns the entity type description object which can be used to define more properties
@@ -43,14 +115,52 @@ func RegisterEntity(typeName string, entityPtr entity.IEntity) *entity.EntityTyp
 	return entity.RegisterEntity(typeName, entityPtr, false)
 }
 
+// RegisterScriptEntity registers the entity type whose behaviors are defined by the Lua script
+//
+// The entity should be &luascript.ScriptEntity{}, or a pointer of struct type embedding luascript.ScriptEntity. Scripts
+// can be changed without rebuilding games by ReloadScripts, see package luascript for hooks and APIs of scripts.
+func RegisterScriptEntity(typeName string, entityPtr entity.IEntity, scriptFile string) *entity.EntityTypeDesc {
+	return luascript.RegisterEntity(typeName, entityPtr, scriptFile)
+}
+
 // RegisterService registeres an service type
 // After registeration, the service entity will be created automatically on some game
 func RegisterService(typeName string, entityPtr entity.IEntity) {
 	service.RegisterService(typeName, entityPtr)
 }
 
+// RegisterServiceSharded registers a service type with multiple shards
+//
+// Each shard is a service entity created automatically on some game, and shards are distributed across games.
+// Use CallServiceShardKey to route calls to shards by keys.
+func RegisterServiceSharded(typeName string, entityPtr entity.IEntity, shardCount int) {
+	service.RegisterServiceSharded(typeName, entityPtr, shardCount)
+}
+
+// RegisterWorkerService registers a stateless worker service with multiple identical instances
+//
+// Worker instances are distributed across worker_games of [deployment], or all games if not set, and CallService
+// balances calls among healthy instances.
+// It is useful for CPU-heavy jobs like pathfinding, battle verification or report generation.
+func RegisterWorkerService(typeName string, entityPtr entity.IEntity, instanceCount int) {
+	service.RegisterWorkerService(typeName, entityPtr, instanceCount)
+}
+
+// RegisterWorldService registers a service with an instance in each world configured by [deployment].worlds
+//
+// The service of each world is a separate service entity (or shards) saved separately, called by CallWorldService.
+func RegisterWorldService(typeName string, entityPtr entity.IEntity, shardCount int) {
+	service.RegisterWorldService(typeName, entityPtr, shardCount)
+}
+
+// GetWorlds returns worlds configured by [deployment].worlds, which is empty for the single default world
+func GetWorlds() []string {
+	return service.GetWorlds()
+}
+
 // CreateSpaceAnywhere creates a space with specified kind in any game server
 func CreateSpaceAnywhere(kind int) EntityID {
+	checkGameRoutine("CreateSpaceAnywhere")
 	return entity.CreateSpaceSomewhere(0, kind)
 }
 
@@ -58,6 +168,7 @@ func CreateSpaceAnywhere(kind int) EntityID {
 //
 // returns the space EntityID
 func CreateSpaceLocally(kind int) *Space {
+	checkGameRoutine("CreateSpaceLocally")
 	return entity.CreateSpaceLocally(kind)
 }
 
@@ -65,6 +176,7 @@ func CreateSpaceLocally(kind int) *Space {
 //
 // returns the space EntityID
 func CreateSpaceOnGame(gameid uint16, kind int) EntityID {
+	checkGameRoutine("CreateSpaceOnGame")
 	return entity.CreateSpaceSomewhere(gameid, kind)
 }
 
@@ -72,15 +184,18 @@ func CreateSpaceOnGame(gameid uint16, kind int) EntityID {
 //
 // returns EntityID
 func CreateEntityLocally(typeName string) *Entity {
+	checkGameRoutine("CreateEntityLocally")
 	return entity.CreateEntityLocally(typeName, nil)
 }
 
 // CreateEntitySomewhere creates a entity on any server
 func CreateEntityAnywhere(typeName string) EntityID {
+	checkGameRoutine("CreateEntityAnywhere")
 	return entity.CreateEntitySomewhere(0, typeName)
 }
 
 func CreateEntityOnGame(gameid uint16, typeName string) EntityID {
+	checkGameRoutine("CreateEntityOnGame")
 	return entity.CreateEntitySomewhere(gameid, typeName)
 }
 
@@ -89,6 +204,29 @@ func LoadEntityAnywhere(typeName string, entityID EntityID) {
 	entity.LoadEntityAnywhere(typeName, entityID)
 }
 
+// CreateSpaceInWorld creates a space of the world with specified kind in any game server
+//
+// Entities can only enter spaces of their own worlds, and entities created or loaded in the space are in its world.
+func CreateSpaceInWorld(world string, kind int) EntityID {
+	checkGameRoutine("CreateSpaceInWorld")
+	return entity.CreateSpaceSomewhereInWorld(0, kind, world)
+}
+
+// CreateEntityInWorld creates a entity of the world on any server
+//
+// Entities of different worlds are saved separately in the entity storage, see Entity.World
+func CreateEntityInWorld(world string, typeName string) EntityID {
+	checkGameRoutine("CreateEntityInWorld")
+	return entity.CreateEntitySomewhereInWorld(0, typeName, world)
+}
+
+// LoadEntityInWorld loads the specified entity of the world from entity storage on any server
+//
+// Entity IDs are global across worlds, LoadEntityInWorld does nothing if the entity already exists in any world.
+func LoadEntityInWorld(world string, typeName string, entityID EntityID) {
+	entity.LoadEntityAnywhereInWorld(typeName, entityID, world)
+}
+
 // LoadEntityOnGame loads entity in the specified game
 // If the entity already exists on any server, LoadEntityOnGame will do nothing
 func LoadEntityOnGame(typeName string, entityID EntityID, gameid uint16) {
@@ -108,13 +246,20 @@ func Exists(typeName string, entityID EntityID, callback storage.ExistsCallbackF
 	storage.Exists(typeName, entityID, callback)
 }
 
+// ExistsContext is Exists with the context, e.g. Entity.Context(), the callback is dropped if ctx is canceled
+func ExistsContext(ctx context.Context, typeName string, entityID EntityID, callback storage.ExistsCallbackFunc) {
+	storage.ExistsContext(ctx, typeName, entityID, callback)
+}
+
 // GetEntity gets the entity by EntityID
 func GetEntity(id EntityID) *Entity {
+	checkGameRoutine("GetEntity")
 	return entity.GetEntity(id)
 }
 
 // GetSpace gets the space by ID
 func GetSpace(id EntityID) *Space {
+	checkGameRoutine("GetSpace")
 	return entity.GetSpace(id)
 }
 
@@ -145,26 +290,181 @@ func RegisterSpace(spacePtr entity.ISpace) {
 
 // Entities gets all entities as an EntityMap (do not modify it!)
 func Entities() entity.EntityMap {
+	checkGameRoutine("Entities")
 	return entity.Entities()
 }
 
 // Call other entities
 func Call(id EntityID, method string, args ...interface{}) {
+	checkGameRoutine("Call")
 	entity.Call(id, method, args)
 }
 
 // CallService calls a service entity
+//
+// A random shard is called if the service is sharded
 func CallService(serviceName string, method string, args ...interface{}) {
+	checkGameRoutine("CallService")
 	service.CallService(serviceName, method, args)
 }
 
-// GetServiceEntityID returns the entityid of the service
+// CallWorldService calls the service of the world registered by RegisterWorldService
+//
+// A random shard is called if the service is sharded
+func CallWorldService(world string, serviceName string, method string, args ...interface{}) {
+	checkGameRoutine("CallWorldService")
+	service.CallWorldService(world, serviceName, method, args)
+}
+
+// CallWorldServiceShardKey calls the shard of service of the world selected by the shard key
+func CallWorldServiceShardKey(world string, serviceName string, shardKey string, method string, args ...interface{}) {
+	checkGameRoutine("CallWorldServiceShardKey")
+	service.CallWorldServiceShardKey(world, serviceName, shardKey, method, args)
+}
+
+// Errors of CallServiceRequest when the service is not available
+var (
+	ErrServiceNotFound = service.ErrServiceNotFound
+	ErrServiceTimeout  = service.ErrServiceTimeout
+	ErrServiceLost     = service.ErrServiceLost
+)
+
+// ServiceMethodError is the error of CallServiceRequest when the service method returns an error
+type ServiceMethodError = service.ServiceMethodError
+
+// ServiceRequestOptions are options of CallServiceRequest: deadline of each attempt and retries of idempotent methods
+type ServiceRequestOptions = service.ServiceRequestOptions
+
+// CallServiceRequest calls a service method and calls back with its result
+//
+// The result is the first return value of the method which is not an error. cb receives ServiceMethodError if the
+// method returns an error, or ErrServiceTimeout, ErrServiceLost, ErrServiceNotFound if the service is not available.
+// Idempotent requests are retried on a different shard when the service is not available.
+func CallServiceRequest(serviceName string, method string, args []interface{}, opts ServiceRequestOptions, cb func(result interface{}, err error)) {
+	checkGameRoutine("CallServiceRequest")
+	service.CallServiceRequest(serviceName, method, args, opts, cb)
+}
+
+// CallServiceShardKey calls the shard of service selected by the shard key using consistent hashing
+//
+// Calls with the same shard key (e.g. player ID) are always routed to the same shard
+func CallServiceShardKey(serviceName string, shardKey string, method string, args ...interface{}) {
+	checkGameRoutine("CallServiceShardKey")
+	service.CallServiceShardKey(serviceName, shardKey, method, args)
+}
+
+// CallServiceShardIndex calls the specified shard of service
+func CallServiceShardIndex(serviceName string, shardIndex int, method string, args ...interface{}) {
+	checkGameRoutine("CallServiceShardIndex")
+	service.CallServiceShardIndex(serviceName, shardIndex, method, args)
+}
+
+// GetServiceEntityID returns the entityid of the service, or the first shard if the service is sharded
 func GetServiceEntityID(serviceName string) common.EntityID {
 	return service.GetServiceEntityID(serviceName)
 }
 
+// GetServiceShardEntityID returns the entityid of the specified shard of service
+func GetServiceShardEntityID(serviceName string, shardIndex int) common.EntityID {
+	return service.GetServiceShardEntityID(serviceName, shardIndex)
+}
+
+// GetServiceShardCount returns the shard count of service
+func GetServiceShardCount(serviceName string) int {
+	return service.GetServiceShardCount(serviceName)
+}
+
+// ListServices returns all service shards with their hosting games, entity IDs and health status
+//
+// Service entities can implement OnHealthCheck() error to report their health.
+func ListServices() []ServiceInfo {
+	return service.ListServices()
+}
+
+// PublishServiceSnapshot publishes a read-only snapshot of the service, which is replicated to all games
+//
+// It should be called by the service entity, and returns the version of the snapshot. Games read the snapshot locally
+// using GetServiceSnapshot or DecodeServiceSnapshot without calling the service. Shards of sharded services publish
+// their own snapshots using PublishServiceShardSnapshot.
+func PublishServiceSnapshot(serviceName string, data interface{}) (uint64, error) {
+	return service.PublishServiceSnapshot(serviceName, data)
+}
+
+// GetServiceSnapshot returns the latest snapshot of service decoded to generic types, and its version (0 if not published)
+func GetServiceSnapshot(serviceName string) (interface{}, uint64) {
+	return service.GetServiceSnapshot(serviceName)
+}
+
+// DecodeServiceSnapshot decodes the latest snapshot of service to v, and returns its version
+func DecodeServiceSnapshot(serviceName string, v interface{}) (uint64, error) {
+	return service.DecodeServiceSnapshot(serviceName, v)
+}
+
+// WatchServiceSnapshot calls the callback when a new version of service snapshot is replicated to this game
+func WatchServiceSnapshot(serviceName string, cb func(serviceName string, version uint64)) {
+	service.WatchServiceSnapshot(serviceName, cb)
+}
+
+// PublishServiceShardSnapshot publishes a read-only snapshot of the shard of service, which is replicated to all games
+func PublishServiceShardSnapshot(serviceName string, shardIndex int, data interface{}) (uint64, error) {
+	return service.PublishServiceShardSnapshot(serviceName, shardIndex, data)
+}
+
+// GetServiceShardSnapshot returns the latest snapshot of the shard of service decoded to generic types, and its version
+func GetServiceShardSnapshot(serviceName string, shardIndex int) (interface{}, uint64) {
+	return service.GetServiceShardSnapshot(serviceName, shardIndex)
+}
+
+// DecodeServiceShardSnapshot decodes the latest snapshot of the shard of service to v, and returns its version
+func DecodeServiceShardSnapshot(serviceName string, shardIndex int, v interface{}) (uint64, error) {
+	return service.DecodeServiceShardSnapshot(serviceName, shardIndex, v)
+}
+
+// WatchServiceShardSnapshot calls the callback when a new version of the snapshot of the shard of service is replicated
+// to this game
+func WatchServiceShardSnapshot(serviceName string, shardIndex int, cb func(serviceName string, version uint64)) {
+	service.WatchServiceShardSnapshot(serviceName, shardIndex, cb)
+}
+
+// HandoffServices migrates all service entities on this game to the target game without service downtime
+//
+// It is useful for restarting games one by one. Calls to services are buffered during the handoff.
+func HandoffServices(targetGame uint16) error {
+	return service.Handoff(targetGame)
+}
+
+// SetServiceCallFailedCallback sets the callback which is called when a service call is failed
+//
+// Dispatchers keep calls to a service entity for service_failover_timeout when its game is down,
+// and fail them if the service entity is not re-created in time.
+func SetServiceCallFailedCallback(cb func(serviceName string, shardIndex int, method string)) {
+	service.SetServiceCallFailedCallback(cb)
+}
+
+// EmitEvent emits the event to handlers subscribed by OnEvent on all games, e.g. EmitEvent("player.levelup", payload)
+//
+// The payload is packed by msgpack. Events of the same name are delivered in order, at most once.
+func EmitEvent(name string, payload interface{}) error {
+	return eventbus.Emit(name, payload)
+}
+
+// EmitPersistentEvent stores the event in KVDB before emitting it, so that it is delivered at least once: games missing
+// the event when they are down or disconnected receive it when they are ready or reconnected
+func EmitPersistentEvent(name string, payload interface{}) error {
+	return eventbus.EmitPersistent(name, payload)
+}
+
+// OnEvent subscribes the handler to events of the name on this game, and returns the subscription to cancel
+//
+// handler should be func(payload T) or func(), where T is the type which payloads are unpacked to, e.g.
+// func(ev *LevelUpEvent). Handlers subscribed by entities should be cancelled when the entities are destroyed.
+func OnEvent(name string, handler interface{}) *EventSubscription {
+	return eventbus.Subscribe(name, handler)
+}
+
 // CallNilSpaces calls methods of all nil spaces on all games
 func CallNilSpaces(method string, args ...interface{}) {
+	checkGameRoutine("CallNilSpaces")
 	entity.CallNilSpaces(method, args, game.GetGameID())
 }
 
@@ -179,6 +479,7 @@ func GetNilSpaceID(gameid uint16) EntityID {
 //
 // Since nil game exists on each game with fixed EntityID, an entity can migrate to target game by calling `e.EnterSpace(GetNilSpaceID(gameid), Vector3{})`
 func GetNilSpace() *Space {
+	checkGameRoutine("GetNilSpace")
 	return entity.GetNilSpace()
 }
 
@@ -192,28 +493,176 @@ func PutKVDB(key string, val string, callback kvdb.KVDBPutCallback) {
 	kvdb.Put(key, val, callback)
 }
 
+// DeleteKVDB deletes key from KVDB
+func DeleteKVDB(key string, callback kvdb.KVDBDeleteCallback) {
+	kvdb.Delete(key, callback)
+}
+
 // GetOrPut gets value of key from KVDB, if val not exists or is "", put key-value to KVDB.
 func GetOrPutKVDB(key string, val string, callback kvdb.KVDBGetOrPutCallback) {
 	kvdb.GetOrPut(key, val, callback)
 }
 
+// GetKVDBContext is GetKVDB with the context, e.g. Entity.Context(), the callback is dropped if ctx is canceled
+func GetKVDBContext(ctx context.Context, key string, callback kvdb.KVDBGetCallback) {
+	kvdb.GetContext(ctx, key, callback)
+}
+
+// PutKVDBContext is PutKVDB with the context, the key-value is not put if ctx is done before the operation starts
+func PutKVDBContext(ctx context.Context, key string, val string, callback kvdb.KVDBPutCallback) {
+	kvdb.PutContext(ctx, key, val, callback)
+}
+
+// GetOrPutKVDBContext is GetOrPutKVDB with the context
+func GetOrPutKVDBContext(ctx context.Context, key string, val string, callback kvdb.KVDBGetOrPutCallback) {
+	kvdb.GetOrPutContext(ctx, key, val, callback)
+}
+
+// Ban bans the client IP or CIDR, account or device for duration, or permanently if duration is 0
+//
+// Bans are saved in KVDB, and published to all gates after saved: clients of banned IPs can not connect, clients of
+// banned accounts or devices are rejected during the handshake, and connected clients are kicked. Gates with
+// persist_ban_list enabled also reload bans from KVDB after restarted.
+func Ban(kind banlist.Kind, value string, duration time.Duration, reason string, callback func(err error)) {
+	ban, err := banlist.NewBan(kind, value, duration, reason, fmt.Sprintf("game%d", GetGameID()))
+	if err != nil {
+		if callback != nil {
+			callback(err)
+		}
+		return
+	}
+	banlist.Save(ban, func(err error) {
+		if err == nil {
+			err = banlist.Publish(ban)
+		}
+		if callback != nil {
+			callback(err)
+		}
+	})
+}
+
+// Unban removes the ban of the client IP or CIDR, account or device from KVDB and all gates
+func Unban(kind banlist.Kind, value string, callback func(err error)) {
+	value, err := banlist.Normalize(kind, value)
+	if err != nil {
+		if callback != nil {
+			callback(err)
+		}
+		return
+	}
+	banlist.Delete(kind, value, func(err error) {
+		if err == nil {
+			err = banlist.PublishDelete(kind, value)
+		}
+		if callback != nil {
+			callback(err)
+		}
+	})
+}
+
+// GetBans gets all bans which are not expired from KVDB
+func GetBans(callback func(bans []BanInfo, err error)) {
+	banlist.Load(callback)
+}
+
+// Errors of Entity.ClaimAccountSession when the session of the account is not claimed
+var (
+	ErrAccountSessionRejected = entity.ErrAccountSessionRejected
+	ErrAccountSessionKicked   = entity.ErrAccountSessionKicked
+)
+
+// OnlinePlayer is the player online by the session of the account claimed by Entity.ClaimAccountSession
+type OnlinePlayer = entity.OnlinePlayer
+
+// Events emitted to all games when players log in or out by claiming or releasing sessions of accounts, e.g.
+// goworld.OnEvent(goworld.PlayerLoginEvent, func(player *goworld.OnlinePlayer) {...})
+const (
+	PlayerLoginEvent  = entity.PlayerLoginEvent
+	PlayerLogoutEvent = entity.PlayerLogoutEvent
+)
+
+// FindOnlinePlayer finds the online player of the account, the callback is called with nil if the account is offline
+//
+// The player is found by sessions claimed by Entity.ClaimAccountSession on any game, and the entity holding the session
+// can be called by the EntityID of the player.
+func FindOnlinePlayer(account string, callback func(player *OnlinePlayer, err error)) {
+	entity.FindOnlinePlayer(account, callback)
+}
+
 // GetOnlineGames returns all online game IDs
 func GetOnlineGames() common.Uint16Set {
 	return game.GetOnlineGames()
 }
 
-// AddTimer adds a timer to be executed after specified duration
+// AddCallback adds a callback to be executed after specified duration
 func AddCallback(d time.Duration, callback func()) {
-	timer.AddCallback(d, callback)
+	checkGameRoutine("AddCallback")
+	gwtimer.AddCallback(d, callback)
+}
+
+// AddCallbackHandle adds a callback like AddCallback, and returns the timer which can be cancelled or reset in the game
+// routine
+func AddCallbackHandle(d time.Duration, callback func()) *Timer {
+	checkGameRoutine("AddCallbackHandle")
+	return gwtimer.AddCallback(d, callback)
 }
 
 // AddTimer adds a repeat timer to be executed every specified duration
 func AddTimer(d time.Duration, callback func()) {
-	timer.AddTimer(d, callback)
+	checkGameRoutine("AddTimer")
+	gwtimer.AddTimer(d, callback)
+}
+
+// AddTimerHandle adds a repeat timer like AddTimer, and returns the timer which can be cancelled or reset in the game
+// routine
+func AddTimerHandle(d time.Duration, callback func()) *Timer {
+	checkGameRoutine("AddTimerHandle")
+	return gwtimer.AddTimer(d, callback)
+}
+
+// Now returns the current time of the game, which is the logical time in the deterministic mode
+//
+// Game logic should use Now instead of time.Now, so that the game is deterministic if deterministic is set in config.
+func Now() time.Time {
+	return simulation.Now()
+}
+
+// Rand returns the RNG of the game, which is seeded by deterministic_seed in the deterministic mode
+//
+// Game logic should use Rand instead of math/rand, so that the game is deterministic if deterministic is set in config.
+func Rand() *rand.Rand {
+	return simulation.Rand()
+}
+
+// Shutdown shuts down the game gracefully after the grace period, e.g. for maintenance
+//
+// New clients and entities are not put on the game anymore, and clients of all entities are notified with the reason and
+// the countdown. After the grace period, in-flight migrations are completed, all entities are saved and destroyed, and
+// the game exits after entity saves and KVDB writes are flushed. SIGTERM shuts down the game the same way without the
+// grace period.
+func Shutdown(grace time.Duration, reason string) {
+	checkGameRoutine("Shutdown")
+	game.Shutdown(grace, reason)
+}
+
+// ReloadPlugin reloads logic of entity types from the Go plugin without restarting the game
+//
+// The plugin exports `func EntityTypes() map[string]entity.IEntity` returning new types of registered entity types.
+// Entities of these types are swapped to the new types in place, keeping their attrs, timers, clients and positions.
+// Entity pointers should not be kept across reloads, keep entity IDs instead.
+func ReloadPlugin(path string) error {
+	checkGameRoutine("ReloadPlugin")
+	return game.ReloadPlugin(path)
+}
+
+// ReloadScripts reloads Lua scripts of all entity types registered by RegisterScriptEntity
+func ReloadScripts() error {
+	checkGameRoutine("ReloadScripts")
+	return luascript.ReloadScripts()
 }
 
 // Post posts a callback to be executed
-// It is almost same as AddCallback(0, callback)
+// It is almost same as AddCallback(0, callback), but can be called in any goroutine
 func Post(callback post.PostCallback) {
 	post.Post(callback)
 }
@@ -229,3 +678,122 @@ func Post(callback post.PostCallback) {
 func RegisterCrontab(minute, hour, day, month, dayofweek int, cb func()) {
 	crontab.Register(minute, hour, day, month, dayofweek, cb)
 }
+
+// Async runs the routine in the worker pool of the group, and calls the callback with its result in the game routine
+//
+// The routine runs when the returned function is called with the callback (or nil to ignore the result), e.g.
+//
+//	goworld.Async("http", func() (interface{}, error) {
+//		return http.Get(url)
+//	})(func(res interface{}, err error) {
+//		// handle the response in the game routine
+//	})
+//
+// Routines should not access entities or other states of the game routine. Each group has 1 worker running jobs in order
+// by default, use async_workers in game config to run jobs of the group in parallel. Panics of routines are returned
+// as errors.
+func Async(group string, routine async.AsyncRoutine) func(callback async.AsyncCallback) {
+	return func(callback async.AsyncCallback) {
+		async.AppendAsyncJob(group, routine, callback)
+	}
+}
+
+// AsyncContext is Async with the context, e.g. Entity.Context()
+//
+// The routine should return when ctx is done. The callback is dropped if ctx is canceled, so that it is not called on
+// destroyed entities.
+func AsyncContext(ctx context.Context, group string, routine async.ContextAsyncRoutine) func(callback async.AsyncCallback) {
+	return func(callback async.AsyncCallback) {
+		async.AppendAsyncJobContext(ctx, group, routine, callback)
+	}
+}
+
+// AddCron adds a callback to be executed at times matching the cron expression, e.g. "0 5 * * *" for 5:00 every day
+//
+// The time zone can be specified by prefix CRON_TZ=<zone>, e.g. "CRON_TZ=Asia/Shanghai 0 5 * * *", see
+// crontab.ParseSchedule for the syntax. Use Entity.AddCron for cron timers of entities, which are kept when entities
+// migrate.
+func AddCron(spec string, cb func()) (*Cron, error) {
+	return crontab.AddCron(spec, cb)
+}
+
+// OnFrameOverrun registers the callback which is called when a game frame exceeds frame_budget_ms
+//
+// The callback is called in the game routine before timers fire, so that game logic can shed load,
+// e.g. skip AI ticks or defer saves.
+func OnFrameOverrun(cb func(info FrameOverrunInfo)) {
+	game.OnFrameOverrun(cb)
+}
+
+// RelevanceScorer scores the relevance of the target entity to the observer entity owning the client
+type RelevanceScorer = entity.RelevanceScorer
+
+// SetRelevanceScorer sets the scorer deciding which position syncs are sent first when client_sync_budget is exceeded
+//
+// Scorers can wrap DefaultRelevanceScore to add game-specific relevance, e.g. party members.
+func SetRelevanceScorer(scorer RelevanceScorer) {
+	entity.SetRelevanceScorer(scorer)
+}
+
+// DefaultRelevanceScore scores the target by the attention target of the observer, recent interactions and distance
+func DefaultRelevanceScore(observer *Entity, target *Entity) float64 {
+	return entity.DefaultRelevanceScore(observer, target)
+}
+
+// FeatureEnabled returns if the feature flag is enabled on this game by [features] config
+//
+// Feature flags can be enabled on all games (e.g. new_combat=true) or some games (e.g. new_combat=game1,game3), and
+// changed without redeploying by hot reloading the config.
+func FeatureEnabled(name string) bool {
+	return game.FeatureEnabled(name)
+}
+
+// OnFeatureChanged registers the callback which is called in the game routine when the feature flag is enabled or
+// disabled on this game by hot reloading the config
+func OnFeatureChanged(name string, cb func(enabled bool)) {
+	game.OnFeatureChanged(name, cb)
+}
+
+// OnWatchdogAlert registers the callback which is called when the watchdog alert level of goroutines, heap size or
+// main loop stall changes, according to thresholds in [watchdog] config
+//
+// The callback is called in the watchdog goroutine since the game routine might be stalled, use Post to run game logic.
+func OnWatchdogAlert(cb func(alert WatchdogAlert)) {
+	watchdog.OnAlert(cb)
+}
+
+// Authorize checks if the user is allowed to do the action by roles in [rbac] config, and audits the decision
+//
+// Actions not covered by RegisterGMCommand can be authorized with action gm:<command>, where the user is usually the
+// AuthID of the client, e.g. Authorize(client.AuthID(), "gm:give_item"). rbac.ErrPermissionDenied is returned if the action is not allowed.
+func Authorize(user string, action string) error {
+	return rbac.Authorize(user, action, "game")
+}
+
+// RegisterGMCommand registers the GM command, e.g. RegisterGMCommand("give_item", handler, "")
+//
+// GM commands can be run by Entity.RunGMCommand (e.g. from chat messages starting with /), the admin API /gm of games or
+// goworld gm <entity-id> <command> [args...]. Users are authorized with permission in [rbac] config, which is
+// gm:<name> if empty, and handlers are called on the game hosting the target entity.
+func RegisterGMCommand(name string, handler GMHandler, permission string) {
+	entity.RegisterGMCommand(name, handler, permission)
+}
+
+// OnAudit registers the callback which is called with records of all authorization decisions of admin API requests,
+// calls through HTTP bridge or gRPC, and Authorize
+//
+// The callback is called in goroutines authorizing actions (e.g. goroutines of admin HTTP servers), use Post to run game
+// logic.
+func OnAudit(cb func(record AuditRecord)) {
+	rbac.OnAudit(cb)
+}
+
+// checkGameRoutine panics if the API is called off the game routine, e.g. in HTTP handlers or callbacks of third-party
+// SDKs, which should use Safe() instead
+//
+// The check is too slow for hot paths, so it is only enabled by consts.DEBUG_GAME_ROUTINE.
+func checkGameRoutine(api string) {
+	if consts.DEBUG_GAME_ROUTINE && opmon.IsOffMainRoutine() {
+		gwlog.Panicf("goworld.%s is called outside of the game routine, use goworld.Safe() in other goroutines", api)
+	}
+}
@@ -0,0 +1,120 @@
+package goworldtest
+
+import (
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/netutil"
+)
+
+// ClientCall is the method call received by the client
+type ClientCall struct {
+	EntityID common.EntityID
+	Method   string
+	Args     []interface{}
+}
+
+// Client is the fake client connected through the fake gate, which records entities and calls received from the game
+type Client struct {
+	ID         common.ClientID
+	OwnerID    common.EntityID            // the player entity of the client
+	Entities   map[common.EntityID]string // entity ID -> type name of entities created on the client
+	Calls      []ClientCall
+	KickReason string // reason of kicking the client by the game, e.g. logged in elsewhere
+
+	ShutdownReason    string        // reason of the game shutting down, notified by goworld.Shutdown
+	ShutdownCountdown time.Duration // countdown of the game shutting down
+
+	world        *World
+	disconnected bool
+}
+
+// CallServer calls the method of the entity from the client like real clients do, e.g. method "Hello" calls Hello_Client
+func (c *Client) CallServer(id common.EntityID, method string, args ...interface{}) {
+	if c.disconnected {
+		gwlog.Panicf("%s is disconnected", c)
+	}
+
+	// pack args like clients do, so that args are received by the entity as from real clients
+	pkt := netutil.NewPacket()
+	pkt.AppendArgs(args)
+	entity.OnCall(id, method, pkt.ReadArgs(), c.ID)
+	pkt.Release()
+	c.world.Step()
+}
+
+// SyncPosition syncs the position and yaw of the entity from the client like real clients do, the move is validated by
+// ValidateMove of the space if the entity is syncing from the client (see Entity.SetClientSyncing)
+func (c *Client) SyncPosition(id common.EntityID, pos entity.Vector3, yaw entity.Yaw) {
+	if c.disconnected {
+		gwlog.Panicf("%s is disconnected", c)
+	}
+
+	entity.OnSyncPositionYawFromClient(id, pos.X, pos.Y, pos.Z, yaw)
+	c.world.Step()
+}
+
+// Disconnect disconnects the client, the owner entity is notified of losing the client
+func (c *Client) Disconnect() {
+	if c.disconnected {
+		return
+	}
+	c.disconnected = true
+	delete(c.world.clients, c.ID)
+	entity.OnClientDisconnected(c.OwnerID, c.ID)
+	c.world.Step()
+}
+
+// Disconnected returns if the client is disconnected or kicked
+func (c *Client) Disconnected() bool {
+	return c.disconnected
+}
+
+// CallsOf returns calls of the method received by the client
+func (c *Client) CallsOf(method string) []ClientCall {
+	var calls []ClientCall
+	for _, call := range c.Calls {
+		if call.Method == method {
+			calls = append(calls, call)
+		}
+	}
+	return calls
+}
+
+func (c *Client) String() string {
+	return "Client<" + string(c.ID) + ">"
+}
+
+func (c *Client) onCreateEntity(typeName string, eid common.EntityID, isPlayer bool) {
+	c.Entities[eid] = typeName
+	if isPlayer {
+		c.OwnerID = eid
+	}
+}
+
+func (c *Client) onDestroyEntity(eid common.EntityID) {
+	delete(c.Entities, eid)
+	if c.OwnerID == eid {
+		c.OwnerID = ""
+	}
+}
+
+func (c *Client) onCall(eid common.EntityID, method string, args [][]byte) {
+	call := ClientCall{EntityID: eid, Method: method, Args: make([]interface{}, len(args))}
+	for i, arg := range args {
+		if err := netutil.MSG_PACKER.UnpackMsg(arg, &call.Args[i]); err != nil {
+			gwlog.Panicf("%s: unpack args of %s failed: %s", c, method, err)
+		}
+	}
+	c.Calls = append(c.Calls, call)
+}
+
+// onKicked disconnects the client kicked by the game like the gate does
+func (c *Client) onKicked(reason string) {
+	c.KickReason = reason
+	c.disconnected = true
+	delete(c.world.clients, c.ID)
+	entity.OnClientDisconnected(c.OwnerID, c.ID)
+}
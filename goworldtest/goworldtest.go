@@ -0,0 +1,321 @@
+// Package goworldtest runs the game logic with an in-memory dispatcher and a fake gate in the test process
+//
+// The world routes packets sent by the game to the dispatcher back to the game, and records packets sent to clients by
+// the fake gate, so that entity logic, RPCs between entities and client RPCs can be tested with go test instead of
+// launching dispatchers, games and gates. The game runs in the deterministic simulation mode with the seed Seed: timers
+// of entities and the game are fired by the logical time only when the world is advanced, so that tests never sleep,
+// and entity IDs and goworld.Rand are the same in every run, so that tests of complex interactions are reproducible.
+//
+// The engine keeps entities in package-level states, so there is only one game in the test process, and the world is
+// shared by all tests in the package. Entities are migrated between spaces of the game, but not between games. Entity
+// storage and KVDB are not initialized by the world, and global timers (e.g. timeouts of service requests) run in real
+// time.
+package goworldtest
+
+import (
+	"sync"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/accountsession"
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster"
+	"github.com/xiaonanln/goworld/engine/dispatchercluster/dispatcherclient"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/eventbus"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/gwtimer"
+	"github.com/xiaonanln/goworld/engine/netutil"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/proto"
+	"github.com/xiaonanln/goworld/engine/service"
+	"github.com/xiaonanln/goworld/engine/simulation"
+	"github.com/xiaonanln/goworld/engine/timingwheel"
+)
+
+const (
+	// GameID is the game ID of the game in the world
+	GameID = 1
+	// GateID is the gate ID of the fake gate connecting clients
+	GateID = 1
+	// TickInterval is the interval of ticks when the world is advanced
+	TickInterval = time.Millisecond * 5
+	// Seed is the seed of the RNG of the deterministic mode
+	Seed = 1
+
+	maxStepRounds = 10000
+)
+
+// World is the in-process game with the in-memory dispatcher and the fake gate, it is not safe for concurrent use
+type World struct {
+	conn       *memConn
+	dispatcher *proto.GoWorldConnection // receives packets sent by the game
+	clients    map[common.ClientID]*Client
+	sessions   *accountsession.Table // sessions of accounts claimed by entities
+
+	clientBytes uint64 // total payload size of packets sent to clients through the fake gate
+}
+
+var (
+	world     *World
+	setupOnce sync.Once
+)
+
+// Setup creates the world for tests, or returns the world if it is already created
+//
+// Entity types and the space type should be registered before Setup, since the nil space is created by Setup.
+func Setup() *World {
+	setupOnce.Do(func() {
+		conn := &memConn{}
+		world = &World{
+			conn:       conn,
+			dispatcher: proto.NewGoWorldConnection(netutil.NetConnection{Conn: conn}, false, ""),
+			clients:    map[common.ClientID]*Client{},
+			sessions:   accountsession.NewTable(),
+		}
+		simulation.Enable(Seed, TickInterval)
+		timingwheel.SetClock(simulation.Now)
+		entity.SetTimingWheel(true)
+		gwtimer.SetTimingWheel(true)
+		dispatchercluster.InitializeLocal(GameID, dispatcherclient.GameDispatcherClientType, conn)
+		world.sessions.SetListener(world.emitOnlinePlayerEvent)
+		entity.CreateNilSpace(GameID)
+		world.Step()
+	})
+	return world
+}
+
+// Now returns the logical time of the world
+func (w *World) Now() time.Time {
+	return simulation.Now()
+}
+
+// ClientBytes returns the total payload size of packets sent by the game to clients through the fake gate, including
+// entity creations, attribute changes, client calls and position syncs
+func (w *World) ClientBytes() uint64 {
+	return w.clientBytes
+}
+
+// Advance advances the logical time by the duration tick by tick, timers expired in each tick are fired
+func (w *World) Advance(d time.Duration) {
+	for d > 0 {
+		step := TickInterval
+		if step > d {
+			step = d
+		}
+		d -= step
+
+		simulation.Advance(step)
+		timingwheel.Tick()
+		w.Step()
+	}
+}
+
+// Step runs posted functions and handles packets sent by the game until there is nothing to do
+func (w *World) Step() {
+	for round := 0; ; round++ {
+		if round >= maxStepRounds {
+			gwlog.Panicf("goworldtest: world is still busy after %d rounds, entities might be calling each other infinitely", round)
+		}
+
+		post.Flush()
+		dispatchercluster.Flush("goworldtest")
+		handled := 0
+		for {
+			var msgtype proto.MsgType
+			pkt, err := w.dispatcher.Recv(&msgtype)
+			if err != nil {
+				break // no more packets
+			}
+			if msgtype >= proto.MT_GATE_SERVICE_MSG_TYPE_START && msgtype <= proto.MT_GATE_SERVICE_MSG_TYPE_STOP {
+				w.clientBytes += uint64(pkt.GetPayloadLen())
+			}
+			w.handlePacket(msgtype, pkt)
+			pkt.Release()
+			handled += 1
+		}
+		if handled == 0 && post.GetQueueLen() == 0 {
+			break
+		}
+	}
+}
+
+// handlePacket handles the packet as the dispatcher and the gate
+func (w *World) handlePacket(msgtype proto.MsgType, pkt *netutil.Packet) {
+	switch msgtype {
+	case proto.MT_CALL_ENTITY_METHOD:
+		eid := pkt.ReadEntityID()
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		entity.OnCall(eid, method, args, "")
+	case proto.MT_CALL_SERVICE_REQUEST:
+		eid := pkt.ReadEntityID()
+		method := pkt.ReadVarStr()
+		callerGameID := pkt.ReadUint16()
+		requestID := pkt.ReadUint32()
+		args := pkt.ReadArgs()
+		service.OnServiceRequest(eid, method, callerGameID, requestID, args)
+	case proto.MT_SERVICE_RESPONSE:
+		_ = pkt.ReadUint16() // caller gameid
+		requestID := pkt.ReadUint32()
+		code := pkt.ReadOneByte()
+		errmsg := pkt.ReadVarStr()
+		var result interface{}
+		pkt.ReadData(&result)
+		service.OnServiceResponse(requestID, code, errmsg, result)
+	case proto.MT_CREATE_ENTITY_SOMEWHERE:
+		_ = pkt.ReadUint16() // gameid
+		eid := pkt.ReadEntityID()
+		typeName := pkt.ReadVarStr()
+		var data map[string]interface{}
+		pkt.ReadData(&data)
+		world := pkt.ReadVarStr()
+		entity.OnCreateEntitySomewhere(eid, typeName, world, data)
+	case proto.MT_LOAD_ENTITY_SOMEWHERE:
+		_ = pkt.ReadUint16() // gameid
+		eid := pkt.ReadEntityID()
+		typeName := pkt.ReadVarStr()
+		world := pkt.ReadVarStr()
+		if entity.GetEntity(eid) == nil {
+			entity.OnLoadEntitySomewhere(typeName, eid, world)
+		}
+	case proto.MT_CALL_NIL_SPACES:
+		exceptGameID := pkt.ReadUint16()
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		if exceptGameID != GameID {
+			entity.OnCallNilSpaces(method, args)
+		}
+	case proto.MT_EMIT_EVENT:
+		name := pkt.ReadVarStr()
+		id := pkt.ReadVarStr()
+		data := pkt.ReadVarBytes()
+		eventbus.OnEvent(name, id, data)
+	case proto.MT_CLAIM_ACCOUNT_SESSION:
+		account := pkt.ReadVarStr()
+		eid := pkt.ReadEntityID()
+		gateid := pkt.ReadUint16()
+		maxSessions := int(pkt.ReadUint32())
+		kickOlder := pkt.ReadBool()
+		refresh := pkt.ReadBool()
+		session := accountsession.Session{EntityID: eid, GameID: GameID, GateID: gateid, LoginTime: simulation.Now()}
+		if refresh {
+			w.sessions.Refresh(account, session)
+			break
+		}
+		ok, kicked := w.sessions.Claim(account, session, maxSessions, kickOlder)
+		kickedEids := common.EntityIDSet{}
+		for _, s := range kicked {
+			kickedEids.Add(s.EntityID)
+		}
+		entity.OnClaimAccountSessionAck(account, eid, ok, kickedEids)
+	case proto.MT_RELEASE_ACCOUNT_SESSION:
+		account := pkt.ReadVarStr()
+		eid := pkt.ReadEntityID()
+		w.sessions.Release(account, eid)
+	case proto.MT_QUERY_ONLINE_PLAYER:
+		account := pkt.ReadVarStr()
+		requestID := pkt.ReadUint32()
+		var data []byte
+		if player := w.sessions.FindPlayer(account); player != nil {
+			data, _ = netutil.MSG_PACKER.PackMsg(player, nil)
+		}
+		entity.OnQueryOnlinePlayerAck(requestID, data)
+	case proto.MT_KICK_ACCOUNT_SESSION:
+		eid := pkt.ReadEntityID()
+		account := pkt.ReadVarStr()
+		reason := pkt.ReadVarStr()
+		entity.OnKickAccountSession(eid, account, reason)
+	case proto.MT_KICK_CLIENT:
+		_ = pkt.ReadUint16() // gateid
+		clientid := pkt.ReadClientID()
+		reason := pkt.ReadVarStr()
+		if client := w.clients[clientid]; client != nil {
+			client.onKicked(reason)
+		}
+	case proto.MT_NOTIFY_SHUTDOWN_ON_CLIENT:
+		_ = pkt.ReadUint16() // gateid
+		clientid := pkt.ReadClientID()
+		reason := pkt.ReadVarStr()
+		countdown := time.Duration(pkt.ReadUint32()) * time.Second
+		if client := w.clients[clientid]; client != nil {
+			client.ShutdownReason, client.ShutdownCountdown = reason, countdown
+		}
+	case proto.MT_CREATE_ENTITY_ON_CLIENT:
+		_ = pkt.ReadUint16() // gateid
+		clientid := pkt.ReadClientID()
+		isPlayer := pkt.ReadBool()
+		eid := pkt.ReadEntityID()
+		typeName := pkt.ReadVarStr()
+		if client := w.clients[clientid]; client != nil {
+			client.onCreateEntity(typeName, eid, isPlayer)
+		}
+	case proto.MT_DESTROY_ENTITY_ON_CLIENT:
+		_ = pkt.ReadUint16() // gateid
+		clientid := pkt.ReadClientID()
+		_ = pkt.ReadVarStr() // typeName
+		eid := pkt.ReadEntityID()
+		if client := w.clients[clientid]; client != nil {
+			client.onDestroyEntity(eid)
+		}
+	case proto.MT_CALL_ENTITY_METHOD_ON_CLIENT:
+		_ = pkt.ReadUint16() // gateid
+		clientid := pkt.ReadClientID()
+		eid := pkt.ReadEntityID()
+		method := pkt.ReadVarStr()
+		args := pkt.ReadArgs()
+		if client := w.clients[clientid]; client != nil {
+			client.onCall(eid, method, args)
+		}
+	default:
+		// other packets (e.g. notifications of entity creation, attr changes on clients) are ignored
+	}
+}
+
+// emitOnlinePlayerEvent emits LoginEvent or LogoutEvent of the player like the dispatcher
+func (w *World) emitOnlinePlayerEvent(player *accountsession.OnlinePlayer, online bool) {
+	name := accountsession.LogoutEvent
+	if online {
+		name = accountsession.LoginEvent
+	}
+	data, err := netutil.MSG_PACKER.PackMsg(player, nil)
+	if err != nil {
+		gwlog.Panic(err)
+	}
+	post.Post(func() {
+		eventbus.OnEvent(name, "", data)
+	})
+}
+
+// CreateEntity creates the entity of the type in the game
+func (w *World) CreateEntity(typeName string) *entity.Entity {
+	e := entity.CreateEntityLocally(typeName, nil)
+	w.Step()
+	return e
+}
+
+// Call calls the method of the entity like entities do, and returns after the call is handled
+func (w *World) Call(id common.EntityID, method string, args ...interface{}) {
+	entity.Call(id, method, args)
+	w.Step()
+}
+
+// Request calls the method of the entity and returns the result of the method
+func (w *World) Request(id common.EntityID, method string, args ...interface{}) (interface{}, error) {
+	result, err := entity.CallRequestLocally(id, method, args)
+	w.Step()
+	return result, err
+}
+
+// Connect connects a new client through the fake gate, the boot entity of the type is created for the client
+func (w *World) Connect(bootEntityType string) *Client {
+	client := &Client{
+		ID:       common.GenClientID(),
+		world:    w,
+		Entities: map[common.EntityID]string{},
+	}
+	w.clients[client.ID] = client
+	e := entity.CreateEntityLocally(bootEntityType, nil)
+	e.SetClient(entity.MakeGameClient(client.ID, GateID))
+	w.Step()
+	return client
+}
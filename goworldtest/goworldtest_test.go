@@ -0,0 +1,303 @@
+package goworldtest
+
+import (
+	"testing"
+	"time"
+
+	"github.com/xiaonanln/goworld/engine/common"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/eventbus"
+)
+
+type testSpace struct {
+	entity.Space
+}
+
+// ValidateMove rejects moves faster than testMaxSpeed
+func (space *testSpace) ValidateMove(e *entity.Entity, from, to entity.Vector3, dt time.Duration) bool {
+	return float64(from.DistanceTo(to)) <= testMaxSpeed*dt.Seconds()
+}
+
+const testMaxSpeed = 10
+
+type testAvatar struct {
+	entity.Entity
+	pings    int
+	fired    int
+	rejected int
+	loginErr error
+}
+
+func (a *testAvatar) DescribeEntityType(desc *entity.EntityTypeDesc) {
+	desc.DefineArgs("CastSkill", entity.EnumArg("fireball", "heal"), entity.IntArg(1, 10))
+}
+
+func (a *testAvatar) Ping(from common.EntityID) {
+	a.pings++
+	if !from.IsNil() {
+		a.Call(from, "Ping", common.EntityID(""))
+	}
+}
+
+func (a *testAvatar) GetPings() int {
+	return a.pings
+}
+
+func (a *testAvatar) StartTimer(d time.Duration) {
+	a.AddCallback(d, "Fire")
+}
+
+func (a *testAvatar) Fire() {
+	a.fired++
+}
+
+func (a *testAvatar) OnMoveRejected(from, to entity.Vector3, strikes int) {
+	a.rejected = strikes
+}
+
+func (a *testAvatar) Login(account string) {
+	a.ClaimAccountSession(account, func(err error) {
+		a.loginErr = err
+	})
+}
+
+func (a *testAvatar) Hello_Client(name string) {
+	a.CallClient("OnHello", "hello "+name)
+}
+
+func (a *testAvatar) CastSkill_Client(skill string, level int) {
+	a.CallClient("OnSkillCast", skill, level)
+}
+
+var w *World
+
+func init() {
+	entity.RegisterSpace(&testSpace{})
+	entity.RegisterEntity("testAvatar", &testAvatar{}, false)
+	w = Setup()
+}
+
+func TestCall(t *testing.T) {
+	a := w.CreateEntity("testAvatar")
+	b := w.CreateEntity("testAvatar")
+	w.Call(a.ID, "Ping", b.ID)
+	if pings, err := w.Request(a.ID, "GetPings"); err != nil || pings != 1 {
+		t.Errorf("a should be pinged once, but got %v, %v", pings, err)
+	}
+	if pings, err := w.Request(b.ID, "GetPings"); err != nil || pings != 1 {
+		t.Errorf("b should be pinged once, but got %v, %v", pings, err)
+	}
+}
+
+func TestAdvance(t *testing.T) {
+	e := w.CreateEntity("testAvatar")
+	a := e.I.(*testAvatar)
+	start := w.Now()
+	w.Call(e.ID, "StartTimer", time.Hour)
+	w.Advance(time.Hour - time.Second)
+	if a.fired != 0 {
+		t.Fatalf("timer fired before expired")
+	}
+	w.Advance(time.Second + TickInterval)
+	if a.fired != 1 {
+		t.Errorf("timer should be fired once, but fired %d times", a.fired)
+	}
+	if elapsed := w.Now().Sub(start); elapsed != time.Hour+TickInterval {
+		t.Errorf("virtual time should be advanced by %s, but advanced by %s", time.Hour+TickInterval, elapsed)
+	}
+}
+
+func TestClient(t *testing.T) {
+	c := w.Connect("testAvatar")
+	if c.OwnerID.IsNil() || c.Entities[c.OwnerID] != "testAvatar" {
+		t.Fatalf("player entity should be created on the client: %v", c.Entities)
+	}
+
+	sent := w.ClientBytes()
+	c.CallServer(c.OwnerID, "Hello", "goworld")
+	if w.ClientBytes() <= sent {
+		t.Errorf("bytes sent to clients should be counted")
+	}
+	c.CallServer(c.OwnerID, "Ping", common.EntityID("")) // not callable by clients
+	calls := c.CallsOf("OnHello")
+	if len(calls) != 1 || calls[0].EntityID != c.OwnerID || calls[0].Args[0] != "hello goworld" {
+		t.Errorf("client should be called once, but got %v", calls)
+	}
+	if pings, _ := w.Request(c.OwnerID, "GetPings"); pings != 0 {
+		t.Errorf("methods not callable by clients should not be called")
+	}
+
+	c.Disconnect()
+	if entity.GetEntity(c.OwnerID).GetClient() != nil {
+		t.Errorf("owner should lose the client")
+	}
+}
+
+func TestDefineArgs(t *testing.T) {
+	c := w.Connect("testAvatar")
+	c.CallServer(c.OwnerID, "CastSkill", "heal", 3)
+	c.CallServer(c.OwnerID, "CastSkill", "revive", 3)
+	c.CallServer(c.OwnerID, "CastSkill", "fireball", 11)
+	c.CallServer(c.OwnerID, "CastSkill", "fireball") // missing level is 0
+	calls := c.CallsOf("OnSkillCast")
+	if len(calls) != 1 || calls[0].Args[0] != "heal" {
+		t.Errorf("calls with arguments violating schemas should be rejected, but got %v", calls)
+	}
+
+	// calls from servers are not validated
+	w.Call(c.OwnerID, "CastSkill", "revive", 0)
+	if calls := c.CallsOf("OnSkillCast"); len(calls) != 2 {
+		t.Errorf("calls from servers should not be validated, but got %v", calls)
+	}
+}
+
+func TestValidateMove(t *testing.T) {
+	space := entity.CreateSpaceLocally(1)
+	c := w.Connect("testAvatar")
+	e := entity.GetEntity(c.OwnerID)
+	a := e.I.(*testAvatar)
+	e.SetClientSyncing(true)
+	e.EnterSpace(space.ID, entity.Vector3{})
+	w.Step()
+
+	w.Advance(time.Second)
+	c.SyncPosition(e.ID, entity.Vector3{X: 5}, 0)
+	if e.GetPosition().X != 5 || a.rejected != 0 {
+		t.Fatalf("valid move should be accepted, position=%s, strikes=%d", e.GetPosition(), a.rejected)
+	}
+
+	c.SyncPosition(e.ID, entity.Vector3{X: 100}, 0) // teleport
+	if e.GetPosition().X != 5 || a.rejected != 1 || e.GetMoveStrikes() != 1 {
+		t.Fatalf("teleport should be rejected, position=%s, strikes=%d", e.GetPosition(), a.rejected)
+	}
+
+	// the time of the rejected move is not counted as moving, so the client can move after waiting
+	w.Advance(time.Second)
+	c.SyncPosition(e.ID, entity.Vector3{X: 15}, 0)
+	if e.GetPosition().X != 15 || e.GetMoveStrikes() != 1 {
+		t.Errorf("valid move should be accepted, position=%s, strikes=%d", e.GetPosition(), e.GetMoveStrikes())
+	}
+	e.ResetMoveStrikes()
+	if e.GetMoveStrikes() != 0 {
+		t.Errorf("strikes should be reset")
+	}
+}
+
+func TestAccountSession(t *testing.T) {
+	entity.SetAccountSessionPolicy(1, true)
+	defer entity.SetAccountSessionPolicy(0, true)
+
+	c1 := w.Connect("testAvatar")
+	e1 := entity.GetEntity(c1.OwnerID)
+	w.Call(e1.ID, "Login", "alice")
+	if e1.AccountSession() != "alice" || e1.I.(*testAvatar).loginErr != nil {
+		t.Fatalf("first login should claim the session, but got %#v, %v", e1.AccountSession(), e1.I.(*testAvatar).loginErr)
+	}
+
+	c2 := w.Connect("testAvatar")
+	e2 := entity.GetEntity(c2.OwnerID)
+	w.Call(e2.ID, "Login", "alice")
+	if e2.AccountSession() != "alice" || e1.AccountSession() != "" {
+		t.Fatalf("second login should kick the first session, but got %#v, %#v", e1.AccountSession(), e2.AccountSession())
+	}
+	if !c1.Disconnected() || c1.KickReason != "logged in elsewhere" || e1.GetClient() != nil {
+		t.Fatalf("client of the first session should be kicked, but got %v, %#v", c1.Disconnected(), c1.KickReason)
+	}
+
+	entity.SetAccountSessionPolicy(1, false)
+	c3 := w.Connect("testAvatar")
+	e3 := entity.GetEntity(c3.OwnerID)
+	w.Call(e3.ID, "Login", "alice")
+	if e3.AccountSession() != "" || e3.I.(*testAvatar).loginErr != entity.ErrAccountSessionRejected || c2.Disconnected() {
+		t.Fatalf("third login should be rejected, but got %#v, %v", e3.AccountSession(), e3.I.(*testAvatar).loginErr)
+	}
+
+	// the session is released when the entity is destroyed
+	e2.Destroy()
+	w.Step()
+	w.Call(e3.ID, "Login", "alice")
+	if e3.AccountSession() != "alice" || e3.I.(*testAvatar).loginErr != nil {
+		t.Fatalf("login should succeed after the session is released, but got %#v, %v", e3.AccountSession(), e3.I.(*testAvatar).loginErr)
+	}
+}
+
+func TestOnlinePlayer(t *testing.T) {
+	var logins, logouts []*entity.OnlinePlayer
+	loginSub := eventbus.Subscribe(entity.PlayerLoginEvent, func(player *entity.OnlinePlayer) {
+		logins = append(logins, player)
+	})
+	defer loginSub.Cancel()
+	logoutSub := eventbus.Subscribe(entity.PlayerLogoutEvent, func(player *entity.OnlinePlayer) {
+		logouts = append(logouts, player)
+	})
+	defer logoutSub.Cancel()
+	findPlayer := func(account string) *entity.OnlinePlayer {
+		var found *entity.OnlinePlayer
+		entity.FindOnlinePlayer(account, func(player *entity.OnlinePlayer, err error) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			found = player
+		})
+		w.Step()
+		return found
+	}
+
+	c := w.Connect("testAvatar")
+	e := entity.GetEntity(c.OwnerID)
+	if findPlayer("bob") != nil {
+		t.Fatalf("bob should be offline before login")
+	}
+	w.Call(e.ID, "Login", "bob")
+	w.Step()
+	player := findPlayer("bob")
+	if player == nil || player.Account != "bob" || player.EntityID != e.ID || player.GameID != GameID || player.GateID != GateID || !player.LoginTime.Equal(w.Now()) {
+		t.Fatalf("bob should be online by %s on gate%d, but got %+v", e.ID, GateID, player)
+	}
+	if len(logins) != 1 || logins[0].EntityID != e.ID || len(logouts) != 0 {
+		t.Fatalf("login event of bob should be emitted, but got %v, %v", logins, logouts)
+	}
+
+	// the player is still online without the client
+	c.Disconnect()
+	if player := findPlayer("bob"); player == nil || player.GateID != 0 {
+		t.Fatalf("bob should be online without gate, but got %+v", player)
+	}
+
+	e.Destroy()
+	w.Step()
+	if findPlayer("bob") != nil || len(logouts) != 1 || logouts[0].Account != "bob" || logouts[0].EntityID != e.ID {
+		t.Fatalf("bob should be offline after the entity is destroyed, but got %v", logouts)
+	}
+}
+
+func TestNotifyShutdown(t *testing.T) {
+	c := w.Connect("testAvatar")
+	entity.NotifyShutdownOnClients("maintenance", time.Minute)
+	w.Step()
+	if c.ShutdownReason != "maintenance" || c.ShutdownCountdown != time.Minute {
+		t.Fatalf("client should be notified of the shutdown, but got %#v, %s", c.ShutdownReason, c.ShutdownCountdown)
+	}
+}
+
+type testLevelUp struct {
+	Player common.EntityID
+	Level  int
+}
+
+func TestEmitEvent(t *testing.T) {
+	var received []testLevelUp
+	sub := eventbus.Subscribe("test.levelup", func(ev *testLevelUp) {
+		received = append(received, *ev)
+	})
+	defer sub.Cancel()
+
+	a := w.CreateEntity("testAvatar")
+	if err := eventbus.Emit("test.levelup", &testLevelUp{Player: a.ID, Level: 2}); err != nil {
+		t.Fatal(err)
+	}
+	w.Step()
+	if len(received) != 1 || received[0].Player != a.ID || received[0].Level != 2 {
+		t.Fatalf("event should be delivered to the subscriber, but got %+v", received)
+	}
+}
@@ -0,0 +1,63 @@
+package goworldtest
+
+import (
+	"bytes"
+	"net"
+	"sync"
+	"time"
+)
+
+// memConn is the in-memory connection from the game to the dispatcher, reads never block
+type memConn struct {
+	lock sync.Mutex
+	buf  bytes.Buffer
+}
+
+func (c *memConn) Read(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.buf.Len() == 0 {
+		return 0, nil
+	}
+	return c.buf.Read(p)
+}
+
+func (c *memConn) Write(p []byte) (int, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.buf.Write(p)
+}
+
+func (c *memConn) Close() error {
+	return nil
+}
+
+func (c *memConn) LocalAddr() net.Addr {
+	return memAddr{}
+}
+
+func (c *memConn) RemoteAddr() net.Addr {
+	return memAddr{}
+}
+
+func (c *memConn) SetDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *memConn) SetReadDeadline(t time.Time) error {
+	return nil
+}
+
+func (c *memConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type memAddr struct{}
+
+func (memAddr) Network() string {
+	return "mem"
+}
+
+func (memAddr) String() string {
+	return "goworldtest"
+}
@@ -0,0 +1,93 @@
+package goworld
+
+import (
+	"context"
+
+	"github.com/pkg/errors"
+	"github.com/xiaonanln/goworld/components/game"
+	"github.com/xiaonanln/goworld/engine/entity"
+	"github.com/xiaonanln/goworld/engine/gwlog"
+	"github.com/xiaonanln/goworld/engine/opmon"
+	"github.com/xiaonanln/goworld/engine/post"
+	"github.com/xiaonanln/goworld/engine/service"
+)
+
+// SafeAPI is the goroutine-safe API for calling into the game routine from other goroutines
+//
+// Most goworld APIs (e.g. Call, CreateEntityAnywhere, GetEntity) access states of the game routine, and can not be
+// called in other goroutines like HTTP handlers or callbacks of third-party SDKs (they panic if consts.DEBUG_GAME_ROUTINE
+// is enabled). SafeAPI marshals operations onto
+// the game routine instead, so that they are executed in order with other game logic.
+type SafeAPI struct{}
+
+// Safe returns the goroutine-safe API, e.g.
+//
+//	http.HandleFunc("/reward", func(w http.ResponseWriter, r *http.Request) {
+//		goworld.Safe().CallEntity(goworld.EntityID(r.FormValue("id")), "Reward", r.FormValue("item"))
+//	})
+func Safe() SafeAPI {
+	return SafeAPI{}
+}
+
+// Post posts the callback to be executed in the game routine
+func (SafeAPI) Post(callback func()) {
+	post.Post(callback)
+}
+
+// Do executes f in the game routine, and waits for its result or ctx to be done
+//
+// Do is useful if the result of the operation is needed, e.g. creating entities or reading attributes of entities.
+// Panics of f are returned as errors. Do should not be called in the game routine, since it would wait forever.
+func (SafeAPI) Do(ctx context.Context, f func() error) error {
+	if opmon.IsMainRoutine() {
+		gwlog.Panicf("goworld.Safe().Do is called in the game routine, call the function directly instead")
+	}
+
+	done := make(chan error, 1)
+	post.Post(func() {
+		defer func() {
+			if err := recover(); err != nil {
+				gwlog.TraceError("goworld.Safe().Do: function panicked: %v", err)
+				done <- errors.Errorf("panic: %v", err)
+			}
+		}()
+		done <- f()
+	})
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// CallEntity calls the method of the entity in the game routine
+func (SafeAPI) CallEntity(id EntityID, method string, args ...interface{}) {
+	post.Post(func() {
+		entity.Call(id, method, args)
+	})
+}
+
+// CallService calls the method of the service in the game routine
+//
+// A random shard is called if the service is sharded
+func (SafeAPI) CallService(serviceName string, method string, args ...interface{}) {
+	post.Post(func() {
+		service.CallService(serviceName, method, args)
+	})
+}
+
+// CallServiceShardKey calls the method of the shard of service selected by the shard key in the game routine
+func (SafeAPI) CallServiceShardKey(serviceName string, shardKey string, method string, args ...interface{}) {
+	post.Post(func() {
+		service.CallServiceShardKey(serviceName, shardKey, method, args)
+	})
+}
+
+// CallNilSpaces calls the method of nil spaces on all games in the game routine
+func (SafeAPI) CallNilSpaces(method string, args ...interface{}) {
+	post.Post(func() {
+		entity.CallNilSpaces(method, args, game.GetGameID())
+	})
+}